@@ -4,8 +4,12 @@
 
 package main
 
+import "github.com/silverton-io/buz/pkg/app"
+
+var VERSION string
+
 func main() {
-	app := App{}
-	app.Initialize()
-	app.Run()
+	a := app.New(VERSION)
+	a.Initialize()
+	a.Run()
 }
@@ -0,0 +1,70 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const DLQ_REPLAY_ROUTE = "/c/dlq/replay"
+
+func usage() {
+	fmt.Println(`usage: dlq [flags] replay   replay dead lettered envelopes through a running instance`)
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func replay(endpoint string, token string, sink string) (int, error) {
+	if sink != "" {
+		endpoint += "?sink=" + url.QueryEscape(sink)
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	var r struct {
+		Replayed int `json:"replayed"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return 0, err
+	}
+	return r.Replayed, nil
+}
+
+func main() {
+	host := flag.String("url", "http://localhost:8080", "The url of the buz instance")
+	token := flag.String("token", os.Getenv("BUZ_TOKEN"), "The auth token of the buz instance")
+	sink := flag.String("sink", "", "Only replay envelopes which failed in this sink")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 1 || args[0] != "replay" {
+		usage()
+	}
+	replayed, err := replay(strings.TrimSuffix(*host, "/")+DLQ_REPLAY_ROUTE, *token, *sink)
+	if err != nil {
+		fmt.Println("replay failed: " + err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("replayed %d envelopes\n", replayed)
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/lint"
+)
+
+// Lint every schema beneath the root directory, naming each schema
+// by its path relative to the root.
+func main() {
+	root := flag.String("root", "schemas", "The directory of schemas to lint")
+	flag.Parse()
+	// References to other schemas are resolved beneath the root
+	load := func(name string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(*root, filepath.FromSlash(name)))
+	}
+	failed := false
+	err := filepath.WalkDir(*root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".json") {
+			return err
+		}
+		contents, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(*root, p)
+		for _, e := range lint.Lint(filepath.ToSlash(name), contents, load) {
+			failed = true
+			fmt.Printf("%s: [%s] %s %s\n", p, e.Rule, e.Field, e.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println("could not lint schemas: " + err.Error())
+		os.Exit(1)
+	}
+	if failed {
+		os.Exit(1)
+	}
+	fmt.Println("all schemas passed")
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const REPLAY_ROUTE = "/c/archive/replay"
+
+type request struct {
+	Archive        string     `json:"archive"`
+	Sinks          []string   `json:"sinks"`
+	Prefix         string     `json:"prefix,omitempty"`
+	Schemas        []string   `json:"schemas,omitempty"`
+	ExcludeSchemas []string   `json:"excludeSchemas,omitempty"`
+	Receives       string     `json:"receives,omitempty"`
+	From           *time.Time `json:"from,omitempty"`
+	To             *time.Time `json:"to,omitempty"`
+	Filter         string     `json:"filter,omitempty"`
+	DryRun         bool       `json:"dryRun,omitempty"`
+}
+
+type result struct {
+	Objects  int  `json:"objects"`
+	Read     int  `json:"read"`
+	Matched  int  `json:"matched"`
+	Replayed int  `json:"replayed"`
+	DryRun   bool `json:"dryRun"`
+}
+
+func usage() {
+	fmt.Println(`usage: replay -archive <name> -sinks <names> [flags]   replay archived envelopes to sinks of a running instance`)
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func list(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func timestamp(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func replay(endpoint string, token string, r request) (result, error) {
+	var res result
+	b, err := json.Marshal(r)
+	if err != nil {
+		return res, err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(b))
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return res, fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	err = json.Unmarshal(body, &res)
+	return res, err
+}
+
+func main() {
+	host := flag.String("url", "http://localhost:8080", "The url of the buz instance")
+	token := flag.String("token", os.Getenv("BUZ_TOKEN"), "The auth token of the buz instance")
+	archive := flag.String("archive", "", "The name of the configured archive")
+	sinks := flag.String("sinks", "", "Comma-separated names of the sinks to replay to")
+	prefix := flag.String("prefix", "", "Only read objects under this prefix of the archive")
+	schemas := flag.String("schemas", "", "Comma-separated schema prefixes to replay")
+	exclude := flag.String("exclude-schemas", "", "Comma-separated schema prefixes to skip")
+	receives := flag.String("receives", "", "Replay all, valid, or invalid envelopes")
+	from := flag.String("from", "", "Replay envelopes collected at or after this RFC3339 time")
+	to := flag.String("to", "", "Replay envelopes collected before this RFC3339 time")
+	filter := flag.String("filter", "", "A CEL expression envelopes must match")
+	dryRun := flag.Bool("dry-run", false, "Count the matching envelopes without replaying them")
+	flag.Parse()
+	if *archive == "" || *sinks == "" || len(flag.Args()) != 0 {
+		usage()
+	}
+	r := request{
+		Archive:        *archive,
+		Sinks:          list(*sinks),
+		Prefix:         *prefix,
+		Schemas:        list(*schemas),
+		ExcludeSchemas: list(*exclude),
+		Receives:       *receives,
+		Filter:         *filter,
+		DryRun:         *dryRun,
+	}
+	var err error
+	if r.From, err = timestamp(*from); err != nil {
+		fmt.Println("invalid -from: " + err.Error())
+		os.Exit(1)
+	}
+	if r.To, err = timestamp(*to); err != nil {
+		fmt.Println("invalid -to: " + err.Error())
+		os.Exit(1)
+	}
+	res, err := replay(strings.TrimSuffix(*host, "/")+REPLAY_ROUTE, *token, r)
+	if err != nil {
+		fmt.Println("replay failed: " + err.Error())
+		os.Exit(1)
+	}
+	if res.DryRun {
+		fmt.Printf("dry run: %d of %d envelopes in %d objects would be replayed\n", res.Matched, res.Read, res.Objects)
+		return
+	}
+	fmt.Printf("replayed %d of %d envelopes in %d objects\n", res.Replayed, res.Read, res.Objects)
+}
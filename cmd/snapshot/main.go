@@ -0,0 +1,128 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/snapshot"
+)
+
+const SNAPSHOT_ROUTE = "/c/snapshot"
+
+func usage() {
+	fmt.Println(`usage: snapshot [flags] export <file>   export the schemas of a running instance
+       snapshot [flags] import <file>   import schemas into a running instance
+       snapshot pack <dir> <file>       pack a directory of schemas, without a running instance`)
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func request(method string, url string, token string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return resp, nil
+}
+
+func export(url string, token string, file string) error {
+	resp, err := request(http.MethodGet, url, token, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, resp.Body)
+	return err
+}
+
+func importSnapshot(url string, token string, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	resp, err := request(http.MethodPost, url, token, f)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func pack(dir string, file string) error {
+	schemas := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".json") {
+			return err
+		}
+		contents, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(dir, p)
+		schemas[filepath.ToSlash(name)] = contents
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return snapshot.Write(f, schemas)
+}
+
+func main() {
+	host := flag.String("url", "http://localhost:8080", "The url of the buz instance")
+	token := flag.String("token", os.Getenv("BUZ_TOKEN"), "The auth token of the buz instance")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+	}
+	url := strings.TrimSuffix(*host, "/") + SNAPSHOT_ROUTE
+	var err error
+	switch {
+	case args[0] == "export":
+		err = export(url, *token, args[1])
+	case args[0] == "import":
+		err = importSnapshot(url, *token, args[1])
+	case args[0] == "pack" && len(args) == 3:
+		err = pack(args[1], args[2])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Println(args[0] + " failed: " + err.Error())
+		os.Exit(1)
+	}
+	fmt.Println(args[0] + " succeeded")
+}
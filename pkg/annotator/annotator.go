@@ -5,9 +5,14 @@
 package annotator
 
 import (
+	"strings"
+
 	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
 	"github.com/silverton-io/buz/pkg/envelope"
 	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/silverton-io/buz/pkg/stats"
 	"github.com/silverton-io/buz/pkg/validator"
 	"github.com/tidwall/gjson"
 )
@@ -33,30 +38,139 @@ func getSchemaMetadata(schema []byte) schemaMetadata {
 	}
 }
 
-func Annotate(envelopes []envelope.Envelope, registry *registry.Registry) []envelope.Envelope {
+// Validation modes
+const (
+	ENFORCE string = "enforce" // Invalid envelopes are routed to invalid sinks
+	WARN    string = "warn"    // Invalid envelopes are annotated with errors but delivered as valid
+	SKIP    string = "skip"    // Envelopes are not validated
+)
+
+// validationMode returns the mode of the longest rule prefix the schema
+// begins with, so specific schemas can override their vendor.
+func validationMode(schema string, conf config.Validation) string {
+	// Erasure requests are built by buz itself
+	if schema == envelope.ERASURE_REQUEST_SCHEMA {
+		return SKIP
+	}
+	mode, matched := conf.Mode, -1
+	for _, rule := range conf.Rules {
+		if strings.HasPrefix(schema, rule.Prefix) && len(rule.Prefix) > matched {
+			mode, matched = rule.Mode, len(rule.Prefix)
+		}
+	}
+	if mode == "" {
+		return ENFORCE
+	}
+	return mode
+}
+
+// Validation outcomes
+const (
+	VALID   string = "valid"
+	INVALID string = "invalid"
+	WARNED  string = "warned" // Invalid, but delivered as valid
+	SKIPPED string = "skipped"
+)
+
+var (
+	validations        = stats.NewSchemaStats()
+	validationFailures = stats.NewSchemaStats()
+)
+
+// Validations returns the number of envelopes by schema and outcome
+func Validations() map[string]map[string]int64 {
+	return validations.Snapshot()
+}
+
+// ValidationFailures returns the number of invalid envelopes, including
+// warned ones, by schema and error type
+func ValidationFailures() map[string]map[string]int64 {
+	return validationFailures.Snapshot()
+}
+
+func countFailure(schema string, validationError envelope.ValidationError) {
+	reason := constants.UNKNOWN
+	if validationError.ErrorType != nil {
+		reason = *validationError.ErrorType
+	}
+	validationFailures.Increment(schema, reason, 1)
+}
+
+func validate(e envelope.Envelope, registry *registry.Registry, mode string) (isValid bool, validationError envelope.ValidationError, schema []byte) {
+	if mode != SKIP {
+		return validator.Validate(e, registry)
+	}
+	// The schema is still used for metadata
+	if e.Schema != constants.UNKNOWN {
+		_, schema = registry.Get(e.Schema)
+	}
+	return true, envelope.ValidationError{}, schema
+}
+
+func Annotate(envelopes []envelope.Envelope, registry *registry.Registry, conf config.Validation) []envelope.Envelope {
 	var e []envelope.Envelope
 	for _, envelope := range envelopes {
 		log.Debug().Msg("🟡 annotating event")
-		isValid, validationError, schemaContents := validator.Validate(envelope, registry)
+		mode := validationMode(envelope.Schema, conf)
+		isValid, validationError, schemaContents := validate(envelope, registry, mode)
 		m := getSchemaMetadata(schemaContents)
 		if m.Namespace != "" {
 			envelope.Vendor = m.Vendor
 			envelope.Namespace = m.Namespace
 			envelope.Version = m.Version
 		}
-		if m.DisableValidation {
+		switch {
+		case m.DisableValidation || mode == SKIP:
 			// If schema-level validation is disabled
 			// consider the payload valid.
-			valid := true
-			envelope.IsValid = valid
-		} else {
+			envelope.IsValid = true
+			validations.Increment(envelope.Schema, SKIPPED, 1)
+		case mode == WARN:
+			envelope.IsValid = true
+			if !isValid {
+				// Mark the envelope without routing it to invalid sinks
+				log.Debug().Msg("🟡 delivering invalid " + envelope.Schema + " envelope as valid")
+				envelope.ValidationError = &validationError
+				validations.Increment(envelope.Schema, WARNED, 1)
+				countFailure(envelope.Schema, validationError)
+			} else {
+				validations.Increment(envelope.Schema, VALID, 1)
+			}
+		default:
 			envelope.IsValid = isValid
 			if !isValid {
 				// Annotate the envelope with associated validation errors
 				envelope.ValidationError = &validationError
+				validations.Increment(envelope.Schema, INVALID, 1)
+				countFailure(envelope.Schema, validationError)
+			} else {
+				validations.Increment(envelope.Schema, VALID, 1)
 			}
 		}
 		e = append(e, envelope)
 	}
 	return e
 }
+
+// retentionClass returns the class of the longest rule prefix the schema
+// begins with, or the default class
+func retentionClass(schema string, conf config.Retention) string {
+	class, matched := conf.Default, -1
+	for _, rule := range conf.Rules {
+		if strings.HasPrefix(schema, rule.Prefix) && len(rule.Prefix) > matched {
+			class, matched = rule.Class, len(rule.Prefix)
+		}
+	}
+	return class
+}
+
+// Retain stamps envelopes with the retention class of their schema
+func Retain(envelopes []envelope.Envelope, conf config.Retention) []envelope.Envelope {
+	if conf.Default == "" && len(conf.Rules) == 0 {
+		return envelopes
+	}
+	for i := range envelopes {
+		envelope.StampRetention(&envelopes[i], retentionClass(envelopes[i].Schema, conf))
+	}
+	return envelopes
+}
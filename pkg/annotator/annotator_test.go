@@ -0,0 +1,92 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package annotator
+
+import (
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/backend/embedded"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidationMode(t *testing.T) {
+	conf := config.Validation{
+		Mode: WARN,
+		Rules: []config.ValidationRule{
+			{Prefix: "com.acme/", Mode: SKIP},
+			{Prefix: "com.acme/checkout/", Mode: ENFORCE},
+		},
+	}
+	assert.Equal(t, WARN, validationMode("io.silverton/event/v1.0.json", conf))
+	assert.Equal(t, SKIP, validationMode("com.acme/page/v1.0.json", conf))
+	assert.Equal(t, ENFORCE, validationMode("com.acme/checkout/v1.0.json", conf))
+	assert.Equal(t, ENFORCE, validationMode("com.acme/page/v1.0.json", config.Validation{}))
+}
+
+func TestAnnotateValidationModes(t *testing.T) {
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	invalid := envelope.Envelope{
+		Schema:  "io.silverton/buz/example/productView/v1.0.json",
+		Payload: envelope.Payload{"productId": 10},
+	}
+	var testCases = []struct {
+		mode             string
+		wantValid        bool
+		wantErrorPresent bool
+	}{
+		{ENFORCE, false, true},
+		{WARN, true, true},
+		{SKIP, true, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.mode, func(t *testing.T) {
+			annotated := Annotate([]envelope.Envelope{invalid}, r, config.Validation{Mode: tc.mode})
+			assert.Equal(t, tc.wantValid, annotated[0].IsValid)
+			assert.Equal(t, tc.wantErrorPresent, annotated[0].ValidationError != nil)
+			// Schema metadata is annotated regardless of mode
+			assert.Equal(t, "buz.example.productView", annotated[0].Namespace)
+		})
+	}
+}
+
+func TestAnnotateCountsValidations(t *testing.T) {
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	schema := "io.silverton/buz/example/productView/v1.0.json"
+	before, beforeFailures := Validations()[schema], ValidationFailures()[schema]
+	invalid := envelope.Envelope{Schema: schema, Payload: envelope.Payload{"productId": 10}}
+	Annotate([]envelope.Envelope{invalid, invalid}, r, config.Validation{})
+	Annotate([]envelope.Envelope{invalid}, r, config.Validation{Mode: WARN})
+	Annotate([]envelope.Envelope{invalid}, r, config.Validation{Mode: SKIP})
+
+	after, afterFailures := Validations()[schema], ValidationFailures()[schema]
+	assert.Equal(t, int64(2), after[INVALID]-before[INVALID])
+	assert.Equal(t, int64(1), after[WARNED]-before[WARNED])
+	assert.Equal(t, int64(1), after[SKIPPED]-before[SKIPPED])
+	assert.Equal(t, int64(3), afterFailures["invalid payload"]-beforeFailures["invalid payload"])
+}
+
+func TestRetain(t *testing.T) {
+	conf := config.Retention{
+		Default: "13mo",
+		Rules: []config.RetentionRule{
+			{Prefix: "com.acme/", Class: "30d"},
+			{Prefix: "com.acme/billing/", Class: "forever"},
+		},
+	}
+	envelopes := Retain([]envelope.Envelope{
+		{Schema: "com.acme/web/page/v1.0.json"},
+		{Schema: "com.acme/billing/invoice/v1.0.json"},
+		{Schema: "io.silverton/buz/pixel/arbitrary/v1.0.json"},
+	}, conf)
+	assert.Equal(t, "30d", envelopes[0].Retention())
+	assert.Equal(t, "forever", envelopes[1].Retention())
+	assert.Equal(t, "13mo", envelopes[2].Retention())
+
+	unstamped := Retain([]envelope.Envelope{{Schema: "com.acme/web/page/v1.0.json"}}, config.Retention{})
+	assert.Nil(t, unstamped[0].Contexts)
+}
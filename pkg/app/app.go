@@ -0,0 +1,786 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/apex/gateway/v2"
+	"github.com/gin-contrib/pprof"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/annotator"
+	"github.com/silverton-io/buz/pkg/audit"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/dlq"
+	"github.com/silverton-io/buz/pkg/env"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/erasure"
+	"github.com/silverton-io/buz/pkg/handler"
+	"github.com/silverton-io/buz/pkg/health"
+	"github.com/silverton-io/buz/pkg/heartbeat"
+	"github.com/silverton-io/buz/pkg/input"
+	"github.com/silverton-io/buz/pkg/loglevel"
+	"github.com/silverton-io/buz/pkg/manifold"
+	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/middleware"
+	cloudevents "github.com/silverton-io/buz/pkg/protocol/cloudevents"
+	pixel "github.com/silverton-io/buz/pkg/protocol/pixel"
+	selfdescribing "github.com/silverton-io/buz/pkg/protocol/selfdescribing"
+	snowplow "github.com/silverton-io/buz/pkg/protocol/snowplow"
+	webhook "github.com/silverton-io/buz/pkg/protocol/webhook"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/silverton-io/buz/pkg/replay"
+	"github.com/silverton-io/buz/pkg/selftracking"
+	"github.com/silverton-io/buz/pkg/sentry"
+	"github.com/silverton-io/buz/pkg/sink"
+	"github.com/silverton-io/buz/pkg/statsd"
+	"github.com/silverton-io/buz/pkg/tap"
+	"github.com/silverton-io/buz/pkg/tele"
+	"github.com/silverton-io/buz/pkg/transform"
+	"github.com/silverton-io/buz/pkg/tuning"
+	"github.com/spf13/viper"
+)
+
+// App is a buz instance. Buz can be extended without patching the app by
+// building a binary which registers its own transforms (transform.Register),
+// enrichments (transform.RegisterEnrichment), sinks (sink.Register), and
+// inputs (input.Register) before running the app:
+//
+//	func main() {
+//		sink.Register("custom", func() backendutils.Sink { return &custom.Sink{} })
+//		a := app.New(VERSION)
+//		a.Initialize()
+//		a.Run()
+//	}
+type App struct {
+	version               string
+	config                *config.Config
+	engine                *gin.Engine
+	manifold              manifold.Manifold
+	sinks                 []backendutils.Sink
+	collectorMeta         *meta.CollectorMeta
+	debug                 bool
+	publicRouterGroup     *gin.RouterGroup
+	switchableRouterGroup *gin.RouterGroup
+	oidcRouterGroup       *gin.RouterGroup
+	opsAuthRouterGroup    *gin.RouterGroup
+	auth                  gin.HandlerFunc
+	operatorAuth          gin.HandlerFunc // The auth of authenticatedRouterGroup, for the admin listener
+	rateLimiters          *middleware.RateLimiters
+	inputRateLimiter      gin.HandlerFunc
+	abuseTracker          *middleware.AbuseTracker
+	deadLetterQueue       dlq.Queue
+	replayer              *replay.Replayer
+	statsd                *statsd.Exporter
+	tracker               *selftracking.Tracker
+	heartbeat             *heartbeat.Heartbeat
+	sentry                *sentry.Reporter
+	audit                 *audit.Log
+	adminServer           *http.Server
+}
+
+func New(version string) *App {
+	return &App{version: version}
+}
+
+func (a *App) configure() {
+	// Set up app logger
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	gin.SetMode("release")
+
+	// Load app config from file
+	conf := os.Getenv(env.BUZ_CONFIG_PATH)
+	debug := os.Getenv(env.DEBUG)
+	if conf == "" {
+		conf = "config.yml"
+	}
+	log.Info().Msg("🟢 loading config from " + conf)
+	viper.SetConfigFile(conf)
+	viper.SetConfigType("yaml")
+	err := viper.ReadInConfig()
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("could not read config")
+	}
+	a.config = &config.Config{}
+	if err := viper.Unmarshal(a.config); err != nil {
+		log.Fatal().Stack().Err(err).Msg("could not unmarshal config")
+	}
+	if debug != "" && (debug == "true" || debug == "1" || debug == "True") {
+		// Put gin, logging, and request logging into debug mode
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		log.Warn().Msg("🟡 DEBUG flag set - setting gin mode to debug")
+		gin.SetMode("debug")
+		log.Warn().Msg("🟡 DEBUG flag set - activating request logger")
+		a.config.Middleware.RequestLogger.Enabled = true
+		a.debug = true
+	}
+	if _, err := config.Redact(*a.config, a.config.App.RedactPatterns); err != nil {
+		log.Fatal().Err(err).Msg("could not redact config")
+	}
+	a.config.App.Version = a.version
+	tuning.Tune(a.config.App.Runtime)
+	meta := meta.BuildCollectorMeta(a.version, a.config)
+	a.collectorMeta = meta
+}
+
+// initializeSentry starts reporting errors to sentry. It must run before
+// the registry and sinks are initialized, so their errors are reported.
+func (a *App) initializeSentry() {
+	if !a.config.Sentry.Enabled {
+		return
+	}
+	log.Info().Msg("🟢 initializing sentry")
+	r, err := sentry.NewReporter(a.config.Sentry, a.config.App, a.collectorMeta)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not initialize sentry")
+	}
+	a.sentry = r
+	backendutils.AddFailureListener(r.SinkFailed)
+	registry.SetErrorListener(r.RegistryFailed)
+}
+
+func (a *App) initializeManifold() {
+	log.Info().Msg("🟢 initializing manifold")
+	m, err := manifold.BuildManifold(a.config.Manifold)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not build manifold")
+	}
+	log.Info().Msg("🟢 initializing registry")
+	registry := registry.Registry{}
+	if err := registry.Initialize(a.config.Registry); err != nil {
+		log.Fatal().Err(err).Msg("could not initialize registry")
+	}
+	if a.config.DeadLetter.Enabled {
+		log.Info().Msg("🟢 initializing dead letter queue")
+		q, err := dlq.BuildQueue(a.config.DeadLetter)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not initialize dead letter queue")
+		}
+		backendutils.SetDeadLetterWriter(&dlq.Writer{Queue: q})
+		a.deadLetterQueue = q
+	}
+	if a.config.Replay.Enabled {
+		log.Info().Msg("🟢 initializing archive replay")
+		r, err := replay.NewReplayer(a.config.Replay)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not initialize archive replay")
+		}
+		a.replayer = r
+	}
+	if a.config.SelfTracking.Enabled {
+		// Sink failures are only reported if the tracker listens before sinks start
+		a.tracker = selftracking.NewTracker(a.config.SelfTracking, a.config.App, a.collectorMeta)
+		backendutils.AddFailureListener(a.tracker.SinkFailed)
+	}
+	log.Info().Msg("🟢 initializing sinks")
+	sinks, err := sink.BuildAndInitializeSinks(a.config.Sinks)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not build and initialize sinks")
+	}
+	err = m.Initialize(&registry, &sinks, a.config, a.collectorMeta)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("could not build manifold")
+	}
+	a.manifold = m
+	a.sinks = sinks
+	if a.tracker != nil {
+		log.Info().Msg("🟢 initializing self tracking")
+		if err := a.tracker.Start(sinks, m.Stats); err != nil {
+			log.Fatal().Err(err).Msg("could not initialize self tracking")
+		}
+	}
+	if a.config.Heartbeat.Enabled {
+		log.Info().Msg("🟢 initializing heartbeat")
+		a.heartbeat = heartbeat.NewHeartbeat(a.config.Heartbeat, a.config.App, a.collectorMeta, m)
+		a.heartbeat.Start()
+	}
+}
+
+func (a *App) initializeAudit() {
+	if !a.config.Audit.Enabled {
+		return
+	}
+	log.Info().Msg("🟢 initializing audit log")
+	l, err := audit.NewLog(a.config.Audit, a.config.App, a.collectorMeta, a.sinks)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not initialize audit log")
+	}
+	a.audit = l
+}
+
+// audited records requests to the route as the action, if the audit log
+// is enabled. The target of the action is read from the route param.
+func (a *App) audited(action string, param string, handler gin.HandlerFunc) []gin.HandlerFunc {
+	if a.audit == nil {
+		return []gin.HandlerFunc{handler}
+	}
+	return []gin.HandlerFunc{a.audit.Middleware(action, param), handler}
+}
+
+func (a *App) initializeStatsd() {
+	if !a.config.Statsd.Enabled {
+		return
+	}
+	log.Info().Msg("🟢 initializing statsd exporter")
+	e, err := statsd.NewExporter(a.config.Statsd)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not initialize statsd exporter")
+	}
+	e.CollectSinks(backendutils.Stats().Snapshot)
+	e.Collect(func(e *statsd.Exporter) {
+		s := a.manifold.Stats()
+		e.Total("manifold.received", s.Received)
+		e.Total("manifold.valid", s.Valid)
+		e.Total("manifold.invalid", s.Invalid)
+		e.Total("manifold.rejected", s.Rejected)
+	})
+	for name, snapshot := range map[string]func() map[string]map[string]int64{
+		"pii.detections":            transform.Detections,
+		"sampled_out":               transform.SampledOut,
+		"consent.actions":           transform.ConsentActions,
+		"privacy.actions":           envelope.PrivacyActions,
+		"size_violations":           manifold.SizeViolations,
+		"api_keys.requests":         middleware.ApiKeyUsage,
+		"bots.rejected":             middleware.BotRejections,
+		"circuit_breaker.rejected":  middleware.CircuitBreakerRejections,
+		"abuse.events":              middleware.AbuseEvents,
+		"required_headers.rejected": middleware.RequiredHeaderRejections,
+		"validation.envelopes":      annotator.Validations,
+		"validation.failures":       annotator.ValidationFailures,
+	} {
+		e.CollectCounts(name, snapshot)
+	}
+	e.Start()
+	a.statsd = e
+}
+
+func (a *App) initializeRouter() {
+	log.Info().Msg("🟢 initializing router")
+	a.engine = gin.New()
+	// Gin never trusts forwarding headers - clients behind proxies are
+	// resolved by the client ip middleware instead
+	if err := a.engine.SetTrustedProxies(nil); err != nil {
+		panic(err)
+	}
+	// Configured profiles are served behind auth instead
+	if a.debug && !a.config.App.Pprof.Enabled {
+		log.Info().Msg("setting up pprof at /debug/pprof")
+		pprof.Register(a.engine)
+	}
+	a.engine.RedirectTrailingSlash = false
+}
+
+func (a *App) initializeMiddleware() {
+	log.Info().Msg("🟢 initializing middleware")
+	a.engine.Use(gin.Recovery())
+	if a.sentry != nil {
+		// Panics are reported before gin recovers from them
+		a.engine.Use(a.sentry.Middleware())
+	}
+	if a.statsd != nil {
+		a.engine.Use(a.statsd.Middleware())
+	}
+	// Client ips are resolved before anything else uses them
+	if a.config.Middleware.ClientIp.Enabled {
+		log.Info().Msg("🟢 initializing client ip middleware")
+		clientIp, err := middleware.ClientIp(a.config.Middleware.ClientIp)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not initialize client ip middleware")
+		}
+		a.engine.Use(clientIp)
+	}
+	// Requests are logged before anything can reject them. The request
+	// logger can be turned on at runtime if the log level route is enabled.
+	middleware.SetRequestLogging(a.config.Middleware.RequestLogger.Enabled)
+	if a.config.Middleware.RequestLogger.Enabled || a.config.App.EnableLogLevelRoute {
+		log.Info().Msg("🟢 initializing request logger middleware")
+		requestLogger, err := middleware.RequestLogger(a.config.Middleware.RequestLogger, a.config.App, a.sinks)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not initialize request logger")
+		}
+		a.engine.Use(requestLogger)
+	}
+	if a.config.Middleware.SecurityHeaders.Enabled {
+		log.Info().Msg("🟢 initializing security headers middleware")
+		a.engine.Use(middleware.SecurityHeaders(a.config.Middleware.SecurityHeaders))
+	}
+	if a.config.Middleware.Timeout.Enabled {
+		log.Info().Msg("🟢 initializing request timeout middleware")
+		a.engine.Use(middleware.Timeout(a.config.Middleware.Timeout))
+	}
+	if a.config.Middleware.RateLimiter.Enabled {
+		log.Info().Msg("🟢 initializing rate limiter middleware")
+		limiters, err := middleware.NewRateLimiters(a.config.Middleware.RateLimiter)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not initialize rate limiter")
+		}
+		a.rateLimiters = limiters
+		switch a.config.Middleware.RateLimiter.Key {
+		case middleware.API_KEY, middleware.TENANT:
+			// Api keys and tenants are only known once ingestion routes have resolved them
+			a.inputRateLimiter = limiters.Middleware()
+		default:
+			a.engine.Use(limiters.Middleware())
+		}
+	}
+	if a.config.Middleware.Cors.Enabled {
+		log.Info().Msg("🟢 initializing cors middleware")
+		a.engine.Use(middleware.CORS(a.config.Middleware.Cors))
+	}
+	if a.config.Middleware.AbuseDetection.Enabled {
+		a.abuseTracker = middleware.NewAbuseTracker(a.config.Middleware.AbuseDetection)
+	}
+	// Groups copy the engine's middleware when they are created
+	a.publicRouterGroup = a.engine.Group("")
+	a.switchableRouterGroup = a.engine.Group("")
+	// Auth is built even if it's disabled, since some routes always require it
+	auth, err := middleware.Auth(a.config.Middleware.Auth)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not initialize auth middleware")
+	}
+	a.auth = auth
+	if a.config.Middleware.Auth.Enabled {
+		log.Info().Msg("🟢 initializing auth middleware")
+		a.switchableRouterGroup.Use(a.auth)
+	}
+	// Ops routes can require their own auth, such as only operators' keys
+	if a.config.Middleware.OpsAuth.Enabled {
+		log.Info().Msg("🟢 initializing ops auth middleware")
+		opsAuth, err := middleware.Auth(a.config.Middleware.OpsAuth)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not initialize ops auth middleware")
+		}
+		a.opsAuthRouterGroup = a.engine.Group("")
+		a.opsAuthRouterGroup.Use(opsAuth)
+		a.operatorAuth = opsAuth
+	}
+	if a.config.Middleware.Auth.Oidc.Enabled {
+		log.Info().Msg("🟢 initializing oidc operator auth")
+		o, err := middleware.NewOidc(a.config.Middleware.Auth.Oidc)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not initialize oidc")
+		}
+		a.publicRouterGroup.GET(middleware.OIDC_LOGIN_ROUTE, o.LoginHandler())
+		a.publicRouterGroup.GET(middleware.OIDC_CALLBACK_ROUTE, o.CallbackHandler())
+		a.publicRouterGroup.POST(middleware.OIDC_LOGOUT_ROUTE, o.LogoutHandler())
+		a.operatorAuth = o.Middleware()
+		a.oidcRouterGroup = a.engine.Group("")
+		a.oidcRouterGroup.Use(a.operatorAuth)
+	}
+	if a.operatorAuth == nil {
+		a.operatorAuth = a.auth
+	}
+}
+
+// 🐝 and healthcheck route are always public
+func (a *App) initializePublicRoutes() {
+	log.Info().Msg("🟢 initializing buz route")
+	a.publicRouterGroup.GET("/", handler.BuzHandler())
+	log.Info().Msg("🟢 initializing health check route")
+	if a.config.App.Health.Deep {
+		checker := health.NewChecker(a.config.App.Health, a.manifold.GetRegistry(), a.sinks)
+		a.publicRouterGroup.GET(constants.HEALTH_PATH, handler.DeepHealthcheckHandler(checker))
+	} else {
+		a.publicRouterGroup.GET(constants.HEALTH_PATH, handler.HealthcheckHandler)
+	}
+}
+
+func (a *App) initializeOpsRoutes() {
+	ops := a.opsRouterGroup()
+	log.Info().Msg("🟢 initializing stats route")
+	ops.GET(constants.STATS_PATH, handler.StatsHandler(a.collectorMeta, a.manifold))
+	ops.GET(constants.INPUT_STATS_PATH, handler.InputStatsHandler)
+	log.Info().Msg("🟢 initializing overview routes")
+	ops.GET(constants.ROUTE_OVERVIEW_PATH, handler.RouteOverviewHandler(*a.config))
+	if a.config.App.EnableConfigRoute {
+		log.Info().Msg("🟢 initializing config overview")
+		ops.GET(constants.CONFIG_OVERVIEW_PATH, handler.ConfigOverviewHandler(*a.config))
+	}
+	if a.config.App.EnableLogLevelRoute {
+		log.Info().Msg("🟢 initializing log level route")
+		ctl := loglevel.NewController()
+		g := a.authenticatedRouterGroup()
+		g.GET(loglevel.LOG_LEVEL_ROUTE, loglevel.Handler(ctl))
+		g.PUT(loglevel.LOG_LEVEL_ROUTE, a.audited(audit.LOG_LEVEL_CHANGE, "", loglevel.Handler(ctl))...)
+	}
+	if a.abuseTracker != nil {
+		// Talkers include client ips, so the routes always require auth
+		log.Info().Msg("🟢 initializing abuse routes")
+		g := a.authenticatedRouterGroup()
+		g.GET(middleware.ABUSE_TALKERS_ROUTE, a.abuseTracker.TalkersHandler())
+		g.DELETE(middleware.ABUSE_BLOCKS_ROUTE+":"+middleware.FINGERPRINT_PARAM, a.audited(audit.ABUSE_UNBLOCK, middleware.FINGERPRINT_PARAM, a.abuseTracker.UnblockHandler())...)
+	}
+}
+
+// opsRouterGroup returns the router group of operator routes. With oidc,
+// operators log in with the provider instead of using auth tokens. With
+// ops auth, operator routes require it instead of the ingestion routes' auth.
+func (a *App) opsRouterGroup() *gin.RouterGroup {
+	if a.oidcRouterGroup != nil {
+		return a.oidcRouterGroup
+	}
+	if a.opsAuthRouterGroup != nil {
+		return a.opsAuthRouterGroup
+	}
+	return a.switchableRouterGroup
+}
+
+// authenticatedRouterGroup returns a router group which always requires
+// auth, even if the rest of the switchable routes are public.
+func (a *App) authenticatedRouterGroup() *gin.RouterGroup {
+	if a.oidcRouterGroup != nil {
+		return a.oidcRouterGroup
+	}
+	if a.opsAuthRouterGroup != nil {
+		return a.opsAuthRouterGroup
+	}
+	g := a.switchableRouterGroup.Group("")
+	if !a.config.Middleware.Auth.Enabled {
+		g.Use(a.auth)
+	}
+	return g
+}
+
+// initializePprofRoutes serves profiles behind the same auth as the other
+// operator routes, on the admin listener if there is one
+func (a *App) initializePprofRoutes() {
+	conf := a.config.App.Pprof
+	if !conf.Enabled {
+		return
+	}
+	if conf.Addr == "" {
+		log.Info().Msg("🟢 initializing pprof routes")
+		pprof.RouteRegister(a.authenticatedRouterGroup())
+		return
+	}
+	log.Info().Msg("🟢 initializing pprof on admin listener " + conf.Addr)
+	admin := gin.New()
+	admin.Use(gin.Recovery(), a.operatorAuth)
+	pprof.Register(admin)
+	a.adminServer = &http.Server{Addr: conf.Addr, Handler: admin}
+	go func() {
+		if err := a.adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("🔴 admin listener failed")
+		}
+	}()
+}
+
+func (a *App) initializeSchemaCacheRoutes() {
+	r := a.manifold.GetRegistry()
+	if a.config.Registry.Purge.Enabled {
+		log.Info().Msg("🟢 initializing schema registry cache purge route")
+		a.opsRouterGroup().GET(registry.CACHE_PURGE_ROUTE, a.audited(audit.CACHE_PURGE, "", registry.PurgeCacheHandler(r))...)
+		a.opsRouterGroup().POST(registry.CACHE_PURGE_ROUTE, a.audited(audit.CACHE_PURGE, "", registry.PurgeCacheHandler(r))...)
+	}
+	if a.config.Registry.Http.Enabled {
+		log.Info().Msg("🟢 initializing schema registry routes")
+		a.switchableRouterGroup.GET(registry.SCHEMAS_ROUTE+"*"+registry.SCHEMA_PARAM, registry.GetSchemaHandler(r))
+		a.opsRouterGroup().GET(registry.BACKEND_STATS_ROUTE, registry.BackendStatsHandler(r))
+		a.switchableRouterGroup.POST(registry.LINT_ROUTE+"*"+registry.SCHEMA_PARAM, registry.LintSchemaHandler(r))
+		a.switchableRouterGroup.POST(registry.COMPAT_ROUTE+"*"+registry.SCHEMA_PARAM, registry.CompatibilityHandler(r))
+	}
+	if a.config.Registry.Introspection.Enabled {
+		log.Info().Msg("🟢 initializing schema registry cache introspection route")
+		a.authenticatedRouterGroup().GET(registry.CACHE_OVERVIEW_ROUTE, registry.CacheOverviewHandler(r))
+	}
+	if a.config.Registry.Docs.Enabled {
+		log.Info().Msg("🟢 initializing schema documentation routes")
+		a.authenticatedRouterGroup().GET(registry.DOCS_ROUTE+"*"+registry.SCHEMA_PARAM, registry.DocsHandler(r))
+	}
+	if a.config.Registry.Snapshot.Enabled {
+		log.Info().Msg("🟢 initializing schema registry snapshot routes")
+		snapshotGroup := a.authenticatedRouterGroup()
+		snapshotGroup.GET(registry.SNAPSHOT_ROUTE, registry.ExportSnapshotHandler(r))
+		snapshotGroup.POST(registry.SNAPSHOT_ROUTE, a.audited(audit.SNAPSHOT_IMPORT, "", registry.ImportSnapshotHandler(r))...)
+	}
+	if a.config.Registry.Publish.Enabled {
+		log.Info().Msg("🟢 initializing schema registry publish routes")
+		publishGroup := a.authenticatedRouterGroup()
+		publishGroup.POST(registry.SCHEMAS_ROUTE+"*"+registry.SCHEMA_PARAM, a.audited(audit.SCHEMA_PUBLISH, registry.SCHEMA_PARAM, registry.CreateSchemaHandler(r))...)
+		publishGroup.PUT(registry.SCHEMAS_ROUTE+"*"+registry.SCHEMA_PARAM, a.audited(audit.SCHEMA_PUBLISH, registry.SCHEMA_PARAM, registry.PutSchemaHandler(r))...)
+		publishGroup.DELETE(registry.SCHEMAS_ROUTE+"*"+registry.SCHEMA_PARAM, a.audited(audit.SCHEMA_DELETE, registry.SCHEMA_PARAM, registry.DeleteSchemaHandler(r))...)
+	}
+}
+
+func (a *App) initializeDeadLetterRoutes() {
+	if a.deadLetterQueue != nil {
+		log.Info().Msg("🟢 initializing dead letter replay route")
+		a.authenticatedRouterGroup().POST(dlq.DLQ_REPLAY_ROUTE, a.audited(audit.DEADLETTER_REPLAY, "", dlq.ReplayHandler(a.deadLetterQueue, a.sinks))...)
+	}
+}
+
+func (a *App) initializeReplayRoutes() {
+	if a.replayer != nil {
+		log.Info().Msg("🟢 initializing archive replay route")
+		a.authenticatedRouterGroup().POST(replay.REPLAY_ROUTE, a.audited(audit.ARCHIVE_REPLAY, "", replay.ReplayHandler(a.replayer, a.sinks))...)
+	}
+}
+
+func (a *App) initializeErasureRoutes() {
+	if a.config.Erasure.Enabled {
+		log.Info().Msg("🟢 initializing erasure route")
+		eraser, err := erasure.NewEraser(a.config.Erasure, a.config.App)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not initialize erasure")
+		}
+		a.authenticatedRouterGroup().POST(erasure.ERASURE_ROUTE, a.audited(audit.ERASURE_REQUEST, "", erasure.Handler(eraser, a.manifold))...)
+	}
+}
+
+func (a *App) initializeTapRoutes() {
+	if a.config.Tap.Enabled {
+		// Envelopes hold user data, so the tap always requires auth, and
+		// streams are audited once they end
+		log.Info().Msg("🟢 initializing tap route")
+		a.authenticatedRouterGroup().GET(tap.TAP_ROUTE, a.audited(audit.TAP_STREAM, "", tap.Handler(a.config.Tap))...)
+	}
+}
+
+func (a *App) initializeInputs() {
+	inputs := []input.Input{
+		&pixel.PixelInput{},
+		&webhook.WebhookInput{},
+		&selfdescribing.SelfDescribingInput{},
+		&cloudevents.CloudeventsInput{},
+		&snowplow.SnowplowInput{},
+	}
+	inputs = append(inputs, input.Registered()...)
+	inputGroup := a.switchableRouterGroup
+	// Overloaded collectors fast-fail requests before doing anything with them
+	if a.config.Middleware.CircuitBreaker.Enabled {
+		log.Info().Msg("🟢 initializing circuit breaker middleware")
+		inputGroup = inputGroup.Group("")
+		inputGroup.Use(middleware.CircuitBreaker(a.config.Middleware.CircuitBreaker, backendutils.Stats().QueuedBatches))
+	}
+	// Requests without the headers of their input are rejected before anything else looks at them
+	var requiredHeaders *middleware.RequiredHeaders
+	if a.config.Middleware.RequiredHeaders.Enabled {
+		log.Info().Msg("🟢 initializing required headers middleware")
+		var err error
+		requiredHeaders, err = middleware.NewRequiredHeaders(a.config.Middleware.RequiredHeaders)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not initialize required headers")
+		}
+		inputGroup = inputGroup.Group("")
+		inputGroup.Use(requiredHeaders.Middleware())
+	}
+	// Bots are rejected before tenants are resolved or limits are counted
+	if a.config.Middleware.BotFilter.Enabled {
+		log.Info().Msg("🟢 initializing bot filter middleware")
+		botFilter, err := middleware.BotFilter(a.config.Middleware.BotFilter)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not initialize bot filter")
+		}
+		inputGroup = inputGroup.Group("")
+		inputGroup.Use(botFilter)
+	}
+	if a.abuseTracker != nil {
+		log.Info().Msg("🟢 initializing abuse detection middleware")
+		inputGroup = inputGroup.Group("")
+		inputGroup.Use(a.abuseTracker.Middleware())
+	}
+	// Only ingestion routes are scoped to tenants
+	if a.config.Middleware.Tenancy.Enabled {
+		log.Info().Msg("🟢 initializing tenancy middleware")
+		inputGroup = inputGroup.Group("")
+		inputGroup.Use(middleware.Tenancy(a.config.Middleware.Tenancy))
+	}
+	if a.inputRateLimiter != nil {
+		inputGroup = inputGroup.Group("")
+		inputGroup.Use(a.inputRateLimiter)
+	}
+	// Quotas count the events of api keys or tenants, once they're resolved
+	if a.config.Middleware.Quotas.Enabled {
+		log.Info().Msg("🟢 initializing quota middleware")
+		quotas, err := middleware.NewQuotas(a.config.Middleware.Quotas)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not initialize quotas")
+		}
+		inputGroup = inputGroup.Group("")
+		inputGroup.Use(quotas.Middleware())
+	}
+	for _, i := range inputs {
+		// Api keys may be limited to some inputs
+		g := inputGroup.Group("", middleware.InputThroughput(input.Name(i)), middleware.InputScope(input.Name(i)))
+		existing := a.routePaths()
+		err := i.Initialize(g, &a.manifold, a.config, a.collectorMeta)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to initialize input")
+		}
+		var paths []string
+		for path := range a.routePaths() {
+			if !existing[path] {
+				paths = append(paths, path)
+			}
+		}
+		if a.rateLimiters != nil {
+			a.rateLimiters.LimitRoutes(input.Name(i), paths)
+		}
+		if requiredHeaders != nil {
+			requiredHeaders.RequireRoutes(input.Name(i), paths)
+		}
+	}
+}
+
+// routePaths returns the paths of the routes registered so far
+func (a *App) routePaths() map[string]bool {
+	paths := make(map[string]bool)
+	for _, r := range a.engine.Routes() {
+		paths[r.Path] = true
+	}
+	return paths
+}
+
+func (a *App) Initialize() {
+	log.Info().Msg("🟢 initializing app")
+	a.configure()
+	a.initializeRouter()
+	a.initializeSentry()
+	a.initializeManifold()
+	a.initializeStatsd()
+	a.initializeAudit()
+	a.initializeMiddleware()
+	a.initializePublicRoutes()
+	a.initializeOpsRoutes()
+	a.initializePprofRoutes()
+	a.initializeSchemaCacheRoutes()
+	a.initializeDeadLetterRoutes()
+	a.initializeReplayRoutes()
+	a.initializeErasureRoutes()
+	a.initializeTapRoutes()
+	a.initializeInputs()
+}
+
+// closeEmitters stops the heartbeat and emits the shutdown event, before
+// the sinks are shut down
+func (a *App) closeEmitters() {
+	if a.heartbeat != nil {
+		a.heartbeat.Close()
+	}
+	if a.tracker != nil {
+		a.tracker.Close()
+	}
+}
+
+// closeRegistry stops refreshing schemas, after the manifold has drained
+func (a *App) closeRegistry() {
+	if r := a.manifold.GetRegistry(); r != nil {
+		r.Close()
+	}
+}
+
+// closeStatsd sends the last metrics, after the manifold has drained
+func (a *App) closeStatsd() {
+	if a.statsd == nil {
+		return
+	}
+	if err := a.statsd.Close(); err != nil {
+		log.Error().Err(err).Msg("could not close statsd exporter")
+	}
+}
+
+// closeAdminServer stops the admin listener
+func (a *App) closeAdminServer() {
+	if a.adminServer != nil {
+		a.adminServer.Close()
+	}
+}
+
+// closeAudit closes the audit file, after the audit sink has drained
+func (a *App) closeAudit() {
+	if a.audit == nil {
+		return
+	}
+	if err := a.audit.Close(); err != nil {
+		log.Error().Err(err).Msg("could not close audit log")
+	}
+}
+
+// closeSentry sends the last errors, including those of draining sinks
+func (a *App) closeSentry() {
+	if a.sentry != nil {
+		a.sentry.Close()
+	}
+}
+
+func (a *App) serverlessMode() {
+	log.Debug().Msg("🟡 running buz in serverless mode")
+	log.Info().Msg("🐝🐝🐝 buz is running 🐝🐝🐝")
+	err := gateway.ListenAndServe(":3000", a.engine)
+	tele.Sis(a.config, a.collectorMeta)
+	if err != nil {
+		log.Fatal().Err(err)
+	}
+	a.closeEmitters()
+	err = a.manifold.Shutdown()
+	if err != nil {
+		log.Error().Err(err).Msg("manifold failed to shut down safely")
+	}
+	a.closeRegistry()
+	a.closeAudit()
+	a.closeStatsd()
+	a.closeSentry()
+}
+
+func (a *App) standardMode() {
+	log.Debug().Msg("🟡 running Buz in standard mode")
+	srv := &http.Server{
+		Addr:    ":" + a.config.App.Port,
+		Handler: a.engine,
+	}
+	go func() {
+		log.Info().Msg("🐝🐝🐝 buz is running 🐝🐝🐝")
+		if err := srv.ListenAndServe(); err != nil && errors.Is(err, http.ErrServerClosed) {
+			log.Info().Msgf("🟢 server shut down")
+		}
+	}()
+	// Safe shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Info().Msg("🟢 shutting down server...")
+	// The server stops accepting requests before the manifold drains
+	ctx, cancel := context.WithTimeout(context.Background(), manifold.ShutdownTimeout(a.config.App))
+	defer cancel()
+	a.closeAdminServer()
+	if err := srv.Shutdown(ctx); err != nil {
+		a.closeEmitters()
+		err := a.manifold.Shutdown()
+		if err != nil {
+			log.Error().Err(err).Msg("manifold failed to shut down safely")
+		}
+		log.Fatal().Stack().Err(err).Msg("server forced to shutdown")
+	}
+	a.closeEmitters()
+	err := a.manifold.Shutdown()
+	if err != nil {
+		log.Error().Err(err).Msg("manifold failed to shut down safely")
+	}
+	a.closeRegistry()
+	a.closeAudit()
+	a.closeStatsd()
+	a.closeSentry()
+	tele.Sis(a.config, a.collectorMeta)
+}
+
+func (a *App) Run() {
+	redacted, _ := config.Redact(*a.config, a.config.App.RedactPatterns)
+	log.Debug().Interface("config", redacted).Msg("running 🐝 with config")
+	tele.Metry(a.config, a.collectorMeta)
+	if a.config.App.Serverless {
+		a.serverlessMode()
+	} else {
+		a.standardMode()
+	}
+}
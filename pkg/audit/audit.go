@@ -0,0 +1,169 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/meta"
+)
+
+const (
+	// The protocol of audit envelopes
+	AUDIT        string = "audit"
+	AUDIT_SCHEMA string = "io.silverton/buz/internal/audit/v1.0.json"
+)
+
+// Actions
+const (
+	CACHE_PURGE       string = "cachePurge"
+	SCHEMA_PUBLISH    string = "schemaPublish"
+	SCHEMA_DELETE     string = "schemaDelete"
+	SNAPSHOT_IMPORT   string = "snapshotImport"
+	DEADLETTER_REPLAY string = "deadLetterReplay"
+	ARCHIVE_REPLAY    string = "archiveReplay"
+	ABUSE_UNBLOCK     string = "abuseUnblock"
+	LOG_LEVEL_CHANGE  string = "logLevelChange"
+	ERASURE_REQUEST   string = "erasureRequest"
+	TAP_STREAM        string = "tapStream"
+)
+
+var ErrNoAuditDestination = errors.New("audit log requires a path or a sink")
+
+// Record is an administrative operation: who performed it, when, and from
+// where
+type Record struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Target     string    `json:"target,omitempty"`
+	Identity   string    `json:"identity,omitempty"`
+	ClientIp   string    `json:"clientIp,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	InstanceId string    `json:"instanceId,omitempty"`
+}
+
+// Log writes audit records to a file and/or sink. Records are written as
+// they happen, not batched, so none are lost if the collector crashes.
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+	sink backendutils.Sink
+	app  config.App
+	meta *meta.CollectorMeta
+	now  func() time.Time
+}
+
+func NewLog(conf config.Audit, app config.App, m *meta.CollectorMeta, sinks []backendutils.Sink) (*Log, error) {
+	if conf.Path == "" && conf.Sink == "" {
+		return nil, ErrNoAuditDestination
+	}
+	l := &Log{app: app, meta: m, now: time.Now}
+	if conf.Sink != "" {
+		for _, s := range sinks {
+			if s.Metadata().Name == conf.Sink {
+				l.sink = s
+			}
+		}
+		if l.sink == nil {
+			return nil, errors.New("audit sink not found: " + conf.Sink)
+		}
+	}
+	if conf.Path != "" {
+		f, err := os.OpenFile(conf.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		l.file = f
+	}
+	return l, nil
+}
+
+// Record writes the record to the file and sink
+func (l *Log) Record(r Record) {
+	if r.Time.IsZero() {
+		r.Time = l.now().UTC()
+	}
+	if l.meta != nil {
+		r.InstanceId = l.meta.InstanceId.String()
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		log.Error().Err(err).Str("action", r.Action).Msg("🔴 could not marshal audit record")
+		return
+	}
+	if l.file != nil {
+		l.mu.Lock()
+		_, err := l.file.Write(append(line, '\n'))
+		l.mu.Unlock()
+		if err != nil {
+			log.Error().Err(err).Str("action", r.Action).Msg("🔴 could not write audit record")
+		}
+	}
+	if l.sink != nil {
+		var payload envelope.Payload
+		json.Unmarshal(line, &payload)
+		l.emit(payload, r.Action)
+	}
+}
+
+func (l *Log) emit(payload envelope.Payload, action string) {
+	e := envelope.NewEnvelope(l.app)
+	e.Protocol = AUDIT
+	e.Schema = AUDIT_SCHEMA
+	e.Vendor = "io.silverton"
+	e.Namespace = "buz.internal.audit"
+	e.Version = "1.0"
+	e.IsValid = true
+	e.Payload = payload
+	if err := l.sink.Enqueue([]envelope.Envelope{e}); err != nil {
+		log.Error().Err(err).Str("action", action).Msg("🔴 could not emit audit record")
+		return
+	}
+	backendutils.Stats().Enqueued(l.sink.Metadata().Name, 1)
+}
+
+// Middleware records the request as the action once it is handled,
+// whether or not it succeeded. The target is read from the route param,
+// if there is one.
+func (l *Log) Middleware(action string, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		r := Record{
+			Action:    action,
+			Identity:  c.GetString(constants.AUTH_IDENTITY),
+			ClientIp:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			Status:    c.Writer.Status(),
+		}
+		if param != "" {
+			r.Target = strings.TrimPrefix(c.Param(param), "/")
+		}
+		l.Record(r)
+	}
+}
+
+// Close closes the audit file
+func (l *Log) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSink struct {
+	envelopes []envelope.Envelope
+}
+
+func (s *recordingSink) Metadata() backendutils.SinkMetadata {
+	return backendutils.SinkMetadata{Name: "audit"}
+}
+func (s *recordingSink) Initialize(conf config.Sink) error { return nil }
+func (s *recordingSink) StartWorker() error                { return nil }
+func (s *recordingSink) Enqueue(envelopes []envelope.Envelope) error {
+	s.envelopes = append(s.envelopes, envelopes...)
+	return nil
+}
+func (s *recordingSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	return nil
+}
+func (s *recordingSink) Shutdown() error { return nil }
+
+func TestMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink := &recordingSink{}
+	l, err := NewLog(config.Audit{Path: path, Sink: "audit"}, config.App{}, nil, []backendutils.Sink{sink})
+	assert.Nil(t, err)
+
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	identify := func(c *gin.Context) { c.Set(constants.AUTH_IDENTITY, "key:ops") }
+	e.POST("/c/schemas/*schema", identify, l.Middleware(SCHEMA_PUBLISH, "schema"), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+	e.POST("/c/cache/purge", l.Middleware(CACHE_PURGE, ""), func(c *gin.Context) {
+		c.Status(http.StatusForbidden)
+	})
+	for _, route := range []string{"/c/schemas/com.yourcompany/checkout/v1.0.json", "/c/cache/purge"} {
+		req := httptest.NewRequest(http.MethodPost, route, nil)
+		req.Header.Set("User-Agent", "curl/8.0")
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Nil(t, l.Close())
+
+	contents, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	assert.Len(t, lines, 2)
+	var published Record
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &published))
+	assert.Equal(t, SCHEMA_PUBLISH, published.Action)
+	assert.Equal(t, "com.yourcompany/checkout/v1.0.json", published.Target)
+	assert.Equal(t, "key:ops", published.Identity)
+	assert.Equal(t, "curl/8.0", published.UserAgent)
+	assert.Equal(t, http.StatusCreated, published.Status)
+	assert.False(t, published.Time.IsZero())
+	// Refused operations are recorded too
+	var purged Record
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &purged))
+	assert.Equal(t, CACHE_PURGE, purged.Action)
+	assert.Equal(t, http.StatusForbidden, purged.Status)
+
+	assert.Len(t, sink.envelopes, 2)
+	assert.Equal(t, AUDIT_SCHEMA, sink.envelopes[0].Schema)
+	assert.Equal(t, "key:ops", sink.envelopes[0].Payload["identity"])
+}
+
+func TestNewLogErrors(t *testing.T) {
+	_, err := NewLog(config.Audit{Enabled: true}, config.App{}, nil, nil)
+	assert.ErrorIs(t, err, ErrNoAuditDestination)
+	_, err = NewLog(config.Audit{Sink: "missing"}, config.App{}, nil, []backendutils.Sink{&recordingSink{}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codecs
+const (
+	GZIP   string = "gzip"
+	ZSTD   string = "zstd"
+	SNAPPY string = "snappy"
+)
+
+// Compressor compresses the request bodies of sinks which post payloads.
+// Sinks with native compression, such as kafka, use the codec name instead.
+type Compressor struct {
+	// The Content-Encoding of compressed bodies
+	Encoding  string
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+func (c *Compressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := c.newWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Body compresses a request body and sets its Content-Encoding. Bodies of
+// sinks without compression are returned as-is.
+func (c *Compressor) Body(b []byte, header http.Header) ([]byte, error) {
+	if c == nil {
+		return b, nil
+	}
+	header.Set("Content-Encoding", c.Encoding)
+	return c.Compress(b)
+}
+
+// NewCompressor returns the compressor of a codec. Sinks without
+// compression return nil.
+func NewCompressor(codec string) (*Compressor, error) {
+	c := &Compressor{Encoding: codec}
+	switch codec {
+	case "":
+		return nil, nil
+	case GZIP:
+		c.newWriter = func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }
+	case ZSTD:
+		c.newWriter = func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }
+	case SNAPPY:
+		// Framed, so bodies can be streamed by the receiver
+		c.newWriter = func(w io.Writer) (io.WriteCloser, error) { return snappy.NewBufferedWriter(w), nil }
+	default:
+		return nil, errors.New("unsupported compression: " + codec)
+	}
+	return c, nil
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressor(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"schema":"io.silverton/buz/example/v1.0.json"}`), 100)
+	readers := map[string]func(r io.Reader) (io.Reader, error){
+		GZIP:   func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		ZSTD:   func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+		SNAPPY: func(r io.Reader) (io.Reader, error) { return snappy.NewReader(r), nil },
+	}
+	for codec, newReader := range readers {
+		c, err := NewCompressor(codec)
+		assert.Nil(t, err)
+		header := http.Header{}
+		compressed, err := c.Body(body, header)
+		assert.Nil(t, err)
+		assert.Equal(t, codec, header.Get("Content-Encoding"))
+		assert.Less(t, len(compressed), len(body), codec)
+		r, err := newReader(bytes.NewReader(compressed))
+		assert.Nil(t, err)
+		decompressed, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, body, decompressed, codec)
+	}
+
+	c, err := NewCompressor("")
+	assert.Nil(t, err)
+	header := http.Header{}
+	uncompressed, err := c.Body(body, header)
+	assert.Nil(t, err)
+	assert.Equal(t, body, uncompressed)
+	assert.Equal(t, "", header.Get("Content-Encoding"))
+
+	_, err = NewCompressor("lzma")
+	assert.NotNil(t, err)
+}
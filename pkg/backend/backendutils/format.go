@@ -0,0 +1,56 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"errors"
+
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// Output formats
+const (
+	JSON     string = "json"
+	AVRO     string = "avro"
+	PROTOBUF string = "protobuf"
+)
+
+// The content type header or attribute of each output format
+var contentTypes = map[string]string{
+	JSON:     "application/json",
+	AVRO:     "avro/binary",
+	PROTOBUF: "application/x-protobuf",
+}
+
+// Encoder serializes envelopes in the output format of a sink
+type Encoder struct {
+	Format      string
+	ContentType string
+	encode      func(e *envelope.Envelope) ([]byte, error)
+}
+
+func (enc Encoder) Encode(e envelope.Envelope) ([]byte, error) {
+	return enc.encode(&e)
+}
+
+// NewEncoder returns the encoder of an output format, which defaults to
+// json. Binary formats are only supported by sinks which write bytes.
+func NewEncoder(format string) (Encoder, error) {
+	if format == "" {
+		format = JSON
+	}
+	enc := Encoder{Format: format, ContentType: contentTypes[format]}
+	switch format {
+	case JSON:
+		enc.encode = (*envelope.Envelope).AsByte
+	case AVRO:
+		enc.encode = (*envelope.Envelope).AsAvro
+	case PROTOBUF:
+		enc.encode = (*envelope.Envelope).AsProtobuf
+	default:
+		return Encoder{}, errors.New("unsupported output format: " + format)
+	}
+	return enc, nil
+}
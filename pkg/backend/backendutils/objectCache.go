@@ -0,0 +1,46 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import "sync"
+
+// CachedObject is a local copy of a remote object, along with the
+// version identifier (etag, generation, etc) it was fetched at.
+type CachedObject struct {
+	Version  string
+	Contents []byte
+}
+
+// ObjectCache holds local copies of objects fetched from object-store
+// registry backends so they can be conditionally refreshed.
+type ObjectCache struct {
+	mu      sync.RWMutex
+	objects map[string]CachedObject
+}
+
+func NewObjectCache() *ObjectCache {
+	return &ObjectCache{
+		objects: make(map[string]CachedObject),
+	}
+}
+
+func (c *ObjectCache) Get(key string) (CachedObject, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	o, ok := c.objects[key]
+	return o, ok
+}
+
+func (c *ObjectCache) Set(key string, version string, contents []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.objects[key] = CachedObject{Version: version, Contents: contents}
+}
+
+func (c *ObjectCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.objects, key)
+}
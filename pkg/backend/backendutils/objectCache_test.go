@@ -0,0 +1,30 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectCache(t *testing.T) {
+	c := NewObjectCache()
+	_, ok := c.Get("a.json")
+	assert.False(t, ok)
+
+	c.Set("a.json", "etag1", []byte(`{}`))
+	o, ok := c.Get("a.json")
+	assert.True(t, ok)
+	assert.Equal(t, CachedObject{Version: "etag1", Contents: []byte(`{}`)}, o)
+
+	c.Set("a.json", "etag2", []byte(`{"a":1}`))
+	o, _ = c.Get("a.json")
+	assert.Equal(t, "etag2", o.Version)
+
+	c.Delete("a.json")
+	_, ok = c.Get("a.json")
+	assert.False(t, ok)
+}
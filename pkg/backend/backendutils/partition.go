@@ -0,0 +1,148 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/util"
+)
+
+type keyPart struct {
+	literal string
+	pointer []string
+}
+
+// PartitionKey derives the partition or ordering key of an envelope from
+// a json pointer into the envelope, such as /payload/user_id, or from a
+// template of pointers, such as {{/namespace}}:{{/payload/user_id}}.
+// Envelopes are ordered downstream by their key.
+type PartitionKey struct {
+	parts []keyPart
+}
+
+func parseKeyPointer(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("partition key pointers must start with /: " + pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// NewPartitionKey compiles the partition key of a sink. Sinks without
+// one return nil.
+func NewPartitionKey(spec string) (*PartitionKey, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	k := &PartitionKey{}
+	if !strings.Contains(spec, "{{") {
+		pointer, err := parseKeyPointer(spec)
+		if err != nil {
+			return nil, err
+		}
+		k.parts = append(k.parts, keyPart{pointer: pointer})
+		return k, nil
+	}
+	for rest := spec; rest != ""; {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			k.parts = append(k.parts, keyPart{literal: rest})
+			break
+		}
+		end := strings.Index(rest[start:], "}}")
+		if end < 0 {
+			return nil, errors.New("unterminated partition key template: " + spec)
+		}
+		if start > 0 {
+			k.parts = append(k.parts, keyPart{literal: rest[:start]})
+		}
+		pointer, err := parseKeyPointer(strings.TrimSpace(rest[start+2 : start+end]))
+		if err != nil {
+			return nil, err
+		}
+		k.parts = append(k.parts, keyPart{pointer: pointer})
+		rest = rest[start+end+2:]
+	}
+	return k, nil
+}
+
+// field returns the top-level field of the envelope
+func field(e envelope.Envelope, name string) interface{} {
+	switch name {
+	case "uuid":
+		return e.Uuid.String()
+	case "protocol":
+		return e.Protocol
+	case "schema":
+		return e.Schema
+	case "vendor":
+		return e.Vendor
+	case "namespace":
+		return e.Namespace
+	case "version":
+		return e.Version
+	case "tenant":
+		return e.Tenant()
+	case "payload":
+		return map[string]interface{}(e.Payload)
+	case "contexts":
+		if e.Contexts == nil {
+			return nil
+		}
+		return map[string]interface{}(*e.Contexts)
+	}
+	return nil
+}
+
+func resolveKeyPointer(e envelope.Envelope, pointer []string) string {
+	v := field(e, pointer[0])
+	for _, token := range pointer[1:] {
+		switch current := v.(type) {
+		case map[string]interface{}:
+			v = current[token]
+		case envelope.Payload:
+			v = current[token]
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(current) {
+				return ""
+			}
+			v = current[i]
+		default:
+			return ""
+		}
+	}
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	return util.Stringify(v)
+}
+
+// Of returns the partition key of the envelope, which is empty if the
+// sink has no partition key or the envelope doesn't have its fields
+func (k *PartitionKey) Of(e envelope.Envelope) string {
+	if k == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, part := range k.parts {
+		if part.pointer == nil {
+			b.WriteString(part.literal)
+			continue
+		}
+		b.WriteString(resolveKeyPointer(e, part.pointer))
+	}
+	return b.String()
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionKey(t *testing.T) {
+	e := envelope.Envelope{
+		Namespace: "checkout",
+		Payload: envelope.Payload{
+			"user_id": "u-123",
+			"cart":    map[string]interface{}{"items": []interface{}{"a", "b"}, "size": 2},
+			"a/b":     "escaped",
+		},
+	}
+	testCases := []struct {
+		spec string
+		want string
+	}{
+		{"/payload/user_id", "u-123"},
+		{"/namespace", "checkout"},
+		{"/payload/cart/items/1", "b"},
+		{"/payload/cart/size", "2"},
+		{"/payload/a~1b", "escaped"},
+		{"/payload/missing", ""},
+		{"/payload/cart/items/9", ""},
+		{"{{/namespace}}:{{ /payload/user_id }}", "checkout:u-123"},
+		{"user-{{/payload/user_id}}", "user-u-123"},
+	}
+	for _, tc := range testCases {
+		k, err := NewPartitionKey(tc.spec)
+		assert.Nil(t, err, tc.spec)
+		assert.Equal(t, tc.want, k.Of(e), tc.spec)
+	}
+
+	k, err := NewPartitionKey("")
+	assert.Nil(t, err)
+	assert.Nil(t, k)
+	assert.Equal(t, "", k.Of(e))
+
+	_, err = NewPartitionKey("payload/user_id")
+	assert.NotNil(t, err)
+	_, err = NewPartitionKey("{{/payload/user_id")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// Poison policies, for envelopes which a sink fails to deliver
+// permanently, such as envelopes it can't serialize
+const (
+	QUARANTINE string = "quarantine" // Deliver the envelope to the deadletter output, with the error attached
+	DEADLETTER string = "deadletter" // Write the envelopes to the dead letter queue
+	DISCARD    string = "drop"       // Drop the envelope
+)
+
+// The validation error of quarantined envelopes. These are vars, since
+// validation errors point to them.
+var (
+	poisonErrorType       = "poison event"
+	poisonErrorResolution = "fix the sink, or the event it could not deliver"
+)
+
+// ValidatePoisonPolicy returns an error if the poison policy is unsupported
+func ValidatePoisonPolicy(policy string) error {
+	switch policy {
+	case QUARANTINE, DEADLETTER, DISCARD, "":
+		return nil
+	}
+	return errors.New("unsupported poison policy: " + policy)
+}
+
+// EnvelopeErrors is returned by sinks which know which envelopes of a
+// batch they failed to deliver, keyed by their index in the batch. The
+// others were delivered, so they aren't sent again.
+type EnvelopeErrors map[int]error
+
+func (e EnvelopeErrors) Error() string {
+	indexes := e.indexes()
+	if len(indexes) == 0 {
+		return "no envelopes failed"
+	}
+	return fmt.Sprintf("%d envelopes were not delivered, such as envelope %d: %v", len(e), indexes[0], e[indexes[0]])
+}
+
+// indexes returns the indexes of the failed envelopes, in order
+func (e EnvelopeErrors) indexes() []int {
+	indexes := make([]int, 0, len(e))
+	for i := range e {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// poison is an envelope which the sink failed to deliver on its own
+type poison struct {
+	envelope envelope.Envelope
+	err      error
+}
+
+// isolate returns the envelopes which the sink reported failing
+// permanently. Envelopes which failed transiently are dead-lettered, and
+// the error is returned if they couldn't be. Envelopes are never sent
+// again to find which failed, as the sink may already have delivered the
+// others.
+func isolate(sink Sink, envelopes []envelope.Envelope, output string, failures EnvelopeErrors) ([]poison, error) {
+	policy := newRetryPolicy(sink.Metadata().Retry)
+	var transient []envelope.Envelope
+	var poisoned []poison
+	for _, i := range failures.indexes() {
+		err := failures[i]
+		if policy.retryable(err) || sink.Metadata().OnPoison == DEADLETTER {
+			transient = append(transient, envelopes[i])
+			continue
+		}
+		poisoned = append(poisoned, poison{envelope: envelopes[i], err: err})
+	}
+	if len(transient) > 0 {
+		return poisoned, deadLetter(sink, output, transient, failures)
+	}
+	return poisoned, nil
+}
+
+// undelivered returns the envelopes of a failed delivery which the sink
+// didn't deliver
+func undelivered(envelopes []envelope.Envelope, err error) []envelope.Envelope {
+	var failures EnvelopeErrors
+	if !errors.As(err, &failures) {
+		return envelopes
+	}
+	var failed []envelope.Envelope
+	for _, i := range failures.indexes() {
+		if i < len(envelopes) {
+			failed = append(failed, envelopes[i])
+		}
+	}
+	return failed
+}
+
+// poisonAll returns every envelope of the batch as poison
+func poisonAll(envelopes []envelope.Envelope, err error) []poison {
+	poisoned := make([]poison, len(envelopes))
+	for i, e := range envelopes {
+		poisoned[i] = poison{envelope: e, err: err}
+	}
+	return poisoned
+}
+
+func invalidatePoison(e *envelope.Envelope, err error) {
+	e.IsValid = false
+	e.ValidationError = &envelope.ValidationError{
+		ErrorType:       &poisonErrorType,
+		ErrorResolution: &poisonErrorResolution,
+		Errors: []envelope.PayloadValidationError{{
+			Description: err.Error(),
+		}},
+	}
+}
+
+// quarantine applies the sink's poison policy to the poisoned envelopes,
+// so they neither block the sink nor are retried forever. The error is
+// returned if envelopes couldn't be quarantined or dead-lettered.
+func quarantine(ctx context.Context, sink Sink, poisoned []poison, output string) (err error) {
+	if len(poisoned) == 0 {
+		return nil
+	}
+	metadata := sink.Metadata()
+	sinkStats.Quarantined(metadata.Name, len(poisoned))
+	log.Error().Err(poisoned[0].err).Int("envelopes", len(poisoned)).Interface("metadata", metadata).Msg("🔴 quarantining poison envelopes")
+	if metadata.OnPoison == DISCARD {
+		discarded := make([]envelope.Envelope, len(poisoned))
+		for i, p := range poisoned {
+			discarded[i] = p.envelope
+		}
+		failed(metadata, discarded, poisoned[0].err)
+		return nil
+	}
+	// Envelopes which were invalid already failed on the deadletter output
+	wasValid := poisoned[0].envelope.IsValid
+	var quarantined []envelope.Envelope
+	for _, p := range poisoned {
+		e := p.envelope
+		invalidatePoison(&e, p.err)
+		quarantined = append(quarantined, e)
+	}
+	if !wasValid {
+		return deadLetter(sink, output, quarantined, poisoned[0].err)
+	}
+	outputs, sharded := shard(metadata.DeadletterOutput, quarantined)
+	for _, deadletterOutput := range outputs {
+		if aErr := attempt(ctx, sink, sharded[deadletterOutput], deadletterOutput); aErr != nil {
+			if dlqErr := deadLetter(sink, deadletterOutput, undelivered(sharded[deadletterOutput], aErr), aErr); dlqErr != nil {
+				err = dlqErr
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+// poisonedSink can't serialize envelopes with a poison payload, which it
+// reports on their own, and panics on envelopes with a panic payload.
+// Unless it reports failures, a poison envelope fails the whole batch.
+type poisonedSink struct {
+	failingSink
+	onPoison   string
+	unreported bool
+	delivered  map[string][]envelope.Envelope
+	sent       int
+}
+
+func (s *poisonedSink) Metadata() SinkMetadata {
+	return SinkMetadata{
+		Name:             "poisoned",
+		DefaultOutput:    "valid",
+		DeadletterOutput: "invalid",
+		OnPoison:         s.onPoison,
+		Retry:            config.Retry{MaxAttempts: 3, InitialBackoffMs: 1},
+	}
+}
+
+func (s *poisonedSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	s.sent += len(envelopes)
+	failures := make(EnvelopeErrors)
+	for i, e := range envelopes {
+		if e.Payload["poison"] != nil && output == "valid" {
+			if s.unreported {
+				return Permanent(errors.New("could not serialize"))
+			}
+			failures[i] = Permanent(errors.New("could not serialize"))
+			continue
+		}
+		if e.Payload["panic"] != nil {
+			panic("could not serialize")
+		}
+	}
+	for i, e := range envelopes {
+		if _, ok := failures[i]; !ok {
+			s.delivered[output] = append(s.delivered[output], e)
+		}
+	}
+	if len(failures) > 0 {
+		return failures
+	}
+	return nil
+}
+
+func poisonBatch() []envelope.Envelope {
+	return []envelope.Envelope{
+		{IsValid: true, Payload: envelope.Payload{}},
+		{IsValid: true, Payload: envelope.Payload{"poison": true}},
+		{IsValid: true, Payload: envelope.Payload{}},
+	}
+}
+
+func TestDeliverQuarantinesPoisonEnvelopes(t *testing.T) {
+	s := &poisonedSink{delivered: make(map[string][]envelope.Envelope)}
+	Deliver(context.Background(), s, poisonBatch())
+	assert.Len(t, s.delivered["valid"], 2)
+	assert.Len(t, s.delivered["invalid"], 1)
+	quarantined := s.delivered["invalid"][0]
+	assert.False(t, quarantined.IsValid)
+	assert.Equal(t, poisonErrorType, *quarantined.ValidationError.ErrorType)
+	assert.Equal(t, "could not serialize", quarantined.ValidationError.Errors[0].Description)
+	// The delivered envelopes aren't sent again
+	assert.Equal(t, 4, s.sent)
+}
+
+func TestDeliverQuarantinesBatchesWithUnreportedPoison(t *testing.T) {
+	s := &poisonedSink{unreported: true, delivered: make(map[string][]envelope.Envelope)}
+	Deliver(context.Background(), s, poisonBatch())
+	// The sink may have delivered some of the batch, so none of it is sent
+	// to the output again
+	assert.Empty(t, s.delivered["valid"])
+	assert.Len(t, s.delivered["invalid"], 3)
+	assert.Equal(t, 6, s.sent)
+}
+
+type flakySink struct {
+	poisonedSink
+}
+
+// Dequeue fails the first envelope of the batch transiently, once
+func (s *flakySink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	s.sent += len(envelopes)
+	if s.sent == len(envelopes) {
+		s.delivered[output] = append(s.delivered[output], envelopes[1:]...)
+		return EnvelopeErrors{0: errors.New("unavailable")}
+	}
+	s.delivered[output] = append(s.delivered[output], envelopes...)
+	return nil
+}
+
+func TestDeliverRetriesOnlyFailedEnvelopes(t *testing.T) {
+	s := &flakySink{poisonedSink{delivered: make(map[string][]envelope.Envelope)}}
+	Deliver(context.Background(), s, poisonBatch())
+	assert.Len(t, s.delivered["valid"], 3)
+	assert.Equal(t, 4, s.sent)
+}
+
+func TestDeliverDeadLettersPanickingEnvelopes(t *testing.T) {
+	w := &recordingDeadLetterWriter{outputs: make(map[string]int)}
+	SetDeadLetterWriter(w)
+	defer SetDeadLetterWriter(nil)
+	s := &poisonedSink{delivered: make(map[string][]envelope.Envelope)}
+	Deliver(context.Background(), s, []envelope.Envelope{
+		{IsValid: true, Payload: envelope.Payload{}},
+		{IsValid: true, Payload: envelope.Payload{"panic": true}},
+	})
+	// A panic doesn't say which envelope failed, so the batch is quarantined,
+	// and panics on the deadletter output too
+	assert.Empty(t, s.delivered["valid"])
+	assert.Equal(t, map[string]int{"invalid": 2}, w.outputs)
+}
+
+func TestDeliverPoisonPolicies(t *testing.T) {
+	w := &recordingDeadLetterWriter{outputs: make(map[string]int)}
+	SetDeadLetterWriter(w)
+	defer SetDeadLetterWriter(nil)
+	s := &poisonedSink{onPoison: DEADLETTER, delivered: make(map[string][]envelope.Envelope)}
+	Deliver(context.Background(), s, poisonBatch())
+	assert.Len(t, s.delivered["valid"], 2)
+	assert.Empty(t, s.delivered["invalid"])
+	assert.Equal(t, map[string]int{"valid": 1}, w.outputs)
+
+	s = &poisonedSink{onPoison: DISCARD, delivered: make(map[string][]envelope.Envelope)}
+	Deliver(context.Background(), s, poisonBatch())
+	assert.Len(t, s.delivered["valid"], 2)
+	assert.Empty(t, s.delivered["invalid"])
+
+	assert.Nil(t, ValidatePoisonPolicy(QUARANTINE))
+	assert.NotNil(t, ValidatePoisonPolicy("retry"))
+}
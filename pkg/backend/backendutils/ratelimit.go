@@ -0,0 +1,116 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// tokenBucket refills at the rate, up to the burst. Reservations larger
+// than the balance put the bucket in debt, so batches of any size are
+// delivered once the rate allows it.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	if burst <= 0 {
+		burst = rate // One second of the rate
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes n tokens, and returns how long to wait before using them
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// rateLimiter holds deliveries to a sink within its events and bytes per
+// second. Held envelopes queue in front of the sink, where the manifold's
+// overload policy applies to them.
+type rateLimiter struct {
+	events *tokenBucket
+	bytes  *tokenBucket
+}
+
+func newRateLimiter(conf config.RateLimit) *rateLimiter {
+	if conf.EventsPerSecond <= 0 && conf.BytesPerSecond <= 0 {
+		return nil
+	}
+	l := &rateLimiter{}
+	if conf.EventsPerSecond > 0 {
+		l.events = newTokenBucket(conf.EventsPerSecond, float64(conf.BurstEvents))
+	}
+	if conf.BytesPerSecond > 0 {
+		l.bytes = newTokenBucket(conf.BytesPerSecond, float64(conf.BurstBytes))
+	}
+	return l
+}
+
+var limiters sync.Map
+
+// rateLimiterFor returns the rate limiter of the sink, which is shared by
+// all of its workers
+func rateLimiterFor(metadata SinkMetadata) *rateLimiter {
+	if v, ok := limiters.Load(metadata.Id); ok {
+		return v.(*rateLimiter)
+	}
+	v, _ := limiters.LoadOrStore(metadata.Id, newRateLimiter(metadata.RateLimit))
+	return v.(*rateLimiter)
+}
+
+// wait blocks until the envelopes can be delivered, returning how long it
+// waited. Waiting is abandoned if the context is done.
+func (l *rateLimiter) wait(ctx context.Context, envelopes []envelope.Envelope) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+	var delay time.Duration
+	if l.events != nil {
+		delay = l.events.reserve(float64(len(envelopes)))
+	}
+	if l.bytes != nil {
+		var size int
+		for _, e := range envelopes {
+			b, _ := e.AsByte()
+			size += len(b)
+		}
+		if d := l.bytes.reserve(float64(size)); d > delay {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(100, 0)
+	assert.Equal(t, time.Duration(0), b.reserve(100)) // The burst
+	// Reservations past the burst wait for the debt to be repaid
+	d := b.reserve(50)
+	assert.True(t, d > 400*time.Millisecond && d <= 500*time.Millisecond)
+}
+
+func TestRateLimiter(t *testing.T) {
+	assert.Nil(t, newRateLimiter(config.RateLimit{}))
+	l := newRateLimiter(config.RateLimit{EventsPerSecond: 1000, BurstEvents: 10})
+	envelopes := make([]envelope.Envelope, 20)
+	waited, err := l.wait(context.Background(), envelopes)
+	assert.Nil(t, err)
+	assert.True(t, waited > 5*time.Millisecond, waited)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = l.wait(ctx, make([]envelope.Envelope, 1000))
+	assert.Equal(t, context.Canceled, err)
+
+	// Sinks share their limiter across workers
+	metadata := SinkMetadata{Id: uuid.New(), RateLimit: config.RateLimit{BytesPerSecond: 100}}
+	assert.Same(t, rateLimiterFor(metadata), rateLimiterFor(metadata))
+	assert.Nil(t, rateLimiterFor(SinkMetadata{Id: uuid.New()}))
+}
@@ -0,0 +1,19 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSchemaNotFound is returned by registry backends which were reached
+// but do not have the schema, as opposed to backends which failed.
+var ErrSchemaNotFound = errors.New("schema not found")
+
+// SchemaNotFound wraps ErrSchemaNotFound with the name of the missing schema
+func SchemaNotFound(schema string, err error) error {
+	return fmt.Errorf("%w: %s: %v", ErrSchemaNotFound, schema, err)
+}
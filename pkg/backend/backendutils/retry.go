@@ -0,0 +1,139 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const (
+	// Retries resend the whole batch, unless the sink reports which
+	// envelopes failed, which duplicates the envelopes it delivered before
+	// failing, so they are opt-in
+	DEFAULT_RETRY_MAX_ATTEMPTS       int     = 1
+	DEFAULT_RETRY_INITIAL_BACKOFF_MS int     = 100
+	DEFAULT_RETRY_MAX_BACKOFF_MS     int     = 5000
+	DEFAULT_RETRY_MULTIPLIER         float64 = 2
+	DEFAULT_RETRY_JITTER             float64 = 0.2
+)
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error which retrying won't fix, such as a rejected
+// payload, so the delivery fails without being retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// retryPolicy retries failed deliveries with exponential backoff and
+// jitter, unless the error is permanent
+type retryPolicy struct {
+	maxAttempts  int
+	initial      time.Duration
+	max          time.Duration
+	multiplier   float64
+	jitter       float64
+	nonRetryable []string
+}
+
+func newRetryPolicy(conf config.Retry) retryPolicy {
+	p := retryPolicy{
+		maxAttempts:  conf.MaxAttempts,
+		initial:      time.Duration(conf.InitialBackoffMs) * time.Millisecond,
+		max:          time.Duration(conf.MaxBackoffMs) * time.Millisecond,
+		multiplier:   conf.Multiplier,
+		jitter:       conf.Jitter,
+		nonRetryable: conf.NonRetryable,
+	}
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = DEFAULT_RETRY_MAX_ATTEMPTS
+	}
+	if p.initial <= 0 {
+		p.initial = time.Duration(DEFAULT_RETRY_INITIAL_BACKOFF_MS) * time.Millisecond
+	}
+	if p.max <= 0 {
+		p.max = time.Duration(DEFAULT_RETRY_MAX_BACKOFF_MS) * time.Millisecond
+	}
+	if p.multiplier < 1 {
+		p.multiplier = DEFAULT_RETRY_MULTIPLIER
+	}
+	if p.jitter <= 0 || p.jitter > 1 {
+		p.jitter = DEFAULT_RETRY_JITTER
+	}
+	return p
+}
+
+// retryable returns false for errors which retrying won't fix. Batches
+// which failed in part are retryable if any envelope failed transiently.
+func (p retryPolicy) retryable(err error) bool {
+	var failures EnvelopeErrors
+	if errors.As(err, &failures) {
+		for _, err := range failures {
+			if p.retryable(err) {
+				return true
+			}
+		}
+		return false
+	}
+	var permanent permanentError
+	if errors.As(err, &permanent) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	for _, s := range p.nonRetryable {
+		if strings.Contains(err.Error(), s) {
+			return false
+		}
+	}
+	return true
+}
+
+// backoff returns how long to wait after the failed attempt, which is
+// randomized by the jitter so sinks aren't retried in lockstep
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.initial) * math.Pow(p.multiplier, float64(attempt-1))
+	if d > float64(p.max) {
+		d = float64(p.max)
+	}
+	d *= 1 - p.jitter*rand.Float64()
+	return time.Duration(d)
+}
+
+// do calls the delivery until it succeeds, fails permanently, or runs out
+// of attempts, and returns the number of attempts made
+func (p retryPolicy) do(ctx context.Context, deliver func() error) (attempts int, err error) {
+	for attempts = 1; ; attempts++ {
+		err = deliver()
+		if err == nil || attempts >= p.maxAttempts || !p.retryable(err) {
+			return attempts, err
+		}
+		timer := time.NewTimer(p.backoff(attempts))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, err
+		}
+	}
+}
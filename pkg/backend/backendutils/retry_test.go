@@ -0,0 +1,79 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	p := newRetryPolicy(config.Retry{})
+	assert.Equal(t, DEFAULT_RETRY_MAX_ATTEMPTS, p.maxAttempts)
+	assert.Equal(t, DEFAULT_RETRY_MULTIPLIER, p.multiplier)
+	assert.Equal(t, DEFAULT_RETRY_JITTER, p.jitter)
+	// Retries are opt-in
+	attempts, err := p.do(context.Background(), func() error { return errors.New("connection reset") })
+	assert.Equal(t, 1, attempts)
+	assert.NotNil(t, err)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := newRetryPolicy(config.Retry{InitialBackoffMs: 100, MaxBackoffMs: 300, Multiplier: 2, Jitter: 0.5})
+	for i := 0; i < 100; i++ {
+		first, second, capped := p.backoff(1), p.backoff(2), p.backoff(5)
+		assert.True(t, first > 50*time.Millisecond && first <= 100*time.Millisecond)
+		assert.True(t, second > 100*time.Millisecond && second <= 200*time.Millisecond)
+		assert.True(t, capped > 150*time.Millisecond && capped <= 300*time.Millisecond)
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	p := newRetryPolicy(config.Retry{NonRetryable: []string{"UNKNOWN_TOPIC"}})
+	assert.True(t, p.retryable(errors.New("connection reset")))
+	assert.False(t, p.retryable(errors.New("UNKNOWN_TOPIC_OR_PARTITION")))
+	assert.False(t, p.retryable(Permanent(errors.New("bad payload"))))
+	assert.False(t, p.retryable(context.Canceled))
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	p := newRetryPolicy(config.Retry{MaxAttempts: 4, InitialBackoffMs: 1})
+	calls := 0
+	attempts, err := p.do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempts)
+
+	calls = 0
+	attempts, err = p.do(context.Background(), func() error {
+		calls++
+		return errors.New("unavailable")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 4, attempts)
+
+	attempts, err = p.do(context.Background(), func() error {
+		return Permanent(errors.New("bad payload"))
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts, _ = newRetryPolicy(config.Retry{MaxAttempts: 4, InitialBackoffMs: 10000}).do(ctx, func() error {
+		return errors.New("unavailable")
+	})
+	assert.Equal(t, 1, attempts)
+}
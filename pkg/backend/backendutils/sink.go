@@ -6,32 +6,79 @@ package backendutils
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
 	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/expression"
+	"github.com/silverton-io/buz/pkg/stats"
 )
 
 var DEFAULT_SINK_TIMEOUT_SECONDS int = 15
 
+// Outputs containing placeholders are rendered per tenant or retention
+// class, such as events-{{tenant}} or events-{{retention}}
+const (
+	TENANT_PLACEHOLDER    string = "{{tenant}}"
+	RETENTION_PLACEHOLDER string = "{{retention}}"
+)
+
 type SinkMetadata struct {
-	Id               uuid.UUID `json:"id"`
-	SinkType         string    `json:"sinkType"`
-	Name             string    `json:"name"`
-	DeliveryRequired bool      `json:"deliveryRequired"`
-	DefaultOutput    string    `json:"defaultOutput"`
-	DeadletterOutput string    `json:"deadletterOutput"`
+	Id                    uuid.UUID        `json:"id"`
+	SinkType              string           `json:"sinkType"`
+	Name                  string           `json:"name"`
+	DeliveryRequired      bool             `json:"deliveryRequired"`
+	DefaultOutput         string           `json:"defaultOutput"`
+	DeadletterOutput      string           `json:"deadletterOutput"`
+	Workers               int              `json:"workers,omitempty"`
+	BatchSize             int              `json:"batchSize,omitempty"`
+	LingerMs              int              `json:"lingerMs,omitempty"`
+	IdleMs                int              `json:"idleMs,omitempty"`
+	Filter                string           `json:"filter,omitempty"`
+	PartitionKey          string           `json:"partitionKey,omitempty"`
+	Format                string           `json:"format,omitempty"`
+	Compression           string           `json:"compression,omitempty"`
+	Retry                 config.Retry     `json:"retry"`
+	OnPoison              string           `json:"onPoison,omitempty"`
+	RateLimit             config.RateLimit `json:"rateLimit"`
+	Schemas               []string         `json:"schemas,omitempty"`
+	ExcludeSchemas        []string         `json:"excludeSchemas,omitempty"`
+	Receives              string           `json:"receives,omitempty"`
+	InvalidSchemas        []string         `json:"invalidSchemas,omitempty"`
+	InvalidExcludeSchemas []string         `json:"invalidExcludeSchemas,omitempty"`
 }
 
 func NewSinkMetadataFromConfig(conf config.Sink) SinkMetadata {
 	return SinkMetadata{
-		Id:               uuid.New(),
-		SinkType:         conf.Type,
-		Name:             conf.Name,
-		DeliveryRequired: conf.DeliveryRequired,
-		DefaultOutput:    conf.DefaultOutput,
-		DeadletterOutput: conf.DeadletterOutput,
+		Id:                    uuid.New(),
+		SinkType:              conf.Type,
+		Name:                  conf.Name,
+		DeliveryRequired:      conf.DeliveryRequired,
+		DefaultOutput:         conf.DefaultOutput,
+		DeadletterOutput:      conf.DeadletterOutput,
+		Workers:               conf.Workers,
+		BatchSize:             conf.BatchSize,
+		LingerMs:              conf.LingerMs,
+		IdleMs:                conf.IdleMs,
+		Filter:                conf.Filter,
+		PartitionKey:          conf.PartitionKey,
+		Format:                conf.Format,
+		Compression:           conf.Compression,
+		Retry:                 conf.Retry,
+		OnPoison:              conf.OnPoison,
+		RateLimit:             conf.RateLimit,
+		Schemas:               conf.Schemas,
+		ExcludeSchemas:        conf.ExcludeSchemas,
+		Receives:              conf.Receives,
+		InvalidSchemas:        conf.InvalidSchemas,
+		InvalidExcludeSchemas: conf.InvalidExcludeSchemas,
 	}
 }
 
@@ -44,48 +91,430 @@ type Sink interface {
 	Shutdown() error
 }
 
-func publish(ctx context.Context, sink Sink, envelopes []envelope.Envelope, output string) error {
-	if len(envelopes) > 0 {
-		err := sink.Dequeue(ctx, envelopes, output)
+// HealthChecker is implemented by sinks which are able to verify their
+// connectivity and auth, for deep health checks
+type HealthChecker interface {
+	Check(ctx context.Context) error
+}
+
+// DeadLetterWriter receives envelopes which a sink failed to deliver
+type DeadLetterWriter interface {
+	WriteFailed(sink SinkMetadata, output string, envelopes []envelope.Envelope, err error) error
+}
+
+var deadLetterWriter DeadLetterWriter
+
+// SetDeadLetterWriter sets the writer of envelopes which sinks fail to
+// deliver. It must be set before sink workers are started.
+func SetDeadLetterWriter(w DeadLetterWriter) {
+	deadLetterWriter = w
+}
+
+// FailureListener is told of envelopes which a sink failed to deliver
+type FailureListener func(sink SinkMetadata, envelopes []envelope.Envelope, err error)
+
+var failureListeners []FailureListener
+
+// AddFailureListener adds a listener of sink failures. Listeners must be
+// added before sink workers are started.
+func AddFailureListener(l FailureListener) {
+	failureListeners = append(failureListeners, l)
+}
+
+// failed counts envelopes which the sink failed to deliver
+func failed(sink SinkMetadata, envelopes []envelope.Envelope, err error) {
+	sinkStats.Failed(sink.Name, len(envelopes))
+	for _, l := range failureListeners {
+		l(sink, envelopes, err)
+	}
+}
+
+var sinkStats = stats.NewSinkStats()
+
+// Stats returns the stats of every sink
+func Stats() *stats.SinkStats {
+	return sinkStats
+}
+
+// attempt delivers the envelopes, retrying them according to the sink's
+// retry policy. Sinks which report the envelopes they failed to deliver
+// only have those retried, and the failures are returned as
+// EnvelopeErrors. Sinks which panic fail the delivery permanently.
+func attempt(ctx context.Context, sink Sink, envelopes []envelope.Envelope, output string) (err error) {
+	start := time.Now()
+	limiter := rateLimiterFor(sink.Metadata())
+	// The envelopes still to be delivered, and their index in the batch
+	pending, indexes := envelopes, make([]int, len(envelopes))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	dequeue := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = Permanent(fmt.Errorf("sink panicked: %v", r))
+			}
+		}()
+		// Retries count against the rate limit too
+		throttled, err := limiter.wait(ctx, pending)
 		if err != nil {
-			log.Error().Err(err).Interface("metadata", sink.Metadata()).Msg("could not dequeue envelopes to output " + output)
+			return err
+		}
+		if throttled > 0 {
+			sinkStats.Throttled(sink.Metadata().Name, throttled)
 		}
+		return sink.Dequeue(ctx, pending, output)
+	}
+	attempts, err := newRetryPolicy(sink.Metadata().Retry).do(ctx, func() error {
+		err := dequeue()
+		var failures EnvelopeErrors
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &failures):
+		case len(pending) == len(envelopes):
+			return err
+		default:
+			// An earlier attempt delivered the others
+			failures = make(EnvelopeErrors, len(pending))
+			for i := range pending {
+				failures[i] = err
+			}
+		}
+		batchFailures := make(EnvelopeErrors, len(failures))
+		var failed []envelope.Envelope
+		var failedIndexes []int
+		for i, e := range pending {
+			if failure, ok := failures[i]; ok {
+				batchFailures[indexes[i]] = failure
+				failed = append(failed, e)
+				failedIndexes = append(failedIndexes, indexes[i])
+			}
+		}
+		pending, indexes = failed, failedIndexes
+		if len(batchFailures) == 0 {
+			return nil
+		}
+		return batchFailures
+	})
+	if attempts > 1 {
+		sinkStats.Retried(sink.Metadata().Name, attempts-1)
+	}
+	delivered := len(envelopes) - len(pending)
+	if err == nil {
+		delivered = len(envelopes)
+	}
+	if delivered > 0 {
+		sinkStats.Delivered(sink.Metadata().Name, delivered, time.Since(start))
+	}
+	if err == nil {
+		return nil
+	}
+	log.Error().Err(err).Int("attempts", attempts).Interface("metadata", sink.Metadata()).Msg("could not dequeue envelopes to output " + output)
+	return err
+}
+
+// deadLetter writes envelopes which couldn't be delivered to the dead
+// letter queue, if there is one. The error is returned unless they were
+// written, as the envelopes are otherwise lost.
+func deadLetter(sink Sink, output string, envelopes []envelope.Envelope, err error) error {
+	failed(sink.Metadata(), envelopes, err)
+	if deadLetterWriter == nil {
+		return err
+	}
+	if dlqErr := deadLetterWriter.WriteFailed(sink.Metadata(), output, envelopes, err); dlqErr != nil {
+		log.Error().Err(dlqErr).Interface("metadata", sink.Metadata()).Msg("🔴 could not write envelopes to dead letter queue")
+		return dlqErr
 	}
 	return nil
 }
 
+func publish(ctx context.Context, sink Sink, envelopes []envelope.Envelope, output string) error {
+	if len(envelopes) == 0 {
+		return nil
+	}
+	err := attempt(ctx, sink, envelopes, output)
+	if err == nil {
+		return nil
+	}
+	var failures EnvelopeErrors
+	if errors.As(err, &failures) {
+		// The sink delivered the others, so only the failed envelopes are handled
+		poisoned, err := isolate(sink, envelopes, output, failures)
+		if qErr := quarantine(ctx, sink, poisoned, output); qErr != nil {
+			return qErr
+		}
+		return err
+	}
+	policy := newRetryPolicy(sink.Metadata().Retry)
+	if policy.retryable(err) || sink.Metadata().OnPoison == DEADLETTER {
+		return deadLetter(sink, output, envelopes, err)
+	}
+	// The sink didn't report which envelopes it couldn't deliver, and it
+	// may have delivered some of them, so the batch isn't sent again to
+	// find out. The whole batch is poison.
+	return quarantine(ctx, sink, poisonAll(envelopes, err), output)
+}
+
+// sinkFilter returns the compiled filter of the sink, if it has one
+func sinkFilter(metadata SinkMetadata) *expression.Expression {
+	if metadata.Filter == "" {
+		return nil
+	}
+	filter, err := expression.Cached(metadata.Filter)
+	if err != nil {
+		// Filters are compiled when sinks are built, so this is unexpected
+		log.Error().Err(err).Interface("metadata", metadata).Msg("🔴 could not compile sink filter")
+		return nil
+	}
+	return filter
+}
+
+// matches returns true if the envelope should be delivered to the sink.
+// Envelopes which the filter fails to evaluate are not delivered.
+func matches(filter *expression.Expression, metadata SinkMetadata, e envelope.Envelope) bool {
+	matched, err := filter.Match(e)
+	if err != nil {
+		log.Debug().Err(err).Str("sink", metadata.Name).Str("schema", e.Schema).Msg("🟡 could not evaluate sink filter")
+		return false
+	}
+	return matched
+}
+
+func hasPrefix(schema string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(schema, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Envelopes received by sinks
+const (
+	ALL     string = "all"
+	VALID   string = "valid"
+	INVALID string = "invalid"
+)
+
+// ValidateReceives returns an error if the envelopes received by a sink
+// are unsupported
+func ValidateReceives(receives string) error {
+	switch receives {
+	case ALL, VALID, INVALID, "":
+		return nil
+	}
+	return errors.New("unsupported sink receives: " + receives)
+}
+
+func routedTo(schemas []string, excludeSchemas []string, schema string) bool {
+	if hasPrefix(schema, excludeSchemas) {
+		return false
+	}
+	return len(schemas) == 0 || hasPrefix(schema, schemas)
+}
+
+// routed returns true if the schema is routed to the sink
+func routed(metadata SinkMetadata, schema string) bool {
+	return routedTo(metadata.Schemas, metadata.ExcludeSchemas, schema)
+}
+
+// receives returns true if the envelope is routed to the sink, by its
+// validity and schema. Invalid envelopes can be routed by their own
+// schema prefixes, so they are triaged separately. Erasure requests are
+// received by every sink.
+func receives(metadata SinkMetadata, e envelope.Envelope) bool {
+	switch {
+	case e.IsErasureRequest():
+		// Every sink may hold data of the subject
+		return true
+	case metadata.Receives == VALID && !e.IsValid:
+		return false
+	case metadata.Receives == INVALID && e.IsValid:
+		return false
+	case !e.IsValid && (len(metadata.InvalidSchemas) > 0 || len(metadata.InvalidExcludeSchemas) > 0):
+		return routedTo(metadata.InvalidSchemas, metadata.InvalidExcludeSchemas, e.Schema)
+	}
+	return routed(metadata, e.Schema)
+}
+
+// Templated returns true if the output is rendered per tenant or
+// retention class, so it can't be verified or created when the sink is
+// initialized
+func Templated(output string) bool {
+	return strings.Contains(output, TENANT_PLACEHOLDER) || strings.Contains(output, RETENTION_PLACEHOLDER)
+}
+
+// RenderOutput returns the output of the envelope. Envelopes without a
+// tenant or retention class are delivered to the unknown output.
+func RenderOutput(output string, e envelope.Envelope) string {
+	if !Templated(output) {
+		return output
+	}
+	tenant := e.Tenant()
+	if tenant == "" {
+		tenant = constants.UNKNOWN
+	}
+	retention := e.Retention()
+	if retention == "" {
+		retention = constants.UNKNOWN
+	}
+	return strings.NewReplacer(TENANT_PLACEHOLDER, tenant, RETENTION_PLACEHOLDER, retention).Replace(output)
+}
+
+// shard groups the envelopes by their rendered output, in the order
+// each output is first seen
+func shard(output string, envelopes []envelope.Envelope) ([]string, map[string][]envelope.Envelope) {
+	if !Templated(output) {
+		return []string{output}, map[string][]envelope.Envelope{output: envelopes}
+	}
+	var outputs []string
+	sharded := make(map[string][]envelope.Envelope)
+	for _, e := range envelopes {
+		rendered := RenderOutput(output, e)
+		if _, ok := sharded[rendered]; !ok {
+			outputs = append(outputs, rendered)
+		}
+		sharded[rendered] = append(sharded[rendered], e)
+	}
+	return outputs, sharded
+}
+
+// route returns the envelopes the sink receives, split by validity
+func route(sink Sink, envelopes []envelope.Envelope) (valid []envelope.Envelope, invalid []envelope.Envelope) {
+	metadata := sink.Metadata()
+	filter := sinkFilter(metadata)
+	for _, envelope := range envelopes {
+		if !receives(metadata, envelope) {
+			continue
+		}
+		if filter != nil && !envelope.IsErasureRequest() && !matches(filter, metadata, envelope) {
+			continue
+		}
+		if envelope.IsValid {
+			valid = append(valid, envelope)
+		} else {
+			invalid = append(invalid, envelope)
+		}
+	}
+	return valid, invalid
+}
+
+// Deliver publishes valid envelopes to the default output of the sink
+// and invalid envelopes to its deadletter output. An error is returned if
+// some envelopes were neither delivered, dead-lettered, nor dropped by
+// the poison policy.
+func Deliver(ctx context.Context, sink Sink, envelopes []envelope.Envelope) (err error) {
+	// Just handle valid/invalid for now. This will be where events will be further sharded going forward.
+	validEnvelopes, invalidEnvelopes := route(sink, envelopes)
+	metadata := sink.Metadata()
+	// Send good events along
+	outputs, sharded := shard(metadata.DefaultOutput, validEnvelopes)
+	for _, output := range outputs {
+		if pErr := publish(ctx, sink, sharded[output], output); pErr != nil {
+			log.Error().Err(pErr).Interface("metadata", sink.Metadata()).Msg("could not publish envelopes to sink")
+			err = pErr
+		}
+	}
+	// Send bad events to deadletter
+	outputs, sharded = shard(metadata.DeadletterOutput, invalidEnvelopes)
+	for _, output := range outputs {
+		if pErr := publish(ctx, sink, sharded[output], output); pErr != nil {
+			log.Error().Err(pErr).Interface("metadata", sink.Metadata()).Msg("could not publish envelopes to sink")
+			err = pErr
+		}
+	}
+	return err
+}
+
+// DeadLetter writes the envelopes which the sink would have delivered to
+// the dead letter queue, by the outputs it would have delivered them to,
+// so they can be replayed to it. An error is returned unless every
+// envelope was written.
+func DeadLetter(sink Sink, envelopes []envelope.Envelope, err error) error {
+	validEnvelopes, invalidEnvelopes := route(sink, envelopes)
+	metadata := sink.Metadata()
+	var dlqErr error
+	write := func(output string, envelopes []envelope.Envelope) {
+		outputs, sharded := shard(output, envelopes)
+		for _, o := range outputs {
+			if len(sharded[o]) == 0 {
+				continue
+			}
+			if wErr := deadLetter(sink, o, sharded[o], err); wErr != nil {
+				dlqErr = wErr
+			}
+		}
+	}
+	write(metadata.DefaultOutput, validEnvelopes)
+	write(metadata.DeadletterOutput, invalidEnvelopes)
+	return dlqErr
+}
+
+// ErrDrainTimeout is returned when a sink worker doesn't deliver its
+// queued envelopes before the deadline
+var ErrDrainTimeout = errors.New("sink did not drain before the deadline")
+
+// worker accepts drain requests, which it replies to once the envelopes
+// queued for its sink have been delivered
+type worker struct {
+	drain chan chan struct{}
+}
+
+var workers sync.Map
+
 // Each sink runs an associated worker goroutine, which is responsible
 // for dequeuing envelopes.
 func StartSinkWorker(input <-chan []envelope.Envelope, shutdown <-chan int, sink Sink) error {
+	w := &worker{drain: make(chan chan struct{})}
+	id := sink.Metadata().Id
+	workers.Store(id, w)
+	sinkStats.TrackQueue(sink.Metadata().Name, func() int { return len(input) }, cap(input))
 	go func(input <-chan []envelope.Envelope, shutdown <-chan int, sink Sink) {
 		for {
 			select {
 			case envelopes := <-input:
-				// Just handle valid/invalid for now. This will be where events will be further sharded going forward.
-				var invalidEnvelopes []envelope.Envelope
-				var validEnvelopes []envelope.Envelope
-				for _, envelope := range envelopes {
-					if envelope.IsValid {
-						validEnvelopes = append(validEnvelopes, envelope)
-					} else {
-						invalidEnvelopes = append(invalidEnvelopes, envelope)
+				Deliver(context.Background(), sink, envelopes)
+			case drained := <-w.drain:
+				for queued := true; queued; {
+					select {
+					case envelopes := <-input:
+						Deliver(context.Background(), sink, envelopes)
+					default:
+						queued = false
 					}
 				}
-				ctx := context.Background()
-				// Send good events along
-				err := publish(ctx, sink, validEnvelopes, sink.Metadata().DefaultOutput)
-				if err != nil {
-					log.Error().Err(err).Interface("metadata", sink.Metadata()).Msg("could not publish envelopes to sink")
-				}
-				// Send bad events to deadletter
-				err = publish(ctx, sink, invalidEnvelopes, sink.Metadata().DeadletterOutput)
-				if err != nil {
-					log.Error().Err(err).Interface("metadata", sink.Metadata()).Msg("could not publish envelopes to sink")
-				}
+				close(drained)
 			case <-shutdown:
+				workers.Delete(id)
 				return
 			}
 		}
 	}(input, shutdown, sink)
 	return nil
 }
+
+// Drain waits until the envelopes queued for the sink have been
+// delivered, so they aren't lost when it is shut down. Envelopes must no
+// longer be enqueued to the sink.
+func Drain(sink Sink, timeout time.Duration) error {
+	v, ok := workers.Load(sink.Metadata().Id)
+	if !ok {
+		// The sink doesn't queue envelopes with a worker
+		return nil
+	}
+	w := v.(*worker)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	drained := make(chan struct{})
+	select {
+	case w.drain <- drained:
+	case <-timer.C:
+		return ErrDrainTimeout
+	}
+	select {
+	case <-drained:
+		return nil
+	case <-timer.C:
+		return ErrDrainTimeout
+	}
+}
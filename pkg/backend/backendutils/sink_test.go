@@ -0,0 +1,249 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package backendutils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingSink struct{}
+
+func (s *failingSink) Metadata() SinkMetadata {
+	return SinkMetadata{Name: "failing", DefaultOutput: "valid", DeadletterOutput: "invalid"}
+}
+func (s *failingSink) Initialize(conf config.Sink) error           { return nil }
+func (s *failingSink) StartWorker() error                          { return nil }
+func (s *failingSink) Enqueue(envelopes []envelope.Envelope) error { return nil }
+func (s *failingSink) Shutdown() error                             { return nil }
+func (s *failingSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	return errors.New("unavailable")
+}
+
+type recordingDeadLetterWriter struct {
+	outputs map[string]int
+}
+
+func (w *recordingDeadLetterWriter) WriteFailed(sink SinkMetadata, output string, envelopes []envelope.Envelope, err error) error {
+	w.outputs[output] += len(envelopes)
+	return nil
+}
+
+func TestDeliverWritesFailuresToDeadLetterWriter(t *testing.T) {
+	w := &recordingDeadLetterWriter{outputs: make(map[string]int)}
+	SetDeadLetterWriter(w)
+	defer SetDeadLetterWriter(nil)
+	assert.Nil(t, Deliver(context.Background(), &failingSink{}, []envelope.Envelope{{IsValid: true}, {IsValid: true}, {IsValid: false}}))
+	assert.Equal(t, map[string]int{"valid": 2, "invalid": 1}, w.outputs)
+}
+
+type failingDeadLetterWriter struct{}
+
+func (w failingDeadLetterWriter) WriteFailed(sink SinkMetadata, output string, envelopes []envelope.Envelope, err error) error {
+	return errors.New("dead letter queue unavailable")
+}
+
+func TestDeliverReturnsUnhandledFailures(t *testing.T) {
+	// Without a dead letter queue, the envelopes would be lost
+	assert.NotNil(t, Deliver(context.Background(), &failingSink{}, []envelope.Envelope{{IsValid: true}}))
+
+	SetDeadLetterWriter(failingDeadLetterWriter{})
+	defer SetDeadLetterWriter(nil)
+	assert.NotNil(t, Deliver(context.Background(), &failingSink{}, []envelope.Envelope{{IsValid: true}}))
+}
+
+func TestDeadLetterRoutesByOutput(t *testing.T) {
+	envelopes := []envelope.Envelope{{IsValid: true}, {IsValid: false}, {IsValid: true}}
+	assert.NotNil(t, DeadLetter(&failingSink{}, envelopes, errors.New("overloaded")))
+
+	w := &recordingDeadLetterWriter{outputs: make(map[string]int)}
+	SetDeadLetterWriter(w)
+	defer SetDeadLetterWriter(nil)
+	assert.Nil(t, DeadLetter(&failingSink{}, envelopes, errors.New("overloaded")))
+	assert.Equal(t, map[string]int{"valid": 2, "invalid": 1}, w.outputs)
+}
+
+type filteredSink struct {
+	failingSink
+	delivered int
+}
+
+func (s *filteredSink) Metadata() SinkMetadata {
+	return SinkMetadata{Name: "filtered", DefaultOutput: "valid", Filter: `schema.startsWith("com.acme/")`}
+}
+
+func (s *filteredSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	s.delivered += len(envelopes)
+	return nil
+}
+
+func TestDeliverAppliesSinkFilter(t *testing.T) {
+	s := &filteredSink{}
+	Deliver(context.Background(), s, []envelope.Envelope{
+		{Schema: "com.acme/page/v1.0.json", IsValid: true},
+		{Schema: "io.silverton/page/v1.0.json", IsValid: true},
+	})
+	assert.Equal(t, 1, s.delivered)
+}
+
+func TestRouted(t *testing.T) {
+	webOnly := SinkMetadata{Schemas: []string{"com.acme/web/"}}
+	noBilling := SinkMetadata{ExcludeSchemas: []string{"com.acme/billing/"}}
+	var testCases = []struct {
+		metadata SinkMetadata
+		schema   string
+		want     bool
+	}{
+		{SinkMetadata{}, "com.acme/billing/invoice/v1.0.json", true},
+		{webOnly, "com.acme/web/page/v1.0.json", true},
+		{webOnly, "com.acme/billing/invoice/v1.0.json", false},
+		{noBilling, "com.acme/web/page/v1.0.json", true},
+		{noBilling, "com.acme/billing/invoice/v1.0.json", false},
+		{SinkMetadata{Schemas: []string{"com.acme/"}, ExcludeSchemas: []string{"com.acme/billing/"}}, "com.acme/billing/invoice/v1.0.json", false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, routed(tc.metadata, tc.schema), tc.schema)
+	}
+}
+
+type tenantSink struct {
+	failingSink
+	outputs map[string]int
+}
+
+func (s *tenantSink) Metadata() SinkMetadata {
+	return SinkMetadata{Name: "tenant", DefaultOutput: "events-{{tenant}}", DeadletterOutput: "invalid"}
+}
+
+func (s *tenantSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	s.outputs[output] += len(envelopes)
+	return nil
+}
+
+func TestDeliverRendersTenantOutputs(t *testing.T) {
+	stamped := func(tenant string, valid bool) envelope.Envelope {
+		contexts := envelope.Contexts{envelope.TENANT_CONTEXT: map[string]interface{}{"tenant": tenant}}
+		return envelope.Envelope{IsValid: valid, Contexts: &contexts}
+	}
+	s := &tenantSink{outputs: make(map[string]int)}
+	Deliver(context.Background(), s, []envelope.Envelope{
+		stamped("acme", true),
+		stamped("globex", true),
+		stamped("acme", true),
+		stamped("acme", false),
+		{IsValid: true},
+	})
+	assert.Equal(t, map[string]int{"events-acme": 2, "events-globex": 1, "events-unknown": 1, "invalid": 1}, s.outputs)
+}
+
+func TestRenderOutputRetention(t *testing.T) {
+	contexts := envelope.Contexts{
+		envelope.TENANT_CONTEXT:    map[string]interface{}{"tenant": "acme"},
+		envelope.RETENTION_CONTEXT: map[string]interface{}{"class": "30d"},
+	}
+	assert.Equal(t, "events-acme-30d", RenderOutput("events-{{tenant}}-{{retention}}", envelope.Envelope{Contexts: &contexts}))
+	assert.Equal(t, "events-unknown", RenderOutput("events-{{retention}}", envelope.Envelope{}))
+	assert.True(t, Templated("events-{{retention}}"))
+}
+
+type slowSink struct {
+	failingSink
+	id        uuid.UUID
+	delay     time.Duration
+	input     chan []envelope.Envelope
+	shutdown  chan int
+	mu        sync.Mutex
+	delivered int
+}
+
+func newSlowSink(delay time.Duration) *slowSink {
+	s := &slowSink{id: uuid.New(), delay: delay, input: make(chan []envelope.Envelope, 10), shutdown: make(chan int, 1)}
+	_ = StartSinkWorker(s.input, s.shutdown, s)
+	return s
+}
+
+func (s *slowSink) Metadata() SinkMetadata {
+	return SinkMetadata{Id: s.id, Name: "slow", DefaultOutput: "valid"}
+}
+
+func (s *slowSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered += len(envelopes)
+	return nil
+}
+
+func TestDrain(t *testing.T) {
+	s := newSlowSink(5 * time.Millisecond)
+	defer func() { s.shutdown <- 1 }()
+	for i := 0; i < 5; i++ {
+		s.input <- []envelope.Envelope{{IsValid: true}, {IsValid: true}}
+	}
+	assert.Nil(t, Drain(s, time.Second))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(t, 10, s.delivered)
+}
+
+func TestDrainTimeout(t *testing.T) {
+	s := newSlowSink(50 * time.Millisecond)
+	defer func() { s.shutdown <- 1 }()
+	for i := 0; i < 5; i++ {
+		s.input <- []envelope.Envelope{{IsValid: true}}
+	}
+	assert.ErrorIs(t, Drain(s, 20*time.Millisecond), ErrDrainTimeout)
+}
+
+func TestDrainWithoutWorker(t *testing.T) {
+	assert.Nil(t, Drain(&failingSink{}, time.Millisecond))
+}
+
+func TestReceives(t *testing.T) {
+	checkout := envelope.Envelope{Schema: "com.acme/checkout/order/v1.0.json"}
+	page := envelope.Envelope{Schema: "com.acme/web/page/v1.0.json"}
+	invalid := func(e envelope.Envelope) envelope.Envelope {
+		e.IsValid = false
+		return e
+	}
+	valid := func(e envelope.Envelope) envelope.Envelope {
+		e.IsValid = true
+		return e
+	}
+	warehouse := SinkMetadata{Receives: VALID}
+	triage := SinkMetadata{Receives: INVALID, Schemas: []string{"com.acme/checkout/"}}
+	bulk := SinkMetadata{Receives: INVALID, ExcludeSchemas: []string{"com.acme/checkout/"}}
+	everything := SinkMetadata{InvalidSchemas: []string{"com.acme/web/"}}
+	var testCases = []struct {
+		name     string
+		metadata SinkMetadata
+		envelope envelope.Envelope
+		want     bool
+	}{
+		{"warehouse valid", warehouse, valid(checkout), true},
+		{"warehouse invalid", warehouse, invalid(checkout), false},
+		{"triage checkout", triage, invalid(checkout), true},
+		{"triage page", triage, invalid(page), false},
+		{"triage valid", triage, valid(checkout), false},
+		{"bulk checkout", bulk, invalid(checkout), false},
+		{"bulk page", bulk, invalid(page), true},
+		{"everything valid checkout", everything, valid(checkout), true},
+		{"everything invalid checkout", everything, invalid(checkout), false},
+		{"everything invalid page", everything, invalid(page), true},
+		{"triage erasure", triage, valid(envelope.Envelope{Schema: envelope.ERASURE_REQUEST_SCHEMA}), true},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, receives(tc.metadata, tc.envelope), tc.name)
+	}
+	assert.NotNil(t, ValidateReceives("some"))
+}
@@ -5,7 +5,10 @@
 package clickhousedb
 
 import (
+	"errors"
+
 	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/db"
 	"gorm.io/driver/clickhouse"
@@ -38,8 +41,10 @@ func (b *RegistryBackend) Initialize(conf config.Backend) error {
 
 func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error) {
 	var s db.RegistryTable
-	b.gormDb.Table(b.registryTable).Where("name = ?", schema).First(&s)
-	err = b.gormDb.Error
+	err = b.gormDb.Table(b.registryTable).Where("name = ?", schema).First(&s).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, backendutils.SchemaNotFound(schema, err)
+	}
 	if err != nil {
 		return nil, err
 	}
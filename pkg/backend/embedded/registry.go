@@ -0,0 +1,51 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package embedded
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/schemas"
+)
+
+// RegistryBackend serves schemas compiled into the buz binary
+type RegistryBackend struct{}
+
+func (b *RegistryBackend) Initialize(conf config.Backend) error {
+	log.Debug().Msg("🟡 initializing embedded registry backend")
+	// No-op
+	return nil
+}
+
+func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error) {
+	content, err := fs.ReadFile(schemas.Embedded, path.Clean(strings.TrimPrefix(schema, "/")))
+	if err != nil {
+		log.Debug().Err(err).Msg("🟡 schema not embedded: " + schema)
+		return nil, err
+	}
+	return content, nil
+}
+
+func (b *RegistryBackend) ListRemote() (schemaNames []string, err error) {
+	err = fs.WalkDir(schemas.Embedded, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(p, ".json") {
+			schemaNames = append(schemaNames, p)
+		}
+		return nil
+	})
+	return schemaNames, err
+}
+
+func (b *RegistryBackend) Close() {
+	log.Debug().Msg("🟡 closing embedded registry backend")
+	// No-op
+}
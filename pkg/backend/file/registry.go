@@ -5,21 +5,26 @@
 package file
 
 import (
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
 )
 
 type RegistryBackend struct {
-	path string
+	path    string
+	watch   bool
+	watcher *fsnotify.Watcher
 }
 
 func (b *RegistryBackend) Initialize(conf config.Backend) error {
 	log.Debug().Msg("🟡 initializing filesystem registry backend")
 	b.path = conf.Path
-	// No-op
+	b.watch = conf.Watch
 	return nil
 }
 
@@ -33,7 +38,79 @@ func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error)
 	return content, nil
 }
 
+// Watch calls onChange with the name of every schema which is created,
+// modified, or removed beneath the registry path. It is a no-op unless
+// watching is enabled.
+func (b *RegistryBackend) Watch(onChange func(schema string)) error {
+	if !b.watch {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not create filesystem watcher")
+		return err
+	}
+	b.watcher = watcher
+	// fsnotify does not watch recursively, so every directory is added
+	if err := b.addDirs(b.path); err != nil {
+		watcher.Close()
+		return err
+	}
+	log.Info().Msg("🟢 watching " + b.path + " for schema changes")
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				b.handle(event, onChange)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error().Err(err).Msg("🔴 filesystem watcher error")
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *RegistryBackend) addDirs(root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return b.watcher.Add(p)
+		}
+		return nil
+	})
+}
+
+func (b *RegistryBackend) handle(event fsnotify.Event, onChange func(schema string)) {
+	if event.Op&fsnotify.Create == fsnotify.Create {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := b.addDirs(event.Name); err != nil {
+				log.Error().Err(err).Msg("🔴 could not watch " + event.Name)
+			}
+			return
+		}
+	}
+	if event.Op == fsnotify.Chmod || !strings.HasSuffix(event.Name, ".json") {
+		return
+	}
+	schema, err := filepath.Rel(b.path, event.Name)
+	if err != nil {
+		return
+	}
+	log.Debug().Msg("🟡 schema changed on filesystem: " + schema)
+	onChange(filepath.ToSlash(schema))
+}
+
 func (b *RegistryBackend) Close() {
 	log.Debug().Msg("🟡 closing filesystem registry backend")
-	// No-op
+	if b.watcher != nil {
+		b.watcher.Close()
+	}
 }
@@ -0,0 +1,47 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "com.acme", "event"), 0755)
+	schema := filepath.Join(dir, "com.acme", "event", "v1.0.json")
+	os.WriteFile(schema, []byte(`{"type": "object"}`), 0644)
+
+	b := RegistryBackend{}
+	b.Initialize(config.Backend{Path: dir, Watch: true})
+	defer b.Close()
+	changed := make(chan string, 10)
+	err := b.Watch(func(s string) { changed <- s })
+	assert.Nil(t, err)
+
+	os.WriteFile(schema, []byte(`{"type": "string"}`), 0644)
+	select {
+	case s := <-changed:
+		assert.Equal(t, "com.acme/event/v1.0.json", s)
+	case <-time.After(5 * time.Second):
+		t.Fatal("schema change was not observed")
+	}
+	contents, err := b.GetRemote("com.acme/event/v1.0.json")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"type": "string"}`, string(contents))
+}
+
+func TestWatchDisabled(t *testing.T) {
+	b := RegistryBackend{}
+	b.Initialize(config.Backend{Path: t.TempDir()})
+	assert.Nil(t, b.Watch(func(s string) {}))
+	assert.Nil(t, b.watcher)
+}
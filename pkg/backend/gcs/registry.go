@@ -6,48 +6,123 @@ package gcs
 
 import (
 	"context"
+	"errors"
 	"io"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"cloud.google.com/go/storage"
 	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
 	"github.com/silverton-io/buz/pkg/config"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 )
 
 type RegistryBackend struct {
 	bucket string
 	path   string
 	client *storage.Client
+	// Optional local copies of schemas, refreshed when the object generation changes
+	objectCache *backendutils.ObjectCache
 }
 
 func (b *RegistryBackend) Initialize(config config.Backend) error {
 	ctx := context.Background()
 	log.Debug().Msg("🟡 initializing gcs schema cache backend")
-	client, err := storage.NewClient(ctx)
+	// Application default credentials are used unless a credentials file is
+	// explicitly specified. This includes workload identity when running on GKE.
+	var opts []option.ClientOption
+	if config.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(config.CredentialsFile))
+	}
+	client, err := storage.NewClient(ctx, opts...)
 	if err != nil {
 		log.Error().Err(err).Msg("🔴 could not initialize gcs schema cache backend")
 		return err
 	}
 	b.client, b.bucket, b.path = client, config.Bucket, config.Path
+	if config.LocalCache {
+		log.Debug().Msg("🟡 gcs schema cache backend local caching enabled")
+		b.objectCache = backendutils.NewObjectCache()
+	}
 	return nil
 }
 
+func (b *RegistryBackend) schemaLocation(schema string) string {
+	if b.path == "/" || b.path == "" {
+		return schema
+	}
+	return filepath.Join(b.path, schema)
+}
+
 func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error) {
 	ctx := context.Background()
-	var schemaLocation string
-	if b.path == "/" {
-		schemaLocation = schema
-	} else {
-		schemaLocation = filepath.Join(b.path, schema)
+	schemaLocation := b.schemaLocation(schema)
+	obj := b.client.Bucket(b.bucket).Object(schemaLocation)
+	var generation string
+	if b.objectCache != nil {
+		attrs, err := obj.Attrs(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, backendutils.SchemaNotFound(schema, err)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not get file attributes from gcs: " + schemaLocation)
+			return nil, err
+		}
+		generation = strconv.FormatInt(attrs.Generation, 10)
+		cached, isCached := b.objectCache.Get(schemaLocation)
+		if isCached && cached.Version == generation {
+			log.Debug().Msg("🟡 gcs object generation unchanged, using local copy of " + schemaLocation)
+			return cached.Contents, nil
+		}
+		// Pin the read to the generation that was just inspected
+		obj = obj.Generation(attrs.Generation)
 	}
 	log.Debug().Msg("🟡 getting file from gcs backend " + schemaLocation)
-	reader, err := b.client.Bucket(b.bucket).Object(schemaLocation).NewReader(ctx)
+	reader, err := obj.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, backendutils.SchemaNotFound(schema, err)
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("🔴 could not get file from gcs: " + schemaLocation)
 		return nil, err
 	}
-	data, _ := io.ReadAll(reader)
-	return data, nil
+	defer reader.Close()
+	contents, err = io.ReadAll(reader)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not read file from gcs: " + schemaLocation)
+		return nil, err
+	}
+	if b.objectCache != nil {
+		b.objectCache.Set(schemaLocation, generation, contents)
+	}
+	return contents, nil
+}
+
+// ListRemote lists all schemas present under the configured bucket prefix
+func (b *RegistryBackend) ListRemote() (schemas []string, err error) {
+	ctx := context.Background()
+	prefix := strings.TrimPrefix(b.path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	it := b.client.Bucket(b.bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not list objects in gcs bucket " + b.bucket)
+			return nil, err
+		}
+		if strings.HasSuffix(attrs.Name, ".json") {
+			schemas = append(schemas, strings.TrimPrefix(attrs.Name, prefix))
+		}
+	}
+	return schemas, nil
 }
 
 func (b *RegistryBackend) Close() {
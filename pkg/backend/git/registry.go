@@ -0,0 +1,172 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package git
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const DEFAULT_SYNC_INTERVAL_SECONDS int = 60
+
+// RegistryBackend clones a git repository and periodically pulls it,
+// serving schemas from the working tree. The git binary must be available
+// on the PATH of the collector.
+type RegistryBackend struct {
+	repo     string
+	ref      string
+	path     string
+	cloneDir string
+	env      []string
+	mu       sync.RWMutex
+	shutdown chan int
+}
+
+func (b *RegistryBackend) git(args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), b.env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Error().Err(err).Str("output", strings.TrimSpace(string(out))).Msg("🔴 git " + args[0] + " failed")
+		return err
+	}
+	return nil
+}
+
+func (b *RegistryBackend) clone() error {
+	args := []string{"clone", "--depth", "1"}
+	if b.ref != "" {
+		args = append(args, "--branch", b.ref)
+	}
+	args = append(args, b.repo, b.cloneDir)
+	return b.git(args...)
+}
+
+// sync fetches the configured ref and hard-resets the working tree to it.
+func (b *RegistryBackend) sync() error {
+	ref := b.ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if err := b.git("-C", b.cloneDir, "fetch", "--depth", "1", "origin", ref); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.git("-C", b.cloneDir, "reset", "--hard", "FETCH_HEAD")
+}
+
+func (b *RegistryBackend) Initialize(conf config.Backend) error {
+	log.Debug().Msg("🟡 initializing git registry backend")
+	if conf.GitRepo == "" {
+		err := errors.New("gitRepo must be set for the git registry backend")
+		log.Error().Err(err).Msg("🔴 could not initialize git registry backend")
+		return err
+	}
+	b.repo, b.ref, b.path = conf.GitRepo, conf.GitRef, conf.Path
+	if conf.GitSshKeyFile != "" {
+		// Deploy keys are used exclusively, and new hosts are trusted on first use.
+		b.env = append(b.env, "GIT_SSH_COMMAND=ssh -i "+conf.GitSshKeyFile+" -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new")
+	}
+	b.env = append(b.env, "GIT_TERMINAL_PROMPT=0")
+	cloneDir := conf.GitCloneDir
+	if cloneDir == "" {
+		dir, err := os.MkdirTemp("", "buz-registry-")
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not create git registry clone directory")
+			return err
+		}
+		cloneDir = dir
+	}
+	b.cloneDir = cloneDir
+	if _, err := os.Stat(filepath.Join(b.cloneDir, ".git")); err == nil {
+		log.Debug().Msg("🟡 git registry already cloned to " + b.cloneDir + " - syncing")
+		if err := b.sync(); err != nil {
+			return err
+		}
+	} else {
+		log.Debug().Msg("🟡 cloning git registry to " + b.cloneDir)
+		if err := b.clone(); err != nil {
+			return err
+		}
+	}
+	interval := conf.GitSyncIntervalSeconds
+	if interval <= 0 {
+		interval = DEFAULT_SYNC_INTERVAL_SECONDS
+	}
+	b.shutdown = make(chan int, 1)
+	go func(shutdown <-chan int) {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Debug().Msg("🟡 syncing git registry")
+				if err := b.sync(); err != nil {
+					log.Error().Err(err).Msg("🔴 could not sync git registry")
+				}
+			case <-shutdown:
+				return
+			}
+		}
+	}(b.shutdown)
+	return nil
+}
+
+func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error) {
+	schemaLocation := filepath.Join(b.cloneDir, b.path, schema)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	content, err := os.ReadFile(schemaLocation)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not get schema from git registry backend: " + schemaLocation)
+		return nil, err
+	}
+	return content, nil
+}
+
+// ListRemote lists all schemas present in the working tree
+func (b *RegistryBackend) ListRemote() (schemas []string, err error) {
+	root := filepath.Join(b.cloneDir, b.path)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && strings.HasSuffix(p, ".json") {
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			schemas = append(schemas, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not list schemas in git registry backend")
+		return nil, err
+	}
+	return schemas, nil
+}
+
+func (b *RegistryBackend) Close() {
+	log.Debug().Msg("🟡 closing git registry backend")
+	if b.shutdown != nil {
+		b.shutdown <- 1
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func run(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=buz", "GIT_AUTHOR_EMAIL=buz@buz.dev",
+		"GIT_COMMITTER_NAME=buz", "GIT_COMMITTER_EMAIL=buz@buz.dev",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v failed: %v %s", args, err, out)
+	}
+}
+
+func commitSchema(t *testing.T, repo string, contents string) {
+	schemaDir := filepath.Join(repo, "schemas", "io.silverton", "test")
+	os.MkdirAll(schemaDir, 0755)
+	os.WriteFile(filepath.Join(schemaDir, "v1.0.json"), []byte(contents), 0644)
+	run(t, repo, "add", "-A")
+	run(t, repo, "commit", "-q", "-m", "schema")
+}
+
+func TestRegistryBackend(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	repo := t.TempDir()
+	run(t, repo, "init", "-q", "-b", "main")
+	commitSchema(t, repo, `{"v":1}`)
+
+	b := RegistryBackend{}
+	err := b.Initialize(config.Backend{
+		GitRepo:                "file://" + repo,
+		GitRef:                 "main",
+		GitCloneDir:            filepath.Join(t.TempDir(), "clone"),
+		Path:                   "schemas",
+		GitSyncIntervalSeconds: 3600,
+	})
+	assert.Nil(t, err)
+	defer b.Close()
+
+	contents, err := b.GetRemote("io.silverton/test/v1.0.json")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(`{"v":1}`), contents)
+
+	schemas, err := b.ListRemote()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"io.silverton/test/v1.0.json"}, schemas)
+
+	commitSchema(t, repo, `{"v":2}`)
+	assert.Nil(t, b.sync())
+	contents, err = b.GetRemote("io.silverton/test/v1.0.json")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(`{"v":2}`), contents)
+
+	_, err = b.GetRemote("io.silverton/test/v9.0.json")
+	assert.NotNil(t, err)
+}
@@ -5,30 +5,104 @@
 package http
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
 	"net/url"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/request"
 )
 
+const DEFAULT_REGISTRY_TIMEOUT_MS int = 10000
+
 type RegistryBackend struct {
 	protocol string
 	host     string
 	path     string
+	header   http.Header
+	client   *http.Client
+}
+
+// buildTlsConfig loads the optional client certificate and ca bundle
+// used for mutual tls.
+func buildTlsConfig(conf config.Backend) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if conf.ClientCertFile != "" || conf.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.ClientCertFile, conf.ClientKeyFile)
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not load client certificate")
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if conf.CaCertFile != "" {
+		caCert, err := os.ReadFile(conf.CaCertFile)
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not read ca certificate")
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			err := errors.New("no certificates found in " + conf.CaCertFile)
+			log.Error().Err(err).Msg("🔴 could not parse ca certificate")
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
 }
 
 func (b *RegistryBackend) Initialize(conf config.Backend) error {
 	log.Debug().Msg("🟡 initializing http schema cache backend")
 	b.protocol = conf.Type
-	b.host = conf.Host // FIXME! String trailing / if it's present (or validate it upstream)
-	b.path = conf.Path // FIXME! Strip leading / if it's present (or validate it upstream)
+	b.host = strings.TrimSuffix(conf.Host, "/")
+	b.path = strings.Trim(conf.Path, "/")
+	b.header = http.Header{}
+	for k, v := range conf.Headers {
+		b.header.Set(k, v)
+	}
+	if conf.BearerToken != "" {
+		b.header.Set("Authorization", "Bearer "+conf.BearerToken)
+	}
+	tlsConfig, err := buildTlsConfig(conf)
+	if err != nil {
+		return err
+	}
+	timeoutMs := conf.TimeoutMs
+	if timeoutMs <= 0 {
+		timeoutMs = DEFAULT_REGISTRY_TIMEOUT_MS
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	b.client = &http.Client{
+		Timeout:   time.Duration(timeoutMs) * time.Millisecond,
+		Transport: transport,
+	}
 	return nil
 }
 
 func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error) {
-	schemaLocation, _ := url.Parse(b.protocol + "://" + b.host + "/" + b.path + "/" + schema) // FIXME!! There's gotta be a better way here.
-	content, err := request.Get(*schemaLocation)
+	location := b.protocol + "://" + b.host + "/"
+	if b.path != "" {
+		location = location + b.path + "/"
+	}
+	schemaLocation, err := url.Parse(location + schema)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not build schema url")
+		return nil, err
+	}
+	content, err := request.GetWithClient(b.client, *schemaLocation, b.header)
+	if errors.Is(err, request.ErrNotFound) {
+		log.Debug().Err(err).Msg("🟡 schema not found in http schema cache backend")
+		return nil, backendutils.SchemaNotFound(schema, err)
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("🔴 could not get schema from http schema cache backend")
 		return nil, err
@@ -38,5 +112,5 @@ func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error)
 
 func (b *RegistryBackend) Close() {
 	log.Debug().Msg("🟡 closing http schema cache backend")
-	// Knock off auth tokens? TBD
+	b.client.CloseIdleConnections()
 }
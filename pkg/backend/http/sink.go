@@ -6,6 +6,8 @@ package http
 
 import (
 	"context"
+	"encoding/json"
+	nethttp "net/http"
 	"net/url"
 
 	"github.com/rs/zerolog/log"
@@ -16,9 +18,10 @@ import (
 )
 
 type Sink struct {
-	metadata backendutils.SinkMetadata
-	input    chan []envelope.Envelope
-	shutdown chan int
+	metadata   backendutils.SinkMetadata
+	compressor *backendutils.Compressor
+	input      chan []envelope.Envelope
+	shutdown   chan int
 }
 
 func (s *Sink) Metadata() backendutils.SinkMetadata {
@@ -37,6 +40,12 @@ func (s *Sink) Initialize(conf config.Sink) error {
 		return err
 	}
 	s.metadata = backendutils.NewSinkMetadataFromConfig(conf)
+	compressor, err := backendutils.NewCompressor(conf.Compression)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not build compressor")
+		return err
+	}
+	s.compressor = compressor
 	s.input = make(chan []envelope.Envelope, 10000)
 	s.shutdown = make(chan int, 1)
 	return nil
@@ -60,7 +69,16 @@ func (s *Sink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, outpu
 		log.Error().Err(err).Msg("🔴 " + output + " is not a valid url")
 		return err
 	}
-	_, err = request.PostEnvelopes(*url, envelopes, nil)
+	body, err := json.Marshal(envelopes)
+	if err != nil {
+		return backendutils.Permanent(err)
+	}
+	header := nethttp.Header{}
+	if body, err = s.compressor.Body(body, header); err != nil {
+		log.Error().Err(err).Interface("metadata", s.Metadata()).Msg("🔴 could not compress payloads")
+		return err
+	}
+	_, err = request.PostBytes(*url, body, header)
 	if err != nil {
 		log.Error().Err(err).Interface("metadata", s.Metadata()).Msg("🔴 could not dequeue payloads")
 	}
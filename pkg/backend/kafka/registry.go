@@ -4,4 +4,147 @@
 
 package kafka
 
-// TODO! Implement me!!
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const (
+	// The full schema path (sans .json) is used as the subject.
+	// ie: `io.silverton/buz/example/gettingStarted/v1.0`
+	SUBJECT_STRATEGY_SCHEMA string = "schema"
+	// The schema path without the version is used as the subject and the
+	// latest registered version is always resolved.
+	// ie: `io.silverton/buz/example/gettingStarted`
+	SUBJECT_STRATEGY_NAMESPACE string = "namespace"
+	// The schema path is dot-delimited, in line with the Confluent
+	// RecordNameStrategy. ie: `io.silverton.buz.example.gettingStarted.v1.0`
+	SUBJECT_STRATEGY_RECORD string = "record"
+
+	LATEST_VERSION            string = "latest"
+	DEFAULT_REGISTRY_TIMEOUT  int    = 10
+	SCHEMA_REGISTRY_MEDIATYPE string = "application/vnd.schemaregistry.v1+json"
+)
+
+type subjectVersion struct {
+	Subject    string `json:"subject"`
+	Version    int    `json:"version"`
+	Id         int    `json:"id"`
+	SchemaType string `json:"schemaType"`
+	Schema     string `json:"schema"`
+}
+
+// RegistryBackend resolves json schemas from a Confluent Schema Registry
+type RegistryBackend struct {
+	registryUrl     *url.URL
+	subjectStrategy string
+	subjectSuffix   string
+	apiKey          string
+	apiSecret       string
+	client          *http.Client
+}
+
+// subjectFor maps a schema name to a schema registry subject according
+// to the configured subject naming strategy.
+func subjectFor(schema string, strategy string, suffix string) (subject string) {
+	s := strings.TrimSuffix(strings.TrimPrefix(schema, "/"), ".json")
+	switch strategy {
+	case SUBJECT_STRATEGY_NAMESPACE:
+		subject = path.Dir(s)
+	case SUBJECT_STRATEGY_RECORD:
+		subject = strings.ReplaceAll(s, "/", ".")
+	default:
+		subject = s
+	}
+	return subject + suffix
+}
+
+func (b *RegistryBackend) Initialize(conf config.Backend) error {
+	log.Debug().Msg("🟡 initializing confluent schema registry backend")
+	if conf.RegistryUrl == "" {
+		err := errors.New("registryUrl must be set for the confluent schema registry backend")
+		log.Error().Err(err).Msg("🔴 could not initialize confluent schema registry backend")
+		return err
+	}
+	registryUrl, err := url.Parse(conf.RegistryUrl)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not parse schema registry url")
+		return err
+	}
+	switch conf.SubjectNamingStrategy {
+	case "", SUBJECT_STRATEGY_SCHEMA:
+		b.subjectStrategy = SUBJECT_STRATEGY_SCHEMA
+	case SUBJECT_STRATEGY_NAMESPACE, SUBJECT_STRATEGY_RECORD:
+		b.subjectStrategy = conf.SubjectNamingStrategy
+	default:
+		err := errors.New("unsupported subject naming strategy: " + conf.SubjectNamingStrategy)
+		log.Error().Err(err).Msg("🔴 could not initialize confluent schema registry backend")
+		return err
+	}
+	b.registryUrl = registryUrl
+	b.subjectSuffix = conf.SubjectSuffix
+	b.apiKey, b.apiSecret = conf.ApiKey, conf.ApiSecret
+	b.client = &http.Client{Timeout: time.Duration(DEFAULT_REGISTRY_TIMEOUT) * time.Second}
+	return nil
+}
+
+func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error) {
+	subject := subjectFor(schema, b.subjectStrategy, b.subjectSuffix)
+	// Subjects may contain slashes, so they must be escaped as a single path segment
+	subjectLocation := strings.TrimSuffix(b.registryUrl.String(), "/") + "/subjects/" + url.PathEscape(subject) + "/versions/" + LATEST_VERSION
+	req, err := http.NewRequest(http.MethodGet, subjectLocation, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not build schema registry request")
+		return nil, err
+	}
+	req.Header.Set("Accept", SCHEMA_REGISTRY_MEDIATYPE)
+	if b.apiKey != "" {
+		req.SetBasicAuth(b.apiKey, b.apiSecret)
+	}
+	log.Debug().Msg("🟡 getting subject " + subject + " from confluent schema registry")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not get subject from confluent schema registry: " + subject)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not read schema registry response")
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, backendutils.SchemaNotFound(schema, errors.New("no subject "+subject))
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := errors.New("schema registry returned " + resp.Status + " for subject " + subject)
+		log.Error().Err(err).Msg("🔴 could not get subject from confluent schema registry")
+		return nil, err
+	}
+	var sv subjectVersion
+	if err := json.Unmarshal(body, &sv); err != nil {
+		log.Error().Err(err).Msg("🔴 could not unmarshal schema registry response")
+		return nil, err
+	}
+	if sv.SchemaType != "JSON" {
+		err := errors.New("subject " + subject + " is not a json schema")
+		log.Error().Err(err).Msg("🔴 unsupported schema type")
+		return nil, err
+	}
+	return []byte(sv.Schema), nil
+}
+
+func (b *RegistryBackend) Close() {
+	log.Debug().Msg("🟡 closing confluent schema registry backend")
+	b.client.CloseIdleConnections()
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package kafka
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubjectFor(t *testing.T) {
+	schema := "io.silverton/buz/example/gettingStarted/v1.0.json"
+	var testCases = []struct {
+		strategy string
+		suffix   string
+		want     string
+	}{
+		{SUBJECT_STRATEGY_SCHEMA, "", "io.silverton/buz/example/gettingStarted/v1.0"},
+		{SUBJECT_STRATEGY_NAMESPACE, "", "io.silverton/buz/example/gettingStarted"},
+		{SUBJECT_STRATEGY_RECORD, "-value", "io.silverton.buz.example.gettingStarted.v1.0-value"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.strategy, func(t *testing.T) {
+			assert.Equal(t, tc.want, subjectFor(schema, tc.strategy, tc.suffix))
+		})
+	}
+}
+
+func TestGetRemote(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "key" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "/subjects/io.silverton%2Fbuz%2Fexample%2FgettingStarted%2Fv1.0/versions/latest", r.URL.RawPath)
+		w.Write([]byte(`{"subject":"s","version":1,"id":1,"schemaType":"JSON","schema":"{\"type\":\"object\"}"}`))
+	}))
+	defer ts.Close()
+
+	b := RegistryBackend{}
+	err := b.Initialize(config.Backend{RegistryUrl: ts.URL, ApiKey: "key", ApiSecret: "secret"})
+	assert.Nil(t, err)
+	contents, err := b.GetRemote("io.silverton/buz/example/gettingStarted/v1.0.json")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(`{"type":"object"}`), contents)
+
+	b.apiSecret = "wrong"
+	_, err = b.GetRemote("io.silverton/buz/example/gettingStarted/v1.0.json")
+	assert.NotNil(t, err)
+}
@@ -5,7 +5,7 @@
 package kafka
 
 import (
-	"encoding/json"
+	"errors"
 	"strconv"
 	"sync"
 
@@ -23,11 +23,21 @@ const (
 	DEFAULT_REPLICATION_FACTOR int16 = 1 // NOTE! Really not a good default.
 )
 
+// Batches are compressed by the producer, so consumers decompress them
+// transparently
+var codecs = map[string]kgo.CompressionCodec{
+	backendutils.GZIP:   kgo.GzipCompression(),
+	backendutils.ZSTD:   kgo.ZstdCompression(),
+	backendutils.SNAPPY: kgo.SnappyCompression(),
+}
+
 type Sink struct {
-	metadata backendutils.SinkMetadata
-	client   *kgo.Client
-	input    chan []envelope.Envelope
-	shutdown chan int
+	metadata     backendutils.SinkMetadata
+	client       *kgo.Client
+	partitionKey *backendutils.PartitionKey
+	encoder      backendutils.Encoder
+	input        chan []envelope.Envelope
+	shutdown     chan int
 }
 
 func (s *Sink) Metadata() backendutils.SinkMetadata {
@@ -36,11 +46,31 @@ func (s *Sink) Metadata() backendutils.SinkMetadata {
 
 func (s *Sink) Initialize(conf config.Sink) error {
 	s.metadata = backendutils.NewSinkMetadataFromConfig(conf)
+	partitionKey, err := backendutils.NewPartitionKey(conf.PartitionKey)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not compile partition key")
+		return err
+	}
+	s.partitionKey = partitionKey
+	encoder, err := backendutils.NewEncoder(conf.Format)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not build encoder")
+		return err
+	}
+	s.encoder = encoder
 	ctx := context.Background()
+	opts := []kgo.Opt{kgo.SeedBrokers(conf.Brokers...)}
+	if conf.Compression != "" {
+		codec, ok := codecs[conf.Compression]
+		if !ok {
+			err := errors.New("unsupported kafka compression: " + conf.Compression)
+			log.Error().Err(err).Msg("🔴 could not build kafka client")
+			return err
+		}
+		opts = append(opts, kgo.ProducerBatchCompression(codec))
+	}
 	log.Debug().Msg("🟡 initializing kafka client")
-	client, err := kgo.NewClient(
-		kgo.SeedBrokers(conf.Brokers...),
-	)
+	client, err := kgo.NewClient(opts...)
 	s.client = client
 	if err != nil {
 		log.Debug().Stack().Err(err).Msg("could not create kafka sink client")
@@ -54,7 +84,14 @@ func (s *Sink) Initialize(conf config.Sink) error {
 	}
 	admClient := kadm.NewClient(client)
 	log.Debug().Msg("🟡 verifying topics exist")
-	topicDetails, err := admClient.DescribeTopicConfigs(ctx, s.metadata.DefaultOutput, s.metadata.DeadletterOutput)
+	// Topics of templated outputs must already exist, or be auto-created by the brokers
+	var topics []string
+	for _, topic := range []string{s.metadata.DefaultOutput, s.metadata.DeadletterOutput} {
+		if !backendutils.Templated(topic) {
+			topics = append(topics, topic)
+		}
+	}
+	topicDetails, err := admClient.DescribeTopicConfigs(ctx, topics...)
 	if err != nil {
 		log.Error().Err(err).Msg("🔴 could not describe topic configs")
 		return err
@@ -87,10 +124,17 @@ func (s *Sink) Enqueue(envelopes []envelope.Envelope) error {
 
 func (s *Sink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
 	var wg sync.WaitGroup
-	for _, e := range envelopes {
-		payload, err := json.Marshal(e)
+	var mu sync.Mutex
+	// Failures are reported per envelope, so the records which were
+	// produced aren't produced again
+	failures := make(backendutils.EnvelopeErrors)
+	for i, e := range envelopes {
+		payload, err := s.encoder.Encode(e)
 		if err != nil {
-			return err
+			mu.Lock()
+			failures[i] = backendutils.Permanent(err) // Retrying won't fix a payload which can't be encoded
+			mu.Unlock()
+			continue
 		}
 		headers := []kgo.RecordHeader{
 			{Key: envelope.PROTOCOL, Value: []byte(e.Protocol)},
@@ -99,34 +143,48 @@ func (s *Sink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, outpu
 			{Key: envelope.NAMESPACE, Value: []byte(e.Namespace)},
 			{Key: envelope.VERSION, Value: []byte(e.Version)},
 			{Key: envelope.IS_VALID, Value: []byte(strconv.FormatBool(e.IsValid))},
+			{Key: envelope.CONTENT_TYPE, Value: []byte(s.encoder.ContentType)},
+		}
+		if retention := e.Retention(); retention != "" {
+			headers = append(headers, kgo.RecordHeader{Key: envelope.RETENTION, Value: []byte(retention)})
+		}
+		key := e.Namespace
+		if s.partitionKey != nil {
+			key = s.partitionKey.Of(e)
 		}
 		record := &kgo.Record{
-			Key:     []byte(e.Namespace),
+			Key:     []byte(key),
 			Topic:   output,
 			Value:   payload,
 			Headers: headers,
 		}
 		wg.Add(1)
-		var produceErr error // FIXME! Could probably do this similarly to pubsub sink and use a chan?
+		i := i
 		s.client.Produce(ctx, record, func(r *kgo.Record, err error) {
 			defer wg.Done()
 			if err != nil {
 				log.Error().Err(err).Msg("🔴 could not publish record")
-				produceErr = err
+				mu.Lock()
+				failures[i] = err
+				mu.Unlock()
 			} else {
 				offset := strconv.FormatInt(r.Offset, 10)
 				partition := strconv.FormatInt(int64(r.Partition), 10)
 				log.Trace().Msg("published event " + offset + " to topic " + output + " partition " + partition)
 			}
 		})
-		if produceErr != nil {
-			return produceErr
-		}
 	}
 	wg.Wait()
+	if len(failures) > 0 {
+		return failures
+	}
 	return nil
 }
 
+func (s *Sink) Check(ctx context.Context) error {
+	return s.client.Ping(ctx)
+}
+
 func (s *Sink) Shutdown() error {
 	log.Debug().Interface("metadata", s.metadata).Msg("🟢 shutting down sink")
 	s.shutdown <- 1
@@ -6,7 +6,6 @@ package kinesis
 
 import (
 	"context"
-	"encoding/json"
 	"sync"
 
 	awsconf "github.com/aws/aws-sdk-go-v2/config"
@@ -18,10 +17,12 @@ import (
 )
 
 type Sink struct {
-	metadata backendutils.SinkMetadata
-	client   *kinesis.Client
-	input    chan []envelope.Envelope
-	shutdown chan int
+	metadata     backendutils.SinkMetadata
+	client       *kinesis.Client
+	partitionKey *backendutils.PartitionKey
+	encoder      backendutils.Encoder
+	input        chan []envelope.Envelope
+	shutdown     chan int
 }
 
 func (s *Sink) Metadata() backendutils.SinkMetadata {
@@ -29,6 +30,18 @@ func (s *Sink) Metadata() backendutils.SinkMetadata {
 }
 
 func (s *Sink) Initialize(conf config.Sink) error {
+	partitionKey, err := backendutils.NewPartitionKey(conf.PartitionKey)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not compile partition key")
+		return err
+	}
+	s.partitionKey = partitionKey
+	encoder, err := backendutils.NewEncoder(conf.Format)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not build encoder")
+		return err
+	}
+	s.encoder = encoder
 	ctx := context.Background()
 	cfg, err := awsconf.LoadDefaultConfig(ctx)
 	client := kinesis.NewFromConfig(cfg)
@@ -53,8 +66,16 @@ func (s *Sink) Enqueue(envelopes []envelope.Envelope) error {
 func (s *Sink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
 	var wg sync.WaitGroup
 	for _, event := range envelopes {
-		partitionKey := "blah" // FIXME!
-		payload, _ := json.Marshal(event)
+		// Kinesis requires a partition key, so envelopes without one are
+		// spread across shards by their id
+		partitionKey := s.partitionKey.Of(event)
+		if partitionKey == "" {
+			partitionKey = event.Uuid.String()
+		}
+		payload, err := s.encoder.Encode(event)
+		if err != nil {
+			return backendutils.Permanent(err)
+		}
 		input := &kinesis.PutRecordInput{
 			Data:         payload,
 			PartitionKey: &partitionKey,
@@ -73,7 +94,7 @@ func (s *Sink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, outpu
 				pErr <- nil
 			}
 		}(pubErr)
-		err := <-pubErr
+		err = <-pubErr
 		if err != nil {
 			return err
 		}
@@ -7,11 +7,13 @@ package minio
 import (
 	"context"
 	"io"
+	"net/http"
 	"path/filepath"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
 	"github.com/silverton-io/buz/pkg/config"
 )
 
@@ -58,6 +60,9 @@ func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error)
 		return nil, err
 	}
 	contents, err = io.ReadAll(obj)
+	if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return nil, backendutils.SchemaNotFound(schema, err)
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("🔴 could not read contents from file: " + schemaLocation)
 		return nil, err
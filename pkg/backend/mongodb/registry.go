@@ -6,8 +6,10 @@ package mongodb
 
 import (
 	"context"
+	"errors"
 
 	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
 	"github.com/silverton-io/buz/pkg/config"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -52,12 +54,11 @@ func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error)
 	ctx := context.Background()
 	var doc = MongoSchemaDocument{}
 	err = b.registryCollection.FindOne(ctx, bson.M{"name": schema}).Decode(&doc)
-	if err != nil {
-		log.Error().Err(err).Msg("🔴 could not decode document")
-		return nil, err
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, backendutils.SchemaNotFound(schema, err)
 	}
 	if err != nil {
-		log.Error().Err(err).Msg("🔴 could not marshal document")
+		log.Error().Err(err).Msg("🔴 could not decode document")
 		return nil, err
 	}
 	return []byte(doc.Contents), nil
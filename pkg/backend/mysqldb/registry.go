@@ -5,9 +5,12 @@
 package mysqldb
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 
 	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/db"
 	"gorm.io/driver/mysql"
@@ -40,8 +43,10 @@ func (b *RegistryBackend) Initialize(conf config.Backend) error {
 
 func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error) {
 	var s db.RegistryTable
-	b.gormDb.Table(b.registryTable).Where("name = ?", schema).First(&s)
-	err = b.gormDb.Error
+	err = b.gormDb.Table(b.registryTable).Where("name = ?", schema).First(&s).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, backendutils.SchemaNotFound(schema, err)
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("🔴 gorm error")
 		return nil, err
@@ -53,6 +58,18 @@ func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error)
 	return contents, nil
 }
 
+func (b *RegistryBackend) PutRemote(schema string, contents []byte) error {
+	return db.PutSchema(b.gormDb, b.registryTable, schema, contents)
+}
+
+func (b *RegistryBackend) DeleteRemote(schema string) error {
+	return db.DeleteSchema(b.gormDb, b.registryTable, schema)
+}
+
+func (b *RegistryBackend) Check(ctx context.Context) error {
+	return db.Ping(ctx, b.gormDb)
+}
+
 func (b *RegistryBackend) Close() {
 	log.Info().Msg("🟢 closing mysql schema cache backend")
 }
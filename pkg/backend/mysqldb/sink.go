@@ -20,6 +20,7 @@ import (
 type Sink struct {
 	metadata backendutils.SinkMetadata
 	gormDb   *gorm.DB
+	tables   db.Tables
 	input    chan []envelope.Envelope
 	shutdown chan int
 }
@@ -48,7 +49,11 @@ func (s *Sink) Initialize(conf config.Sink) error {
 	s.input = make(chan []envelope.Envelope, 10000)
 	s.shutdown = make(chan int, 1)
 	for _, tbl := range []string{s.metadata.DefaultOutput, s.metadata.DeadletterOutput} {
-		ensureErr := db.EnsureTable(s.gormDb, tbl, &envelope.Envelope{})
+		// Tables of templated outputs are ensured as they are delivered to
+		if backendutils.Templated(tbl) {
+			continue
+		}
+		ensureErr := s.tables.Ensure(s.gormDb, tbl, &envelope.Envelope{})
 		if ensureErr != nil {
 			return ensureErr
 		}
@@ -69,10 +74,17 @@ func (s *Sink) Enqueue(envelopes []envelope.Envelope) error {
 
 func (s *Sink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
 	log.Debug().Interface("metadata", s.Metadata()).Msg("dequeueing envelopes")
+	if err := s.tables.Ensure(s.gormDb, output, &envelope.Envelope{}); err != nil {
+		return err
+	}
 	err := s.gormDb.Table(output).Create(envelopes).Error
 	return err
 }
 
+func (s *Sink) Check(ctx context.Context) error {
+	return db.Ping(ctx, s.gormDb)
+}
+
 func (s *Sink) Shutdown() error {
 	log.Debug().Interface("metadata", s.metadata).Msg("🟢 shutting down sink")
 	db, _ := s.gormDb.DB()
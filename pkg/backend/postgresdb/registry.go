@@ -5,7 +5,11 @@
 package postgresdb
 
 import (
+	"context"
+	"errors"
+
 	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/db"
 	"gorm.io/driver/postgres"
@@ -38,14 +42,28 @@ func (b *RegistryBackend) Initialize(conf config.Backend) error {
 
 func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error) {
 	var s db.RegistryTable
-	b.gormDb.Table(b.registryTable).Where("name = ?", schema).First(&s)
-	err = b.gormDb.Error
+	err = b.gormDb.Table(b.registryTable).Where("name = ?", schema).First(&s).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, backendutils.SchemaNotFound(schema, err)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return s.Contents, nil
 }
 
+func (b *RegistryBackend) PutRemote(schema string, contents []byte) error {
+	return db.PutSchema(b.gormDb, b.registryTable, schema, contents)
+}
+
+func (b *RegistryBackend) DeleteRemote(schema string) error {
+	return db.DeleteSchema(b.gormDb, b.registryTable, schema)
+}
+
+func (b *RegistryBackend) Check(ctx context.Context) error {
+	return db.Ping(ctx, b.gormDb)
+}
+
 func (b *RegistryBackend) Close() {
 	log.Info().Msg("🟢 closing postgres schema cache backend")
 }
@@ -5,7 +5,6 @@
 package pubsub
 
 import (
-	"encoding/json"
 	"strconv"
 	"time"
 
@@ -36,10 +35,12 @@ func checkTopicExistence(topic *pubsub.Topic) bool {
 }
 
 type Sink struct {
-	metadata backendutils.SinkMetadata
-	client   *pubsub.Client
-	input    chan []envelope.Envelope
-	shutdown chan int
+	metadata     backendutils.SinkMetadata
+	client       *pubsub.Client
+	partitionKey *backendutils.PartitionKey
+	encoder      backendutils.Encoder
+	input        chan []envelope.Envelope
+	shutdown     chan int
 }
 
 func (s *Sink) Metadata() backendutils.SinkMetadata {
@@ -48,6 +49,18 @@ func (s *Sink) Metadata() backendutils.SinkMetadata {
 
 func (s *Sink) Initialize(conf config.Sink) error {
 	s.metadata = backendutils.NewSinkMetadataFromConfig(conf)
+	partitionKey, err := backendutils.NewPartitionKey(conf.PartitionKey)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not compile partition key")
+		return err
+	}
+	s.partitionKey = partitionKey
+	encoder, err := backendutils.NewEncoder(conf.Format)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not build encoder")
+		return err
+	}
+	s.encoder = encoder
 	ctx, _ := context.WithTimeout(context.Background(), INIT_TIMEOUT_SECONDS*time.Second)
 	client, err := pubsub.NewClient(ctx, conf.Project)
 	if err != nil {
@@ -78,19 +91,25 @@ func (s *Sink) Enqueue(envelopes []envelope.Envelope) error {
 func (s *Sink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
 	var wg sync.WaitGroup
 	for _, e := range envelopes {
-		payload, _ := json.Marshal(e)
+		payload, err := s.encoder.Encode(e)
+		if err != nil {
+			return backendutils.Permanent(err)
+		}
 		msg := &pubsub.Message{
 			Data: payload,
 			Attributes: map[string]string{
-				envelope.PROTOCOL:  e.Protocol,
-				envelope.SCHEMA:    e.Schema,
-				envelope.VENDOR:    e.Vendor,
-				envelope.NAMESPACE: e.Namespace,
-				envelope.VERSION:   e.Version,
-				envelope.IS_VALID:  strconv.FormatBool(e.IsValid),
+				envelope.PROTOCOL:     e.Protocol,
+				envelope.SCHEMA:       e.Schema,
+				envelope.VENDOR:       e.Vendor,
+				envelope.NAMESPACE:    e.Namespace,
+				envelope.VERSION:      e.Version,
+				envelope.IS_VALID:     strconv.FormatBool(e.IsValid),
+				envelope.CONTENT_TYPE: s.encoder.ContentType,
 			},
+			OrderingKey: s.partitionKey.Of(e),
 		}
 		topic := s.client.Topic(output)
+		topic.EnableMessageOrdering = s.partitionKey != nil
 		result := topic.Publish(ctx, msg)
 		wg.Add(1)
 		publishErr := make(chan error, 1)
@@ -98,6 +117,10 @@ func (s *Sink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, outpu
 			defer wg.Done()
 			id, err := res.Get(ctx)
 			if err != nil {
+				// Publishing is paused for an ordering key after a failure
+				if msg.OrderingKey != "" {
+					topic.ResumePublish(msg.OrderingKey)
+				}
 				pErr <- err
 
 			} else {
@@ -105,7 +128,7 @@ func (s *Sink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, outpu
 				pErr <- nil
 			}
 		}(result, publishErr)
-		err := <-publishErr
+		err = <-publishErr
 		if err != nil {
 			return err
 		}
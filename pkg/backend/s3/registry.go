@@ -6,21 +6,27 @@ package s3
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net/http"
 	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	awsconf "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
 	"github.com/silverton-io/buz/pkg/config"
 )
 
 type RegistryBackend struct {
-	bucket     string
-	path       string
-	client     *s3.Client
-	downloader *manager.Downloader
+	bucket string
+	path   string
+	client *s3.Client
+	// Optional local copies of schemas, refreshed when the object etag changes
+	objectCache *backendutils.ObjectCache
 }
 
 func (b *RegistryBackend) Initialize(conf config.Backend) error {
@@ -31,31 +37,108 @@ func (b *RegistryBackend) Initialize(conf config.Backend) error {
 		log.Error().Err(err).Msg("🔴 could not load aws config")
 		return err
 	}
+	if conf.Region != "" {
+		cfg.Region = conf.Region
+	}
 	client := s3.NewFromConfig(cfg)
-	downloader := manager.NewDownloader(client)
-	b.bucket, b.path, b.client, b.downloader = conf.Bucket, conf.Path, client, downloader
+	b.bucket, b.path, b.client = conf.Bucket, conf.Path, client
+	if conf.LocalCache {
+		log.Debug().Msg("🟡 s3 schema cache backend local caching enabled")
+		b.objectCache = backendutils.NewObjectCache()
+	}
 	return nil
 }
 
+func (b *RegistryBackend) schemaLocation(schema string) string {
+	if b.path == "/" || b.path == "" {
+		return schema
+	}
+	return filepath.Join(b.path, schema)
+}
+
+// notFound returns true if the s3 error is a 404 for a missing key
+func notFound(err error) bool {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode() == http.StatusNotFound
+	}
+	return false
+}
+
+// notModified returns true if the s3 error is a 304 as a result of a conditional get
+func notModified(err error) bool {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode() == http.StatusNotModified
+	}
+	return false
+}
+
 func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error) {
 	ctx := context.Background()
-	var schemaLocation string
-	if b.path == "/" {
-		schemaLocation = schema
-	} else {
-		schemaLocation = filepath.Join(b.path, schema)
-	}
-	buffer := manager.NewWriteAtBuffer([]byte{})
-	log.Debug().Msg("🟡 getting file from s3 backend " + schemaLocation)
-	_, err = b.downloader.Download(ctx, buffer, &s3.GetObjectInput{
+	schemaLocation := b.schemaLocation(schema)
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(b.bucket),
 		Key:    aws.String(schemaLocation),
-	})
+	}
+	var cached backendutils.CachedObject
+	var isCached bool
+	if b.objectCache != nil {
+		cached, isCached = b.objectCache.Get(schemaLocation)
+		if isCached {
+			input.IfNoneMatch = aws.String(cached.Version)
+		}
+	}
+	log.Debug().Msg("🟡 getting file from s3 backend " + schemaLocation)
+	output, err := b.client.GetObject(ctx, input)
 	if err != nil {
+		if isCached && notModified(err) {
+			log.Debug().Msg("🟡 s3 object not modified, using local copy of " + schemaLocation)
+			return cached.Contents, nil
+		}
+		if notFound(err) {
+			return nil, backendutils.SchemaNotFound(schema, err)
+		}
 		log.Error().Err(err).Msg("🔴 could not get file from s3: " + schemaLocation)
 		return nil, err
 	}
-	return buffer.Bytes(), nil
+	defer output.Body.Close()
+	contents, err = io.ReadAll(output.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not read file from s3: " + schemaLocation)
+		return nil, err
+	}
+	if b.objectCache != nil {
+		b.objectCache.Set(schemaLocation, aws.ToString(output.ETag), contents)
+	}
+	return contents, nil
+}
+
+// ListRemote lists all schemas present under the configured bucket prefix
+func (b *RegistryBackend) ListRemote() (schemas []string, err error) {
+	ctx := context.Background()
+	prefix := strings.TrimPrefix(b.path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not list objects in s3 bucket " + b.bucket)
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if strings.HasSuffix(key, ".json") {
+				schemas = append(schemas, strings.TrimPrefix(key, prefix))
+			}
+		}
+	}
+	return schemas, nil
 }
 
 func (b *RegistryBackend) Close() {
@@ -0,0 +1,61 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/snapshot"
+)
+
+// RegistryBackend serves schemas from a snapshot tarball, for air-gapped
+// deployments and reproducible environments.
+type RegistryBackend struct {
+	schemas map[string][]byte
+}
+
+func (b *RegistryBackend) Initialize(conf config.Backend) error {
+	log.Debug().Msg("🟡 initializing snapshot registry backend")
+	f, err := os.Open(conf.Path)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not open schema snapshot " + conf.Path)
+		return err
+	}
+	defer f.Close()
+	schemas, err := snapshot.Read(f)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not read schema snapshot " + conf.Path)
+		return err
+	}
+	b.schemas = schemas
+	log.Info().Msgf("🟢 loaded %d schemas from snapshot %s", len(schemas), conf.Path)
+	return nil
+}
+
+func (b *RegistryBackend) GetRemote(schema string) (contents []byte, err error) {
+	contents, ok := b.schemas[schema]
+	if !ok {
+		return nil, backendutils.SchemaNotFound(schema, errors.New("not in snapshot"))
+	}
+	return contents, nil
+}
+
+func (b *RegistryBackend) ListRemote() (schemas []string, err error) {
+	for name := range b.schemas {
+		schemas = append(schemas, name)
+	}
+	sort.Strings(schemas)
+	return schemas, nil
+}
+
+func (b *RegistryBackend) Close() {
+	log.Debug().Msg("🟡 closing snapshot registry backend")
+	// No-op
+}
@@ -6,6 +6,7 @@ package splunk
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/url"
 
@@ -17,11 +18,12 @@ import (
 )
 
 type Sink struct {
-	metadata backendutils.SinkMetadata
-	url      *url.URL
-	token    string
-	input    chan []envelope.Envelope
-	shutdown chan int
+	metadata   backendutils.SinkMetadata
+	url        *url.URL
+	token      string
+	compressor *backendutils.Compressor
+	input      chan []envelope.Envelope
+	shutdown   chan int
 }
 
 func (s *Sink) Metadata() backendutils.SinkMetadata {
@@ -36,6 +38,12 @@ func (s *Sink) Initialize(conf config.Sink) error {
 	s.url = url
 	s.token = conf.Token
 	s.metadata = backendutils.NewSinkMetadataFromConfig(conf)
+	compressor, err := backendutils.NewCompressor(conf.Compression)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not build compressor")
+		return err
+	}
+	s.compressor = compressor
 	s.input = make(chan []envelope.Envelope, 10000)
 	s.shutdown = make(chan int, 1)
 	return nil
@@ -57,7 +65,15 @@ func (s *Sink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, outpu
 	splunkHeader := http.Header{
 		"Authorization": {"Splunk " + s.token},
 	}
-	resp, err := request.PostEnvelopes(*s.url, envelopes, splunkHeader)
+	body, err := json.Marshal(envelopes)
+	if err != nil {
+		return backendutils.Permanent(err)
+	}
+	if body, err = s.compressor.Body(body, splunkHeader); err != nil {
+		log.Error().Err(err).Interface("metadata", s.Metadata()).Msg("🔴 could not compress envelopes")
+		return err
+	}
+	resp, err := request.PostBytes(*s.url, body, splunkHeader)
 	if err != nil {
 		log.Error().Interface("response", resp).Err(err).Msg("could not post envelopes")
 	}
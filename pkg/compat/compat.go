@@ -0,0 +1,179 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package compat
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/docs"
+)
+
+// Compatibility modes. Backward compatible schemas accept every event the
+// current schema accepts, so consumers can upgrade first. Forward compatible
+// schemas only produce events the current schema accepts, so producers can
+// upgrade first.
+const (
+	BACKWARD string = "backward"
+	FORWARD  string = "forward"
+	FULL     string = "full"
+)
+
+// Kinds of change
+const (
+	FIELD_ADDED          string = "fieldAdded"
+	REQUIRED_FIELD_ADDED string = "requiredFieldAdded"
+	FIELD_REMOVED        string = "fieldRemoved"
+	MADE_REQUIRED        string = "madeRequired"
+	MADE_OPTIONAL        string = "madeOptional"
+	TYPE_CHANGED         string = "typeChanged"
+	ENUM_CHANGED         string = "enumChanged"
+)
+
+type Change struct {
+	Path           string `json:"path"`
+	Change         string `json:"change"`
+	Description    string `json:"description"`
+	BreaksBackward bool   `json:"breaksBackward"`
+	BreaksForward  bool   `json:"breaksForward"`
+}
+
+type Report struct {
+	Backward bool     `json:"backward"`
+	Forward  bool     `json:"forward"`
+	Changes  []Change `json:"changes"`
+}
+
+// Compatible returns true if the report satisfies the mode
+func (r Report) Compatible(mode string) bool {
+	switch mode {
+	case FORWARD:
+		return r.Forward
+	case FULL:
+		return r.Backward && r.Forward
+	}
+	return r.Backward
+}
+
+func typeSet(t string) map[string]bool {
+	set := make(map[string]bool)
+	for _, typ := range strings.Split(t, " | ") {
+		if typ != "" {
+			set[typ] = true
+		}
+	}
+	return set
+}
+
+// accepts returns true if a field of type `wider` accepts every value of type `narrower`
+func accepts(wider string, narrower string) bool {
+	w, n := typeSet(wider), typeSet(narrower)
+	if len(w) == 0 {
+		return true // Untyped fields accept anything
+	}
+	if len(n) == 0 {
+		return false
+	}
+	for typ := range n {
+		if !w[typ] && !(typ == "integer" && w["number"]) {
+			return false
+		}
+	}
+	return true
+}
+
+func enumValues(values []interface{}) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range values {
+		b, _ := json.Marshal(v)
+		set[string(b)] = true
+	}
+	return set
+}
+
+// subset returns true if every value of a is in b. Fields without
+// an enum allow every value.
+func subset(a []interface{}, b []interface{}) bool {
+	if len(b) == 0 {
+		return true
+	}
+	if len(a) == 0 {
+		return false
+	}
+	bv := enumValues(b)
+	for v := range enumValues(a) {
+		if !bv[v] {
+			return false
+		}
+	}
+	return true
+}
+
+// Check compares the fields of the current and proposed schemas. Whether
+// objects allow additional properties is not considered.
+func Check(current docs.SchemaDoc, proposed docs.SchemaDoc) Report {
+	currentFields := make(map[string]docs.FieldDoc)
+	for _, f := range current.Fields {
+		currentFields[f.Path] = f
+	}
+	proposedFields := make(map[string]docs.FieldDoc)
+	for _, f := range proposed.Fields {
+		proposedFields[f.Path] = f
+	}
+	var changes []Change
+	for path, p := range proposedFields {
+		c, existed := currentFields[path]
+		switch {
+		case !existed && p.Required:
+			changes = append(changes, Change{Path: path, Change: REQUIRED_FIELD_ADDED, Description: "required field added", BreaksBackward: true})
+		case !existed:
+			changes = append(changes, Change{Path: path, Change: FIELD_ADDED, Description: "optional field added"})
+		default:
+			if p.Required && !c.Required {
+				changes = append(changes, Change{Path: path, Change: MADE_REQUIRED, Description: "optional field made required", BreaksBackward: true})
+			}
+			if !p.Required && c.Required {
+				changes = append(changes, Change{Path: path, Change: MADE_OPTIONAL, Description: "required field made optional", BreaksForward: true})
+			}
+			if p.Type != c.Type {
+				changes = append(changes, Change{
+					Path:           path,
+					Change:         TYPE_CHANGED,
+					Description:    "type changed from " + c.Type + " to " + p.Type,
+					BreaksBackward: !accepts(p.Type, c.Type),
+					BreaksForward:  !accepts(c.Type, p.Type),
+				})
+			}
+			backward, forward := subset(c.Enum, p.Enum), subset(p.Enum, c.Enum)
+			if !backward || !forward {
+				changes = append(changes, Change{
+					Path:           path,
+					Change:         ENUM_CHANGED,
+					Description:    "allowed values changed",
+					BreaksBackward: !backward,
+					BreaksForward:  !forward,
+				})
+			}
+		}
+	}
+	for path, c := range currentFields {
+		if _, exists := proposedFields[path]; !exists {
+			changes = append(changes, Change{Path: path, Change: FIELD_REMOVED, Description: "field removed", BreaksForward: c.Required})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	report := Report{Backward: true, Forward: true, Changes: changes}
+	for _, c := range changes {
+		report.Backward = report.Backward && !c.BreaksBackward
+		report.Forward = report.Forward && !c.BreaksForward
+	}
+	if report.Changes == nil {
+		report.Changes = []Change{}
+	}
+	return report
+}
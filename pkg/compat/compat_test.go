@@ -0,0 +1,53 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package compat
+
+import (
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/docs"
+	"github.com/stretchr/testify/assert"
+)
+
+func field(path string, typ string, required bool, enum ...interface{}) docs.FieldDoc {
+	return docs.FieldDoc{Path: path, Type: typ, Required: required, Enum: enum}
+}
+
+func TestCheck(t *testing.T) {
+	current := docs.SchemaDoc{Fields: []docs.FieldDoc{
+		field("id", "string", true),
+		field("count", "integer", false),
+		field("status", "string", false, "open", "closed"),
+		field("legacy", "string", false),
+	}}
+	var testCases = []struct {
+		name         string
+		proposed     []docs.FieldDoc
+		wantChange   string
+		wantBackward bool
+		wantForward  bool
+	}{
+		{"optional field added", append(current.Fields, field("note", "string", false)), FIELD_ADDED, true, true},
+		{"required field added", append(current.Fields, field("note", "string", true)), REQUIRED_FIELD_ADDED, false, true},
+		{"optional field removed", current.Fields[:3], FIELD_REMOVED, true, true},
+		{"required field removed", current.Fields[1:], FIELD_REMOVED, true, false},
+		{"type widened", []docs.FieldDoc{current.Fields[0], field("count", "number", false), current.Fields[2], current.Fields[3]}, TYPE_CHANGED, true, false},
+		{"type changed", []docs.FieldDoc{field("id", "integer", true), current.Fields[1], current.Fields[2], current.Fields[3]}, TYPE_CHANGED, false, false},
+		{"enum value added", []docs.FieldDoc{current.Fields[0], current.Fields[1], field("status", "string", false, "open", "closed", "void"), current.Fields[3]}, ENUM_CHANGED, true, false},
+		{"made optional", []docs.FieldDoc{field("id", "string", false), current.Fields[1], current.Fields[2], current.Fields[3]}, MADE_OPTIONAL, true, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			report := Check(current, docs.SchemaDoc{Fields: tc.proposed})
+			assert.Len(t, report.Changes, 1)
+			assert.Equal(t, tc.wantChange, report.Changes[0].Change)
+			assert.Equal(t, tc.wantBackward, report.Backward)
+			assert.Equal(t, tc.wantForward, report.Forward)
+		})
+	}
+	unchanged := Check(current, current)
+	assert.True(t, unchanged.Compatible(FULL))
+	assert.Empty(t, unchanged.Changes)
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package compiler
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// The number of compiled schemas retained when no maximum is set
+const DEFAULT_MAX_COMPILED int = 10000
+
+// Compilation errors are retried after this long, in case they were caused
+// by a referenced schema which couldn't be loaded at the time
+const COMPILE_ERROR_TTL_SECONDS int = 30
+
+type compiled struct {
+	fingerprint [sha256.Size]byte
+	schema      *jsonschema.Schema
+	err         error
+	expires     time.Time           // When a compilation error is retried
+	references  map[string]struct{} // The registry schemas loaded to compile the schema
+}
+
+// Cache is an lru cache of compiled schemas keyed by a fingerprint of the
+// schema contents, so each schema is only compiled once no matter how many
+// payloads are validated against it. Compilation errors are cached for a
+// short while too.
+type Cache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[[sha256.Size]byte]*list.Element
+	hits       int64
+	misses     int64
+	now        func() time.Time
+}
+
+func NewCache(maxEntries int) *Cache {
+	if maxEntries <= 0 {
+		maxEntries = DEFAULT_MAX_COMPILED
+	}
+	return &Cache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[[sha256.Size]byte]*list.Element),
+		now:        time.Now,
+	}
+}
+
+// referenceName normalizes the name of a referenced schema, which may be
+// loaded with or without its suffix
+func referenceName(name string) string {
+	return strings.TrimSuffix(name, ".json")
+}
+
+// Compile returns the compiled schema, compiling it with the loader if it
+// has not been compiled before.
+func (c *Cache) Compile(schema []byte, load Loader) (*jsonschema.Schema, error) {
+	fingerprint := sha256.Sum256(schema)
+	c.mu.Lock()
+	if el, ok := c.items[fingerprint]; ok {
+		entry := el.Value.(*compiled)
+		if entry.err == nil || c.now().Before(entry.expires) {
+			c.hits++
+			c.ll.MoveToFront(el)
+			c.mu.Unlock()
+			return entry.schema, entry.err
+		}
+		c.remove(el)
+	}
+	c.misses++
+	c.mu.Unlock()
+	// Compile outside the lock, as the loader may itself need to compile
+	references := make(map[string]struct{})
+	var loader Loader
+	if load != nil {
+		var mu sync.Mutex
+		loader = func(name string) ([]byte, error) {
+			mu.Lock()
+			references[referenceName(name)] = struct{}{}
+			mu.Unlock()
+			return load(name)
+		}
+	}
+	s, err := CompileWith(schema, loader)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[fingerprint]; !ok {
+		entry := &compiled{fingerprint: fingerprint, schema: s, err: err, references: references}
+		if err != nil {
+			entry.expires = c.now().Add(time.Duration(COMPILE_ERROR_TTL_SECONDS) * time.Second)
+		}
+		c.items[fingerprint] = c.ll.PushFront(entry)
+		for c.ll.Len() > c.maxEntries {
+			c.remove(c.ll.Back())
+		}
+	}
+	return s, err
+}
+
+func (c *Cache) remove(el *list.Element) {
+	entry := c.ll.Remove(el).(*compiled)
+	delete(c.items, entry.fingerprint)
+}
+
+// Evict forgets the compiled schemas which reference the named schema, such
+// as when it changes
+func (c *Cache) Evict(name string) {
+	name = referenceName(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if _, ok := el.Value.(*compiled).references[name]; ok {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// Clear forgets all compiled schemas
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[[sha256.Size]byte]*list.Element)
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Stats returns the number of cache hits and misses
+func (c *Cache) Stats() (hits int64, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package compiler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	c := NewCache(2)
+	a, err := c.Compile([]byte(`{"type": "string"}`), nil)
+	assert.Nil(t, err)
+	again, _ := c.Compile([]byte(`{"type": "string"}`), nil)
+	assert.Same(t, a, again)
+
+	// Compilation errors are cached too
+	_, err = c.Compile([]byte(`{"type": 10}`), nil)
+	assert.NotNil(t, err)
+	_, err = c.Compile([]byte(`{"type": 10}`), nil)
+	assert.NotNil(t, err)
+	hits, misses := c.Stats()
+	assert.Equal(t, int64(2), hits)
+	assert.Equal(t, int64(2), misses)
+
+	// The least-recently used schema is evicted
+	c.Compile([]byte(`{"type": "number"}`), nil)
+	assert.Equal(t, 2, c.Len())
+	_, misses = c.Stats()
+	c.Compile([]byte(`{"type": "string"}`), nil)
+	_, missesAfter := c.Stats()
+	assert.Equal(t, misses+1, missesAfter)
+
+	c.Clear()
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestCacheRetriesErrors(t *testing.T) {
+	c := NewCache(0)
+	now := time.Unix(1700000000, 0)
+	c.now = func() time.Time { return now }
+	available := false
+	load := func(name string) ([]byte, error) {
+		if !available {
+			return nil, errors.New("unavailable")
+		}
+		return []byte(`{"type": "string"}`), nil
+	}
+	schema := []byte(`{"$ref": "/s/com.acme/name/v1.0.json"}`)
+	_, err := c.Compile(schema, load)
+	assert.NotNil(t, err)
+	available = true
+	_, err = c.Compile(schema, load)
+	assert.NotNil(t, err)
+	now = now.Add(time.Duration(COMPILE_ERROR_TTL_SECONDS) * time.Second)
+	_, err = c.Compile(schema, load)
+	assert.Nil(t, err)
+}
+
+func TestCacheEvictsDependents(t *testing.T) {
+	c := NewCache(0)
+	load := func(name string) ([]byte, error) {
+		return []byte(`{"type": "string"}`), nil
+	}
+	c.Compile([]byte(`{"$ref": "/s/com.acme/name/v1.0.json"}`), load)
+	c.Compile([]byte(`{"type": "number"}`), load)
+	assert.Equal(t, 2, c.Len())
+	c.Evict("com.acme/other/v1.0.json")
+	assert.Equal(t, 2, c.Len())
+	// References are matched with or without their suffix
+	c.Evict("com.acme/name/v1.0")
+	assert.Equal(t, 1, c.Len())
+}
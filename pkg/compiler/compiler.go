@@ -0,0 +1,113 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package compiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Schemas are compiled relative to this base so that relative `$id`s
+// resolve to their location in the buz registry.
+const BASE_URL string = "https://registry.buz.dev/s/"
+
+const ROOT_SCHEMA_URL string = BASE_URL + "schema.json"
+
+// The draft used when `$schema` is absent or refers to a custom metaschema.
+var DefaultDraft = jsonschema.Draft2020
+
+var drafts = map[string]*jsonschema.Draft{
+	"https://json-schema.org/schema":               jsonschema.Draft2020,
+	"https://json-schema.org/draft/2020-12/schema": jsonschema.Draft2020,
+	"https://json-schema.org/draft/2019-09/schema": jsonschema.Draft2019,
+	"https://json-schema.org/draft-07/schema":      jsonschema.Draft7,
+	"https://json-schema.org/draft-06/schema":      jsonschema.Draft6,
+	"https://json-schema.org/draft-04/schema":      jsonschema.Draft4,
+}
+
+// DraftFor returns the json schema draft referenced by the `$schema` keyword,
+// or nil if it is not a standard draft.
+func DraftFor(metaschema string) *jsonschema.Draft {
+	u := strings.Replace(metaschema, "http://", "https://", 1)
+	u = strings.TrimSuffix(strings.TrimSuffix(u, "#"), "#/")
+	return drafts[u]
+}
+
+// A Loader gets a schema from the registry by name, such as
+// `io.silverton/buz/example/productView/v1.0.json`.
+type Loader func(name string) ([]byte, error)
+
+// Compile compiles a json schema. The draft is selected from the `$schema`
+// keyword - schemas using a custom metaschema (such as the buz metaschema)
+// are compiled according to DefaultDraft.
+func Compile(schema []byte) (*jsonschema.Schema, error) {
+	return CompileWith(schema, nil)
+}
+
+// CompileWith compiles a json schema, resolving `$ref`s to other registry
+// schemas with the loader. Registry schemas are referenced by their full url
+// (`https://registry.buz.dev/s/com.acme/entity/v1.0.json`) or by their path
+// (`/s/com.acme/entity/v1.0.json`). Reference cycles which never consume
+// any input are reported as compilation errors.
+func CompileWith(schema []byte, load Loader) (*jsonschema.Schema, error) {
+	s, err := withoutCustomMetaschema(schema)
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	c.Draft = DefaultDraft
+	c.ExtractAnnotations = true // Descriptions and examples are used for documentation
+	c.LoadURL = func(url string) (io.ReadCloser, error) {
+		name := strings.TrimPrefix(url, BASE_URL)
+		if load == nil || name == url {
+			return nil, fmt.Errorf("cannot resolve %s - only registry schemas may be referenced", url)
+		}
+		contents, err := load(name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve %s: %w", url, err)
+		}
+		contents, err = withoutCustomMetaschema(contents)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(contents)), nil
+	}
+	if err := c.AddResource(ROOT_SCHEMA_URL, bytes.NewReader(s)); err != nil {
+		return nil, err
+	}
+	compiled, err := c.Compile(ROOT_SCHEMA_URL)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCycles(compiled); err != nil {
+		return nil, err
+	}
+	return compiled, nil
+}
+
+// withoutCustomMetaschema removes the `$schema` keyword if it does not refer
+// to a standard draft, so the schema is compiled according to DefaultDraft.
+func withoutCustomMetaschema(schema []byte) ([]byte, error) {
+	var doc interface{}
+	d := json.NewDecoder(bytes.NewReader(schema))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return schema, nil
+	}
+	if metaschema, ok := m["$schema"].(string); ok && DraftFor(metaschema) == nil {
+		delete(m, "$schema")
+		return json.Marshal(m)
+	}
+	return schema, nil
+}
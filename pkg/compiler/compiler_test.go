@@ -0,0 +1,98 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package compiler
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/silverton-io/buz/schemas"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDraftFor(t *testing.T) {
+	var testCases = []struct {
+		metaschema string
+		want       *jsonschema.Draft
+	}{
+		{"https://json-schema.org/draft/2020-12/schema", jsonschema.Draft2020},
+		{"https://json-schema.org/draft/2019-09/schema", jsonschema.Draft2019},
+		{"http://json-schema.org/draft-07/schema#", jsonschema.Draft7},
+		{"https://registry.buz.dev/s/io.silverton/buz/internal/meta/v1.0.json", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.metaschema, func(t *testing.T) {
+			assert.Equal(t, tc.want, DraftFor(tc.metaschema))
+		})
+	}
+}
+
+func TestCompileEmbeddedSchemas(t *testing.T) {
+	err := fs.WalkDir(schemas.Embedded, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".json") {
+			return err
+		}
+		contents, _ := fs.ReadFile(schemas.Embedded, p)
+		_, compileErr := Compile(contents)
+		assert.Nil(t, compileErr, p)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestCompileInvalidSchema(t *testing.T) {
+	_, err := Compile([]byte(`{"type": 10}`))
+	assert.NotNil(t, err)
+	_, err = Compile([]byte(`{"type": `))
+	assert.NotNil(t, err)
+}
+
+func TestCompileWithRegistryRefs(t *testing.T) {
+	registry := map[string]string{
+		"com.acme/entities/address/v1.0.json": `{
+			"$schema": "https://registry.buz.dev/s/io.silverton/buz/internal/meta/v1.0.json",
+			"type": "object",
+			"properties": {"city": {"type": "string"}},
+			"required": ["city"]
+		}`,
+		"com.acme/entities/a/v1.0.json": `{"$ref": "/s/com.acme/entities/b/v1.0.json"}`,
+		"com.acme/entities/b/v1.0.json": `{"$ref": "/s/com.acme/entities/a/v1.0.json"}`,
+	}
+	load := func(name string) ([]byte, error) {
+		s, ok := registry[name]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		return []byte(s), nil
+	}
+
+	s, err := CompileWith([]byte(`{
+		"$id": "com.acme/order/v1.0.json",
+		"properties": {
+			"billing": {"$ref": "/s/com.acme/entities/address/v1.0.json"},
+			"shipping": {"$ref": "https://registry.buz.dev/s/com.acme/entities/address/v1.0.json"}
+		}
+	}`), load)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Validate(map[string]interface{}{"billing": map[string]interface{}{"city": "Denver"}}))
+	assert.NotNil(t, s.Validate(map[string]interface{}{"shipping": map[string]interface{}{}}))
+
+	_, err = CompileWith([]byte(`{"$ref": "/s/com.acme/entities/missing/v1.0.json"}`), load)
+	assert.NotNil(t, err)
+
+	_, err = CompileWith([]byte(`{"$ref": "https://example.com/schema.json"}`), load)
+	assert.NotNil(t, err)
+
+	// Reference cycles are detected rather than recursing forever
+	_, err = CompileWith([]byte(`{"$ref": "/s/com.acme/entities/a/v1.0.json"}`), load)
+	assert.NotNil(t, err)
+
+	// Without a loader registry references can't be resolved
+	_, err = Compile([]byte(`{"$ref": "/s/com.acme/entities/address/v1.0.json"}`))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// inPlace returns the subschemas applied to the same instance as the schema
+func inPlace(s *jsonschema.Schema) []*jsonschema.Schema {
+	subschemas := []*jsonschema.Schema{s.Ref, s.RecursiveRef, s.DynamicRef, s.Not, s.If, s.Then, s.Else}
+	subschemas = append(subschemas, s.AllOf...)
+	subschemas = append(subschemas, s.AnyOf...)
+	subschemas = append(subschemas, s.OneOf...)
+	for _, d := range s.DependentSchemas {
+		subschemas = append(subschemas, d)
+	}
+	for _, d := range s.Dependencies {
+		if d, ok := d.(*jsonschema.Schema); ok {
+			subschemas = append(subschemas, d)
+		}
+	}
+	return subschemas
+}
+
+// nested returns the subschemas applied to properties or items of the instance
+func nested(s *jsonschema.Schema) []*jsonschema.Schema {
+	subschemas := []*jsonschema.Schema{s.PropertyNames, s.UnevaluatedProperties, s.Items2020, s.Contains, s.UnevaluatedItems, s.ContentSchema}
+	for _, p := range s.Properties {
+		subschemas = append(subschemas, p)
+	}
+	for _, p := range s.PatternProperties {
+		subschemas = append(subschemas, p)
+	}
+	subschemas = append(subschemas, s.PrefixItems...)
+	for _, v := range []interface{}{s.AdditionalProperties, s.AdditionalItems, s.Items} {
+		switch v := v.(type) {
+		case *jsonschema.Schema:
+			subschemas = append(subschemas, v)
+		case []*jsonschema.Schema:
+			subschemas = append(subschemas, v...)
+		}
+	}
+	return subschemas
+}
+
+// checkCycles returns an error if subschemas refer to each other without
+// ever descending into the instance, which would never finish validating.
+// Recursive schemas which do descend (such as trees) are allowed.
+func checkCycles(root *jsonschema.Schema) error {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[*jsonschema.Schema]int)
+	var visit func(s *jsonschema.Schema) error
+	visit = func(s *jsonschema.Schema) error {
+		switch state[s] {
+		case visiting:
+			return fmt.Errorf("reference cycle at %s", s.Location)
+		case visited:
+			return nil
+		}
+		state[s] = visiting
+		for _, sub := range inPlace(s) {
+			if sub == nil {
+				continue
+			}
+			if err := visit(sub); err != nil {
+				return err
+			}
+		}
+		state[s] = visited
+		return nil
+	}
+	seen := map[*jsonschema.Schema]bool{root: true}
+	queue := []*jsonschema.Schema{root}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if err := visit(s); err != nil {
+			return err
+		}
+		for _, sub := range append(inPlace(s), nested(s)...) {
+			if sub != nil && !seen[sub] {
+				seen[sub] = true
+				queue = append(queue, sub)
+			}
+		}
+	}
+	return nil
+}
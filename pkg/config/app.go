@@ -5,11 +5,44 @@
 package config
 
 type App struct {
-	Version           string `json:"version"`
-	Name              string `json:"name"`
-	Env               string `json:"env"`
-	Port              string `json:"port"`
-	TrackerDomain     string `json:"trackerDomain"`
-	EnableConfigRoute bool   `json:"enableConfigRoute"`
-	Serverless        bool   `json:"serverless"`
+	Version             string   `json:"version"`
+	Name                string   `json:"name"`
+	Env                 string   `json:"env"`
+	Port                string   `json:"port"`
+	TrackerDomain       string   `json:"trackerDomain"`
+	EnableConfigRoute   bool     `json:"enableConfigRoute"`
+	EnableLogLevelRoute bool     `json:"enableLogLevelRoute"`      // Change the log level and toggle the request logger at runtime. Always requires auth
+	RedactPatterns      []string `json:"redactPatterns,omitempty"` // Regular expressions of config keys masked in the config route and logs, in addition to secrets
+	Serverless          bool     `json:"serverless"`
+	ShutdownTimeoutMs   int      `json:"shutdownTimeoutMs"` // How long to wait for in-flight requests, and then for queued envelopes to be delivered
+	Health              Health   `json:"health"`
+	Pprof               Pprof    `json:"pprof"`
+	Runtime             Runtime  `json:"runtime"`
+}
+
+// Runtime sets GOMAXPROCS and the go memory limit from the container's cpu
+// and memory limits, unless they are overridden here or by the GOMAXPROCS
+// and GOMEMLIMIT env vars
+type Runtime struct {
+	DisableAutoTune  bool    `json:"disableAutoTune,omitempty"`
+	MaxProcs         int     `json:"maxProcs,omitempty"`
+	MemoryLimitMb    int     `json:"memoryLimitMb,omitempty"`
+	MemoryLimitRatio float64 `json:"memoryLimitRatio,omitempty"` // The share of the container's memory the go runtime is limited to, leaving headroom for memory it doesn't manage. Defaults to 0.9
+}
+
+// Pprof serves profiles at /debug/pprof behind auth, in any mode. With an
+// addr they are served on a separate admin listener instead of the public
+// port.
+type Pprof struct {
+	Enabled bool   `json:"enabled"`
+	Addr    string `json:"addr,omitempty"` // Such as 127.0.0.1:6060, so profiles aren't reachable from outside the host or pod
+}
+
+// Health configures deep health checks, which are requested with
+// ?deep=true and verify the registry, sinks, and queues
+type Health struct {
+	Deep                bool    `json:"deep"`
+	TimeoutMs           int     `json:"timeoutMs,omitempty"`
+	CacheMs             int     `json:"cacheMs,omitempty"`             // Results are reused for this long, so checks can't flood dependencies
+	MaxQueueUtilization float64 `json:"maxQueueUtilization,omitempty"` // Queues fuller than this are unhealthy. Defaults to 0.9
 }
@@ -0,0 +1,13 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// Audit records administrative operations, such as cache purges and
+// schema publishes, to a file of json lines and/or a dedicated sink
+type Audit struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path,omitempty"` // Records are appended to this file, one json object per line
+	Sink    string `json:"sink,omitempty"` // The name of the sink receiving audit envelopes
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// Capture lists request values to capture into a context of each envelope
+type Capture struct {
+	Headers []CaptureField `json:"headers,omitempty"`
+	Cookies []CaptureField `json:"cookies,omitempty"`
+	Query   []CaptureField `json:"query,omitempty"`
+}
+
+type CaptureField struct {
+	Name string `json:"name"`
+	As   string `json:"as,omitempty"` // The key in the capture context, which defaults to the name
+	Hash bool   `json:"hash,omitempty"`
+}
@@ -5,6 +5,8 @@
 package config
 
 type Cloudevents struct {
-	Enabled bool   `json:"enabled"`
-	Path    string `json:"path"`
+	Enabled bool    `json:"enabled"`
+	Path    string  `json:"path"`
+	Capture Capture `json:"capture,omitempty"`
+	Privacy Privacy `json:"privacy,omitempty"`
 }
@@ -5,12 +5,24 @@
 package config
 
 type Config struct {
-	App        `json:"app"`
-	Middleware `json:"middleware"`
-	Inputs     `json:"inputs"`
-	Registry   `json:"registry"`
-	Manifold   `json:"manifold,omitempty"`
-	Sinks      []Sink `json:"sinks"`
-	Squawkbox  `json:"squawkBox"`
-	Tele       `json:"tele"`
+	App          `json:"app"`
+	Middleware   `json:"middleware"`
+	Inputs       `json:"inputs"`
+	Registry     `json:"registry"`
+	Validation   `json:"validation"`
+	Retention    `json:"retention"`
+	Manifold     `json:"manifold,omitempty"`
+	Transforms   []Transform `json:"transforms,omitempty"`
+	Sinks        []Sink      `json:"sinks"`
+	DeadLetter   `json:"deadLetter"`
+	Replay       `json:"replay"`
+	Erasure      `json:"erasure"`
+	Statsd       `json:"statsd"`
+	SelfTracking `json:"selfTracking"`
+	Tap          `json:"tap"`
+	Heartbeat    `json:"heartbeat"`
+	Sentry       `json:"sentry"`
+	Audit        `json:"audit"`
+	Squawkbox    `json:"squawkBox"`
+	Tele         `json:"tele"`
 }
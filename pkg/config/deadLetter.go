@@ -0,0 +1,19 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// DeadLetter stores envelopes which sinks fail to deliver, so they
+// can be replayed.
+type DeadLetter struct {
+	Enabled bool   `json:"enabled"`
+	Type    string `json:"type"` // file, s3, or stream
+	Path    string `json:"path"`
+	// S3
+	Bucket string `json:"bucket,omitempty"`
+	Region string `json:"region,omitempty"`
+	// Stream
+	Redis  Redis  `json:"redis,omitempty"`
+	Stream string `json:"stream,omitempty"` // The redis stream key, after the redis prefix. Defaults to deadletter
+}
@@ -0,0 +1,21 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// Erasure accepts requests to erase the data of a subject, which are
+// delivered to every sink and forwarded to the webhooks.
+type Erasure struct {
+	Enabled   bool             `json:"enabled"`
+	Webhooks  []ErasureWebhook `json:"webhooks,omitempty"`
+	TimeoutMs int              `json:"timeoutMs,omitempty"` // Per webhook
+}
+
+// ErasureWebhook is a downstream system which erases data of its own,
+// such as a crm or email provider
+type ErasureWebhook struct {
+	Name    string            `json:"name"`
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"-"` // Such as the webhook's auth
+}
@@ -0,0 +1,13 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// Heartbeat emits an envelope through the pipeline every interval, so
+// freshness monitors can tell a quiet period from a broken pipeline
+type Heartbeat struct {
+	Enabled         bool   `json:"enabled"`
+	Schema          string `json:"schema,omitempty"`          // Defaults to io.silverton/buz/internal/heartbeat/v1.0.json
+	IntervalSeconds int    `json:"intervalSeconds,omitempty"` // Defaults to 60
+}
@@ -4,5 +4,42 @@
 
 package config
 
+// Batch controls when the batching manifold delivers a batch to a sink,
+// whichever threshold is reached first.
+type Batch struct {
+	MaxEnvelopes int `json:"maxEnvelopes"`
+	LingerMs     int `json:"lingerMs"`
+	IdleMs       int `json:"idleMs"` // Flush once no envelopes have arrived for this long. Zero disables the idle timeout
+}
+
+// Pool controls the number of workers delivering envelopes to each sink,
+// and the number of batches queued for each sink before enqueueing blocks.
+type Pool struct {
+	Workers   int `json:"workers"`
+	QueueSize int `json:"queueSize"`
+}
+
+// Overload controls what happens to envelopes when the manifold's queues
+// are full because sinks can't keep up.
+type Overload struct {
+	Policy     string `json:"policy"`     // block, shed, or spill
+	QueueSize  int    `json:"queueSize"`  // Batches queued by the channel and batching manifolds
+	DeadlineMs int    `json:"deadlineMs"` // How long to block before shedding. Zero blocks indefinitely
+	SpillPath  string `json:"spillPath"`
+}
+
+type Limits struct {
+	MaxEventBytes int    `json:"maxEventBytes"` // Zero disables the limit
+	MaxBatchBytes int    `json:"maxBatchBytes"` // Batches over the limit are always rejected
+	Action        string `json:"action"`        // reject, invalid, or truncate
+	MaxFieldBytes int    `json:"maxFieldBytes"` // Free-text fields of oversize events are truncated to this length
+}
+
 type Manifold struct {
+	Type     string `json:"type"` // simple, channel, batching, or pool
+	Batch    `json:"batch"`
+	Pool     `json:"pool"`
+	Wal      `json:"wal"`
+	Overload `json:"overload"`
+	Limits   `json:"limits"`
 }
@@ -5,12 +5,52 @@
 package config
 
 type Middleware struct {
-	Timeout       `json:"timeout"`
-	RateLimiter   `json:"rateLimiter"`
-	Identity      `json:"identity"`
-	Cors          `json:"cors"`
-	RequestLogger `json:"requestLogger"`
-	Auth          `json:"auth"`
+	Timeout         `json:"timeout"`
+	RateLimiter     `json:"rateLimiter"`
+	Identity        `json:"identity"`
+	Cors            `json:"cors"`
+	RequestLogger   `json:"requestLogger"`
+	Auth            `json:"auth"`
+	OpsAuth         Auth `json:"opsAuth"` // Replaces auth for the ops and registry routes. Oidc is configured under auth.
+	Tenancy         `json:"tenancy"`
+	BotFilter       `json:"botFilter"`
+	CircuitBreaker  `json:"circuitBreaker"`
+	SecurityHeaders `json:"securityHeaders"`
+	AbuseDetection  `json:"abuseDetection"`
+	Quotas          `json:"quotas"`
+	ClientIp        `json:"clientIp"`
+	RequiredHeaders `json:"requiredHeaders"`
+}
+
+// RequiredHeaders rejects requests to inputs which don't carry the
+// headers declared for them
+type RequiredHeaders struct {
+	Enabled bool           `json:"enabled"`
+	Routes  []RouteHeaders `json:"routes"`
+}
+
+// RouteHeaders are the headers required on an input, or on one of its routes
+type RouteHeaders struct {
+	Input   string           `json:"input"`
+	Path    string           `json:"path,omitempty"` // One route of the input, rather than all of them
+	Headers []RequiredHeader `json:"headers"`
+}
+
+// RequiredHeader must be present, and equal its value or match its
+// pattern if either is set
+type RequiredHeader struct {
+	Name    string `json:"name"`
+	Value   string `json:"-"`                 // A shared secret, compared in constant time
+	Pattern string `json:"pattern,omitempty"` // A regular expression the value must match
+}
+
+// ClientIp resolves the ip of clients behind proxies, for every use of the
+// client ip. Without it, the client ip is the peer which connected to buz.
+type ClientIp struct {
+	Enabled        bool     `json:"enabled"`
+	TrustedProxies []string `json:"trustedProxies"`  // Ips or cidrs of the proxies in front of buz. No peer is trusted if empty.
+	Header         string   `json:"header"`          // X-Forwarded-For, X-Real-Ip, True-Client-Ip, or Cf-Connecting-Ip
+	Depth          int      `json:"depth,omitempty"` // Which X-Forwarded-For entry, counting from the right, is the client. One for a single proxy.
 }
 
 type Timeout struct {
@@ -19,39 +59,272 @@ type Timeout struct {
 }
 
 type RateLimiter struct {
-	Enabled bool   `json:"enabled"`
-	Period  string `json:"period"`
-	Limit   int64  `json:"limit"`
+	Enabled bool               `json:"enabled"`
+	Period  string             `json:"period"`
+	Limit   int64              `json:"limit"`
+	Store   string             `json:"store,omitempty"` // memory, or redis to share limits across instances
+	Redis   Redis              `json:"redis,omitempty"`
+	Key     string             `json:"key,omitempty"` // Limit by ip, apiKey, or tenant
+	Routes  []RouteRateLimiter `json:"routes,omitempty"`
+}
+
+// RouteRateLimiter replaces the global rate limit for an input, or for one
+// of its routes
+type RouteRateLimiter struct {
+	Input  string `json:"input"`
+	Path   string `json:"path,omitempty"` // One route of the input, rather than all of them
+	Period string `json:"period"`
+	Limit  int64  `json:"limit"`
+}
+
+// Quotas limit the events each api key or tenant may send per day or
+// month, in UTC. Zero quotas aren't enforced.
+type Quotas struct {
+	Enabled    bool            `json:"enabled"`
+	Key        string          `json:"key"` // apiKey or tenant
+	Daily      int64           `json:"daily"`
+	Monthly    int64           `json:"monthly"`
+	OnExceed   string          `json:"onExceed"`             // reject, or sample
+	SampleRate float64         `json:"sampleRate,omitempty"` // The fraction of events kept once a quota is exceeded, when sampling
+	Store      string          `json:"store,omitempty"`      // memory, or redis to share usage across instances
+	Redis      Redis           `json:"redis,omitempty"`
+	Overrides  []QuotaOverride `json:"overrides,omitempty"`
+}
+
+// QuotaOverride replaces the quotas of one api key or tenant
+type QuotaOverride struct {
+	Name    string `json:"name"`
+	Daily   int64  `json:"daily"`
+	Monthly int64  `json:"monthly"`
 }
 
 type Identity struct {
-	Cookie   IdentityCookie `json:"cookie"`
-	Fallback string         `json:"fallback"`
+	Cookie     IdentityCookie `json:"cookie"`
+	Cookieless Cookieless     `json:"cookieless"`
+	Fallback   string         `json:"fallback"`
+}
+
+// Cookieless derives a daily-rotating identity from the ip and user agent
+// of requests, instead of setting cookies
+type Cookieless struct {
+	Enabled bool   `json:"enabled"`
+	Salt    string `json:"-"` // Shared by every instance, so they derive the same identities
 }
 
 type IdentityCookie struct {
-	Enabled  bool   `json:"enabled"`
-	Name     string `json:"name"`
-	Secure   bool   `json:"secure"`
-	TtlDays  int    `json:"ttlDays"`
-	Domain   string `json:"domain"`
-	Path     string `json:"path"`
-	SameSite string `json:"sameSite"`
+	Enabled  bool     `json:"enabled"` // If disabled, existing cookies are read but never set
+	Name     string   `json:"name"`
+	Secure   bool     `json:"secure"`
+	HttpOnly bool     `json:"httpOnly,omitempty"`
+	TtlDays  int      `json:"ttlDays"`
+	Domain   string   `json:"domain"`            // The fallback domain, if the request host doesn't match any of the domains
+	Domains  []string `json:"domains,omitempty"` // The longest domain which the request host is, or is a subdomain of, is used
+	Path     string   `json:"path"`
+	SameSite string   `json:"sameSite"`
 }
 
 type Cors struct {
-	Enabled          bool     `json:"enabled"`
+	Enabled          bool        `json:"enabled"`
+	AllowOrigin      []string    `json:"allowOrigin"`
+	AllowCredentials bool        `json:"allowCredentials"`
+	AllowHeaders     []string    `json:"allowHeaders,omitempty"`
+	AllowMethods     []string    `json:"allowMethods"`
+	MaxAge           int         `json:"maxAge"`
+	Routes           []RouteCors `json:"routes,omitempty"`
+}
+
+// RouteCors replaces the cors policy for routes under its path prefixes
+type RouteCors struct {
+	Paths            []string `json:"paths"` // Path prefixes, like /c/
 	AllowOrigin      []string `json:"allowOrigin"`
 	AllowCredentials bool     `json:"allowCredentials"`
+	AllowHeaders     []string `json:"allowHeaders,omitempty"`
 	AllowMethods     []string `json:"allowMethods"`
 	MaxAge           int      `json:"maxAge"`
 }
 
+// RequestLogger writes an access log of json lines to stdout, or emits
+// them to a sink
 type RequestLogger struct {
-	Enabled bool `json:"enabled"`
+	Enabled    bool     `json:"enabled"`
+	Fields     []string `json:"fields,omitempty"`     // Defaults to time, latencyMs, status, method, route, path, clientIp, identity, requestBytes, and responseBytes
+	SampleRate float64  `json:"sampleRate,omitempty"` // The share of requests which are logged. Server errors are always logged. Defaults to 1
+	Sink       string   `json:"sink,omitempty"`       // The name of the sink receiving access log envelopes, instead of stdout
+}
+
+// SecurityHeaders are set on every response
+type SecurityHeaders struct {
+	Enabled        bool              `json:"enabled"`
+	Hsts           Hsts              `json:"hsts"`
+	ReferrerPolicy string            `json:"referrerPolicy,omitempty"`
+	FrameOptions   string            `json:"frameOptions,omitempty"` // DENY or SAMEORIGIN
+	Headers        map[string]string `json:"headers,omitempty"`      // Additional headers, or overrides. Empty values remove a header.
+}
+
+type Hsts struct {
+	Enabled           bool `json:"enabled"`
+	MaxAgeSeconds     int  `json:"maxAgeSeconds,omitempty"`
+	IncludeSubdomains bool `json:"includeSubdomains,omitempty"`
+	Preload           bool `json:"preload,omitempty"`
+}
+
+// AbuseDetection tracks the request rate of each client fingerprint, and
+// temporarily blocks fingerprints over the threshold
+type AbuseDetection struct {
+	Enabled         bool  `json:"enabled"`
+	WindowSeconds   int   `json:"windowSeconds,omitempty"`
+	BlockThreshold  int64 `json:"blockThreshold,omitempty"` // Requests per window which block a fingerprint. Zero only tracks rates.
+	BlockSeconds    int   `json:"blockSeconds,omitempty"`
+	TopTalkers      int   `json:"topTalkers,omitempty"`      // How many fingerprints the ops endpoint returns
+	MaxFingerprints int   `json:"maxFingerprints,omitempty"` // The least recently seen fingerprints are forgotten beyond this
 }
 
 type Auth struct {
-	Enabled bool     `json:"enabled"`
-	Tokens  []string `json:"-"`
+	Enabled     bool        `json:"enabled"`
+	Tokens      []string    `json:"-"` // Unnamed keys, which may use every input
+	ApiKeys     []ApiKey    `json:"apiKeys"`
+	ApiKeyStore ApiKeyStore `json:"apiKeyStore"`
+	Jwt         Jwt         `json:"jwt"`
+	Oidc        Oidc        `json:"oidc"`
+	Hmac        Hmac        `json:"hmac"`
+	Replays     Replays     `json:"replays"`
+	Basic       []BasicUser `json:"basic,omitempty"`   // Basic auth credentials, for webhook providers which only support basic auth
+	Bearer      []Bearer    `json:"bearer,omitempty"`  // Named static bearer tokens
+	Methods     []string    `json:"methods,omitempty"` // apiKey, basic, bearer, jwt, or hmac. Every method if empty.
+	Routes      []RouteAuth `json:"routes,omitempty"`
+}
+
+type BasicUser struct {
+	Username string `json:"username"`
+	Password string `json:"-"`
+}
+
+type Bearer struct {
+	Name  string `json:"name"`
+	Token string `json:"-"`
+}
+
+// RouteAuth replaces the auth methods accepted by routes under its path
+// prefixes
+type RouteAuth struct {
+	Paths   []string `json:"paths"` // Path prefixes, like /webhook
+	Methods []string `json:"methods"`
+}
+
+// Replays rejects signed requests and jwt requests which were already used
+type Replays struct {
+	Enabled      bool   `json:"enabled"`
+	Store        string `json:"store,omitempty"` // memory, or redis to reject replays across instances
+	Redis        Redis  `json:"redis,omitempty"`
+	SingleUseJti bool   `json:"singleUseJti,omitempty"` // Treat jwts with a jti claim as single-use until they expire, for clients which mint a token per request
+	NonceClaim   string `json:"nonceClaim,omitempty"`   // The jwt claim of a per-request nonce. Defaults to nonce
+	TtlSeconds   int    `json:"ttlSeconds,omitempty"`   // How long nonces of jwts without an exp claim are remembered
+	RequireNonce bool   `json:"requireNonce,omitempty"` // Reject jwt requests without a nonce claim, or a jti claim when it is single-use
+}
+
+// Hmac authenticates requests signed with the secret of a client
+type Hmac struct {
+	Enabled          bool         `json:"enabled"`
+	Header           string       `json:"header"`
+	ToleranceSeconds int          `json:"toleranceSeconds"`
+	Clients          []HmacClient `json:"clients"`
+}
+
+type HmacClient struct {
+	Id     string `json:"id"`
+	Secret string `json:"-"`
+}
+
+// ApiKey is a named bearer token, with what it may be used for
+type ApiKey struct {
+	Name        string      `json:"name"`
+	Key         string      `json:"-"`
+	Disabled    bool        `json:"disabled,omitempty"`
+	Expires     string      `json:"expires,omitempty"` // An RFC3339 time or date
+	Tenant      string      `json:"tenant,omitempty"`
+	Inputs      []string    `json:"inputs,omitempty"` // Input names, or all inputs if empty
+	RateLimiter RateLimiter `json:"rateLimiter,omitempty"`
+}
+
+// ApiKeyStore loads api keys from a json file or url, alongside those
+// which are configured, and reloads them periodically
+type ApiKeyStore struct {
+	Enabled        bool   `json:"enabled"`
+	Type           string `json:"type"` // file or http
+	Path           string `json:"path"` // The file path or url
+	RefreshSeconds int    `json:"refreshSeconds"`
+}
+
+// Oidc authenticates operators of the ops and registry routes with an
+// OpenID Connect provider, instead of with auth tokens
+type Oidc struct {
+	Enabled        bool     `json:"enabled"`
+	Issuer         string   `json:"issuer"`
+	ClientId       string   `json:"clientId"`
+	ClientSecret   string   `json:"-"`
+	RedirectUrl    string   `json:"redirectUrl"` // The public url of the callback route
+	Scopes         []string `json:"scopes"`
+	AllowedDomains []string `json:"allowedDomains"` // Verified email domains of operators, or any if empty
+}
+
+// Jwt validates bearer tokens signed by a key of the JWKS
+type Jwt struct {
+	Enabled          bool       `json:"enabled"`
+	JwksUrl          string     `json:"jwksUrl"`
+	JwksCacheSeconds int        `json:"jwksCacheSeconds"`
+	Issuer           string     `json:"issuer"`
+	Audiences        []string   `json:"audiences"`
+	ClockSkewSeconds int        `json:"clockSkewSeconds"`
+	Cache            TokenCache `json:"cache"`
+}
+
+// TokenCache remembers valid tokens for a short while, so that requests
+// with the same token aren't verified again
+type TokenCache struct {
+	Enabled    bool `json:"enabled"`
+	TtlSeconds int  `json:"ttlSeconds"` // Tokens are also forgotten once they expire
+	MaxEntries int  `json:"maxEntries"`
+}
+
+// BotFilter rejects requests to inputs which match a rule, before their
+// envelopes are built
+type BotFilter struct {
+	Enabled bool      `json:"enabled"`
+	AsnPath string    `json:"asnPath,omitempty"` // A maxmind asn database, for rules by asn
+	Rules   []BotRule `json:"rules"`
+}
+
+// BotRule matches requests which meet all of its conditions
+type BotRule struct {
+	Name          string `json:"name"`
+	UserAgent     string `json:"userAgent,omitempty"` // A regular expression
+	Asns          []uint `json:"asns,omitempty"`
+	Header        string `json:"header,omitempty"`
+	HeaderValue   string `json:"headerValue,omitempty"` // A regular expression, or any value of the header if empty
+	MissingHeader string `json:"missingHeader,omitempty"`
+}
+
+type Tenancy struct {
+	Enabled       bool     `json:"enabled"`
+	ApiKeyHeader  string   `json:"apiKeyHeader"`
+	Header        string   `json:"header,omitempty"` // Trust a header naming the tenant, such as from an upstream gateway
+	Claim         string   `json:"claim,omitempty"`  // The jwt claim naming the tenant
+	DefaultTenant string   `json:"defaultTenant,omitempty"`
+	Tenants       []Tenant `json:"tenants"`
+}
+
+type Tenant struct {
+	Name    string   `json:"name"`
+	ApiKeys []string `json:"-"`
+	Domains []string `json:"domains,omitempty"`
+}
+
+// CircuitBreaker fast-fails requests to inputs while the collector is
+// overloaded. Thresholds which are zero aren't checked.
+type CircuitBreaker struct {
+	Enabled          bool `json:"enabled"`
+	MaxInFlight      int  `json:"maxInFlight"`
+	MaxQueuedBatches int  `json:"maxQueuedBatches"`
+	MaxP99LatencyMs  int  `json:"maxP99LatencyMs"`
+	CooldownMs       int  `json:"cooldownMs"` // How long the breaker stays open once tripped
 }
@@ -0,0 +1,13 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// Privacy is how an input handles requests with Do Not Track or Global
+// Privacy Control signals
+type Privacy struct {
+	Dnt    string   `json:"dnt,omitempty"`    // ignore, strip, or drop
+	Gpc    string   `json:"gpc,omitempty"`    // ignore, strip, or drop
+	Fields []string `json:"fields,omitempty"` // Payload fields to strip, in addition to the default identifiers
+}
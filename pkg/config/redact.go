@@ -0,0 +1,148 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const REDACTED string = "********"
+
+// Keys whose string values are always redacted, in addition to fields
+// tagged `secret:"true"` and any configured patterns
+var DEFAULT_REDACT_PATTERNS = []string{
+	`(?i)pass(word|wd)?$`,
+	`(?i)secret`,
+	`(?i)token$`,
+	`(?i)dsn$`,
+	`(?i)credential`,
+	`(?i)private`,
+}
+
+// Redact returns the value as json-like maps and slices, with the values of
+// secret fields and keys matching a pattern masked. Passwords in urls are
+// masked wherever they appear. Fields are named, omitted, and hidden by
+// their json tags, like when they are marshalled.
+func Redact(v interface{}, patterns []string) (interface{}, error) {
+	var keys []*regexp.Regexp
+	for _, p := range append(DEFAULT_REDACT_PATTERNS, patterns...) {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %s: %w", p, err)
+		}
+		keys = append(keys, re)
+	}
+	r := redactor{keys: keys}
+	return r.value(reflect.ValueOf(v), false), nil
+}
+
+type redactor struct {
+	keys []*regexp.Regexp
+}
+
+func (r redactor) secretKey(key string) bool {
+	for _, re := range r.keys {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// value converts v, masking its strings if it is secret
+func (r redactor) value(v reflect.Value, secret bool) interface{} {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return r.value(v.Elem(), secret)
+	case reflect.String:
+		s := v.String()
+		if secret && s != "" {
+			return REDACTED
+		}
+		return redactUrl(s)
+	case reflect.Struct:
+		if _, ok := v.Interface().(json.Marshaler); ok {
+			return v.Interface()
+		}
+		out := make(map[string]interface{})
+		r.fields(v, out)
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key().Interface())
+			out[key] = r.value(iter.Value(), secret || r.secretKey(key))
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = r.value(v.Index(i), secret)
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+// fields adds the struct's fields to out, inlining embedded structs
+// without a json name like encoding/json does
+func (r redactor) fields(v reflect.Value, out map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			r.fields(fv, out)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(opts, "omitempty") && fv.IsZero() {
+			continue
+		}
+		out[name] = r.value(fv, f.Tag.Get("secret") == "true" || r.secretKey(name))
+	}
+}
+
+// redactUrl masks the password of urls with credentials, such as dsns
+func redactUrl(s string) string {
+	if !strings.Contains(s, "://") || !strings.Contains(s, "@") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	return strings.Replace(u.Redacted(), ":xxxxx@", ":"+REDACTED+"@", 1)
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	conf := Config{
+		Sinks: []Sink{{Name: "warehouse", Url: "postgres://buz:hunter2@db:5432/events", Password: "hunter2", PubnubPubKey: "pub-c-123"}},
+	}
+	conf.Middleware.Quotas.Redis = Redis{Addr: "localhost:6379", Password: "r3dis"}
+	conf.Middleware.SecurityHeaders.Headers = map[string]string{"X-Upstream-Token": "t0ken", "X-Frame-Options": "DENY"}
+	conf.Middleware.Auth.Hmac.Clients = []HmacClient{{Id: "billing", Secret: "s3cret"}}
+	conf.App.Name = "buz"
+	conf.App.TrackerDomain = "example.com"
+
+	v, err := Redact(conf, []string{"(?i)^trackerDomain$"})
+	assert.Nil(t, err)
+	redacted := v.(map[string]interface{})
+
+	sink := redacted["sinks"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "warehouse", sink["name"])
+	assert.Equal(t, "postgres://buz:"+REDACTED+"@db:5432/events", sink["url"])
+	assert.Equal(t, REDACTED, sink["pubnubPubKey"])
+	assert.NotContains(t, sink, "password")
+
+	middleware := redacted["middleware"].(map[string]interface{})
+	redis := middleware["quotas"].(map[string]interface{})["redis"].(map[string]interface{})
+	assert.Equal(t, "localhost:6379", redis["addr"])
+	assert.Equal(t, REDACTED, redis["password"])
+	headers := middleware["securityHeaders"].(map[string]interface{})["headers"].(map[string]interface{})
+	assert.Equal(t, REDACTED, headers["X-Upstream-Token"])
+	assert.Equal(t, "DENY", headers["X-Frame-Options"])
+
+	app := redacted["app"].(map[string]interface{})
+	assert.Equal(t, "buz", app["name"])
+	assert.Equal(t, REDACTED, app["trackerDomain"])
+}
+
+func TestRedactInvalidPattern(t *testing.T) {
+	_, err := Redact(Config{}, []string{"("})
+	assert.NotNil(t, err)
+}
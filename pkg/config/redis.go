@@ -0,0 +1,13 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+type Redis struct {
+	Addr     string `json:"addr"` // host:port
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty" secret:"true"`
+	Db       int    `json:"db"`
+	Prefix   string `json:"prefix,omitempty"` // Prepended to keys, so instances can share a database
+}
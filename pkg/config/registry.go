@@ -12,15 +12,71 @@ type Purge struct {
 	Enabled bool `json:"enabled"`
 }
 
+type Refresh struct {
+	Enabled         bool  `json:"enabled"`
+	IntervalSeconds int   `json:"intervalSeconds"`
+	MinHits         int64 `json:"minHits"`
+}
+
+type Negative struct {
+	Enabled    bool `json:"enabled"`
+	TtlSeconds int  `json:"ttlSeconds"`
+}
+
+type Introspection struct {
+	Enabled bool `json:"enabled"`
+}
+
+// VendorResolution controls how dynamic schema versions (`latest` and ranges
+// such as `1-0-*`) resolve for schemas beginning with the vendor prefix.
+type VendorResolution struct {
+	Vendor string `json:"vendor"`
+	Policy string `json:"policy"`        // exact, range, or latest
+	Pin    string `json:"pin,omitempty"` // range that `latest` resolves within, such as `1-*`
+}
+
+type Resolution struct {
+	Enabled bool               `json:"enabled"`
+	Vendors []VendorResolution `json:"vendors,omitempty"`
+}
+
+type Docs struct {
+	Enabled bool `json:"enabled"`
+}
+
+type Snapshot struct {
+	Enabled bool `json:"enabled"`
+}
+
+type Publish struct {
+	Enabled bool `json:"enabled"`
+}
+
 type Backend struct {
+	Name string `json:"name,omitempty"`
 	Type string `json:"type"`
 	Path string `json:"path"`
+	// File
+	Watch bool `json:"watch,omitempty"`
+	// Chained backends are consulted in ascending priority order, and
+	// optionally only for schemas beginning with one of the vendor prefixes.
+	Priority       int      `json:"priority,omitempty"`
+	VendorPrefixes []string `json:"vendorPrefixes,omitempty"`
 	// S3 and Gcs
-	Bucket string `json:"bucket,omitempty"`
-	// Gcs
+	Bucket     string `json:"bucket,omitempty"`
+	LocalCache bool   `json:"localCache,omitempty"`
+	// Gcs and S3
 	Region string `json:"region,omitempty"`
+	// Gcs
+	CredentialsFile string `json:"-"`
 	// Http
-	Host string `json:"host,omitempty"`
+	Host           string            `json:"host,omitempty"`
+	Headers        map[string]string `json:"-"`
+	BearerToken    string            `json:"-"`
+	ClientCertFile string            `json:"clientCertFile,omitempty"`
+	ClientKeyFile  string            `json:"-"`
+	CaCertFile     string            `json:"caCertFile,omitempty"`
+	TimeoutMs      int               `json:"timeoutMs,omitempty"`
 	// Db, general
 	RegistryTable string `json:"registryTable,omitempty"`
 	// Postgres Database
@@ -39,13 +95,34 @@ type Backend struct {
 	// Minio
 	MinioEndpoint   string `json:"minioEndpoint,omitempty"`
 	AccessKeyId     string `json:"accessKeyId,omitempty"`
-	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	SecretAccessKey string `json:"secretAccessKey,omitempty" secret:"true"`
+	// Git
+	GitRepo                string `json:"gitRepo,omitempty"`
+	GitRef                 string `json:"gitRef,omitempty"`
+	GitSshKeyFile          string `json:"-"`
+	GitCloneDir            string `json:"gitCloneDir,omitempty"`
+	GitSyncIntervalSeconds int    `json:"gitSyncIntervalSeconds,omitempty"`
+	// Confluent Schema Registry
+	RegistryUrl           string `json:"registryUrl,omitempty"`
+	SubjectNamingStrategy string `json:"subjectNamingStrategy,omitempty"`
+	SubjectSuffix         string `json:"subjectSuffix,omitempty"`
+	ApiKey                string `json:"-"`
+	ApiSecret             string `json:"-"`
 }
 
 type Registry struct {
-	Backend      `json:"backend"`
-	TtlSeconds   int `json:"ttlSeconds"`
-	MaxSizeBytes int `json:"maxSizeBytes"`
-	Purge        `json:"purge"`
-	Http         `json:"http"`
+	Backend       `json:"backend"`
+	Backends      []Backend `json:"backends,omitempty"`
+	TtlSeconds    int       `json:"ttlSeconds"`
+	MaxSizeBytes  int       `json:"maxSizeBytes"`
+	MaxSchemas    int       `json:"maxSchemas"`
+	Refresh       `json:"refresh"`
+	Negative      `json:"negative"`
+	Resolution    `json:"resolution"`
+	Purge         `json:"purge"`
+	Http          `json:"http"`
+	Publish       `json:"publish"`
+	Introspection `json:"introspection"`
+	Docs          `json:"docs"`
+	Snapshot      `json:"snapshot"`
 }
@@ -0,0 +1,23 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// Replay re-delivers envelopes archived by sinks to the sinks named in
+// each replay request, so downstream tables can be rebuilt
+type Replay struct {
+	Enabled   bool      `json:"enabled"`
+	BatchSize int       `json:"batchSize,omitempty"`
+	Archives  []Archive `json:"archives"`
+}
+
+// Archive is a location sinks write newline delimited envelopes to
+type Archive struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // file, s3, or gcs
+	Path string `json:"path"` // The directory, or the object key prefix
+	// S3 and GCS
+	Bucket string `json:"bucket,omitempty"`
+	Region string `json:"region,omitempty"`
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// RetentionRule sets the retention class for schemas beginning with the prefix
+type RetentionRule struct {
+	Prefix string `json:"prefix"`
+	Class  string `json:"class"` // Such as 30d, 13mo, or forever
+}
+
+// Retention stamps envelopes with the retention class of their schema, so
+// sinks can route them to storage which enforces it
+type Retention struct {
+	Default string          `json:"default,omitempty"` // The class of schemas without a rule. Unstamped if empty
+	Rules   []RetentionRule `json:"rules,omitempty"`
+}
@@ -19,4 +19,6 @@ type SelfDescribing struct {
 	Path     string                           `json:"path"`
 	Contexts SelfDescribingRootConfig         `json:"contexts"`
 	Payload  SelfDescribingRootAndChildConfig `json:"payload"`
+	Capture  Capture                          `json:"capture,omitempty"`
+	Privacy  Privacy                          `json:"privacy,omitempty"`
 }
@@ -0,0 +1,21 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// InvalidSpike reports when the share of invalid envelopes between
+// heartbeats is over the rate
+type InvalidSpike struct {
+	Rate         float64 `json:"rate,omitempty"`         // Defaults to 0.1
+	MinEnvelopes int64   `json:"minEnvelopes,omitempty"` // Spikes of fewer envelopes are ignored. Defaults to 100
+}
+
+// SelfTracking emits the collector's own operational events as envelopes
+// to one of its sinks
+type SelfTracking struct {
+	Enabled          bool         `json:"enabled"`
+	Sink             string       `json:"sink"` // The name of the sink receiving collector events
+	HeartbeatSeconds int          `json:"heartbeatSeconds,omitempty"`
+	InvalidSpike     InvalidSpike `json:"invalidSpike,omitempty"`
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// Sentry reports panics, sink failures, and registry errors to sentry,
+// tagged with the schema, input, and sink involved
+type Sentry struct {
+	Enabled       bool    `json:"enabled"`
+	Dsn           string  `json:"dsn,omitempty" secret:"true"`
+	Environment   string  `json:"environment,omitempty"`   // Defaults to the app env
+	SampleRate    float64 `json:"sampleRate,omitempty"`    // Defaults to 1
+	DedupeSeconds int     `json:"dedupeSeconds,omitempty"` // The same error is reported at most once per window. Defaults to 60
+	TimeoutMs     int     `json:"timeoutMs,omitempty"`
+}
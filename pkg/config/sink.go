@@ -4,12 +4,53 @@
 
 package config
 
+// Retry controls how deliveries which fail are retried, backing off
+// exponentially between attempts. Retries resend the whole batch, unless
+// the sink reports which envelopes failed, so envelopes the sink delivered
+// before failing may be duplicated.
+type Retry struct {
+	MaxAttempts      int      `json:"maxAttempts"` // Including the first attempt. Defaults to one, which disables retries
+	InitialBackoffMs int      `json:"initialBackoffMs"`
+	MaxBackoffMs     int      `json:"maxBackoffMs"`
+	Multiplier       float64  `json:"multiplier"`
+	Jitter           float64  `json:"jitter"`       // Fraction of each backoff which is randomized, from 0 to 1
+	NonRetryable     []string `json:"nonRetryable"` // Errors containing any of these are not retried
+}
+
+// RateLimit caps the rate envelopes are delivered to a sink, for
+// destinations which enforce ingest quotas. Zero disables a limit.
+type RateLimit struct {
+	EventsPerSecond float64 `json:"eventsPerSecond"`
+	BytesPerSecond  float64 `json:"bytesPerSecond"`
+	BurstEvents     int     `json:"burstEvents"` // Defaults to one second of events
+	BurstBytes      int     `json:"burstBytes"`  // Defaults to one second of bytes
+}
+
 type Sink struct {
-	Name             string `json:"name"`
-	Type             string `json:"type"`
-	DeliveryRequired bool   `json:"deliveryRequired"`
-	DefaultOutput    string `json:"defaultOutput"`
-	DeadletterOutput string `json:"deadletterOutput"`
+	Name             string    `json:"name"`
+	Type             string    `json:"type"`
+	DeliveryRequired bool      `json:"deliveryRequired"`
+	DefaultOutput    string    `json:"defaultOutput"`
+	DeadletterOutput string    `json:"deadletterOutput"`
+	Workers          int       `json:"workers,omitempty"`      // Overrides the worker pool manifold concurrency
+	BatchSize        int       `json:"batchSize,omitempty"`    // Overrides the batching manifold batch size
+	LingerMs         int       `json:"lingerMs,omitempty"`     // Overrides the batching manifold linger
+	IdleMs           int       `json:"idleMs,omitempty"`       // Overrides the batching manifold idle timeout
+	Filter           string    `json:"filter,omitempty"`       // Cel expression selecting the envelopes delivered to the sink
+	PartitionKey     string    `json:"partitionKey,omitempty"` // Json pointer or template of pointers deriving the partition or ordering key
+	Format           string    `json:"format,omitempty"`       // Output format of streaming sinks - json (default), avro, or protobuf
+	Compression      string    `json:"compression,omitempty"`  // Compression of kafka batches and http bodies - gzip, zstd, or snappy
+	Retry            Retry     `json:"retry,omitempty"`
+	RateLimit        RateLimit `json:"rateLimit,omitempty"`
+	OnPoison         string    `json:"onPoison,omitempty"` // quarantine, deadletter, or drop envelopes the sink fails to deliver permanently
+	// Routing
+	Schemas        []string `json:"schemas,omitempty"`        // Schema prefixes delivered to the sink. Empty delivers every schema
+	ExcludeSchemas []string `json:"excludeSchemas,omitempty"` // Schema prefixes never delivered to the sink
+	Receives       string   `json:"receives,omitempty"`       // all, valid, or invalid envelopes
+	// Schema prefixes of invalid envelopes delivered to, or never delivered to, the sink. These
+	// replace schemas and excludeSchemas for invalid envelopes if either is set
+	InvalidSchemas        []string `json:"invalidSchemas,omitempty"`
+	InvalidExcludeSchemas []string `json:"invalidExcludeSchemas,omitempty"`
 	// GCP
 	Project string `json:"project,omitempty"`
 	// Kafka
@@ -27,6 +68,6 @@ type Sink struct {
 	User     string   `json:"-"`
 	Password string   `json:"-"`
 	// Pubnub
-	PubnubPubKey string `json:"pubnubPubKey,omitempty"`
-	PubnubSubKey string `json:"pubnubSubKey,omitempty"`
+	PubnubPubKey string `json:"pubnubPubKey,omitempty" secret:"true"`
+	PubnubSubKey string `json:"pubnubSubKey,omitempty" secret:"true"`
 }
@@ -0,0 +1,15 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// Statsd pushes counters and timers to a StatsD or DogStatsD agent
+type Statsd struct {
+	Enabled         bool              `json:"enabled"`
+	Addr            string            `json:"addr"`             // Such as localhost:8125
+	Prefix          string            `json:"prefix,omitempty"` // Defaults to buz.
+	Format          string            `json:"format,omitempty"` // dogstatsd or influx. Defaults to dogstatsd
+	Tags            map[string]string `json:"tags,omitempty"`   // Added to every metric
+	FlushIntervalMs int               `json:"flushIntervalMs,omitempty"`
+}
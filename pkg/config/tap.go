@@ -0,0 +1,14 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// Tap streams a sampled copy of envelopes to developers as they arrive
+type Tap struct {
+	Enabled        bool    `json:"enabled"`
+	SampleRate     float64 `json:"sampleRate,omitempty"`     // The rate of streams which don't request one. Defaults to 1
+	MaxSubscribers int     `json:"maxSubscribers,omitempty"` // Defaults to 5
+	BufferSize     int     `json:"bufferSize,omitempty"`     // Envelopes buffered per stream before they are dropped. Defaults to 100
+	MaxSeconds     int     `json:"maxSeconds,omitempty"`     // Streams are closed after this long. Defaults to 3600
+}
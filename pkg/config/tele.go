@@ -4,7 +4,12 @@
 
 package config
 
+// Tele sends usage telemetry. Every event includes the collector's
+// version, name, instance id, start time, and tracker and cookie domains.
+// The startup event also includes the config, with secrets redacted.
 type Tele struct {
-	Enabled bool   `json:"enabled,omitempty"`
-	Host    string `json:"host,omitempty"`
+	Enabled   bool     `json:"enabled,omitempty"`
+	Endpoint  string   `json:"endpoint,omitempty"` // Defaults to https://tele.buz.dev/self-describing. Any buz collector's /self-describing route accepts telemetry
+	Disabled  []string `json:"disabled,omitempty"` // Categories which are not sent: startup, config, heartbeat, and/or shutdown
+	TimeoutMs int      `json:"timeoutMs,omitempty"`
 }
@@ -0,0 +1,93 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// Transform is a stage of the transformation pipeline, which envelopes
+// pass through in order after they are validated.
+type Transform struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Schemas   []string `json:"schemas,omitempty"`   // Schema prefixes the transform applies to. Empty applies to every schema
+	Protocols []string `json:"protocols,omitempty"` // Protocols the transform applies to. Empty applies to every protocol
+	TimeoutMs int      `json:"timeoutMs"`
+	OnError   string   `json:"onError"` // pass, drop, or invalid
+	// Ip, geoip, and useragent
+	Headers  []string `json:"headers,omitempty"`  // Headers of the http headers context the transform reads
+	Pointers []string `json:"pointers,omitempty"` // Json pointers to payload fields the transform reads
+	// Wasm, lua, geoip, and bot
+	Path string `json:"path,omitempty"`
+	// Lua
+	Script string `json:"script,omitempty"` // Inline alternative to a script path
+	// Cel
+	Expression string `json:"expression,omitempty"` // Envelopes the expression is false for are dropped
+	// Pii and bot
+	Detectors []PiiDetector `json:"detectors,omitempty"`
+	Action    string        `json:"action,omitempty"` // mask, redact, or drop pii. tag or drop bots
+	// Hash
+	Fields []HashField `json:"fields,omitempty"`
+	Salt   string      `json:"salt,omitempty" secret:"true"`   // Prepended to values before they are hashed or sampled
+	Pepper string      `json:"pepper,omitempty" secret:"true"` // Hmac key for hashed values, which should be kept out of the warehouse
+	Key    string      `json:"key,omitempty" secret:"true"`    // Base64 encoded 16, 24, or 32 byte aes key for encrypted values
+	// Ip
+	Valid   string `json:"valid,omitempty"`   // keep, truncate, hash, or drop ips of valid envelopes
+	Invalid string `json:"invalid,omitempty"` // keep, truncate, hash, or drop ips of invalid envelopes
+	// Geoip
+	AsnPath                string `json:"asnPath,omitempty"`                  // Optional asn database, alongside the city database at path
+	AccountId              string `json:"accountId,omitempty"`                // Maxmind account id of the license key
+	LicenseKey             string `json:"licenseKey,omitempty" secret:"true"` // Maxmind license key, to download the databases to their paths
+	Edition                string `json:"edition,omitempty"`                  // Defaults to GeoLite2-City
+	AsnEdition             string `json:"asnEdition,omitempty"`               // Defaults to GeoLite2-ASN
+	RefreshIntervalSeconds int    `json:"refreshIntervalSeconds,omitempty"`
+	// Useragent
+	CacheSize int `json:"cacheSize,omitempty"` // Parsed user agents are cached by user agent
+	// Attribution
+	UrlPointers      []string `json:"urlPointers,omitempty"`      // Payload fields containing the page url, before the Referer header
+	ReferrerPointers []string `json:"referrerPointers,omitempty"` // Payload fields containing the page referrer
+	InternalDomains  []string `json:"internalDomains,omitempty"`  // Referrers from these domains and their subdomains are internal
+	// Session, dedup, and consent
+	Store string `json:"store,omitempty"` // memory or redis
+	Redis Redis  `json:"redis"`
+	// Session
+	Cookie                   string `json:"cookie,omitempty"` // Cookie containing the device id, if payload fields don't
+	InactivityTimeoutSeconds int    `json:"inactivityTimeoutSeconds,omitempty"`
+	// Dedup and consent
+	TtlSeconds int `json:"ttlSeconds,omitempty"` // How long ids, or the consent of devices, are remembered
+	// Dedup
+	MaxIds int `json:"maxIds,omitempty"` // Ids the memory store remembers. Those claimed longest ago are forgotten first
+	// Sample
+	Rates []SampleRate `json:"rates,omitempty"`
+	// Consent
+	ConsentPointer     string        `json:"consentPointer,omitempty"`     // Json pointer to the consent of the envelope, such as /consent
+	JurisdictionHeader string        `json:"jurisdictionHeader,omitempty"` // Header of the http headers context with the jurisdiction, such as CF-IPCountry
+	MaxDevices         int           `json:"maxDevices,omitempty"`         // Devices whose consent the memory store remembers. Those set longest ago are forgotten first
+	ConsentRules       []ConsentRule `json:"consentRules,omitempty"`
+}
+
+// ConsentRule decides what happens to envelopes without consent to a
+// purpose
+type ConsentRule struct {
+	Purpose       string   `json:"purpose"`
+	Schemas       []string `json:"schemas,omitempty"`       // Schema prefixes the rule applies to. Empty applies to every schema
+	Jurisdictions []string `json:"jurisdictions,omitempty"` // Jurisdictions the rule applies to. Empty applies to every jurisdiction
+	Action        string   `json:"action"`                  // keep, pseudonymize, or drop
+}
+
+type SampleRate struct {
+	Schema string  `json:"schema"` // Schema prefix, such as a vendor. The longest matching prefix applies
+	Rate   float64 `json:"rate"`   // Fraction of envelopes kept, from 0 to 1
+}
+
+type HashField struct {
+	Pointer string `json:"pointer"`          // Json pointer to the payload field, such as /user/email
+	Method  string `json:"method,omitempty"` // hash or encrypt
+}
+
+type PiiDetector struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`              // regex, fields, email, phone, or creditCard
+	Pattern string   `json:"pattern,omitempty"` // Regex
+	Fields  []string `json:"fields,omitempty"`  // Field names, which match case-insensitively at any depth
+	Action  string   `json:"action,omitempty"`  // Overrides the transform action
+}
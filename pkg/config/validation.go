@@ -0,0 +1,16 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// ValidationRule sets the validation mode for schemas beginning with the prefix
+type ValidationRule struct {
+	Prefix string `json:"prefix"`
+	Mode   string `json:"mode"` // enforce, warn, or skip
+}
+
+type Validation struct {
+	Mode  string           `json:"mode"`
+	Rules []ValidationRule `json:"rules,omitempty"`
+}
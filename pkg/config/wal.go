@@ -0,0 +1,16 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+// Wal persists accepted envelopes to disk before requests are acked, so
+// envelopes which haven't been delivered when the process dies are
+// replayed on startup.
+type Wal struct {
+	Enabled         bool   `json:"enabled"`
+	Path            string `json:"path"`
+	Fsync           string `json:"fsync"` // always, interval, or never
+	FsyncIntervalMs int    `json:"fsyncIntervalMs"`
+	SegmentBytes    int64  `json:"segmentBytes"`
+}
@@ -30,6 +30,10 @@ const (
 	STDOUT    string = "stdout"
 	BLACKHOLE string = "blackhole"
 	FILE      string = "file"
+	EMBEDDED  string = "embedded"
+	SNAPSHOT  string = "snapshot"
+	// Source Control
+	GIT string = "git"
 	// Web
 	HTTP  string = "http"
 	HTTPS string = "https"
@@ -39,4 +43,5 @@ const (
 	PUBNUB     string = "pubnub"
 	IGLU       string = "iglu"
 	SPLUNK     string = "splunk"
+	CONFLUENT  string = "confluent"
 )
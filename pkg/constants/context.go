@@ -5,3 +5,22 @@
 package constants
 
 const IDENTITY string = "identity"
+
+// The key under which the authenticated caller of an authenticated route is stored
+const AUTH_IDENTITY string = "authIdentity"
+
+// The key under which requests whose client ip must not be stored, such
+// as those of cookieless identities, are marked
+const WITHHOLD_CLIENT_IP string = "withholdClientIp"
+
+// The key under which the tenant of a request is stored
+const TENANT string = "tenant"
+
+// The key under which the verified claims of a jwt bearer token are stored
+const AUTH_CLAIMS string = "authClaims"
+
+// The key under which the api key of an authenticated request is stored
+const AUTH_API_KEY string = "authApiKey"
+
+// The key under which the quota of a request is stored
+const QUOTA string = "quota"
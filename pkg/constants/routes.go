@@ -2,6 +2,7 @@ package constants
 
 const (
 	STATS_PATH                      = "/stats"
+	INPUT_STATS_PATH                = "/stats/inputs"
 	HEALTH_PATH                     = "/health"
 	ROUTE_OVERVIEW_PATH             = "/routes"
 	CONFIG_OVERVIEW_PATH            = "/config"
@@ -0,0 +1,45 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package db
+
+import (
+	"errors"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// PutSchema creates the named schema in the registry table, or replaces
+// its contents if it already exists.
+func PutSchema(gormDb *gorm.DB, tableName string, name string, contents []byte) error {
+	var s RegistryTable
+	result := gormDb.Table(tableName).Where("name = ?", name).First(&s)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		log.Error().Err(result.Error).Msg("🔴 could not look up schema " + name)
+		return result.Error
+	}
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		s = RegistryTable{Name: name, Contents: datatypes.JSON(contents)}
+		result = gormDb.Table(tableName).Create(&s)
+	} else {
+		result = gormDb.Table(tableName).Where("name = ?", name).Update("contents", datatypes.JSON(contents))
+	}
+	if result.Error != nil {
+		log.Error().Err(result.Error).Msg("🔴 could not write schema " + name)
+		return result.Error
+	}
+	return nil
+}
+
+// DeleteSchema removes the named schema from the registry table
+func DeleteSchema(gormDb *gorm.DB, tableName string, name string) error {
+	result := gormDb.Table(tableName).Where("name = ?", name).Delete(&RegistryTable{})
+	if result.Error != nil {
+		log.Error().Err(result.Error).Msg("🔴 could not delete schema " + name)
+		return result.Error
+	}
+	return nil
+}
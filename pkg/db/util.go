@@ -5,12 +5,17 @@
 package db
 
 import (
+	"context"
+	"sync"
+
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
 
 // EnsureTable creates a table according to the specified model if it
-// does not already exist.
+// does not already exist. Existing tables are given any columns the model
+// has gained since they were created, so inserts don't fail after an
+// upgrade adds envelope fields.
 func EnsureTable(gormDb *gorm.DB, tableName string, model interface{}) error {
 	tblExists := gormDb.Migrator().HasTable(tableName)
 	if !tblExists {
@@ -21,6 +26,62 @@ func EnsureTable(gormDb *gorm.DB, tableName string, model interface{}) error {
 		}
 	} else {
 		log.Debug().Msg("🟡 " + tableName + " table already exists - not creating")
+		if err := addMissingColumns(gormDb, tableName, model); err != nil {
+			log.Error().Err(err).Msg("🔴 could not add missing columns to " + tableName + " table")
+			return err
+		}
+	}
+	return nil
+}
+
+// addMissingColumns adds the columns of the model the table doesn't have.
+// Existing columns are never altered or dropped.
+func addMissingColumns(gormDb *gorm.DB, tableName string, model interface{}) error {
+	stmt := &gorm.Statement{DB: gormDb}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	migrator := gormDb.Table(tableName).Migrator()
+	for _, column := range stmt.Schema.DBNames {
+		if migrator.HasColumn(model, column) {
+			continue
+		}
+		log.Info().Msg("🟢 adding " + column + " column to " + tableName + " table")
+		if err := migrator.AddColumn(model, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tables ensures each table at most once, so tables of templated outputs
+// can be created as they are first delivered to
+type Tables struct {
+	mu      sync.Mutex
+	ensured map[string]bool
+}
+
+func (t *Tables) Ensure(gormDb *gorm.DB, tableName string, model interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ensured[tableName] {
+		return nil
 	}
+	if err := EnsureTable(gormDb, tableName, model); err != nil {
+		return err
+	}
+	if t.ensured == nil {
+		t.ensured = make(map[string]bool)
+	}
+	t.ensured[tableName] = true
 	return nil
 }
+
+// Ping verifies the database is reachable with the configured credentials
+func Ping(ctx context.Context, gormDb *gorm.DB) error {
+	sqlDb, err := gormDb.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.PingContext(ctx)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package dlq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// STREAM queues are redis streams, which are shared by every instance
+const STREAM string = "stream"
+
+// Entry is an envelope which a sink failed to deliver, with the failure
+type Entry struct {
+	Envelope envelope.Envelope `json:"envelope"`
+	Sink     string            `json:"sink"`
+	SinkType string            `json:"sinkType"`
+	Output   string            `json:"output"`
+	Error    string            `json:"error"`
+	FailedAt time.Time         `json:"failedAt"`
+}
+
+// Queue is a dead letter queue. Entries are stored in batches, as newline
+// delimited json, and removed from the queue when drained.
+type Queue interface {
+	Initialize(conf config.DeadLetter) error
+	Write(entries []Entry) error
+	// Drain passes the entries matching the filter to fn, one stored batch at
+	// a time, and removes them from the queue once fn returns without error.
+	Drain(filter func(Entry) bool, fn func([]Entry) error) (drained int, err error)
+	Close() error
+}
+
+func BuildQueue(conf config.DeadLetter) (Queue, error) {
+	var q Queue
+	switch conf.Type {
+	case constants.FILE, "":
+		q = &FileQueue{}
+	case constants.S3:
+		q = &S3Queue{}
+	case STREAM:
+		q = &StreamQueue{}
+	default:
+		return nil, errors.New("unsupported dead letter queue type: " + conf.Type)
+	}
+	if err := q.Initialize(conf); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Writer writes envelopes which sinks fail to deliver to the queue
+type Writer struct {
+	Queue Queue
+}
+
+func (w *Writer) WriteFailed(sink backendutils.SinkMetadata, output string, envelopes []envelope.Envelope, err error) error {
+	now := time.Now().UTC()
+	entries := make([]Entry, len(envelopes))
+	for i, e := range envelopes {
+		entries[i] = Entry{
+			Envelope: e,
+			Sink:     sink.Name,
+			SinkType: sink.SinkType,
+			Output:   output,
+			Error:    err.Error(),
+			FailedAt: now,
+		}
+	}
+	return w.Queue.Write(entries)
+}
+
+func encode(entries []Entry) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+func decode(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// partition splits entries into those matching the filter and the rest
+func partition(entries []Entry, filter func(Entry) bool) (matched []Entry, rest []Entry) {
+	for _, e := range entries {
+		if filter == nil || filter(e) {
+			matched = append(matched, e)
+		} else {
+			rest = append(rest, e)
+		}
+	}
+	return matched, rest
+}
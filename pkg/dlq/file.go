@@ -0,0 +1,120 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package dlq
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const FILE_SUFFIX string = ".jsonl"
+
+// FileQueue stores entries in files in a local directory. Entries are
+// appended to a single file until the queue is drained.
+type FileQueue struct {
+	dir     string
+	mu      sync.Mutex
+	current *os.File
+	seq     int
+}
+
+func (q *FileQueue) Initialize(conf config.DeadLetter) error {
+	log.Debug().Msg("🟡 initializing file dead letter queue")
+	q.dir = conf.Path
+	return os.MkdirAll(q.dir, 0755)
+}
+
+// nextPath returns a new file path, ordered after every existing file
+func (q *FileQueue) nextPath() string {
+	q.seq++
+	return filepath.Join(q.dir, fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), q.seq, FILE_SUFFIX))
+}
+
+func (q *FileQueue) Write(entries []Entry) error {
+	contents, err := encode(entries)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.current == nil {
+		f, err := os.OpenFile(q.nextPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		q.current = f
+	}
+	if _, err := q.current.Write(contents); err != nil {
+		return err
+	}
+	return q.current.Sync()
+}
+
+func (q *FileQueue) closeCurrent() error {
+	if q.current == nil {
+		return nil
+	}
+	err := q.current.Close()
+	q.current = nil
+	return err
+}
+
+func (q *FileQueue) Drain(filter func(Entry) bool, fn func([]Entry) error) (drained int, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.closeCurrent(); err != nil {
+		return 0, err
+	}
+	paths, err := filepath.Glob(filepath.Join(q.dir, "*"+FILE_SUFFIX))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return drained, err
+		}
+		entries, err := decode(f)
+		f.Close()
+		if err != nil {
+			return drained, fmt.Errorf("could not read %s: %w", path, err)
+		}
+		matched, rest := partition(entries, filter)
+		if len(matched) == 0 {
+			continue
+		}
+		if err := fn(matched); err != nil {
+			return drained, err
+		}
+		drained += len(matched)
+		if len(rest) > 0 {
+			contents, err := encode(rest)
+			if err != nil {
+				return drained, err
+			}
+			if err := os.WriteFile(q.nextPath(), contents, 0644); err != nil {
+				return drained, err
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return drained, err
+		}
+	}
+	return drained, nil
+}
+
+func (q *FileQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closeCurrent()
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package dlq
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func testQueue(t *testing.T) *FileQueue {
+	q, err := BuildQueue(config.DeadLetter{Enabled: true, Type: "file", Path: t.TempDir()})
+	assert.Nil(t, err)
+	return q.(*FileQueue)
+}
+
+func schemas(entries []Entry) []string {
+	var s []string
+	for _, e := range entries {
+		s = append(s, e.Envelope.Schema)
+	}
+	return s
+}
+
+func TestWriterRecordsFailure(t *testing.T) {
+	q := testQueue(t)
+	w := &Writer{Queue: q}
+	sink := backendutils.SinkMetadata{Name: "warehouse", SinkType: "postgres"}
+	err := w.WriteFailed(sink, "events", []envelope.Envelope{{Schema: "a"}}, errors.New("connection refused"))
+	assert.Nil(t, err)
+	var drained []Entry
+	n, err := q.Drain(nil, func(entries []Entry) error {
+		drained = append(drained, entries...)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "warehouse", drained[0].Sink)
+	assert.Equal(t, "postgres", drained[0].SinkType)
+	assert.Equal(t, "events", drained[0].Output)
+	assert.Equal(t, "connection refused", drained[0].Error)
+	assert.False(t, drained[0].FailedAt.IsZero())
+}
+
+func TestDrainWithFilter(t *testing.T) {
+	q := testQueue(t)
+	assert.Nil(t, q.Write([]Entry{{Sink: "a", Envelope: envelope.Envelope{Schema: "1"}}, {Sink: "b", Envelope: envelope.Envelope{Schema: "2"}}}))
+	assert.Nil(t, q.Write([]Entry{{Sink: "a", Envelope: envelope.Envelope{Schema: "3"}}}))
+
+	var drained []Entry
+	collect := func(entries []Entry) error {
+		drained = append(drained, entries...)
+		return nil
+	}
+	n, err := q.Drain(func(e Entry) bool { return e.Sink == "a" }, collect)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"1", "3"}, schemas(drained))
+
+	// Entries written after a drain, and entries which didn't match, are kept
+	assert.Nil(t, q.Write([]Entry{{Sink: "c", Envelope: envelope.Envelope{Schema: "4"}}}))
+	drained = nil
+	n, err = q.Drain(nil, collect)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.ElementsMatch(t, []string{"2", "4"}, schemas(drained))
+
+	n, err = q.Drain(nil, collect)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestDrainKeepsEntriesOnFailure(t *testing.T) {
+	q := testQueue(t)
+	assert.Nil(t, q.Write([]Entry{{Sink: "a"}}))
+	_, err := q.Drain(nil, func([]Entry) error { return errors.New("manifold is shut down") })
+	assert.NotNil(t, err)
+	n, err := q.Drain(nil, func([]Entry) error { return nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package dlq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/response"
+)
+
+const (
+	DLQ_REPLAY_ROUTE = "/c/dlq/replay"
+	SINK_PARAM       = "sink"
+)
+
+type ReplayResponse struct {
+	Replayed int `json:"replayed"`
+}
+
+// ErrSinkNotFound is returned when dead letters are replayed to a sink
+// which isn't configured
+var ErrSinkNotFound = errors.New("dead letter sink not found")
+
+// replay delivers the entries to the sink and output each of them failed
+// in, so sinks which already took their batch don't receive duplicates.
+// Entries are delivered in order, grouped by sink and output.
+func replay(ctx context.Context, sinks map[string]backendutils.Sink, entries []Entry) error {
+	for i := 0; i < len(entries); {
+		j := i
+		var envelopes []envelope.Envelope
+		for ; j < len(entries) && entries[j].Sink == entries[i].Sink && entries[j].Output == entries[i].Output; j++ {
+			envelopes = append(envelopes, entries[j].Envelope)
+		}
+		sink, ok := sinks[entries[i].Sink]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrSinkNotFound, entries[i].Sink)
+		}
+		start := time.Now()
+		if err := sink.Dequeue(ctx, envelopes, entries[i].Output); err != nil {
+			return err
+		}
+		backendutils.Stats().Delivered(entries[i].Sink, len(envelopes), time.Since(start))
+		i = j
+	}
+	return nil
+}
+
+// ReplayHandler drains the queue, delivering each envelope to the sink
+// it failed in. The `sink` query param limits the replay to envelopes
+// which failed in that sink.
+func ReplayHandler(q Queue, sinks []backendutils.Sink) gin.HandlerFunc {
+	byName := make(map[string]backendutils.Sink, len(sinks))
+	for _, s := range sinks {
+		byName[s.Metadata().Name] = s
+	}
+	fn := func(c *gin.Context) {
+		var filter func(Entry) bool
+		if sink := c.Query(SINK_PARAM); sink != "" {
+			filter = func(e Entry) bool { return e.Sink == sink }
+		}
+		replayed, err := q.Drain(filter, func(entries []Entry) error {
+			return replay(c.Request.Context(), byName, entries)
+		})
+		if err != nil {
+			log.Error().Err(err).Int("replayed", replayed).Msg("🔴 could not replay dead letter queue")
+			c.JSON(http.StatusInternalServerError, response.DeadLetterReplayFailed)
+			return
+		}
+		log.Info().Int("replayed", replayed).Msg("🟢 replayed dead letter queue")
+		c.JSON(http.StatusOK, ReplayResponse{Replayed: replayed})
+	}
+	return gin.HandlerFunc(fn)
+}
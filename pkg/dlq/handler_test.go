@@ -0,0 +1,95 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink records the envelopes dequeued to it, by output
+type recordingSink struct {
+	name     string
+	enqueued int
+	dequeued map[string][]string
+}
+
+func (s *recordingSink) Metadata() backendutils.SinkMetadata {
+	return backendutils.SinkMetadata{Name: s.name}
+}
+func (s *recordingSink) Initialize(conf config.Sink) error { return nil }
+func (s *recordingSink) StartWorker() error                { return nil }
+func (s *recordingSink) Enqueue(envelopes []envelope.Envelope) error {
+	s.enqueued += len(envelopes)
+	return nil
+}
+func (s *recordingSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	if s.dequeued == nil {
+		s.dequeued = make(map[string][]string)
+	}
+	for _, e := range envelopes {
+		s.dequeued[output] = append(s.dequeued[output], e.Schema)
+	}
+	return nil
+}
+func (s *recordingSink) Shutdown() error { return nil }
+
+func replayRequest(e *gin.Engine, route string) (int, ReplayResponse) {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, route, nil))
+	var resp ReplayResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp
+}
+
+func TestReplayHandler(t *testing.T) {
+	q := testQueue(t)
+	assert.Nil(t, q.Write([]Entry{
+		{Sink: "a", Output: "events", Envelope: envelope.Envelope{Schema: "1"}},
+		{Sink: "b", Output: "events", Envelope: envelope.Envelope{Schema: "2"}},
+		{Sink: "a", Output: "invalid", Envelope: envelope.Envelope{Schema: "3"}},
+	}))
+	a, b := &recordingSink{name: "a"}, &recordingSink{name: "b"}
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.POST(DLQ_REPLAY_ROUTE, ReplayHandler(q, []backendutils.Sink{a, b}))
+
+	code, resp := replayRequest(e, DLQ_REPLAY_ROUTE+"?sink=b")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, resp.Replayed)
+	assert.Equal(t, map[string][]string{"events": {"2"}}, b.dequeued)
+	assert.Nil(t, a.dequeued)
+
+	code, resp = replayRequest(e, DLQ_REPLAY_ROUTE)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, resp.Replayed)
+	// Each envelope goes only to the sink and output it failed in
+	assert.Equal(t, map[string][]string{"events": {"1"}, "invalid": {"3"}}, a.dequeued)
+	assert.Equal(t, map[string][]string{"events": {"2"}}, b.dequeued)
+	assert.Zero(t, a.enqueued+b.enqueued)
+}
+
+func TestReplayHandlerKeepsEntriesOfUnknownSinks(t *testing.T) {
+	q := testQueue(t)
+	assert.Nil(t, q.Write([]Entry{{Sink: "removed", Output: "events", Envelope: envelope.Envelope{Schema: "1"}}}))
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.POST(DLQ_REPLAY_ROUTE, ReplayHandler(q, []backendutils.Sink{&recordingSink{name: "a"}}))
+
+	code, _ := replayRequest(e, DLQ_REPLAY_ROUTE)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	n, err := q.Drain(nil, func([]Entry) error { return nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package dlq
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconf "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+// S3Queue stores each batch of entries as an object under the path
+type S3Queue struct {
+	bucket string
+	prefix string
+	client *s3.Client
+}
+
+func (q *S3Queue) Initialize(conf config.DeadLetter) error {
+	log.Debug().Msg("🟡 initializing s3 dead letter queue")
+	cfg, err := awsconf.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not load aws config")
+		return err
+	}
+	if conf.Region != "" {
+		cfg.Region = conf.Region
+	}
+	q.bucket, q.prefix, q.client = conf.Bucket, conf.Path, s3.NewFromConfig(cfg)
+	return nil
+}
+
+// key returns a new object key. Keys sort by the time they were written.
+func (q *S3Queue) key() string {
+	return path.Join(q.prefix, fmt.Sprintf("%020d-%s%s", time.Now().UnixNano(), uuid.New().String(), FILE_SUFFIX))
+}
+
+func (q *S3Queue) put(entries []Entry) error {
+	contents, err := encode(entries)
+	if err != nil {
+		return err
+	}
+	_, err = q.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(q.bucket),
+		Key:    aws.String(q.key()),
+		Body:   bytes.NewReader(contents),
+	})
+	return err
+}
+
+func (q *S3Queue) Write(entries []Entry) error {
+	return q.put(entries)
+}
+
+func (q *S3Queue) Drain(filter func(Entry) bool, fn func([]Entry) error) (drained int, err error) {
+	ctx := context.Background()
+	paginator := s3.NewListObjectsV2Paginator(q.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(q.bucket),
+		Prefix: aws.String(q.prefix),
+	})
+	var keys []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, err
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+	for _, key := range keys {
+		obj, err := q.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(q.bucket), Key: aws.String(key)})
+		if err != nil {
+			return drained, err
+		}
+		entries, err := decode(obj.Body)
+		obj.Body.Close()
+		if err != nil {
+			return drained, fmt.Errorf("could not read %s: %w", key, err)
+		}
+		matched, rest := partition(entries, filter)
+		if len(matched) == 0 {
+			continue
+		}
+		if err := fn(matched); err != nil {
+			return drained, err
+		}
+		drained += len(matched)
+		if len(rest) > 0 {
+			if err := q.put(rest); err != nil {
+				return drained, err
+			}
+		}
+		if _, err := q.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(q.bucket), Key: aws.String(key)}); err != nil {
+			return drained, err
+		}
+	}
+	return drained, nil
+}
+
+func (q *S3Queue) Close() error {
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package dlq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const (
+	DEFAULT_STREAM string = "deadletter"
+	STREAM_FIELD   string = "entries"
+	// Messages are read from the stream in pages of this size
+	STREAM_PAGE_SIZE int64 = 100
+)
+
+// StreamQueue stores each batch of entries as a message of a redis stream
+type StreamQueue struct {
+	client *redis.Client
+	stream string
+}
+
+func (q *StreamQueue) Initialize(conf config.DeadLetter) error {
+	log.Debug().Msg("🟡 initializing stream dead letter queue")
+	q.client = redis.NewClient(&redis.Options{
+		Addr:     conf.Redis.Addr,
+		Username: conf.Redis.Username,
+		Password: conf.Redis.Password,
+		DB:       conf.Redis.Db,
+	})
+	q.stream = conf.Stream
+	if q.stream == "" {
+		q.stream = DEFAULT_STREAM
+	}
+	q.stream = conf.Redis.Prefix + q.stream
+	if err := q.client.Ping(context.Background()).Err(); err != nil {
+		log.Error().Err(err).Msg("🔴 could not connect to dead letter stream")
+		return err
+	}
+	return nil
+}
+
+func (q *StreamQueue) add(entries []Entry) error {
+	contents, err := encode(entries)
+	if err != nil {
+		return err
+	}
+	return q.client.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: q.stream,
+		Values: map[string]interface{}{STREAM_FIELD: contents},
+	}).Err()
+}
+
+func (q *StreamQueue) Write(entries []Entry) error {
+	return q.add(entries)
+}
+
+func (q *StreamQueue) Drain(filter func(Entry) bool, fn func([]Entry) error) (drained int, err error) {
+	ctx := context.Background()
+	// Only the messages in the stream when the drain starts are drained, so
+	// the entries which didn't match and are added back aren't read again
+	last, err := q.client.XRevRangeN(ctx, q.stream, "+", "-", 1).Result()
+	if err != nil || len(last) == 0 {
+		return 0, err
+	}
+	end := last[0].ID
+	start := "-"
+	for {
+		messages, err := q.client.XRangeN(ctx, q.stream, start, end, STREAM_PAGE_SIZE).Result()
+		if err != nil {
+			return drained, err
+		}
+		for _, m := range messages {
+			contents, _ := m.Values[STREAM_FIELD].(string)
+			entries, err := decode(strings.NewReader(contents))
+			if err != nil {
+				return drained, fmt.Errorf("could not read %s: %w", m.ID, err)
+			}
+			matched, rest := partition(entries, filter)
+			if len(matched) == 0 {
+				continue
+			}
+			if err := fn(matched); err != nil {
+				return drained, err
+			}
+			drained += len(matched)
+			if len(rest) > 0 {
+				if err := q.add(rest); err != nil {
+					return drained, err
+				}
+			}
+			if err := q.client.XDel(ctx, q.stream, m.ID).Err(); err != nil {
+				return drained, err
+			}
+		}
+		if int64(len(messages)) < STREAM_PAGE_SIZE {
+			return drained, nil
+		}
+		start = "(" + messages[len(messages)-1].ID
+	}
+}
+
+func (q *StreamQueue) Close() error {
+	return q.client.Close()
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package dlq
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamQueue(t *testing.T) {
+	mr := miniredis.RunT(t)
+	q, err := BuildQueue(config.DeadLetter{Enabled: true, Type: STREAM, Redis: config.Redis{Addr: mr.Addr(), Prefix: "buz:"}})
+	assert.Nil(t, err)
+	defer q.Close()
+
+	assert.Nil(t, q.Write([]Entry{{Sink: "a", Envelope: envelope.Envelope{Schema: "1"}}, {Sink: "b", Envelope: envelope.Envelope{Schema: "2"}}}))
+	assert.Nil(t, q.Write([]Entry{{Sink: "a", Envelope: envelope.Envelope{Schema: "3"}}}))
+	assert.True(t, mr.Exists("buz:"+DEFAULT_STREAM))
+
+	var drained []Entry
+	collect := func(entries []Entry) error {
+		drained = append(drained, entries...)
+		return nil
+	}
+	n, err := q.Drain(func(e Entry) bool { return e.Sink == "a" }, collect)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"1", "3"}, schemas(drained))
+
+	drained = nil
+	n, err = q.Drain(nil, collect)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"2"}, schemas(drained))
+
+	n, err = q.Drain(nil, collect)
+	assert.Nil(t, err)
+	assert.Zero(t, n)
+}
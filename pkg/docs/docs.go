@@ -0,0 +1,135 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package docs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/tidwall/gjson"
+)
+
+// Nested fields are documented to this depth, which also bounds recursive schemas
+const MAX_DEPTH int = 8
+
+type FieldDoc struct {
+	Path        string        `json:"path"`
+	Type        string        `json:"type,omitempty"`
+	Format      string        `json:"format,omitempty"`
+	Required    bool          `json:"required"`
+	Description string        `json:"description,omitempty"`
+	Enum        []interface{} `json:"enum,omitempty"`
+	Examples    []interface{} `json:"examples,omitempty"`
+	Deprecated  bool          `json:"deprecated,omitempty"`
+}
+
+type SchemaDoc struct {
+	Name         string     `json:"name"`
+	Title        string     `json:"title,omitempty"`
+	Description  string     `json:"description,omitempty"`
+	Vendor       string     `json:"vendor,omitempty"`
+	Namespace    string     `json:"namespace,omitempty"`
+	Version      string     `json:"version,omitempty"`
+	Deprecated   bool       `json:"deprecated,omitempty"`
+	SupersededBy string     `json:"supersededBy,omitempty"`
+	Fields       []FieldDoc `json:"fields"`
+}
+
+// deref follows references to the schema which defines the field
+func deref(s *jsonschema.Schema) *jsonschema.Schema {
+	for i := 0; i < MAX_DEPTH && s.Ref != nil; i++ {
+		if len(s.Types) > 0 || len(s.Properties) > 0 {
+			break
+		}
+		s = s.Ref
+	}
+	return s
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Build documents the compiled schema. Metadata which isn't part of json
+// schema (such as `self`) is read from the raw schema contents.
+func Build(name string, compiled *jsonschema.Schema, raw []byte) SchemaDoc {
+	r := gjson.ParseBytes(raw)
+	d := SchemaDoc{
+		Name:         name,
+		Title:        r.Get("title").String(),
+		Description:  r.Get("description").String(),
+		Vendor:       r.Get("self.vendor").String(),
+		Namespace:    r.Get("self.namespace").String(),
+		Version:      r.Get("self.version").String(),
+		Deprecated:   r.Get("deprecated").Bool(),
+		SupersededBy: r.Get("supersededBy").String(),
+		Fields:       []FieldDoc{},
+	}
+	walkProperties("", compiled, 0, &d.Fields)
+	return d
+}
+
+// walkProperties documents the properties of the schema, including those
+// of any schemas it is composed from with `allOf`.
+func walkProperties(path string, s *jsonschema.Schema, depth int, fields *[]FieldDoc) {
+	if depth > MAX_DEPTH {
+		return
+	}
+	s = deref(s)
+	names := make([]string, 0, len(s.Properties))
+	for n := range s.Properties {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		p := s.Properties[n]
+		fieldPath := n
+		if path != "" {
+			fieldPath = path + "." + n
+		}
+		walkField(fieldPath, p, contains(s.Required, n), depth+1, fields)
+	}
+	for _, sub := range s.AllOf {
+		walkProperties(path, sub, depth+1, fields)
+	}
+	var items *jsonschema.Schema
+	if s.Items2020 != nil {
+		items = s.Items2020
+	} else if i, ok := s.Items.(*jsonschema.Schema); ok {
+		items = i
+	}
+	if items != nil && path != "" {
+		walkField(path+"[]", items, false, depth+1, fields)
+	}
+}
+
+func walkField(path string, s *jsonschema.Schema, required bool, depth int, fields *[]FieldDoc) {
+	field := deref(s)
+	description := s.Description
+	if description == "" {
+		description = field.Description
+	}
+	examples := s.Examples
+	if field != s {
+		examples = append(append([]interface{}{}, s.Examples...), field.Examples...)
+	}
+	*fields = append(*fields, FieldDoc{
+		Path:        path,
+		Type:        strings.Join(field.Types, " | "),
+		Format:      field.Format,
+		Required:    required,
+		Description: description,
+		Enum:        field.Enum,
+		Examples:    examples,
+		Deprecated:  s.Deprecated || field.Deprecated,
+	})
+	walkProperties(path, field, depth, fields)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package docs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/compiler"
+	"github.com/stretchr/testify/assert"
+)
+
+const schema = `{
+	"description": "An order",
+	"self": {"vendor": "com.acme", "namespace": "order", "version": "1.0"},
+	"deprecated": true,
+	"supersededBy": "com.acme/order/v2.0.json",
+	"$defs": {"address": {"type": "object", "description": "An address", "properties": {"city": {"type": "string"}}}},
+	"type": "object",
+	"properties": {
+		"id": {"type": "string", "format": "uuid", "description": "The order id", "examples": ["7d1b..."]},
+		"status": {"enum": ["open", "closed"]},
+		"shipping": {"$ref": "#/$defs/address"},
+		"lines": {"type": "array", "items": {"type": "object", "properties": {"sku": {"type": "string"}}, "required": ["sku"]}}
+	},
+	"required": ["id"]
+}`
+
+func TestBuild(t *testing.T) {
+	compiled, err := compiler.Compile([]byte(schema))
+	assert.Nil(t, err)
+	d := Build("com.acme/order/v1.0.json", compiled, []byte(schema))
+	assert.Equal(t, "An order", d.Description)
+	assert.Equal(t, "com.acme", d.Vendor)
+	assert.True(t, d.Deprecated)
+	assert.Equal(t, "com.acme/order/v2.0.json", d.SupersededBy)
+
+	fields := make(map[string]FieldDoc)
+	var paths []string
+	for _, f := range d.Fields {
+		fields[f.Path] = f
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, []string{"id", "lines", "lines[]", "lines[].sku", "shipping", "shipping.city", "status"}, paths)
+	assert.Equal(t, FieldDoc{
+		Path: "id", Type: "string", Format: "uuid", Required: true, Description: "The order id", Examples: []interface{}{"7d1b..."},
+	}, fields["id"])
+	assert.Equal(t, "object", fields["shipping"].Type)
+	assert.Equal(t, "An address", fields["shipping"].Description)
+	assert.True(t, fields["lines[].sku"].Required)
+	assert.Equal(t, []interface{}{"open", "closed"}, fields["status"].Enum)
+
+	var page bytes.Buffer
+	assert.Nil(t, RenderSchema(&page, d, "/c/docs/"))
+	assert.Contains(t, page.String(), `<a href="/c/docs/com.acme/order/v2.0.json">`)
+	assert.Contains(t, page.String(), "<code>lines[].sku</code>")
+}
+
+func TestBuildRecursive(t *testing.T) {
+	tree := []byte(`{"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#"}}}}`)
+	compiled, err := compiler.Compile(tree)
+	assert.Nil(t, err)
+	d := Build("tree", compiled, tree)
+	assert.NotEmpty(t, d.Fields)
+	assert.Less(t, len(d.Fields), 100)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package docs
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+)
+
+const style = `<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 70em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.4em; text-align: left; vertical-align: top; }
+code { background: #f4f4f4; padding: 0 0.2em; }
+.deprecated { color: #a00; }
+</style>`
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	},
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><title>Schemas</title>` + style + `</head><body>
+<h1>Schemas</h1>
+<ul>
+{{- range . }}
+<li><a href="{{ . }}">{{ . }}</a></li>
+{{- end }}
+</ul>
+</body></html>`))
+
+var schemaTemplate = template.Must(template.New("schema").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html><head><title>{{ .Name }}</title>` + style + `</head><body>
+<h1>{{ .Name }}</h1>
+{{- if .Deprecated }}
+<p class="deprecated">Deprecated{{ if .SupersededBy }} - superseded by <a href="{{ .Route }}{{ .SupersededBy }}">{{ .SupersededBy }}</a>{{ end }}</p>
+{{- end }}
+{{- if .Title }}<h2>{{ .Title }}</h2>{{ end }}
+<p>{{ .Description }}</p>
+<p>Vendor <code>{{ .Vendor }}</code> &middot; Namespace <code>{{ .Namespace }}</code> &middot; Version <code>{{ .Version }}</code></p>
+<table>
+<tr><th>Field</th><th>Type</th><th>Required</th><th>Description</th><th>Allowed values</th><th>Examples</th></tr>
+{{- range .Fields }}
+<tr{{ if .Deprecated }} class="deprecated"{{ end }}>
+<td><code>{{ .Path }}</code></td>
+<td>{{ .Type }}{{ if .Format }} ({{ .Format }}){{ end }}</td>
+<td>{{ if .Required }}yes{{ end }}</td>
+<td>{{ .Description }}</td>
+<td>{{ range .Enum }}<code>{{ json . }}</code> {{ end }}</td>
+<td>{{ range .Examples }}<code>{{ json . }}</code> {{ end }}</td>
+</tr>
+{{- end }}
+</table>
+</body></html>`))
+
+// RenderIndex writes an html page linking to the documentation of every schema
+func RenderIndex(w io.Writer, schemas []string) error {
+	return indexTemplate.Execute(w, schemas)
+}
+
+type schemaPage struct {
+	SchemaDoc
+	Route string
+}
+
+// RenderSchema writes the html documentation of a schema, linking to
+// other schemas beneath the route.
+func RenderSchema(w io.Writer, d SchemaDoc, route string) error {
+	return schemaTemplate.Execute(w, schemaPage{SchemaDoc: d, Route: route})
+}
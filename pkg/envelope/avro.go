@@ -0,0 +1,230 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package envelope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/silverton-io/buz/schemas"
+)
+
+// The avro schema of the envelope, which is served by the schema registry
+const AVRO_SCHEMA string = "io.silverton/buz/internal/envelope/v1.0.avsc"
+
+const AVRO_EMPTY_FINGERPRINT uint64 = 0xc15d213aa4d7a795
+
+// Avro single-object encoding header
+var avroMagic = []byte{0xc3, 0x01}
+
+var avroFingerprintTable = func() (table [256]uint64) {
+	for i := range table {
+		fp := uint64(i)
+		for j := 0; j < 8; j++ {
+			fp = (fp >> 1) ^ (AVRO_EMPTY_FINGERPRINT & -(fp & 1))
+		}
+		table[i] = fp
+	}
+	return table
+}()
+
+// avroFingerprint returns the CRC-64-AVRO fingerprint of a canonical schema
+func avroFingerprint(canonical []byte) uint64 {
+	fp := AVRO_EMPTY_FINGERPRINT
+	for _, b := range canonical {
+		fp = (fp >> 8) ^ avroFingerprintTable[byte(fp)^b]
+	}
+	return fp
+}
+
+// avroCanonical writes the parsing canonical form of a schema, which
+// strips docs, defaults, and logical types, and fully qualifies names
+func avroCanonical(buf *bytes.Buffer, schema interface{}, namespace string) error {
+	switch s := schema.(type) {
+	case string:
+		if !strings.Contains(s, ".") && namespace != "" && !isAvroPrimitive(s) {
+			s = namespace + "." + s
+		}
+		b, _ := json.Marshal(s)
+		buf.Write(b)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, branch := range s {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := avroCanonical(buf, branch, namespace); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		t, _ := s["type"].(string)
+		switch t {
+		case "record", "enum", "fixed":
+			name, _ := s["name"].(string)
+			if ns, ok := s["namespace"].(string); ok && !strings.Contains(name, ".") {
+				namespace = ns
+			}
+			if !strings.Contains(name, ".") && namespace != "" {
+				name = namespace + "." + name
+			}
+			if i := strings.LastIndex(name, "."); i >= 0 {
+				namespace = name[:i]
+			}
+			n, _ := json.Marshal(name)
+			buf.WriteString(`{"name":` + string(n) + `,"type":"` + t + `"`)
+			switch t {
+			case "record":
+				fields, _ := s["fields"].([]interface{})
+				buf.WriteString(`,"fields":[`)
+				for i, f := range fields {
+					field, ok := f.(map[string]interface{})
+					if !ok {
+						return errors.New("invalid avro record field")
+					}
+					if i > 0 {
+						buf.WriteByte(',')
+					}
+					fn, _ := json.Marshal(field["name"])
+					buf.WriteString(`{"name":` + string(fn) + `,"type":`)
+					if err := avroCanonical(buf, field["type"], namespace); err != nil {
+						return err
+					}
+					buf.WriteByte('}')
+				}
+				buf.WriteByte(']')
+			case "enum":
+				symbols, _ := json.Marshal(s["symbols"])
+				buf.WriteString(`,"symbols":` + string(symbols))
+			case "fixed":
+				size, _ := json.Marshal(s["size"])
+				buf.WriteString(`,"size":` + string(size))
+			}
+			buf.WriteByte('}')
+		case "array":
+			buf.WriteString(`{"type":"array","items":`)
+			if err := avroCanonical(buf, s["items"], namespace); err != nil {
+				return err
+			}
+			buf.WriteByte('}')
+		case "map":
+			buf.WriteString(`{"type":"map","values":`)
+			if err := avroCanonical(buf, s["values"], namespace); err != nil {
+				return err
+			}
+			buf.WriteByte('}')
+		default:
+			// Primitives, possibly annotated with a logical type
+			return avroCanonical(buf, t, namespace)
+		}
+	default:
+		return errors.New("invalid avro schema")
+	}
+	return nil
+}
+
+func isAvroPrimitive(t string) bool {
+	switch t {
+	case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+		return true
+	}
+	return false
+}
+
+// avroHeader is the single-object encoding header of the envelope
+// schema, which is the magic followed by its little-endian fingerprint
+var avroHeader = func() []byte {
+	contents, err := fs.ReadFile(schemas.Embedded, AVRO_SCHEMA)
+	if err != nil {
+		panic(err)
+	}
+	var schema interface{}
+	if err := json.Unmarshal(contents, &schema); err != nil {
+		panic(err)
+	}
+	var canonical bytes.Buffer
+	if err := avroCanonical(&canonical, schema, ""); err != nil {
+		panic(err)
+	}
+	header := make([]byte, len(avroMagic)+8)
+	copy(header, avroMagic)
+	binary.LittleEndian.PutUint64(header[len(avroMagic):], avroFingerprint(canonical.Bytes()))
+	return header
+}()
+
+func writeAvroLong(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v) // Zig-zag, like avro
+	buf.Write(b[:n])
+}
+
+func writeAvroString(buf *bytes.Buffer, s string) {
+	writeAvroLong(buf, int64(len(s)))
+	buf.WriteString(s)
+}
+
+func writeAvroTimestamp(buf *bytes.Buffer, t *time.Time) {
+	if t == nil {
+		writeAvroLong(buf, 0) // The null branch
+		return
+	}
+	writeAvroLong(buf, 1)
+	writeAvroLong(buf, t.UnixMicro())
+}
+
+// writeAvroJson writes a nullable json-encoded value
+func writeAvroJson(buf *bytes.Buffer, v interface{}, isNull bool) error {
+	if isNull {
+		writeAvroLong(buf, 0)
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	writeAvroLong(buf, 1)
+	writeAvroString(buf, string(b))
+	return nil
+}
+
+// AsAvro encodes the envelope with the avro envelope schema, using avro
+// single-object encoding so consumers can resolve the schema by its
+// fingerprint
+func (e *Envelope) AsAvro() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(avroHeader)
+	writeAvroString(&buf, e.Uuid.String())
+	writeAvroLong(&buf, e.Timestamp.UnixMicro())
+	writeAvroLong(&buf, e.BuzTimestamp.UnixMicro())
+	writeAvroTimestamp(&buf, e.DeviceCreatedTimestamp)
+	writeAvroTimestamp(&buf, e.DeviceSentTimestamp)
+	writeAvroLong(&buf, e.DerivedTimestamp.UnixMicro())
+	for _, s := range []string{e.BuzVersion, e.BuzName, e.BuzEnv, e.Protocol, e.Schema, e.Vendor, e.Namespace, e.Version} {
+		writeAvroString(&buf, s)
+	}
+	if e.IsValid {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	if err := writeAvroJson(&buf, e.ValidationError, e.ValidationError == nil); err != nil {
+		return nil, err
+	}
+	if err := writeAvroJson(&buf, e.Contexts, e.Contexts == nil); err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(e.Payload)
+	if err != nil {
+		return nil, err
+	}
+	writeAvroString(&buf, string(payload))
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package envelope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAvroFingerprint(t *testing.T) {
+	// Fingerprints from the avro specification test vectors
+	assert.Equal(t, int64(7195948357588979594), int64(avroFingerprint([]byte(`"null"`))))
+	assert.Equal(t, int64(8247732601305521295), int64(avroFingerprint([]byte(`"int"`))))
+}
+
+func TestAvroCanonical(t *testing.T) {
+	schema := map[string]interface{}{
+		"type":      "record",
+		"name":      "Thing",
+		"namespace": "io.silverton",
+		"doc":       "stripped",
+		"fields": []interface{}{
+			map[string]interface{}{"name": "at", "type": map[string]interface{}{"type": "long", "logicalType": "timestamp-micros"}},
+			map[string]interface{}{"name": "maybe", "type": []interface{}{"null", "string"}, "default": nil},
+			map[string]interface{}{"name": "other", "type": "Other"},
+		},
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, avroCanonical(&buf, schema, ""))
+	assert.Equal(t, `{"name":"io.silverton.Thing","type":"record","fields":[{"name":"at","type":"long"},{"name":"maybe","type":["null","string"]},{"name":"other","type":"io.silverton.Other"}]}`, buf.String())
+}
+
+func TestAsAvro(t *testing.T) {
+	ts := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	e := Envelope{
+		Uuid:             uuid.New(),
+		Timestamp:        ts,
+		BuzTimestamp:     ts,
+		DerivedTimestamp: ts,
+		Schema:           "io.silverton/buz/example/v1.0.json",
+		IsValid:          true,
+		Payload:          Payload{"a": "b"},
+	}
+	b, err := e.AsAvro()
+	assert.Nil(t, err)
+	assert.Equal(t, avroMagic, b[:2])
+
+	r := bytes.NewReader(b[len(avroHeader):])
+	readString := func() string {
+		n, _ := binary.ReadVarint(r)
+		s := make([]byte, n)
+		r.Read(s)
+		return string(s)
+	}
+	assert.Equal(t, e.Uuid.String(), readString())
+	micros, _ := binary.ReadVarint(r)
+	assert.Equal(t, ts.UnixMicro(), micros)
+	binary.ReadVarint(r) // buzTimestamp
+	created, _ := binary.ReadVarint(r)
+	sent, _ := binary.ReadVarint(r)
+	assert.Equal(t, int64(0), created) // null
+	assert.Equal(t, int64(0), sent)
+	binary.ReadVarint(r) // derivedTimestamp
+	for i := 0; i < 4; i++ {
+		readString() // buzVersion, buzName, buzEnv, protocol
+	}
+	assert.Equal(t, e.Schema, readString())
+	for i := 0; i < 3; i++ {
+		readString() // vendor, namespace, version
+	}
+	isValid, _ := r.ReadByte()
+	assert.Equal(t, byte(1), isValid)
+	validationError, _ := binary.ReadVarint(r)
+	contexts, _ := binary.ReadVarint(r)
+	assert.Equal(t, int64(0), validationError)
+	assert.Equal(t, int64(0), contexts)
+	assert.Equal(t, `{"a":"b"}`, readString())
+	assert.Equal(t, 0, r.Len())
+}
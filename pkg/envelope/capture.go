@@ -0,0 +1,53 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package envelope
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const CAPTURE_CONTEXT string = "io.silverton/buz/internal/contexts/capture/v1.0.json"
+
+func captureField(captured map[string]interface{}, field config.CaptureField, value string) {
+	if value == "" {
+		return
+	}
+	key := field.As
+	if key == "" {
+		key = field.Name
+	}
+	if field.Hash {
+		sum := sha256.Sum256([]byte(value))
+		value = hex.EncodeToString(sum[:])
+	}
+	captured[key] = value
+}
+
+// Capture attaches the configured headers, cookies, and query params of
+// the request to each envelope, so clients can send metadata without
+// changing their schemas. Values which aren't present are skipped.
+func Capture(c *gin.Context, conf config.Capture, envelopes []Envelope) {
+	captured := make(map[string]interface{})
+	for _, field := range conf.Headers {
+		captureField(captured, field, c.GetHeader(field.Name))
+	}
+	for _, field := range conf.Cookies {
+		value, _ := c.Cookie(field.Name)
+		captureField(captured, field, value)
+	}
+	for _, field := range conf.Query {
+		captureField(captured, field, c.Query(field.Name))
+	}
+	if len(captured) == 0 {
+		return
+	}
+	for i := range envelopes {
+		setContext(&envelopes[i], CAPTURE_CONTEXT, captured)
+	}
+}
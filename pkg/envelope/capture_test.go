@@ -0,0 +1,42 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package envelope
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapture(t *testing.T) {
+	conf := config.Capture{
+		Headers: []config.CaptureField{{Name: "X-Request-ID", As: "requestId"}, {Name: "X-Missing"}},
+		Cookies: []config.CaptureField{{Name: "sp", Hash: true}},
+		Query:   []config.CaptureField{{Name: "exp"}},
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/?exp=checkout-b", nil)
+	c.Request.Header.Set("X-Request-ID", "abc123")
+	c.Request.AddCookie(&http.Cookie{Name: "sp", Value: "device"})
+	shared := Contexts{HTTP_HEADERS_CONTEXT: map[string]interface{}{}}
+	envelopes := []Envelope{{Contexts: &shared}, {Contexts: &shared}}
+
+	Capture(c, conf, envelopes)
+
+	want := map[string]interface{}{
+		"requestId": "abc123",
+		"sp":        "263a4dbe41488fb87214b0032339dbb9f0c8da14c16dfcf13084bf3c2552eca5",
+		"exp":       "checkout-b",
+	}
+	for _, e := range envelopes {
+		assert.Equal(t, want, (*e.Contexts)[CAPTURE_CONTEXT])
+	}
+	_, ok := shared[CAPTURE_CONTEXT]
+	assert.False(t, ok, "shared contexts should be copied")
+}
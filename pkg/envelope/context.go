@@ -9,10 +9,16 @@ import (
 	"encoding/json"
 
 	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/constants"
 	"github.com/silverton-io/buz/pkg/util"
 )
 
-const HTTP_HEADERS_CONTEXT string = "io.silverton/buz/internal/contexts/httpHeaders/v1.0.json"
+const (
+	HTTP_HEADERS_CONTEXT string = "io.silverton/buz/internal/contexts/httpHeaders/v1.0.json"
+	TENANT_CONTEXT       string = "io.silverton/buz/internal/contexts/tenant/v1.0.json"
+	CLIENT_CONTEXT       string = "io.silverton/buz/internal/contexts/client/v1.0.json"
+	RETENTION_CONTEXT    string = "io.silverton/buz/internal/contexts/retention/v1.0.json"
+)
 
 type Contexts map[string]interface{}
 
@@ -30,5 +36,52 @@ func BuildContextsFromRequest(c *gin.Context) Contexts {
 	context := map[string]interface{}{
 		HTTP_HEADERS_CONTEXT: headers,
 	}
+	if !c.GetBool(constants.WITHHOLD_CLIENT_IP) {
+		context[CLIENT_CONTEXT] = map[string]interface{}{"ip": c.ClientIP()}
+	}
+	if tenant := c.GetString(constants.TENANT); tenant != "" {
+		context[TENANT_CONTEXT] = map[string]interface{}{"tenant": tenant}
+	}
 	return context
 }
+
+// StampTenant attaches the tenant of the request, if it has one, to an
+// envelope which wasn't built with request contexts
+func StampTenant(c *gin.Context, e *Envelope) {
+	tenant := c.GetString(constants.TENANT)
+	if tenant == "" {
+		return
+	}
+	setContext(e, TENANT_CONTEXT, map[string]interface{}{"tenant": tenant})
+}
+
+// StampRetention attaches the retention class of the envelope's schema
+func StampRetention(e *Envelope, class string) {
+	if class == "" {
+		return
+	}
+	setContext(e, RETENTION_CONTEXT, map[string]interface{}{"class": class})
+}
+
+// setContext attaches a context to the envelope. Contexts are copied
+// first, since envelopes of a request may share them.
+func setContext(e *Envelope, name string, value interface{}) {
+	contexts := make(Contexts)
+	if e.Contexts != nil {
+		for k, v := range *e.Contexts {
+			contexts[k] = v
+		}
+	}
+	contexts[name] = value
+	e.Contexts = &contexts
+}
+
+// ClientIp returns the client ip the envelope was collected from, if any
+func (e *Envelope) ClientIp() string {
+	if e.Contexts == nil {
+		return ""
+	}
+	client, _ := (*e.Contexts)[CLIENT_CONTEXT].(map[string]interface{})
+	ip, _ := client["ip"].(string)
+	return ip
+}
@@ -20,25 +20,70 @@ const (
 	VERSION   string = "version"
 	SCHEMA    string = "schema"
 	IS_VALID  string = "isValid"
+	RETENTION string = "retention"
+	// The content type of the serialized envelope
+	CONTENT_TYPE string = "contentType"
 )
 
 // An envelope consisting of minimally-defined properties
 type Envelope struct {
-	Uuid            uuid.UUID        `json:"uuid"`
-	Timestamp       time.Time        `json:"timestamp" sql:"index"`
-	BuzTimestamp    time.Time        `json:"buzTimestamp" sql:"index"`
-	BuzVersion      string           `json:"buzVersion"`
-	BuzName         string           `json:"buzName"`
-	BuzEnv          string           `json:"buzEnv"`
-	Protocol        string           `json:"protocol"`
-	Schema          string           `json:"schema"`
-	Vendor          string           `json:"vendor"`
-	Namespace       string           `json:"namespace"`
-	Version         string           `json:"version"`
-	IsValid         bool             `json:"isValid"`
-	ValidationError *ValidationError `json:"validationError,omitempty" gorm:"type:json"`
-	Contexts        *Contexts        `json:"contexts,omitempty" gorm:"type:json"`
-	Payload         Payload          `json:"payload" gorm:"type:json"`
+	Uuid                   uuid.UUID        `json:"uuid"`
+	Timestamp              time.Time        `json:"timestamp" sql:"index"`
+	BuzTimestamp           time.Time        `json:"buzTimestamp" sql:"index"`
+	DeviceCreatedTimestamp *time.Time       `json:"deviceCreatedTimestamp,omitempty"`
+	DeviceSentTimestamp    *time.Time       `json:"deviceSentTimestamp,omitempty"`
+	DerivedTimestamp       time.Time        `json:"derivedTimestamp" sql:"index"`
+	BuzVersion             string           `json:"buzVersion"`
+	BuzName                string           `json:"buzName"`
+	BuzEnv                 string           `json:"buzEnv"`
+	Protocol               string           `json:"protocol"`
+	Schema                 string           `json:"schema"`
+	Vendor                 string           `json:"vendor"`
+	Namespace              string           `json:"namespace"`
+	Version                string           `json:"version"`
+	IsValid                bool             `json:"isValid"`
+	ValidationError        *ValidationError `json:"validationError,omitempty" gorm:"type:json"`
+	Contexts               *Contexts        `json:"contexts,omitempty" gorm:"type:json"`
+	Payload                Payload          `json:"payload" gorm:"type:json"`
+}
+
+// DeriveTimestamp returns the time an event happened, by the collector
+// clock. Device clocks are often wrong, but the time an event spent on
+// the device before it was sent is not, so it is subtracted from the time
+// the event was collected. Without both device timestamps, or if they are
+// out of order, the collector timestamp is used.
+func DeriveTimestamp(collected time.Time, created *time.Time, sent *time.Time) time.Time {
+	if created == nil || sent == nil || sent.Before(*created) {
+		return collected
+	}
+	return collected.Add(-sent.Sub(*created))
+}
+
+// SetDeviceTimestamps sets the timestamps sent by the device, and the
+// derived timestamp of the envelope
+func (e *Envelope) SetDeviceTimestamps(created *time.Time, sent *time.Time) {
+	e.DeviceCreatedTimestamp, e.DeviceSentTimestamp = created, sent
+	e.DerivedTimestamp = DeriveTimestamp(e.BuzTimestamp, created, sent)
+}
+
+// Tenant returns the tenant the envelope was stamped with, if any
+func (e *Envelope) Tenant() string {
+	if e.Contexts == nil {
+		return ""
+	}
+	tenant, _ := (*e.Contexts)[TENANT_CONTEXT].(map[string]interface{})
+	name, _ := tenant["tenant"].(string)
+	return name
+}
+
+// Retention returns the retention class the envelope was stamped with, if any
+func (e *Envelope) Retention() string {
+	if e.Contexts == nil {
+		return ""
+	}
+	retention, _ := (*e.Contexts)[RETENTION_CONTEXT].(map[string]interface{})
+	class, _ := retention["class"].(string)
+	return class
 }
 
 func (e *Envelope) AsMap() (map[string]interface{}, error) {
@@ -62,55 +107,62 @@ func (e *Envelope) AsByte() ([]byte, error) {
 }
 
 type JsonbEnvelope struct {
-	Uuid            uuid.UUID        `json:"uuid" gorm:"type:uuid"`
-	Timestamp       time.Time        `json:"timestamp" sql:"index"`
-	BuzTimestamp    time.Time        `json:"buzTimestamp" sql:"index"`
-	BuzVersion      string           `json:"buzVersion"`
-	BuzName         string           `json:"buzName"`
-	BuzEnv          string           `json:"buzEnv"`
-	Protocol        string           `json:"protocol"`
-	Schema          string           `json:"schema"`
-	Vendor          string           `json:"vendor"`
-	Namespace       string           `json:"namespace"`
-	Version         string           `json:"version"`
-	IsValid         bool             `json:"isValid"`
-	ValidationError *ValidationError `json:"validationError,omitempty" gorm:"type:jsonb"`
-	Contexts        *Contexts        `json:"contexts,omitempty" gorm:"type:jsonb"`
-	Payload         Payload          `json:"payload" gorm:"type:jsonb"`
+	Uuid                   uuid.UUID        `json:"uuid" gorm:"type:uuid"`
+	Timestamp              time.Time        `json:"timestamp" sql:"index"`
+	BuzTimestamp           time.Time        `json:"buzTimestamp" sql:"index"`
+	DeviceCreatedTimestamp *time.Time       `json:"deviceCreatedTimestamp,omitempty"`
+	DeviceSentTimestamp    *time.Time       `json:"deviceSentTimestamp,omitempty"`
+	DerivedTimestamp       time.Time        `json:"derivedTimestamp" sql:"index"`
+	BuzVersion             string           `json:"buzVersion"`
+	BuzName                string           `json:"buzName"`
+	BuzEnv                 string           `json:"buzEnv"`
+	Protocol               string           `json:"protocol"`
+	Schema                 string           `json:"schema"`
+	Vendor                 string           `json:"vendor"`
+	Namespace              string           `json:"namespace"`
+	Version                string           `json:"version"`
+	IsValid                bool             `json:"isValid"`
+	ValidationError        *ValidationError `json:"validationError,omitempty" gorm:"type:jsonb"`
+	Contexts               *Contexts        `json:"contexts,omitempty" gorm:"type:jsonb"`
+	Payload                Payload          `json:"payload" gorm:"type:jsonb"`
 }
 
 type StringEnvelope struct {
-	Uuid            uuid.UUID        `json:"uuid" gorm:"type:uuid"`
-	Timestamp       time.Time        `json:"timestamp" sql:"index"`
-	BuzTimestamp    time.Time        `json:"buzTimestamp" sql:"index"`
-	BuzVersion      string           `json:"buzVersion"`
-	BuzName         string           `json:"buzName"`
-	BuzEnv          string           `json:"buzEnv"`
-	Protocol        string           `json:"protocol"`
-	Schema          string           `json:"schema"`
-	Vendor          string           `json:"vendor"`
-	Namespace       string           `json:"namespace"`
-	Version         string           `json:"version"`
-	IsValid         bool             `json:"isValid"`
-	ValidationError *ValidationError `json:"validationError,omitempty" gorm:"type:string"`
-	Contexts        *Contexts        `json:"contexts,omitempty" gorm:"type:string"`
-	Payload         Payload          `json:"payload" gorm:"type:string"`
+	Uuid                   uuid.UUID        `json:"uuid" gorm:"type:uuid"`
+	Timestamp              time.Time        `json:"timestamp" sql:"index"`
+	BuzTimestamp           time.Time        `json:"buzTimestamp" sql:"index"`
+	DeviceCreatedTimestamp *time.Time       `json:"deviceCreatedTimestamp,omitempty"`
+	DeviceSentTimestamp    *time.Time       `json:"deviceSentTimestamp,omitempty"`
+	DerivedTimestamp       time.Time        `json:"derivedTimestamp" sql:"index"`
+	BuzVersion             string           `json:"buzVersion"`
+	BuzName                string           `json:"buzName"`
+	BuzEnv                 string           `json:"buzEnv"`
+	Protocol               string           `json:"protocol"`
+	Schema                 string           `json:"schema"`
+	Vendor                 string           `json:"vendor"`
+	Namespace              string           `json:"namespace"`
+	Version                string           `json:"version"`
+	IsValid                bool             `json:"isValid"`
+	ValidationError        *ValidationError `json:"validationError,omitempty" gorm:"type:string"`
+	Contexts               *Contexts        `json:"contexts,omitempty" gorm:"type:string"`
+	Payload                Payload          `json:"payload" gorm:"type:string"`
 }
 
 // Build a new envelope with base fields populated
 func NewEnvelope(conf config.App) Envelope {
 	now := time.Now().UTC()
 	envelope := Envelope{
-		Uuid:         uuid.New(),
-		Timestamp:    now,
-		BuzTimestamp: now,
-		BuzVersion:   conf.Version,
-		BuzName:      conf.Name,
-		BuzEnv:       conf.Env,
-		Schema:       constants.UNKNOWN,
-		Vendor:       constants.UNKNOWN,
-		Namespace:    constants.UNKNOWN,
-		Version:      constants.UNKNOWN,
+		Uuid:             uuid.New(),
+		Timestamp:        now,
+		BuzTimestamp:     now,
+		DerivedTimestamp: now,
+		BuzVersion:       conf.Version,
+		BuzName:          conf.Name,
+		BuzEnv:           conf.Env,
+		Schema:           constants.UNKNOWN,
+		Vendor:           constants.UNKNOWN,
+		Namespace:        constants.UNKNOWN,
+		Version:          constants.UNKNOWN,
 	}
 	return envelope
 }
@@ -0,0 +1,31 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package envelope
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveTimestamp(t *testing.T) {
+	collected := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	// The device clock is an hour behind, and the event waited 5s before it was sent
+	created := time.Date(2023, 6, 1, 11, 0, 0, 0, time.UTC)
+	sent := created.Add(5 * time.Second)
+	early := created.Add(-time.Second)
+
+	assert.Equal(t, collected.Add(-5*time.Second), DeriveTimestamp(collected, &created, &sent))
+	assert.Equal(t, collected, DeriveTimestamp(collected, &created, nil))
+	assert.Equal(t, collected, DeriveTimestamp(collected, nil, &sent))
+	assert.Equal(t, collected, DeriveTimestamp(collected, &created, &early))
+
+	e := Envelope{BuzTimestamp: collected}
+	e.SetDeviceTimestamps(&created, &sent)
+	assert.Equal(t, &created, e.DeviceCreatedTimestamp)
+	assert.Equal(t, &sent, e.DeviceSentTimestamp)
+	assert.Equal(t, collected.Add(-5*time.Second), e.DerivedTimestamp)
+}
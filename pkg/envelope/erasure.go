@@ -0,0 +1,14 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package envelope
+
+// Erasure requests are delivered to every sink, regardless of its routing
+const ERASURE_REQUEST_SCHEMA string = "io.silverton/buz/internal/erasure/request/v1.0.json"
+
+// IsErasureRequest returns true if the envelope asks sinks to erase the
+// data of a subject
+func (e *Envelope) IsErasureRequest() bool {
+	return e.Schema == ERASURE_REQUEST_SCHEMA
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package envelope
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+// Privacy actions, in increasing order of strictness
+const (
+	IGNORE string = "ignore" // Collect the event as usual
+	STRIP  string = "strip"  // Strip identifiers from the envelope
+	DROP   string = "drop"   // Drop the event
+)
+
+// Privacy signals
+const (
+	DNT_HEADER string = "DNT"
+	GPC_HEADER string = "Sec-GPC"
+)
+
+// Payload fields which identify a user or device
+var identifierFields = []string{
+	"network_userid",
+	"domain_userid",
+	"user_id",
+	"domain_sessionid",
+	"user_ipaddress",
+	"user_fingerprint",
+	"mac_address",
+	"refr_domain_userid",
+}
+
+// Headers which identify a user or device
+var identifierHeaders = []string{
+	"Cookie",
+	"Authorization",
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Real-Ip",
+	"True-Client-Ip",
+	"Cf-Connecting-Ip",
+}
+
+var privacyActions = stats.NewSchemaStats()
+
+// PrivacyActions returns the envelopes of each input which were ignored,
+// stripped, or dropped because of privacy signals
+func PrivacyActions() map[string]map[string]int64 {
+	return privacyActions.Snapshot()
+}
+
+// ValidatePrivacy returns an error if a privacy action is unsupported
+func ValidatePrivacy(conf config.Privacy) error {
+	for _, action := range []string{conf.Dnt, conf.Gpc} {
+		switch action {
+		case IGNORE, STRIP, DROP, "":
+		default:
+			return errors.New("unsupported privacy action: " + action)
+		}
+	}
+	return nil
+}
+
+func strictness(action string) int {
+	switch action {
+	case STRIP:
+		return 1
+	case DROP:
+		return 2
+	}
+	return 0
+}
+
+// privacyAction returns the strictest action of the request's signals, or
+// an empty string if it doesn't send any
+func privacyAction(c *gin.Context, conf config.Privacy) string {
+	var action string
+	if c.GetHeader(DNT_HEADER) == "1" {
+		action = conf.Dnt
+		if action == "" {
+			action = IGNORE
+		}
+	}
+	if c.GetHeader(GPC_HEADER) == "1" {
+		gpc := conf.Gpc
+		if gpc == "" {
+			gpc = IGNORE
+		}
+		if action == "" || strictness(gpc) > strictness(action) {
+			action = gpc
+		}
+	}
+	return action
+}
+
+// stripIdentifiers removes identifying payload fields, headers, and
+// captured request values from the envelope
+func stripIdentifiers(e *Envelope, fields []string) {
+	if payload := e.Payload; payload != nil {
+		stripped := make(Payload, len(payload))
+		for k, v := range payload {
+			stripped[k] = v
+		}
+		for _, f := range identifierFields {
+			delete(stripped, f)
+		}
+		for _, f := range fields {
+			delete(stripped, f)
+		}
+		e.Payload = stripped
+	}
+	StripIdentifyingContexts(e)
+}
+
+// StripIdentifyingContexts removes the client and captured request
+// contexts, and identifying headers, from the envelope
+func StripIdentifyingContexts(e *Envelope) {
+	if e.Contexts == nil {
+		return
+	}
+	contexts := make(Contexts)
+	for k, v := range *e.Contexts {
+		contexts[k] = v
+	}
+	delete(contexts, CAPTURE_CONTEXT)
+	delete(contexts, CLIENT_CONTEXT)
+	if headers, ok := contexts[HTTP_HEADERS_CONTEXT].(map[string]interface{}); ok {
+		stripped := make(map[string]interface{}, len(headers))
+		for k, v := range headers {
+			stripped[k] = v
+		}
+		for _, h := range identifierHeaders {
+			delete(stripped, h)
+		}
+		contexts[HTTP_HEADERS_CONTEXT] = stripped
+	}
+	e.Contexts = &contexts
+}
+
+// ApplyPrivacy handles the envelopes of a request which sends Do Not
+// Track or Global Privacy Control signals, returning the envelopes which
+// should be collected. Requests with both signals get the strictest action.
+func ApplyPrivacy(c *gin.Context, conf config.Privacy, input string, envelopes []Envelope) []Envelope {
+	action := privacyAction(c, conf)
+	if action == "" || len(envelopes) == 0 {
+		return envelopes
+	}
+	privacyActions.Increment(input, action, int64(len(envelopes)))
+	switch action {
+	case DROP:
+		return nil
+	case STRIP:
+		for i := range envelopes {
+			stripIdentifiers(&envelopes[i], conf.Fields)
+		}
+	}
+	return envelopes
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package envelope
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func privacyRequest(headers map[string]string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	for k, v := range headers {
+		c.Request.Header.Set(k, v)
+	}
+	return c
+}
+
+func privacyEnvelopes() []Envelope {
+	contexts := Contexts{
+		HTTP_HEADERS_CONTEXT: map[string]interface{}{"Cookie": "nuid=abc", "User-Agent": "browser", "Dnt": "1"},
+		CAPTURE_CONTEXT:      map[string]interface{}{"sp": "device"},
+	}
+	return []Envelope{{
+		Contexts: &contexts,
+		Payload:  Payload{"event": "page_view", "network_userid": "abc", "user_ipaddress": "10.0.0.1", "email": "a@b.c"},
+	}}
+}
+
+func TestApplyPrivacy(t *testing.T) {
+	conf := config.Privacy{Dnt: STRIP, Gpc: DROP, Fields: []string{"email"}}
+
+	t.Run("no signal", func(t *testing.T) {
+		envelopes := ApplyPrivacy(privacyRequest(nil), conf, "test", privacyEnvelopes())
+		assert.Equal(t, privacyEnvelopes(), envelopes)
+	})
+
+	t.Run("strip", func(t *testing.T) {
+		envelopes := ApplyPrivacy(privacyRequest(map[string]string{DNT_HEADER: "1"}), conf, "test", privacyEnvelopes())
+		assert.Equal(t, Payload{"event": "page_view"}, envelopes[0].Payload)
+		assert.Equal(t, map[string]interface{}{"User-Agent": "browser", "Dnt": "1"}, (*envelopes[0].Contexts)[HTTP_HEADERS_CONTEXT])
+		_, captured := (*envelopes[0].Contexts)[CAPTURE_CONTEXT]
+		assert.False(t, captured)
+	})
+
+	t.Run("strictest signal", func(t *testing.T) {
+		envelopes := ApplyPrivacy(privacyRequest(map[string]string{DNT_HEADER: "1", GPC_HEADER: "1"}), conf, "test", privacyEnvelopes())
+		assert.Empty(t, envelopes)
+	})
+
+	t.Run("ignore", func(t *testing.T) {
+		envelopes := ApplyPrivacy(privacyRequest(map[string]string{GPC_HEADER: "1"}), config.Privacy{}, "test", privacyEnvelopes())
+		assert.Equal(t, privacyEnvelopes(), envelopes)
+	})
+
+	assert.Equal(t, map[string]int64{STRIP: 1, DROP: 1, IGNORE: 1}, PrivacyActions()["test"])
+}
+
+func TestValidatePrivacy(t *testing.T) {
+	assert.Nil(t, ValidatePrivacy(config.Privacy{Dnt: IGNORE, Gpc: DROP}))
+	assert.NotNil(t, ValidatePrivacy(config.Privacy{Gpc: "anonymize"}))
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package envelope
+
+import (
+	"encoding/json"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The protobuf definition of the envelope, which is served by the schema
+// registry
+const PROTOBUF_SCHEMA string = "io.silverton/buz/internal/envelope/v1.0.proto"
+
+// Field numbers of the protobuf envelope
+const (
+	pbUuid protowire.Number = iota + 1
+	pbTimestamp
+	pbBuzTimestamp
+	pbDeviceCreatedTimestamp
+	pbDeviceSentTimestamp
+	pbDerivedTimestamp
+	pbBuzVersion
+	pbBuzName
+	pbBuzEnv
+	pbProtocol
+	pbSchema
+	pbVendor
+	pbNamespace
+	pbVersion
+	pbIsValid
+	pbValidationError
+	pbContexts
+	pbPayload
+)
+
+func appendPbMessage(b []byte, num protowire.Number, m proto.Message) ([]byte, error) {
+	encoded, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, encoded), nil
+}
+
+func appendPbTimestamp(b []byte, num protowire.Number, t *time.Time) ([]byte, error) {
+	if t == nil {
+		return b, nil
+	}
+	return appendPbMessage(b, num, timestamppb.New(*t))
+}
+
+// appendPbStruct appends a json object as a google.protobuf.Struct
+func appendPbStruct(b []byte, num protowire.Number, v interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := &structpb.Struct{}
+	if err := protojson.Unmarshal(encoded, s); err != nil {
+		return nil, err
+	}
+	return appendPbMessage(b, num, s)
+}
+
+// AsProtobuf encodes the envelope as the protobuf envelope message. Like
+// proto3, empty strings and false are omitted.
+func (e *Envelope) AsProtobuf() ([]byte, error) {
+	var b []byte
+	var err error
+	b = protowire.AppendTag(b, pbUuid, protowire.BytesType)
+	b = protowire.AppendString(b, e.Uuid.String())
+	// Fields are written in field number order
+	for _, f := range []struct {
+		num protowire.Number
+		t   *time.Time
+	}{
+		{pbTimestamp, &e.Timestamp},
+		{pbBuzTimestamp, &e.BuzTimestamp},
+		{pbDeviceCreatedTimestamp, e.DeviceCreatedTimestamp},
+		{pbDeviceSentTimestamp, e.DeviceSentTimestamp},
+		{pbDerivedTimestamp, &e.DerivedTimestamp},
+	} {
+		if b, err = appendPbTimestamp(b, f.num, f.t); err != nil {
+			return nil, err
+		}
+	}
+	for i, s := range []string{e.BuzVersion, e.BuzName, e.BuzEnv, e.Protocol, e.Schema, e.Vendor, e.Namespace, e.Version} {
+		if s != "" {
+			b = protowire.AppendTag(b, pbBuzVersion+protowire.Number(i), protowire.BytesType)
+			b = protowire.AppendString(b, s)
+		}
+	}
+	if e.IsValid {
+		b = protowire.AppendTag(b, pbIsValid, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	if e.ValidationError != nil {
+		if b, err = appendPbStruct(b, pbValidationError, e.ValidationError); err != nil {
+			return nil, err
+		}
+	}
+	if e.Contexts != nil {
+		if b, err = appendPbStruct(b, pbContexts, e.Contexts); err != nil {
+			return nil, err
+		}
+	}
+	if e.Payload != nil {
+		if b, err = appendPbStruct(b, pbPayload, e.Payload); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package envelope
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestAsProtobuf(t *testing.T) {
+	ts := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	e := Envelope{
+		Uuid:             uuid.New(),
+		Timestamp:        ts,
+		BuzTimestamp:     ts,
+		DerivedTimestamp: ts,
+		Schema:           "io.silverton/buz/example/v1.0.json",
+		IsValid:          true,
+		Payload:          Payload{"a": "b", "n": 1},
+	}
+	b, err := e.AsProtobuf()
+	assert.Nil(t, err)
+
+	fields := make(map[protowire.Number][]byte)
+	var numbers []protowire.Number
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		assert.True(t, n > 0)
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			fields[num], b = v, b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			fields[num], b = []byte{byte(v)}, b[n:]
+		}
+		numbers = append(numbers, num)
+	}
+	assert.Equal(t, []protowire.Number{pbUuid, pbTimestamp, pbBuzTimestamp, pbDerivedTimestamp, pbSchema, pbIsValid, pbPayload}, numbers)
+	assert.Equal(t, e.Uuid.String(), string(fields[pbUuid]))
+	assert.Equal(t, e.Schema, string(fields[pbSchema]))
+	assert.Equal(t, []byte{1}, fields[pbIsValid])
+
+	timestamp := &timestamppb.Timestamp{}
+	assert.Nil(t, proto.Unmarshal(fields[pbTimestamp], timestamp))
+	assert.Equal(t, ts, timestamp.AsTime())
+
+	payload := &structpb.Struct{}
+	assert.Nil(t, proto.Unmarshal(fields[pbPayload], payload))
+	assert.Equal(t, map[string]interface{}{"a": "b", "n": float64(1)}, payload.AsMap())
+}
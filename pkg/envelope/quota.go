@@ -0,0 +1,28 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package envelope
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/constants"
+)
+
+// Quota is attached to requests by the quota middleware, and counts the
+// envelopes built from them
+type Quota interface {
+	// Count returns the envelopes which should be collected, counting them
+	// against the quota
+	Count(envelopes []Envelope) []Envelope
+}
+
+// ApplyQuota counts the envelopes of a request against its quota, if it
+// has one, returning the envelopes which should be collected
+func ApplyQuota(c *gin.Context, envelopes []Envelope) []Envelope {
+	q, ok := c.Value(constants.QUOTA).(Quota)
+	if !ok || len(envelopes) == 0 {
+		return envelopes
+	}
+	return q.Count(envelopes)
+}
@@ -24,9 +24,12 @@ func (e Validation) Scan(input interface{}) error {
 }
 
 type PayloadValidationError struct {
-	Field       string `json:"field,omitempty"`
-	Description string `json:"description,omitempty"`
-	ErrorType   string `json:"errorType,omitempty"`
+	Field          string      `json:"field,omitempty"` // Json pointer to the failing payload value
+	Description    string      `json:"description,omitempty"`
+	ErrorType      string      `json:"errorType,omitempty"` // The failing schema keyword
+	SchemaLocation string      `json:"schemaLocation,omitempty"`
+	Expected       interface{} `json:"expected,omitempty"`
+	Actual         interface{} `json:"actual,omitempty"`
 }
 
 type ValidationError struct {
@@ -0,0 +1,167 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const (
+	// The protocol of erasure request envelopes
+	ERASURE                    string = "erasure"
+	DEFAULT_WEBHOOK_TIMEOUT_MS int    = 5000
+)
+
+// Request asks for the data of a subject to be erased. The request id
+// defaults to a new uuid, and can be set so that retries are idempotent
+// downstream.
+type Request struct {
+	RequestId   string `json:"requestId,omitempty"`
+	Subject     string `json:"subject"`
+	SubjectType string `json:"subjectType,omitempty"` // Such as userId, anonymousId, or email
+	Reason      string `json:"reason,omitempty"`
+}
+
+// Forward is the result of forwarding a request to a webhook
+type Forward struct {
+	Name   string `json:"name"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type webhook struct {
+	name   string
+	url    string
+	header http.Header
+}
+
+// Eraser turns erasure requests into envelopes for every sink, and
+// forwards them to downstream webhooks
+type Eraser struct {
+	app      config.App
+	webhooks []webhook
+	client   *http.Client
+	now      func() time.Time
+}
+
+func NewEraser(conf config.Erasure, app config.App) (*Eraser, error) {
+	timeout := conf.TimeoutMs
+	if timeout <= 0 {
+		timeout = DEFAULT_WEBHOOK_TIMEOUT_MS
+	}
+	e := &Eraser{
+		app:    app,
+		client: &http.Client{Timeout: time.Duration(timeout) * time.Millisecond},
+		now:    time.Now,
+	}
+	for _, w := range conf.Webhooks {
+		if w.Name == "" {
+			return nil, errors.New("erasure webhooks require a name")
+		}
+		if u, err := url.Parse(w.Url); err != nil || u.Host == "" {
+			return nil, errors.New("invalid erasure webhook url: " + w.Name)
+		}
+		header := http.Header{}
+		for k, v := range w.Headers {
+			header.Set(k, v)
+		}
+		e.webhooks = append(e.webhooks, webhook{name: w.Name, url: w.Url, header: header})
+	}
+	return e, nil
+}
+
+// payload returns the erasure event of the request
+func (e *Eraser) payload(req Request, requestedBy string) envelope.Payload {
+	p := envelope.Payload{
+		"requestId":   req.RequestId,
+		"subject":     req.Subject,
+		"requestedAt": e.now().UTC().Format(time.RFC3339Nano),
+	}
+	for k, v := range map[string]string{"subjectType": req.SubjectType, "reason": req.Reason, "requestedBy": requestedBy} {
+		if v != "" {
+			p[k] = v
+		}
+	}
+	return p
+}
+
+func (e *Eraser) envelope(payload envelope.Payload) envelope.Envelope {
+	env := envelope.NewEnvelope(e.app)
+	env.Protocol = ERASURE
+	env.Schema = envelope.ERASURE_REQUEST_SCHEMA
+	env.Vendor = "io.silverton"
+	env.Namespace = "buz.internal.erasure.request"
+	env.Version = "1.0"
+	env.IsValid = true
+	env.Payload = payload
+	return env
+}
+
+func (e *Eraser) post(ctx context.Context, w webhook, body []byte) Forward {
+	f := Forward{Name: w.name}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+	req.Header = w.header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	f.Status = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		f.Error = fmt.Sprintf("webhook responded %d", resp.StatusCode)
+	}
+	return f
+}
+
+// forward posts the payload to every webhook concurrently, returning
+// their results in the order they are configured
+func (e *Eraser) forward(ctx context.Context, payload envelope.Payload) []Forward {
+	if len(e.webhooks) == 0 {
+		return nil
+	}
+	body, _ := json.Marshal(payload)
+	forwards := make([]Forward, len(e.webhooks))
+	var wg sync.WaitGroup
+	for i, w := range e.webhooks {
+		wg.Add(1)
+		go func(i int, w webhook) {
+			defer wg.Done()
+			forwards[i] = e.post(ctx, w, body)
+		}(i, w)
+	}
+	wg.Wait()
+	return forwards
+}
+
+// normalize validates the request and sets its id
+func normalize(req *Request) error {
+	if req.Subject == "" {
+		return errors.New("erasure requests require a subject")
+	}
+	if req.RequestId == "" {
+		req.RequestId = uuid.New().String()
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package erasure
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/manifold"
+	"github.com/silverton-io/buz/pkg/response"
+)
+
+const ERASURE_ROUTE = "/c/erasure"
+
+type ErasureResponse struct {
+	RequestId string    `json:"requestId"`
+	Webhooks  []Forward `json:"webhooks,omitempty"`
+}
+
+// Handler emits an erasure request envelope to every sink, and forwards
+// the request to the webhooks. If a webhook fails the response is a 502,
+// so the request can be retried with the same request id.
+func Handler(e *Eraser, m manifold.Manifold) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var req Request
+		if err := c.ShouldBindJSON(&req); err != nil || normalize(&req) != nil {
+			c.JSON(http.StatusBadRequest, response.InvalidErasureRequest)
+			return
+		}
+		payload := e.payload(req, c.GetString(constants.AUTH_IDENTITY))
+		if err := m.Enqueue([]envelope.Envelope{e.envelope(payload)}); err != nil {
+			log.Error().Err(err).Str("requestId", req.RequestId).Msg("🔴 could not enqueue erasure request")
+			c.JSON(http.StatusInternalServerError, response.ErasureRequestFailed)
+			return
+		}
+		resp := ErasureResponse{RequestId: req.RequestId, Webhooks: e.forward(c, payload)}
+		status := http.StatusOK
+		for _, f := range resp.Webhooks {
+			if f.Error != "" {
+				log.Error().Str("webhook", f.Name).Str("error", f.Error).Str("requestId", req.RequestId).Msg("🔴 could not forward erasure request")
+				status = http.StatusBadGateway
+			}
+		}
+		log.Info().Str("requestId", req.RequestId).Msg("🟢 erasure requested")
+		c.JSON(status, resp)
+	}
+	return gin.HandlerFunc(fn)
+}
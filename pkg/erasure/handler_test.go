@@ -0,0 +1,159 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package erasure
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/manifold"
+	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/silverton-io/buz/pkg/transform"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingManifold struct {
+	envelopes []envelope.Envelope
+}
+
+func (m *recordingManifold) Initialize(registry *registry.Registry, sinks *[]backendutils.Sink, conf *config.Config, metadata *meta.CollectorMeta) error {
+	return nil
+}
+
+func (m *recordingManifold) Enqueue(envelopes []envelope.Envelope) error {
+	m.envelopes = append(m.envelopes, envelopes...)
+	return nil
+}
+
+func (m *recordingManifold) GetRegistry() *registry.Registry { return nil }
+func (m *recordingManifold) Stats() manifold.Stats           { return manifold.Stats{} }
+func (m *recordingManifold) Shutdown() error                 { return nil }
+
+func postErasure(e *gin.Engine, body string) (*httptest.ResponseRecorder, ErasureResponse) {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, ERASURE_ROUTE, strings.NewReader(body)))
+	var resp ErasureResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+func TestHandler(t *testing.T) {
+	var forwarded []map[string]interface{}
+	crm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer crm", r.Header.Get("Authorization"))
+		b, _ := io.ReadAll(r.Body)
+		var p map[string]interface{}
+		json.Unmarshal(b, &p)
+		forwarded = append(forwarded, p)
+	}))
+	defer crm.Close()
+	eraser, err := NewEraser(config.Erasure{
+		Enabled:  true,
+		Webhooks: []config.ErasureWebhook{{Name: "crm", Url: crm.URL, Headers: map[string]string{"Authorization": "Bearer crm"}}},
+	}, config.App{Name: "buz"})
+	assert.Nil(t, err)
+	m := &recordingManifold{}
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.POST(ERASURE_ROUTE, func(c *gin.Context) { c.Set(constants.AUTH_IDENTITY, "key:privacy") }, Handler(eraser, m))
+
+	rec, resp := postErasure(e, `{"subject": "user-1", "subjectType": "userId"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, resp.RequestId)
+	assert.Equal(t, []Forward{{Name: "crm", Status: http.StatusOK}}, resp.Webhooks)
+
+	assert.Len(t, m.envelopes, 1)
+	env := m.envelopes[0]
+	assert.True(t, env.IsErasureRequest())
+	assert.Equal(t, ERASURE, env.Protocol)
+	assert.Equal(t, resp.RequestId, env.Payload["requestId"])
+	assert.Equal(t, "user-1", env.Payload["subject"])
+	assert.Equal(t, "userId", env.Payload["subjectType"])
+	assert.Equal(t, "key:privacy", env.Payload["requestedBy"])
+	assert.Equal(t, "user-1", forwarded[0]["subject"])
+
+	// Retries keep their request id
+	_, resp = postErasure(e, `{"requestId": "gdpr-42", "subject": "user-1"}`)
+	assert.Equal(t, "gdpr-42", resp.RequestId)
+	assert.Equal(t, "gdpr-42", forwarded[1]["requestId"])
+
+	rec, _ = postErasure(e, `{"subjectType": "userId"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Len(t, m.envelopes, 2)
+}
+
+// transformingManifold runs envelopes through the transform pipeline, as
+// manifolds do, before recording them
+type transformingManifold struct {
+	recordingManifold
+	pipeline *transform.Pipeline
+}
+
+func (m *transformingManifold) Enqueue(envelopes []envelope.Envelope) error {
+	return m.recordingManifold.Enqueue(m.pipeline.Run(envelopes))
+}
+
+func TestHandlerSkipsTransforms(t *testing.T) {
+	pipeline, err := transform.BuildPipeline([]config.Transform{
+		{Name: "hash", Type: transform.HASH, Salt: "salt", Fields: []config.HashField{{Pointer: "/subject"}}},
+		{Name: "sample", Type: transform.SAMPLE, Rates: []config.SampleRate{{Schema: "io.silverton/", Rate: 0}}},
+	})
+	assert.Nil(t, err)
+	eraser, err := NewEraser(config.Erasure{Enabled: true}, config.App{})
+	assert.Nil(t, err)
+	m := &transformingManifold{pipeline: pipeline}
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.POST(ERASURE_ROUTE, Handler(eraser, m))
+
+	rec, _ := postErasure(e, `{"subject": "jane@example.com", "subjectType": "email"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	// The request is neither sampled out nor is its subject hashed
+	assert.Len(t, m.envelopes, 1)
+	assert.Equal(t, "jane@example.com", m.envelopes[0].Payload["subject"])
+
+	// Other envelopes of the same vendor are still transformed
+	assert.Empty(t, pipeline.Run([]envelope.Envelope{{Schema: "io.silverton/buz/internal/heartbeat/v1.0.json", Payload: envelope.Payload{}}}))
+}
+
+func TestHandlerWebhookFailure(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	eraser, err := NewEraser(config.Erasure{Webhooks: []config.ErasureWebhook{{Name: "email", Url: down.URL}}}, config.App{})
+	assert.Nil(t, err)
+	m := &recordingManifold{}
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.POST(ERASURE_ROUTE, Handler(eraser, m))
+
+	rec, resp := postErasure(e, `{"subject": "someone@example.com", "subjectType": "email"}`)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Webhooks[0].Status)
+	assert.NotEmpty(t, resp.Webhooks[0].Error)
+	// Sinks still receive the request
+	assert.Len(t, m.envelopes, 1)
+}
+
+func TestNewEraserErrors(t *testing.T) {
+	for _, w := range []config.ErasureWebhook{
+		{Url: "https://crm.example.com"},
+		{Name: "crm", Url: "crm"},
+	} {
+		_, err := NewEraser(config.Erasure{Webhooks: []config.ErasureWebhook{w}}, config.App{})
+		assert.NotNil(t, err)
+	}
+}
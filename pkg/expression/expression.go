@@ -0,0 +1,129 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package expression
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+var (
+	env     *cel.Env
+	envErr  error
+	envOnce sync.Once
+)
+
+// environment declares the envelope fields available to expressions, such
+// as `payload.env == "prod" && schema.startsWith("com.acme")`
+func environment() (*cel.Env, error) {
+	envOnce.Do(func() {
+		env, envErr = cel.NewEnv(
+			cel.Variable("uuid", cel.StringType),
+			cel.Variable("timestamp", cel.TimestampType),
+			cel.Variable("protocol", cel.StringType),
+			cel.Variable("schema", cel.StringType),
+			cel.Variable("vendor", cel.StringType),
+			cel.Variable("namespace", cel.StringType),
+			cel.Variable("version", cel.StringType),
+			cel.Variable("isValid", cel.BoolType),
+			cel.Variable("payload", cel.MapType(cel.StringType, cel.DynType)),
+			cel.Variable("contexts", cel.MapType(cel.StringType, cel.DynType)),
+		)
+	})
+	return env, envErr
+}
+
+// Expression is a compiled boolean CEL expression over envelopes
+type Expression struct {
+	source  string
+	program cel.Program
+}
+
+func Compile(source string) (*Expression, error) {
+	e, err := environment()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := e.Compile(source)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, errors.New("expression must evaluate to a bool: " + source)
+	}
+	program, err := e.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+	return &Expression{source: source, program: program}, nil
+}
+
+var (
+	compiled   = make(map[string]*Expression)
+	compiledMu sync.RWMutex
+)
+
+// Cached returns the compiled expression, compiling it the first time
+func Cached(source string) (*Expression, error) {
+	compiledMu.RLock()
+	expr, ok := compiled[source]
+	compiledMu.RUnlock()
+	if ok {
+		return expr, nil
+	}
+	expr, err := Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	compiledMu.Lock()
+	compiled[source] = expr
+	compiledMu.Unlock()
+	return expr, nil
+}
+
+func (x *Expression) String() string {
+	return x.source
+}
+
+func activation(e envelope.Envelope) map[string]interface{} {
+	payload := map[string]interface{}(e.Payload)
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	contexts := map[string]interface{}{}
+	if e.Contexts != nil {
+		contexts = *e.Contexts
+	}
+	return map[string]interface{}{
+		"uuid":      e.Uuid.String(),
+		"timestamp": e.Timestamp,
+		"protocol":  e.Protocol,
+		"schema":    e.Schema,
+		"vendor":    e.Vendor,
+		"namespace": e.Namespace,
+		"version":   e.Version,
+		"isValid":   e.IsValid,
+		"payload":   payload,
+		"contexts":  contexts,
+	}
+}
+
+// Match evaluates the expression against the envelope. Evaluation fails
+// if the expression refers to a missing field, such as `payload.env` when
+// the payload has no `env`. Use `has(payload.env)` to check first.
+func (x *Expression) Match(e envelope.Envelope) (bool, error) {
+	out, _, err := x.program.Eval(activation(e))
+	if err != nil {
+		return false, err
+	}
+	matched, ok := out.Value().(bool)
+	if !ok {
+		return false, errors.New("expression did not evaluate to a bool: " + x.source)
+	}
+	return matched, nil
+}
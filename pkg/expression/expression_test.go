@@ -0,0 +1,58 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package expression
+
+import (
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	e := envelope.Envelope{
+		Schema:   "com.acme/checkout/v1.0.json",
+		IsValid:  true,
+		Payload:  envelope.Payload{"env": "prod", "total": 10.5},
+		Contexts: &envelope.Contexts{"io.silverton/buz/internal/contexts/httpHeaders/v1.0.json": map[string]interface{}{"Origin": "acme.com"}},
+	}
+	var testCases = []struct {
+		expression string
+		want       bool
+		wantErr    bool
+	}{
+		{`payload.env == "prod" && schema.startsWith("com.acme")`, true, false},
+		{`payload.env == "dev"`, false, false},
+		{`payload.total > 10.0 && isValid`, true, false},
+		{`has(payload.region) && payload.region == "eu"`, false, false},
+		{`payload.region == "eu"`, false, true},
+		{`contexts["io.silverton/buz/internal/contexts/httpHeaders/v1.0.json"].Origin == "acme.com"`, true, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expression, func(t *testing.T) {
+			expr, err := Compile(tc.expression)
+			assert.Nil(t, err)
+			got, err := expr.Match(e)
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	_, err := Compile(`schema.startsWith(`)
+	assert.NotNil(t, err)
+	_, err = Compile(`schema`)
+	assert.NotNil(t, err, "expressions must be boolean")
+	_, err = Compile(`unknown == "a"`)
+	assert.NotNil(t, err)
+}
+
+func TestCached(t *testing.T) {
+	a, err := Cached(`isValid`)
+	assert.Nil(t, err)
+	b, _ := Cached(`isValid`)
+	assert.Same(t, a, b)
+}
@@ -6,11 +6,31 @@ package handler
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/health"
 	"github.com/silverton-io/buz/pkg/response"
 )
 
 func HealthcheckHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, response.Ok)
 }
+
+// DeepHealthcheckHandler checks the registry, sinks, and queues when
+// requested with ?deep=true, and responds 503 if any are unhealthy
+func DeepHealthcheckHandler(checker *health.Checker) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		if deep, _ := strconv.ParseBool(c.Query("deep")); !deep {
+			HealthcheckHandler(c)
+			return
+		}
+		report := checker.Check(c.Request.Context())
+		status := http.StatusOK
+		if report.Status != health.OK {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, report)
+	}
+	return gin.HandlerFunc(fn)
+}
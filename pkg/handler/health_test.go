@@ -13,6 +13,8 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/health"
 	"github.com/silverton-io/buz/pkg/response"
 )
 
@@ -35,3 +37,26 @@ func TestHealthcheckHandler(t *testing.T) {
 		t.Fatalf(`HealthcheckHandler returned body %v, want %v`, b, marshaledB)
 	}
 }
+
+func TestDeepHealthcheckHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/health", DeepHealthcheckHandler(health.NewChecker(config.Health{}, nil, nil)))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	marshaledOk, _ := json.Marshal(response.Ok)
+	if rec.Code != http.StatusOK || rec.Body.String() != string(marshaledOk) {
+		t.Fatalf(`DeepHealthcheckHandler returned %v %s without deep, want %v %s`, rec.Code, rec.Body.String(), http.StatusOK, marshaledOk)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health?deep=true", nil))
+	var report health.Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Could not unmarshal report: %v", err)
+	}
+	if report.Registry.Status != health.UNCHECKED {
+		t.Fatalf(`DeepHealthcheckHandler returned registry status %v, want %v`, report.Registry.Status, health.UNCHECKED)
+	}
+}
@@ -14,6 +14,7 @@ import (
 type systemPaths struct {
 	Health         string `json:"health"`
 	Stats          string `json:"stats"`
+	InputStats     string `json:"inputStats"`
 	RouteOverview  string `json:"routeOverview"`
 	ConfigOverview string `json:"configOverview"`
 }
@@ -53,6 +54,7 @@ func RouteOverviewHandler(conf config.Config) gin.HandlerFunc {
 			systemPaths{
 				Health:         constants.HEALTH_PATH,
 				Stats:          constants.STATS_PATH,
+				InputStats:     constants.INPUT_STATS_PATH,
 				RouteOverview:  constants.ROUTE_OVERVIEW_PATH,
 				ConfigOverview: constants.CONFIG_OVERVIEW_PATH,
 			},
@@ -72,9 +74,12 @@ func RouteOverviewHandler(conf config.Config) gin.HandlerFunc {
 	return gin.HandlerFunc(fn)
 }
 
+// ConfigOverviewHandler serves the config with its secrets masked
 func ConfigOverviewHandler(conf config.Config) gin.HandlerFunc {
+	// Redact patterns are validated when the app is configured
+	redacted, _ := config.Redact(conf, conf.App.RedactPatterns)
 	fn := func(c *gin.Context) {
-		c.JSON(200, conf)
+		c.JSON(200, redacted)
 	}
 	return gin.HandlerFunc(fn)
 }
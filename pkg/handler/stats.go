@@ -5,23 +5,75 @@
 package handler
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/annotator"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/manifold"
 	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/middleware"
 	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/silverton-io/buz/pkg/transform"
+	"github.com/silverton-io/buz/pkg/tuning"
 )
 
 type StatsResponse struct {
-	CollectorMeta *meta.CollectorMeta  `json:"collectorMeta"`
-	Stats         *stats.ProtocolStats `json:"stats"`
+	CollectorMeta      *meta.CollectorMeta           `json:"collectorMeta"`
+	Stats              manifold.Stats                `json:"stats"`
+	PiiDetections      map[string]map[string]int64   `json:"piiDetections"`
+	SampledOut         map[string]map[string]int64   `json:"sampledOut"`
+	SizeViolations     map[string]map[string]int64   `json:"sizeViolations"`
+	Sinks              map[string]stats.SinkSnapshot `json:"sinks"`
+	ApiKeys            map[string]map[string]int64   `json:"apiKeys"`
+	BotRejections      map[string]map[string]int64   `json:"botRejections"`
+	CircuitBreaker     map[string]map[string]int64   `json:"circuitBreaker"`
+	Privacy            map[string]map[string]int64   `json:"privacy"`
+	Consent            map[string]map[string]int64   `json:"consent"`
+	Abuse              map[string]map[string]int64   `json:"abuse"`
+	Quotas             map[string]map[string]int64   `json:"quotas"`
+	RequiredHeaders    map[string]map[string]int64   `json:"requiredHeaders"`
+	Validations        map[string]map[string]int64   `json:"validations"`
+	ValidationFailures map[string]map[string]int64   `json:"validationFailures"`
+	Runtime            tuning.Settings               `json:"runtime"`
 }
 
-func StatsHandler(m *meta.CollectorMeta) gin.HandlerFunc {
+// StatsHandler reports the runtime stats of the manifold and its sinks,
+// and the counts kept by middleware and transforms
+func StatsHandler(m *meta.CollectorMeta, mf manifold.Manifold) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		resp := StatsResponse{
-			CollectorMeta: m,
-			// Stats:         s,
+			CollectorMeta:      m,
+			Stats:              mf.Stats(),
+			PiiDetections:      transform.Detections(),
+			SampledOut:         transform.SampledOut(),
+			SizeViolations:     manifold.SizeViolations(),
+			Sinks:              backendutils.Stats().Snapshot(),
+			ApiKeys:            middleware.ApiKeyUsage(),
+			BotRejections:      middleware.BotRejections(),
+			CircuitBreaker:     middleware.CircuitBreakerRejections(),
+			Privacy:            envelope.PrivacyActions(),
+			Consent:            transform.ConsentActions(),
+			Abuse:              middleware.AbuseEvents(),
+			Quotas:             middleware.QuotaUsage(),
+			RequiredHeaders:    middleware.RequiredHeaderRejections(),
+			Validations:        annotator.Validations(),
+			ValidationFailures: annotator.ValidationFailures(),
+			Runtime:            tuning.Effective(),
 		}
 		c.JSON(200, resp)
 	}
 	return gin.HandlerFunc(fn)
 }
+
+type InputStatsResponse struct {
+	// Throughput by input and window, such as 5m
+	Inputs map[string]map[string]stats.InputWindow `json:"inputs"`
+}
+
+// InputStatsHandler summarizes the requests, events, invalid rate, and
+// latency of every input over rolling windows
+func InputStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, InputStatsResponse{Inputs: stats.Inputs().Snapshot()})
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/registry"
+)
+
+// Statuses
+const (
+	OK        string = "ok"
+	UNHEALTHY string = "unhealthy"
+	UNCHECKED string = "unchecked" // The dependency can't be checked, so it is assumed healthy
+)
+
+const (
+	DEFAULT_HEALTH_TIMEOUT_MS     int     = 2000
+	DEFAULT_HEALTH_CACHE_MS       int     = 5000
+	DEFAULT_MAX_QUEUE_UTILIZATION float64 = 0.9
+)
+
+type Dependency struct {
+	Status    string  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+}
+
+type Queue struct {
+	Status      string  `json:"status"`
+	Queued      int     `json:"queuedBatches"`
+	Capacity    int     `json:"queueCapacity"`
+	Utilization float64 `json:"utilization"`
+}
+
+// Report is the status of every dependency. It is unhealthy if any
+// dependency is.
+type Report struct {
+	Status    string                `json:"status"`
+	CheckedAt time.Time             `json:"checkedAt"`
+	Registry  Dependency            `json:"registry"`
+	Sinks     map[string]Dependency `json:"sinks"`
+	Queues    map[string]Queue      `json:"queues"`
+}
+
+// Checker verifies the registry backend and sinks are reachable, and
+// that sink queues have headroom
+type Checker struct {
+	registry       *registry.Registry
+	sinks          []backendutils.Sink
+	timeout        time.Duration
+	cacheFor       time.Duration
+	maxUtilization float64
+	mu             sync.Mutex
+	last           *Report
+}
+
+func NewChecker(conf config.Health, r *registry.Registry, sinks []backendutils.Sink) *Checker {
+	c := &Checker{
+		registry:       r,
+		sinks:          sinks,
+		timeout:        time.Duration(conf.TimeoutMs) * time.Millisecond,
+		cacheFor:       time.Duration(conf.CacheMs) * time.Millisecond,
+		maxUtilization: conf.MaxQueueUtilization,
+	}
+	if c.timeout <= 0 {
+		c.timeout = time.Duration(DEFAULT_HEALTH_TIMEOUT_MS) * time.Millisecond
+	}
+	if c.cacheFor <= 0 {
+		c.cacheFor = time.Duration(DEFAULT_HEALTH_CACHE_MS) * time.Millisecond
+	}
+	if c.maxUtilization <= 0 {
+		c.maxUtilization = DEFAULT_MAX_QUEUE_UTILIZATION
+	}
+	return c
+}
+
+// check runs the check until the deadline, since not every dependency's
+// client accepts a context
+func check(ctx context.Context, fn func(ctx context.Context) error) Dependency {
+	start := time.Now()
+	result := make(chan error, 1)
+	go func() { result <- fn(ctx) }()
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	d := Dependency{Status: OK, LatencyMs: float64(time.Since(start)) / float64(time.Millisecond)}
+	if err != nil {
+		d.Status, d.Error = UNHEALTHY, err.Error()
+	}
+	return d
+}
+
+func (c *Checker) checkRegistry(ctx context.Context) Dependency {
+	if c.registry == nil {
+		return Dependency{Status: UNCHECKED}
+	}
+	switch b := c.registry.Backend.(type) {
+	case registry.HealthCheckingBackend:
+		return check(ctx, b.Check)
+	case registry.SchemaListingBackend:
+		return check(ctx, func(context.Context) error {
+			_, err := b.ListRemote()
+			return err
+		})
+	}
+	return Dependency{Status: UNCHECKED}
+}
+
+func (c *Checker) checkSink(ctx context.Context, sink backendutils.Sink) Dependency {
+	if checker, ok := sink.(backendutils.HealthChecker); ok {
+		return check(ctx, checker.Check)
+	}
+	return Dependency{Status: UNCHECKED}
+}
+
+func (c *Checker) checkQueues() map[string]Queue {
+	queues := make(map[string]Queue)
+	for name, s := range backendutils.Stats().Snapshot() {
+		if s.QueueCapacity == 0 {
+			continue
+		}
+		q := Queue{Status: OK, Queued: s.QueuedBatches, Capacity: s.QueueCapacity}
+		q.Utilization = float64(q.Queued) / float64(q.Capacity)
+		if q.Utilization >= c.maxUtilization {
+			q.Status = UNHEALTHY
+		}
+		queues[name] = q
+	}
+	return queues
+}
+
+// Check returns the report of every dependency, which is reused until it
+// is older than the cache duration
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last != nil && time.Since(c.last.CheckedAt) < c.cacheFor {
+		return *c.last
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	r := Report{Status: OK, CheckedAt: time.Now().UTC(), Sinks: make(map[string]Dependency)}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.Registry = c.checkRegistry(ctx)
+	}()
+	for _, s := range c.sinks {
+		wg.Add(1)
+		go func(s backendutils.Sink) {
+			defer wg.Done()
+			d := c.checkSink(ctx, s)
+			mu.Lock()
+			r.Sinks[s.Metadata().Name] = d
+			mu.Unlock()
+		}(s)
+	}
+	wg.Wait()
+	r.Queues = c.checkQueues()
+	healthy := r.Registry.Status != UNHEALTHY
+	for _, d := range r.Sinks {
+		healthy = healthy && d.Status != UNHEALTHY
+	}
+	for _, q := range r.Queues {
+		healthy = healthy && q.Status != UNHEALTHY
+	}
+	if !healthy {
+		r.Status = UNHEALTHY
+	}
+	c.last = &r
+	return r
+}
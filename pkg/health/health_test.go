@@ -0,0 +1,93 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSink struct {
+	name  string
+	err   error
+	delay time.Duration
+	calls int
+}
+
+func (s *fakeSink) Metadata() backendutils.SinkMetadata {
+	return backendutils.SinkMetadata{Name: s.name}
+}
+func (s *fakeSink) Initialize(conf config.Sink) error           { return nil }
+func (s *fakeSink) StartWorker() error                          { return nil }
+func (s *fakeSink) Enqueue(envelopes []envelope.Envelope) error { return nil }
+func (s *fakeSink) Shutdown() error                             { return nil }
+func (s *fakeSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	return nil
+}
+
+// checkedSink can verify its connectivity
+type checkedSink struct{ fakeSink }
+
+func (s *checkedSink) Check(ctx context.Context) error {
+	s.calls++
+	select {
+	case <-time.After(s.delay):
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type listingBackend struct{ err error }
+
+func (b *listingBackend) Initialize(conf config.Backend) error      { return nil }
+func (b *listingBackend) GetRemote(schema string) ([]byte, error)   { return nil, nil }
+func (b *listingBackend) Close()                                    {}
+func (b *listingBackend) ListRemote() (schemas []string, err error) { return nil, b.err }
+
+func TestChecker(t *testing.T) {
+	healthy := &checkedSink{fakeSink{name: "health-kafka"}}
+	unchecked := &fakeSink{name: "health-stdout"}
+	r := &registry.Registry{Backend: &listingBackend{}}
+	c := NewChecker(config.Health{}, r, []backendutils.Sink{healthy, unchecked})
+
+	report := c.Check(context.Background())
+	assert.Equal(t, OK, report.Status)
+	assert.Equal(t, OK, report.Registry.Status)
+	assert.Equal(t, OK, report.Sinks["health-kafka"].Status)
+	assert.Equal(t, UNCHECKED, report.Sinks["health-stdout"].Status)
+
+	// Reports are cached
+	healthy.err = errors.New("broker down")
+	assert.Equal(t, OK, c.Check(context.Background()).Status)
+	assert.Equal(t, 1, healthy.calls)
+}
+
+func TestCheckerUnhealthy(t *testing.T) {
+	slow := &checkedSink{fakeSink{name: "health-slow", delay: time.Second}}
+	failing := &checkedSink{fakeSink{name: "health-failing", err: errors.New("auth failed")}}
+	r := &registry.Registry{Backend: &listingBackend{err: errors.New("bucket not found")}}
+	queue := make(chan int, 10)
+	for i := 0; i < 9; i++ {
+		queue <- i
+	}
+	backendutils.Stats().TrackQueue("health-full", func() int { return len(queue) }, cap(queue))
+	c := NewChecker(config.Health{TimeoutMs: 50}, r, []backendutils.Sink{slow, failing})
+
+	report := c.Check(context.Background())
+	assert.Equal(t, UNHEALTHY, report.Status)
+	assert.Equal(t, "bucket not found", report.Registry.Error)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Sinks["health-slow"].Error)
+	assert.Equal(t, "auth failed", report.Sinks["health-failing"].Error)
+	assert.Equal(t, Queue{Status: UNHEALTHY, Queued: 9, Capacity: 10, Utilization: 0.9}, report.Queues["health-full"])
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package heartbeat
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/manifold"
+	"github.com/silverton-io/buz/pkg/meta"
+)
+
+const (
+	// The protocol of heartbeat envelopes
+	HEARTBEAT                       string = "heartbeat"
+	DEFAULT_HEARTBEAT_SCHEMA        string = "io.silverton/buz/internal/heartbeat/v1.0.json"
+	DEFAULT_HEARTBEAT_INTERVAL_SECS int    = 60
+)
+
+// Heartbeat enqueues a heartbeat envelope to the manifold every interval.
+// Heartbeats are validated, transformed, and routed like any other
+// envelope, so their arrival shows the whole pipeline is working.
+type Heartbeat struct {
+	schema   string
+	interval time.Duration
+	app      config.App
+	meta     *meta.CollectorMeta
+	manifold manifold.Manifold
+	now      func() time.Time
+	sequence int64
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewHeartbeat(conf config.Heartbeat, app config.App, m *meta.CollectorMeta, mf manifold.Manifold) *Heartbeat {
+	h := &Heartbeat{
+		schema:   conf.Schema,
+		interval: time.Duration(conf.IntervalSeconds) * time.Second,
+		app:      app,
+		meta:     m,
+		manifold: mf,
+		now:      time.Now,
+	}
+	if h.schema == "" {
+		h.schema = DEFAULT_HEARTBEAT_SCHEMA
+	}
+	if h.interval <= 0 {
+		h.interval = time.Duration(DEFAULT_HEARTBEAT_INTERVAL_SECS) * time.Second
+	}
+	return h
+}
+
+func (h *Heartbeat) envelope() envelope.Envelope {
+	h.sequence++
+	e := envelope.NewEnvelope(h.app)
+	e.Protocol = HEARTBEAT
+	e.Schema = h.schema
+	e.Payload = envelope.Payload{
+		"time":            h.now().UTC().Format(time.RFC3339Nano),
+		"sequence":        h.sequence,
+		"intervalSeconds": int64(h.interval / time.Second),
+	}
+	if h.meta != nil {
+		e.Payload["instanceId"] = h.meta.InstanceId.String()
+		e.Payload["name"] = h.meta.Name
+		e.Payload["version"] = h.meta.Version
+		e.Payload["uptimeSeconds"] = h.meta.Elapsed()
+	}
+	return e
+}
+
+func (h *Heartbeat) beat() {
+	if err := h.manifold.Enqueue([]envelope.Envelope{h.envelope()}); err != nil {
+		log.Error().Err(err).Msg("🔴 could not enqueue heartbeat")
+	}
+}
+
+// Start emits a heartbeat, and then another every interval until the
+// heartbeat is closed
+func (h *Heartbeat) Start() {
+	h.stop, h.done = make(chan struct{}), make(chan struct{})
+	h.beat()
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.beat()
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the heartbeat. It must be called before the manifold is
+// shut down.
+func (h *Heartbeat) Close() {
+	if h.stop == nil {
+		return
+	}
+	close(h.stop)
+	<-h.done
+}
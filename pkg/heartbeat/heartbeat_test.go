@@ -0,0 +1,78 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package heartbeat
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/manifold"
+	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingManifold struct {
+	mu        sync.Mutex
+	envelopes []envelope.Envelope
+}
+
+func (m *recordingManifold) Initialize(registry *registry.Registry, sinks *[]backendutils.Sink, conf *config.Config, metadata *meta.CollectorMeta) error {
+	return nil
+}
+
+func (m *recordingManifold) Enqueue(envelopes []envelope.Envelope) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.envelopes = append(m.envelopes, envelopes...)
+	return nil
+}
+
+func (m *recordingManifold) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.envelopes)
+}
+
+func (m *recordingManifold) GetRegistry() *registry.Registry { return nil }
+func (m *recordingManifold) Stats() manifold.Stats           { return manifold.Stats{} }
+func (m *recordingManifold) Shutdown() error                 { return nil }
+
+func TestHeartbeat(t *testing.T) {
+	m := &recordingManifold{}
+	id := uuid.New()
+	h := NewHeartbeat(config.Heartbeat{}, config.App{Name: "buz"}, &meta.CollectorMeta{Name: "buz", Version: "1.0", InstanceId: id}, m)
+	h.interval = 10 * time.Millisecond
+	h.Start()
+	assert.Eventually(t, func() bool { return m.count() >= 3 }, time.Second, 5*time.Millisecond)
+	h.Close()
+	n := m.count()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, n, m.count())
+
+	for i, e := range m.envelopes {
+		assert.Equal(t, HEARTBEAT, e.Protocol)
+		assert.Equal(t, DEFAULT_HEARTBEAT_SCHEMA, e.Schema)
+		assert.Equal(t, int64(i+1), e.Payload["sequence"])
+		assert.Equal(t, id.String(), e.Payload["instanceId"])
+		// Heartbeats are validated by the pipeline, like any other envelope
+		assert.False(t, e.IsValid)
+	}
+}
+
+func TestNewHeartbeatDefaults(t *testing.T) {
+	h := NewHeartbeat(config.Heartbeat{Schema: "com.yourcompany/heartbeat/v1.0.json", IntervalSeconds: 5}, config.App{}, nil, &recordingManifold{})
+	assert.Equal(t, "com.yourcompany/heartbeat/v1.0.json", h.schema)
+	assert.Equal(t, 5*time.Second, h.interval)
+
+	h = NewHeartbeat(config.Heartbeat{}, config.App{}, nil, &recordingManifold{})
+	assert.Equal(t, DEFAULT_HEARTBEAT_SCHEMA, h.schema)
+	assert.Equal(t, time.Duration(DEFAULT_HEARTBEAT_INTERVAL_SECS)*time.Second, h.interval)
+}
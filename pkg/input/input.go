@@ -4,11 +4,16 @@
 package input
 
 import (
+	"errors"
+	"net/http"
+	"reflect"
+
 	"github.com/gin-gonic/gin"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/envelope"
 	"github.com/silverton-io/buz/pkg/manifold"
 	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/response"
 )
 
 type Input interface {
@@ -19,3 +24,49 @@ type Input interface {
 	// Routes() []string
 	// Auth() interface{}
 }
+
+// EnqueueFailed responds to a request whose envelopes the manifold didn't accept
+func EnqueueFailed(c *gin.Context, err error) {
+	if errors.Is(err, manifold.ErrTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, response.EnvelopesTooLarge)
+		return
+	}
+	if errors.Is(err, manifold.ErrOverloaded) {
+		c.Header("Retry-After", response.RETRY_AFTER_3)
+		c.JSON(http.StatusTooManyRequests, response.ManifoldOverloaded)
+		return
+	}
+	c.Header("Retry-After", response.RETRY_AFTER_60)
+	c.JSON(http.StatusServiceUnavailable, response.ManifoldDistributionError)
+}
+
+// Named inputs are known by name, such as to the api keys allowed to use
+// them
+type Named interface {
+	Name() string
+}
+
+// Name returns the name of the input, or its type name if it isn't named
+func Name(i Input) string {
+	if n, ok := i.(Named); ok {
+		return n.Name()
+	}
+	t := reflect.TypeOf(i)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+var registered []Input
+
+// Register adds a custom input, which is initialized alongside the built-in
+// inputs. Inputs must be registered before the app is initialized.
+func Register(i Input) {
+	registered = append(registered, i)
+}
+
+// Registered returns the custom inputs
+func Registered() []Input {
+	return registered
+}
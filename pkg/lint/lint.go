@@ -0,0 +1,89 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package lint
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/compiler"
+	"github.com/tidwall/gjson"
+)
+
+// Lint rules
+const (
+	RULE_JSON        string = "json"
+	RULE_JSON_SCHEMA string = "jsonSchema"
+	RULE_SELF        string = "self"
+	RULE_NAMING      string = "naming"
+	RULE_DESCRIPTION string = "description"
+)
+
+var (
+	vendorPattern    = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)+$`)
+	namespacePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*(\.[a-zA-Z][a-zA-Z0-9_]*)*$`)
+	versionPattern   = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+)
+
+type LintError struct {
+	Rule    string `json:"rule"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Lint checks that a candidate schema is a valid json schema, carries the
+// self-describing metadata buz relies on, and follows naming conventions.
+// If name is non-empty it is checked against the schema's metadata. The
+// loader resolves references to other registry schemas, and may be nil.
+func Lint(name string, schema []byte, load compiler.Loader) []LintError {
+	if !gjson.ValidBytes(schema) || !gjson.ParseBytes(schema).IsObject() {
+		return []LintError{{Rule: RULE_JSON, Message: "schema must be a json object"}}
+	}
+	var errs []LintError
+	if _, err := compiler.CompileWith(schema, load); err != nil {
+		errs = append(errs, LintError{Rule: RULE_JSON_SCHEMA, Message: err.Error()})
+	}
+	s := gjson.ParseBytes(schema)
+	if s.Get("description").String() == "" {
+		errs = append(errs, LintError{Rule: RULE_DESCRIPTION, Field: "description", Message: "schema should be described"})
+	}
+	vendor, namespace, version := s.Get("self.vendor").String(), s.Get("self.namespace").String(), s.Get("self.version").String()
+	for _, field := range []string{"self.vendor", "self.namespace", "self.version"} {
+		if s.Get(field).String() == "" {
+			errs = append(errs, LintError{Rule: RULE_SELF, Field: field, Message: field + " is required"})
+		}
+	}
+	if vendor != "" && !vendorPattern.MatchString(vendor) {
+		errs = append(errs, LintError{Rule: RULE_NAMING, Field: "self.vendor", Message: "vendor should be a reverse domain name, such as com.acme"})
+	}
+	if namespace != "" && !namespacePattern.MatchString(namespace) {
+		errs = append(errs, LintError{Rule: RULE_NAMING, Field: "self.namespace", Message: "namespace should be dot-separated identifiers, such as web.pageView"})
+	}
+	if version != "" && !versionPattern.MatchString(version) {
+		errs = append(errs, LintError{Rule: RULE_NAMING, Field: "self.version", Message: "version should be dot-separated numbers, such as 1.0"})
+	}
+	if name != "" {
+		errs = append(errs, lintName(name, s.Get("$id").String(), vendor, version)...)
+	}
+	return errs
+}
+
+// lintName checks that the schema name agrees with the schema's metadata
+func lintName(name string, id string, vendor string, version string) []LintError {
+	var errs []LintError
+	if !strings.HasSuffix(name, ".json") {
+		name = name + ".json"
+	}
+	if id != "" && id != name {
+		errs = append(errs, LintError{Rule: RULE_NAMING, Field: "$id", Message: "$id should match the schema name " + name})
+	}
+	if vendor != "" && !strings.HasPrefix(name, vendor+"/") {
+		errs = append(errs, LintError{Rule: RULE_NAMING, Field: "self.vendor", Message: "schema name should begin with the vendor " + vendor})
+	}
+	if version != "" && !strings.HasSuffix(name, "/v"+version+".json") {
+		errs = append(errs, LintError{Rule: RULE_NAMING, Field: "self.version", Message: "schema name should end with the version v" + version + ".json"})
+	}
+	return errs
+}
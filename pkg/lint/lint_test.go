@@ -0,0 +1,72 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package lint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const validSchema = `{
+	"$id": "com.acme/web/pageView/v1.0.json",
+	"description": "A page view",
+	"self": {"vendor": "com.acme", "namespace": "web.pageView", "version": "1.0"},
+	"type": "object"
+}`
+
+func rules(errs []LintError) []string {
+	var r []string
+	for _, e := range errs {
+		r = append(r, e.Rule+":"+e.Field)
+	}
+	return r
+}
+
+func TestLint(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		schema string
+		want   []string
+	}{
+		{"valid", validSchema, nil},
+		{"not json", `{"type": `, []string{"json:"}},
+		{"not an object", `[]`, []string{"json:"}},
+		{
+			"invalid json schema",
+			`{"description": "d", "self": {"vendor": "com.acme", "namespace": "web", "version": "1.0"}, "type": 10}`,
+			[]string{"jsonSchema:"},
+		},
+		{
+			"missing metadata",
+			`{"description": "d", "self": {"vendor": "com.acme", "namespace": "web"}}`,
+			[]string{"self:self.version"},
+		},
+		{
+			"bad naming",
+			`{"description": "d", "self": {"vendor": "Acme", "namespace": "web-page", "version": "one"}}`,
+			[]string{"naming:self.vendor", "naming:self.namespace", "naming:self.version"},
+		},
+		{
+			"undescribed",
+			`{"self": {"vendor": "com.acme", "namespace": "web", "version": "1.0"}}`,
+			[]string{"description:description"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ElementsMatch(t, tc.want, rules(Lint("", []byte(tc.schema), nil)))
+		})
+	}
+}
+
+func TestLintName(t *testing.T) {
+	assert.Empty(t, Lint("com.acme/web/pageView/v1.0", []byte(validSchema), nil))
+	assert.ElementsMatch(
+		t,
+		[]string{"naming:$id", "naming:self.vendor", "naming:self.version"},
+		rules(Lint("io.other/web/pageView/v2.0.json", []byte(validSchema), nil)),
+	)
+}
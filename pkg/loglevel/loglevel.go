@@ -0,0 +1,146 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package loglevel
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/middleware"
+	"github.com/silverton-io/buz/pkg/response"
+)
+
+const LOG_LEVEL_ROUTE = "/c/log/level"
+
+var ErrInvalidLevel = errors.New("invalid log level")
+
+// State is the log level and whether requests are logged
+type State struct {
+	Level         string     `json:"level"`
+	RequestLogger bool       `json:"requestLogger"`
+	RevertsAt     *time.Time `json:"revertsAt,omitempty"`
+}
+
+// Change changes the log level and/or request logger. With ttlSeconds,
+// both are reverted once it passes, so debug logging left on by accident
+// doesn't flood the logs.
+type Change struct {
+	Level         string `json:"level,omitempty"`
+	RequestLogger *bool  `json:"requestLogger,omitempty"`
+	TtlSeconds    int    `json:"ttlSeconds,omitempty"`
+}
+
+// Controller changes the global log level and request logger at runtime
+type Controller struct {
+	mu        sync.Mutex
+	previous  *State
+	revert    *time.Timer
+	revertsAt *time.Time
+	changes   int // Reverts of superseded changes are ignored
+	now       func() time.Time
+}
+
+func NewController() *Controller {
+	return &Controller{now: time.Now}
+}
+
+func (ctl *Controller) current() State {
+	return State{
+		Level:         zerolog.GlobalLevel().String(),
+		RequestLogger: middleware.RequestLogging(),
+		RevertsAt:     ctl.revertsAt,
+	}
+}
+
+// State returns the current log level and request logger
+func (ctl *Controller) State() State {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	return ctl.current()
+}
+
+// Apply applies the change, cancelling any pending revert. A change with
+// a ttl reverts to the state before the first change which is still
+// pending, so successive changes don't extend debug logging forever.
+func (ctl *Controller) Apply(change Change) (State, error) {
+	var level zerolog.Level
+	if change.Level != "" {
+		l, err := zerolog.ParseLevel(change.Level)
+		if err != nil || l == zerolog.NoLevel {
+			return State{}, ErrInvalidLevel
+		}
+		level = l
+	}
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	before := ctl.current()
+	ctl.changes++
+	if ctl.revert != nil {
+		ctl.revert.Stop()
+		ctl.revert, ctl.revertsAt = nil, nil
+	}
+	if ctl.previous == nil {
+		ctl.previous = &before
+	}
+	if change.Level != "" {
+		zerolog.SetGlobalLevel(level)
+	}
+	if change.RequestLogger != nil {
+		middleware.SetRequestLogging(*change.RequestLogger)
+	}
+	if change.TtlSeconds > 0 {
+		ttl := time.Duration(change.TtlSeconds) * time.Second
+		at := ctl.now().Add(ttl).UTC()
+		ctl.revertsAt = &at
+		n := ctl.changes
+		ctl.revert = time.AfterFunc(ttl, func() { ctl.restore(n) })
+	} else {
+		ctl.previous = nil
+	}
+	after := ctl.current()
+	log.Warn().Str("level", after.Level).Bool("requestLogger", after.RequestLogger).Msg("🟡 log level changed")
+	return after, nil
+}
+
+// restore reverts to the state before the pending change
+func (ctl *Controller) restore(n int) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	if n != ctl.changes || ctl.previous == nil {
+		return
+	}
+	level, _ := zerolog.ParseLevel(ctl.previous.Level)
+	zerolog.SetGlobalLevel(level)
+	middleware.SetRequestLogging(ctl.previous.RequestLogger)
+	ctl.previous, ctl.revert, ctl.revertsAt = nil, nil, nil
+	log.Warn().Str("level", level.String()).Msg("🟡 log level reverted")
+}
+
+// Handler returns the current state on GET, and applies a Change on PUT
+func Handler(ctl *Controller) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet {
+			c.JSON(http.StatusOK, ctl.State())
+			return
+		}
+		var change Change
+		if err := c.ShouldBindJSON(&change); err != nil {
+			c.JSON(http.StatusBadRequest, response.InvalidLogLevel)
+			return
+		}
+		state, err := ctl.Apply(change)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, response.InvalidLogLevel)
+			return
+		}
+		c.JSON(http.StatusOK, state)
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package loglevel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/silverton-io/buz/pkg/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func request(e *gin.Engine, method string, body string) (int, State) {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(method, LOG_LEVEL_ROUTE, strings.NewReader(body)))
+	var s State
+	json.Unmarshal(rec.Body.Bytes(), &s)
+	return rec.Code, s
+}
+
+func TestHandler(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	defer middleware.SetRequestLogging(false)
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	middleware.SetRequestLogging(false)
+	ctl := NewController()
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.GET(LOG_LEVEL_ROUTE, Handler(ctl))
+	e.PUT(LOG_LEVEL_ROUTE, Handler(ctl))
+
+	code, s := request(e, http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, State{Level: "info"}, s)
+
+	code, s = request(e, http.MethodPut, `{"level": "debug", "requestLogger": true, "ttlSeconds": 600}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "debug", s.Level)
+	assert.True(t, s.RequestLogger)
+	assert.NotNil(t, s.RevertsAt)
+	assert.Equal(t, zerolog.DebugLevel, zerolog.GlobalLevel())
+	assert.True(t, middleware.RequestLogging())
+
+	// A second change keeps reverting to the state before the first
+	_, s = request(e, http.MethodPut, `{"level": "trace", "ttlSeconds": 600}`)
+	assert.Equal(t, "trace", s.Level)
+	assert.True(t, s.RequestLogger)
+	ctl.restore(ctl.changes - 1)
+	assert.Equal(t, zerolog.TraceLevel, zerolog.GlobalLevel())
+	ctl.restore(ctl.changes)
+	assert.Equal(t, zerolog.InfoLevel, zerolog.GlobalLevel())
+	assert.False(t, middleware.RequestLogging())
+	assert.Nil(t, ctl.State().RevertsAt)
+
+	// Changes without a ttl are kept
+	_, s = request(e, http.MethodPut, `{"level": "warn"}`)
+	assert.Equal(t, "warn", s.Level)
+	assert.Nil(t, s.RevertsAt)
+
+	for _, body := range []string{`{"level": "loud"}`, `{"level": 1}`} {
+		code, _ = request(e, http.MethodPut, body)
+		assert.Equal(t, http.StatusBadRequest, code)
+	}
+	assert.Equal(t, zerolog.WarnLevel, zerolog.GlobalLevel())
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/annotator"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/silverton-io/buz/pkg/tap"
+	"github.com/silverton-io/buz/pkg/transform"
+)
+
+const (
+	DEFAULT_BATCH_MAX_ENVELOPES int = 500
+	DEFAULT_BATCH_LINGER_MS     int = 1000
+)
+
+// batcher accumulates envelopes for a single sink, so a slow sink
+// doesn't hold up delivery to the others.
+type batcher struct {
+	sink         backendutils.Sink
+	maxEnvelopes int
+	linger       time.Duration
+	idle         time.Duration
+	input        *admission
+	done         chan struct{}
+}
+
+func (b *batcher) flush(batch []envelope.Envelope) []envelope.Envelope {
+	if len(batch) == 0 {
+		return batch
+	}
+	log.Debug().Interface("metadata", b.sink.Metadata()).Int("envelopes", len(batch)).Msg("🟡 flushing batch to sink")
+	if err := b.sink.Enqueue(batch); err != nil {
+		log.Error().Err(err).Interface("metadata", b.sink.Metadata()).Msg("🔴 failed to enqueue batch to sink")
+		return nil
+	}
+	backendutils.Stats().Enqueued(b.sink.Metadata().Name, len(batch))
+	return nil
+}
+
+// stopTimer stops the timer and drains its channel, so it can be reset
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
+
+// run delivers a batch once it reaches maxEnvelopes, once its oldest
+// envelope has waited for the linger duration, or once no envelopes have
+// arrived for the idle duration. Remaining envelopes are flushed when the
+// input is closed.
+func (b *batcher) run() {
+	defer close(b.done)
+	var batch []envelope.Envelope
+	lingerTimer, idleTimer := time.NewTimer(b.linger), time.NewTimer(b.idle)
+	stopTimer(lingerTimer)
+	stopTimer(idleTimer)
+	var lingering, idling <-chan time.Time
+	reset := func() {
+		stopTimer(lingerTimer)
+		stopTimer(idleTimer)
+		lingering, idling = nil, nil
+	}
+	for {
+		select {
+		case envelopes, ok := <-b.input.queue:
+			if !ok {
+				reset()
+				b.flush(batch)
+				return
+			}
+			if len(envelopes) == 0 {
+				continue
+			}
+			if len(batch) == 0 {
+				lingerTimer.Reset(b.linger)
+				lingering = lingerTimer.C
+			}
+			if b.idle > 0 {
+				stopTimer(idleTimer)
+				idleTimer.Reset(b.idle)
+				idling = idleTimer.C
+			}
+			batch = append(batch, envelopes...)
+			if len(batch) >= b.maxEnvelopes {
+				reset()
+				batch = b.flush(batch)
+			}
+		case <-lingering:
+			lingering = nil
+			stopTimer(idleTimer)
+			idling = nil
+			batch = b.flush(batch)
+		case <-idling:
+			idling = nil
+			stopTimer(lingerTimer)
+			lingering = nil
+			batch = b.flush(batch)
+		}
+	}
+}
+
+// newBatcher returns the batcher of the sink, which overrides the batch
+// size, linger, and idle timeout of the manifold if it sets them
+func newBatcher(sink backendutils.Sink, conf config.Batch, input *admission) *batcher {
+	metadata := sink.Metadata()
+	maxEnvelopes, lingerMs, idleMs := conf.MaxEnvelopes, conf.LingerMs, conf.IdleMs
+	if metadata.BatchSize > 0 {
+		maxEnvelopes = metadata.BatchSize
+	}
+	if metadata.LingerMs > 0 {
+		lingerMs = metadata.LingerMs
+	}
+	if metadata.IdleMs > 0 {
+		idleMs = metadata.IdleMs
+	}
+	if maxEnvelopes <= 0 {
+		maxEnvelopes = DEFAULT_BATCH_MAX_ENVELOPES
+	}
+	if lingerMs <= 0 {
+		lingerMs = DEFAULT_BATCH_LINGER_MS
+	}
+	log.Info().Interface("metadata", metadata).Int("maxEnvelopes", maxEnvelopes).Int("lingerMs", lingerMs).Int("idleMs", idleMs).Msg("🟢 starting sink batcher")
+	return &batcher{
+		sink:         sink,
+		maxEnvelopes: maxEnvelopes,
+		linger:       time.Duration(lingerMs) * time.Millisecond,
+		idle:         time.Duration(idleMs) * time.Millisecond,
+		input:        input,
+		done:         make(chan struct{}),
+	}
+}
+
+// A manifold which accumulates envelopes into batches for each sink,
+// trading a bounded amount of latency for fewer, larger writes.
+type BatchingManifold struct {
+	registry      *registry.Registry
+	sinks         *[]backendutils.Sink
+	conf          *config.Config
+	pipeline      *transform.Pipeline
+	limits        *sizeLimits
+	counts        *counts
+	collectorMeta *meta.CollectorMeta
+	batchers      []*batcher
+	mu            sync.RWMutex
+	admit         sync.Mutex // Serializes enqueues, so the space waited for in each queue isn't taken
+	closed        bool
+}
+
+func (m *BatchingManifold) Initialize(registry *registry.Registry, sinks *[]backendutils.Sink, conf *config.Config, metadata *meta.CollectorMeta) error {
+	m.registry = registry
+	m.sinks = sinks
+	m.conf = conf
+	pipeline, err := transform.BuildPipeline(conf.Transforms)
+	if err != nil {
+		return err
+	}
+	m.pipeline = pipeline
+	limits, err := newSizeLimits(conf.Manifold.Limits)
+	if err != nil {
+		return err
+	}
+	m.limits = limits
+	m.counts = newCounts()
+	m.collectorMeta = metadata
+	log.Info().Interface("batch", conf.Manifold.Batch).Msg("🟢 initializing batching manifold")
+	for i, sink := range *sinks {
+		input, err := newAdmission(conf.Manifold.Overload, "batching-"+strconv.Itoa(i))
+		if err != nil {
+			return err
+		}
+		b := newBatcher(sink, conf.Manifold.Batch, input)
+		backendutils.Stats().TrackQueue(sink.Metadata().Name, func() int { return len(input.queue) }, cap(input.queue))
+		m.batchers = append(m.batchers, b)
+		go b.run()
+	}
+	return nil
+}
+
+func (m *BatchingManifold) Enqueue(envelopes []envelope.Envelope) error {
+	annotatedEnvelopes, release := m.pipeline.RunReleasable(annotator.Retain(annotator.Annotate(envelopes, m.registry, m.conf.Validation), m.conf.Retention))
+	annotatedEnvelopes, err := m.limits.enforce(annotatedEnvelopes)
+	m.counts.record(len(envelopes), annotatedEnvelopes, err)
+	if err != nil {
+		release()
+		return err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		release()
+		return ErrManifoldShutdown
+	}
+	m.admit.Lock()
+	defer m.admit.Unlock()
+	// Envelopes are queued for every sink or none, so the envelopes which
+	// a client retries aren't duplicated in the sinks which accepted them
+	err = waitForSpace(m.conf.Manifold.Overload, func() bool {
+		for _, b := range m.batchers {
+			if b.input.full() {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		for _, b := range m.batchers {
+			backendutils.Stats().Dropped(b.sink.Metadata().Name, len(annotatedEnvelopes))
+		}
+		release()
+		return err
+	}
+	for _, b := range m.batchers {
+		// Every queue has space, but spilling envelopes may still fail
+		if sinkErr := b.input.offer(annotatedEnvelopes); sinkErr != nil {
+			if errors.Is(sinkErr, ErrOverloaded) {
+				backendutils.Stats().Dropped(b.sink.Metadata().Name, len(annotatedEnvelopes))
+			}
+			err = sinkErr
+		}
+	}
+	if err == nil {
+		tap.Publish(annotatedEnvelopes)
+	}
+	return err
+}
+
+func (m *BatchingManifold) GetRegistry() *registry.Registry {
+	return m.registry
+}
+
+func (m *BatchingManifold) Stats() Stats {
+	return m.counts.stats(m.collectorMeta)
+}
+
+// Shutdown flushes outstanding batches before shutting down the sinks
+func (m *BatchingManifold) Shutdown() error {
+	log.Info().Msg("🟢 shutting down batching manifold")
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	for _, b := range m.batchers {
+		b.input.close()
+		close(b.input.queue)
+	}
+	m.mu.Unlock()
+	for _, b := range m.batchers {
+		<-b.done
+	}
+	shutdownSinks(*m.sinks, ShutdownTimeout(m.conf.App))
+	if err := m.pipeline.Close(); err != nil {
+		log.Error().Err(err).Msg("🔴 transforms did not safely shut down")
+	}
+	log.Info().Msg("🟢 manifold shut down")
+	return nil
+}
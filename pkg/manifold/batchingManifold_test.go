@@ -0,0 +1,156 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/backend/embedded"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSink struct {
+	mu       sync.Mutex
+	metadata backendutils.SinkMetadata
+	batches  [][]envelope.Envelope
+	dequeued map[string]int
+	delay    time.Duration
+	active   int
+	peak     int
+	shutdown bool
+}
+
+func (s *recordingSink) Metadata() backendutils.SinkMetadata { return s.metadata }
+func (s *recordingSink) Initialize(conf config.Sink) error   { return nil }
+func (s *recordingSink) StartWorker() error                  { return nil }
+
+func (s *recordingSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	s.mu.Lock()
+	s.active++
+	if s.active > s.peak {
+		s.peak = s.active
+	}
+	s.mu.Unlock()
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	if s.dequeued == nil {
+		s.dequeued = make(map[string]int)
+	}
+	s.dequeued[output] += len(envelopes)
+	return nil
+}
+
+func (s *recordingSink) Enqueue(envelopes []envelope.Envelope) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, envelopes)
+	return nil
+}
+
+func (s *recordingSink) Shutdown() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = true
+	return nil
+}
+
+func (s *recordingSink) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sizes []int
+	for _, b := range s.batches {
+		sizes = append(sizes, len(b))
+	}
+	return sizes
+}
+
+func testBatchingManifold(t *testing.T, batch config.Batch) (*BatchingManifold, *recordingSink) {
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	sink := &recordingSink{}
+	sinks := []backendutils.Sink{sink}
+	conf := &config.Config{
+		Validation: config.Validation{Mode: "skip"},
+		Manifold:   config.Manifold{Type: BATCHING, Batch: batch},
+	}
+	m := &BatchingManifold{}
+	assert.Nil(t, m.Initialize(r, &sinks, conf, nil))
+	return m, sink
+}
+
+func envelopes(n int) []envelope.Envelope {
+	e := make([]envelope.Envelope, n)
+	for i := range e {
+		e[i] = envelope.Envelope{Schema: "io.silverton/buz/example/productView/v1.0.json"}
+	}
+	return e
+}
+
+func TestBatchingManifoldFlushesAtMaxEnvelopes(t *testing.T) {
+	m, sink := testBatchingManifold(t, config.Batch{MaxEnvelopes: 3, LingerMs: 60000})
+	assert.Nil(t, m.Enqueue(envelopes(2)))
+	assert.Nil(t, m.Enqueue(envelopes(2)))
+	assert.Eventually(t, func() bool { return len(sink.sizes()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int{4}, sink.sizes())
+}
+
+func TestBatchingManifoldFlushesAfterLinger(t *testing.T) {
+	m, sink := testBatchingManifold(t, config.Batch{MaxEnvelopes: 100, LingerMs: 20})
+	assert.Nil(t, m.Enqueue(envelopes(1)))
+	assert.Nil(t, m.Enqueue(envelopes(1)))
+	assert.Eventually(t, func() bool { return len(sink.sizes()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int{2}, sink.sizes())
+}
+
+func TestBatchingManifoldFlushesOnShutdown(t *testing.T) {
+	m, sink := testBatchingManifold(t, config.Batch{MaxEnvelopes: 100, LingerMs: 60000})
+	assert.Nil(t, m.Enqueue(envelopes(5)))
+	assert.Nil(t, m.Shutdown())
+	assert.Equal(t, []int{5}, sink.sizes())
+	assert.True(t, sink.shutdown)
+	assert.ErrorIs(t, m.Enqueue(envelopes(1)), ErrManifoldShutdown)
+}
+
+func TestBatchingManifoldFlushesWhenIdle(t *testing.T) {
+	m, sink := testBatchingManifold(t, config.Batch{MaxEnvelopes: 100, LingerMs: 60000, IdleMs: 20})
+	assert.Nil(t, m.Enqueue(envelopes(1)))
+	assert.Nil(t, m.Enqueue(envelopes(2)))
+	assert.Eventually(t, func() bool { return len(sink.sizes()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int{3}, sink.sizes())
+}
+
+func TestNewBatcherUsesSinkOverrides(t *testing.T) {
+	conf := config.Batch{MaxEnvelopes: 100, LingerMs: 1000}
+	b := newBatcher(&recordingSink{}, conf, nil)
+	assert.Equal(t, 100, b.maxEnvelopes)
+	assert.Equal(t, time.Second, b.linger)
+	assert.Equal(t, time.Duration(0), b.idle)
+	sink := &recordingSink{metadata: backendutils.SinkMetadata{BatchSize: 10, LingerMs: 50, IdleMs: 5}}
+	b = newBatcher(sink, conf, nil)
+	assert.Equal(t, 10, b.maxEnvelopes)
+	assert.Equal(t, 50*time.Millisecond, b.linger)
+	assert.Equal(t, 5*time.Millisecond, b.idle)
+}
+
+func TestBuildManifold(t *testing.T) {
+	m, err := BuildManifold(config.Manifold{})
+	assert.Nil(t, err)
+	assert.IsType(t, &ChannelManifold{}, m)
+	m, err = BuildManifold(config.Manifold{Type: BATCHING})
+	assert.Nil(t, err)
+	assert.IsType(t, &BatchingManifold{}, m)
+	_, err = BuildManifold(config.Manifold{Type: "kernel"})
+	assert.NotNil(t, err)
+	_, err = BuildManifold(config.Manifold{Type: CHANNEL, Wal: config.Wal{Enabled: true}})
+	assert.NotNil(t, err)
+}
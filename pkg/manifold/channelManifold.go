@@ -5,6 +5,8 @@
 package manifold
 
 import (
+	"sync"
+
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/annotator"
 	"github.com/silverton-io/buz/pkg/backend/backendutils"
@@ -12,58 +14,131 @@ import (
 	"github.com/silverton-io/buz/pkg/envelope"
 	"github.com/silverton-io/buz/pkg/meta"
 	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/silverton-io/buz/pkg/tap"
+	"github.com/silverton-io/buz/pkg/transform"
 )
 
+// A manifold which gives each sink its own bounded queue, and a goroutine
+// passing queued envelopes to the sink. A slow sink fills its own queue
+// without holding up delivery to the others, and the overload policy
+// applies to each queue separately. If only some of the queues accept the
+// envelopes, they are accepted, and dead-lettered for the sinks whose
+// queues rejected them, since clients retrying them would duplicate them
+// in the others.
 type ChannelManifold struct {
 	registry      *registry.Registry
 	sinks         *[]backendutils.Sink
 	conf          *config.Config
+	pipeline      *transform.Pipeline
+	limits        *sizeLimits
+	counts        *counts
 	collectorMeta *meta.CollectorMeta
-	inputChan     chan []envelope.Envelope
-	shutdown      chan int
+	lanes         []*lane
+	mu            sync.RWMutex
+	closed        bool
+}
+
+// lane is the queue in front of a sink
+type lane struct {
+	sink  backendutils.Sink
+	input *admission
+	done  chan struct{}
+}
+
+func (l *lane) run() {
+	defer close(l.done)
+	for envelopes := range l.input.queue {
+		if err := l.sink.Enqueue(envelopes); err != nil {
+			log.Error().Err(err).Interface("metadata", l.sink.Metadata()).Msg("failed to enqueue envelopes to sink")
+			continue
+		}
+		backendutils.Stats().Enqueued(l.sink.Metadata().Name, len(envelopes))
+	}
 }
 
 func (m *ChannelManifold) Initialize(registry *registry.Registry, sinks *[]backendutils.Sink, conf *config.Config, metadata *meta.CollectorMeta) error {
 	m.registry = registry
 	m.sinks = sinks
 	m.conf = conf
+	pipeline, err := transform.BuildPipeline(conf.Transforms)
+	if err != nil {
+		return err
+	}
+	m.pipeline = pipeline
+	limits, err := newSizeLimits(conf.Manifold.Limits)
+	if err != nil {
+		return err
+	}
+	m.limits = limits
+	m.counts = newCounts()
 	m.collectorMeta = metadata
-	m.inputChan = make(chan []envelope.Envelope, 2)
-	m.shutdown = make(chan int, 1)
-	go func(envelopes <-chan []envelope.Envelope, shutdown chan int) {
-		for {
-			select {
-			case envelopes := <-envelopes:
-				for _, sink := range *m.sinks {
-					err := sink.Enqueue(envelopes)
-					if err != nil {
-						log.Error().Err(err).Interface("metadata", sink.Metadata()).Msg("failed to enqueue envelopes to sink")
-					}
-				}
-			case <-shutdown:
-				// Read all envelopes from input channel and pass to all sinks
-				// FIXME
-				// Then send shutdown sig to all sinks
-				log.Info().Msg("🟢 shutting down all sinks")
-				for _, s := range *m.sinks {
-					err := s.Shutdown()
-					if err != nil {
-						meta := s.Metadata()
-						log.Error().Err(err).Interface("metadata", meta).Msg("sink did not safely shut down")
-					}
-				}
-				log.Info().Msg("🟢 manifold shut down")
-				return
-			}
+	for _, sink := range *sinks {
+		// Spilled envelopes are kept per sink, so they are found again after a restart
+		input, err := newAdmission(conf.Manifold.Overload, "channel-"+sink.Metadata().Name)
+		if err != nil {
+			return err
 		}
-	}(m.inputChan, m.shutdown)
+		l := &lane{sink: sink, input: input, done: make(chan struct{})}
+		backendutils.Stats().TrackQueue(sink.Metadata().Name, func() int { return len(l.input.queue) }, cap(l.input.queue))
+		m.lanes = append(m.lanes, l)
+		go l.run()
+	}
 	return nil
 }
 
 func (m *ChannelManifold) Enqueue(envelopes []envelope.Envelope) error {
-	annotatedEnvelopes := annotator.Annotate(envelopes, m.registry)
+	annotatedEnvelopes, release := m.pipeline.RunReleasable(annotator.Retain(annotator.Annotate(envelopes, m.registry, m.conf.Validation), m.conf.Retention))
+	annotatedEnvelopes, err := m.limits.enforce(annotatedEnvelopes)
+	m.counts.record(len(envelopes), annotatedEnvelopes, err)
+	if err != nil {
+		release()
+		return err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		release()
+		return ErrManifoldShutdown
+	}
 	// anonymizedEnvelopes := privacy.AnonymizeEnvelopes(annotatedEnvelopes, m.conf.Privacy)
-	m.inputChan <- annotatedEnvelopes
+	// Each lane is offered the envelopes at once, so a lane which blocks
+	// until its deadline doesn't hold up the others
+	errs := make([]error, len(m.lanes))
+	var wg sync.WaitGroup
+	for i, l := range m.lanes {
+		wg.Add(1)
+		go func(i int, l *lane) {
+			defer wg.Done()
+			errs[i] = l.input.offer(annotatedEnvelopes)
+		}(i, l)
+	}
+	wg.Wait()
+	accepted := 0
+	for _, err := range errs {
+		if err == nil {
+			accepted++
+		}
+	}
+	for i, l := range m.lanes {
+		if errs[i] == nil {
+			continue
+		}
+		err = errs[i]
+		if accepted == 0 {
+			// The client retries the envelopes, so they aren't dead-lettered
+			backendutils.Stats().Dropped(l.sink.Metadata().Name, len(annotatedEnvelopes))
+			continue
+		}
+		log.Error().Err(err).Interface("metadata", l.sink.Metadata()).Msg("🔴 could not queue envelopes for sink, dead-lettering them")
+		if dlqErr := backendutils.DeadLetter(l.sink, annotatedEnvelopes, err); dlqErr != nil {
+			backendutils.Stats().Dropped(l.sink.Metadata().Name, len(annotatedEnvelopes))
+		}
+	}
+	if accepted == 0 && len(m.lanes) > 0 {
+		release()
+		return err
+	}
+	tap.Publish(annotatedEnvelopes)
 	return nil
 }
 
@@ -71,8 +146,32 @@ func (m *ChannelManifold) GetRegistry() *registry.Registry {
 	return m.registry
 }
 
+func (m *ChannelManifold) Stats() Stats {
+	return m.counts.stats(m.collectorMeta)
+}
+
+// Shutdown delivers queued envelopes before shutting down the sinks
 func (m *ChannelManifold) Shutdown() error {
 	log.Info().Msg("🟢 shutting down channel manifold")
-	m.shutdown <- 1
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	for _, l := range m.lanes {
+		// Stop queueing spilled envelopes before closing the queue
+		l.input.close()
+		close(l.input.queue)
+	}
+	m.mu.Unlock()
+	for _, l := range m.lanes {
+		<-l.done
+	}
+	shutdownSinks(*m.sinks, ShutdownTimeout(m.conf.App))
+	if err := m.pipeline.Close(); err != nil {
+		log.Error().Err(err).Msg("🔴 transforms did not safely shut down")
+	}
+	log.Info().Msg("🟢 manifold shut down")
 	return nil
 }
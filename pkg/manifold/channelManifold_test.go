@@ -0,0 +1,148 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/backend/embedded"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelManifoldDeliversQueuedEnvelopesOnShutdown(t *testing.T) {
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	sink := &recordingSink{}
+	sinks := []backendutils.Sink{sink}
+	conf := &config.Config{
+		Validation: config.Validation{Mode: "skip"},
+		Manifold:   config.Manifold{Overload: config.Overload{QueueSize: 10}},
+	}
+	m := &ChannelManifold{}
+	assert.Nil(t, m.Initialize(r, &sinks, conf, nil))
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, m.Enqueue(envelopes(2)))
+	}
+	assert.Nil(t, m.Shutdown())
+	assert.Equal(t, []int{2, 2, 2, 2, 2}, sink.sizes())
+	assert.True(t, sink.shutdown)
+	assert.ErrorIs(t, m.Enqueue(envelopes(1)), ErrManifoldShutdown)
+}
+
+// blockedSink doesn't accept envelopes until it is released
+type blockedSink struct {
+	recordingSink
+	release chan struct{}
+}
+
+func (s *blockedSink) Enqueue(envelopes []envelope.Envelope) error {
+	<-s.release
+	return s.recordingSink.Enqueue(envelopes)
+}
+
+type recordingDeadLetterWriter struct {
+	mu      sync.Mutex
+	written map[string]int
+}
+
+func (w *recordingDeadLetterWriter) WriteFailed(sink backendutils.SinkMetadata, output string, envelopes []envelope.Envelope, err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.written == nil {
+		w.written = make(map[string]int)
+	}
+	w.written[sink.Name] += len(envelopes)
+	return nil
+}
+
+func (w *recordingDeadLetterWriter) sinks() map[string]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written
+}
+
+func TestChannelManifoldIsolatesSlowSinks(t *testing.T) {
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	slow := &blockedSink{recordingSink: recordingSink{metadata: backendutils.SinkMetadata{Name: "slow"}}, release: make(chan struct{})}
+	fast := &recordingSink{metadata: backendutils.SinkMetadata{Name: "fast"}}
+	sinks := []backendutils.Sink{slow, fast}
+	conf := &config.Config{
+		Validation: config.Validation{Mode: "skip"},
+		Manifold:   config.Manifold{Overload: config.Overload{Policy: SHED, QueueSize: 1}},
+	}
+	m := &ChannelManifold{}
+	assert.Nil(t, m.Initialize(r, &sinks, conf, nil))
+	dlq := &recordingDeadLetterWriter{}
+	backendutils.SetDeadLetterWriter(dlq)
+	defer backendutils.SetDeadLetterWriter(nil)
+	// The slow sink holds one batch and queues another, then sheds the rest,
+	// which are dead-lettered rather than retried by the client
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, m.Enqueue(envelopes(1)))
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []int{1, 1, 1, 1, 1}, fast.sizes())
+	assert.Empty(t, slow.sizes())
+	assert.Equal(t, map[string]int{"slow": 3}, dlq.sinks())
+	close(slow.release)
+	assert.Nil(t, m.Shutdown())
+	assert.Equal(t, []int{1, 1}, slow.sizes())
+}
+
+func TestChannelManifoldRejectsEnvelopesNoSinkAccepts(t *testing.T) {
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	slow := &blockedSink{recordingSink: recordingSink{metadata: backendutils.SinkMetadata{Name: "slow"}}, release: make(chan struct{})}
+	sinks := []backendutils.Sink{slow}
+	conf := &config.Config{
+		Validation: config.Validation{Mode: "skip"},
+		Manifold:   config.Manifold{Overload: config.Overload{Policy: SHED, QueueSize: 1}},
+	}
+	m := &ChannelManifold{}
+	assert.Nil(t, m.Initialize(r, &sinks, conf, nil))
+	dlq := &recordingDeadLetterWriter{}
+	backendutils.SetDeadLetterWriter(dlq)
+	defer backendutils.SetDeadLetterWriter(nil)
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, m.Enqueue(envelopes(1)))
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Clients retry envelopes which no sink accepted, so they aren't dead-lettered
+	assert.ErrorIs(t, m.Enqueue(envelopes(1)), ErrOverloaded)
+	assert.Empty(t, dlq.sinks())
+	close(slow.release)
+	assert.Nil(t, m.Shutdown())
+}
+
+func TestChannelManifoldDoesNotBlockOnSlowSinks(t *testing.T) {
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	slow := &blockedSink{recordingSink: recordingSink{metadata: backendutils.SinkMetadata{Name: "slow"}}, release: make(chan struct{})}
+	fast := &recordingSink{metadata: backendutils.SinkMetadata{Name: "fast"}}
+	sinks := []backendutils.Sink{slow, fast}
+	conf := &config.Config{
+		Validation: config.Validation{Mode: "skip"},
+		Manifold:   config.Manifold{Overload: config.Overload{Policy: BLOCK, DeadlineMs: 500, QueueSize: 1}},
+	}
+	m := &ChannelManifold{}
+	assert.Nil(t, m.Initialize(r, &sinks, conf, nil))
+	// Fill the slow sink's queue
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, m.Enqueue(envelopes(1)))
+		time.Sleep(10 * time.Millisecond)
+	}
+	result := make(chan error, 1)
+	go func() { result <- m.Enqueue(envelopes(1)) }()
+	// The fast sink gets the envelopes while the slow sink's queue is still blocked
+	assert.Eventually(t, func() bool { return len(fast.sizes()) == 3 }, 250*time.Millisecond, 5*time.Millisecond)
+	// The envelopes were queued for the fast sink, so they aren't rejected
+	assert.Nil(t, <-result)
+	close(slow.release)
+	assert.Nil(t, m.Shutdown())
+	assert.Equal(t, []int{1, 1}, slow.sizes())
+}
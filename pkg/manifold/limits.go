@@ -0,0 +1,155 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/silverton-io/buz/pkg/validator"
+)
+
+// Size limit actions
+const (
+	REJECT   string = "reject"   // Reject the request
+	INVALID  string = "invalid"  // Route the envelope to invalid sinks
+	TRUNCATE string = "truncate" // Truncate free-text fields, and route the envelope to invalid sinks if it is still too large
+)
+
+const (
+	DEFAULT_MAX_FIELD_BYTES int    = 1024
+	BATCH                   string = "batch"
+)
+
+// ErrTooLarge is returned when envelopes are rejected because an event
+// or the batch is over the size limit
+var ErrTooLarge = errors.New("envelopes are too large")
+
+var violations = stats.NewSchemaStats()
+
+// SizeViolations returns the number of size limit violations by schema
+// and action
+func SizeViolations() map[string]map[string]int64 {
+	return violations.Snapshot()
+}
+
+// sizeLimits protects sinks with message size caps, by limiting the
+// serialized size of each envelope and of each batch
+type sizeLimits struct {
+	maxEventBytes int
+	maxBatchBytes int
+	maxFieldBytes int
+	action        string
+}
+
+func newSizeLimits(conf config.Limits) (*sizeLimits, error) {
+	l := &sizeLimits{
+		maxEventBytes: conf.MaxEventBytes,
+		maxBatchBytes: conf.MaxBatchBytes,
+		maxFieldBytes: conf.MaxFieldBytes,
+		action:        conf.Action,
+	}
+	if l.maxFieldBytes <= 0 {
+		l.maxFieldBytes = DEFAULT_MAX_FIELD_BYTES
+	}
+	switch l.action {
+	case "":
+		l.action = REJECT
+	case REJECT, INVALID, TRUNCATE:
+	default:
+		return nil, errors.New("unsupported size limit action: " + l.action)
+	}
+	return l, nil
+}
+
+func sizeOf(e envelope.Envelope) int {
+	b, err := e.AsByte()
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
+
+// truncate returns a copy of the value with strings cut to the max
+// length, without splitting multi-byte characters
+func truncate(v interface{}, max int) interface{} {
+	switch v := v.(type) {
+	case string:
+		if len(v) <= max {
+			return v
+		}
+		cut := max
+		for cut > 0 && !utf8.RuneStart(v[cut]) {
+			cut--
+		}
+		return v[:cut]
+	case map[string]interface{}:
+		truncated := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			truncated[k] = truncate(item, max)
+		}
+		return truncated
+	case envelope.Payload:
+		return truncate(map[string]interface{}(v), max)
+	case []interface{}:
+		truncated := make([]interface{}, len(v))
+		for i, item := range v {
+			truncated[i] = truncate(item, max)
+		}
+		return truncated
+	}
+	return v
+}
+
+func invalidateTooLarge(e *envelope.Envelope, size int, max int) {
+	e.IsValid = false
+	e.ValidationError = &envelope.ValidationError{
+		ErrorType:       &validator.EventTooLarge.Type,
+		ErrorResolution: &validator.EventTooLarge.Resolution,
+		Errors: []envelope.PayloadValidationError{{
+			Description: "event is larger than the size limit",
+			Expected:    max,
+			Actual:      size,
+		}},
+	}
+}
+
+// enforce applies the limits to the envelopes, returning ErrTooLarge if
+// they should be rejected
+func (l *sizeLimits) enforce(envelopes []envelope.Envelope) ([]envelope.Envelope, error) {
+	if l.maxEventBytes <= 0 && l.maxBatchBytes <= 0 {
+		return envelopes, nil
+	}
+	var total int
+	for i, e := range envelopes {
+		size := sizeOf(e)
+		if l.maxEventBytes > 0 && size > l.maxEventBytes {
+			violations.Increment(e.Schema, l.action, 1)
+			switch l.action {
+			case REJECT:
+				return nil, ErrTooLarge
+			case TRUNCATE:
+				e.Payload = truncate(e.Payload, l.maxFieldBytes).(map[string]interface{})
+				if size = sizeOf(e); size > l.maxEventBytes {
+					invalidateTooLarge(&e, size, l.maxEventBytes)
+				}
+			case INVALID:
+				invalidateTooLarge(&e, size, l.maxEventBytes)
+			}
+			envelopes[i] = e
+		}
+		total += size
+	}
+	if l.maxBatchBytes > 0 && total > l.maxBatchBytes {
+		for _, e := range envelopes {
+			violations.Increment(e.Schema, BATCH, 1)
+		}
+		return nil, ErrTooLarge
+	}
+	return envelopes, nil
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func sized(schema string, text string) envelope.Envelope {
+	return envelope.Envelope{Schema: schema, IsValid: true, Payload: envelope.Payload{"text": text, "n": 1}}
+}
+
+func TestSizeLimits(t *testing.T) {
+	long := strings.Repeat("é", 1000)
+
+	t.Run("reject", func(t *testing.T) {
+		l, _ := newSizeLimits(config.Limits{MaxEventBytes: 1000})
+		_, err := l.enforce([]envelope.Envelope{sized("io.silverton/reject", "short"), sized("io.silverton/reject", long)})
+		assert.ErrorIs(t, err, ErrTooLarge)
+		assert.Equal(t, int64(1), SizeViolations()["io.silverton/reject"][REJECT])
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		l, _ := newSizeLimits(config.Limits{MaxEventBytes: 1000, Action: INVALID})
+		limited, err := l.enforce([]envelope.Envelope{sized("io.silverton/invalid", "short"), sized("io.silverton/invalid", long)})
+		assert.Nil(t, err)
+		assert.True(t, limited[0].IsValid)
+		assert.False(t, limited[1].IsValid)
+		assert.Equal(t, long, limited[1].Payload["text"])
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		l, _ := newSizeLimits(config.Limits{MaxEventBytes: 1000, Action: TRUNCATE, MaxFieldBytes: 101})
+		original := sized("io.silverton/truncate", long)
+		limited, err := l.enforce([]envelope.Envelope{original})
+		assert.Nil(t, err)
+		assert.True(t, limited[0].IsValid)
+		// Multi-byte characters aren't split
+		assert.Equal(t, strings.Repeat("é", 50), limited[0].Payload["text"])
+		assert.Equal(t, 1, limited[0].Payload["n"])
+		assert.Equal(t, long, original.Payload["text"])
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		l, _ := newSizeLimits(config.Limits{MaxBatchBytes: 1500})
+		_, err := l.enforce([]envelope.Envelope{sized("io.silverton/batch", long)})
+		assert.ErrorIs(t, err, ErrTooLarge)
+		limited, err := l.enforce([]envelope.Envelope{sized("io.silverton/batch", "short")})
+		assert.Nil(t, err)
+		assert.Len(t, limited, 1)
+	})
+
+	t.Run("unsupported action", func(t *testing.T) {
+		_, err := newSizeLimits(config.Limits{Action: "explode"})
+		assert.NotNil(t, err)
+	})
+}
@@ -5,6 +5,11 @@
 package manifold
 
 import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/backend/backendutils"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/envelope"
@@ -12,9 +17,72 @@ import (
 	"github.com/silverton-io/buz/pkg/registry"
 )
 
+var ErrManifoldShutdown = errors.New("manifold is shut down")
+
 type Manifold interface {
 	Initialize(registry *registry.Registry, sinks *[]backendutils.Sink, conf *config.Config, metadata *meta.CollectorMeta) error
 	Enqueue(envelopes []envelope.Envelope) error
 	GetRegistry() *registry.Registry
+	Stats() Stats
 	Shutdown() error
 }
+
+// Manifold types
+const (
+	SIMPLE   string = "simple"
+	CHANNEL  string = "channel"
+	BATCHING string = "batching"
+	POOL     string = "pool"
+)
+
+const DEFAULT_SHUTDOWN_TIMEOUT_MS int = 15000
+
+// ShutdownTimeout returns how long the server waits for in-flight
+// requests, and then how long the manifold waits for sinks to drain
+func ShutdownTimeout(conf config.App) time.Duration {
+	if conf.ShutdownTimeoutMs <= 0 {
+		return time.Duration(DEFAULT_SHUTDOWN_TIMEOUT_MS) * time.Millisecond
+	}
+	return time.Duration(conf.ShutdownTimeoutMs) * time.Millisecond
+}
+
+// shutdownSinks waits, up to the timeout, for each sink to deliver the
+// envelopes it has queued, and then shuts the sinks down
+func shutdownSinks(sinks []backendutils.Sink, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, s := range sinks {
+		wg.Add(1)
+		go func(s backendutils.Sink) {
+			defer wg.Done()
+			if err := backendutils.Drain(s, timeout); err != nil {
+				log.Error().Err(err).Interface("metadata", s.Metadata()).Msg("🔴 sink did not drain before shutting down")
+			}
+		}(s)
+	}
+	wg.Wait()
+	log.Info().Msg("🟢 shutting down all sinks")
+	for _, s := range sinks {
+		if err := s.Shutdown(); err != nil {
+			log.Error().Err(err).Interface("metadata", s.Metadata()).Msg("sink did not safely shut down")
+		}
+	}
+}
+
+// BuildManifold returns the configured manifold, defaulting to the channel manifold
+func BuildManifold(conf config.Manifold) (Manifold, error) {
+	if conf.Wal.Enabled && conf.Type != POOL {
+		// Other manifolds hand envelopes to sinks without knowing when they are delivered
+		return nil, errors.New("the write-ahead log requires the pool manifold")
+	}
+	switch conf.Type {
+	case SIMPLE:
+		return &SimpleManifold{}, nil
+	case CHANNEL, "":
+		return &ChannelManifold{}, nil
+	case BATCHING:
+		return &BatchingManifold{}, nil
+	case POOL:
+		return &PoolManifold{}, nil
+	}
+	return nil, errors.New("unsupported manifold type: " + conf.Type)
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// Overload policies
+const (
+	BLOCK string = "block" // Wait for space in the queue, until the deadline
+	SHED  string = "shed"  // Reject envelopes immediately
+	SPILL string = "spill" // Write envelopes to disk, and queue them once there is space
+)
+
+const (
+	DEFAULT_QUEUE_SIZE int           = 2
+	SPILL_SUFFIX       string        = ".json"
+	SPILL_POLL         time.Duration = 100 * time.Millisecond
+	// How often blocked enqueues check every sink's queue for space
+	ADMISSION_POLL time.Duration = 5 * time.Millisecond
+)
+
+// ErrOverloaded is returned when envelopes are rejected because the
+// manifold's queues are full. Clients should retry later.
+var ErrOverloaded = errors.New("manifold is overloaded")
+
+// admission applies the overload policy to a bounded queue
+type admission struct {
+	policy   string
+	deadline time.Duration
+	queue    chan []envelope.Envelope
+	spill    *spill
+}
+
+func queueSize(conf config.Overload) int {
+	if conf.QueueSize <= 0 {
+		return DEFAULT_QUEUE_SIZE
+	}
+	return conf.QueueSize
+}
+
+// newAdmission returns the admission of a new queue. Queues which spill
+// are named so their envelopes are found again after a restart.
+func newAdmission(conf config.Overload, name string) (*admission, error) {
+	a := &admission{
+		policy:   conf.Policy,
+		deadline: time.Duration(conf.DeadlineMs) * time.Millisecond,
+		queue:    make(chan []envelope.Envelope, queueSize(conf)),
+	}
+	switch conf.Policy {
+	case BLOCK, SHED, "":
+	case SPILL:
+		if conf.SpillPath == "" {
+			return nil, errors.New("the spill overload policy requires a spill path")
+		}
+		s, err := openSpill(filepath.Join(conf.SpillPath, name), a.queue)
+		if err != nil {
+			return nil, err
+		}
+		a.spill = s
+	default:
+		return nil, errors.New("unsupported overload policy: " + conf.Policy)
+	}
+	return a, nil
+}
+
+// offer queues the envelopes according to the policy
+func (a *admission) offer(envelopes []envelope.Envelope) error {
+	select {
+	case a.queue <- envelopes:
+		return nil
+	default:
+	}
+	switch a.policy {
+	case SHED:
+		return ErrOverloaded
+	case SPILL:
+		return a.spill.write(envelopes)
+	}
+	if a.deadline <= 0 {
+		a.queue <- envelopes
+		return nil
+	}
+	timer := time.NewTimer(a.deadline)
+	defer timer.Stop()
+	select {
+	case a.queue <- envelopes:
+		return nil
+	case <-timer.C:
+		return ErrOverloaded
+	}
+}
+
+// full returns true if the queue has no space, and envelopes can't be
+// spilled instead
+func (a *admission) full() bool {
+	return a.spill == nil && len(a.queue) >= cap(a.queue)
+}
+
+// waitForSpace waits, according to the overload policy, until full
+// returns false. Manifolds which queue envelopes for several sinks wait
+// for space in every queue before queueing them, so envelopes are queued
+// for every sink or for none, and clients which retry rejected envelopes
+// don't duplicate them. The caller must serialize enqueues, so the space
+// isn't taken by another enqueue.
+func waitForSpace(conf config.Overload, full func() bool) error {
+	if !full() {
+		return nil
+	}
+	if conf.Policy == SHED {
+		return ErrOverloaded
+	}
+	var deadline <-chan time.Time
+	if conf.DeadlineMs > 0 {
+		timer := time.NewTimer(time.Duration(conf.DeadlineMs) * time.Millisecond)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(ADMISSION_POLL)
+	defer ticker.Stop()
+	for full() {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return ErrOverloaded
+		}
+	}
+	return nil
+}
+
+// close stops queueing spilled envelopes. Envelopes which haven't been
+// queued stay on disk until the next start.
+func (a *admission) close() {
+	if a.spill != nil {
+		a.spill.close()
+	}
+}
+
+// spill stores envelopes in a directory, one file per batch, and moves
+// them to the queue in the order they were written.
+type spill struct {
+	dir     string
+	queue   chan<- []envelope.Envelope
+	mu      sync.Mutex
+	seq     int64
+	written chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func openSpill(dir string, queue chan<- []envelope.Envelope) (*spill, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &spill{
+		dir:     dir,
+		queue:   queue,
+		seq:     time.Now().UnixNano(),
+		written: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.drain()
+	return s, nil
+}
+
+func (s *spill) write(envelopes []envelope.Envelope) error {
+	contents, err := json.Marshal(envelopes)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.seq++
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.seq, SPILL_SUFFIX))
+	s.mu.Unlock()
+	// Write then rename, so the drain never reads a partial batch
+	if err := os.WriteFile(path+".tmp", contents, 0644); err != nil {
+		log.Error().Err(err).Msg("🔴 could not spill envelopes to disk")
+		return ErrOverloaded
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Error().Err(err).Msg("🔴 could not spill envelopes to disk")
+		return ErrOverloaded
+	}
+	select {
+	case s.written <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *spill) drain() {
+	defer close(s.stopped)
+	for {
+		paths, err := filepath.Glob(filepath.Join(s.dir, "*"+SPILL_SUFFIX))
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not list spilled envelopes")
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				log.Error().Err(err).Msg("🔴 could not read spilled envelopes " + path)
+				continue
+			}
+			var envelopes []envelope.Envelope
+			if err := json.Unmarshal(contents, &envelopes); err != nil {
+				log.Error().Err(err).Msg("🔴 discarding corrupt spilled envelopes " + path)
+				os.Remove(path)
+				continue
+			}
+			select {
+			case s.queue <- envelopes:
+				if err := os.Remove(path); err != nil {
+					log.Error().Err(err).Msg("🔴 could not remove spilled envelopes " + path)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+		select {
+		case <-s.written:
+		case <-time.After(SPILL_POLL):
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *spill) close() {
+	close(s.stop)
+	<-s.stopped
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShedWhenFull(t *testing.T) {
+	a, err := newAdmission(config.Overload{Policy: SHED, QueueSize: 1}, "test")
+	assert.Nil(t, err)
+	assert.Nil(t, a.offer(envelopes(1)))
+	assert.ErrorIs(t, a.offer(envelopes(1)), ErrOverloaded)
+}
+
+func TestBlockUntilDeadline(t *testing.T) {
+	a, err := newAdmission(config.Overload{Policy: BLOCK, QueueSize: 1, DeadlineMs: 20}, "test")
+	assert.Nil(t, err)
+	assert.Nil(t, a.offer(envelopes(1)))
+	start := time.Now()
+	assert.ErrorIs(t, a.offer(envelopes(1)), ErrOverloaded)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	// Space freed before the deadline is used
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-a.queue
+	}()
+	assert.Nil(t, a.offer(envelopes(1)))
+}
+
+func TestWaitForSpace(t *testing.T) {
+	a, err := newAdmission(config.Overload{QueueSize: 1}, "test")
+	assert.Nil(t, err)
+	assert.Nil(t, a.offer(envelopes(1)))
+	assert.ErrorIs(t, waitForSpace(config.Overload{Policy: SHED}, a.full), ErrOverloaded)
+	start := time.Now()
+	assert.ErrorIs(t, waitForSpace(config.Overload{Policy: BLOCK, DeadlineMs: 20}, a.full), ErrOverloaded)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-a.queue
+	}()
+	assert.Nil(t, waitForSpace(config.Overload{Policy: BLOCK, DeadlineMs: 1000}, a.full))
+}
+
+func TestSpillToDisk(t *testing.T) {
+	dir := t.TempDir()
+	conf := config.Overload{Policy: SPILL, QueueSize: 1, SpillPath: dir}
+	a, err := newAdmission(conf, "test")
+	assert.Nil(t, err)
+	assert.Nil(t, a.offer(envelopes(1)))
+	assert.Nil(t, a.offer(envelopes(2)))
+	assert.Nil(t, a.offer(envelopes(3)))
+	// Spilled envelopes are kept on disk through a restart
+	a.close()
+	spilled, _ := filepath.Glob(filepath.Join(dir, "test", "*"+SPILL_SUFFIX))
+	assert.Len(t, spilled, 2)
+
+	restarted, err := newAdmission(conf, "test")
+	assert.Nil(t, err)
+	defer restarted.close()
+	var sizes []int
+	receive := func() []envelope.Envelope {
+		select {
+		case e := <-restarted.queue:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("spilled envelopes were not queued")
+			return nil
+		}
+	}
+	sizes = append(sizes, len(receive()), len(receive()))
+	assert.Equal(t, []int{2, 3}, sizes)
+	assert.Eventually(t, func() bool {
+		spilled, _ := filepath.Glob(filepath.Join(dir, "test", "*"+SPILL_SUFFIX))
+		return len(spilled) == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestUnsupportedOverloadPolicy(t *testing.T) {
+	_, err := newAdmission(config.Overload{Policy: "drop"}, "test")
+	assert.NotNil(t, err)
+	_, err = newAdmission(config.Overload{Policy: SPILL}, "test")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/annotator"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/silverton-io/buz/pkg/tap"
+	"github.com/silverton-io/buz/pkg/transform"
+	"github.com/silverton-io/buz/pkg/wal"
+)
+
+const (
+	DEFAULT_POOL_WORKERS    int = 4
+	DEFAULT_POOL_QUEUE_SIZE int = 100
+)
+
+// A manifold which delivers envelopes to each sink from a pool of workers,
+// so a slow sink can write concurrently. Each sink has a bounded queue,
+// and enqueueing blocks while the queue of any sink is full.
+//
+// If the write-ahead log is enabled, envelopes are appended to it before
+// they are queued and acked once every sink has delivered or dead-lettered
+// them.
+//
+// Workers deliver to the sink directly rather than through the sink's own
+// worker, so sinks must be safe to dequeue concurrently.
+type PoolManifold struct {
+	registry      *registry.Registry
+	sinks         *[]backendutils.Sink
+	conf          *config.Config
+	pipeline      *transform.Pipeline
+	limits        *sizeLimits
+	counts        *counts
+	collectorMeta *meta.CollectorMeta
+	queues        []chan delivery
+	wal           *wal.Log
+	workers       sync.WaitGroup
+	mu            sync.RWMutex
+	admit         sync.Mutex // Serializes enqueues, so the space waited for in each queue isn't taken
+	closed        bool
+}
+
+// delivery is a batch of envelopes queued for every sink
+type delivery struct {
+	envelopes []envelope.Envelope
+	position  wal.Position
+	remaining *int32
+	failed    *int32 // Set if any sink neither delivered nor dead-lettered the envelopes
+}
+
+func (m *PoolManifold) work(sink backendutils.Sink, queue <-chan delivery) {
+	defer m.workers.Done()
+	for d := range queue {
+		if err := backendutils.Deliver(context.Background(), sink, d.envelopes); err != nil {
+			atomic.StoreInt32(d.failed, 1)
+		}
+		// Envelopes which failed aren't acked, so they are replayed from the
+		// write-ahead log when the collector restarts
+		if atomic.AddInt32(d.remaining, -1) == 0 && m.wal != nil && atomic.LoadInt32(d.failed) == 0 {
+			m.wal.Ack(d.position)
+		}
+	}
+}
+
+func (m *PoolManifold) Initialize(registry *registry.Registry, sinks *[]backendutils.Sink, conf *config.Config, metadata *meta.CollectorMeta) error {
+	m.registry = registry
+	m.sinks = sinks
+	m.conf = conf
+	pipeline, err := transform.BuildPipeline(conf.Transforms)
+	if err != nil {
+		return err
+	}
+	m.pipeline = pipeline
+	limits, err := newSizeLimits(conf.Manifold.Limits)
+	if err != nil {
+		return err
+	}
+	m.limits = limits
+	m.counts = newCounts()
+	m.collectorMeta = metadata
+	if conf.Manifold.Overload.Policy == SPILL {
+		return errors.New("the pool manifold does not support the spill overload policy, enable the write-ahead log instead")
+	}
+	queueSize := conf.Manifold.Pool.QueueSize
+	if queueSize <= 0 {
+		queueSize = DEFAULT_POOL_QUEUE_SIZE
+	}
+	for _, sink := range *sinks {
+		workers := sink.Metadata().Workers
+		if workers <= 0 {
+			workers = conf.Manifold.Pool.Workers
+		}
+		if workers <= 0 {
+			workers = DEFAULT_POOL_WORKERS
+		}
+		log.Info().Interface("metadata", sink.Metadata()).Int("workers", workers).Int("queueSize", queueSize).Msg("🟢 starting sink workers")
+		queue := make(chan delivery, queueSize)
+		backendutils.Stats().TrackQueue(sink.Metadata().Name, func() int { return len(queue) }, cap(queue))
+		m.queues = append(m.queues, queue)
+		m.workers.Add(workers)
+		for i := 0; i < workers; i++ {
+			go m.work(sink, queue)
+		}
+	}
+	if conf.Manifold.Wal.Enabled {
+		l, err := wal.Open(conf.Manifold.Wal)
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not open write-ahead log")
+			return err
+		}
+		m.wal = l
+		// Replayed envelopes wait for space however long it takes, since
+		// they can't be retried by a client
+		replayed, err := l.Replay(func(envelopes []envelope.Envelope) error {
+			return m.enqueue(envelopes, config.Overload{Policy: BLOCK})
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not replay write-ahead log")
+			return err
+		}
+		if replayed > 0 {
+			log.Info().Int("envelopes", replayed).Msg("🟢 replayed envelopes from write-ahead log")
+		}
+	}
+	return nil
+}
+
+// enqueue queues annotated envelopes for every sink, or for none if any
+// sink's queue has no space by the deadline of the overload policy. Envelopes are only
+// appended to the write-ahead log once they can be queued, so envelopes
+// which clients retry aren't also replayed from the log.
+func (m *PoolManifold) enqueue(envelopes []envelope.Envelope, overload config.Overload) error {
+	m.admit.Lock()
+	defer m.admit.Unlock()
+	err := waitForSpace(overload, func() bool {
+		for _, queue := range m.queues {
+			if len(queue) >= cap(queue) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		for _, sink := range *m.sinks {
+			backendutils.Stats().Dropped(sink.Metadata().Name, len(envelopes))
+		}
+		return err
+	}
+	d := delivery{envelopes: envelopes, remaining: new(int32), failed: new(int32)}
+	*d.remaining = int32(len(m.queues))
+	if m.wal != nil {
+		position, err := m.wal.Append(envelopes)
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not append envelopes to write-ahead log")
+			return err
+		}
+		d.position = position
+		if len(m.queues) == 0 {
+			m.wal.Ack(position)
+		}
+	}
+	for i, queue := range m.queues {
+		// Every queue has space, so this doesn't block
+		queue <- d
+		backendutils.Stats().Enqueued((*m.sinks)[i].Metadata().Name, len(envelopes))
+	}
+	return nil
+}
+
+func (m *PoolManifold) Enqueue(envelopes []envelope.Envelope) error {
+	annotatedEnvelopes, release := m.pipeline.RunReleasable(annotator.Retain(annotator.Annotate(envelopes, m.registry, m.conf.Validation), m.conf.Retention))
+	annotatedEnvelopes, err := m.limits.enforce(annotatedEnvelopes)
+	m.counts.record(len(envelopes), annotatedEnvelopes, err)
+	if err != nil {
+		release()
+		return err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		release()
+		return ErrManifoldShutdown
+	}
+	if err := m.enqueue(annotatedEnvelopes, m.conf.Manifold.Overload); err != nil {
+		release()
+		return err
+	}
+	tap.Publish(annotatedEnvelopes)
+	return nil
+}
+
+func (m *PoolManifold) GetRegistry() *registry.Registry {
+	return m.registry
+}
+
+func (m *PoolManifold) Stats() Stats {
+	return m.counts.stats(m.collectorMeta)
+}
+
+// Shutdown drains the queues before shutting down the sinks
+func (m *PoolManifold) Shutdown() error {
+	log.Info().Msg("🟢 shutting down pool manifold")
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	for _, queue := range m.queues {
+		close(queue)
+	}
+	m.mu.Unlock()
+	m.workers.Wait()
+	if m.wal != nil {
+		if err := m.wal.Close(); err != nil {
+			log.Error().Err(err).Msg("🔴 could not close write-ahead log")
+		}
+	}
+	shutdownSinks(*m.sinks, ShutdownTimeout(m.conf.App))
+	if err := m.pipeline.Close(); err != nil {
+		log.Error().Err(err).Msg("🔴 transforms did not safely shut down")
+	}
+	log.Info().Msg("🟢 manifold shut down")
+	return nil
+}
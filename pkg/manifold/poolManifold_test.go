@@ -0,0 +1,212 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/backend/embedded"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/silverton-io/buz/pkg/tap"
+	"github.com/silverton-io/buz/pkg/transform"
+	"github.com/silverton-io/buz/pkg/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolManifold(t *testing.T) {
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	slow := &recordingSink{
+		metadata: backendutils.SinkMetadata{DefaultOutput: "valid", DeadletterOutput: "invalid", Workers: 4},
+		delay:    20 * time.Millisecond,
+	}
+	fast := &recordingSink{metadata: backendutils.SinkMetadata{DefaultOutput: "valid", DeadletterOutput: "invalid"}}
+	sinks := []backendutils.Sink{slow, fast}
+	conf := &config.Config{
+		Validation: config.Validation{Mode: "skip"},
+		Manifold:   config.Manifold{Type: POOL, Pool: config.Pool{Workers: 1, QueueSize: 2}},
+	}
+	m := &PoolManifold{}
+	assert.Nil(t, m.Initialize(r, &sinks, conf, nil))
+	for i := 0; i < 8; i++ {
+		assert.Nil(t, m.Enqueue([]envelope.Envelope{{Schema: "io.silverton/buz/example/productView/v1.0.json"}}))
+	}
+	assert.Nil(t, m.Shutdown())
+
+	assert.Equal(t, map[string]int{"valid": 8}, slow.dequeued)
+	assert.Equal(t, map[string]int{"valid": 8}, fast.dequeued)
+	assert.Greater(t, slow.peak, 1, "the sink's worker override should deliver concurrently")
+	assert.Equal(t, 1, fast.peak)
+	assert.True(t, slow.shutdown && fast.shutdown)
+	assert.ErrorIs(t, m.Enqueue(nil), ErrManifoldShutdown)
+}
+
+func TestPoolManifoldReplaysWal(t *testing.T) {
+	dir := t.TempDir()
+	l, err := wal.Open(config.Wal{Path: dir})
+	assert.Nil(t, err)
+	_, err = l.Append([]envelope.Envelope{{Schema: "io.silverton/buz/example/productView/v1.0.json", IsValid: true}})
+	assert.Nil(t, err)
+
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	sink := &recordingSink{metadata: backendutils.SinkMetadata{DefaultOutput: "valid", DeadletterOutput: "invalid"}}
+	sinks := []backendutils.Sink{sink}
+	conf := &config.Config{
+		Validation: config.Validation{Mode: "skip"},
+		Manifold:   config.Manifold{Type: POOL, Wal: config.Wal{Enabled: true, Path: dir}},
+	}
+	m := &PoolManifold{}
+	assert.Nil(t, m.Initialize(r, &sinks, conf, nil))
+	assert.Nil(t, m.Enqueue([]envelope.Envelope{{Schema: "io.silverton/buz/example/productView/v1.0.json"}}))
+	assert.Nil(t, m.Shutdown())
+
+	assert.Equal(t, map[string]int{"valid": 2}, sink.dequeued)
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+wal.SEGMENT_SUFFIX))
+	assert.Empty(t, segments, "delivered envelopes should be removed from the log")
+}
+
+func TestPoolManifoldReplaysBacklogLargerThanQueues(t *testing.T) {
+	dir := t.TempDir()
+	// Every record is written to its own segment
+	l, err := wal.Open(config.Wal{Path: dir, SegmentBytes: 1})
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = l.Append([]envelope.Envelope{{Schema: "io.silverton/buz/example/productView/v1.0.json", IsValid: true}})
+		assert.Nil(t, err)
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+wal.SEGMENT_SUFFIX))
+	assert.Greater(t, len(segments), 2)
+
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	sink := &recordingSink{metadata: backendutils.SinkMetadata{DefaultOutput: "valid", DeadletterOutput: "invalid", Workers: 1}, delay: 5 * time.Millisecond}
+	sinks := []backendutils.Sink{sink}
+	conf := &config.Config{
+		Validation: config.Validation{Mode: "skip"},
+		Manifold: config.Manifold{
+			Type:     POOL,
+			Pool:     config.Pool{QueueSize: 1},
+			Overload: config.Overload{Policy: SHED},
+			Wal:      config.Wal{Enabled: true, Path: dir},
+		},
+	}
+	// The backlog isn't shed, even though it doesn't fit in the queue
+	m := &PoolManifold{}
+	assert.Nil(t, m.Initialize(r, &sinks, conf, nil))
+	assert.Nil(t, m.Shutdown())
+	assert.Equal(t, map[string]int{"valid": 10}, sink.dequeued)
+}
+
+// unavailableSink fails every delivery
+type unavailableSink struct {
+	recordingSink
+}
+
+func (s *unavailableSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	return errors.New("unavailable")
+}
+
+func TestPoolManifoldKeepsUndeliveredEnvelopesInWal(t *testing.T) {
+	dir := t.TempDir()
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	sinks := []backendutils.Sink{
+		&recordingSink{metadata: backendutils.SinkMetadata{DefaultOutput: "valid", DeadletterOutput: "invalid"}},
+		&unavailableSink{recordingSink{metadata: backendutils.SinkMetadata{DefaultOutput: "valid", DeadletterOutput: "invalid"}}},
+	}
+	conf := &config.Config{
+		Validation: config.Validation{Mode: "skip"},
+		Manifold:   config.Manifold{Type: POOL, Wal: config.Wal{Enabled: true, Path: dir}},
+	}
+	m := &PoolManifold{}
+	assert.Nil(t, m.Initialize(r, &sinks, conf, nil))
+	assert.Nil(t, m.Enqueue([]envelope.Envelope{{Schema: "io.silverton/buz/example/productView/v1.0.json", IsValid: true}}))
+	assert.Nil(t, m.Shutdown())
+
+	// Without a dead letter queue, the envelopes are replayed after a restart
+	l, err := wal.Open(config.Wal{Path: dir})
+	assert.Nil(t, err)
+	replayed, err := l.Replay(func([]envelope.Envelope) error { return nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 1, replayed)
+}
+
+// stalledSink doesn't deliver envelopes until it is released
+type stalledSink struct {
+	recordingSink
+	release chan struct{}
+}
+
+func (s *stalledSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	<-s.release
+	return s.recordingSink.Dequeue(ctx, envelopes, output)
+}
+
+func TestPoolManifoldQueuesForEverySinkOrNone(t *testing.T) {
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	slow := &stalledSink{recordingSink: recordingSink{metadata: backendutils.SinkMetadata{DefaultOutput: "valid"}}, release: make(chan struct{})}
+	fast := &recordingSink{metadata: backendutils.SinkMetadata{DefaultOutput: "valid"}}
+	sinks := []backendutils.Sink{slow, fast}
+	conf := &config.Config{
+		Validation: config.Validation{Mode: "skip"},
+		Manifold: config.Manifold{
+			Type:     POOL,
+			Pool:     config.Pool{Workers: 1, QueueSize: 1},
+			Overload: config.Overload{Policy: SHED},
+		},
+	}
+	m := &PoolManifold{}
+	assert.Nil(t, m.Initialize(r, &sinks, conf, nil))
+	sub, _ := tap.Subscribe(tap.Filter{SampleRate: 1}, 10, 10)
+	defer tap.Unsubscribe(sub)
+	e := []envelope.Envelope{{Schema: "io.silverton/buz/example/productView/v1.0.json", IsValid: true}}
+	// The slow sink's worker holds one batch and its queue another
+	assert.Nil(t, m.Enqueue(e))
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, m.Enqueue(e))
+	// The fast sink has space, but the envelopes aren't queued for it either
+	assert.ErrorIs(t, m.Enqueue(e), ErrOverloaded)
+	close(slow.release)
+	assert.Nil(t, m.Shutdown())
+	assert.ErrorIs(t, m.Enqueue(e), ErrManifoldShutdown)
+	assert.Equal(t, map[string]int{"valid": 2}, slow.dequeued)
+	assert.Equal(t, map[string]int{"valid": 2}, fast.dequeued)
+	// The tap only shows the envelopes which were queued
+	assert.Len(t, sub.Envelopes, 2)
+}
+
+func TestPoolManifoldReleasesDedupIdsOfRejectedEnvelopes(t *testing.T) {
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	slow := &stalledSink{recordingSink: recordingSink{metadata: backendutils.SinkMetadata{DefaultOutput: "valid"}}, release: make(chan struct{})}
+	sinks := []backendutils.Sink{slow}
+	conf := &config.Config{
+		Validation: config.Validation{Mode: "skip"},
+		Transforms: []config.Transform{{Type: transform.DEDUP}},
+		Manifold: config.Manifold{
+			Type:     POOL,
+			Pool:     config.Pool{Workers: 1, QueueSize: 1},
+			Overload: config.Overload{Policy: SHED},
+		},
+	}
+	m := &PoolManifold{}
+	assert.Nil(t, m.Initialize(r, &sinks, conf, nil))
+	event := func(id string) []envelope.Envelope {
+		return []envelope.Envelope{{Schema: "io.silverton/buz/example/productView/v1.0.json", IsValid: true, Payload: envelope.Payload{"event_id": id}}}
+	}
+	assert.Nil(t, m.Enqueue(event("a")))
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, m.Enqueue(event("b")))
+	assert.ErrorIs(t, m.Enqueue(event("c")), ErrOverloaded)
+	close(slow.release)
+	// The retry isn't dropped as a duplicate of the rejected envelopes
+	assert.Eventually(t, func() bool { return m.Enqueue(event("c")) == nil }, time.Second, 10*time.Millisecond)
+	assert.Nil(t, m.Enqueue(event("a")))
+	assert.Nil(t, m.Shutdown())
+	assert.Equal(t, map[string]int{"valid": 3}, slow.dequeued)
+}
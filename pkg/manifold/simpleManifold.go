@@ -12,6 +12,8 @@ import (
 	"github.com/silverton-io/buz/pkg/envelope"
 	"github.com/silverton-io/buz/pkg/meta"
 	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/silverton-io/buz/pkg/tap"
+	"github.com/silverton-io/buz/pkg/transform"
 )
 
 // A stupid-simple manifold with strict guarantees.
@@ -21,6 +23,9 @@ type SimpleManifold struct {
 	registry         *registry.Registry
 	sinks            *[]backendutils.Sink
 	conf             *config.Config
+	pipeline         *transform.Pipeline
+	limits           *sizeLimits
+	counts           *counts
 	collectorMetdata *meta.CollectorMeta
 }
 
@@ -28,19 +33,39 @@ func (m *SimpleManifold) Initialize(registry *registry.Registry, sinks *[]backen
 	m.registry = registry
 	m.sinks = sinks
 	m.conf = conf
+	pipeline, err := transform.BuildPipeline(conf.Transforms)
+	if err != nil {
+		return err
+	}
+	m.pipeline = pipeline
+	limits, err := newSizeLimits(conf.Manifold.Limits)
+	if err != nil {
+		return err
+	}
+	m.limits = limits
+	m.counts = newCounts()
 	m.collectorMetdata = metadata
 	return nil
 }
 
 func (m *SimpleManifold) Enqueue(envelopes []envelope.Envelope) error {
-	annotatedEnvelopes := annotator.Annotate(envelopes, m.registry)
+	annotatedEnvelopes, release := m.pipeline.RunReleasable(annotator.Retain(annotator.Annotate(envelopes, m.registry, m.conf.Validation), m.conf.Retention))
+	annotatedEnvelopes, err := m.limits.enforce(annotatedEnvelopes)
+	m.counts.record(len(envelopes), annotatedEnvelopes, err)
+	if err != nil {
+		release()
+		return err
+	}
+	tap.Publish(annotatedEnvelopes)
 	for _, sink := range *m.sinks {
 		meta := sink.Metadata()
 		log.Debug().Interface("metadata", meta).Msg("🟡 enqueueing envelopes to sink")
 		err := sink.Enqueue(annotatedEnvelopes)
 		if err != nil {
 			log.Error().Err(err).Interface("metadata", sink.Metadata()).Msg("failed to enqueue envelopes to sink")
+			continue
 		}
+		backendutils.Stats().Enqueued(meta.Name, len(annotatedEnvelopes))
 	}
 	return nil
 }
@@ -49,8 +74,18 @@ func (m *SimpleManifold) GetRegistry() *registry.Registry {
 	return m.registry
 }
 
+func (m *SimpleManifold) Stats() Stats {
+	return m.counts.stats(m.collectorMetdata)
+}
+
+// Shutdown delivers the envelopes queued by the sinks before shutting
+// them down
 func (m *SimpleManifold) Shutdown() error {
 	log.Info().Msg("shutting down simple manifold")
+	shutdownSinks(*m.sinks, ShutdownTimeout(m.conf.App))
+	if err := m.pipeline.Close(); err != nil {
+		log.Error().Err(err).Msg("🔴 transforms did not safely shut down")
+	}
 	log.Info().Msg("manifold shut down")
 	return nil
 }
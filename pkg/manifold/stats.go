@@ -0,0 +1,92 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"sync/atomic"
+
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+// Stats are the runtime statistics of a manifold. Delivery stats are
+// summed over its sinks.
+type Stats struct {
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+	Received      int64   `json:"received"` // Envelopes enqueued to the manifold
+	Valid         int64   `json:"valid"`
+	Invalid       int64   `json:"invalid"`
+	Rejected      int64   `json:"rejected"` // Envelopes refused by the size limits
+	Delivered     int64   `json:"delivered"`
+	Failed        int64   `json:"failed"`
+	Dropped       int64   `json:"dropped"` // Envelopes shed by overloaded sink queues
+	QueuedBatches int     `json:"queuedBatches"`
+	// Accepted envelopes by protocol and namespace
+	Protocols *stats.ProtocolStats `json:"protocols"`
+}
+
+// counts tracks the envelopes passing through a manifold
+type counts struct {
+	received  atomic.Int64
+	valid     atomic.Int64
+	invalid   atomic.Int64
+	rejected  atomic.Int64
+	protocols *stats.ProtocolStats
+}
+
+func newCounts() *counts {
+	return &counts{protocols: stats.NewProtocolStats()}
+}
+
+// record counts the envelopes enqueued to the manifold, and the
+// annotated envelopes which it accepted
+func (c *counts) record(received int, annotated []envelope.Envelope, err error) {
+	c.received.Add(int64(received))
+	if err != nil {
+		c.rejected.Add(int64(received))
+		return
+	}
+	events, invalid := make(map[string]int64), make(map[string]int64)
+	for _, e := range annotated {
+		events[e.Protocol]++
+		if e.IsValid {
+			c.valid.Add(1)
+		} else {
+			c.invalid.Add(1)
+			invalid[e.Protocol]++
+		}
+		namespace := e.Namespace
+		if namespace == "" {
+			namespace = constants.UNKNOWN
+		}
+		c.protocols.Increment(e.Protocol, namespace, e.IsValid, 1)
+	}
+	for protocol, n := range events {
+		stats.Inputs().Events(protocol, n, invalid[protocol])
+	}
+}
+
+func (c *counts) stats(m *meta.CollectorMeta) Stats {
+	s := Stats{
+		Received:  c.received.Load(),
+		Valid:     c.valid.Load(),
+		Invalid:   c.invalid.Load(),
+		Rejected:  c.rejected.Load(),
+		Protocols: c.protocols.Snapshot(),
+	}
+	if m != nil {
+		s.UptimeSeconds = m.Elapsed()
+	}
+	for _, sink := range backendutils.Stats().Snapshot() {
+		s.Delivered += sink.Delivered
+		s.Failed += sink.Failed
+		s.Dropped += sink.Dropped
+		s.QueuedBatches += sink.QueuedBatches
+	}
+	return s
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/backend/embedded"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifoldStats(t *testing.T) {
+	r := &registry.Registry{Cache: registry.NewSchemaCache(0, 0, 0), Backend: &embedded.RegistryBackend{}}
+	sinks := []backendutils.Sink{&recordingSink{}}
+	conf := &config.Config{Manifold: config.Manifold{Limits: config.Limits{MaxEventBytes: 1000}}}
+	m := &SimpleManifold{}
+	assert.Nil(t, m.Initialize(r, &sinks, conf, &meta.CollectorMeta{StartTime: time.Now().Add(-time.Minute)}))
+
+	valid := envelope.Envelope{Protocol: "webhook", Schema: "io.silverton/buz/example/productView/v1.0.json", Payload: envelope.Payload{
+		"productId": "10", "category": "shoes", "brand": "acme", "returning": true, "price": 10.5, "sizes": []interface{}{"m"}, "availableSince": "2023-01-01T00:00:00Z",
+	}}
+	invalid := envelope.Envelope{Protocol: "webhook", Schema: "io.silverton/buz/example/productView/v1.0.json", Payload: envelope.Payload{"productId": 10}}
+	tooLarge := envelope.Envelope{Protocol: "webhook", Payload: envelope.Payload{"text": strings.Repeat("a", 2000)}}
+	assert.Nil(t, m.Enqueue([]envelope.Envelope{valid, valid, invalid}))
+	assert.ErrorIs(t, m.Enqueue([]envelope.Envelope{tooLarge}), ErrTooLarge)
+
+	s := m.Stats()
+	assert.Equal(t, int64(4), s.Received)
+	assert.Equal(t, int64(2), s.Valid)
+	assert.Equal(t, int64(1), s.Invalid)
+	assert.Equal(t, int64(1), s.Rejected)
+	assert.GreaterOrEqual(t, s.UptimeSeconds, 60.0)
+	assert.Equal(t, int64(2), s.Protocols.Valid["webhook"]["buz.example.productView"])
+	assert.Equal(t, int64(1), s.Protocols.Invalid["webhook"]["buz.example.productView"])
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/response"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+const (
+	ABUSE_TALKERS_ROUTE = "/c/abuse/talkers"
+	ABUSE_BLOCKS_ROUTE  = "/c/abuse/blocks/"
+	FINGERPRINT_PARAM   = "fingerprint"
+)
+
+const (
+	DEFAULT_ABUSE_WINDOW_SECONDS   int = 60
+	DEFAULT_ABUSE_BLOCK_SECONDS    int = 600
+	DEFAULT_ABUSE_TOP_TALKERS      int = 20
+	DEFAULT_ABUSE_MAX_FINGERPRINTS int = 100000
+	ABUSE_FINGERPRINT_LENGTH       int = 16
+)
+
+// Abuse events
+const (
+	BLOCKED   string = "blocked"   // A fingerprint went over the threshold
+	REJECTED  string = "rejected"  // A request of a blocked fingerprint was rejected
+	EVICTED   string = "evicted"   // The least recently seen fingerprint was forgotten, to track a new one
+	UNTRACKED string = "untracked" // A request wasn't tracked, since every tracked fingerprint was blocked
+)
+
+var abuseEvents = stats.NewSchemaStats()
+
+// AbuseEvents returns the fingerprints blocked, and the requests rejected
+// or not tracked, by route
+func AbuseEvents() map[string]map[string]int64 {
+	return abuseEvents.Snapshot()
+}
+
+// Talker is the request rate of a fingerprint
+type Talker struct {
+	Fingerprint  string     `json:"fingerprint"`
+	Ip           string     `json:"ip"`
+	UserAgent    string     `json:"userAgent"`
+	Identity     string     `json:"identity,omitempty"`
+	Rate         float64    `json:"rate"` // Requests per window
+	Total        int64      `json:"total"`
+	BlockedUntil *time.Time `json:"blockedUntil,omitempty"`
+	current      int64
+	previous     int64
+	seen         *list.Element // Of the tracker's recently seen talkers, unless blocked
+}
+
+// rate estimates the requests of the trailing window, by weighting the
+// previous window by how much of it is still trailing
+func (t *Talker) rate(elapsed float64) float64 {
+	return float64(t.previous)*(1-elapsed) + float64(t.current)
+}
+
+func (t *Talker) blocked(now time.Time) bool {
+	return t.BlockedUntil != nil && now.Before(*t.BlockedUntil)
+}
+
+// AbuseTracker fingerprints requests by their ip, user agent, and api key
+// or other identity, and tracks the rate of each fingerprint. Fingerprints
+// over the threshold are blocked for a while. Rates are tracked per
+// instance, and the least recently seen fingerprints are forgotten to
+// track new ones once there are too many.
+type AbuseTracker struct {
+	conf        config.AbuseDetection
+	window      time.Duration
+	block       time.Duration
+	topTalkers  int
+	max         int
+	mu          sync.Mutex
+	talkers     map[string]*Talker
+	seen        *list.List // Unblocked talkers, most recently seen first
+	windowStart time.Time
+	now         func() time.Time
+}
+
+func NewAbuseTracker(conf config.AbuseDetection) *AbuseTracker {
+	t := &AbuseTracker{
+		conf:       conf,
+		window:     time.Duration(conf.WindowSeconds) * time.Second,
+		block:      time.Duration(conf.BlockSeconds) * time.Second,
+		topTalkers: conf.TopTalkers,
+		max:        conf.MaxFingerprints,
+		talkers:    make(map[string]*Talker),
+		seen:       list.New(),
+		now:        time.Now,
+	}
+	if t.window <= 0 {
+		t.window = time.Duration(DEFAULT_ABUSE_WINDOW_SECONDS) * time.Second
+	}
+	if t.block <= 0 {
+		t.block = time.Duration(DEFAULT_ABUSE_BLOCK_SECONDS) * time.Second
+	}
+	if t.topTalkers <= 0 {
+		t.topTalkers = DEFAULT_ABUSE_TOP_TALKERS
+	}
+	if t.max <= 0 {
+		t.max = DEFAULT_ABUSE_MAX_FINGERPRINTS
+	}
+	return t
+}
+
+func fingerprint(ip string, userAgent string, identity string) string {
+	sum := sha256.Sum256([]byte(ip + "\n" + userAgent + "\n" + identity))
+	return hex.EncodeToString(sum[:])[:ABUSE_FINGERPRINT_LENGTH]
+}
+
+// rotate starts a new window once the current one has passed, forgetting
+// fingerprints which were quiet for two windows and aren't blocked. It
+// returns the elapsed fraction of the current window.
+func (t *AbuseTracker) rotate(now time.Time) float64 {
+	if t.windowStart.IsZero() {
+		t.windowStart = now
+	}
+	if elapsed := now.Sub(t.windowStart); elapsed >= t.window {
+		windows := elapsed / t.window
+		for fp, talker := range t.talkers {
+			talker.previous = talker.current
+			if windows > 1 {
+				talker.previous = 0
+			}
+			talker.current = 0
+			if talker.previous == 0 && !talker.blocked(now) {
+				t.forget(fp, talker)
+			}
+		}
+		t.windowStart = t.windowStart.Add(windows * t.window)
+	}
+	return float64(now.Sub(t.windowStart)) / float64(t.window)
+}
+
+func (t *AbuseTracker) forget(fp string, talker *Talker) {
+	if talker.seen != nil {
+		t.seen.Remove(talker.seen)
+	}
+	delete(t.talkers, fp)
+}
+
+// observe counts a request of the fingerprint, returning how long it is
+// blocked for. Blocked fingerprints are never forgotten to make room, so
+// they can't be unblocked by sending requests of new fingerprints.
+func (t *AbuseTracker) observe(talker Talker, route string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	elapsed := t.rotate(now)
+	tracked, ok := t.talkers[talker.Fingerprint]
+	if !ok {
+		if len(t.talkers) >= t.max {
+			oldest := t.seen.Back()
+			if oldest == nil {
+				abuseEvents.Increment(UNTRACKED, route, 1)
+				return 0
+			}
+			evicted := oldest.Value.(*Talker)
+			t.forget(evicted.Fingerprint, evicted)
+			abuseEvents.Increment(EVICTED, route, 1)
+		}
+		tracked = &talker
+		t.talkers[talker.Fingerprint] = tracked
+	}
+	tracked.current++
+	tracked.Total++
+	if tracked.blocked(now) {
+		return tracked.BlockedUntil.Sub(now)
+	}
+	if tracked.seen == nil {
+		tracked.seen = t.seen.PushFront(tracked)
+	} else {
+		t.seen.MoveToFront(tracked.seen)
+	}
+	if t.conf.BlockThreshold > 0 && tracked.rate(elapsed) > float64(t.conf.BlockThreshold) {
+		until := now.Add(t.block)
+		tracked.BlockedUntil = &until
+		t.seen.Remove(tracked.seen)
+		tracked.seen = nil
+		abuseEvents.Increment(BLOCKED, route, 1)
+		log.Warn().Str("fingerprint", tracked.Fingerprint).Str("ip", tracked.Ip).Str("identity", tracked.Identity).Time("until", until).Msg("🟡 blocking abusive fingerprint")
+		return t.block
+	}
+	return 0
+}
+
+// Talkers returns the fingerprints with the highest rates
+func (t *AbuseTracker) Talkers() []Talker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	elapsed := t.rotate(now)
+	talkers := make([]Talker, 0, len(t.talkers))
+	for _, tracked := range t.talkers {
+		talker := *tracked
+		talker.seen = nil
+		talker.Rate = math.Round(tracked.rate(elapsed)*100) / 100
+		if !tracked.blocked(now) {
+			talker.BlockedUntil = nil
+		}
+		talkers = append(talkers, talker)
+	}
+	sort.Slice(talkers, func(i, j int) bool { return talkers[i].Rate > talkers[j].Rate })
+	if len(talkers) > t.topTalkers {
+		talkers = talkers[:t.topTalkers]
+	}
+	return talkers
+}
+
+// Unblock lifts the block of a fingerprint, returning false if it wasn't
+// blocked
+func (t *AbuseTracker) Unblock(fp string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	talker, ok := t.talkers[fp]
+	if !ok || !talker.blocked(t.now()) {
+		return false
+	}
+	talker.BlockedUntil = nil
+	talker.current, talker.previous = 0, 0
+	talker.seen = t.seen.PushFront(talker)
+	return true
+}
+
+// Middleware tracks requests, and rejects the requests of blocked
+// fingerprints with a 429. It runs after auth, so api keys are part of the
+// fingerprint.
+func (t *AbuseTracker) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip, userAgent, identity := c.ClientIP(), c.Request.UserAgent(), c.GetString(constants.AUTH_IDENTITY)
+		talker := Talker{
+			Fingerprint: fingerprint(ip, userAgent, identity),
+			Ip:          ip,
+			UserAgent:   userAgent,
+			Identity:    identity,
+		}
+		if blocked := t.observe(talker, c.FullPath()); blocked > 0 {
+			abuseEvents.Increment(REJECTED, c.FullPath(), 1)
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(blocked.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, response.ClientBlocked)
+			return
+		}
+		c.Next()
+	}
+}
+
+// TalkersHandler returns the top talkers, for operators
+func (t *AbuseTracker) TalkersHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"windowSeconds": int(t.window.Seconds()),
+			"talkers":       t.Talkers(),
+		})
+	}
+}
+
+// UnblockHandler lifts the block of the fingerprint in the path
+func (t *AbuseTracker) UnblockHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !t.Unblock(c.Param(FINGERPRINT_PARAM)) {
+			c.JSON(http.StatusNotFound, response.FingerprintNotBlocked)
+			return
+		}
+		c.JSON(http.StatusOK, response.FingerprintUnblocked)
+	}
+}
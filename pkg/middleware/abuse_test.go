@@ -0,0 +1,97 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAbuseTracker(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tracker := NewAbuseTracker(config.AbuseDetection{Enabled: true, WindowSeconds: 10, BlockThreshold: 3, BlockSeconds: 30})
+	tracker.now = func() time.Time { return now }
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(tracker.Middleware())
+	var body string
+	r.POST("/", func(c *gin.Context) {
+		b, _ := io.ReadAll(c.Request.Body)
+		body = string(b)
+	})
+	send := func(ua string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"e":"page_view"}`))
+		req.Header.Set("User-Agent", ua)
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, send("flood").Code)
+	}
+	assert.Equal(t, `{"e":"page_view"}`, body)
+	rec := send("flood")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+	// Other fingerprints aren't blocked
+	assert.Equal(t, http.StatusOK, send("browser").Code)
+
+	talkers := tracker.Talkers()
+	assert.Equal(t, 2, len(talkers))
+	assert.Equal(t, "flood", talkers[0].UserAgent)
+	assert.Equal(t, float64(4), talkers[0].Rate)
+	assert.NotNil(t, talkers[0].BlockedUntil)
+
+	// Blocks outlast the window, and expire
+	now = now.Add(20 * time.Second)
+	assert.Equal(t, http.StatusTooManyRequests, send("flood").Code)
+	now = now.Add(11 * time.Second)
+	assert.Equal(t, http.StatusOK, send("flood").Code)
+
+	// Quiet fingerprints are forgotten
+	now = now.Add(25 * time.Second)
+	assert.Equal(t, 0, len(tracker.Talkers()))
+}
+
+func TestAbuseTrackerUnblock(t *testing.T) {
+	tracker := NewAbuseTracker(config.AbuseDetection{Enabled: true, BlockThreshold: 1})
+	fp := fingerprint("192.0.2.1", "flood", "")
+	talker := Talker{Fingerprint: fp, Ip: "192.0.2.1", UserAgent: "flood"}
+	tracker.observe(talker, "/")
+	assert.True(t, tracker.observe(talker, "/") > 0)
+	assert.True(t, tracker.Unblock(fp))
+	assert.False(t, tracker.Unblock(fp))
+	assert.Equal(t, time.Duration(0), tracker.observe(talker, "/"))
+}
+
+func TestAbuseTrackerEvictsLeastRecentlySeen(t *testing.T) {
+	tracker := NewAbuseTracker(config.AbuseDetection{Enabled: true, BlockThreshold: 2, MaxFingerprints: 2})
+	talker := func(ua string) Talker {
+		return Talker{Fingerprint: fingerprint("192.0.2.1", ua, ""), UserAgent: ua}
+	}
+	flood := talker("flood")
+	for i := 0; i < 3; i++ {
+		tracker.observe(flood, "/")
+	}
+	// New fingerprints replace each other, rather than going untracked or
+	// replacing the blocked one
+	for _, ua := range []string{"a", "b", "c"} {
+		tracker.observe(talker(ua), "/")
+	}
+	var agents []string
+	for _, tracked := range tracker.Talkers() {
+		agents = append(agents, tracked.UserAgent)
+	}
+	assert.ElementsMatch(t, []string{"flood", "c"}, agents)
+	assert.True(t, tracker.observe(flood, "/") > 0)
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/response"
+	"github.com/silverton-io/buz/pkg/stats"
+	limiter "github.com/ulule/limiter/v3"
+)
+
+const DEFAULT_API_KEY_REFRESH_SECONDS int = 60
+
+// Api key usage, by key name
+const (
+	KEY_ACCEPTED     string = "accepted"
+	KEY_DISABLED     string = "disabled"
+	KEY_EXPIRED      string = "expired"
+	KEY_THROTTLED    string = "throttled"
+	KEY_INPUT_DENIED string = "inputDenied"
+)
+
+var keyUsage = stats.NewSchemaStats()
+
+// ApiKeyUsage returns the requests made with each api key, by outcome
+func ApiKeyUsage() map[string]map[string]int64 {
+	return keyUsage.Snapshot()
+}
+
+type apiKey struct {
+	name     string
+	unnamed  bool
+	disabled bool
+	expires  time.Time
+	tenant   string
+	inputs   map[string]bool
+	limiter  *limiter.Limiter
+	limit    config.RateLimiter // The config the limiter was built from
+}
+
+func parseExpiry(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// buildApiKey builds the key, keeping the limiter of the previous key if
+// its limit is unchanged, so counts aren't reset whenever keys reload
+func buildApiKey(conf config.ApiKey, previous *apiKey) (*apiKey, error) {
+	if conf.Name == "" || conf.Key == "" {
+		return nil, errors.New("api keys require a name and key")
+	}
+	k := &apiKey{name: conf.Name, disabled: conf.Disabled, tenant: conf.Tenant}
+	if conf.Expires != "" {
+		expires, err := parseExpiry(conf.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry of api key %s: %w", conf.Name, err)
+		}
+		k.expires = expires
+	}
+	if len(conf.Inputs) > 0 {
+		k.inputs = make(map[string]bool)
+		for _, i := range conf.Inputs {
+			k.inputs[i] = true
+		}
+	}
+	if conf.RateLimiter.Enabled {
+		k.limit = conf.RateLimiter
+		if previous != nil && previous.limiter != nil && reflect.DeepEqual(previous.limit, k.limit) {
+			k.limiter = previous.limiter
+			return k, nil
+		}
+		l, err := BuildRateLimiter(conf.RateLimiter)
+		if err != nil {
+			return nil, err
+		}
+		k.limiter = l
+	}
+	return k, nil
+}
+
+// allows returns true if the key may use the input
+func (k *apiKey) allows(input string) bool {
+	return k.inputs == nil || k.inputs[input]
+}
+
+// apiKeys holds the configured keys, and those of the store
+type apiKeys struct {
+	mu    sync.RWMutex
+	byKey map[string]*apiKey
+}
+
+// set replaces the keys. Keys are only replaced if all of them are valid.
+func (a *apiKeys) set(conf config.Auth, stored []config.ApiKey) error {
+	byKey := make(map[string]*apiKey)
+	for _, token := range conf.Tokens {
+		byKey[token] = &apiKey{name: identify(BEARER, token), unnamed: true}
+	}
+	for _, c := range append(append([]config.ApiKey{}, conf.ApiKeys...), stored...) {
+		previous, _ := a.lookup(c.Key)
+		k, err := buildApiKey(c, previous)
+		if err != nil {
+			return err
+		}
+		byKey[c.Key] = k
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.byKey = byKey
+	return nil
+}
+
+func (a *apiKeys) lookup(token string) (*apiKey, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	k, ok := a.byKey[token]
+	return k, ok
+}
+
+// storedApiKey is an api key of the store, whose key can be read
+type storedApiKey struct {
+	config.ApiKey
+	Key string `json:"key"`
+}
+
+func readApiKeyStore(conf config.ApiKeyStore) ([]config.ApiKey, error) {
+	var r io.ReadCloser
+	switch conf.Type {
+	case constants.FILE, "":
+		f, err := os.Open(conf.Path)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	case constants.HTTP:
+		resp, err := (&http.Client{Timeout: 10 * time.Second}).Get(conf.Path)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("could not fetch api keys: %s", resp.Status)
+		}
+		r = resp.Body
+	default:
+		return nil, errors.New("unsupported api key store type: " + conf.Type)
+	}
+	defer r.Close()
+	var stored []storedApiKey
+	if err := json.NewDecoder(r).Decode(&stored); err != nil {
+		return nil, err
+	}
+	keys := make([]config.ApiKey, len(stored))
+	for i, s := range stored {
+		keys[i] = s.ApiKey
+		keys[i].Key = s.Key
+	}
+	return keys, nil
+}
+
+// buildApiKeys loads the api keys, and reloads those of the store in the
+// background. Keys which can't be reloaded stay as they were.
+func buildApiKeys(conf config.Auth) (*apiKeys, error) {
+	keys := &apiKeys{}
+	var stored []config.ApiKey
+	if conf.ApiKeyStore.Enabled {
+		var err error
+		if stored, err = readApiKeyStore(conf.ApiKeyStore); err != nil {
+			return nil, err
+		}
+	}
+	if err := keys.set(conf, stored); err != nil {
+		return nil, err
+	}
+	if conf.ApiKeyStore.Enabled {
+		refresh := conf.ApiKeyStore.RefreshSeconds
+		if refresh <= 0 {
+			refresh = DEFAULT_API_KEY_REFRESH_SECONDS
+		}
+		go func() {
+			for range time.Tick(time.Duration(refresh) * time.Second) {
+				stored, err := readApiKeyStore(conf.ApiKeyStore)
+				if err == nil {
+					err = keys.set(conf, stored)
+				}
+				if err != nil {
+					log.Error().Err(err).Msg("🔴 could not reload api keys")
+				}
+			}
+		}()
+	}
+	return keys, nil
+}
+
+// admit checks the state and rate limit of the key, recording its usage
+func (k *apiKey) admit(c *gin.Context) (int, response.Response, bool) {
+	switch {
+	case k.disabled:
+		keyUsage.Increment(k.name, KEY_DISABLED, 1)
+		return http.StatusUnauthorized, response.InvalidAuthToken, false
+	case !k.expires.IsZero() && time.Now().After(k.expires):
+		keyUsage.Increment(k.name, KEY_EXPIRED, 1)
+		return http.StatusUnauthorized, response.InvalidAuthToken, false
+	}
+	if k.limiter != nil {
+		if limit, err := k.limiter.Get(c, k.name); err == nil && limit.Reached {
+			keyUsage.Increment(k.name, KEY_THROTTLED, 1)
+			return http.StatusTooManyRequests, response.RateLimitExceeded, false
+		}
+	}
+	keyUsage.Increment(k.name, KEY_ACCEPTED, 1)
+	return http.StatusOK, response.Ok, true
+}
+
+// InputScope rejects requests to the input made with api keys which may
+// not use it
+func InputScope(input string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if k, ok := c.Value(constants.AUTH_API_KEY).(*apiKey); ok && !k.allows(input) {
+			keyUsage.Increment(k.name, KEY_INPUT_DENIED, 1)
+			c.JSON(http.StatusForbidden, response.ApiKeyInputDenied)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiKeys(t *testing.T) {
+	store := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(store, []byte(`[{"name": "stored", "key": "stored-key", "inputs": ["pixel"]}]`), 0644)
+	conf := config.Auth{
+		Enabled: true,
+		Tokens:  []string{"legacy"},
+		ApiKeys: []config.ApiKey{
+			{Name: "web", Key: "web-key", Tenant: "acme", Inputs: []string{"snowplow"}},
+			{Name: "off", Key: "off-key", Disabled: true},
+			{Name: "old", Key: "old-key", Expires: "2020-01-01"},
+			{Name: "slow", Key: "slow-key", RateLimiter: config.RateLimiter{Enabled: true, Period: "H", Limit: 1}},
+		},
+		ApiKeyStore: config.ApiKeyStore{Enabled: true, Type: "file", Path: store, RefreshSeconds: 3600},
+	}
+	auth, err := Auth(conf)
+	assert.Nil(t, err)
+	gin.SetMode(gin.TestMode)
+	var identity, tenant string
+	r := gin.New()
+	r.Use(auth)
+	handler := func(c *gin.Context) {
+		identity = c.GetString(constants.AUTH_IDENTITY)
+		tenant = c.GetString(constants.TENANT)
+	}
+	r.GET("/snowplow", InputScope("snowplow"), handler)
+	r.GET("/pixel", InputScope("pixel"), handler)
+	var testCases = []struct {
+		name         string
+		path         string
+		key          string
+		wantCode     int
+		wantIdentity string
+	}{
+		{"named", "/snowplow", "web-key", http.StatusOK, "key:web"},
+		{"other input", "/pixel", "web-key", http.StatusForbidden, ""},
+		{"stored", "/pixel", "stored-key", http.StatusOK, "key:stored"},
+		{"legacy token", "/pixel", "legacy", http.StatusOK, identify(BEARER, "legacy")},
+		{"disabled", "/pixel", "off-key", http.StatusUnauthorized, ""},
+		{"expired", "/pixel", "old-key", http.StatusUnauthorized, ""},
+		{"unknown", "/pixel", "nope", http.StatusUnauthorized, ""},
+		{"within limit", "/pixel", "slow-key", http.StatusOK, "key:slow"},
+		{"throttled", "/pixel", "slow-key", http.StatusTooManyRequests, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			identity, tenant = "", ""
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tc.key)
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, tc.wantCode, rec.Code)
+			assert.Equal(t, tc.wantIdentity, identity)
+			if tc.name == "named" {
+				assert.Equal(t, "acme", tenant)
+			}
+		})
+	}
+	usage := ApiKeyUsage()
+	assert.Equal(t, int64(2), usage["web"][KEY_ACCEPTED]) // Input scopes apply after auth
+	assert.Equal(t, int64(1), usage["web"][KEY_INPUT_DENIED])
+	assert.Equal(t, int64(1), usage["off"][KEY_DISABLED])
+	assert.Equal(t, int64(1), usage["old"][KEY_EXPIRED])
+	assert.Equal(t, int64(1), usage["slow"][KEY_THROTTLED])
+}
+
+func TestApiKeysRejectInvalidConfig(t *testing.T) {
+	_, err := Auth(config.Auth{ApiKeys: []config.ApiKey{{Name: "nameless"}}})
+	assert.NotNil(t, err)
+	_, err = Auth(config.Auth{ApiKeys: []config.ApiKey{{Name: "a", Key: "k", Expires: "soon"}}})
+	assert.NotNil(t, err)
+}
+
+func TestApiKeysKeepLimitersOnReload(t *testing.T) {
+	limit := config.RateLimiter{Enabled: true, Period: "H", Limit: 1}
+	conf := config.Auth{ApiKeys: []config.ApiKey{{Name: "web", Key: "web-key", RateLimiter: limit}}}
+	keys := &apiKeys{}
+	assert.Nil(t, keys.set(conf, []config.ApiKey{{Name: "stored", Key: "stored-key", RateLimiter: limit}}))
+	web, _ := keys.lookup("web-key")
+	stored, _ := keys.lookup("stored-key")
+
+	// Unchanged limits keep counting across reloads
+	changed := limit
+	changed.Limit = 2
+	assert.Nil(t, keys.set(conf, []config.ApiKey{{Name: "stored", Key: "stored-key", RateLimiter: changed}}))
+	reloadedWeb, _ := keys.lookup("web-key")
+	reloadedStored, _ := keys.lookup("stored-key")
+	assert.Same(t, web.limiter, reloadedWeb.limiter)
+	assert.NotSame(t, stored.limiter, reloadedStored.limiter)
+}
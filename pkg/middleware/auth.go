@@ -5,11 +5,19 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
 	"github.com/silverton-io/buz/pkg/response"
 )
 
@@ -22,53 +30,260 @@ type authHeader struct {
 	Token string `header:"Authorization"`
 }
 
-func tokenIsValid(token string, conf config.Auth) bool {
-	for _, validToken := range conf.Tokens {
-		if token == validToken {
-			return true
+// identify returns a loggable identity for the token - the username of
+// basic credentials, or a fingerprint of bearer tokens.
+func identify(scheme string, token string) string {
+	if scheme == BASIC {
+		if decoded, err := base64.StdEncoding.DecodeString(token); err == nil {
+			username, _, _ := strings.Cut(string(decoded), ":")
+			return username
 		}
 	}
-	return false
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])[:12]
 }
 
-// The simplest-possible way to lock down routes
-func Auth(conf config.Auth) gin.HandlerFunc {
+// Auth methods
+const (
+	BASIC_AUTH   string = "basic"  // Basic auth users
+	BEARER_TOKEN string = "bearer" // Static bearer tokens
+	JWT          string = "jwt"
+	HMAC         string = "hmac"
+)
+
+const BASIC_AUTH_CHALLENGE string = `Basic realm="buz"`
+
+// authMethods are the methods a route accepts. Nil accepts every method.
+type authMethods map[string]bool
+
+func buildAuthMethods(methods []string) (authMethods, error) {
+	if len(methods) == 0 {
+		return nil, nil
+	}
+	m := make(authMethods)
+	for _, method := range methods {
+		switch method {
+		case API_KEY, BASIC_AUTH, BEARER_TOKEN, JWT, HMAC:
+			m[method] = true
+		default:
+			return nil, errors.New("unsupported auth method: " + method)
+		}
+	}
+	return m, nil
+}
+
+func (m authMethods) allows(method string) bool {
+	return m == nil || m[method]
+}
+
+type routeAuth struct {
+	paths   []string
+	methods authMethods
+}
+
+// staticCredentials are the basic auth users and static bearer tokens.
+// Secrets are compared by their hashes, in constant time.
+type staticCredentials struct {
+	basic  map[string][32]byte
+	bearer map[[32]byte]string
+}
+
+func buildStaticCredentials(conf config.Auth) (*staticCredentials, error) {
+	creds := &staticCredentials{basic: make(map[string][32]byte), bearer: make(map[[32]byte]string)}
+	for _, u := range conf.Basic {
+		if u.Username == "" || u.Password == "" {
+			return nil, errors.New("basic auth users require a username and password")
+		}
+		creds.basic[u.Username] = sha256.Sum256([]byte(u.Password))
+	}
+	for _, b := range conf.Bearer {
+		if b.Name == "" || b.Token == "" {
+			return nil, errors.New("bearer tokens require a name and token")
+		}
+		creds.bearer[sha256.Sum256([]byte(b.Token))] = b.Name
+	}
+	return creds, nil
+}
+
+// basicUser returns the username of valid basic credentials
+func (s *staticCredentials) basicUser(token string) (string, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return "", false
+	}
+	username, password, _ := strings.Cut(string(decoded), ":")
+	expected, ok := s.basic[username]
+	sum := sha256.Sum256([]byte(password))
+	if !ok || subtle.ConstantTimeCompare(sum[:], expected[:]) != 1 {
+		return "", false
+	}
+	return username, true
+}
+
+// bearerName returns the name of a static bearer token
+func (s *staticCredentials) bearerName(token string) (string, bool) {
+	name, ok := s.bearer[sha256.Sum256([]byte(token))]
+	return name, ok
+}
+
+// The simplest-possible way to lock down routes. Tokens are api keys,
+// which are checked against their state and rate limit. Bearer tokens
+// which aren't api keys are validated as jwts, if enabled, and their
+// claims are stored for downstream handlers. Requests can be signed
+// instead, if hmac is enabled. With replay protection, signatures are
+// shared across instances and jwts can only be used once per nonce.
+//
+// Basic auth users and static bearer tokens are accepted alongside api
+// keys. Routes accept the configured methods, which can be replaced for
+// routes under path prefixes, so that routes for webhook providers which
+// only support basic auth can accept it alone.
+func Auth(conf config.Auth) (gin.HandlerFunc, error) {
+	keys, err := buildApiKeys(conf)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := buildStaticCredentials(conf)
+	if err != nil {
+		return nil, err
+	}
+	defaultMethods, err := buildAuthMethods(conf.Methods)
+	if err != nil {
+		return nil, err
+	}
+	var routes []routeAuth
+	for _, r := range conf.Routes {
+		methods, err := buildAuthMethods(r.Methods)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, routeAuth{paths: r.Paths, methods: methods})
+	}
+	var verifier *jwtVerifier
+	if conf.Jwt.Enabled {
+		verifier = newJwtVerifier(conf.Jwt)
+	}
+	var replays *replayGuard
+	var seen nonceStore
+	if conf.Replays.Enabled {
+		var skew time.Duration
+		if verifier != nil {
+			skew = verifier.skew
+		}
+		if replays, err = newReplayGuard(conf.Replays, skew); err != nil {
+			return nil, err
+		}
+		seen = replays.store
+	}
+	var signatures *hmacVerifier
+	if conf.Hmac.Enabled {
+		signatures = newHmacVerifier(conf.Hmac, seen)
+	}
 	return func(c *gin.Context) {
+		methods := defaultMethods
+		for _, r := range routes {
+			if anyPrefix(c.Request.URL.Path, r.paths) {
+				methods = r.methods
+				break
+			}
+		}
+		unauthorized := func(resp response.Response) {
+			if methods.allows(BASIC_AUTH) && len(creds.basic) > 0 {
+				c.Header("WWW-Authenticate", BASIC_AUTH_CHALLENGE)
+			}
+			c.JSON(http.StatusUnauthorized, resp)
+			c.Abort()
+		}
+		// Signed requests are authenticated by their signature alone
+		if signatures != nil && methods.allows(HMAC) && c.GetHeader(signatures.header) != "" {
+			client, err := signatures.verify(c.Request)
+			if err != nil {
+				log.Debug().Err(err).Msg("🟡 invalid request signature")
+				unauthorized(response.InvalidSignature)
+				return
+			}
+			c.Set(constants.AUTH_IDENTITY, "hmac:"+client)
+			c.Next()
+			return
+		}
 		h := authHeader{}
 		if err := c.ShouldBindHeader(&h); err != nil {
 			// Can't bind Authorization header
-			c.JSON(http.StatusUnauthorized, response.MissingAuthHeader)
-			c.Abort()
+			unauthorized(response.MissingAuthHeader)
 			return
 		}
 		if h.Token == "" {
 			// No Authorization header present
-			c.JSON(http.StatusUnauthorized, response.MissingAuthHeader)
-			c.Abort()
+			unauthorized(response.MissingAuthHeader)
 			return
 		}
 		tokenParts := strings.Split(h.Token, " ")
 		if len(tokenParts) < 2 {
 			// Header present but missing scheme or token
-			c.JSON(http.StatusUnauthorized, response.MissingAuthSchemeOrToken)
-			c.Abort()
+			unauthorized(response.MissingAuthSchemeOrToken)
 			return
 		}
 		scheme := tokenParts[0]
 		token := tokenParts[1]
 		if scheme != BEARER && scheme != BASIC {
 			// Auth scheme isn't supported
-			c.JSON(http.StatusUnauthorized, response.InvalidAuthScheme)
-			c.Abort()
+			unauthorized(response.InvalidAuthScheme)
+			return
+		}
+		if scheme == BASIC && methods.allows(BASIC_AUTH) {
+			if username, ok := creds.basicUser(token); ok {
+				c.Set(constants.AUTH_IDENTITY, "basic:"+username)
+				c.Next()
+				return
+			}
+		}
+		if scheme == BEARER && methods.allows(BEARER_TOKEN) {
+			if name, ok := creds.bearerName(token); ok {
+				c.Set(constants.AUTH_IDENTITY, "bearer:"+name)
+				c.Next()
+				return
+			}
+		}
+		key, isValid := keys.lookup(token)
+		isValid = isValid && methods.allows(API_KEY)
+		if !isValid && scheme == BEARER && verifier != nil && methods.allows(JWT) && looksLikeJwt(token) {
+			claims, err := verifier.verify(token)
+			if err != nil {
+				log.Debug().Err(err).Msg("🟡 invalid jwt")
+				unauthorized(response.InvalidAuthToken)
+				return
+			}
+			if replays != nil {
+				if err := replays.checkJwt(c, claims); err != nil {
+					log.Debug().Err(err).Msg("🟡 jwt request rejected as a replay")
+					unauthorized(response.RequestReplayed)
+					return
+				}
+			}
+			sub, _ := claims["sub"].(string)
+			c.Set(constants.AUTH_IDENTITY, "jwt:"+sub)
+			c.Set(constants.AUTH_CLAIMS, claims)
+			c.Next()
 			return
 		}
-		isValid := tokenIsValid(token, conf)
 		if !isValid {
 			// Invalid token
-			c.JSON(http.StatusUnauthorized, response.InvalidAuthToken)
+			unauthorized(response.InvalidAuthToken)
+			return
+		}
+		if status, resp, ok := key.admit(c); !ok {
+			c.JSON(status, resp)
 			c.Abort()
 			return
 		}
+		if key.unnamed {
+			c.Set(constants.AUTH_IDENTITY, identify(scheme, token))
+		} else {
+			c.Set(constants.AUTH_IDENTITY, "key:"+key.name)
+		}
+		if key.tenant != "" {
+			c.Set(constants.TENANT, key.tenant)
+		}
+		c.Set(constants.AUTH_API_KEY, key)
 		c.Next()
-	}
+	}, nil
 }
@@ -3,3 +3,81 @@
 // which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
 
 package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthMethods(t *testing.T) {
+	conf := config.Auth{
+		Enabled: true,
+		ApiKeys: []config.ApiKey{{Name: "tracker", Key: "k3y"}},
+		Basic:   []config.BasicUser{{Username: "provider", Password: "pa55"}},
+		Bearer:  []config.Bearer{{Name: "ci", Token: "t0ken"}},
+		Methods: []string{API_KEY, BEARER_TOKEN},
+		Routes:  []config.RouteAuth{{Paths: []string{"/webhook"}, Methods: []string{BASIC_AUTH}}},
+	}
+	gin.SetMode(gin.TestMode)
+	auth, err := Auth(conf)
+	assert.Nil(t, err)
+	var identity string
+	r := gin.New()
+	r.Use(auth)
+	handle := func(c *gin.Context) { identity = c.GetString(constants.AUTH_IDENTITY) }
+	r.GET("/webhook", handle)
+	r.GET("/events", handle)
+	basic := func(username string, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+	var testCases = []struct {
+		name          string
+		path          string
+		auth          string
+		wantCode      int
+		wantIdentity  string
+		wantChallenge bool
+	}{
+		{"basic", "/webhook", basic("provider", "pa55"), http.StatusOK, "basic:provider", false},
+		{"wrong password", "/webhook", basic("provider", "guess"), http.StatusUnauthorized, "", true},
+		{"unknown user", "/webhook", basic("other", "pa55"), http.StatusUnauthorized, "", true},
+		{"api key on basic route", "/webhook", "Bearer k3y", http.StatusUnauthorized, "", true},
+		{"missing on basic route", "/webhook", "", http.StatusUnauthorized, "", true},
+		{"api key", "/events", "Bearer k3y", http.StatusOK, "key:tracker", false},
+		{"static bearer", "/events", "Bearer t0ken", http.StatusOK, "bearer:ci", false},
+		{"basic on default route", "/events", basic("provider", "pa55"), http.StatusUnauthorized, "", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			identity = ""
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, tc.wantCode, rec.Code)
+			assert.Equal(t, tc.wantIdentity, identity)
+			assert.Equal(t, tc.wantChallenge, rec.Header().Get("WWW-Authenticate") != "")
+		})
+	}
+}
+
+func TestAuthConfigErrors(t *testing.T) {
+	for _, conf := range []config.Auth{
+		{Methods: []string{"cookie"}},
+		{Routes: []config.RouteAuth{{Paths: []string{"/"}, Methods: []string{"cookie"}}}},
+		{Basic: []config.BasicUser{{Username: "provider"}}},
+		{Bearer: []config.Bearer{{Token: "t0ken"}}},
+	} {
+		_, err := Auth(conf)
+		assert.NotNil(t, err)
+	}
+}
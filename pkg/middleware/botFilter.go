@@ -0,0 +1,162 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/response"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+var botRejections = stats.NewSchemaStats()
+
+// BotRejections returns the requests rejected by each bot filter rule,
+// by route
+func BotRejections() map[string]map[string]int64 {
+	return botRejections.Snapshot()
+}
+
+type asnReader interface {
+	ASN(ip net.IP) (*geoip2.ASN, error)
+}
+
+type botRule struct {
+	name          string
+	userAgent     *regexp.Regexp
+	asns          map[uint]bool
+	header        string
+	headerValue   *regexp.Regexp
+	missingHeader string
+}
+
+func buildBotRule(conf config.BotRule) (*botRule, error) {
+	if conf.Name == "" {
+		return nil, errors.New("bot filter rules require a name")
+	}
+	r := &botRule{name: conf.Name, header: conf.Header, missingHeader: conf.MissingHeader}
+	var err error
+	if conf.UserAgent != "" {
+		if r.userAgent, err = regexp.Compile(conf.UserAgent); err != nil {
+			return nil, err
+		}
+	}
+	if conf.HeaderValue != "" {
+		if r.headerValue, err = regexp.Compile(conf.HeaderValue); err != nil {
+			return nil, err
+		}
+	}
+	if len(conf.Asns) > 0 {
+		r.asns = make(map[uint]bool)
+		for _, asn := range conf.Asns {
+			r.asns[asn] = true
+		}
+	}
+	if r.userAgent == nil && r.asns == nil && r.header == "" && r.missingHeader == "" {
+		return nil, errors.New("bot filter rule has no conditions: " + conf.Name)
+	}
+	return r, nil
+}
+
+// matches returns true if the request meets all of the rule's conditions.
+// The asn is only looked up if a rule needs it.
+func (r *botRule) matches(req *http.Request, asn func() uint) bool {
+	if r.userAgent != nil && !r.userAgent.MatchString(req.UserAgent()) {
+		return false
+	}
+	if r.header != "" {
+		values, ok := req.Header[http.CanonicalHeaderKey(r.header)]
+		if !ok || (r.headerValue != nil && !anyMatch(r.headerValue, values)) {
+			return false
+		}
+	}
+	if r.missingHeader != "" && req.Header.Get(r.missingHeader) != "" {
+		return false
+	}
+	if r.asns != nil && !r.asns[asn()] {
+		return false
+	}
+	return true
+}
+
+func anyMatch(re *regexp.Regexp, values []string) bool {
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+type botFilter struct {
+	rules []*botRule
+	asns  asnReader
+}
+
+// lookupAsn returns the asn of the client, or 0 if it is unknown
+func (f *botFilter) lookupAsn(c *gin.Context) uint {
+	ip := net.ParseIP(c.ClientIP())
+	if f.asns == nil || ip == nil {
+		return 0
+	}
+	asn, err := f.asns.ASN(ip)
+	if err != nil {
+		return 0
+	}
+	return asn.AutonomousSystemNumber
+}
+
+func (f *botFilter) handle(c *gin.Context) {
+	var asn *uint
+	lookup := func() uint {
+		if asn == nil {
+			a := f.lookupAsn(c)
+			asn = &a
+		}
+		return *asn
+	}
+	for _, r := range f.rules {
+		if r.matches(c.Request, lookup) {
+			botRejections.Increment(r.name, c.FullPath(), 1)
+			log.Trace().Str("rule", r.name).Str("userAgent", c.Request.UserAgent()).Msg("bot request rejected")
+			c.JSON(http.StatusForbidden, response.BotRejected)
+			c.Abort()
+			return
+		}
+	}
+	c.Next()
+}
+
+// BotFilter rejects requests matching any of the rules, so bots don't use
+// validation and sink capacity. Unlike the bot transform, which tags or
+// drops envelopes after they are built, rejected requests get no further.
+func BotFilter(conf config.BotFilter) (gin.HandlerFunc, error) {
+	f := &botFilter{}
+	for _, c := range conf.Rules {
+		r, err := buildBotRule(c)
+		if err != nil {
+			return nil, err
+		}
+		if r.asns != nil && conf.AsnPath == "" {
+			return nil, errors.New("bot filter rules by asn require an asn database: " + r.name)
+		}
+		f.rules = append(f.rules, r)
+	}
+	if conf.AsnPath != "" {
+		asns, err := geoip2.Open(conf.AsnPath)
+		if err != nil {
+			return nil, err
+		}
+		f.asns = asns
+	}
+	return f.handle, nil
+}
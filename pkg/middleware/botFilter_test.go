@@ -0,0 +1,86 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAsnReader struct{}
+
+func (r fakeAsnReader) ASN(ip net.IP) (*geoip2.ASN, error) {
+	asn := &geoip2.ASN{}
+	if ip.Equal(net.ParseIP("203.0.113.9")) {
+		asn.AutonomousSystemNumber = 64500
+	}
+	return asn, nil
+}
+
+func TestBotFilter(t *testing.T) {
+	var rules []*botRule
+	for _, c := range []config.BotRule{
+		{Name: "scrapers", UserAgent: "(?i)scrapy"},
+		{Name: "scanner", Header: "X-Scanner", HeaderValue: "zgrab"},
+		{Name: "headless", UserAgent: "^Mozilla/", MissingHeader: "Accept-Language"},
+		{Name: "hosting", Asns: []uint{64500}},
+	} {
+		r, err := buildBotRule(c)
+		assert.Nil(t, err)
+		rules = append(rules, r)
+	}
+	f := &botFilter{rules: rules, asns: fakeAsnReader{}}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(f.handle)
+	r.GET("/pixel", func(c *gin.Context) {})
+	var testCases = []struct {
+		name     string
+		headers  map[string]string
+		ip       string
+		wantCode int
+	}{
+		{"browser", map[string]string{"User-Agent": "Mozilla/5.0", "Accept-Language": "en"}, "198.51.100.1", http.StatusOK},
+		{"scraper", map[string]string{"User-Agent": "Scrapy/2.8"}, "198.51.100.1", http.StatusForbidden},
+		{"scanner", map[string]string{"X-Scanner": "zgrab/0.x"}, "198.51.100.1", http.StatusForbidden},
+		{"other scanner header", map[string]string{"X-Scanner": "friendly"}, "198.51.100.1", http.StatusOK},
+		{"headless", map[string]string{"User-Agent": "Mozilla/5.0"}, "198.51.100.1", http.StatusForbidden},
+		{"hosting", map[string]string{"User-Agent": "Mozilla/5.0", "Accept-Language": "en"}, "203.0.113.9", http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/pixel", nil)
+			req.RemoteAddr = tc.ip + ":1234"
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, tc.wantCode, rec.Code)
+		})
+	}
+	rejections := BotRejections()
+	assert.Equal(t, int64(1), rejections["scrapers"]["/pixel"])
+	assert.Equal(t, int64(1), rejections["hosting"]["/pixel"])
+}
+
+func TestBotFilterRejectsInvalidRules(t *testing.T) {
+	for _, conf := range []config.BotFilter{
+		{Rules: []config.BotRule{{Name: "empty"}}},
+		{Rules: []config.BotRule{{UserAgent: "nameless"}}},
+		{Rules: []config.BotRule{{Name: "bad", UserAgent: "("}}},
+		{Rules: []config.BotRule{{Name: "no database", Asns: []uint{1}}}},
+	} {
+		_, err := BotFilter(conf)
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/response"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+const (
+	DEFAULT_CIRCUIT_BREAKER_COOLDOWN_MS int = 5000
+	CIRCUIT_BREAKER_LATENCY_SAMPLES     int = 1000
+)
+
+// Reasons the circuit breaker trips
+const (
+	IN_FLIGHT   string = "inFlight"
+	QUEUE_DEPTH string = "queueDepth"
+	P99_LATENCY string = "p99Latency"
+)
+
+// How often the p99 latency is recomputed
+const P99_INTERVAL time.Duration = time.Second
+
+var breakerRejections = stats.NewSchemaStats()
+
+// CircuitBreakerRejections returns the requests rejected by the circuit
+// breaker, by the reason it tripped and by route
+func CircuitBreakerRejections() map[string]map[string]int64 {
+	return breakerRejections.Snapshot()
+}
+
+// circuitBreaker trips when a threshold is exceeded, and stays open for the
+// cooldown. Latencies of the overload are forgotten once it closes, since
+// no requests are measured while it's open.
+type circuitBreaker struct {
+	inFlight   int64 // First, so it's aligned for atomic access
+	conf       config.CircuitBreaker
+	cooldown   time.Duration
+	queueDepth func() int
+	mu         sync.Mutex
+	reason     string
+	openUntil  time.Time
+	latencies  []time.Duration
+	next       int
+	p99        time.Duration
+	measured   time.Time
+	now        func() time.Time
+}
+
+func newCircuitBreaker(conf config.CircuitBreaker, queueDepth func() int) *circuitBreaker {
+	cooldown := conf.CooldownMs
+	if cooldown <= 0 {
+		cooldown = DEFAULT_CIRCUIT_BREAKER_COOLDOWN_MS
+	}
+	return &circuitBreaker{
+		conf:       conf,
+		cooldown:   time.Duration(cooldown) * time.Millisecond,
+		queueDepth: queueDepth,
+		latencies:  make([]time.Duration, 0, CIRCUIT_BREAKER_LATENCY_SAMPLES),
+		now:        time.Now,
+	}
+}
+
+// record adds the latency of a request to the samples, replacing the oldest
+func (b *circuitBreaker) record(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.latencies) < cap(b.latencies) {
+		b.latencies = append(b.latencies, d)
+		return
+	}
+	b.latencies[b.next] = d
+	b.next = (b.next + 1) % len(b.latencies)
+}
+
+// p99Latency returns the p99 of the samples, which is recomputed at most
+// once per interval
+func (b *circuitBreaker) p99Latency(now time.Time) time.Duration {
+	if now.Sub(b.measured) < P99_INTERVAL || len(b.latencies) == 0 {
+		return b.p99
+	}
+	sorted := make([]time.Duration, len(b.latencies))
+	copy(sorted, b.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	b.p99 = sorted[int(math.Ceil(0.99*float64(len(sorted))))-1]
+	b.measured = now
+	return b.p99
+}
+
+// check returns why the request should be rejected, and how long until
+// it should be retried. The reason is empty if it should go through.
+func (b *circuitBreaker) check(inFlight int) (string, time.Duration) {
+	// The depth is read before locking, so requests don't wait on the
+	// sinks' stats while holding the breaker
+	depth := 0
+	if b.conf.MaxQueuedBatches > 0 && b.queueDepth != nil {
+		depth = b.queueDepth()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if now.Before(b.openUntil) {
+		return b.reason, b.openUntil.Sub(now)
+	}
+	if b.reason != "" {
+		log.Info().Str("reason", b.reason).Msg("🟢 circuit breaker closed")
+		b.reason = ""
+		b.latencies = b.latencies[:0]
+		b.next = 0
+		b.p99 = 0
+	}
+	var reason string
+	switch {
+	case b.conf.MaxInFlight > 0 && inFlight > b.conf.MaxInFlight:
+		reason = IN_FLIGHT
+	case b.conf.MaxQueuedBatches > 0 && depth > b.conf.MaxQueuedBatches:
+		reason = QUEUE_DEPTH
+	case b.conf.MaxP99LatencyMs > 0 && b.p99Latency(now) > time.Duration(b.conf.MaxP99LatencyMs)*time.Millisecond:
+		reason = P99_LATENCY
+	}
+	if reason == "" {
+		return "", 0
+	}
+	log.Error().Str("reason", reason).Dur("cooldown", b.cooldown).Msg("🔴 circuit breaker tripped")
+	b.reason = reason
+	b.openUntil = now.Add(b.cooldown)
+	return reason, b.cooldown
+}
+
+// CircuitBreaker fast-fails requests with a 503 while the collector is
+// overloaded, rather than letting them queue up and time out. The queue
+// depth is the number of batches queued in front of the sinks.
+func CircuitBreaker(conf config.CircuitBreaker, queueDepth func() int) gin.HandlerFunc {
+	b := newCircuitBreaker(conf, queueDepth)
+	return func(c *gin.Context) {
+		inFlight := atomic.AddInt64(&b.inFlight, 1)
+		defer atomic.AddInt64(&b.inFlight, -1)
+		if reason, retryAfter := b.check(int(inFlight)); reason != "" {
+			breakerRejections.Increment(reason, c.FullPath(), 1)
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, response.CollectorOverloaded)
+			return
+		}
+		start := time.Now()
+		c.Next()
+		b.record(time.Since(start))
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreakerQueueDepth(t *testing.T) {
+	depth := 0
+	conf := config.CircuitBreaker{Enabled: true, MaxQueuedBatches: 10, CooldownMs: 2000}
+	b := newCircuitBreaker(conf, func() int { return depth })
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	reason, _ := b.check(1)
+	assert.Equal(t, "", reason)
+
+	depth = 11
+	reason, retryAfter := b.check(1)
+	assert.Equal(t, QUEUE_DEPTH, reason)
+	assert.Equal(t, 2*time.Second, retryAfter)
+
+	depth = 0
+	now = now.Add(time.Second)
+	reason, _ = b.check(1)
+	assert.Equal(t, QUEUE_DEPTH, reason, "the breaker should stay open for the cooldown")
+
+	now = now.Add(time.Second)
+	reason, _ = b.check(1)
+	assert.Equal(t, "", reason)
+}
+
+func TestCircuitBreakerLatency(t *testing.T) {
+	conf := config.CircuitBreaker{Enabled: true, MaxP99LatencyMs: 100}
+	b := newCircuitBreaker(conf, nil)
+	for i := 0; i < 199; i++ {
+		b.record(time.Millisecond)
+	}
+	b.record(time.Second)
+	reason, _ := b.check(1)
+	assert.Equal(t, "", reason, "one slow request shouldn't trip the breaker")
+
+	b.record(time.Second)
+	b.record(time.Second)
+	b.measured = time.Time{}
+	reason, _ = b.check(1)
+	assert.Equal(t, P99_LATENCY, reason)
+}
+
+func TestCircuitBreakerMiddleware(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	conf := config.CircuitBreaker{Enabled: true, MaxInFlight: 1, CooldownMs: 1500}
+	r := gin.New()
+	r.Use(CircuitBreaker(conf, nil))
+	r.GET("/slow", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.Status(http.StatusOK)
+	})
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		done <- rec.Code
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assert.Equal(t, int64(1), CircuitBreakerRejections()[IN_FLIGHT]["/slow"])
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+}
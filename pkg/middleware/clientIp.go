@@ -0,0 +1,120 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+// Client ip headers
+const (
+	X_FORWARDED_FOR  string = "X-Forwarded-For"
+	X_REAL_IP        string = "X-Real-Ip"
+	TRUE_CLIENT_IP   string = "True-Client-Ip"
+	CF_CONNECTING_IP string = "Cf-Connecting-Ip"
+)
+
+const DEFAULT_FORWARDED_FOR_DEPTH int = 1
+
+type clientIpResolver struct {
+	trusted []*net.IPNet
+	header  string
+	depth   int
+}
+
+func newClientIpResolver(conf config.ClientIp) (*clientIpResolver, error) {
+	r := &clientIpResolver{header: http.CanonicalHeaderKey(conf.Header), depth: conf.Depth}
+	switch r.header {
+	case X_FORWARDED_FOR, X_REAL_IP, TRUE_CLIENT_IP, CF_CONNECTING_IP, "":
+	default:
+		return nil, errors.New("unsupported client ip header: " + conf.Header)
+	}
+	if r.depth <= 0 {
+		r.depth = DEFAULT_FORWARDED_FOR_DEPTH
+	}
+	for _, p := range conf.TrustedProxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, cidr, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, errors.New("invalid trusted proxy: " + p)
+		}
+		r.trusted = append(r.trusted, cidr)
+	}
+	return r, nil
+}
+
+// trusts returns true if the peer is a trusted proxy. Without trusted
+// proxies no peer is trusted, so clients can't spoof their ip by sending
+// the header themselves.
+func (r *clientIpResolver) trusts(peer net.IP) bool {
+	for _, cidr := range r.trusted {
+		if cidr.Contains(peer) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the client ip of a request from the peer, or nil if the
+// peer isn't a trusted proxy or its header doesn't hold a valid ip.
+// X-Forwarded-For is appended to by each proxy, so entries are counted
+// from the right, where they can't be spoofed by the client.
+func (r *clientIpResolver) resolve(peer net.IP, headers http.Header) net.IP {
+	if r.header == "" || peer == nil || !r.trusts(peer) {
+		return nil
+	}
+	if r.header != X_FORWARDED_FOR {
+		return net.ParseIP(strings.TrimSpace(headers.Get(r.header)))
+	}
+	var entries []string
+	for _, v := range headers.Values(r.header) {
+		entries = append(entries, strings.Split(v, ",")...)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	i := len(entries) - r.depth
+	if i < 0 {
+		i = 0
+	}
+	return net.ParseIP(strings.TrimSpace(entries[i]))
+}
+
+// ClientIp replaces the remote address of requests from trusted proxies
+// with the client ip from the configured header, so that rate limits, bot
+// filters, identities, and envelopes all see the same client. The port of
+// the remote address is kept.
+func ClientIp(conf config.ClientIp) (gin.HandlerFunc, error) {
+	r, err := newClientIpResolver(conf)
+	if err != nil {
+		return nil, err
+	}
+	if r.header != "" && len(r.trusted) == 0 {
+		log.Warn().Msg("🟡 client ip header is set without trusted proxies - it will be ignored")
+	}
+	return func(c *gin.Context) {
+		host, port, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+		if err != nil {
+			host = strings.TrimSpace(c.Request.RemoteAddr)
+		}
+		if ip := r.resolve(net.ParseIP(host), c.Request.Header); ip != nil {
+			c.Request.RemoteAddr = net.JoinHostPort(ip.String(), port)
+		}
+		c.Next()
+	}, nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIp(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		conf    config.ClientIp
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"no header", config.ClientIp{}, "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "10.0.0.1"},
+		{"single proxy", config.ClientIp{Header: "x-forwarded-for", TrustedProxies: []string{"10.0.0.0/8"}}, "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.9"}, "203.0.113.9"},
+		{"two proxies", config.ClientIp{Header: X_FORWARDED_FOR, Depth: 2, TrustedProxies: []string{"10.0.0.0/8"}}, "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.9, 10.0.0.2"}, "203.0.113.9"},
+		{"shallow header", config.ClientIp{Header: X_FORWARDED_FOR, Depth: 3, TrustedProxies: []string{"10.0.0.0/8"}}, "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "203.0.113.9"},
+		{"untrusted peer", config.ClientIp{Header: X_FORWARDED_FOR, TrustedProxies: []string{"10.0.0.2"}}, "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "10.0.0.1"},
+		{"no trusted proxies", config.ClientIp{Header: X_FORWARDED_FOR}, "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "10.0.0.1"},
+		{"cloudflare", config.ClientIp{Header: CF_CONNECTING_IP, TrustedProxies: []string{"10.0.0.1"}}, "10.0.0.1:4000", map[string]string{"Cf-Connecting-Ip": "2001:db8::1", "X-Forwarded-For": "203.0.113.9"}, "2001:db8::1"},
+		{"invalid header", config.ClientIp{Header: TRUE_CLIENT_IP, TrustedProxies: []string{"10.0.0.1"}}, "10.0.0.1:4000", map[string]string{"True-Client-Ip": "unknown"}, "10.0.0.1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientIp, err := ClientIp(tc.conf)
+			assert.Nil(t, err)
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			// Like the app, gin itself trusts no forwarding headers
+			assert.Nil(t, r.SetTrustedProxies(nil))
+			var got string
+			r.Use(clientIp)
+			r.GET("/", func(c *gin.Context) { got = c.ClientIP() })
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.peer
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestClientIpConfigErrors(t *testing.T) {
+	_, err := ClientIp(config.ClientIp{Header: "Forwarded"})
+	assert.NotNil(t, err)
+	_, err = ClientIp(config.ClientIp{TrustedProxies: []string{"proxy"}})
+	assert.NotNil(t, err)
+}
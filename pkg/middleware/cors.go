@@ -13,13 +13,85 @@ import (
 	"github.com/silverton-io/buz/pkg/config"
 )
 
+const DEFAULT_CORS_ALLOW_HEADERS string = "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Set-Cookie, Cookie"
+
+type corsPolicy struct {
+	paths            []string
+	allowOrigin      []string
+	allowCredentials bool
+	allowHeaders     string
+	allowMethods     string
+	maxAge           string
+}
+
+func newCorsPolicy(paths []string, origin []string, credentials bool, headers []string, methods []string, maxAge int) corsPolicy {
+	p := corsPolicy{
+		paths:            paths,
+		allowOrigin:      origin,
+		allowCredentials: credentials,
+		allowHeaders:     strings.Join(headers, ", "),
+		allowMethods:     strings.Join(methods, ", "),
+		maxAge:           strconv.Itoa(maxAge),
+	}
+	if len(headers) == 0 {
+		p.allowHeaders = DEFAULT_CORS_ALLOW_HEADERS
+	}
+	return p
+}
+
+// origin returns the allowed origin of the request. Origins other than *
+// are only allowed if listed, since browsers expect a single origin.
+func (p corsPolicy) origin(requestOrigin string) string {
+	for _, o := range p.allowOrigin {
+		if o == "*" {
+			return o
+		}
+		if requestOrigin != "" && o == requestOrigin {
+			return o
+		}
+	}
+	return ""
+}
+
+// anyPrefix returns true if the path is under one of the prefixes
+func anyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p corsPolicy) matches(path string) bool {
+	return anyPrefix(path, p.paths)
+}
+
+// CORS sets the cors headers of the first route policy which matches the
+// request path, or of the default policy. Policies are matched by path
+// rather than route, since preflight requests don't match a route.
 func CORS(conf config.Cors) gin.HandlerFunc {
+	defaultPolicy := newCorsPolicy(nil, conf.AllowOrigin, conf.AllowCredentials, conf.AllowHeaders, conf.AllowMethods, conf.MaxAge)
+	var policies []corsPolicy
+	for _, r := range conf.Routes {
+		policies = append(policies, newCorsPolicy(r.Paths, r.AllowOrigin, r.AllowCredentials, r.AllowHeaders, r.AllowMethods, r.MaxAge))
+	}
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", strings.Join(conf.AllowOrigin, ", "))
-		c.Header("Access-Control-Allow-Credentials", strconv.FormatBool(conf.AllowCredentials))
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Set-Cookie, Cookie")
-		c.Header("Access-Control-Allow-Methods", strings.Join(conf.AllowMethods, ", "))
-		c.Header("Access-Control-Max-Age", strconv.Itoa(conf.MaxAge))
+		policy := defaultPolicy
+		for _, p := range policies {
+			if p.matches(c.Request.URL.Path) {
+				policy = p
+				break
+			}
+		}
+		if origin := policy.origin(c.GetHeader("Origin")); origin != "" {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		c.Header("Access-Control-Allow-Credentials", strconv.FormatBool(policy.allowCredentials))
+		c.Header("Access-Control-Allow-Headers", policy.allowHeaders)
+		c.Header("Access-Control-Allow-Methods", policy.allowMethods)
+		c.Header("Access-Control-Max-Age", policy.maxAge)
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
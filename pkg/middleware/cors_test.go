@@ -46,3 +46,46 @@ func TestCors(t *testing.T) {
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 	})
 }
+
+func TestRouteCors(t *testing.T) {
+	conf := config.Cors{
+		Enabled:          true,
+		AllowOrigin:      []string{"*"},
+		AllowCredentials: true,
+		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
+		MaxAge:           86400,
+		Routes: []config.RouteCors{
+			{
+				Paths:        []string{"/c/", "/stats"},
+				AllowOrigin:  []string{"https://ops.example.com"},
+				AllowHeaders: []string{"Authorization"},
+				AllowMethods: []string{"GET"},
+				MaxAge:       60,
+			},
+		},
+	}
+	r := gin.New()
+	r.Use(CORS(conf))
+	var testCases = []struct {
+		name        string
+		path        string
+		origin      string
+		wantOrigin  string
+		wantMethods string
+	}{
+		{"open", "/com.snowplowanalytics.snowplow/tp2", "https://shop.example.com", "*", "GET, POST, OPTIONS"},
+		{"locked down", "/c/purge", "https://shop.example.com", "", "GET"},
+		{"allowed origin", "/stats", "https://ops.example.com", "https://ops.example.com", "GET"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodOptions, tc.path, nil)
+			req.Header.Set("Origin", tc.origin)
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.Equal(t, tc.wantOrigin, rec.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tc.wantMethods, rec.Header().Get("Access-Control-Allow-Methods"))
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const (
+	DEFAULT_SIGNATURE_HEADER            string = "X-Buz-Signature"
+	DEFAULT_SIGNATURE_TOLERANCE_SECONDS int    = 300
+)
+
+var (
+	errSignatureMalformed = errors.New("malformed signature header")
+	errSignatureClient    = errors.New("unknown signing client")
+	errSignatureStale     = errors.New("signature timestamp outside of tolerance")
+	errSignatureMismatch  = errors.New("signature does not match")
+	errSignatureReplayed  = errors.New("signature was already used")
+)
+
+// hmacVerifier checks request signatures. Clients sign the timestamp,
+// method, request uri, and body, each separated by a newline, with
+// hmac-sha256 and send it as `keyId=<client>,t=<unix seconds>,v1=<hex>`.
+// Signatures are remembered until their timestamp is outside of the
+// tolerance, after which they are rejected as stale anyway.
+type hmacVerifier struct {
+	header    string
+	tolerance time.Duration
+	secrets   map[string][]byte
+	seen      nonceStore
+	now       func() time.Time
+}
+
+func newHmacVerifier(conf config.Hmac, seen nonceStore) *hmacVerifier {
+	v := &hmacVerifier{
+		header:    conf.Header,
+		tolerance: time.Duration(conf.ToleranceSeconds) * time.Second,
+		secrets:   make(map[string][]byte),
+		seen:      seen,
+		now:       time.Now,
+	}
+	if v.seen == nil {
+		v.seen = newMemoryNonceStore()
+	}
+	if v.header == "" {
+		v.header = DEFAULT_SIGNATURE_HEADER
+	}
+	if v.tolerance <= 0 {
+		v.tolerance = time.Duration(DEFAULT_SIGNATURE_TOLERANCE_SECONDS) * time.Second
+	}
+	for _, c := range conf.Clients {
+		v.secrets[c.Id] = []byte(c.Secret)
+	}
+	return v
+}
+
+// Sign returns the signature of the request contents, for clients
+func Sign(secret []byte, timestamp int64, method string, requestUri string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "\n" + method + "\n" + requestUri + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the id of the client which signed the request. The body
+// is restored for downstream handlers.
+func (v *hmacVerifier) verify(r *http.Request) (string, error) {
+	fields := make(map[string]string)
+	for _, part := range strings.Split(r.Header.Get(v.header), ",") {
+		k, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return "", errSignatureMalformed
+		}
+		fields[k] = val
+	}
+	client, sig := fields["keyId"], fields["v1"]
+	ts, err := strconv.ParseInt(fields["t"], 10, 64)
+	if err != nil || client == "" || sig == "" {
+		return "", errSignatureMalformed
+	}
+	secret, ok := v.secrets[client]
+	if !ok {
+		return "", errSignatureClient
+	}
+	now := v.now()
+	signedAt := time.Unix(ts, 0)
+	if signedAt.Before(now.Add(-v.tolerance)) || signedAt.After(now.Add(v.tolerance)) {
+		return "", errSignatureStale
+	}
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return "", err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	expected := Sign(secret, ts, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(sig))) {
+		return "", errSignatureMismatch
+	}
+	claimed, err := v.seen.claim(r.Context(), "hmac:"+client+":"+expected, signedAt.Add(v.tolerance), now)
+	if err != nil {
+		return "", err
+	}
+	if !claimed {
+		return "", errSignatureReplayed
+	}
+	return client, nil
+}
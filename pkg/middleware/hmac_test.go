@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthHmac(t *testing.T) {
+	conf := config.Auth{Enabled: true, Hmac: config.Hmac{Enabled: true, ToleranceSeconds: 60, Clients: []config.HmacClient{{Id: "billing", Secret: "s3cret"}}}}
+	gin.SetMode(gin.TestMode)
+	var identity, body string
+	auth, err := Auth(conf)
+	assert.Nil(t, err)
+	r := gin.New()
+	r.Use(auth)
+	r.POST("/webhook", func(c *gin.Context) {
+		identity = c.GetString(constants.AUTH_IDENTITY)
+		b, _ := io.ReadAll(c.Request.Body)
+		body = string(b)
+	})
+	now := time.Now().Unix()
+	signed := func(client string, secret string, ts int64, path string) string {
+		return fmt.Sprintf("keyId=%s,t=%d,v1=%s", client, ts, Sign([]byte(secret), ts, http.MethodPost, path, []byte(`{"a":1}`)))
+	}
+	valid := signed("billing", "s3cret", now, "/webhook")
+	var testCases = []struct {
+		name      string
+		signature string
+		wantCode  int
+	}{
+		{"valid", valid, http.StatusOK},
+		{"replayed", valid, http.StatusUnauthorized},
+		{"wrong secret", signed("billing", "guess", now, "/webhook"), http.StatusUnauthorized},
+		{"unknown client", signed("other", "s3cret", now, "/webhook"), http.StatusUnauthorized},
+		{"other route", signed("billing", "s3cret", now, "/other"), http.StatusUnauthorized},
+		{"stale", signed("billing", "s3cret", now-120, "/webhook"), http.StatusUnauthorized},
+		{"malformed", "v1=abc", http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			identity, body = "", ""
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"a":1}`))
+			req.Header.Set(DEFAULT_SIGNATURE_HEADER, tc.signature)
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, tc.wantCode, rec.Code)
+			if tc.wantCode == http.StatusOK {
+				assert.Equal(t, "hmac:billing", identity)
+				assert.Equal(t, `{"a":1}`, body)
+			}
+		})
+	}
+}
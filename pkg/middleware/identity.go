@@ -5,47 +5,128 @@
 package middleware
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/constants"
 )
 
+// cookieDomain returns the longest configured domain which the host is,
+// or is a subdomain of, so one collector can set first-party cookies for
+// several sites. Hosts which don't match fall back to the domain.
+func cookieDomain(conf config.IdentityCookie, host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	domain := conf.Domain
+	matched := -1
+	for _, d := range conf.Domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if (host == d || strings.HasSuffix(host, "."+d)) && len(d) > matched {
+			domain, matched = d, len(d)
+		}
+	}
+	return domain
+}
+
+func sameSite(mode string) http.SameSite {
+	switch mode {
+	case "None":
+		return http.SameSiteNoneMode
+	case "Lax":
+		return http.SameSiteLaxMode
+	case "Strict":
+		return http.SameSiteStrictMode
+	}
+	return http.SameSiteDefaultMode
+}
+
+// Headers with the ip of the client, which aren't kept in cookieless mode
+var clientIpHeaders = []string{"X-Forwarded-For", "X-Real-Ip", "True-Client-Ip", "Cf-Connecting-Ip", "Forwarded"}
+
+var (
+	generatedSalt     []byte
+	generatedSaltOnce sync.Once
+)
+
+// cookielessSalt returns the configured salt, or a random salt if there
+// isn't one. Random salts aren't shared by instances, so each derives
+// different identities.
+func cookielessSalt(conf config.Cookieless) []byte {
+	if conf.Salt != "" {
+		return []byte(conf.Salt)
+	}
+	generatedSaltOnce.Do(func() {
+		log.Warn().Msg("🟡 cookieless identity has no salt - generating one for this instance")
+		generatedSalt = make([]byte, 32)
+		if _, err := rand.Read(generatedSalt); err != nil {
+			panic(err)
+		}
+	})
+	return generatedSalt
+}
+
+// cookielessIdentity derives a pseudonymous identity from the ip and user
+// agent, which rotates every utc day so it can't be tracked across days
+func cookielessIdentity(salt []byte, now time.Time, ip string, userAgent string) string {
+	data := now.UTC().Format("2006-01-02") + "|" + ip + "|" + userAgent
+	return uuid.NewHash(hmac.New(sha256.New, salt), uuid.Nil, []byte(data), 8).String()
+}
+
+// Cookieless sets a daily-rotating identity without setting cookies. The
+// ip headers of the request are removed once the identity is derived, and
+// the request is marked so its client ip isn't stored, so raw ips aren't
+// collected.
+func Cookieless(conf config.Cookieless) gin.HandlerFunc {
+	salt := cookielessSalt(conf)
+	return func(c *gin.Context) {
+		c.Set(constants.IDENTITY, cookielessIdentity(salt, time.Now(), c.ClientIP(), c.Request.UserAgent()))
+		c.Set(constants.WITHHOLD_CLIENT_IP, true)
+		for _, h := range clientIpHeaders {
+			c.Request.Header.Del(h)
+		}
+		c.Next()
+	}
+}
+
 func Identity(conf config.Identity) gin.HandlerFunc {
+	if conf.Cookieless.Enabled {
+		return Cookieless(conf.Cookieless)
+	}
 	return func(c *gin.Context) {
 		identityCookieValue, _ := c.Cookie(conf.Cookie.Name)
-		switch conf.Cookie.SameSite {
-		case "None":
-			c.SetSameSite(http.SameSiteNoneMode)
-		case "Lax":
-			c.SetSameSite(http.SameSiteLaxMode)
-		case "Strict":
-			c.SetSameSite(http.SameSiteStrictMode)
+		if !conf.Cookie.Enabled {
+			if identityCookieValue != "" {
+				c.Set(constants.IDENTITY, identityCookieValue)
+			}
+			c.Next()
+			return
 		}
-		if identityCookieValue != "" {
-			c.SetCookie(
-				conf.Cookie.Name,
-				identityCookieValue,
-				60*60*24*conf.Cookie.TtlDays,
-				conf.Cookie.Path,
-				conf.Cookie.Domain,
-				conf.Cookie.Secure,
-				false,
-			)
-		} else {
+		if identityCookieValue == "" {
 			identityCookieValue = uuid.New().String()
-			c.SetCookie(
-				conf.Cookie.Name,
-				identityCookieValue,
-				60*60*24*conf.Cookie.TtlDays,
-				conf.Cookie.Path,
-				conf.Cookie.Domain,
-				conf.Cookie.Secure,
-				false,
-			)
 		}
+		c.SetSameSite(sameSite(conf.Cookie.SameSite))
+		c.SetCookie(
+			conf.Cookie.Name,
+			identityCookieValue,
+			60*60*24*conf.Cookie.TtlDays,
+			conf.Cookie.Path,
+			cookieDomain(conf.Cookie, c.Request.Host),
+			// Browsers reject SameSite=None cookies which aren't secure
+			conf.Cookie.Secure || conf.Cookie.SameSite == "None",
+			conf.Cookie.HttpOnly,
+		)
 		c.Set(constants.IDENTITY, identityCookieValue)
 		c.Next()
 	}
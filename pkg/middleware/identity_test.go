@@ -0,0 +1,107 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookieDomain(t *testing.T) {
+	conf := config.IdentityCookie{Domain: "fallback.com", Domains: []string{".acme.com", "shop.acme.com", "acme.co.uk"}}
+	var testCases = []struct {
+		host string
+		want string
+	}{
+		{"acme.com", "acme.com"},
+		{"t.acme.com:8080", "acme.com"},
+		{"t.shop.acme.com", "shop.acme.com"},
+		{"T.ACME.CO.UK", "acme.co.uk"},
+		{"notacme.com", "fallback.com"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.host, func(t *testing.T) {
+			assert.Equal(t, tc.want, cookieDomain(conf, tc.host))
+		})
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	serve := func(conf config.Identity, cookie string) (*httptest.ResponseRecorder, string) {
+		var identity string
+		r := gin.New()
+		r.GET("/i", Identity(conf), func(c *gin.Context) { identity = c.GetString(constants.IDENTITY) })
+		req := httptest.NewRequest(http.MethodGet, "http://t.acme.com/i", nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "nuid", Value: cookie})
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec, identity
+	}
+	conf := config.Identity{Cookie: config.IdentityCookie{
+		Enabled: true, Name: "nuid", TtlDays: 1, Path: "/", Domains: []string{"acme.com"}, SameSite: "None", HttpOnly: true,
+	}}
+
+	rec, identity := serve(conf, "")
+	cookies := rec.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, identity, cookies[0].Value)
+	assert.Equal(t, "acme.com", cookies[0].Domain)
+	assert.True(t, cookies[0].Secure)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.Equal(t, http.SameSiteNoneMode, cookies[0].SameSite)
+
+	conf.Cookie.Enabled = false
+	rec, identity = serve(conf, "existing")
+	assert.Empty(t, rec.Result().Cookies())
+	assert.Equal(t, "existing", identity)
+	_, identity = serve(conf, "")
+	assert.Equal(t, "", identity)
+}
+
+func TestCookielessIdentity(t *testing.T) {
+	day := time.Date(2023, 6, 1, 23, 0, 0, 0, time.UTC)
+	id := cookielessIdentity([]byte("salt"), day, "203.0.113.1", "browser")
+	assert.Equal(t, id, cookielessIdentity([]byte("salt"), day.Add(30*time.Minute).In(time.FixedZone("", 3600)), "203.0.113.1", "browser"), "identities should be stable within a utc day")
+	assert.NotEqual(t, id, cookielessIdentity([]byte("salt"), day.Add(2*time.Hour), "203.0.113.1", "browser"))
+	assert.NotEqual(t, id, cookielessIdentity([]byte("pepper"), day, "203.0.113.1", "browser"))
+	assert.NotEqual(t, id, cookielessIdentity([]byte("salt"), day, "203.0.113.2", "browser"))
+}
+
+func TestCookieless(t *testing.T) {
+	conf := config.Identity{
+		Cookie:     config.IdentityCookie{Enabled: true, Name: "nuid"},
+		Cookieless: config.Cookieless{Enabled: true, Salt: "salt"},
+	}
+	var identity string
+	var forwarded string
+	var contexts envelope.Contexts
+	r := gin.New()
+	r.GET("/i", Identity(conf), func(c *gin.Context) {
+		identity = c.GetString(constants.IDENTITY)
+		forwarded = c.GetHeader("X-Forwarded-For")
+		contexts = envelope.BuildContextsFromRequest(c)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/i", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	assert.Empty(t, rec.Result().Cookies())
+	assert.NotEqual(t, "", identity)
+	assert.Equal(t, "", forwarded)
+	// The client ip isn't stored in envelopes either
+	assert.NotContains(t, contexts, envelope.CLIENT_CONTEXT)
+	assert.Contains(t, contexts, envelope.HTTP_HEADERS_CONTEXT)
+}
@@ -7,72 +7,209 @@ package middleware
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
-	"strings"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
-	"github.com/silverton-io/buz/pkg/util"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/envelope"
 )
 
-type request struct {
-	ResponseCode             int           `json:"responseCode"`
-	RequestDuration          time.Duration `json:"requestDuration"`
-	RequestDurationForHumans string        `json:"requestDurationForHumans"`
-	ClientIp                 string        `json:"clientIp"`
-	RequestMethod            string        `json:"requestMethod"`
-	RequestUri               string        `json:"requestUri"`
-	Body                     interface{}   `json:"body"`
+// Access log fields
+const (
+	ACCESS_TIME           string = "time"
+	ACCESS_LATENCY        string = "latencyMs"
+	ACCESS_STATUS         string = "status"
+	ACCESS_METHOD         string = "method"
+	ACCESS_ROUTE          string = "route" // The matched route pattern, which doesn't vary with params
+	ACCESS_PATH           string = "path"
+	ACCESS_QUERY          string = "query"
+	ACCESS_CLIENT_IP      string = "clientIp"
+	ACCESS_USER_AGENT     string = "userAgent"
+	ACCESS_IDENTITY       string = "identity" // The api key, token, or client the request authenticated as
+	ACCESS_REQUEST_BYTES  string = "requestBytes"
+	ACCESS_RESPONSE_BYTES string = "responseBytes"
+	ACCESS_BODY           string = "body" // The json request body. Bodies are buffered to be logged, so this is expensive
+)
+
+const (
+	// The protocol of access log envelopes
+	ACCESS        string = "access"
+	ACCESS_SCHEMA string = "io.silverton/buz/internal/access/v1.0.json"
+)
+
+var DEFAULT_ACCESS_FIELDS = []string{
+	ACCESS_TIME, ACCESS_LATENCY, ACCESS_STATUS, ACCESS_METHOD, ACCESS_ROUTE, ACCESS_PATH,
+	ACCESS_CLIENT_IP, ACCESS_IDENTITY, ACCESS_REQUEST_BYTES, ACCESS_RESPONSE_BYTES,
+}
+
+var accessFields = map[string]bool{
+	ACCESS_TIME: true, ACCESS_LATENCY: true, ACCESS_STATUS: true, ACCESS_METHOD: true,
+	ACCESS_ROUTE: true, ACCESS_PATH: true, ACCESS_QUERY: true, ACCESS_CLIENT_IP: true,
+	ACCESS_USER_AGENT: true, ACCESS_IDENTITY: true, ACCESS_REQUEST_BYTES: true,
+	ACCESS_RESPONSE_BYTES: true, ACCESS_BODY: true,
 }
 
-func getIp(c *gin.Context) string {
-	ip := c.Request.Header.Get("X-Forwarded-For")
-	if len(ip) == 0 {
-		ip = c.Request.Header.Get("X-Real-IP")
+var requestLogging atomic.Bool
+
+// SetRequestLogging turns the request logger on or off, at runtime
+func SetRequestLogging(enabled bool) {
+	requestLogging.Store(enabled)
+}
+
+// RequestLogging returns whether the request logger is on
+func RequestLogging() bool {
+	return requestLogging.Load()
+}
+
+// accessLogger writes access log lines to its output, or emits them to a
+// sink
+type accessLogger struct {
+	fields     []string
+	body       bool
+	sampleRate float64
+	app        config.App
+	sink       backendutils.Sink
+	mu         sync.Mutex
+	out        io.Writer
+}
+
+func newAccessLogger(conf config.RequestLogger, app config.App, sinks []backendutils.Sink, out io.Writer) (*accessLogger, error) {
+	l := &accessLogger{fields: conf.Fields, sampleRate: conf.SampleRate, app: app, out: out}
+	if len(l.fields) == 0 {
+		l.fields = DEFAULT_ACCESS_FIELDS
 	}
-	if len(ip) == 0 {
-		ip = c.Request.RemoteAddr
+	for _, f := range l.fields {
+		if !accessFields[f] {
+			return nil, errors.New("unknown access log field: " + f)
+		}
+		if f == ACCESS_BODY {
+			l.body = true
+		}
 	}
-	if strings.Contains(ip, ",") {
-		ip = strings.Split(ip, ",")[0]
+	if l.sampleRate <= 0 || l.sampleRate > 1 {
+		l.sampleRate = 1
+	}
+	if conf.Sink != "" {
+		for _, s := range sinks {
+			if s.Metadata().Name == conf.Sink {
+				l.sink = s
+			}
+		}
+		if l.sink == nil {
+			return nil, errors.New("request logger sink not found: " + conf.Sink)
+		}
 	}
-	return ip
+	return l, nil
 }
 
-func RequestLogger() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		start := time.Now().UTC()
-		end := time.Now().UTC()
-		duration := util.GetDuration(start, end)
-		buf, _ := io.ReadAll(c.Request.Body)
-		r1 := io.NopCloser(bytes.NewBuffer(buf))
-		r2 := io.NopCloser(bytes.NewBuffer(buf))
-		reqBody, err := io.ReadAll(r1)
-		c.Request.Body = r2
-		c.Next()
-		if err != nil {
-			log.Error().Err(err).Msg("could not read request body")
+func (l *accessLogger) entry(c *gin.Context, start time.Time, latency time.Duration, body []byte) map[string]interface{} {
+	e := make(map[string]interface{}, len(l.fields))
+	for _, f := range l.fields {
+		switch f {
+		case ACCESS_TIME:
+			e[f] = start.UTC()
+		case ACCESS_LATENCY:
+			e[f] = float64(latency) / float64(time.Millisecond)
+		case ACCESS_STATUS:
+			e[f] = c.Writer.Status()
+		case ACCESS_METHOD:
+			e[f] = c.Request.Method
+		case ACCESS_ROUTE:
+			e[f] = c.FullPath()
+		case ACCESS_PATH:
+			e[f] = c.Request.URL.Path
+		case ACCESS_QUERY:
+			e[f] = c.Request.URL.RawQuery
+		case ACCESS_CLIENT_IP:
+			if !c.GetBool(constants.WITHHOLD_CLIENT_IP) {
+				e[f] = c.ClientIP()
+			}
+		case ACCESS_USER_AGENT:
+			e[f] = c.Request.UserAgent()
+		case ACCESS_IDENTITY:
+			e[f] = c.GetString(constants.AUTH_IDENTITY)
+		case ACCESS_REQUEST_BYTES:
+			e[f] = c.Request.ContentLength
+		case ACCESS_RESPONSE_BYTES:
+			e[f] = c.Writer.Size()
+		case ACCESS_BODY:
+			var b interface{}
+			if len(body) > 0 {
+				if err := json.Unmarshal(body, &b); err != nil {
+					log.Debug().Err(err).Msg("could not unmarshal request body")
+				}
+			}
+			e[f] = b
 		}
+	}
+	return e
+}
 
-		var b interface{}
-		if string(reqBody) != "" {
-			err = json.Unmarshal(reqBody, &b)
-
-			if err != nil {
-				log.Debug().Err(err).Interface("body", reqBody).Msg("could not unmarshal request body")
-			}
+func (l *accessLogger) write(e map[string]interface{}) {
+	if l.sink != nil {
+		env := envelope.NewEnvelope(l.app)
+		env.Protocol = ACCESS
+		env.Schema = ACCESS_SCHEMA
+		env.Vendor = "io.silverton"
+		env.Namespace = "buz.internal.access"
+		env.Version = "1.0"
+		env.IsValid = true
+		env.Payload = e
+		if err := l.sink.Enqueue([]envelope.Envelope{env}); err != nil {
+			log.Error().Err(err).Msg("🔴 could not emit access log")
+			return
 		}
+		backendutils.Stats().Enqueued(l.sink.Metadata().Name, 1)
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not marshal access log")
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(line, '\n'))
+}
+
+// RequestLogger writes an access log of json lines to stdout, or to a
+// sink, while request logging is on. Requests are sampled, but server
+// errors are always logged.
+func RequestLogger(conf config.RequestLogger, app config.App, sinks []backendutils.Sink) (gin.HandlerFunc, error) {
+	l, err := newAccessLogger(conf, app, sinks, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	return l.middleware(), nil
+}
 
-		r := request{
-			ResponseCode:             c.Writer.Status(),
-			RequestDuration:          duration,
-			RequestDurationForHumans: duration.String(),
-			ClientIp:                 getIp(c),
-			RequestMethod:            c.Request.Method,
-			RequestUri:               c.Request.RequestURI,
-			Body:                     b,
+func (l *accessLogger) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requestLogging.Load() {
+			c.Next()
+			return
+		}
+		var body []byte
+		if l.body && c.Request.Body != nil {
+			body, _ = io.ReadAll(c.Request.Body)
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+		}
+		start := time.Now()
+		c.Next()
+		latency := time.Since(start)
+		if c.Writer.Status() < http.StatusInternalServerError && l.sampleRate < 1 && rand.Float64() >= l.sampleRate {
+			return
 		}
-		log.Info().Interface("request", r).Msg("🟢")
+		l.write(l.entry(c, start, latency, body))
 	}
 }
@@ -0,0 +1,112 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+type accessSink struct {
+	envelopes []envelope.Envelope
+}
+
+func (s *accessSink) Metadata() backendutils.SinkMetadata {
+	return backendutils.SinkMetadata{Name: "access"}
+}
+func (s *accessSink) Initialize(conf config.Sink) error { return nil }
+func (s *accessSink) StartWorker() error                { return nil }
+func (s *accessSink) Enqueue(envelopes []envelope.Envelope) error {
+	s.envelopes = append(s.envelopes, envelopes...)
+	return nil
+}
+func (s *accessSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	return nil
+}
+func (s *accessSink) Shutdown() error { return nil }
+
+func accessLogEngine(l *accessLogger) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.Use(l.middleware())
+	e.POST("/c/:input", func(c *gin.Context) {
+		c.Set(constants.AUTH_IDENTITY, "key:web")
+		c.String(http.StatusOK, "ok")
+	})
+	e.GET("/boom", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+	return e
+}
+
+func TestRequestLogger(t *testing.T) {
+	defer SetRequestLogging(false)
+	SetRequestLogging(true)
+	var out bytes.Buffer
+	l, err := newAccessLogger(config.RequestLogger{}, config.App{}, nil, &out)
+	assert.Nil(t, err)
+	e := accessLogEngine(l)
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/c/webhook?x=1", strings.NewReader(`{"a": 1}`)))
+
+	var entry map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &entry))
+	for _, f := range DEFAULT_ACCESS_FIELDS {
+		assert.Contains(t, entry, f)
+	}
+	assert.Len(t, entry, len(DEFAULT_ACCESS_FIELDS))
+	assert.Equal(t, "/c/:input", entry[ACCESS_ROUTE])
+	assert.Equal(t, "/c/webhook", entry[ACCESS_PATH])
+	assert.Equal(t, "key:web", entry[ACCESS_IDENTITY])
+	assert.Equal(t, float64(8), entry[ACCESS_REQUEST_BYTES])
+	assert.Equal(t, float64(2), entry[ACCESS_RESPONSE_BYTES])
+
+	// Nothing is logged while request logging is off
+	out.Reset()
+	SetRequestLogging(false)
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/c/webhook", nil))
+	assert.Equal(t, 0, out.Len())
+}
+
+func TestRequestLoggerFieldsAndSampling(t *testing.T) {
+	defer SetRequestLogging(false)
+	SetRequestLogging(true)
+	sink := &accessSink{}
+	l, err := newAccessLogger(config.RequestLogger{
+		Fields:     []string{ACCESS_STATUS, ACCESS_QUERY, ACCESS_BODY},
+		SampleRate: 0.000001,
+		Sink:       "access",
+	}, config.App{}, []backendutils.Sink{sink}, nil)
+	assert.Nil(t, err)
+	e := accessLogEngine(l)
+	for i := 0; i < 10; i++ {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/c/webhook", nil))
+	}
+	// Server errors are always logged
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/boom?debug=1", nil))
+	assert.Len(t, sink.envelopes, 1)
+	assert.Equal(t, ACCESS_SCHEMA, sink.envelopes[0].Schema)
+	assert.Equal(t, envelope.Payload{ACCESS_STATUS: http.StatusInternalServerError, ACCESS_QUERY: "debug=1", ACCESS_BODY: nil}, sink.envelopes[0].Payload)
+
+	l.sampleRate = 1
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/c/webhook", strings.NewReader(`{"event": "click"}`)))
+	assert.Equal(t, map[string]interface{}{"event": "click"}, sink.envelopes[1].Payload[ACCESS_BODY])
+}
+
+func TestNewAccessLoggerErrors(t *testing.T) {
+	_, err := newAccessLogger(config.RequestLogger{Fields: []string{"password"}}, config.App{}, nil, nil)
+	assert.NotNil(t, err)
+	_, err = newAccessLogger(config.RequestLogger{Sink: "missing"}, config.App{}, nil, nil)
+	assert.NotNil(t, err)
+}
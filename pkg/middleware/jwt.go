@@ -0,0 +1,421 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const (
+	DEFAULT_JWKS_CACHE_SECONDS     int = 300
+	DEFAULT_JWT_CLOCK_SKEW_SECONDS int = 60
+	// Unknown key ids refetch the JWKS, at most this often
+	JWKS_MIN_REFRESH_SECONDS int = 30
+	// Failed JWKS fetches back off exponentially, up to this long
+	JWKS_MAX_BACKOFF_SECONDS    int = 300
+	DEFAULT_TOKEN_CACHE_SECONDS int = 60
+	DEFAULT_TOKEN_CACHE_ENTRIES int = 10000
+)
+
+var (
+	errJwtMalformed = errors.New("malformed jwt")
+	errJwtAlgorithm = errors.New("unsupported jwt algorithm")
+	errJwtKey       = errors.New("unknown jwt signing key")
+	errJwtSignature = errors.New("invalid jwt signature")
+	errJwtExpired   = errors.New("jwt is expired")
+	errJwtNotYet    = errors.New("jwt is not valid yet")
+	errJwtIssuer    = errors.New("unexpected jwt issuer")
+	errJwtAudience  = errors.New("unexpected jwt audience")
+)
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := decodeSegment(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey returns the rsa or ecdsa key of the jwk
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported jwk curve: " + k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("unsupported jwk type: " + k.Kty)
+}
+
+// jwks caches the signing keys published at the url. Fetches run outside
+// of the lock, one at a time, and back off while the JWKS is failing.
+type jwks struct {
+	url      string
+	ttl      time.Duration
+	client   *http.Client
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	fetched  time.Time
+	fetching chan struct{}
+	failures int
+	retryAt  time.Time
+}
+
+func (s *jwks) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch jwks: %s", resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Debug().Err(err).Str("kid", k.Kid).Msg("🟡 skipping jwk")
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+// refresh starts a fetch unless one is in flight, and returns a channel
+// which is closed once it is done. The lock must be held.
+func (s *jwks) refresh() chan struct{} {
+	if s.fetching != nil {
+		return s.fetching
+	}
+	done := make(chan struct{})
+	s.fetching = done
+	go func() {
+		defer close(done)
+		keys, err := s.fetch()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetching = nil
+		if err != nil {
+			// Keep using the cached keys until the JWKS is reachable again
+			s.failures++
+			backoff := jwksBackoff(s.failures)
+			s.retryAt = time.Now().Add(backoff)
+			log.Error().Err(err).Str("url", s.url).Dur("backoff", backoff).Msg("🔴 could not refresh jwks")
+			return
+		}
+		s.keys, s.fetched, s.failures, s.retryAt = keys, time.Now(), 0, time.Time{}
+	}()
+	return done
+}
+
+// jwksBackoff returns how long to wait after the consecutive failed fetches,
+// doubling from a second up to JWKS_MAX_BACKOFF_SECONDS
+func jwksBackoff(failures int) time.Duration {
+	d := float64(time.Second) * math.Pow(2, float64(failures-1))
+	if max := float64(time.Duration(JWKS_MAX_BACKOFF_SECONDS) * time.Second); d > max {
+		d = max
+	}
+	return time.Duration(d)
+}
+
+// key returns the signing key with the id. Keys are refetched once they
+// are stale, or when a key is unknown, since signing keys are rotated.
+// Known keys are returned while stale keys are refreshed, so only requests
+// signed by unknown keys wait on the JWKS.
+func (s *jwks) key(kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	age := time.Since(s.fetched)
+	key, ok := s.keys[kid]
+	stale := s.keys == nil || age > s.ttl || (!ok && age > time.Duration(JWKS_MIN_REFRESH_SECONDS)*time.Second)
+	var done chan struct{}
+	if stale && !time.Now().Before(s.retryAt) {
+		done = s.refresh()
+	}
+	s.mu.Unlock()
+	if !ok && done != nil {
+		<-done
+		s.mu.Lock()
+		key, ok = s.keys[kid]
+		s.mu.Unlock()
+	}
+	if !ok {
+		return nil, errJwtKey
+	}
+	return key, nil
+}
+
+type cachedToken struct {
+	claims  map[string]interface{}
+	expires time.Time
+}
+
+// tokenCache holds the claims of verified tokens by their hash, so tokens
+// themselves aren't kept in memory
+type tokenCache struct {
+	mu         sync.Mutex
+	tokens     map[[32]byte]cachedToken
+	ttl        time.Duration
+	maxEntries int
+}
+
+func newTokenCache(conf config.TokenCache) *tokenCache {
+	ttl := conf.TtlSeconds
+	if ttl <= 0 {
+		ttl = DEFAULT_TOKEN_CACHE_SECONDS
+	}
+	maxEntries := conf.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DEFAULT_TOKEN_CACHE_ENTRIES
+	}
+	return &tokenCache{
+		tokens:     make(map[[32]byte]cachedToken),
+		ttl:        time.Duration(ttl) * time.Second,
+		maxEntries: maxEntries,
+	}
+}
+
+func (c *tokenCache) get(token string, now time.Time) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := sha256.Sum256([]byte(token))
+	t, ok := c.tokens[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(t.expires) {
+		delete(c.tokens, key)
+		return nil, false
+	}
+	return t.claims, true
+}
+
+// put caches the claims until the ttl passes or the token expires,
+// whichever is first. Expired tokens are swept when the cache is full, and
+// arbitrary tokens are evicted if it still is.
+func (c *tokenCache) put(token string, claims map[string]interface{}, now time.Time, expires time.Time) {
+	if exp := now.Add(c.ttl); exp.Before(expires) {
+		expires = exp
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.tokens) >= c.maxEntries {
+		for k, t := range c.tokens {
+			if now.After(t.expires) {
+				delete(c.tokens, k)
+			}
+		}
+		for k := range c.tokens {
+			if len(c.tokens) < c.maxEntries {
+				break
+			}
+			delete(c.tokens, k)
+		}
+	}
+	c.tokens[sha256.Sum256([]byte(token))] = cachedToken{claims: claims, expires: expires}
+}
+
+// jwtVerifier validates the signature, timestamps, issuer, and audience
+// of jwts
+type jwtVerifier struct {
+	keys      *jwks
+	issuer    string
+	audiences []string
+	skew      time.Duration
+	cache     *tokenCache
+	now       func() time.Time
+}
+
+func newJwtVerifier(conf config.Jwt) *jwtVerifier {
+	ttl := conf.JwksCacheSeconds
+	if ttl <= 0 {
+		ttl = DEFAULT_JWKS_CACHE_SECONDS
+	}
+	skew := conf.ClockSkewSeconds
+	if skew <= 0 {
+		skew = DEFAULT_JWT_CLOCK_SKEW_SECONDS
+	}
+	var cache *tokenCache
+	if conf.Cache.Enabled {
+		cache = newTokenCache(conf.Cache)
+	}
+	return &jwtVerifier{
+		keys: &jwks{
+			url:    conf.JwksUrl,
+			ttl:    time.Duration(ttl) * time.Second,
+			client: &http.Client{Timeout: 5 * time.Second},
+		},
+		issuer:    conf.Issuer,
+		audiences: conf.Audiences,
+		skew:      time.Duration(skew) * time.Second,
+		cache:     cache,
+		now:       time.Now,
+	}
+}
+
+// looksLikeJwt returns true if the token has a jwt's three segments
+func looksLikeJwt(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// numericDate returns the time of a numeric date claim, if present
+func numericDate(claims map[string]interface{}, name string) (time.Time, bool) {
+	v, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+func (v *jwtVerifier) audienceAllowed(claims jwt.MapClaims) bool {
+	if len(v.audiences) == 0 {
+		return true
+	}
+	aud, _ := claims.GetAudience()
+	for _, a := range aud {
+		for _, allowed := range v.audiences {
+			if a == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// signingKey returns the jwks key the token is signed with. Only asymmetric
+// algorithms are accepted, so "none" and key confusion are rejected.
+func (v *jwtVerifier) signingKey(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+	default:
+		return nil, errJwtAlgorithm
+	}
+	kid, _ := token.Header["kid"].(string)
+	return v.keys.key(kid)
+}
+
+// verifyError maps the errors of the jwt parser to the reasons tokens are
+// rejected for. Tokens without a numeric expiry are rejected as expired.
+func verifyError(err error, claims jwt.MapClaims) error {
+	switch {
+	case errors.Is(err, errJwtKey):
+		return errJwtKey
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return errJwtMalformed
+	case errors.Is(err, errJwtAlgorithm), errors.Is(err, jwt.ErrTokenUnverifiable):
+		// Unverifiable tokens are signed with an unknown algorithm
+		return errJwtAlgorithm
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return errJwtSignature
+	}
+	if _, ok := numericDate(claims, "exp"); !ok || errors.Is(err, jwt.ErrTokenExpired) {
+		return errJwtExpired
+	}
+	if errors.Is(err, jwt.ErrTokenNotValidYet) {
+		return errJwtNotYet
+	}
+	return errJwtMalformed
+}
+
+// verify returns the claims of the token, if it is valid. Cached tokens
+// skip verification, including of their signing key, until they leave the
+// cache.
+func (v *jwtVerifier) verify(token string) (map[string]interface{}, error) {
+	now := v.now()
+	if v.cache != nil {
+		if claims, ok := v.cache.get(token, now); ok {
+			return claims, nil
+		}
+	}
+	parser := jwt.NewParser(
+		jwt.WithLeeway(v.skew),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+	)
+	claims := jwt.MapClaims{}
+	if _, err := parser.ParseWithClaims(token, claims, v.signingKey); err != nil {
+		return nil, verifyError(err, claims)
+	}
+	if iss, _ := claims.GetIssuer(); v.issuer != "" && iss != v.issuer {
+		return nil, errJwtIssuer
+	}
+	if !v.audienceAllowed(claims) {
+		return nil, errJwtAudience
+	}
+	if v.cache != nil {
+		exp, _ := numericDate(claims, "exp")
+		v.cache.put(token, claims, now, exp.Add(v.skew))
+	}
+	return claims, nil
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signJwt(t *testing.T, alg string, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest.Sum(nil))
+		assert.Nil(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest.Sum(nil))
+		assert.Nil(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + b64(sig)
+}
+
+// testIdp serves a JWKS, and the oidc discovery and token endpoints. The
+// token endpoint issues the id token set by the test.
+type testIdp struct {
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	fetches int
+	idToken func(code string) string
+	server  *httptest.Server
+}
+
+func newTestIdp(t *testing.T) *testIdp {
+	idp := &testIdp{}
+	idp.rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	idp.ecKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	idp.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(oidcDiscovery{
+				Issuer:                idp.server.URL,
+				AuthorizationEndpoint: idp.server.URL + "/authorize",
+				TokenEndpoint:         idp.server.URL + "/token",
+				JwksUri:               idp.server.URL + "/jwks",
+			})
+			return
+		case "/token":
+			r.ParseForm()
+			json.NewEncoder(w).Encode(map[string]string{"id_token": idp.idToken(r.PostForm.Get("code"))})
+			return
+		}
+		idp.fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kid": "rsa", "kty": "RSA", "use": "sig", "n": b64(idp.rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(idp.rsaKey.E)).Bytes())},
+			{"kid": "ec", "kty": "EC", "crv": "P-256", "x": b64(idp.ecKey.X.Bytes()), "y": b64(idp.ecKey.Y.Bytes())},
+		}})
+	}))
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func TestJwtVerifier(t *testing.T) {
+	idp := newTestIdp(t)
+	v := newJwtVerifier(config.Jwt{JwksUrl: idp.server.URL, Issuer: "https://idp", Audiences: []string{"buz"}, ClockSkewSeconds: 30})
+	now := time.Now()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"iss": "https://idp", "aud": []string{"other", "buz"}, "sub": "svc", "exp": now.Add(time.Minute).Unix()}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+	var testCases = []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"rsa", signJwt(t, "RS256", "rsa", idp.rsaKey, claims(nil)), nil},
+		{"ecdsa", signJwt(t, "ES256", "ec", idp.ecKey, claims(nil)), nil},
+		{"expired within skew", signJwt(t, "RS256", "rsa", idp.rsaKey, claims(map[string]interface{}{"exp": now.Add(-10 * time.Second).Unix()})), nil},
+		{"expired", signJwt(t, "RS256", "rsa", idp.rsaKey, claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})), errJwtExpired},
+		{"no expiry", signJwt(t, "RS256", "rsa", idp.rsaKey, claims(map[string]interface{}{"exp": nil})), errJwtExpired},
+		{"not yet", signJwt(t, "RS256", "rsa", idp.rsaKey, claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})), errJwtNotYet},
+		{"issuer", signJwt(t, "RS256", "rsa", idp.rsaKey, claims(map[string]interface{}{"iss": "https://evil"})), errJwtIssuer},
+		{"audience", signJwt(t, "RS256", "rsa", idp.rsaKey, claims(map[string]interface{}{"aud": "other"})), errJwtAudience},
+		{"wrong key", signJwt(t, "RS256", "rsa", mustRsaKey(t), claims(nil)), errJwtSignature},
+		{"unknown key", signJwt(t, "RS256", "rotated", idp.rsaKey, claims(nil)), errJwtKey},
+		{"none", b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{}`)) + ".", errJwtAlgorithm},
+		{"hmac", b64([]byte(`{"alg":"HS256","kid":"rsa"}`)) + "." + b64([]byte(`{}`)) + ".c2ln", errJwtAlgorithm},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := v.verify(tc.token)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+	// Keys are cached, and unknown keys don't refetch more than once
+	assert.Equal(t, 1, idp.fetches)
+}
+
+func TestJwtTokenCache(t *testing.T) {
+	idp := newTestIdp(t)
+	v := newJwtVerifier(config.Jwt{JwksUrl: idp.server.URL, Cache: config.TokenCache{Enabled: true, TtlSeconds: 60, MaxEntries: 2}})
+	now := time.Now()
+	v.now = func() time.Time { return now }
+	token := signJwt(t, "RS256", "rsa", idp.rsaKey, map[string]interface{}{"sub": "svc", "exp": now.Add(time.Hour).Unix()})
+	_, err := v.verify(token)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, idp.fetches)
+
+	// Cached tokens don't need the signing keys
+	v.keys.keys = nil
+	claims, err := v.verify(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "svc", claims["sub"])
+	assert.Equal(t, 1, idp.fetches)
+
+	// Tokens are verified again once the ttl passes
+	now = now.Add(61 * time.Second)
+	_, err = v.verify(token)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, idp.fetches)
+
+	// Or once they expire, with skew
+	short := signJwt(t, "RS256", "rsa", idp.rsaKey, map[string]interface{}{"exp": now.Add(time.Second).Unix()})
+	_, err = v.verify(short)
+	assert.Nil(t, err)
+	now = now.Add(time.Duration(DEFAULT_JWT_CLOCK_SKEW_SECONDS+2) * time.Second)
+	_, err = v.verify(short)
+	assert.Equal(t, errJwtExpired, err)
+
+	// Invalid tokens aren't cached, and the cache is bounded
+	_, err = v.verify(signJwt(t, "RS256", "rsa", mustRsaKey(t), map[string]interface{}{"exp": now.Add(time.Hour).Unix()}))
+	assert.Equal(t, errJwtSignature, err)
+	for i := 0; i < 3; i++ {
+		_, err = v.verify(signJwt(t, "RS256", "rsa", idp.rsaKey, map[string]interface{}{"jti": i, "exp": now.Add(time.Hour).Unix()}))
+		assert.Nil(t, err)
+	}
+	assert.Len(t, v.cache.tokens, 2)
+}
+
+func TestJwksBackoff(t *testing.T) {
+	idp := newTestIdp(t)
+	var fetches int
+	failing, release := true, make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		<-release
+		http.Redirect(w, r, idp.server.URL+"/jwks", http.StatusFound)
+	}))
+	t.Cleanup(server.Close)
+	v := newJwtVerifier(config.Jwt{JwksUrl: server.URL})
+	token := signJwt(t, "RS256", "rsa", idp.rsaKey, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})
+
+	// Failed fetches back off, instead of being retried by every request
+	_, err := v.verify(token)
+	assert.Equal(t, errJwtKey, err)
+	_, err = v.verify(token)
+	assert.Equal(t, errJwtKey, err)
+	assert.Equal(t, 1, fetches)
+	assert.Equal(t, time.Second, jwksBackoff(1))
+	assert.Equal(t, time.Duration(JWKS_MAX_BACKOFF_SECONDS)*time.Second, jwksBackoff(100))
+
+	failing, v.keys.retryAt = false, time.Time{}
+	close(release)
+	_, err = v.verify(token)
+	assert.Nil(t, err)
+
+	// Known keys are used while a slow JWKS is refreshed
+	release = make(chan struct{})
+	v.keys.fetched = time.Now().Add(-2 * v.keys.ttl)
+	_, err = v.verify(token)
+	assert.Nil(t, err)
+	close(release)
+}
+
+func mustRsaKey(t *testing.T) *rsa.PrivateKey {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	return k
+}
+
+func TestAuthJwt(t *testing.T) {
+	idp := newTestIdp(t)
+	conf := config.Auth{Enabled: true, Tokens: []string{"static"}, Jwt: config.Jwt{Enabled: true, JwksUrl: idp.server.URL}}
+	token := signJwt(t, "ES256", "ec", idp.ecKey, map[string]interface{}{"sub": "svc", "tenant": "acme", "exp": time.Now().Add(time.Minute).Unix()})
+	var testCases = []struct {
+		name         string
+		auth         string
+		wantCode     int
+		wantIdentity string
+		wantTenant   string
+	}{
+		{"static token", "Bearer static", http.StatusOK, identify(BEARER, "static"), ""},
+		{"jwt", "Bearer " + token, http.StatusOK, "jwt:svc", "acme"},
+		{"tampered jwt", "Bearer " + token + "x", http.StatusUnauthorized, "", ""},
+		{"basic jwt", "Basic " + token, http.StatusUnauthorized, "", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			var identity, tenant string
+			r := gin.New()
+			auth, err := Auth(conf)
+			assert.Nil(t, err)
+			r.Use(auth, Tenancy(config.Tenancy{Enabled: true, Claim: "tenant", DefaultTenant: "shared", Tenants: []config.Tenant{{Name: "acme"}}}))
+			r.GET("/", func(c *gin.Context) {
+				identity = c.GetString(constants.AUTH_IDENTITY)
+				tenant = c.GetString(constants.TENANT)
+			})
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", tc.auth)
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, tc.wantCode, rec.Code)
+			assert.Equal(t, tc.wantIdentity, identity)
+			if tc.wantTenant != "" {
+				assert.Equal(t, tc.wantTenant, tenant)
+			}
+		})
+	}
+}
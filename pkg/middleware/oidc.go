@@ -0,0 +1,257 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/response"
+)
+
+const (
+	OIDC_LOGIN_ROUTE    = "/c/oidc/login"
+	OIDC_CALLBACK_ROUTE = "/c/oidc/callback"
+	OIDC_LOGOUT_ROUTE   = "/c/oidc/logout"
+	OIDC_RETURN_PARAM   = "return"
+	OIDC_SESSION_COOKIE = "buz_oidc_session"
+	OIDC_STATE_COOKIE   = "buz_oidc_state"
+	// How long operators have to log in with the provider
+	OIDC_STATE_TTL_SECONDS int = 600
+)
+
+var DEFAULT_OIDC_SCOPES = []string{"openid", "email", "profile"}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// Oidc logs operators in with the authorization code flow. The id token
+// of the provider is kept as the session cookie, so sessions last as long
+// as the id token, and the ops routes accept it as a bearer token too.
+type Oidc struct {
+	conf      config.Oidc
+	discovery oidcDiscovery
+	verifier  *jwtVerifier
+	client    *http.Client
+	secure    bool
+}
+
+func NewOidc(conf config.Oidc) (*Oidc, error) {
+	o := &Oidc{
+		conf:   conf,
+		client: &http.Client{Timeout: 10 * time.Second},
+		secure: strings.HasPrefix(conf.RedirectUrl, "https://"),
+	}
+	resp, err := o.client.Get(strings.TrimSuffix(conf.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not discover oidc provider: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&o.discovery); err != nil {
+		return nil, err
+	}
+	if o.discovery.Issuer != conf.Issuer {
+		return nil, errors.New("oidc provider issuer does not match: " + o.discovery.Issuer)
+	}
+	o.verifier = newJwtVerifier(config.Jwt{
+		JwksUrl:   o.discovery.JwksUri,
+		Issuer:    o.discovery.Issuer,
+		Audiences: []string{conf.ClientId},
+	})
+	return o, nil
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// returnPath only allows local paths, so the login can't redirect
+// operators elsewhere
+func returnPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return constants.STATS_PATH
+	}
+	return p
+}
+
+// allowed returns true if the operator's verified email is in an allowed
+// domain. Providers which don't assert the email is verified are rejected.
+func (o *Oidc) allowed(claims map[string]interface{}) bool {
+	if len(o.conf.AllowedDomains) == 0 {
+		return true
+	}
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return false
+	}
+	email, _ := claims["email"].(string)
+	_, domain, found := strings.Cut(email, "@")
+	if !found {
+		return false
+	}
+	for _, d := range o.conf.AllowedDomains {
+		if strings.EqualFold(domain, d) {
+			return true
+		}
+	}
+	return false
+}
+
+func operatorIdentity(claims map[string]interface{}) string {
+	if email, _ := claims["email"].(string); email != "" {
+		return "oidc:" + email
+	}
+	sub, _ := claims["sub"].(string)
+	return "oidc:" + sub
+}
+
+// LoginHandler redirects operators to the provider
+func (o *Oidc) LoginHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state, err := randomState()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, response.OidcLoginFailed)
+			return
+		}
+		scopes := o.conf.Scopes
+		if len(scopes) == 0 {
+			scopes = DEFAULT_OIDC_SCOPES
+		}
+		params := url.Values{
+			"response_type": {"code"},
+			"client_id":     {o.conf.ClientId},
+			"redirect_uri":  {o.conf.RedirectUrl},
+			"scope":         {strings.Join(scopes, " ")},
+			"state":         {state},
+			"nonce":         {state},
+		}
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(OIDC_STATE_COOKIE, state+"|"+returnPath(c.Query(OIDC_RETURN_PARAM)), OIDC_STATE_TTL_SECONDS, "/", "", o.secure, true)
+		c.Redirect(http.StatusFound, o.discovery.AuthorizationEndpoint+"?"+params.Encode())
+	}
+}
+
+// exchange trades the authorization code for the operator's id token
+func (o *Oidc) exchange(code string) (string, error) {
+	resp, err := o.client.PostForm(o.discovery.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.conf.RedirectUrl},
+		"client_id":     {o.conf.ClientId},
+		"client_secret": {o.conf.ClientSecret},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not exchange oidc code: %s", resp.Status)
+	}
+	var tokens struct {
+		IdToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return "", err
+	}
+	if tokens.IdToken == "" {
+		return "", errors.New("oidc provider returned no id token")
+	}
+	return tokens.IdToken, nil
+}
+
+// CallbackHandler completes the login, starting the operator's session
+func (o *Oidc) CallbackHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cookie, _ := c.Cookie(OIDC_STATE_COOKIE)
+		state, returnTo, _ := strings.Cut(cookie, "|")
+		c.SetCookie(OIDC_STATE_COOKIE, "", -1, "/", "", o.secure, true)
+		if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+			c.JSON(http.StatusUnauthorized, response.OidcLoginFailed)
+			return
+		}
+		idToken, err := o.exchange(c.Query("code"))
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not complete oidc login")
+			c.JSON(http.StatusUnauthorized, response.OidcLoginFailed)
+			return
+		}
+		claims, err := o.verifier.verify(idToken)
+		if nonce, _ := claims["nonce"].(string); err != nil || nonce != state {
+			log.Error().Err(err).Msg("🔴 invalid oidc id token")
+			c.JSON(http.StatusUnauthorized, response.OidcLoginFailed)
+			return
+		}
+		if !o.allowed(claims) {
+			c.JSON(http.StatusForbidden, response.OidcOperatorForbidden)
+			return
+		}
+		exp, _ := numericDate(claims, "exp")
+		log.Info().Str("operator", operatorIdentity(claims)).Msg("🟢 operator logged in")
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(OIDC_SESSION_COOKIE, idToken, int(time.Until(exp).Seconds()), "/", "", o.secure, true)
+		c.Redirect(http.StatusFound, returnPath(returnTo))
+	}
+}
+
+// LogoutHandler ends the operator's session
+func (o *Oidc) LogoutHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.SetCookie(OIDC_SESSION_COOKIE, "", -1, "/", "", o.secure, true)
+		c.JSON(http.StatusOK, response.Ok)
+	}
+}
+
+// Middleware requires an id token of an allowed operator, from the
+// session cookie or as a bearer token
+func (o *Oidc) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, _ := c.Cookie(OIDC_SESSION_COOKIE)
+		if scheme, bearer, ok := strings.Cut(c.GetHeader("Authorization"), " "); ok && scheme == BEARER {
+			token = bearer
+		}
+		if token == "" {
+			c.JSON(http.StatusUnauthorized, response.OidcLoginRequired)
+			c.Abort()
+			return
+		}
+		claims, err := o.verifier.verify(token)
+		if err != nil {
+			log.Debug().Err(err).Msg("🟡 invalid oidc id token")
+			c.JSON(http.StatusUnauthorized, response.OidcLoginRequired)
+			c.Abort()
+			return
+		}
+		if !o.allowed(claims) {
+			c.JSON(http.StatusForbidden, response.OidcOperatorForbidden)
+			c.Abort()
+			return
+		}
+		c.Set(constants.AUTH_IDENTITY, operatorIdentity(claims))
+		c.Set(constants.AUTH_CLAIMS, claims)
+		c.Next()
+	}
+}
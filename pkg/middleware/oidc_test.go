@@ -0,0 +1,91 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOidcLogin(t *testing.T) {
+	idp := newTestIdp(t)
+	var nonce string
+	idp.idToken = func(code string) string {
+		assert.Equal(t, "the-code", code)
+		return signJwt(t, "RS256", "rsa", idp.rsaKey, map[string]interface{}{
+			"iss": idp.server.URL, "aud": "buz", "sub": "1", "email": "ops@acme.com", "email_verified": true, "nonce": nonce,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+	}
+	o, err := NewOidc(config.Oidc{Enabled: true, Issuer: idp.server.URL, ClientId: "buz", RedirectUrl: "https://buz/c/oidc/callback", AllowedDomains: []string{"acme.com"}})
+	assert.Nil(t, err)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET(OIDC_LOGIN_ROUTE, o.LoginHandler())
+	r.GET(OIDC_CALLBACK_ROUTE, o.CallbackHandler())
+	var identity string
+	r.GET(constants.STATS_PATH, o.Middleware(), func(c *gin.Context) {
+		identity = c.GetString(constants.AUTH_IDENTITY)
+	})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, OIDC_LOGIN_ROUTE+"?return=//evil.com", nil))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	redirect, _ := url.Parse(rec.Header().Get("Location"))
+	assert.Equal(t, "/authorize", redirect.Path)
+	state := redirect.Query().Get("state")
+	nonce = redirect.Query().Get("nonce")
+	stateCookie := rec.Result().Cookies()[0]
+
+	// The state must match the cookie
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, OIDC_CALLBACK_ROUTE+"?code=the-code&state=forged", nil)
+	req.AddCookie(stateCookie)
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, OIDC_CALLBACK_ROUTE+"?code=the-code&state="+state, nil)
+	req.AddCookie(stateCookie)
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, constants.STATS_PATH, rec.Header().Get("Location"))
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == OIDC_SESSION_COOKIE {
+			session = c
+		}
+	}
+	assert.True(t, session.HttpOnly && session.Secure)
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, constants.STATS_PATH, nil)
+	req.AddCookie(session)
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "oidc:ops@acme.com", identity)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, constants.STATS_PATH, nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestOidcAllowedDomains(t *testing.T) {
+	o := &Oidc{conf: config.Oidc{AllowedDomains: []string{"acme.com"}}}
+	assert.True(t, o.allowed(map[string]interface{}{"email": "ops@ACME.com", "email_verified": true}))
+	assert.False(t, o.allowed(map[string]interface{}{"email": "ops@acme.com", "email_verified": false}))
+	assert.False(t, o.allowed(map[string]interface{}{"email": "ops@acme.com"}))
+	assert.False(t, o.allowed(map[string]interface{}{"email": "ops@acme.com", "email_verified": "true"}))
+	assert.False(t, o.allowed(map[string]interface{}{"email": "ops@evil.com", "email_verified": true}))
+	assert.False(t, o.allowed(map[string]interface{}{"sub": "1"}))
+}
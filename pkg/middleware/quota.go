@@ -0,0 +1,348 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/response"
+)
+
+// What happens to the events of an api key or tenant over its quota
+const (
+	REJECT string = "reject" // Reject requests with a 429 until the period ends
+	SAMPLE string = "sample" // Keep a sample of the events until the period ends
+)
+
+const (
+	DEFAULT_QUOTA_SAMPLE_RATE float64 = 0.1
+	QUOTA_KEY_PREFIX          string  = "buz:quota:"
+)
+
+// Quota periods
+const (
+	DAY   string = "day"
+	MONTH string = "month"
+)
+
+// Quota usage, by api key or tenant
+const (
+	QUOTA_DAILY_LIMIT   string = "dailyLimit"
+	QUOTA_MONTHLY_LIMIT string = "monthlyLimit"
+	QUOTA_REJECTED      string = "rejected"   // Requests rejected
+	QUOTA_SAMPLED_OUT   string = "sampledOut" // Events dropped by sampling
+)
+
+// quotaUsage holds the last known usage of each api key or tenant. Usage
+// is tracked by the store, and updated here as events are counted.
+type quotaUsage struct {
+	mu    sync.Mutex
+	usage map[string]map[string]int64
+}
+
+func (u *quotaUsage) update(subject string, fn func(map[string]int64)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.usage[subject] == nil {
+		u.usage[subject] = make(map[string]int64)
+	}
+	fn(u.usage[subject])
+}
+
+var usage = &quotaUsage{usage: make(map[string]map[string]int64)}
+
+// QuotaUsage returns the events each api key or tenant sent this day and
+// month, their quotas, and the requests or events dropped for exceeding them
+func QuotaUsage() map[string]map[string]int64 {
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	snapshot := make(map[string]map[string]int64, len(usage.usage))
+	for subject, u := range usage.usage {
+		snapshot[subject] = make(map[string]int64, len(u))
+		for k, v := range u {
+			snapshot[subject][k] = v
+		}
+	}
+	return snapshot
+}
+
+// quotaStore counts the events of each api key or tenant per period
+type quotaStore interface {
+	get(ctx context.Context, key string) (int64, error)
+	add(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+}
+
+type memoryQuotaStore struct {
+	mu     sync.Mutex
+	counts map[string]int64
+	expiry map[string]time.Time
+	now    func() time.Time
+}
+
+func newMemoryQuotaStore() *memoryQuotaStore {
+	return &memoryQuotaStore{counts: make(map[string]int64), expiry: make(map[string]time.Time), now: time.Now}
+}
+
+func (s *memoryQuotaStore) get(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.now().After(s.expiry[key]) {
+		return 0, nil
+	}
+	return s.counts[key], nil
+}
+
+func (s *memoryQuotaStore) add(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if exp, ok := s.expiry[key]; !ok || now.After(exp) {
+		// Counts of past periods are swept when a period starts
+		for k, exp := range s.expiry {
+			if now.After(exp) {
+				delete(s.counts, k)
+				delete(s.expiry, k)
+			}
+		}
+		s.expiry[key] = now.Add(ttl)
+	}
+	s.counts[key] += n
+	return s.counts[key], nil
+}
+
+// redisQuotaStore shares usage across instances
+type redisQuotaStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func (s *redisQuotaStore) get(ctx context.Context, key string) (int64, error) {
+	n, err := s.client.Get(ctx, s.prefix+QUOTA_KEY_PREFIX+key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (s *redisQuotaStore) add(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	// The rate limiter's script starts the expiry of the period with its first count
+	result, err := incrementScript.Run(ctx, s.client, []string{s.prefix + QUOTA_KEY_PREFIX + key}, n, ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, err
+	}
+	return result[0], nil
+}
+
+type quotaLimits struct {
+	daily   int64
+	monthly int64
+}
+
+// Quotas counts the events of each api key or tenant against its daily
+// and monthly quotas. Requests over a quota are rejected, or have their
+// events sampled, until the period ends. Usage is checked before each
+// request and counted once its envelopes are built, so the request which
+// crosses a quota is collected in full.
+type Quotas struct {
+	key        string
+	defaults   quotaLimits
+	overrides  map[string]quotaLimits
+	onExceed   string
+	sampleRate float64
+	store      quotaStore
+	now        func() time.Time
+}
+
+func NewQuotas(conf config.Quotas) (*Quotas, error) {
+	q := &Quotas{
+		key:        conf.Key,
+		defaults:   quotaLimits{daily: conf.Daily, monthly: conf.Monthly},
+		overrides:  make(map[string]quotaLimits),
+		onExceed:   conf.OnExceed,
+		sampleRate: conf.SampleRate,
+		now:        time.Now,
+	}
+	switch q.key {
+	case API_KEY, TENANT:
+	case "":
+		q.key = API_KEY
+	default:
+		return nil, errors.New("unsupported quota key: " + conf.Key)
+	}
+	switch q.onExceed {
+	case REJECT, SAMPLE:
+	case "":
+		q.onExceed = REJECT
+	default:
+		return nil, errors.New("unsupported quota action: " + conf.OnExceed)
+	}
+	if q.sampleRate < 0 || q.sampleRate > 1 {
+		return nil, errors.New("quota sample rate is not between 0 and 1")
+	}
+	if q.sampleRate == 0 {
+		q.sampleRate = DEFAULT_QUOTA_SAMPLE_RATE
+	}
+	for _, o := range conf.Overrides {
+		q.overrides[o.Name] = quotaLimits{daily: o.Daily, monthly: o.Monthly}
+	}
+	switch conf.Store {
+	case MEMORY, "":
+		q.store = newMemoryQuotaStore()
+	case REDIS:
+		client := newRedisClient(conf.Redis)
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			client.Close()
+			return nil, err
+		}
+		q.store = &redisQuotaStore{client: client, prefix: conf.Redis.Prefix}
+	default:
+		return nil, errors.New("unsupported quota store: " + conf.Store)
+	}
+	return q, nil
+}
+
+// subject returns the api key or tenant of the request, which is empty if
+// the request doesn't have one
+func (q *Quotas) subject(c *gin.Context) string {
+	if q.key == TENANT {
+		return c.GetString(constants.TENANT)
+	}
+	if k, ok := c.Value(constants.AUTH_API_KEY).(*apiKey); ok {
+		return k.name
+	}
+	return ""
+}
+
+func (q *Quotas) limits(subject string) quotaLimits {
+	if l, ok := q.overrides[subject]; ok {
+		return l
+	}
+	return q.defaults
+}
+
+// period returns the store key of the subject's period containing now,
+// and when the period ends. Periods are in UTC.
+func (q *Quotas) period(subject string, name string, now time.Time) (string, time.Time) {
+	now = now.UTC()
+	if name == DAY {
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		return q.key + ":" + subject + ":" + start.Format("2006-01-02"), start.AddDate(0, 0, 1)
+	}
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return q.key + ":" + subject + ":" + start.Format("2006-01"), start.AddDate(0, 1, 0)
+}
+
+// exceeded returns true if the subject is over one of its quotas, and when
+// the period of that quota ends
+func (q *Quotas) exceeded(ctx context.Context, subject string, limits quotaLimits) (bool, time.Time, error) {
+	now := q.now()
+	for _, p := range []struct {
+		name  string
+		limit int64
+	}{{DAY, limits.daily}, {MONTH, limits.monthly}} {
+		if p.limit <= 0 {
+			continue
+		}
+		key, end := q.period(subject, p.name, now)
+		used, err := q.store.get(ctx, key)
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		if used >= p.limit {
+			return true, end, nil
+		}
+	}
+	return false, time.Time{}, nil
+}
+
+// requestQuota counts the envelopes of a request
+type requestQuota struct {
+	quotas  *Quotas
+	ctx     context.Context
+	subject string
+	limits  quotaLimits
+	sample  bool
+}
+
+func (r *requestQuota) Count(envelopes []envelope.Envelope) []envelope.Envelope {
+	if r.sample {
+		var kept []envelope.Envelope
+		for _, e := range envelopes {
+			h := fnv.New64a()
+			h.Write([]byte(e.Uuid.String()))
+			if float64(h.Sum64()) < r.quotas.sampleRate*math.MaxUint64 {
+				kept = append(kept, e)
+			}
+		}
+		dropped := int64(len(envelopes) - len(kept))
+		usage.update(r.subject, func(u map[string]int64) { u[QUOTA_SAMPLED_OUT] += dropped })
+		envelopes = kept
+	}
+	if len(envelopes) == 0 {
+		return envelopes
+	}
+	now := r.quotas.now()
+	for _, p := range []struct {
+		name  string
+		limit int64
+	}{{DAY, r.limits.daily}, {MONTH, r.limits.monthly}} {
+		if p.limit <= 0 {
+			continue
+		}
+		key, end := r.quotas.period(r.subject, p.name, now)
+		used, err := r.quotas.store.add(r.ctx, key, int64(len(envelopes)), end.Sub(now))
+		if err != nil {
+			log.Error().Err(err).Str("subject", r.subject).Msg("🔴 could not count quota usage")
+			continue
+		}
+		usage.update(r.subject, func(u map[string]int64) { u[p.name] = used })
+	}
+	return envelopes
+}
+
+// Middleware enforces the quota of the request's api key or tenant, which
+// are resolved by earlier middleware. Requests are let through if the
+// store fails, rather than failing collection.
+func (q *Quotas) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subject := q.subject(c)
+		limits := q.limits(subject)
+		if subject == "" || (limits.daily <= 0 && limits.monthly <= 0) {
+			c.Next()
+			return
+		}
+		usage.update(subject, func(u map[string]int64) {
+			u[QUOTA_DAILY_LIMIT] = limits.daily
+			u[QUOTA_MONTHLY_LIMIT] = limits.monthly
+		})
+		exceeded, end, err := q.exceeded(c, subject, limits)
+		if err != nil {
+			log.Error().Err(err).Str("subject", subject).Msg("🔴 could not check quota")
+			c.Next()
+			return
+		}
+		if exceeded && q.onExceed == REJECT {
+			usage.update(subject, func(u map[string]int64) { u[QUOTA_REJECTED]++ })
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(end.Sub(q.now()).Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, response.QuotaExceeded)
+			return
+		}
+		c.Set(constants.QUOTA, &requestQuota{quotas: q, ctx: c, subject: subject, limits: limits, sample: exceeded})
+		c.Next()
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+// quotaRouter serves a route which collects n envelopes per request, with
+// api keys named after their tokens
+func quotaRouter(t *testing.T, quotas *Quotas, n int, collected *int) *gin.Engine {
+	auth, err := Auth(config.Auth{ApiKeys: []config.ApiKey{{Name: "small", Key: "small"}, {Name: "large", Key: "large"}}})
+	assert.Nil(t, err)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(auth, quotas.Middleware())
+	r.POST("/", func(c *gin.Context) {
+		envelopes := make([]envelope.Envelope, n)
+		for i := range envelopes {
+			envelopes[i] = envelope.NewEnvelope(config.App{})
+		}
+		*collected += len(envelope.ApplyQuota(c, envelopes))
+	})
+	return r
+}
+
+func sendWithKey(r *gin.Engine, key string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestQuotasReject(t *testing.T) {
+	now := time.Date(2023, 5, 31, 23, 0, 0, 0, time.UTC)
+	quotas, err := NewQuotas(config.Quotas{Enabled: true, Daily: 5, Monthly: 100, Overrides: []config.QuotaOverride{{Name: "large", Daily: 100}}})
+	assert.Nil(t, err)
+	quotas.now = func() time.Time { return now }
+	quotas.store.(*memoryQuotaStore).now = quotas.now
+	var collected int
+	r := quotaRouter(t, quotas, 3, &collected)
+
+	// The request which crosses the quota is collected in full
+	assert.Equal(t, http.StatusOK, sendWithKey(r, "small").Code)
+	assert.Equal(t, http.StatusOK, sendWithKey(r, "small").Code)
+	rec := sendWithKey(r, "small")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "3600", rec.Header().Get("Retry-After"))
+	assert.Equal(t, 6, collected)
+	assert.Equal(t, http.StatusOK, sendWithKey(r, "large").Code)
+
+	u := QuotaUsage()["small"]
+	assert.Equal(t, int64(6), u[DAY])
+	assert.Equal(t, int64(6), u[MONTH])
+	assert.Equal(t, int64(5), u[QUOTA_DAILY_LIMIT])
+	assert.Equal(t, int64(1), u[QUOTA_REJECTED])
+
+	// Quotas reset with the day
+	now = now.Add(time.Hour)
+	assert.Equal(t, http.StatusOK, sendWithKey(r, "small").Code)
+}
+
+func TestQuotasSample(t *testing.T) {
+	quotas, err := NewQuotas(config.Quotas{Enabled: true, Monthly: 10, OnExceed: SAMPLE, SampleRate: 0.5})
+	assert.Nil(t, err)
+	var collected int
+	r := quotaRouter(t, quotas, 10, &collected)
+	assert.Equal(t, http.StatusOK, sendWithKey(r, "large").Code)
+	assert.Equal(t, 10, collected)
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, http.StatusOK, sendWithKey(r, "large").Code)
+	}
+	// Around half of the 200 events over quota are kept
+	assert.InDelta(t, 110, collected, 30)
+	assert.Equal(t, int64(collected), QuotaUsage()["large"][MONTH])
+}
+
+func TestRedisQuotasAreShared(t *testing.T) {
+	mr := miniredis.RunT(t)
+	conf := config.Quotas{Enabled: true, Daily: 3, Store: REDIS, Redis: config.Redis{Addr: mr.Addr()}}
+	var codes []int
+	var collected int
+	// Each instance has its own middleware, sharing only redis
+	for i := 0; i < 2; i++ {
+		quotas, err := NewQuotas(conf)
+		assert.Nil(t, err)
+		codes = append(codes, sendWithKey(quotaRouter(t, quotas, 3, &collected), "small").Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests}, codes)
+}
+
+func TestNewQuotasErrors(t *testing.T) {
+	for _, conf := range []config.Quotas{
+		{Key: "ip"},
+		{OnExceed: "drop"},
+		{SampleRate: 2},
+		{Store: "disk"},
+	} {
+		_, err := NewQuotas(conf)
+		assert.NotNil(t, err)
+	}
+}
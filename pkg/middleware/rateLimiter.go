@@ -5,15 +5,17 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
 	"github.com/silverton-io/buz/pkg/response"
 	limiter "github.com/ulule/limiter/v3"
-	ginMiddleware "github.com/ulule/limiter/v3/drivers/middleware/gin"
 	"github.com/ulule/limiter/v3/drivers/store/memory"
 )
 
@@ -39,18 +41,173 @@ func onLimitReachedHandler(c *gin.Context) {
 	c.JSON(http.StatusTooManyRequests, response.RateLimitExceeded)
 }
 
-func BuildRateLimiter(conf config.RateLimiter) *limiter.Limiter {
-	period := getDurationFromString(conf.Period)
-	rate := limiter.Rate{
-		Period: period,
-		Limit:  conf.Limit,
+// Rate limit stores
+const (
+	MEMORY string = "memory"
+	REDIS  string = "redis"
+)
+
+// Rate limit keys
+const (
+	IP      string = "ip"
+	API_KEY string = "apiKey"
+	TENANT  string = "tenant"
+)
+
+// ValidateRateLimitKey returns an error if requests can't be limited by the key
+func ValidateRateLimitKey(key string) error {
+	switch key {
+	case IP, API_KEY, TENANT, "":
+		return nil
+	}
+	return errors.New("unsupported rate limit key: " + key)
+}
+
+func buildRateLimitStore(conf config.RateLimiter) (limiter.Store, error) {
+	switch conf.Store {
+	case MEMORY, "":
+		return memory.NewStore(), nil
+	case REDIS:
+		return newRedisRateLimitStore(conf.Redis), nil
+	}
+	return nil, errors.New("unsupported rate limit store: " + conf.Store)
+}
+
+func buildRate(period string, limit int64) limiter.Rate {
+	return limiter.Rate{
+		Period: getDurationFromString(period),
+		Limit:  limit,
 	}
-	store := memory.NewStore()
-	l := limiter.New(store, rate)
-	return l
 }
 
-func BuildRateLimiterMiddleware(l *limiter.Limiter) gin.HandlerFunc {
-	middleware := ginMiddleware.NewMiddleware(l, ginMiddleware.WithLimitReachedHandler(onLimitReachedHandler))
-	return middleware
+func BuildRateLimiter(conf config.RateLimiter) (*limiter.Limiter, error) {
+	store, err := buildRateLimitStore(conf)
+	if err != nil {
+		return nil, err
+	}
+	l := limiter.New(store, buildRate(conf.Period, conf.Limit))
+	return l, nil
+}
+
+// rateLimitKey returns the key the request is limited by. Requests without
+// an api key or tenant are limited by ip.
+func rateLimitKey(c *gin.Context, key string) string {
+	switch key {
+	case API_KEY:
+		if k, ok := c.Value(constants.AUTH_API_KEY).(*apiKey); ok {
+			return "key:" + k.name
+		}
+	case TENANT:
+		if tenant := c.GetString(constants.TENANT); tenant != "" {
+			return "tenant:" + tenant
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// limitRequest limits the request by the key. Requests are let through if
+// the limiter's store fails, rather than failing collection.
+func limitRequest(c *gin.Context, l *limiter.Limiter, key string) {
+	limit, err := l.Get(c, key)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not check rate limit")
+		c.Next()
+		return
+	}
+	c.Header("X-RateLimit-Limit", strconv.FormatInt(limit.Limit, 10))
+	c.Header("X-RateLimit-Remaining", strconv.FormatInt(limit.Remaining, 10))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(limit.Reset, 10))
+	if limit.Reached {
+		onLimitReachedHandler(c)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+func BuildRateLimiterMiddleware(l *limiter.Limiter, key string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limitRequest(c, l, rateLimitKey(c, key))
+	}
+}
+
+// routeRateLimit is the limit of an input, or one of its routes. Requests
+// are counted separately from the global limit.
+type routeRateLimit struct {
+	limiter *limiter.Limiter
+	prefix  string
+}
+
+// RateLimiters limits requests by the limit of their route, falling back to
+// the global limit for routes without one
+type RateLimiters struct {
+	key    string
+	store  limiter.Store
+	global *limiter.Limiter
+	rules  []config.RouteRateLimiter
+	routes map[string]routeRateLimit
+}
+
+func NewRateLimiters(conf config.RateLimiter) (*RateLimiters, error) {
+	if err := ValidateRateLimitKey(conf.Key); err != nil {
+		return nil, err
+	}
+	store, err := buildRateLimitStore(conf)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range conf.Routes {
+		if rule.Input == "" {
+			return nil, errors.New("route rate limits require an input")
+		}
+	}
+	return &RateLimiters{
+		key:    conf.Key,
+		store:  store,
+		global: limiter.New(store, buildRate(conf.Period, conf.Limit)),
+		rules:  conf.Routes,
+		routes: make(map[string]routeRateLimit),
+	}, nil
+}
+
+// LimitRoutes applies the input's route limits to its routes. Limits of a
+// single route take precedence over limits of the whole input. It must be
+// called before serving requests.
+func (r *RateLimiters) LimitRoutes(input string, paths []string) {
+	for _, rule := range r.rules {
+		if rule.Input != input || rule.Path != "" {
+			continue
+		}
+		limit := routeRateLimit{
+			limiter: limiter.New(r.store, buildRate(rule.Period, rule.Limit)),
+			prefix:  "input:" + input + ":",
+		}
+		for _, path := range paths {
+			r.routes[path] = limit
+		}
+	}
+	for _, rule := range r.rules {
+		if rule.Input != input || rule.Path == "" {
+			continue
+		}
+		for _, path := range paths {
+			if path == rule.Path {
+				r.routes[path] = routeRateLimit{
+					limiter: limiter.New(r.store, buildRate(rule.Period, rule.Limit)),
+					prefix:  "route:" + path + ":",
+				}
+			}
+		}
+	}
+}
+
+func (r *RateLimiters) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := rateLimitKey(c, r.key)
+		if route, ok := r.routes[c.FullPath()]; ok {
+			limitRequest(c, route.limiter, route.prefix+key)
+			return
+		}
+		limitRequest(c, r.global, key)
+	}
 }
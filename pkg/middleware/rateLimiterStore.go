@@ -0,0 +1,85 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/silverton-io/buz/pkg/config"
+	limiter "github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/common"
+)
+
+const DEFAULT_RATE_LIMIT_PREFIX string = "buz:ratelimit:"
+
+// Increments the count of the window, starting the window if it's new.
+// Returns the count and the milliseconds left in the window.
+var incrementScript = redis.NewScript(`
+local count = redis.call("incrby", KEYS[1], ARGV[1])
+if count == tonumber(ARGV[1]) then
+	redis.call("pexpire", KEYS[1], ARGV[2])
+	return {count, tonumber(ARGV[2])}
+end
+return {count, redis.call("pttl", KEYS[1])}
+`)
+
+var peekScript = redis.NewScript(`
+local count = redis.call("get", KEYS[1])
+if count == false then
+	return {0, 0}
+end
+return {tonumber(count), redis.call("pttl", KEYS[1])}
+`)
+
+// redisRateLimitStore counts requests in fixed windows in redis, so every
+// instance shares the same limits
+type redisRateLimitStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func newRedisRateLimitStore(conf config.Redis) *redisRateLimitStore {
+	prefix := conf.Prefix + DEFAULT_RATE_LIMIT_PREFIX
+	return &redisRateLimitStore{
+		client: newRedisClient(conf),
+		prefix: prefix,
+	}
+}
+
+func (s *redisRateLimitStore) run(ctx context.Context, script *redis.Script, key string, rate limiter.Rate, args ...interface{}) (limiter.Context, error) {
+	now := time.Now()
+	result, err := script.Run(ctx, s.client, []string{s.prefix + key}, args...).Int64Slice()
+	if err != nil {
+		return limiter.Context{}, err
+	}
+	count, ttl := result[0], result[1]
+	expiration := now.Add(rate.Period)
+	if ttl > 0 {
+		expiration = now.Add(time.Duration(ttl) * time.Millisecond)
+	}
+	return common.GetContextFromState(now, rate, expiration, count), nil
+}
+
+func (s *redisRateLimitStore) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return s.Increment(ctx, key, 1, rate)
+}
+
+func (s *redisRateLimitStore) Increment(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
+	return s.run(ctx, incrementScript, key, rate, count, rate.Period.Milliseconds())
+}
+
+func (s *redisRateLimitStore) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return s.run(ctx, peekScript, key, rate)
+}
+
+func (s *redisRateLimitStore) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if err := s.client.Del(ctx, s.prefix+key).Err(); err != nil {
+		return limiter.Context{}, err
+	}
+	now := time.Now()
+	return common.GetContextFromState(now, rate, now.Add(rate.Period), 0), nil
+}
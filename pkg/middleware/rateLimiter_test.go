@@ -12,8 +12,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gin-gonic/gin"
 	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
 	"github.com/silverton-io/buz/pkg/response"
 	"github.com/stretchr/testify/assert"
 )
@@ -58,7 +60,8 @@ func TestBuildRateLimiter(t *testing.T) {
 		Limit:   int64(1),
 	}
 	wantDuration := getDurationFromString(c.Period)
-	limiter := BuildRateLimiter(c)
+	limiter, err := BuildRateLimiter(c)
+	assert.Nil(t, err)
 	assert.Equal(t, limiter.Rate.Period, wantDuration)
 	assert.Equal(t, limiter.Rate.Limit, c.Limit)
 }
@@ -69,6 +72,109 @@ func TestBuildRateLimiterMiddleware(t *testing.T) {
 		Period:  "H",
 		Limit:   int64(1),
 	}
-	limiter := BuildRateLimiter(c)
-	BuildRateLimiterMiddleware(limiter)
+	limiter, err := BuildRateLimiter(c)
+	assert.Nil(t, err)
+	BuildRateLimiterMiddleware(limiter, IP)
+}
+
+func TestRedisRateLimiterIsShared(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := config.RateLimiter{
+		Enabled: true,
+		Period:  "H",
+		Limit:   int64(2),
+		Store:   REDIS,
+		Redis:   config.Redis{Addr: mr.Addr()},
+	}
+	// Two instances behind a load balancer
+	var routers []*gin.Engine
+	for i := 0; i < 2; i++ {
+		l, err := BuildRateLimiter(c)
+		assert.Nil(t, err)
+		r := gin.New()
+		r.Use(BuildRateLimiterMiddleware(l, IP))
+		r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+		routers = append(routers, r)
+	}
+	var codes []int
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		routers[i%2].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		codes = append(codes, rec.Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+
+	mr.Close()
+	rec := httptest.NewRecorder()
+	routers[0].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code, "requests should be let through when redis is down")
+}
+
+func TestRateLimitKey(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		key    string
+		values map[string]interface{}
+		want   string
+	}{
+		{"ip", IP, nil, "ip:10.0.0.1"},
+		{"api key", API_KEY, map[string]interface{}{constants.AUTH_API_KEY: &apiKey{name: "web"}}, "key:web"},
+		{"no api key", API_KEY, nil, "ip:10.0.0.1"},
+		{"tenant", TENANT, map[string]interface{}{constants.TENANT: "acme"}, "tenant:acme"},
+		{"no tenant", TENANT, nil, "ip:10.0.0.1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			c.Request.RemoteAddr = "10.0.0.1:1234"
+			for k, v := range tc.values {
+				c.Set(k, v)
+			}
+			assert.Equal(t, tc.want, rateLimitKey(c, tc.key))
+		})
+	}
+}
+
+func TestBuildRateLimiterUnsupportedStore(t *testing.T) {
+	_, err := BuildRateLimiter(config.RateLimiter{Store: "memcached"})
+	assert.NotNil(t, err)
+	assert.NotNil(t, ValidateRateLimitKey("cookie"))
+}
+
+func TestRouteRateLimits(t *testing.T) {
+	c := config.RateLimiter{
+		Enabled: true,
+		Period:  "H",
+		Limit:   int64(3),
+		Routes: []config.RouteRateLimiter{
+			{Input: "webhook", Period: "H", Limit: 1},
+			{Input: "snowplow", Path: "/tp2", Period: "H", Limit: 2},
+		},
+	}
+	limiters, err := NewRateLimiters(c)
+	assert.Nil(t, err)
+	limiters.LimitRoutes("webhook", []string{"/hook", "/hook/*schema"})
+	limiters.LimitRoutes("snowplow", []string{"/i", "/tp2"})
+	r := gin.New()
+	r.Use(limiters.Middleware())
+	for _, path := range []string{"/hook", "/hook/*schema", "/i", "/tp2", "/stats"} {
+		r.GET(path, func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+	accepted := func(path string, n int) int {
+		var ok int
+		for i := 0; i < n; i++ {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			if rec.Code == http.StatusOK {
+				ok++
+			}
+		}
+		return ok
+	}
+	assert.Equal(t, 1, accepted("/hook", 2))
+	assert.Equal(t, 0, accepted("/hook/com.acme.event", 1), "routes of an input share its limit")
+	assert.Equal(t, 2, accepted("/tp2", 5))
+	assert.Equal(t, 2, accepted("/i", 2))
+	assert.Equal(t, 1, accepted("/stats", 5), "routes without limits share the global limit")
 }
@@ -0,0 +1,167 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const (
+	DEFAULT_NONCE_CLAIM       string = "nonce"
+	DEFAULT_NONCE_TTL_SECONDS int    = 300
+	NONCE_KEY_PREFIX          string = "buz:nonce:"
+)
+
+var (
+	errRequestReplayed = errors.New("request was already used")
+	errNonceMissing    = errors.New("request has no nonce")
+)
+
+// nonceStore remembers nonces until they expire. Claiming a nonce returns
+// false if it was already claimed, or if it has already expired, since
+// it couldn't be remembered.
+type nonceStore interface {
+	claim(ctx context.Context, nonce string, expires time.Time, now time.Time) (bool, error)
+}
+
+type memoryNonceStore struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+func newMemoryNonceStore() *memoryNonceStore {
+	return &memoryNonceStore{seen: make(map[string]time.Time)}
+}
+
+func (s *memoryNonceStore) claim(ctx context.Context, nonce string, expires time.Time, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.pruned) > time.Minute {
+		for k, exp := range s.seen {
+			if now.After(exp) {
+				delete(s.seen, k)
+			}
+		}
+		s.pruned = now
+	}
+	if !expires.After(now) {
+		return false, nil
+	}
+	if exp, ok := s.seen[nonce]; ok && !now.After(exp) {
+		return false, nil
+	}
+	s.seen[nonce] = expires
+	return true, nil
+}
+
+// redisNonceStore shares nonces across instances, so a request can't be
+// replayed against another instance
+type redisNonceStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func (s *redisNonceStore) claim(ctx context.Context, nonce string, expires time.Time, now time.Time) (bool, error) {
+	ttl := expires.Sub(now)
+	if ttl <= 0 {
+		return false, nil
+	}
+	return s.client.SetNX(ctx, s.prefix+NONCE_KEY_PREFIX+nonce, 1, ttl).Result()
+}
+
+func newRedisClient(conf config.Redis) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     conf.Addr,
+		Username: conf.Username,
+		Password: conf.Password,
+		DB:       conf.Db,
+	})
+}
+
+// replayGuard rejects jwt requests which were already used. A nonce is
+// only trusted if the token signs it, so a replayed token can't be sent
+// with a new one. Tokens with a nonce claim are claimed for their subject
+// and nonce. Tokens with a jti claim are single-use only when configured,
+// as ordinary bearer tokens are reused until they expire.
+type replayGuard struct {
+	store        nonceStore
+	singleUseJti bool
+	nonceClaim   string
+	ttl          time.Duration
+	skew         time.Duration // The clock skew tokens are accepted with after they expire
+	require      bool
+	now          func() time.Time
+}
+
+func newReplayGuard(conf config.Replays, skew time.Duration) (*replayGuard, error) {
+	g := &replayGuard{
+		singleUseJti: conf.SingleUseJti,
+		nonceClaim:   conf.NonceClaim,
+		ttl:          time.Duration(conf.TtlSeconds) * time.Second,
+		skew:         skew,
+		require:      conf.RequireNonce,
+		now:          time.Now,
+	}
+	if g.nonceClaim == "" {
+		g.nonceClaim = DEFAULT_NONCE_CLAIM
+	}
+	if g.ttl <= 0 {
+		g.ttl = time.Duration(DEFAULT_NONCE_TTL_SECONDS) * time.Second
+	}
+	switch conf.Store {
+	case MEMORY, "":
+		g.store = newMemoryNonceStore()
+	case REDIS:
+		client := newRedisClient(conf.Redis)
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			client.Close()
+			return nil, err
+		}
+		g.store = &redisNonceStore{client: client, prefix: conf.Redis.Prefix}
+	default:
+		return nil, errors.New("unsupported replay store: " + conf.Store)
+	}
+	return g, nil
+}
+
+// checkJwt claims the nonce or jti of the request. Nonces are remembered
+// until the token expires, with skew, so it can't be replayed while it is
+// accepted.
+func (g *replayGuard) checkJwt(c *gin.Context, claims map[string]interface{}) error {
+	now := g.now()
+	var nonce string
+	expires := now.Add(g.ttl)
+	if exp, ok := numericDate(claims, "exp"); ok {
+		expires = exp.Add(g.skew)
+	}
+	if n, _ := claims[g.nonceClaim].(string); n != "" {
+		sub, _ := claims["sub"].(string)
+		nonce = "jwt:" + sub + ":" + n
+	} else if jti, _ := claims["jti"].(string); jti != "" && g.singleUseJti {
+		nonce = "jti:" + jti
+	}
+	if nonce == "" {
+		if g.require {
+			return errNonceMissing
+		}
+		return nil
+	}
+	claimed, err := g.store.claim(c, nonce, expires, now)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return errRequestReplayed
+	}
+	return nil
+}
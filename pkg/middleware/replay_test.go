@@ -0,0 +1,139 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryNonceStore(t *testing.T) {
+	s := newMemoryNonceStore()
+	now := time.Now()
+	claimed, _ := s.claim(context.Background(), "a", now.Add(time.Minute), now)
+	assert.True(t, claimed)
+	claimed, _ = s.claim(context.Background(), "a", now.Add(time.Minute), now.Add(30*time.Second))
+	assert.False(t, claimed)
+	claimed, _ = s.claim(context.Background(), "a", now.Add(3*time.Minute), now.Add(2*time.Minute))
+	assert.True(t, claimed)
+	// Nonces which already expired can't be remembered, so they're rejected
+	claimed, _ = s.claim(context.Background(), "b", now, now.Add(time.Second))
+	assert.False(t, claimed)
+}
+
+func TestAuthJwtReplayedAfterExpiry(t *testing.T) {
+	idp := newTestIdp(t)
+	mr := miniredis.RunT(t)
+	// Expired, but within the clock skew tokens are accepted with
+	token := signJwt(t, "ES256", "ec", idp.ecKey, map[string]interface{}{"sub": "svc", "nonce": "a", "exp": time.Now().Add(-10 * time.Second).Unix()})
+	gin.SetMode(gin.TestMode)
+	for _, store := range []string{MEMORY, REDIS} {
+		t.Run(store, func(t *testing.T) {
+			replays := config.Replays{Enabled: true, Store: store, Redis: config.Redis{Addr: mr.Addr()}}
+			auth, err := Auth(config.Auth{Enabled: true, Jwt: config.Jwt{Enabled: true, JwksUrl: idp.server.URL}, Replays: replays})
+			assert.Nil(t, err)
+			r := gin.New()
+			r.Use(auth)
+			r.GET("/", func(c *gin.Context) {})
+			var codes []int
+			for i := 0; i < 2; i++ {
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				r.ServeHTTP(rec, req)
+				codes = append(codes, rec.Code)
+			}
+			assert.Equal(t, []int{http.StatusOK, http.StatusUnauthorized}, codes)
+		})
+	}
+}
+
+func TestAuthJwtReplays(t *testing.T) {
+	idp := newTestIdp(t)
+	exp := time.Now().Add(time.Minute).Unix()
+	withJti := signJwt(t, "ES256", "ec", idp.ecKey, map[string]interface{}{"sub": "svc", "jti": "1", "exp": exp})
+	withNonce := signJwt(t, "ES256", "ec", idp.ecKey, map[string]interface{}{"sub": "svc", "nonce": "a", "exp": exp})
+	withOtherNonce := signJwt(t, "ES256", "ec", idp.ecKey, map[string]interface{}{"sub": "svc", "nonce": "b", "exp": exp})
+	plain := signJwt(t, "ES256", "ec", idp.ecKey, map[string]interface{}{"sub": "svc", "exp": exp})
+	gin.SetMode(gin.TestMode)
+	router := func(replays config.Replays) *gin.Engine {
+		replays.Enabled = true
+		auth, err := Auth(config.Auth{Enabled: true, Jwt: config.Jwt{Enabled: true, JwksUrl: idp.server.URL}, Replays: replays})
+		assert.Nil(t, err)
+		r := gin.New()
+		r.Use(auth)
+		r.GET("/", func(c *gin.Context) {})
+		return r
+	}
+	var testCases = []struct {
+		name      string
+		replays   config.Replays
+		tokens    []string
+		wantCodes []int
+	}{
+		{"reused tokens", config.Replays{}, []string{plain, plain, withJti, withJti}, []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK}},
+		{"nonce claims", config.Replays{}, []string{withNonce, withNonce, withOtherNonce}, []int{http.StatusOK, http.StatusUnauthorized, http.StatusOK}},
+		{"single-use jti", config.Replays{SingleUseJti: true}, []string{withJti, withJti, plain}, []int{http.StatusOK, http.StatusUnauthorized, http.StatusOK}},
+		{"required nonce", config.Replays{RequireNonce: true}, []string{plain, withJti, withNonce}, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusOK}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := router(tc.replays)
+			var codes []int
+			for _, token := range tc.tokens {
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				// Unsigned headers aren't nonces
+				req.Header.Set("Idempotency-Key", fmt.Sprint(len(codes)))
+				r.ServeHTTP(rec, req)
+				codes = append(codes, rec.Code)
+			}
+			assert.Equal(t, tc.wantCodes, codes)
+		})
+	}
+}
+
+func TestRedisReplaysAreShared(t *testing.T) {
+	mr := miniredis.RunT(t)
+	conf := config.Auth{
+		Enabled: true,
+		Hmac:    config.Hmac{Enabled: true, Clients: []config.HmacClient{{Id: "billing", Secret: "s3cret"}}},
+		Replays: config.Replays{Enabled: true, Store: REDIS, Redis: config.Redis{Addr: mr.Addr()}},
+	}
+	gin.SetMode(gin.TestMode)
+	now := time.Now().Unix()
+	signature := fmt.Sprintf("keyId=billing,t=%d,v1=%s", now, Sign([]byte("s3cret"), now, http.MethodPost, "/webhook", []byte(`{}`)))
+	var codes []int
+	// Each instance has its own middleware, sharing only redis
+	for i := 0; i < 2; i++ {
+		auth, err := Auth(conf)
+		assert.Nil(t, err)
+		r := gin.New()
+		r.Use(auth)
+		r.POST("/webhook", func(c *gin.Context) {})
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{}`))
+		req.Header.Set(DEFAULT_SIGNATURE_HEADER, signature)
+		r.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusUnauthorized}, codes)
+}
+
+func TestReplaysUnsupportedStore(t *testing.T) {
+	_, err := newReplayGuard(config.Replays{Enabled: true, Store: "disk"}, 0)
+	assert.NotNil(t, err)
+}
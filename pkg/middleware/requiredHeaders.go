@@ -0,0 +1,136 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/response"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+var requiredHeaderRejections = stats.NewSchemaStats()
+
+// RequiredHeaderRejections returns the requests rejected for each missing
+// or invalid header, by route
+func RequiredHeaderRejections() map[string]map[string]int64 {
+	return requiredHeaderRejections.Snapshot()
+}
+
+type requiredHeader struct {
+	name    string
+	value   *[32]byte
+	pattern *regexp.Regexp
+}
+
+func buildRequiredHeader(conf config.RequiredHeader) (requiredHeader, error) {
+	h := requiredHeader{name: http.CanonicalHeaderKey(conf.Name)}
+	if conf.Name == "" {
+		return h, errors.New("required headers require a name")
+	}
+	if conf.Value != "" {
+		sum := sha256.Sum256([]byte(conf.Value))
+		h.value = &sum
+	}
+	if conf.Pattern != "" {
+		var err error
+		if h.pattern, err = regexp.Compile(conf.Pattern); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+// valid returns true if the header is present, and has the expected value
+// and pattern. Values are hashed so they are compared in constant time
+// regardless of their length.
+func (h requiredHeader) valid(headers http.Header) bool {
+	values, ok := headers[h.name]
+	if !ok || len(values) == 0 {
+		return false
+	}
+	if h.value != nil {
+		sum := sha256.Sum256([]byte(values[0]))
+		if subtle.ConstantTimeCompare(sum[:], h.value[:]) != 1 {
+			return false
+		}
+	}
+	if h.pattern != nil && !h.pattern.MatchString(values[0]) {
+		return false
+	}
+	return true
+}
+
+type routeHeaders struct {
+	input   string
+	path    string
+	headers []requiredHeader
+}
+
+// RequiredHeaders rejects requests to inputs which don't carry the headers
+// declared for them, before their payloads are read.
+type RequiredHeaders struct {
+	rules  []routeHeaders
+	routes map[string][]requiredHeader
+}
+
+func NewRequiredHeaders(conf config.RequiredHeaders) (*RequiredHeaders, error) {
+	r := &RequiredHeaders{routes: make(map[string][]requiredHeader)}
+	for _, rc := range conf.Routes {
+		if rc.Input == "" {
+			return nil, errors.New("required headers require an input")
+		}
+		if len(rc.Headers) == 0 {
+			return nil, errors.New("required headers of input have no headers: " + rc.Input)
+		}
+		rule := routeHeaders{input: rc.Input, path: rc.Path}
+		for _, hc := range rc.Headers {
+			h, err := buildRequiredHeader(hc)
+			if err != nil {
+				return nil, err
+			}
+			rule.headers = append(rule.headers, h)
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+// RequireRoutes applies the input's required headers to its routes. Headers
+// of a single route are required in addition to those of the whole input.
+// It must be called before serving requests.
+func (r *RequiredHeaders) RequireRoutes(input string, paths []string) {
+	for _, rule := range r.rules {
+		if rule.input != input {
+			continue
+		}
+		for _, path := range paths {
+			if rule.path == "" || rule.path == path {
+				r.routes[path] = append(r.routes[path], rule.headers...)
+			}
+		}
+	}
+}
+
+func (r *RequiredHeaders) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, h := range r.routes[c.FullPath()] {
+			if !h.valid(c.Request.Header) {
+				log.Debug().Str("header", h.name).Str("path", c.FullPath()).Msg("🟡 required header missing or invalid")
+				requiredHeaderRejections.Increment(h.name, c.FullPath(), 1)
+				c.AbortWithStatusJSON(http.StatusForbidden, response.RequiredHeaderInvalid)
+				return
+			}
+		}
+		c.Next()
+	}
+}
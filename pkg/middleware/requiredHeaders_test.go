@@ -0,0 +1,71 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequiredHeaders(t *testing.T) {
+	required, err := NewRequiredHeaders(config.RequiredHeaders{
+		Enabled: true,
+		Routes: []config.RouteHeaders{
+			{Input: "pixel", Headers: []config.RequiredHeader{{Name: "x-pixel-secret", Value: "s3cret"}}},
+			{Input: "webhook", Path: "/webhook", Headers: []config.RequiredHeader{{Name: "Content-Type", Pattern: "^application/json"}}},
+		},
+	})
+	assert.Nil(t, err)
+	required.RequireRoutes("pixel", []string{"/pixel", "/pixel/*schema"})
+	required.RequireRoutes("webhook", []string{"/webhook", "/webhook/*schema"})
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(required.Middleware())
+	for _, path := range []string{"/pixel", "/pixel/*schema", "/webhook", "/webhook/*schema"} {
+		r.GET(path, func(c *gin.Context) {})
+	}
+	var testCases = []struct {
+		name     string
+		path     string
+		headers  map[string]string
+		wantCode int
+	}{
+		{"secret", "/pixel", map[string]string{"X-Pixel-Secret": "s3cret"}, http.StatusOK},
+		{"secret on schema route", "/pixel/io.silverton/test/v1.0.json", map[string]string{"X-Pixel-Secret": "s3cret"}, http.StatusOK},
+		{"wrong secret", "/pixel", map[string]string{"X-Pixel-Secret": "guess"}, http.StatusForbidden},
+		{"missing secret", "/pixel", nil, http.StatusForbidden},
+		{"pattern", "/webhook", map[string]string{"Content-Type": "application/json; charset=utf-8"}, http.StatusOK},
+		{"pattern mismatch", "/webhook", map[string]string{"Content-Type": "text/plain"}, http.StatusForbidden},
+		{"other route of input", "/webhook/io.silverton/test/v1.0.json", nil, http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, tc.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestRequiredHeadersConfigErrors(t *testing.T) {
+	for _, conf := range []config.RouteHeaders{
+		{Headers: []config.RequiredHeader{{Name: "X-Secret"}}},
+		{Input: "pixel"},
+		{Input: "pixel", Headers: []config.RequiredHeader{{Value: "s3cret"}}},
+		{Input: "pixel", Headers: []config.RequiredHeader{{Name: "X-Secret", Pattern: "("}}},
+	} {
+		_, err := NewRequiredHeaders(config.RequiredHeaders{Routes: []config.RouteHeaders{conf}})
+		assert.NotNil(t, err)
+	}
+}
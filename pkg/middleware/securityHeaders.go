@@ -0,0 +1,69 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const (
+	DEFAULT_HSTS_MAX_AGE_SECONDS int    = 365 * 24 * 60 * 60
+	DEFAULT_REFERRER_POLICY      string = "strict-origin-when-cross-origin"
+	DEFAULT_FRAME_OPTIONS        string = "DENY"
+)
+
+// securityHeaders returns the headers to set on every response
+func securityHeaders(conf config.SecurityHeaders) http.Header {
+	headers := http.Header{}
+	headers.Set("X-Content-Type-Options", "nosniff")
+	headers.Set("Referrer-Policy", DEFAULT_REFERRER_POLICY)
+	headers.Set("X-Frame-Options", DEFAULT_FRAME_OPTIONS)
+	if conf.ReferrerPolicy != "" {
+		headers.Set("Referrer-Policy", conf.ReferrerPolicy)
+	}
+	if conf.FrameOptions != "" {
+		headers.Set("X-Frame-Options", conf.FrameOptions)
+	}
+	if conf.Hsts.Enabled {
+		maxAge := conf.Hsts.MaxAgeSeconds
+		if maxAge <= 0 {
+			maxAge = DEFAULT_HSTS_MAX_AGE_SECONDS
+		}
+		hsts := "max-age=" + strconv.Itoa(maxAge)
+		if conf.Hsts.IncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if conf.Hsts.Preload {
+			hsts += "; preload"
+		}
+		headers.Set("Strict-Transport-Security", hsts)
+	}
+	for k, v := range conf.Headers {
+		if v == "" {
+			headers.Del(k)
+			continue
+		}
+		headers.Set(k, v)
+	}
+	return headers
+}
+
+// SecurityHeaders sets security headers on every response, including
+// rejections by later middleware. Browsers ignore hsts over plain http, so
+// it only takes effect when buz is served over tls.
+func SecurityHeaders(conf config.SecurityHeaders) gin.HandlerFunc {
+	headers := securityHeaders(conf)
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		for k, v := range headers {
+			h[k] = v
+		}
+		c.Next()
+	}
+}
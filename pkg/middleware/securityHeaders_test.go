@@ -0,0 +1,66 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	var testCases = []struct {
+		name string
+		conf config.SecurityHeaders
+		want map[string]string
+	}{
+		{
+			name: "defaults",
+			conf: config.SecurityHeaders{Enabled: true},
+			want: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"Referrer-Policy":           DEFAULT_REFERRER_POLICY,
+				"X-Frame-Options":           DEFAULT_FRAME_OPTIONS,
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			name: "configured",
+			conf: config.SecurityHeaders{
+				Enabled:        true,
+				Hsts:           config.Hsts{Enabled: true, IncludeSubdomains: true, Preload: true},
+				ReferrerPolicy: "no-referrer",
+				FrameOptions:   "SAMEORIGIN",
+				Headers:        map[string]string{"content-security-policy": "default-src 'none'", "x-frame-options": ""},
+			},
+			want: map[string]string{
+				"Referrer-Policy":           "no-referrer",
+				"X-Frame-Options":           "",
+				"Strict-Transport-Security": "max-age=31536000; includeSubDomains; preload",
+				"Content-Security-Policy":   "default-src 'none'",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Use(SecurityHeaders(tc.conf))
+			r.GET("/", func(c *gin.Context) {})
+			// Responses without a route get the headers too
+			for _, path := range []string{"/", "/missing"} {
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				for k, v := range tc.want {
+					assert.Equal(t, v, rec.Header().Get(k), path+" "+k)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/response"
+)
+
+const DEFAULT_API_KEY_HEADER string = "X-Api-Key"
+
+type tenants struct {
+	byApiKey map[string]string
+	byName   map[string]bool
+	domains  map[string]string
+}
+
+func buildTenants(conf config.Tenancy) tenants {
+	t := tenants{
+		byApiKey: make(map[string]string),
+		byName:   make(map[string]bool),
+		domains:  make(map[string]string),
+	}
+	for _, tenant := range conf.Tenants {
+		t.byName[tenant.Name] = true
+		for _, key := range tenant.ApiKeys {
+			t.byApiKey[key] = tenant.Name
+		}
+		for _, domain := range tenant.Domains {
+			t.domains[strings.ToLower(domain)] = tenant.Name
+		}
+	}
+	return t
+}
+
+// byHost returns the tenant of the most specific domain matching the host
+func (t tenants) byHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	var tenant, matchedDomain string
+	for domain, name := range t.domains {
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > len(matchedDomain) {
+			tenant, matchedDomain = name, domain
+		}
+	}
+	return tenant
+}
+
+// claimedTenant returns the tenant named by the claim of the request's
+// verified jwt, if any
+func claimedTenant(c *gin.Context, claim string) string {
+	if claim == "" {
+		return ""
+	}
+	claims, _ := c.Value(constants.AUTH_CLAIMS).(map[string]interface{})
+	tenant, _ := claims[claim].(string)
+	return tenant
+}
+
+// Tenancy resolves the tenant of each request - by tenant api key, then
+// by the tenant of the auth api key, then by the jwt claim, then by the
+// tenant header if one is trusted, then by the request domain - and
+// stores it for the envelope builders. Requests with an unknown tenant
+// api key, or which can't be resolved to a tenant, are rejected unless
+// there is a default tenant.
+func Tenancy(conf config.Tenancy) gin.HandlerFunc {
+	t := buildTenants(conf)
+	apiKeyHeader := conf.ApiKeyHeader
+	if apiKeyHeader == "" {
+		apiKeyHeader = DEFAULT_API_KEY_HEADER
+	}
+	return func(c *gin.Context) {
+		var tenant string
+		if key := c.GetHeader(apiKeyHeader); key != "" {
+			var ok bool
+			if tenant, ok = t.byApiKey[key]; !ok {
+				c.JSON(http.StatusUnauthorized, response.UnknownTenant)
+				c.Abort()
+				return
+			}
+		}
+		// Only configured tenants can be named by the auth api key, claim,
+		// or header, since tenants are templated into sink outputs
+		if name := c.GetString(constants.TENANT); tenant == "" && t.byName[name] {
+			tenant = name
+		}
+		if name := claimedTenant(c, conf.Claim); tenant == "" && t.byName[name] {
+			tenant = name
+		}
+		if name := c.GetHeader(conf.Header); tenant == "" && conf.Header != "" && t.byName[name] {
+			tenant = name
+		}
+		if tenant == "" {
+			tenant = t.byHost(c.Request.Host)
+		}
+		if tenant == "" {
+			tenant = conf.DefaultTenant
+		}
+		if tenant == "" {
+			c.JSON(http.StatusUnauthorized, response.UnknownTenant)
+			c.Abort()
+			return
+		}
+		c.Set(constants.TENANT, tenant)
+		c.Next()
+	}
+}
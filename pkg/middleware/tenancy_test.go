@@ -0,0 +1,65 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenancy(t *testing.T) {
+	conf := config.Tenancy{
+		Enabled: true,
+		Header:  "X-Buz-Tenant",
+		Tenants: []config.Tenant{
+			{Name: "acme", ApiKeys: []string{"acme-key"}, Domains: []string{"acme.com"}},
+			{Name: "globex", ApiKeys: []string{"globex-key"}, Domains: []string{"globex.com"}},
+		},
+	}
+	var testCases = []struct {
+		name       string
+		conf       config.Tenancy
+		host       string
+		headers    map[string]string
+		wantCode   int
+		wantTenant string
+	}{
+		{"api key", conf, "collector.io", map[string]string{"X-Api-Key": "globex-key"}, http.StatusOK, "globex"},
+		{"api key wins", conf, "t.acme.com", map[string]string{"X-Api-Key": "globex-key"}, http.StatusOK, "globex"},
+		{"unknown api key", conf, "t.acme.com", map[string]string{"X-Api-Key": "nope"}, http.StatusUnauthorized, ""},
+		{"header", conf, "collector.io", map[string]string{"X-Buz-Tenant": "acme"}, http.StatusOK, "acme"},
+		{"unknown header", conf, "collector.io", map[string]string{"X-Buz-Tenant": "initech"}, http.StatusUnauthorized, ""},
+		{"domain", conf, "t.acme.com:8080", nil, http.StatusOK, "acme"},
+		{"unresolved", conf, "collector.io", nil, http.StatusUnauthorized, ""},
+		{"default", config.Tenancy{Enabled: true, DefaultTenant: "shared"}, "collector.io", nil, http.StatusOK, "shared"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			var tenant string
+			r := gin.New()
+			r.Use(Tenancy(tc.conf))
+			r.GET("/", func(c *gin.Context) {
+				tenant = c.GetString(constants.TENANT)
+				c.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tc.host
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tc.wantCode, w.Code)
+			assert.Equal(t, tc.wantTenant, tenant)
+		})
+	}
+}
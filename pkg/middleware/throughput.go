@@ -0,0 +1,23 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+// InputThroughput counts the requests to an input, with their latency and
+// status, for the input stats route
+func InputThroughput(input string) gin.HandlerFunc {
+	stats.Inputs().Track(input)
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		stats.Inputs().Request(input, time.Since(start), c.Writer.Status())
+	}
+}
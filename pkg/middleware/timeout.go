@@ -13,6 +13,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/response"
+	"github.com/silverton-io/buz/pkg/tap"
 )
 
 func timeoutHandler(c *gin.Context) {
@@ -22,11 +23,19 @@ func timeoutHandler(c *gin.Context) {
 
 func Timeout(conf config.Timeout) gin.HandlerFunc {
 	// TODO: pass context down the line so events aren't passed to invalid if the request times out.
-	return timeout.New(
+	t := timeout.New(
 		timeout.WithTimeout(time.Duration(conf.Ms)*time.Millisecond),
 		timeout.WithHandler(func(c *gin.Context) {
 			c.Next()
 		}),
 		timeout.WithResponse(timeoutHandler),
 	)
+	return func(c *gin.Context) {
+		// The tap streams events for as long as it is connected, and can't be buffered
+		if c.FullPath() == tap.TAP_ROUTE {
+			c.Next()
+			return
+		}
+		t(c)
+	}
 }
@@ -16,6 +16,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/response"
+	"github.com/silverton-io/buz/pkg/tap"
+	"github.com/stretchr/testify/assert"
 )
 
 func testHandler(c *gin.Context) {
@@ -64,3 +66,20 @@ func TestTimeout(t *testing.T) {
 		}
 	}
 }
+
+func TestTimeoutExemptsTap(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Timeout(config.Timeout{Enabled: true, Ms: 1}))
+	r.GET(tap.TAP_ROUTE, testHandler)
+	r.GET("/somepath", testHandler)
+
+	// The exemption is by route, not by what the client asks to accept
+	for path, wantCode := range map[string]int{tap.TAP_ROUTE: http.StatusOK, "/somepath": http.StatusRequestTimeout} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", "text/event-stream")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		assert.Equal(t, wantCode, rec.Code, path)
+	}
+}
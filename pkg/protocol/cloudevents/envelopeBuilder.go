@@ -37,9 +37,11 @@ func buildEnvelopesFromRequest(c *gin.Context, conf *config.Config, m *meta.Coll
 		if evnt.Time != nil {
 			n.Timestamp = *evnt.Time
 		}
+		n.SetDeviceTimestamps(evnt.Time, nil)
 		n.Contexts = &contexts
 		n.Payload = evnt.Data
 		envelopes = append(envelopes, n)
 	}
-	return envelopes
+	envelope.Capture(c, conf.Cloudevents.Capture, envelopes)
+	return envelope.ApplyQuota(c, envelope.ApplyPrivacy(c, conf.Cloudevents.Privacy, protocol.CLOUDEVENTS, envelopes))
 }
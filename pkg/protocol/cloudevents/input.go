@@ -11,14 +11,23 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/input"
 	"github.com/silverton-io/buz/pkg/manifold"
 	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/protocol"
 	"github.com/silverton-io/buz/pkg/response"
 )
 
 type CloudeventsInput struct{}
 
+func (i *CloudeventsInput) Name() string {
+	return protocol.CLOUDEVENTS
+}
+
 func (i *CloudeventsInput) Initialize(routerGroup *gin.RouterGroup, manifold *manifold.Manifold, conf *config.Config, metadata *meta.CollectorMeta) error {
+	if err := envelope.ValidatePrivacy(conf.Inputs.Cloudevents.Privacy); err != nil {
+		return err
+	}
 	if conf.Inputs.Cloudevents.Enabled {
 		log.Info().Msg("🟢 initializing cloudevents input")
 		routerGroup.POST(conf.Inputs.Cloudevents.Path, i.Handler(*manifold, *conf, metadata))
@@ -36,8 +45,7 @@ func (i *CloudeventsInput) Handler(m manifold.Manifold, conf config.Config, meta
 			envelopes := i.EnvelopeBuilder(c, &conf, metadata)
 			err := m.Enqueue(envelopes)
 			if err != nil {
-				c.Header("Retry-After", response.RETRY_AFTER_60)
-				c.JSON(http.StatusServiceUnavailable, response.ManifoldDistributionError)
+				input.EnqueueFailed(c, err)
 			} else {
 				c.JSON(http.StatusOK, response.Ok)
 			}
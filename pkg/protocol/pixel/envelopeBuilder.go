@@ -29,5 +29,6 @@ func buildEnvelopesFromRequest(c *gin.Context, conf *config.Config, m *meta.Coll
 	n.Contexts = &contexts
 	n.Payload = evnt.Data
 	envelopes = append(envelopes, n)
-	return envelopes
+	envelope.Capture(c, conf.Pixel.Capture, envelopes)
+	return envelope.ApplyQuota(c, envelope.ApplyPrivacy(c, conf.Pixel.Privacy, protocol.PIXEL, envelopes))
 }
@@ -13,20 +13,30 @@ import (
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/constants"
 	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/input"
 	"github.com/silverton-io/buz/pkg/manifold"
 	"github.com/silverton-io/buz/pkg/meta"
-	"github.com/silverton-io/buz/pkg/response"
+	"github.com/silverton-io/buz/pkg/middleware"
+	"github.com/silverton-io/buz/pkg/protocol"
 )
 
 const PX string = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mP8Xw8AAoMBgDTD2qgAAAAASUVORK5CYII="
 
 type PixelInput struct{}
 
+func (i *PixelInput) Name() string {
+	return protocol.PIXEL
+}
+
 func (i *PixelInput) Initialize(routerGroup *gin.RouterGroup, manifold *manifold.Manifold, conf *config.Config, metadata *meta.CollectorMeta) error {
+	if err := envelope.ValidatePrivacy(conf.Inputs.Pixel.Privacy); err != nil {
+		return err
+	}
+	identityMiddleware := middleware.Identity(conf.Identity)
 	if conf.Inputs.Pixel.Enabled {
 		log.Info().Msg("🟢 initializing pixel input")
-		routerGroup.GET(conf.Inputs.Pixel.Path, i.Handler(*manifold, *conf, metadata))
-		routerGroup.GET(conf.Inputs.Pixel.Path+"/*"+constants.BUZ_SCHEMA_PARAM, i.Handler(*manifold, *conf, metadata))
+		routerGroup.GET(conf.Inputs.Pixel.Path, identityMiddleware, i.Handler(*manifold, *conf, metadata))
+		routerGroup.GET(conf.Inputs.Pixel.Path+"/*"+constants.BUZ_SCHEMA_PARAM, identityMiddleware, i.Handler(*manifold, *conf, metadata))
 	}
 	if conf.Squawkbox.Enabled {
 		log.Info().Msg("🟢 initializing pixel input squawkbox")
@@ -40,8 +50,7 @@ func (i *PixelInput) Handler(m manifold.Manifold, conf config.Config, metadata *
 		envelopes := i.EnvelopeBuilder(c, &conf, metadata)
 		err := m.Enqueue(envelopes)
 		if err != nil {
-			c.Header("Retry-After", response.RETRY_AFTER_60)
-			c.JSON(http.StatusServiceUnavailable, response.ManifoldDistributionError)
+			input.EnqueueFailed(c, err)
 		} else {
 			b, _ := base64.StdEncoding.DecodeString(PX)
 			c.Data(http.StatusOK, "image/png", b)
@@ -56,5 +56,6 @@ func buildEnvelopesFromRequest(c *gin.Context, conf *config.Config, m *meta.Coll
 		n.Payload = evnt.Payload.Data
 		envelopes = append(envelopes, n)
 	}
-	return envelopes
+	envelope.Capture(c, conf.SelfDescribing.Capture, envelopes)
+	return envelope.ApplyQuota(c, envelope.ApplyPrivacy(c, conf.SelfDescribing.Privacy, protocol.SELF_DESCRIBING, envelopes))
 }
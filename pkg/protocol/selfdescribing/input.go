@@ -11,14 +11,23 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/input"
 	"github.com/silverton-io/buz/pkg/manifold"
 	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/protocol"
 	"github.com/silverton-io/buz/pkg/response"
 )
 
 type SelfDescribingInput struct{}
 
+func (i *SelfDescribingInput) Name() string {
+	return protocol.SELF_DESCRIBING
+}
+
 func (i *SelfDescribingInput) Initialize(routerGroup *gin.RouterGroup, manifold *manifold.Manifold, conf *config.Config, metadata *meta.CollectorMeta) error {
+	if err := envelope.ValidatePrivacy(conf.Inputs.SelfDescribing.Privacy); err != nil {
+		return err
+	}
 	if conf.Inputs.SelfDescribing.Enabled {
 		log.Info().Msg("🟢 initializing self-describing input")
 		routerGroup.POST(conf.Inputs.SelfDescribing.Path, i.Handler(*manifold, *conf, metadata))
@@ -34,8 +43,7 @@ func (i *SelfDescribingInput) Handler(m manifold.Manifold, conf config.Config, m
 		envelopes := i.EnvelopeBuilder(c, &conf, metadata)
 		err := m.Enqueue(envelopes)
 		if err != nil {
-			c.Header("Retry-After", response.RETRY_AFTER_60)
-			c.JSON(http.StatusServiceUnavailable, response.ManifoldDistributionError)
+			input.EnqueueFailed(c, err)
 		} else {
 			c.JSON(http.StatusOK, response.Ok)
 		}
@@ -19,7 +19,13 @@ import (
 
 func buildSnowplowEnvelope(conf config.Config, e SnowplowEvent) envelope.Envelope {
 	n := envelope.NewEnvelope(conf.App)
-	n.Timestamp = *e.DvceCreatedTstamp
+	n.BuzTimestamp = e.CollectorTstamp
+	n.DeviceCreatedTimestamp, n.DeviceSentTimestamp = e.DvceCreatedTstamp, e.DvceSentTstamp
+	n.DerivedTimestamp = e.DerivedTstamp
+	n.Timestamp = e.DerivedTstamp
+	if e.DvceCreatedTstamp != nil {
+		n.Timestamp = *e.DvceCreatedTstamp
+	}
 	n.Protocol = protocol.SNOWPLOW
 	n.Schema = *e.SelfDescribingEvent.SchemaName()
 	n.Payload = e.Map()
@@ -38,13 +44,16 @@ func buildEnvelopesFromRequest(c *gin.Context, conf *config.Config, m *meta.Coll
 		for _, event := range payloadData.Array() {
 			spEvent := buildEventFromMappedParams(c, event.Value().(map[string]interface{}), *conf)
 			e := buildSnowplowEnvelope(*conf, spEvent)
+			envelope.StampTenant(c, &e)
 			envelopes = append(envelopes, e)
 		}
 	} else {
 		params := util.MapUrlParams(c)
 		spEvent := buildEventFromMappedParams(c, params, *conf)
 		e := buildSnowplowEnvelope(*conf, spEvent)
+		envelope.StampTenant(c, &e)
 		envelopes = append(envelopes, e)
 	}
-	return envelopes
+	envelope.Capture(c, conf.Snowplow.Capture, envelopes)
+	return envelope.ApplyQuota(c, envelope.ApplyPrivacy(c, conf.Snowplow.Privacy, protocol.SNOWPLOW, envelopes))
 }
@@ -15,6 +15,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
 	"github.com/silverton-io/buz/pkg/envelope"
 	"github.com/silverton-io/buz/pkg/util"
 	"github.com/tidwall/gjson"
@@ -188,9 +189,10 @@ func setTstamps(e *SnowplowEvent, params map[string]interface{}) {
 	e.TrueTstamp = getTimeParam(params, "ttm")
 	e.CollectorTstamp = time.Now().UTC()
 	e.EtlTstamp = &now
-	if e.DvceCreatedTstamp != nil {
-		timeOnDevice := e.DvceSentTstamp.Sub(*e.DvceCreatedTstamp)
-		e.DerivedTstamp = e.CollectorTstamp.Add(-timeOnDevice)
+	e.DerivedTstamp = envelope.DeriveTimestamp(e.CollectorTstamp, e.DvceCreatedTstamp, e.DvceSentTstamp)
+	// Trackers send a true timestamp when they know the event time
+	if e.TrueTstamp != nil {
+		e.DerivedTstamp = *e.TrueTstamp
 	}
 }
 
@@ -217,6 +219,15 @@ func setUser(c *gin.Context, conf config.Middleware, e *SnowplowEvent, params ma
 	e.NetworkUserid = &identity
 	e.DomainUserid = getStringParam(params, "duid")
 	e.Userid = getStringParam(params, "uid")
+	if c.GetBool(constants.WITHHOLD_CLIENT_IP) {
+		return
+	}
+	// Trackers which proxy events can send the ip of the user
+	e.UserIpAddress = getStringParam(params, "ip")
+	if e.UserIpAddress == nil {
+		ip := c.ClientIP()
+		e.UserIpAddress = &ip
+	}
 }
 
 func setSession(e *SnowplowEvent, params map[string]interface{}) {
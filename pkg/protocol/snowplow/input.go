@@ -12,15 +12,24 @@ import (
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/constants"
 	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/input"
 	"github.com/silverton-io/buz/pkg/manifold"
 	"github.com/silverton-io/buz/pkg/meta"
 	"github.com/silverton-io/buz/pkg/middleware"
+	"github.com/silverton-io/buz/pkg/protocol"
 	"github.com/silverton-io/buz/pkg/response"
 )
 
 type SnowplowInput struct{}
 
+func (i *SnowplowInput) Name() string {
+	return protocol.SNOWPLOW
+}
+
 func (i *SnowplowInput) Initialize(routerGroup *gin.RouterGroup, manifold *manifold.Manifold, conf *config.Config, metadata *meta.CollectorMeta) error {
+	if err := envelope.ValidatePrivacy(conf.Inputs.Snowplow.Privacy); err != nil {
+		return err
+	}
 	identityMiddleware := middleware.Identity(conf.Identity)
 	log.Info().Msg("🟢 initializing snowplow input")
 	if conf.Inputs.Snowplow.Enabled {
@@ -54,8 +63,7 @@ func (i *SnowplowInput) Handler(m manifold.Manifold, conf config.Config, metadat
 		envelopes := i.EnvelopeBuilder(c, &conf, metadata)
 		err := m.Enqueue(envelopes)
 		if err != nil {
-			c.Header("Retry-After", response.RETRY_AFTER_60)
-			c.JSON(http.StatusServiceUnavailable, response.ManifoldDistributionError)
+			input.EnqueueFailed(c, err)
 		} else {
 			c.JSON(http.StatusOK, response.Ok)
 		}
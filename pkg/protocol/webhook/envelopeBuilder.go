@@ -38,5 +38,6 @@ func buildEnvelopesFromRequest(c *gin.Context, conf *config.Config, m *meta.Coll
 		n.Payload = evnt.Data
 		envelopes = append(envelopes, n)
 	}
-	return envelopes
+	envelope.Capture(c, conf.Webhook.Capture, envelopes)
+	return envelope.ApplyQuota(c, envelope.ApplyPrivacy(c, conf.Webhook.Privacy, protocol.WEBHOOK, envelopes))
 }
@@ -12,14 +12,23 @@ import (
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/constants"
 	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/input"
 	"github.com/silverton-io/buz/pkg/manifold"
 	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/protocol"
 	"github.com/silverton-io/buz/pkg/response"
 )
 
 type WebhookInput struct{}
 
+func (i *WebhookInput) Name() string {
+	return protocol.WEBHOOK
+}
+
 func (i *WebhookInput) Initialize(routerGroup *gin.RouterGroup, manifold *manifold.Manifold, conf *config.Config, metadata *meta.CollectorMeta) error {
+	if err := envelope.ValidatePrivacy(conf.Inputs.Webhook.Privacy); err != nil {
+		return err
+	}
 	if conf.Inputs.Webhook.Enabled {
 		log.Info().Msg("🟢 initializing webhook input")
 		routerGroup.POST(conf.Inputs.Webhook.Path, i.Handler(*manifold, *conf, metadata))
@@ -38,8 +47,7 @@ func (i *WebhookInput) Handler(m manifold.Manifold, conf config.Config, metadata
 			envelopes := i.EnvelopeBuilder(c, &conf, metadata)
 			err := m.Enqueue(envelopes)
 			if err != nil {
-				c.Header("Retry-After", response.RETRY_AFTER_60)
-				c.JSON(http.StatusServiceUnavailable, response.ManifoldDistributionError)
+				input.EnqueueFailed(c, err)
 			} else {
 				c.JSON(http.StatusOK, response.Ok)
 			}
@@ -5,18 +5,23 @@
 package registry
 
 import (
+	"context"
 	"errors"
 
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/backend/clickhousedb"
+	"github.com/silverton-io/buz/pkg/backend/embedded"
 	"github.com/silverton-io/buz/pkg/backend/file"
 	"github.com/silverton-io/buz/pkg/backend/gcs"
+	"github.com/silverton-io/buz/pkg/backend/git"
 	"github.com/silverton-io/buz/pkg/backend/http"
+	"github.com/silverton-io/buz/pkg/backend/kafka"
 	"github.com/silverton-io/buz/pkg/backend/minio"
 	"github.com/silverton-io/buz/pkg/backend/mongodb"
 	"github.com/silverton-io/buz/pkg/backend/mysqldb"
 	"github.com/silverton-io/buz/pkg/backend/postgresdb"
 	"github.com/silverton-io/buz/pkg/backend/s3"
+	"github.com/silverton-io/buz/pkg/backend/snapshot"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/constants"
 )
@@ -27,6 +32,31 @@ type SchemaCacheBackend interface {
 	Close()
 }
 
+// SchemaListingBackend is implemented by backends which are able to
+// enumerate all available schemas.
+type SchemaListingBackend interface {
+	ListRemote() (schemas []string, err error)
+}
+
+// SchemaWritingBackend is implemented by backends which schemas can be
+// published to at runtime.
+type SchemaWritingBackend interface {
+	PutRemote(schema string, contents []byte) error
+	DeleteRemote(schema string) error
+}
+
+// SchemaWatchingBackend is implemented by backends which are able to
+// notify the registry when schemas change.
+type SchemaWatchingBackend interface {
+	Watch(onChange func(schema string)) error
+}
+
+// HealthCheckingBackend is implemented by backends which are able to
+// verify they are reachable, for deep health checks.
+type HealthCheckingBackend interface {
+	Check(ctx context.Context) error
+}
+
 func BuildSchemaCacheBackend(conf config.Backend) (backend SchemaCacheBackend, err error) {
 	switch conf.Type {
 	case constants.GCS:
@@ -41,6 +71,15 @@ func BuildSchemaCacheBackend(conf config.Backend) (backend SchemaCacheBackend, e
 	case constants.FILE:
 		cacheBackend := file.RegistryBackend{}
 		return &cacheBackend, nil
+	case constants.EMBEDDED:
+		cacheBackend := embedded.RegistryBackend{}
+		return &cacheBackend, nil
+	case constants.SNAPSHOT:
+		cacheBackend := snapshot.RegistryBackend{}
+		return &cacheBackend, nil
+	case constants.GIT:
+		cacheBackend := git.RegistryBackend{}
+		return &cacheBackend, nil
 	case constants.HTTP:
 		cacheBackend := http.RegistryBackend{}
 		return &cacheBackend, nil
@@ -67,9 +106,11 @@ func BuildSchemaCacheBackend(conf config.Backend) (backend SchemaCacheBackend, e
 		log.Fatal().Stack().Err(e).Msg("iglu is unsupported")
 		return nil, e
 	case constants.KAFKA:
-		e := errors.New("the kafka schema registry cache backend is not yet available")
-		log.Fatal().Stack().Err(e).Msg("kafka schema registry is unsupported")
-		return nil, e
+		cacheBackend := kafka.RegistryBackend{}
+		return &cacheBackend, nil
+	case constants.CONFLUENT:
+		cacheBackend := kafka.RegistryBackend{}
+		return &cacheBackend, nil
 	default:
 		e := errors.New("unsupported schema cache backend: " + conf.Type)
 		log.Fatal().Stack().Err(e).Msg("🔴 unsupported backend")
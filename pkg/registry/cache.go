@@ -0,0 +1,332 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package registry
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	key        string
+	contents   []byte
+	source     string
+	cachedAt   time.Time
+	expiresAt  time.Time
+	hits       int64
+	recentHits int64
+}
+
+// CachedSchema describes a cache entry, for introspection
+type CachedSchema struct {
+	Schema     string     `json:"schema"`
+	Source     string     `json:"source"`
+	Hits       int64      `json:"hits"`
+	SizeBytes  int        `json:"sizeBytes"`
+	AgeSeconds float64    `json:"ageSeconds"`
+	CachedAt   time.Time  `json:"cachedAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+type CacheStats struct {
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Evictions   int64 `json:"evictions"`
+	Expirations int64 `json:"expirations"`
+	Entries     int   `json:"entries"`
+	SizeBytes   int   `json:"sizeBytes"`
+	// Lookups answered by a cached "schema not found" result
+	NegativeHits    int64 `json:"negativeHits"`
+	NegativeEntries int   `json:"negativeEntries"`
+}
+
+// The maximum number of missing schemas remembered when maxEntries is unset
+const DEFAULT_MAX_NEGATIVE_ENTRIES int = 10000
+
+// SchemaCache is an lru cache of schemas with per-entry ttl, bounded by
+// both the number of schemas and their total size.
+type SchemaCache struct {
+	mu           sync.Mutex
+	ttl          time.Duration
+	maxEntries   int
+	maxSizeBytes int
+	sizeBytes    int
+	ll           *list.List
+	items        map[string]*list.Element
+	now          func() time.Time
+	hits         int64
+	misses       int64
+	evictions    int64
+	expirations  int64
+	negativeTtl  time.Duration
+	missing      map[string]time.Time
+	negativeHits int64
+	nextExpiry   time.Time        // No entry expires before this
+	onExpire     func(key string) // Told of entries removed because they expired
+}
+
+// NewSchemaCache builds a cache. A zero ttl, maxEntries, or maxSizeBytes
+// disables the corresponding limit.
+func NewSchemaCache(ttlSeconds int, maxEntries int, maxSizeBytes int) *SchemaCache {
+	return &SchemaCache{
+		ttl:          time.Duration(ttlSeconds) * time.Second,
+		maxEntries:   maxEntries,
+		maxSizeBytes: maxSizeBytes,
+		ll:           list.New(),
+		items:        make(map[string]*list.Element),
+		missing:      make(map[string]time.Time),
+		now:          time.Now,
+	}
+}
+
+// SetNegativeTtl enables caching of "schema not found" results. A zero
+// ttl disables negative caching.
+func (c *SchemaCache) SetNegativeTtl(ttlSeconds int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.negativeTtl = time.Duration(ttlSeconds) * time.Second
+}
+
+// IsMissing returns true if the key was recently found to not exist
+func (c *SchemaCache) IsMissing(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt, ok := c.missing[key]
+	if !ok {
+		return false
+	}
+	if c.now().After(expiresAt) {
+		delete(c.missing, key)
+		return false
+	}
+	c.negativeHits++
+	return true
+}
+
+// SetMissing records that the key does not exist, until the negative ttl elapses
+func (c *SchemaCache) SetMissing(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.negativeTtl <= 0 {
+		return
+	}
+	now := c.now()
+	maxEntries := c.maxEntries
+	if maxEntries <= 0 {
+		maxEntries = DEFAULT_MAX_NEGATIVE_ENTRIES
+	}
+	if len(c.missing) >= maxEntries {
+		for k, expiresAt := range c.missing {
+			if now.After(expiresAt) {
+				delete(c.missing, k)
+			}
+		}
+		if len(c.missing) >= maxEntries {
+			// Too many distinct missing schemas - start over rather than grow unbounded
+			c.missing = make(map[string]time.Time)
+		}
+	}
+	c.missing[key] = now.Add(c.negativeTtl)
+}
+
+// SetExpiryListener sets the listener of entries which expire
+func (c *SchemaCache) SetExpiryListener(l func(key string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onExpire = l
+}
+
+func (c *SchemaCache) Get(key string) (contents []byte, ok bool) {
+	c.mu.Lock()
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if c.ttl > 0 && c.now().After(entry.expiresAt) {
+		c.removeElement(el)
+		c.misses++
+		c.expirations++
+		onExpire := c.onExpire
+		c.mu.Unlock()
+		// The listener is told outside the lock, as it may need to load schemas
+		if onExpire != nil {
+			onExpire(key)
+		}
+		return nil, false
+	}
+	c.hits++
+	entry.hits++
+	entry.recentHits++
+	c.ll.MoveToFront(el)
+	c.mu.Unlock()
+	return entry.contents, true
+}
+
+// Expire removes every expired entry, so the listener is told of entries
+// which expired without being looked up again. The cache is only scanned
+// once the earliest entry has expired.
+func (c *SchemaCache) Expire() {
+	c.mu.Lock()
+	now := c.now()
+	if c.ttl <= 0 || now.Before(c.nextExpiry) {
+		c.mu.Unlock()
+		return
+	}
+	var expired []string
+	next := now.Add(c.ttl)
+	for el := c.ll.Front(); el != nil; {
+		prev := el
+		el = el.Next()
+		entry := prev.Value.(*cacheEntry)
+		if now.After(entry.expiresAt) {
+			expired = append(expired, entry.key)
+			c.removeElement(prev)
+			c.expirations++
+		} else if entry.expiresAt.Before(next) {
+			next = entry.expiresAt
+		}
+	}
+	c.nextExpiry = next
+	onExpire := c.onExpire
+	c.mu.Unlock()
+	if onExpire != nil {
+		for _, key := range expired {
+			onExpire(key)
+		}
+	}
+}
+
+// Set caches the schema, resetting its ttl. Hit counts are retained
+// when an existing entry is replaced. It returns true if the contents of
+// an existing entry changed.
+func (c *SchemaCache) Set(key string, contents []byte, source string) (changed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		changed = !bytes.Equal(entry.contents, contents)
+		c.sizeBytes += len(contents) - len(entry.contents)
+		entry.contents, entry.source = contents, source
+		entry.cachedAt, entry.expiresAt = now, now.Add(c.ttl)
+		c.ll.MoveToFront(el)
+	} else {
+		entry := &cacheEntry{
+			key:       key,
+			contents:  contents,
+			source:    source,
+			cachedAt:  now,
+			expiresAt: now.Add(c.ttl),
+		}
+		c.items[key] = c.ll.PushFront(entry)
+		c.sizeBytes += len(contents)
+	}
+	c.evict()
+	return changed
+}
+
+// evict removes least-recently-used entries until the cache is within bounds
+func (c *SchemaCache) evict() {
+	for c.ll.Len() > 1 {
+		overCount := c.maxEntries > 0 && c.ll.Len() > c.maxEntries
+		overSize := c.maxSizeBytes > 0 && c.sizeBytes > c.maxSizeBytes
+		if !overCount && !overSize {
+			return
+		}
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+func (c *SchemaCache) removeElement(el *list.Element) {
+	entry := c.ll.Remove(el).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.sizeBytes -= len(entry.contents)
+}
+
+func (c *SchemaCache) Del(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.missing, key)
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *SchemaCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.missing = make(map[string]time.Time)
+	c.sizeBytes = 0
+}
+
+func (c *SchemaCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Hot returns the keys of entries hit at least minHits times since the
+// previous call, and resets the recent hit count of every entry.
+func (c *SchemaCache) Hot(minHits int64) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []string
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*cacheEntry)
+		if entry.recentHits >= minHits {
+			keys = append(keys, entry.key)
+		}
+		entry.recentHits = 0
+	}
+	return keys
+}
+
+// Snapshot describes every entry in the cache, most-recently used first
+func (c *SchemaCache) Snapshot() []CachedSchema {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	schemas := make([]CachedSchema, 0, c.ll.Len())
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*cacheEntry)
+		s := CachedSchema{
+			Schema:     entry.key,
+			Source:     entry.source,
+			Hits:       entry.hits,
+			SizeBytes:  len(entry.contents),
+			AgeSeconds: now.Sub(entry.cachedAt).Seconds(),
+			CachedAt:   entry.cachedAt,
+		}
+		if c.ttl > 0 {
+			expiresAt := entry.expiresAt
+			s.ExpiresAt = &expiresAt
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas
+}
+
+func (c *SchemaCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:            c.hits,
+		Misses:          c.misses,
+		Evictions:       c.evictions,
+		Expirations:     c.expirations,
+		Entries:         c.ll.Len(),
+		SizeBytes:       c.sizeBytes,
+		NegativeHits:    c.negativeHits,
+		NegativeEntries: len(c.missing),
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaCacheTtl(t *testing.T) {
+	now := time.Now()
+	c := NewSchemaCache(10, 0, 0)
+	c.now = func() time.Time { return now }
+	c.Set("a", []byte("a"), "file")
+
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	now = now.Add(11 * time.Second)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestSchemaCacheExpire(t *testing.T) {
+	now := time.Now()
+	c := NewSchemaCache(10, 0, 0)
+	c.now = func() time.Time { return now }
+	var expired []string
+	c.SetExpiryListener(func(key string) { expired = append(expired, key) })
+	c.Set("a", []byte("a"), "file")
+	now = now.Add(5 * time.Second)
+	c.Set("b", []byte("b"), "file")
+
+	c.Expire()
+	assert.Empty(t, expired)
+	now = now.Add(6 * time.Second)
+	c.Expire()
+	assert.Equal(t, []string{"a"}, expired)
+	now = now.Add(5 * time.Second)
+	_, ok := c.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"a", "b"}, expired)
+	assert.Equal(t, int64(2), c.Stats().Expirations)
+}
+
+func TestSchemaCacheLruEviction(t *testing.T) {
+	c := NewSchemaCache(0, 2, 0)
+	c.Set("a", []byte("a"), "file")
+	c.Set("b", []byte("b"), "file")
+	c.Get("a") // b is now least-recently used
+	c.Set("c", []byte("c"), "file")
+
+	_, ok := c.Get("b")
+	assert.False(t, ok)
+	_, ok = c.Get("a")
+	assert.True(t, ok)
+	_, ok = c.Get("c")
+	assert.True(t, ok)
+}
+
+func TestSchemaCacheSizeEviction(t *testing.T) {
+	c := NewSchemaCache(0, 0, 5)
+	c.Set("a", []byte("aaa"), "file")
+	c.Set("b", []byte("bbb"), "file")
+
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+	assert.Equal(t, 3, c.sizeBytes)
+}
+
+func TestSchemaCacheHot(t *testing.T) {
+	c := NewSchemaCache(0, 0, 0)
+	c.Set("a", []byte("a"), "file")
+	c.Set("b", []byte("b"), "file")
+	c.Get("a")
+	c.Get("a")
+	c.Get("b")
+
+	assert.Equal(t, []string{"a"}, c.Hot(2))
+	// Recent hits are reset after each call
+	assert.Empty(t, c.Hot(1))
+}
+
+func TestSchemaCacheStats(t *testing.T) {
+	c := NewSchemaCache(0, 1, 0)
+	c.Set("a", []byte("aa"), "file")
+	c.Get("a")
+	c.Get("b")
+	c.Set("b", []byte("b"), "gcs")
+
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1, Evictions: 1, Entries: 1, SizeBytes: 1}, c.Stats())
+	snapshot := c.Snapshot()
+	assert.Len(t, snapshot, 1)
+	assert.Equal(t, "b", snapshot[0].Schema)
+	assert.Equal(t, "gcs", snapshot[0].Source)
+	assert.Nil(t, snapshot[0].ExpiresAt)
+}
+
+func TestSchemaCacheNegative(t *testing.T) {
+	c := NewSchemaCache(0, 2, 0)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.SetMissing("a")
+	assert.False(t, c.IsMissing("a"), "negative caching is disabled by default")
+
+	c.SetNegativeTtl(5)
+	c.SetMissing("a")
+	assert.True(t, c.IsMissing("a"))
+	assert.True(t, c.IsMissing("a"))
+	assert.Equal(t, int64(2), c.Stats().NegativeHits)
+
+	// Deleting (such as when a schema is published) forgets the missing result
+	c.Del("a")
+	assert.False(t, c.IsMissing("a"))
+
+	c.SetMissing("a")
+	now = now.Add(6 * time.Second)
+	assert.False(t, c.IsMissing("a"))
+
+	// Missing results are bounded by maxEntries
+	c.SetMissing("b")
+	c.SetMissing("c")
+	c.SetMissing("d")
+	assert.LessOrEqual(t, c.Stats().NegativeEntries, 2)
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+var ErrSchemaNotFound = errors.New("schema not found in any registry backend")
+
+type chainLink struct {
+	name           string
+	priority       int
+	vendorPrefixes []string
+	backend        SchemaCacheBackend
+}
+
+// handles returns true if the backend should be consulted for the schema
+func (l *chainLink) handles(schema string) bool {
+	if len(l.vendorPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range l.vendorPrefixes {
+		if strings.HasPrefix(schema, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChainBackend resolves schemas from multiple backends, consulting each
+// in ascending priority order until the schema is found.
+type ChainBackend struct {
+	links []chainLink
+}
+
+func backendName(conf config.Backend) string {
+	if conf.Name != "" {
+		return conf.Name
+	}
+	return conf.Type
+}
+
+// Initialize is not supported for chains - use BuildChainBackend
+func (b *ChainBackend) Initialize(conf config.Backend) error {
+	return errors.New("chain backends must be built with BuildChainBackend")
+}
+
+// BuildChainBackend builds and initializes all configured backends. Backends
+// with equal priority are consulted in the order they are configured.
+func BuildChainBackend(confs []config.Backend) (*ChainBackend, error) {
+	chain := ChainBackend{}
+	for _, conf := range confs {
+		backend, err := BuildSchemaCacheBackend(conf)
+		if err != nil {
+			return nil, err
+		}
+		if err := InitializeSchemaCacheBackend(conf, backend); err != nil {
+			return nil, err
+		}
+		chain.links = append(chain.links, chainLink{
+			name:           backendName(conf),
+			priority:       conf.Priority,
+			vendorPrefixes: conf.VendorPrefixes,
+			backend:        backend,
+		})
+	}
+	sort.SliceStable(chain.links, func(i, j int) bool {
+		return chain.links[i].priority < chain.links[j].priority
+	})
+	return &chain, nil
+}
+
+// GetRemoteFrom returns the schema along with the name of the backend which
+// served it. If no backend has the schema, and any backend failed, the last
+// failure is returned instead of ErrSchemaNotFound.
+func (b *ChainBackend) GetRemoteFrom(schema string) (contents []byte, source string, err error) {
+	var failed error
+	for _, link := range b.links {
+		if !link.handles(schema) {
+			continue
+		}
+		contents, err := link.backend.GetRemote(schema)
+		if err == nil {
+			return contents, link.name, nil
+		}
+		log.Debug().Err(err).Msg("🟡 " + link.name + " registry backend could not serve " + schema)
+		if !notFound(err) {
+			failed = fmt.Errorf("%s registry backend: %w", link.name, err)
+		}
+	}
+	if failed != nil {
+		return nil, "", failed
+	}
+	return nil, "", ErrSchemaNotFound
+}
+
+func (b *ChainBackend) GetRemote(schema string) (contents []byte, err error) {
+	contents, _, err = b.GetRemoteFrom(schema)
+	return contents, err
+}
+
+// ListRemote returns the deduplicated union of schemas from all listable backends
+func (b *ChainBackend) ListRemote() (schemas []string, err error) {
+	seen := make(map[string]bool)
+	for _, link := range b.links {
+		lister, ok := link.backend.(SchemaListingBackend)
+		if !ok {
+			continue
+		}
+		s, err := lister.ListRemote()
+		if err != nil {
+			return nil, err
+		}
+		for _, schema := range s {
+			if !seen[schema] && link.handles(schema) {
+				seen[schema] = true
+				schemas = append(schemas, schema)
+			}
+		}
+	}
+	sort.Strings(schemas)
+	return schemas, nil
+}
+
+// PutRemote publishes the schema to the highest-priority writable backend
+func (b *ChainBackend) PutRemote(schema string, contents []byte) error {
+	for _, link := range b.links {
+		writer, ok := link.backend.(SchemaWritingBackend)
+		if ok && link.handles(schema) {
+			return writer.PutRemote(schema, contents)
+		}
+	}
+	return ErrPublishUnsupported
+}
+
+// DeleteRemote deletes the schema from the highest-priority writable backend
+func (b *ChainBackend) DeleteRemote(schema string) error {
+	for _, link := range b.links {
+		writer, ok := link.backend.(SchemaWritingBackend)
+		if ok && link.handles(schema) {
+			return writer.DeleteRemote(schema)
+		}
+	}
+	return ErrPublishUnsupported
+}
+
+// Watch watches every watchable backend in the chain
+func (b *ChainBackend) Watch(onChange func(schema string)) error {
+	for _, link := range b.links {
+		if watcher, ok := link.backend.(SchemaWatchingBackend); ok {
+			if err := watcher.Watch(onChange); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *ChainBackend) Close() {
+	for _, link := range b.links {
+		link.backend.Close()
+	}
+}
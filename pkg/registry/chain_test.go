@@ -0,0 +1,107 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package registry
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainBackend(t *testing.T) {
+	embedded, err := BuildChainBackend([]config.Backend{
+		{Name: "remote", Type: "file", Path: "/nonexistent", Priority: 10},
+		{Name: "builtin", Type: "embedded", Priority: 1, VendorPrefixes: []string{"io.silverton/"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "builtin", embedded.links[0].name)
+
+	remote := &memoryBackend{}
+	remote.Initialize(config.Backend{})
+	remote.PutRemote("com.acme/thing/v1.0.json", []byte(`{"acme":true}`))
+	remote.PutRemote("io.silverton/buz/hook/arbitrary/v1.0.json", []byte(`{"shadowed":true}`))
+	embedded.links[1].backend = remote
+
+	r := Registry{Cache: NewSchemaCache(0, 0, 0), Backend: embedded}
+
+	// Embedded schemas are served first
+	exists, contents := r.Get("io.silverton/buz/hook/arbitrary/v1.0")
+	assert.True(t, exists)
+	assert.NotContains(t, string(contents), "shadowed")
+
+	// Schemas outside of the embedded vendor prefixes fall through to remote
+	exists, contents = r.Get("com.acme/thing/v1.0")
+	assert.True(t, exists)
+	assert.Equal(t, []byte(`{"acme":true}`), contents)
+
+	exists, _ = r.Get("com.acme/missing/v1.0")
+	assert.False(t, exists)
+
+	assert.Equal(t, map[string]int64{"builtin": 1, "remote": 1}, r.BackendStats())
+}
+
+type unavailableBackend struct{ memoryBackend }
+
+func (b *unavailableBackend) GetRemote(schema string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestRegistryReportsOnlyBackendFailures(t *testing.T) {
+	var reported []string
+	SetErrorListener(func(schema string, err error) {
+		reported = append(reported, schema)
+	})
+	defer SetErrorListener(nil)
+
+	chain, err := BuildChainBackend([]config.Backend{
+		{Name: "remote", Type: "file", Path: "/nonexistent"},
+	})
+	assert.Nil(t, err)
+	memory := &memoryBackend{}
+	memory.Initialize(config.Backend{})
+	chain.links = append(chain.links, chainLink{name: "memory", backend: memory})
+	r := Registry{Cache: NewSchemaCache(0, 0, 0), Backend: chain}
+	exists, _ := r.Get("com.acme/missing/v1.0.json")
+	assert.False(t, exists)
+	assert.Empty(t, reported)
+
+	chain.links = append(chain.links, chainLink{name: "unavailable", backend: &unavailableBackend{}})
+	exists, _ = r.Get("com.acme/unreachable/v1.0.json")
+	assert.False(t, exists)
+	assert.Equal(t, []string{"com.acme/unreachable/v1.0.json"}, reported)
+}
+
+// flakyBackend is unavailable while down
+type flakyBackend struct {
+	memoryBackend
+	down bool
+}
+
+func (b *flakyBackend) GetRemote(schema string) ([]byte, error) {
+	if b.down {
+		return nil, errors.New("503 service unavailable")
+	}
+	return b.memoryBackend.GetRemote(schema)
+}
+
+func TestRegistryNegativelyCachesOnlyMissingSchemas(t *testing.T) {
+	backend := &flakyBackend{down: true}
+	backend.Initialize(config.Backend{})
+	backend.PutRemote("com.acme/thing/v1.0.json", []byte(`{"acme":true}`))
+	r := Registry{Cache: NewSchemaCache(0, 0, 0), Backend: backend}
+	r.Cache.SetNegativeTtl(60)
+
+	exists, _ := r.Get("com.acme/thing/v1.0.json")
+	assert.False(t, exists)
+	backend.down = false
+	exists, _ = r.Get("com.acme/thing/v1.0.json")
+	assert.True(t, exists, "backend failures are not cached as missing")
+
+	exists, _ = r.Get("com.acme/missing/v1.0.json")
+	assert.False(t, exists)
+	assert.True(t, r.Cache.IsMissing("com.acme/missing/v1.0.json"))
+}
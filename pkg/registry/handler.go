@@ -5,24 +5,90 @@
 package registry
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/compat"
+	"github.com/silverton-io/buz/pkg/compiler"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/docs"
+	"github.com/silverton-io/buz/pkg/lint"
 	"github.com/silverton-io/buz/pkg/response"
+	"github.com/silverton-io/buz/pkg/snapshot"
 	"github.com/tidwall/gjson"
 )
 
 func PurgeCacheHandler(r *Registry) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		log.Debug().Msg("🟡 schema cache purged")
-		r.Cache.Clear()
+		r.Purge()
 		c.JSON(200, response.CachePurged)
 	}
 	return gin.HandlerFunc(fn)
 }
 
+func listSchemas(c *gin.Context, r *Registry) {
+	supported, schemas, err := r.List()
+	if !supported {
+		c.JSON(http.StatusNotImplemented, response.SchemaListingUnsupported)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not list schemas")
+		c.JSON(http.StatusInternalServerError, response.SchemaListingFailed)
+		return
+	}
+	c.JSON(http.StatusOK, schemas)
+}
+
+func BackendStatsHandler(r *Registry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"served": r.BackendStats()})
+	}
+	return gin.HandlerFunc(fn)
+}
+
+type CompiledStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+}
+
+type CacheOverviewResponse struct {
+	Stats    CacheStats       `json:"stats"`
+	Compiled CompiledStats    `json:"compiled"`
+	Backends map[string]int64 `json:"backends"`
+	Schemas  []CachedSchema   `json:"schemas"`
+}
+
+// CacheOverviewHandler lists every cached schema along with cache statistics
+func CacheOverviewHandler(r *Registry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		resp := CacheOverviewResponse{
+			Stats:    r.Cache.Stats(),
+			Backends: r.BackendStats(),
+			Schemas:  r.Cache.Snapshot(),
+		}
+		if r.Compiled != nil {
+			resp.Compiled.Hits, resp.Compiled.Misses = r.Compiled.Stats()
+			resp.Compiled.Entries = r.Compiled.Len()
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+	return gin.HandlerFunc(fn)
+}
+
 func GetSchemaHandler(r *Registry) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		schemaName := c.Param(SCHEMA_PARAM)[1:]
+		if schemaName == "" {
+			listSchemas(c, r)
+			return
+		}
 		exists, schemaContents := r.Get(schemaName)
 		if !exists {
 			c.JSON(404, response.SchemaNotAvailable)
@@ -34,3 +100,276 @@ func GetSchemaHandler(r *Registry) gin.HandlerFunc {
 	}
 	return gin.HandlerFunc(fn)
 }
+
+func publishSchema(c *gin.Context, r *Registry, replace bool) {
+	schemaName := c.Param(SCHEMA_PARAM)[1:]
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil || schemaName == "" {
+		c.JSON(http.StatusBadRequest, response.BadRequest)
+		return
+	}
+	if _, err := compiler.CompileWith(body, r.Load); err != nil {
+		log.Debug().Err(err).Msg("🟡 refusing to publish invalid schema " + schemaName)
+		c.JSON(http.StatusBadRequest, response.InvalidSchema)
+		return
+	}
+	if !replace {
+		if _, err := r.Backend.GetRemote(schemaKey(schemaName)); err == nil {
+			c.JSON(http.StatusConflict, response.SchemaAlreadyExists)
+			return
+		}
+	}
+	err = r.Put(schemaName, body)
+	if errors.Is(err, ErrPublishUnsupported) {
+		c.JSON(http.StatusNotImplemented, response.SchemaPublishingUnsupported)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response.SchemaPublishingFailed)
+		return
+	}
+	audit(c, "publish", schemaName)
+	c.JSON(http.StatusCreated, response.SchemaPublished)
+}
+
+// audit records who changed which schema
+func audit(c *gin.Context, action string, schemaName string) {
+	log.Info().
+		Str("audit", "registry").
+		Str("action", action).
+		Str("schema", schemaKey(schemaName)).
+		Str("actor", c.GetString(constants.AUTH_IDENTITY)).
+		Str("ip", c.ClientIP()).
+		Msg("🟢 schema registry changed")
+}
+
+// updateSchemaState deprecates, supersedes, or reactivates an existing schema
+func updateSchemaState(c *gin.Context, r *Registry, state string) {
+	schemaName := c.Param(SCHEMA_PARAM)[1:]
+	supersededBy := c.Query(SUPERSEDED_BY_PARAM)
+	err := r.SetState(schemaName, state, supersededBy)
+	switch {
+	case err == nil:
+		audit(c, state, schemaName)
+		c.JSON(http.StatusOK, response.SchemaStateUpdated)
+	case errors.Is(err, ErrInvalidState), errors.Is(err, ErrSuccessorNotFound):
+		c.JSON(http.StatusBadRequest, response.InvalidSchemaState)
+	case errors.Is(err, ErrSchemaNotFound):
+		c.JSON(http.StatusNotFound, response.SchemaNotAvailable)
+	case errors.Is(err, ErrPublishUnsupported):
+		c.JSON(http.StatusNotImplemented, response.SchemaPublishingUnsupported)
+	default:
+		c.JSON(http.StatusInternalServerError, response.SchemaPublishingFailed)
+	}
+}
+
+// CreateSchemaHandler publishes a new schema, refusing to overwrite
+// a schema which already exists. If the `state` query parameter is present
+// the existing schema is instead deprecated, superseded, or reactivated.
+func CreateSchemaHandler(r *Registry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		if state := c.Query(STATE_PARAM); state != "" {
+			updateSchemaState(c, r, state)
+			return
+		}
+		publishSchema(c, r, false)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// PutSchemaHandler publishes a schema, replacing it if it already exists.
+func PutSchemaHandler(r *Registry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		publishSchema(c, r, true)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// DeleteSchemaHandler removes a schema from the registry backend.
+func DeleteSchemaHandler(r *Registry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		schemaName := c.Param(SCHEMA_PARAM)[1:]
+		if schemaName == "" {
+			c.JSON(http.StatusBadRequest, response.BadRequest)
+			return
+		}
+		if _, _, err := r.getRemote(schemaKey(schemaName)); err != nil {
+			c.JSON(http.StatusNotFound, response.SchemaNotAvailable)
+			return
+		}
+		err := r.Delete(schemaName)
+		if errors.Is(err, ErrPublishUnsupported) {
+			c.JSON(http.StatusNotImplemented, response.SchemaPublishingUnsupported)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, response.SchemaPublishingFailed)
+			return
+		}
+		audit(c, "delete", schemaName)
+		c.JSON(http.StatusOK, response.SchemaDeleted)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+type LintResponse struct {
+	Valid  bool             `json:"valid"`
+	Errors []lint.LintError `json:"errors"`
+}
+
+// LintSchemaHandler lints the candidate schema in the request body. The
+// schema name is optional, and is checked against the schema's metadata.
+func LintSchemaHandler(r *Registry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, response.BadRequest)
+			return
+		}
+		errs := lint.Lint(c.Param(SCHEMA_PARAM)[1:], body, r.Load)
+		if errs == nil {
+			errs = []lint.LintError{}
+		}
+		c.JSON(http.StatusOK, LintResponse{Valid: len(errs) == 0, Errors: errs})
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// DocsHandler serves html documentation of every registry schema, or json
+// documentation of a single schema if the `format=json` query parameter is present.
+func DocsHandler(r *Registry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		schemaName := c.Param(SCHEMA_PARAM)[1:]
+		var page bytes.Buffer
+		if schemaName == "" {
+			supported, schemas, err := r.List()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, response.SchemaListingFailed)
+				return
+			}
+			if !supported {
+				// Document what has been seen, as the backend can't be listed
+				for _, s := range r.Cache.Snapshot() {
+					schemas = append(schemas, s.Schema)
+				}
+			}
+			if err := docs.RenderIndex(&page, schemas); err != nil {
+				log.Error().Err(err).Msg("🔴 could not render schema index")
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+			c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+			return
+		}
+		exists, contents := r.Get(schemaName)
+		if !exists {
+			c.JSON(http.StatusNotFound, response.SchemaNotAvailable)
+			return
+		}
+		compiled, err := r.Compile(contents)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, response.InvalidSchema)
+			return
+		}
+		d := docs.Build(schemaName, compiled, contents)
+		if c.Query("format") == "json" {
+			c.JSON(http.StatusOK, d)
+			return
+		}
+		if err := docs.RenderSchema(&page, d, DOCS_ROUTE); err != nil {
+			log.Error().Err(err).Msg("🔴 could not render schema documentation")
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// CompatibilityHandler reports the compatibility of the proposed schema in
+// the request body with the current version of the named schema. If the
+// proposed schema is incompatible according to the `mode` query parameter
+// (backward, forward, or full - backward by default) a 409 is returned.
+func CompatibilityHandler(r *Registry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		schemaName := c.Param(SCHEMA_PARAM)[1:]
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil || schemaName == "" {
+			c.JSON(http.StatusBadRequest, response.BadRequest)
+			return
+		}
+		proposed, err := compiler.CompileWith(body, r.Load)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, response.InvalidSchema)
+			return
+		}
+		exists, contents := r.Get(schemaName)
+		if !exists {
+			c.JSON(http.StatusNotFound, response.SchemaNotAvailable)
+			return
+		}
+		current, err := r.Compile(contents)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, response.InvalidSchema)
+			return
+		}
+		report := compat.Check(docs.Build(schemaName, current, contents), docs.Build(schemaName, proposed, body))
+		mode := c.DefaultQuery("mode", compat.BACKWARD)
+		if !report.Compatible(mode) {
+			c.JSON(http.StatusConflict, report)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// ExportSnapshotHandler serves every registry schema as a gzipped tarball
+func ExportSnapshotHandler(r *Registry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		schemas, err := r.Export()
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not export schemas")
+			c.JSON(http.StatusInternalServerError, response.SchemaListingFailed)
+			return
+		}
+		var archive bytes.Buffer
+		if err := snapshot.Write(&archive, schemas); err != nil {
+			log.Error().Err(err).Msg("🔴 could not write schema snapshot")
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="schemas.tar.gz"`)
+		c.Data(http.StatusOK, "application/gzip", archive.Bytes())
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// ImportSnapshotHandler publishes every schema in the gzipped tarball
+// in the request body.
+func ImportSnapshotHandler(r *Registry) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		schemas, err := snapshot.Read(c.Request.Body)
+		if err != nil {
+			log.Debug().Err(err).Msg("🟡 refusing to import invalid snapshot")
+			c.JSON(http.StatusBadRequest, response.InvalidSnapshot)
+			return
+		}
+		err = r.Import(schemas)
+		switch {
+		case err == nil:
+			for name := range schemas {
+				audit(c, "import", name)
+			}
+			c.JSON(http.StatusCreated, response.SnapshotImported)
+		case errors.Is(err, ErrPublishUnsupported):
+			c.JSON(http.StatusNotImplemented, response.SchemaPublishingUnsupported)
+		case errors.Is(err, ErrInvalidSchema):
+			log.Debug().Err(err).Msg("🟡 refusing to import snapshot")
+			c.JSON(http.StatusBadRequest, response.InvalidSchema)
+		default:
+			c.JSON(http.StatusInternalServerError, response.SchemaPublishingFailed)
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -1 +1,225 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
 package registry
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryBackend is an in-memory, writable schema cache backend
+type memoryBackend struct {
+	schemas map[string][]byte
+}
+
+func (b *memoryBackend) Initialize(conf config.Backend) error {
+	b.schemas = make(map[string][]byte)
+	return nil
+}
+
+func (b *memoryBackend) GetRemote(schema string) ([]byte, error) {
+	contents, ok := b.schemas[schema]
+	if !ok {
+		return nil, backendutils.SchemaNotFound(schema, errors.New("not in memory"))
+	}
+	return contents, nil
+}
+
+func (b *memoryBackend) PutRemote(schema string, contents []byte) error {
+	b.schemas[schema] = contents
+	return nil
+}
+
+func (b *memoryBackend) DeleteRemote(schema string) error {
+	delete(b.schemas, schema)
+	return nil
+}
+
+func (b *memoryBackend) ListRemote() ([]string, error) {
+	var schemas []string
+	for schema := range b.schemas {
+		schemas = append(schemas, schema)
+	}
+	sort.Strings(schemas)
+	return schemas, nil
+}
+
+func (b *memoryBackend) Close() {}
+
+func testRegistry() *Registry {
+	b := &memoryBackend{}
+	b.Initialize(config.Backend{})
+	return &Registry{
+		Cache:   NewSchemaCache(0, 0, 0),
+		Backend: b,
+	}
+}
+
+func testRouter(r *Registry) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.GET(SCHEMAS_ROUTE+"*"+SCHEMA_PARAM, GetSchemaHandler(r))
+	e.POST(SCHEMAS_ROUTE+"*"+SCHEMA_PARAM, CreateSchemaHandler(r))
+	e.PUT(SCHEMAS_ROUTE+"*"+SCHEMA_PARAM, PutSchemaHandler(r))
+	e.DELETE(SCHEMAS_ROUTE+"*"+SCHEMA_PARAM, DeleteSchemaHandler(r))
+	e.POST(LINT_ROUTE+"*"+SCHEMA_PARAM, LintSchemaHandler(r))
+	return e
+}
+
+func do(e *gin.Engine, method string, path string, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPublishSchemaHandlers(t *testing.T) {
+	r := testRegistry()
+	e := testRouter(r)
+	path := SCHEMAS_ROUTE + "io.silverton/test/v1.0.json"
+
+	rec := do(e, http.MethodPost, path, `{"type": "object"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = do(e, http.MethodPost, path, `{"type": "object"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = do(e, http.MethodPut, path, `{"type": "object"`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(e, http.MethodGet, path, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"type": "object"}`, rec.Body.String())
+
+	// Replacing a schema evicts the cached copy
+	rec = do(e, http.MethodPut, path, `{"type": "string"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = do(e, http.MethodGet, path, "")
+	assert.JSONEq(t, `{"type": "string"}`, rec.Body.String())
+}
+
+func TestSchemaStateHandlers(t *testing.T) {
+	r := testRegistry()
+	e := testRouter(r)
+	v1 := SCHEMAS_ROUTE + "io.silverton/test/v1.0.json"
+	v2 := SCHEMAS_ROUTE + "io.silverton/test/v1.1.json"
+	do(e, http.MethodPost, v1, `{"type": "object"}`)
+
+	rec := do(e, http.MethodPost, v1+"?state=deprecated", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = do(e, http.MethodGet, v1, "")
+	assert.JSONEq(t, `{"type": "object", "deprecated": true}`, rec.Body.String())
+
+	rec = do(e, http.MethodPost, v1+"?state=superseded&supersededBy=io.silverton/test/v1.1", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	do(e, http.MethodPost, v2, `{"type": "object"}`)
+	rec = do(e, http.MethodPost, v1+"?state=superseded&supersededBy=io.silverton/test/v1.1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = do(e, http.MethodGet, v1, "")
+	assert.JSONEq(t, `{"type": "object", "deprecated": true, "supersededBy": "io.silverton/test/v1.1.json"}`, rec.Body.String())
+
+	rec = do(e, http.MethodPost, v1+"?state=active", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = do(e, http.MethodGet, v1, "")
+	assert.JSONEq(t, `{"type": "object"}`, rec.Body.String())
+
+	rec = do(e, http.MethodPost, v1+"?state=bogus", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = do(e, http.MethodPost, SCHEMAS_ROUTE+"io.silverton/missing/v1.0.json?state=deprecated", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do(e, http.MethodDelete, v1, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = do(e, http.MethodGet, v1, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = do(e, http.MethodDelete, v1, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestLintSchemaHandler(t *testing.T) {
+	e := testRouter(testRegistry())
+	schema := `{"description": "d", "self": {"vendor": "com.acme", "namespace": "web", "version": "1.0"}}`
+
+	rec := do(e, http.MethodPost, LINT_ROUTE, schema)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"valid": true, "errors": []}`, rec.Body.String())
+
+	rec = do(e, http.MethodPost, LINT_ROUTE+"com.acme/web/v2.0.json", schema)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"valid":false`)
+	assert.Contains(t, rec.Body.String(), `"rule":"naming"`)
+}
+
+func TestDocsHandler(t *testing.T) {
+	r := testRegistry()
+	r.Backend.(*memoryBackend).PutRemote("com.acme/order/v1.0.json", []byte(`{"description": "An order", "properties": {"id": {"type": "string"}}}`))
+	e := testRouter(r)
+	e.GET(DOCS_ROUTE+"*"+SCHEMA_PARAM, DocsHandler(r))
+
+	rec := do(e, http.MethodGet, DOCS_ROUTE, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<a href="com.acme/order/v1.0.json">`)
+
+	rec = do(e, http.MethodGet, DOCS_ROUTE+"com.acme/order/v1.0.json", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "An order")
+
+	rec = do(e, http.MethodGet, DOCS_ROUTE+"com.acme/order/v1.0.json?format=json", "")
+	assert.Contains(t, rec.Body.String(), `"path":"id"`)
+
+	rec = do(e, http.MethodGet, DOCS_ROUTE+"com.acme/missing/v1.0.json", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestCompatibilityHandler(t *testing.T) {
+	r := testRegistry()
+	r.Backend.(*memoryBackend).PutRemote("com.acme/order/v1.0.json", []byte(`{"properties": {"id": {"type": "string"}}, "required": ["id"]}`))
+	e := testRouter(r)
+	e.POST(COMPAT_ROUTE+"*"+SCHEMA_PARAM, CompatibilityHandler(r))
+	path := COMPAT_ROUTE + "com.acme/order/v1.0.json"
+
+	rec := do(e, http.MethodPost, path, `{"properties": {"id": {"type": "string"}, "note": {"type": "string"}}, "required": ["id"]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"change":"fieldAdded"`)
+
+	proposed := `{"properties": {"id": {"type": "string"}, "note": {"type": "string"}}, "required": ["id", "note"]}`
+	rec = do(e, http.MethodPost, path, proposed)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = do(e, http.MethodPost, path+"?mode=forward", proposed)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = do(e, http.MethodPost, COMPAT_ROUTE+"com.acme/missing/v1.0.json", proposed)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestSnapshotHandlers(t *testing.T) {
+	source := testRegistry()
+	source.Backend.(*memoryBackend).PutRemote("com.acme/address/v1.0.json", []byte(`{"type": "object"}`))
+	source.Backend.(*memoryBackend).PutRemote("com.acme/order/v1.0.json", []byte(`{"properties": {"shipping": {"$ref": "/s/com.acme/address/v1.0.json"}}}`))
+	e := testRouter(source)
+	e.GET(SNAPSHOT_ROUTE, ExportSnapshotHandler(source))
+	rec := do(e, http.MethodGet, SNAPSHOT_ROUTE, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	archive := rec.Body.String()
+
+	target := testRegistry()
+	e = testRouter(target)
+	e.POST(SNAPSHOT_ROUTE, ImportSnapshotHandler(target))
+	rec = do(e, http.MethodPost, SNAPSHOT_ROUTE, archive)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, source.Backend.(*memoryBackend).schemas, target.Backend.(*memoryBackend).schemas)
+
+	rec = do(e, http.MethodPost, SNAPSHOT_ROUTE, "not a tarball")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
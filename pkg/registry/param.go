@@ -5,7 +5,15 @@
 package registry
 
 const (
-	SCHEMAS_ROUTE     = "/s/"
-	CACHE_PURGE_ROUTE = "/c/purge"
-	SCHEMA_PARAM      = "schema"
+	SCHEMAS_ROUTE        = "/s/"
+	CACHE_PURGE_ROUTE    = "/c/purge"
+	BACKEND_STATS_ROUTE  = "/c/backends"
+	CACHE_OVERVIEW_ROUTE = "/c/schemas"
+	LINT_ROUTE           = "/c/lint/"
+	DOCS_ROUTE           = "/c/docs/"
+	COMPAT_ROUTE         = "/c/compat/"
+	SNAPSHOT_ROUTE       = "/c/snapshot"
+	SCHEMA_PARAM         = "schema"
+	STATE_PARAM          = "state"
+	SUPERSEDED_BY_PARAM  = "supersededBy"
 )
@@ -5,55 +5,342 @@
 package registry
 
 import (
+	"errors"
+	"io/fs"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/coocood/freecache"
 	"github.com/rs/zerolog/log"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/compiler"
 	"github.com/silverton-io/buz/pkg/config"
 )
 
 type Registry struct {
-	Cache        *freecache.Cache
-	Backend      SchemaCacheBackend
-	maxSizeBytes int
-	ttlSeconds   int
+	Cache       *SchemaCache
+	Compiled    *compiler.Cache
+	Backend     SchemaCacheBackend
+	backendName string
+	resolution  config.Resolution
+	servedMu    sync.Mutex
+	served      map[string]int64
+	stopRefresh chan struct{}
+	refreshDone chan struct{}
 }
 
 func (r *Registry) Initialize(conf config.Registry) error {
-	cacheBackend, _ := BuildSchemaCacheBackend(conf.Backend) // FIXME - pass err up
-	initErr := InitializeSchemaCacheBackend(conf.Backend, cacheBackend)
-	if initErr != nil {
-		return initErr
-	}
-	r.Backend = cacheBackend
-	r.Cache = freecache.NewCache(conf.MaxSizeBytes)
-	r.maxSizeBytes = conf.MaxSizeBytes
-	r.ttlSeconds = conf.TtlSeconds
+	if len(conf.Backends) > 0 {
+		chain, err := BuildChainBackend(conf.Backends)
+		if err != nil {
+			return err
+		}
+		r.Backend = chain
+	} else {
+		cacheBackend, err := BuildSchemaCacheBackend(conf.Backend)
+		if err != nil {
+			return err
+		}
+		initErr := InitializeSchemaCacheBackend(conf.Backend, cacheBackend)
+		if initErr != nil {
+			return initErr
+		}
+		r.Backend = cacheBackend
+		r.backendName = backendName(conf.Backend)
+	}
+	r.Cache = NewSchemaCache(conf.TtlSeconds, conf.MaxSchemas, conf.MaxSizeBytes)
+	r.Compiled = compiler.NewCache(conf.MaxSchemas)
+	r.Cache.SetExpiryListener(r.evictCompiled)
+	r.resolution = conf.Resolution
+	if conf.Negative.Enabled {
+		ttl := conf.Negative.TtlSeconds
+		if ttl <= 0 {
+			ttl = DEFAULT_NEGATIVE_TTL_SECONDS
+		}
+		r.Cache.SetNegativeTtl(ttl)
+	}
+	if watcher, ok := r.Backend.(SchemaWatchingBackend); ok {
+		if err := watcher.Watch(r.invalidate); err != nil {
+			return err
+		}
+	}
+	if conf.Refresh.Enabled {
+		r.startRefresher(conf)
+	}
+	return nil
+}
+
+// startRefresher periodically re-fetches hot schemas from the backend so
+// frequently-used schemas pick up remote changes without ever expiring.
+func (r *Registry) startRefresher(conf config.Registry) {
+	interval := conf.Refresh.IntervalSeconds
+	if interval <= 0 {
+		interval = conf.TtlSeconds / 2
+	}
+	if interval <= 0 {
+		interval = DEFAULT_REFRESH_INTERVAL_SECONDS
+	}
+	minHits := conf.Refresh.MinHits
+	if minHits <= 0 {
+		minHits = 1
+	}
+	log.Info().Msgf("🟢 refreshing hot schemas every %ds", interval)
+	r.stopRefresh, r.refreshDone = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(r.refreshDone)
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.refresh(minHits)
+			case <-r.stopRefresh:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops refreshing hot schemas, waiting for any refresh in progress
+func (r *Registry) Close() {
+	if r.stopRefresh == nil {
+		return
+	}
+	log.Debug().Msg("🟡 stopping schema refresher")
+	close(r.stopRefresh)
+	<-r.refreshDone
+	r.stopRefresh = nil
+}
+
+func (r *Registry) refresh(minHits int64) {
+	for _, key := range r.Cache.Hot(minHits) {
+		contents, source, err := r.fetch(key)
+		if err != nil {
+			// Keep serving the cached copy until it expires
+			log.Warn().Err(err).Msg("🟡 could not refresh schema " + key)
+			fetchFailed(key, err)
+			continue
+		}
+		log.Debug().Msg("🟡 refreshed schema " + key)
+		if r.Cache.Set(key, contents, source) {
+			r.evictCompiled(key)
+		}
+	}
+}
+
+const DEFAULT_REFRESH_INTERVAL_SECONDS int = 60
+
+// ErrorListener is told of schemas the backend failed to serve
+type ErrorListener func(schema string, err error)
+
+var errorListener ErrorListener
+
+// SetErrorListener sets the listener of backend errors
+func SetErrorListener(l ErrorListener) {
+	errorListener = l
+}
+
+// fetchFailed tells the listener of backends which failed, but not of
+// schemas which the backends were reached and do not have
+func fetchFailed(schema string, err error) {
+	if errorListener != nil && !notFound(err) {
+		errorListener(schema, err)
+	}
+}
+
+// notFound returns true if the error means the schema does not exist,
+// rather than that a backend could not be reached
+func notFound(err error) bool {
+	return errors.Is(err, ErrSchemaNotFound) ||
+		errors.Is(err, backendutils.ErrSchemaNotFound) ||
+		errors.Is(err, fs.ErrNotExist)
+}
+
+const DEFAULT_NEGATIVE_TTL_SECONDS int = 30
+
+var (
+	ErrPublishUnsupported = errors.New("registry backend does not support publishing schemas")
+	ErrInvalidSchema      = errors.New("invalid schema")
+)
+
+// schemaKey ensures the key ends in .json
+func schemaKey(key string) string {
+	if !strings.HasSuffix(key, ".json") {
+		return key + ".json"
+	}
+	return key
+}
+
+// Put publishes a schema to the remote backend, if the backend supports
+// writes, and evicts any locally-cached copy.
+func (r *Registry) Put(key string, contents []byte) error {
+	writer, ok := r.Backend.(SchemaWritingBackend)
+	if !ok {
+		return ErrPublishUnsupported
+	}
+	k := schemaKey(key)
+	if err := writer.PutRemote(k, contents); err != nil {
+		log.Error().Err(err).Msg("🔴 could not publish schema " + k)
+		return err
+	}
+	r.evict(k)
+	log.Info().Msg("🟢 published schema " + k)
+	return nil
+}
+
+// Delete removes a schema from the remote backend, if the backend supports
+// writes, and evicts any locally-cached copy.
+func (r *Registry) Delete(key string) error {
+	writer, ok := r.Backend.(SchemaWritingBackend)
+	if !ok {
+		return ErrPublishUnsupported
+	}
+	k := schemaKey(key)
+	if err := writer.DeleteRemote(k); err != nil {
+		log.Error().Err(err).Msg("🔴 could not delete schema " + k)
+		return err
+	}
+	r.evict(k)
+	log.Info().Msg("🟢 deleted schema " + k)
 	return nil
 }
 
+// invalidate evicts a changed schema from the cache, along with any cached
+// dynamic versions (such as `latest`) which may have resolved to it.
+func (r *Registry) invalidate(schema string) {
+	k := schemaKey(schema)
+	r.evict(k)
+	dir, _ := splitSchemaVersion(k)
+	for _, cached := range r.Cache.Snapshot() {
+		d, version := splitSchemaVersion(cached.Schema)
+		if d == dir && isDynamicVersion(version) {
+			r.evict(cached.Schema)
+		}
+	}
+	log.Info().Msg("🟢 invalidated changed schema " + k)
+}
+
+// evict removes both the suffixed and unsuffixed forms of the key from the
+// cache, along with the compiled schemas which reference it.
+func (r *Registry) evict(k string) {
+	r.Cache.Del(k)
+	r.Cache.Del(strings.TrimSuffix(k, ".json"))
+	r.evictCompiled(k)
+}
+
+func (r *Registry) evictCompiled(k string) {
+	if r.Compiled != nil {
+		r.Compiled.Evict(k)
+	}
+}
+
+func (r *Registry) clearCompiled() {
+	if r.Compiled != nil {
+		r.Compiled.Clear()
+	}
+}
+
+// Purge clears all cached and compiled schemas
+func (r *Registry) Purge() {
+	r.Cache.Clear()
+	r.clearCompiled()
+}
+
+// Compile compiles the schema once, resolving references through the
+// registry. Compiled schemas which reference an expired schema are
+// compiled again.
+func (r *Registry) Compile(schema []byte) (*jsonschema.Schema, error) {
+	if r.Compiled == nil {
+		return compiler.CompileWith(schema, r.Load)
+	}
+	r.Cache.Expire()
+	return r.Compiled.Compile(schema, r.Load)
+}
+
+// fetch resolves any dynamic version in the key and gets the schema from
+// the backend. Resolved schemas are cached under the requested key, so
+// dynamic versions are re-resolved when they expire or are refreshed.
+func (r *Registry) fetch(key string) (contents []byte, source string, err error) {
+	// Ensure schemaKey is key ending in .json (add if not present)
+	resolved, err := r.resolve(schemaKey(key))
+	if err != nil {
+		return nil, "", err
+	}
+	return r.getRemote(resolved)
+}
+
+// getRemote gets the schema from the backend, along with the name of
+// the backend that served it.
+func (r *Registry) getRemote(key string) (contents []byte, source string, err error) {
+	if chain, ok := r.Backend.(*ChainBackend); ok {
+		return chain.GetRemoteFrom(key)
+	}
+	contents, err = r.Backend.GetRemote(key)
+	return contents, r.backendName, err
+}
+
+func (r *Registry) recordServed(source string) {
+	r.servedMu.Lock()
+	defer r.servedMu.Unlock()
+	if r.served == nil {
+		r.served = make(map[string]int64)
+	}
+	r.served[source]++
+}
+
+// BackendStats returns the number of schemas served by each backend
+func (r *Registry) BackendStats() map[string]int64 {
+	r.servedMu.Lock()
+	defer r.servedMu.Unlock()
+	stats := make(map[string]int64, len(r.served))
+	for k, v := range r.served {
+		stats[k] = v
+	}
+	return stats
+}
+
+// Load gets a schema by name, for resolving references between schemas
+func (r *Registry) Load(name string) ([]byte, error) {
+	exists, contents := r.Get(name)
+	if !exists {
+		return nil, ErrSchemaNotFound
+	}
+	return contents, nil
+}
+
+// List returns all schemas available in the remote backend, if the
+// backend supports listing.
+func (r *Registry) List() (supported bool, schemas []string, err error) {
+	lister, ok := r.Backend.(SchemaListingBackend)
+	if !ok {
+		return false, nil, nil
+	}
+	schemas, err = lister.ListRemote()
+	return true, schemas, err
+}
+
 func (r *Registry) Get(key string) (exists bool, data []byte) {
-	k := []byte(key)
-	schemaContents, _ := r.Cache.Get(k)
-	if schemaContents != nil { // Schema already cached locally
+	schemaContents, cached := r.Cache.Get(key)
+	if cached { // Schema already cached locally
 		log.Debug().Msg("🟡 found cache key " + key)
 		return true, schemaContents
+	} else if r.Cache.IsMissing(key) { // Schema recently not found - don't ask the remote backend again
+		log.Debug().Msg("🟡 schema recently not found " + key)
+		return false, nil
 	} else { // Schema not yet cached locally - getting from remote backend
-		// Ensure schemaKey is key ending in .json (add if not present)
-		schemaKey := key
-		if !strings.HasSuffix(schemaKey, ".json") {
-			schemaKey = schemaKey + ".json"
-		}
-		schemaContents, err := r.Backend.GetRemote(schemaKey)
+		schemaContents, source, err := r.fetch(key)
 		if err != nil { // Error when getting schema from remote backend
-			log.Debug().Msg("error when getting remote schema")
+			log.Debug().Err(err).Msg("error when getting remote schema")
+			fetchFailed(key, err)
+			if notFound(err) { // Backend failures are retried by the next lookup
+				r.Cache.SetMissing(key)
+			}
 			return false, nil
 		}
+		r.recordServed(source)
 		log.Debug().Msg("🟡 caching " + key)
-		err = r.Cache.Set(k, schemaContents, r.ttlSeconds)
-		if err != nil {
-			log.Error().Err(err).Msg("🔴 error when setting key " + key)
-		}
+		r.Cache.Set(key, schemaContents, source)
 		log.Debug().Msg("🟡 " + key + " cached successfully")
 		return true, schemaContents // Schema was aquired from remote backed and cached successfully
 	}
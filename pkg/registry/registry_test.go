@@ -0,0 +1,57 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/compiler"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryCloseStopsRefresher(t *testing.T) {
+	backend := &memoryBackend{}
+	backend.Initialize(config.Backend{})
+	r := Registry{Cache: NewSchemaCache(0, 0, 0), Backend: backend}
+	r.Close() // Nothing to stop
+
+	r.startRefresher(config.Registry{Refresh: config.Refresh{Enabled: true, IntervalSeconds: 1}})
+	closed := make(chan struct{})
+	go func() {
+		r.Close()
+		r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("refresher did not stop")
+	}
+	assert.Nil(t, r.stopRefresh)
+}
+
+func TestRegistryRecompilesDependentsOfExpiredSchemas(t *testing.T) {
+	backend := &memoryBackend{}
+	backend.Initialize(config.Backend{})
+	backend.PutRemote("com.acme/name/v1.0.json", []byte(`{"type": "string"}`))
+	now := time.Now()
+	r := Registry{Cache: NewSchemaCache(10, 0, 0), Compiled: compiler.NewCache(0), Backend: backend}
+	r.Cache.now = func() time.Time { return now }
+	r.Cache.SetExpiryListener(r.evictCompiled)
+
+	dependent := []byte(`{"$ref": "/s/com.acme/name/v1.0.json"}`)
+	s, err := r.Compile(dependent)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Validate("ops"))
+
+	// The referenced schema changes, and expires without being looked up again
+	backend.PutRemote("com.acme/name/v1.0.json", []byte(`{"type": "number"}`))
+	now = now.Add(11 * time.Second)
+	s, err = r.Compile(dependent)
+	assert.Nil(t, err)
+	assert.NotNil(t, s.Validate("ops"))
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package registry
+
+import (
+	"fmt"
+
+	"github.com/silverton-io/buz/pkg/compiler"
+)
+
+// Export returns the contents of every schema in the registry. If the
+// backend can't be listed, the cached schemas are exported instead.
+func (r *Registry) Export() (map[string][]byte, error) {
+	supported, names, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	if !supported {
+		for _, s := range r.Cache.Snapshot() {
+			names = append(names, s.Schema)
+		}
+	}
+	schemas := make(map[string][]byte, len(names))
+	for _, name := range names {
+		exists, contents := r.Get(name)
+		if !exists {
+			return nil, fmt.Errorf("could not export %s: %w", name, ErrSchemaNotFound)
+		}
+		schemas[schemaKey(name)] = contents
+	}
+	return schemas, nil
+}
+
+// Import publishes every schema to the backend, after checking that all
+// of them compile. References are resolved within the imported schemas
+// before the registry.
+func (r *Registry) Import(schemas map[string][]byte) error {
+	if _, ok := r.Backend.(SchemaWritingBackend); !ok {
+		return ErrPublishUnsupported
+	}
+	load := func(name string) ([]byte, error) {
+		if contents, ok := schemas[schemaKey(name)]; ok {
+			return contents, nil
+		}
+		return r.Load(name)
+	}
+	for name, contents := range schemas {
+		if _, err := compiler.CompileWith(contents, load); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrInvalidSchema, name, err)
+		}
+	}
+	for name, contents := range schemas {
+		if err := r.Put(name, contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
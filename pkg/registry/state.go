@@ -0,0 +1,71 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// Schema lifecycle states
+const (
+	STATE_ACTIVE     string = "active"
+	STATE_DEPRECATED string = "deprecated"
+	STATE_SUPERSEDED string = "superseded"
+)
+
+// Lifecycle states are persisted within the schema itself, using the
+// standard `deprecated` annotation and a buz-specific `supersededBy` keyword.
+const (
+	DEPRECATED_KEY    string = "deprecated"
+	SUPERSEDED_BY_KEY string = "supersededBy"
+)
+
+var (
+	ErrInvalidState      = errors.New("invalid schema state")
+	ErrSuccessorNotFound = errors.New("superseding schema does not exist")
+)
+
+// SetState transitions the schema to the given lifecycle state and
+// republishes it. Superseded schemas are also deprecated, and must name
+// an existing successor.
+func (r *Registry) SetState(key string, state string, supersededBy string) error {
+	if state == STATE_SUPERSEDED && supersededBy == "" {
+		return ErrInvalidState
+	}
+	if state != STATE_ACTIVE && state != STATE_DEPRECATED && state != STATE_SUPERSEDED {
+		return ErrInvalidState
+	}
+	contents, _, err := r.getRemote(schemaKey(key))
+	if err != nil {
+		return ErrSchemaNotFound
+	}
+	if state == STATE_SUPERSEDED {
+		if _, _, err := r.getRemote(schemaKey(supersededBy)); err != nil {
+			return ErrSuccessorNotFound
+		}
+	}
+	var schema map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(contents))
+	d.UseNumber()
+	if err := d.Decode(&schema); err != nil {
+		return err
+	}
+	delete(schema, DEPRECATED_KEY)
+	delete(schema, SUPERSEDED_BY_KEY)
+	switch state {
+	case STATE_DEPRECATED:
+		schema[DEPRECATED_KEY] = true
+	case STATE_SUPERSEDED:
+		schema[DEPRECATED_KEY] = true
+		schema[SUPERSEDED_BY_KEY] = schemaKey(supersededBy)
+	}
+	updated, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	return r.Put(key, updated)
+}
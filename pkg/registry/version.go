@@ -0,0 +1,159 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package registry
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const (
+	LATEST_VERSION   string = "latest"
+	VERSION_WILDCARD string = "*"
+)
+
+// Resolution policies
+const (
+	RESOLUTION_EXACT  string = "exact"  // Dynamic versions are never resolved
+	RESOLUTION_RANGE  string = "range"  // Ranges are resolved, `latest` is not
+	RESOLUTION_LATEST string = "latest" // Ranges and `latest` are resolved
+)
+
+var (
+	ErrResolutionUnsupported = errors.New("registry backend does not support listing, so versions cannot be resolved")
+	ErrResolutionNotAllowed  = errors.New("dynamic schema versions are not allowed for this vendor")
+	ErrNoMatchingVersion     = errors.New("no schema version matches the requested range")
+)
+
+// splitSchemaVersion splits a schema key such as `com.acme/event/v1.0.json`
+// into its directory (`com.acme/event`) and version (`v1.0`).
+func splitSchemaVersion(key string) (dir string, version string) {
+	k := strings.TrimSuffix(key, ".json")
+	i := strings.LastIndex(k, "/")
+	if i == -1 {
+		return "", k
+	}
+	return k[:i], k[i+1:]
+}
+
+// versionParts splits both buz (`v1.0`) and SchemaVer (`1-0-0`) versions
+func versionParts(version string) []string {
+	return strings.FieldsFunc(strings.TrimPrefix(version, "v"), func(r rune) bool {
+		return r == '.' || r == '-'
+	})
+}
+
+func isDynamicVersion(version string) bool {
+	return version == LATEST_VERSION || strings.Contains(version, VERSION_WILDCARD)
+}
+
+// parseVersion returns the numeric parts of a concrete version
+func parseVersion(version string) (parts []int, ok bool) {
+	for _, p := range versionParts(version) {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, len(parts) > 0
+}
+
+// matchesRange returns true if the version satisfies the range. A wildcard
+// matches any single part, and a trailing wildcard matches all remaining parts.
+func matchesRange(rangeParts []string, version []int) bool {
+	for i, r := range rangeParts {
+		if r == VERSION_WILDCARD {
+			if i == len(rangeParts)-1 {
+				return len(version) > i
+			}
+			continue
+		}
+		n, err := strconv.Atoi(r)
+		if err != nil || i >= len(version) || version[i] != n {
+			return false
+		}
+	}
+	return len(version) == len(rangeParts)
+}
+
+func compareVersions(a []int, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// vendorResolution returns the resolution config for the first vendor whose
+// prefix the schema begins with. Unconfigured vendors resolve everything.
+func (r *Registry) vendorResolution(key string) config.VendorResolution {
+	for _, v := range r.resolution.Vendors {
+		if strings.HasPrefix(key, v.Vendor) {
+			return v
+		}
+	}
+	return config.VendorResolution{Policy: RESOLUTION_LATEST}
+}
+
+// resolve maps a schema key with a dynamic version, such as
+// `com.acme/event/latest.json` or `com.acme/event/1-0-*.json`, to the key of
+// the highest matching version available in the backend. Keys with concrete
+// versions are returned as-is.
+func (r *Registry) resolve(key string) (string, error) {
+	dir, version := splitSchemaVersion(key)
+	if !r.resolution.Enabled || !isDynamicVersion(version) {
+		return key, nil
+	}
+	v := r.vendorResolution(key)
+	switch {
+	case v.Policy == RESOLUTION_EXACT:
+		return "", ErrResolutionNotAllowed
+	case v.Policy == RESOLUTION_RANGE && version == LATEST_VERSION:
+		return "", ErrResolutionNotAllowed
+	}
+	rangeParts := versionParts(version)
+	if version == LATEST_VERSION {
+		rangeParts = []string{VERSION_WILDCARD}
+		if v.Pin != "" {
+			rangeParts = versionParts(v.Pin)
+		}
+	}
+	supported, schemas, err := r.List()
+	if !supported {
+		return "", ErrResolutionUnsupported
+	}
+	if err != nil {
+		return "", err
+	}
+	var resolved string
+	var resolvedVersion []int
+	for _, s := range schemas {
+		d, candidate := splitSchemaVersion(s)
+		if d != dir {
+			continue
+		}
+		parts, ok := parseVersion(candidate)
+		if !ok || !matchesRange(rangeParts, parts) {
+			continue
+		}
+		if resolved == "" || compareVersions(parts, resolvedVersion) > 0 {
+			resolved, resolvedVersion = s, parts
+		}
+	}
+	if resolved == "" {
+		return "", ErrNoMatchingVersion
+	}
+	log.Debug().Msg("🟡 resolved " + key + " to " + resolved)
+	return schemaKey(resolved), nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package registry
+
+import (
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchesRange(t *testing.T) {
+	var testCases = []struct {
+		versionRange string
+		version      string
+		want         bool
+	}{
+		{"1-0-*", "1-0-3", true},
+		{"1-0-*", "1-1-0", false},
+		{"1-*", "1-4-2", true},
+		{"*", "2-0-0", true},
+		{"v1.*", "v1.2", true},
+		{"v1.*", "v2.0", false},
+		{"1-*-0", "1-3-0", true},
+		{"1-*-0", "1-3-1", false},
+		{"1-0-0", "1-0-0", true},
+		{"1-0", "1-0-0", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.versionRange+" "+tc.version, func(t *testing.T) {
+			parts, _ := parseVersion(tc.version)
+			assert.Equal(t, tc.want, matchesRange(versionParts(tc.versionRange), parts))
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	r := testRegistry()
+	r.resolution = config.Resolution{
+		Enabled: true,
+		Vendors: []config.VendorResolution{
+			{Vendor: "com.exact/", Policy: RESOLUTION_EXACT},
+			{Vendor: "com.ranged/", Policy: RESOLUTION_RANGE},
+			{Vendor: "com.pinned/", Policy: RESOLUTION_LATEST, Pin: "1-*"},
+		},
+	}
+	for _, s := range []string{
+		"com.acme/event/jsonschema/1-0-0.json",
+		"com.acme/event/jsonschema/1-0-10.json",
+		"com.acme/event/jsonschema/1-0-2.json",
+		"com.acme/event/jsonschema/2-0-0.json",
+		"com.acme/other/jsonschema/3-0-0.json",
+		"com.exact/event/v1.0.json",
+		"com.ranged/event/v1.0.json",
+		"com.ranged/event/v1.1.json",
+		"com.pinned/event/1-2-0.json",
+		"com.pinned/event/2-0-0.json",
+	} {
+		r.Backend.(*memoryBackend).PutRemote(s, []byte(`{"self": {"version": "`+s+`"}}`))
+	}
+	var testCases = []struct {
+		key     string
+		want    string
+		wantErr error
+	}{
+		{"com.acme/event/jsonschema/1-0-0", "com.acme/event/jsonschema/1-0-0.json", nil},
+		{"com.acme/event/jsonschema/1-0-*", "com.acme/event/jsonschema/1-0-10.json", nil},
+		{"com.acme/event/jsonschema/latest", "com.acme/event/jsonschema/2-0-0.json", nil},
+		{"com.acme/event/jsonschema/3-*", "", ErrNoMatchingVersion},
+		{"com.exact/event/latest", "", ErrResolutionNotAllowed},
+		{"com.ranged/event/v1.*", "com.ranged/event/v1.1.json", nil},
+		{"com.ranged/event/latest", "", ErrResolutionNotAllowed},
+		{"com.pinned/event/latest", "com.pinned/event/1-2-0.json", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			got, err := r.resolve(schemaKey(tc.key))
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	exists, contents := r.Get("com.acme/event/jsonschema/latest")
+	assert.True(t, exists)
+	assert.Contains(t, string(contents), "2-0-0")
+}
+
+func TestResolveDisabled(t *testing.T) {
+	r := testRegistry()
+	got, err := r.resolve("com.acme/event/latest.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "com.acme/event/latest.json", got)
+}
+
+func TestInvalidateEvictsDynamicVersions(t *testing.T) {
+	r := testRegistry()
+	r.Cache.Set("com.acme/event/v1.0.json", []byte(`{}`), "")
+	r.Cache.Set("com.acme/event/latest", []byte(`{}`), "")
+	r.Cache.Set("com.acme/event/v1.*", []byte(`{}`), "")
+	r.Cache.Set("com.acme/other/latest", []byte(`{}`), "")
+	r.invalidate("com.acme/event/v1.0.json")
+	assert.Equal(t, 1, r.Cache.Len())
+	_, ok := r.Cache.Get("com.acme/other/latest")
+	assert.True(t, ok)
+}
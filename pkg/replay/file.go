@@ -0,0 +1,52 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package replay
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+// FileArchive reads the files under a local directory, such as the
+// outputs of file sinks
+type FileArchive struct {
+	dir string
+}
+
+func (a *FileArchive) Initialize(conf config.Archive) error {
+	log.Debug().Msg("🟡 initializing file archive " + conf.Name)
+	a.dir = conf.Path
+	_, err := os.Stat(a.dir)
+	return err
+}
+
+func (a *FileArchive) Read(prefix string, fn func(object string, r io.Reader) error) error {
+	// Files are matched by their path relative to the directory, so the
+	// prefix can't reach outside of it
+	return filepath.WalkDir(a.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(a.dir, p)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(filepath.ToSlash(rel), prefix) {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return fn(p, f)
+	})
+}
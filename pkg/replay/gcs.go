@@ -0,0 +1,57 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package replay
+
+import (
+	"context"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"google.golang.org/api/iterator"
+)
+
+// GcsArchive reads the objects under a prefix of a bucket
+type GcsArchive struct {
+	bucket string
+	prefix string
+	client *storage.Client
+}
+
+func (a *GcsArchive) Initialize(conf config.Archive) error {
+	log.Debug().Msg("🟡 initializing gcs archive " + conf.Name)
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not initialize gcs client")
+		return err
+	}
+	a.bucket, a.prefix, a.client = conf.Bucket, conf.Path, client
+	return nil
+}
+
+func (a *GcsArchive) Read(prefix string, fn func(object string, r io.Reader) error) error {
+	ctx := context.Background()
+	bucket := a.client.Bucket(a.bucket)
+	it := bucket.Objects(ctx, &storage.Query{Prefix: a.prefix + prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r, err := bucket.Object(attrs.Name).NewReader(ctx)
+		if err != nil {
+			return err
+		}
+		err = fn(attrs.Name, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package replay
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/response"
+)
+
+const REPLAY_ROUTE = "/c/archive/replay"
+
+type replayFailure struct {
+	Message string `json:"message"`
+	Error   string `json:"error"`
+	Result
+}
+
+// ReplayHandler replays archived envelopes to the sinks, as selected by
+// the json request body
+func ReplayHandler(r *Replayer, sinks []backendutils.Sink) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var req Request
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, response.InvalidReplay)
+			return
+		}
+		result, err := r.Replay(req, sinks)
+		if err != nil {
+			status, message := http.StatusInternalServerError, response.ArchiveReplayFailed.Message
+			if errors.Is(err, ErrInvalidRequest) {
+				status, message = http.StatusBadRequest, response.InvalidReplay.Message
+			}
+			log.Error().Err(err).Interface("result", result).Msg("🔴 could not replay archive")
+			c.JSON(status, replayFailure{Message: message, Error: err.Error(), Result: result})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package replay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayHandler(t *testing.T) {
+	r := testReplayer(t, 0)
+	sink := &recordingSink{name: "warehouse"}
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.POST(REPLAY_ROUTE, ReplayHandler(r, []backendutils.Sink{sink}))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, REPLAY_ROUTE, strings.NewReader(`{"archive": "local", "sinks": ["warehouse"], "schemas": ["io.acme/page"]}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var result Result
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.Replayed)
+	assert.Equal(t, []string{"io.acme/page/v1.0"}, sink.schemas())
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, REPLAY_ROUTE, strings.NewReader(`{"archive": "missing"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/expression"
+)
+
+const DEFAULT_BATCH_SIZE int = 500
+
+// ErrInvalidRequest is returned, wrapped, for requests which can't be
+// replayed as given
+var ErrInvalidRequest = errors.New("invalid replay request")
+
+// Archive reads the objects sinks have archived envelopes to. Each object
+// is newline delimited json, and may be gzipped.
+type Archive interface {
+	Initialize(conf config.Archive) error
+	// Read passes each object under the prefix to fn, in lexical order.
+	// The prefix is relative to the archive path.
+	Read(prefix string, fn func(object string, r io.Reader) error) error
+}
+
+func BuildArchive(conf config.Archive) (Archive, error) {
+	var a Archive
+	switch conf.Type {
+	case constants.FILE, "":
+		a = &FileArchive{}
+	case constants.S3:
+		a = &S3Archive{}
+	case constants.GCS:
+		a = &GcsArchive{}
+	default:
+		return nil, errors.New("unsupported archive type: " + conf.Type)
+	}
+	if err := a.Initialize(conf); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Request selects the archived envelopes to replay, and the sinks to
+// replay them to. Archived envelopes were already transformed, so they are
+// delivered to the sinks directly rather than through the manifold, and
+// are routed by each sink's own schemas and filter.
+type Request struct {
+	Archive        string     `json:"archive"`
+	Sinks          []string   `json:"sinks"` // The names of the sinks to replay to
+	Prefix         string     `json:"prefix,omitempty"`
+	Schemas        []string   `json:"schemas,omitempty"`
+	ExcludeSchemas []string   `json:"excludeSchemas,omitempty"`
+	Receives       string     `json:"receives,omitempty"` // all, valid, or invalid
+	From           *time.Time `json:"from,omitempty"`     // Inclusive, by buz timestamp
+	To             *time.Time `json:"to,omitempty"`       // Exclusive, by buz timestamp
+	Filter         string     `json:"filter,omitempty"`   // A CEL expression
+	DryRun         bool       `json:"dryRun,omitempty"`
+}
+
+// Result counts the envelopes read from the archive, and those which
+// matched the request. Matched envelopes are not replayed in a dry run.
+type Result struct {
+	Objects  int  `json:"objects"`
+	Read     int  `json:"read"`
+	Matched  int  `json:"matched"`
+	Replayed int  `json:"replayed"`
+	DryRun   bool `json:"dryRun"`
+}
+
+type selector struct {
+	req    Request
+	filter *expression.Expression
+}
+
+func newSelector(req Request) (*selector, error) {
+	if err := backendutils.ValidateReceives(req.Receives); err != nil {
+		return nil, err
+	}
+	if req.From != nil && req.To != nil && !req.From.Before(*req.To) {
+		return nil, errors.New("replay window is empty")
+	}
+	s := &selector{req: req}
+	if req.Filter != "" {
+		filter, err := expression.Cached(req.Filter)
+		if err != nil {
+			return nil, err
+		}
+		s.filter = filter
+	}
+	return s, nil
+}
+
+func hasPrefix(schema string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(schema, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *selector) matches(e envelope.Envelope) bool {
+	switch {
+	case s.req.Receives == backendutils.VALID && !e.IsValid,
+		s.req.Receives == backendutils.INVALID && e.IsValid,
+		len(s.req.Schemas) > 0 && !hasPrefix(e.Schema, s.req.Schemas),
+		hasPrefix(e.Schema, s.req.ExcludeSchemas),
+		s.req.From != nil && e.BuzTimestamp.Before(*s.req.From),
+		s.req.To != nil && !e.BuzTimestamp.Before(*s.req.To):
+		return false
+	}
+	if s.filter == nil {
+		return true
+	}
+	matched, err := s.filter.Match(e)
+	return err == nil && matched
+}
+
+// decode reads the envelopes of an object, gunzipping it if needed
+func decode(object string, r io.Reader, fn func(envelope.Envelope) error) error {
+	if strings.HasSuffix(object, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e envelope.Envelope
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Replayer replays envelopes from the configured archives
+type Replayer struct {
+	archives  map[string]Archive
+	batchSize int
+}
+
+func NewReplayer(conf config.Replay) (*Replayer, error) {
+	r := &Replayer{archives: make(map[string]Archive), batchSize: conf.BatchSize}
+	if r.batchSize <= 0 {
+		r.batchSize = DEFAULT_BATCH_SIZE
+	}
+	for _, c := range conf.Archives {
+		if _, ok := r.archives[c.Name]; ok {
+			return nil, errors.New("duplicate archive name: " + c.Name)
+		}
+		a, err := BuildArchive(c)
+		if err != nil {
+			return nil, err
+		}
+		r.archives[c.Name] = a
+	}
+	return r, nil
+}
+
+// targets returns the sinks of the request, by name
+func targets(names []string, sinks []backendutils.Sink) ([]backendutils.Sink, error) {
+	if len(names) == 0 {
+		return nil, errors.New("replay requires target sinks")
+	}
+	var targets []backendutils.Sink
+	for _, name := range names {
+		var target backendutils.Sink
+		for _, s := range sinks {
+			if s.Metadata().Name == name {
+				target = s
+			}
+		}
+		if target == nil {
+			return nil, errors.New("unknown sink " + name)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// Replay delivers the matching envelopes of the archive to the target
+// sinks in batches. An error is returned with the envelopes replayed
+// before it.
+func (r *Replayer) Replay(req Request, sinks []backendutils.Sink) (Result, error) {
+	result := Result{DryRun: req.DryRun}
+	archive, ok := r.archives[req.Archive]
+	if !ok {
+		return result, fmt.Errorf("%w: unknown archive %s", ErrInvalidRequest, req.Archive)
+	}
+	to, err := targets(req.Sinks, sinks)
+	if err != nil {
+		return result, fmt.Errorf("%w: %s", ErrInvalidRequest, err)
+	}
+	s, err := newSelector(req)
+	if err != nil {
+		return result, fmt.Errorf("%w: %s", ErrInvalidRequest, err)
+	}
+	var batch []envelope.Envelope
+	flush := func() error {
+		if len(batch) == 0 || req.DryRun {
+			batch = batch[:0]
+			return nil
+		}
+		for _, sink := range to {
+			if err := backendutils.Deliver(context.Background(), sink, batch); err != nil {
+				return fmt.Errorf("could not deliver to %s: %w", sink.Metadata().Name, err)
+			}
+		}
+		result.Replayed += len(batch)
+		batch = nil
+		return nil
+	}
+	err = archive.Read(req.Prefix, func(object string, body io.Reader) error {
+		result.Objects++
+		err := decode(object, body, func(e envelope.Envelope) error {
+			result.Read++
+			if !s.matches(e) {
+				return nil
+			}
+			result.Matched++
+			batch = append(batch, e)
+			if len(batch) >= r.batchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", object, err)
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		log.Info().Str("archive", req.Archive).Interface("result", result).Msg("🟢 replayed archive")
+	}
+	return result, err
+}
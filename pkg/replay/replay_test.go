@@ -0,0 +1,160 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package replay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink records the envelopes delivered to it
+type recordingSink struct {
+	name    string
+	batches [][]envelope.Envelope
+}
+
+func (s *recordingSink) Metadata() backendutils.SinkMetadata {
+	return backendutils.SinkMetadata{Name: s.name}
+}
+func (s *recordingSink) Initialize(conf config.Sink) error { return nil }
+func (s *recordingSink) StartWorker() error                { return nil }
+func (s *recordingSink) Enqueue(envelopes []envelope.Envelope) error {
+	return errors.New("replayed envelopes should be delivered directly")
+}
+func (s *recordingSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	s.batches = append(s.batches, append([]envelope.Envelope(nil), envelopes...))
+	return nil
+}
+func (s *recordingSink) Shutdown() error { return nil }
+
+func (s *recordingSink) schemas() []string {
+	var schemas []string
+	for _, batch := range s.batches {
+		for _, e := range batch {
+			schemas = append(schemas, e.Schema)
+		}
+	}
+	return schemas
+}
+
+var day = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func writeArchived(t *testing.T, path string, envelopes []envelope.Envelope) {
+	var b bytes.Buffer
+	for _, e := range envelopes {
+		line, err := json.Marshal(e)
+		assert.Nil(t, err)
+		b.Write(append(line, '\n'))
+	}
+	contents := b.Bytes()
+	if filepath.Ext(path) == ".gz" {
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		w.Write(contents)
+		w.Close()
+		contents = gz.Bytes()
+	}
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.Nil(t, os.WriteFile(path, contents, 0644))
+}
+
+func testReplayer(t *testing.T, batchSize int) *Replayer {
+	dir := t.TempDir()
+	writeArchived(t, filepath.Join(dir, "2023-06-01", "events.jsonl"), []envelope.Envelope{
+		{Schema: "io.acme/checkout/v1.0", IsValid: true, BuzTimestamp: day},
+		{Schema: "io.acme/page/v1.0", IsValid: true, BuzTimestamp: day.Add(time.Hour)},
+		{Schema: "io.acme/checkout/v1.0", IsValid: false, BuzTimestamp: day.Add(2 * time.Hour)},
+	})
+	writeArchived(t, filepath.Join(dir, "2023-06-02", "events.jsonl.gz"), []envelope.Envelope{
+		{Schema: "io.acme/checkout/v1.1", IsValid: true, BuzTimestamp: day.Add(24 * time.Hour)},
+	})
+	r, err := NewReplayer(config.Replay{BatchSize: batchSize, Archives: []config.Archive{{Name: "local", Type: "file", Path: dir}}})
+	assert.Nil(t, err)
+	return r
+}
+
+func TestReplayFiltersEnvelopes(t *testing.T) {
+	r := testReplayer(t, 0)
+	to := day.Add(24 * time.Hour)
+	testCases := []struct {
+		name string
+		req  Request
+		want []string
+	}{
+		{"all", Request{}, []string{"io.acme/checkout/v1.0", "io.acme/page/v1.0", "io.acme/checkout/v1.0", "io.acme/checkout/v1.1"}},
+		{"prefix", Request{Prefix: "2023-06-02"}, []string{"io.acme/checkout/v1.1"}},
+		{"schemas", Request{Schemas: []string{"io.acme/checkout"}, ExcludeSchemas: []string{"io.acme/checkout/v1.1"}}, []string{"io.acme/checkout/v1.0", "io.acme/checkout/v1.0"}},
+		{"invalid", Request{Receives: backendutils.INVALID}, []string{"io.acme/checkout/v1.0"}},
+		{"window", Request{From: &day, To: &to, Receives: backendutils.VALID}, []string{"io.acme/checkout/v1.0", "io.acme/page/v1.0"}},
+		{"filter", Request{Filter: `schema.endsWith("v1.1")`}, []string{"io.acme/checkout/v1.1"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := &recordingSink{name: "warehouse"}
+			tc.req.Archive, tc.req.Sinks = "local", []string{"warehouse"}
+			result, err := r.Replay(tc.req, []backendutils.Sink{sink})
+			assert.Nil(t, err)
+			// Valid and invalid envelopes of a batch are delivered separately
+			assert.ElementsMatch(t, tc.want, sink.schemas())
+			assert.Equal(t, len(tc.want), result.Replayed)
+		})
+	}
+}
+
+func TestReplayBatches(t *testing.T) {
+	r := testReplayer(t, 2)
+	sink := &recordingSink{name: "warehouse"}
+	result, err := r.Replay(Request{Archive: "local", Sinks: []string{"warehouse"}, Receives: backendutils.VALID}, []backendutils.Sink{sink})
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Objects: 2, Read: 4, Matched: 3, Replayed: 3}, result)
+	assert.Len(t, sink.batches, 2)
+}
+
+func TestReplayDryRun(t *testing.T) {
+	r := testReplayer(t, 0)
+	sink := &recordingSink{name: "warehouse"}
+	result, err := r.Replay(Request{Archive: "local", Sinks: []string{"warehouse"}, Schemas: []string{"io.acme/page"}, DryRun: true}, []backendutils.Sink{sink})
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Objects: 2, Read: 4, Matched: 1, DryRun: true}, result)
+	assert.Empty(t, sink.batches)
+}
+
+func TestReplayRejectsInvalidRequests(t *testing.T) {
+	r := testReplayer(t, 0)
+	sinks := []backendutils.Sink{&recordingSink{name: "warehouse"}}
+	for _, req := range []Request{
+		{Archive: "missing", Sinks: []string{"warehouse"}},
+		{Archive: "local"},
+		{Archive: "local", Sinks: []string{"lake"}},
+		{Archive: "local", Sinks: []string{"warehouse"}, Receives: "some"},
+		{Archive: "local", Sinks: []string{"warehouse"}, From: &day, To: &day},
+		{Archive: "local", Sinks: []string{"warehouse"}, Filter: "schema =="},
+	} {
+		_, err := r.Replay(req, sinks)
+		assert.True(t, errors.Is(err, ErrInvalidRequest), req)
+	}
+}
+
+func TestReplayDeliversOnlyToTargetSinks(t *testing.T) {
+	r := testReplayer(t, 0)
+	source, target := &recordingSink{name: "lake"}, &recordingSink{name: "warehouse"}
+	result, err := r.Replay(Request{Archive: "local", Sinks: []string{"warehouse"}, Schemas: []string{"io.acme/page"}}, []backendutils.Sink{source, target})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Replayed)
+	assert.Equal(t, []string{"io.acme/page/v1.0"}, target.schemas())
+	assert.Empty(t, source.batches)
+}
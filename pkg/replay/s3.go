@@ -0,0 +1,64 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package replay
+
+import (
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconf "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+// S3Archive reads the objects under a key prefix of a bucket
+type S3Archive struct {
+	bucket string
+	prefix string
+	client *s3.Client
+}
+
+func (a *S3Archive) Initialize(conf config.Archive) error {
+	log.Debug().Msg("🟡 initializing s3 archive " + conf.Name)
+	cfg, err := awsconf.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not load aws config")
+		return err
+	}
+	if conf.Region != "" {
+		cfg.Region = conf.Region
+	}
+	a.bucket, a.prefix, a.client = conf.Bucket, conf.Path, s3.NewFromConfig(cfg)
+	return nil
+}
+
+func (a *S3Archive) Read(prefix string, fn func(object string, r io.Reader) error) error {
+	ctx := context.Background()
+	paginator := s3.NewListObjectsV2Paginator(a.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(a.bucket),
+		Prefix: aws.String(a.prefix + prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			out, err := a.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(a.bucket), Key: aws.String(key)})
+			if err != nil {
+				return err
+			}
+			err = fn(key, out.Body)
+			out.Body.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
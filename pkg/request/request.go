@@ -7,6 +7,8 @@ package request
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -19,12 +21,24 @@ const (
 	JSON_CONTENT_TYPE string = "application/json"
 )
 
+// ErrNotFound is wrapped by errors for urls which answer 404
+var ErrNotFound = errors.New("not found")
+
 func PostPayload(url url.URL, payload interface{}, header http.Header) (resp *http.Response, err error) {
 	data, err := json.Marshal(payload)
 	if err != nil {
 		log.Error().Err(err).Msg("🔴 could not marshal payload")
 		return nil, err
 	}
+	return PostBytes(url, data, header)
+}
+
+// PostBytes posts an already-serialized json body, which may be
+// compressed if the header sets its Content-Encoding
+func PostBytes(url url.URL, data []byte, header http.Header) (resp *http.Response, err error) {
+	if header == nil {
+		header = http.Header{}
+	}
 	buf := bytes.NewBuffer(data)
 	// Set up a client, add appropriate headers, and make the request
 	client := http.Client{}
@@ -32,7 +46,9 @@ func PostPayload(url url.URL, payload interface{}, header http.Header) (resp *ht
 	if err != nil {
 		log.Error().Err(err).Msg("🔴 could not build request")
 	}
-	header.Add("Content-Type", JSON_CONTENT_TYPE)
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", JSON_CONTENT_TYPE)
+	}
 	req.Header = header
 	resp, err = client.Do(req)
 	if resp == nil {
@@ -77,3 +93,32 @@ func Get(url url.URL) (body []byte, err error) {
 	}
 	return body, nil
 }
+
+// GetWithClient gets the url using the provided client and headers, and
+// considers any non-2xx response an error.
+func GetWithClient(client *http.Client, url url.URL, header http.Header) (body []byte, err error) {
+	req, err := http.NewRequest(http.MethodGet, url.String(), nil)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not build request")
+		return nil, err
+	}
+	req.Header = header.Clone()
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Trace().Err(err).Msg("could not get url " + url.String())
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, ioerr := io.ReadAll(resp.Body)
+	if ioerr != nil {
+		log.Trace().Err(ioerr).Msg("could not read response body")
+		return nil, ioerr
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("got %s from %s: %w", resp.Status, url.String(), ErrNotFound)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.New("got " + resp.Status + " from " + url.String())
+	}
+	return body, nil
+}
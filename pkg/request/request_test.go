@@ -72,3 +72,27 @@ func TestGet(t *testing.T) {
 		}
 	})
 }
+
+func TestGetWithClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	dest, _ := url.Parse(ts.URL)
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+	body, err := GetWithClient(ts.Client(), *dest, header)
+	if err != nil || string(body) != "ok" {
+		t.Fatalf(`got %v %v, want ok`, string(body), err)
+	}
+
+	_, err = GetWithClient(ts.Client(), *dest, http.Header{})
+	if err == nil {
+		t.Fatalf(`got nil err, want err for unauthorized response`)
+	}
+}
@@ -28,6 +28,82 @@ var SchemaNotAvailable = Response{
 	Message: "schema not available",
 }
 
+var SchemaListingUnsupported = Response{
+	Message: "schema listing not supported by registry backend",
+}
+
+var SchemaListingFailed = Response{
+	Message: "could not list schemas",
+}
+
+var SchemaPublished = Response{
+	Message: "schema published",
+}
+
+var SchemaAlreadyExists = Response{
+	Message: "schema already exists",
+}
+
+var InvalidSchema = Response{
+	Message: "invalid schema",
+}
+
+var SchemaPublishingUnsupported = Response{
+	Message: "schema publishing not supported by registry backend",
+}
+
+var SchemaPublishingFailed = Response{
+	Message: "could not publish schema",
+}
+
+var SchemaIncompatible = Response{
+	Message: "schema is incompatible",
+}
+
+var InvalidSnapshot = Response{
+	Message: "invalid schema snapshot",
+}
+
+var SnapshotImported = Response{
+	Message: "schema snapshot imported",
+}
+
+var SchemaDeleted = Response{
+	Message: "schema deleted",
+}
+
+var SchemaStateUpdated = Response{
+	Message: "schema state updated",
+}
+
+var InvalidSchemaState = Response{
+	Message: "invalid schema state",
+}
+
+var DeadLetterReplayFailed = Response{
+	Message: "dead letter replay failed",
+}
+
+var ArchiveReplayFailed = Response{
+	Message: "archive replay failed",
+}
+
+var InvalidReplay = Response{
+	Message: "invalid replay request",
+}
+
+var OidcLoginRequired = Response{
+	Message: "login required",
+}
+
+var OidcLoginFailed = Response{
+	Message: "login failed",
+}
+
+var OidcOperatorForbidden = Response{
+	Message: "operator not allowed",
+}
+
 var CachePurged = Response{
 	Message: "cache purged",
 }
@@ -44,6 +120,14 @@ var ManifoldDistributionError = Response{
 	Message: "distribution error",
 }
 
+var ManifoldOverloaded = Response{
+	Message: "manifold is overloaded",
+}
+
+var EnvelopesTooLarge = Response{
+	Message: "envelopes are too large",
+}
+
 var MissingAuthHeader = Response{
 	Message: "missing authorization header",
 }
@@ -59,3 +143,67 @@ var InvalidAuthScheme = Response{
 var InvalidAuthToken = Response{
 	Message: "invalid token",
 }
+
+var ApiKeyInputDenied = Response{
+	Message: "api key may not use this input",
+}
+
+var BotRejected = Response{
+	Message: "request rejected",
+}
+
+var InvalidSignature = Response{
+	Message: "invalid signature",
+}
+
+var UnknownTenant = Response{
+	Message: "unknown tenant",
+}
+
+var CollectorOverloaded = Response{
+	Message: "collector overloaded",
+}
+
+var RequestReplayed = Response{
+	Message: "request was already used",
+}
+
+var ClientBlocked = Response{
+	Message: "client temporarily blocked",
+}
+
+var FingerprintUnblocked = Response{
+	Message: "fingerprint unblocked",
+}
+
+var FingerprintNotBlocked = Response{
+	Message: "fingerprint not blocked",
+}
+
+var QuotaExceeded = Response{
+	Message: "quota exceeded",
+}
+
+var RequiredHeaderInvalid = Response{
+	Message: "missing or invalid required header",
+}
+
+var InvalidErasureRequest = Response{
+	Message: "invalid erasure request",
+}
+
+var ErasureRequestFailed = Response{
+	Message: "could not request erasure",
+}
+
+var InvalidTapFilter = Response{
+	Message: "invalid tap filter",
+}
+
+var TooManyTapSubscribers = Response{
+	Message: "too many tap subscribers",
+}
+
+var InvalidLogLevel = Response{
+	Message: "invalid log level",
+}
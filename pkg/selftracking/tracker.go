@@ -0,0 +1,227 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package selftracking
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/manifold"
+	"github.com/silverton-io/buz/pkg/meta"
+)
+
+const (
+	// The protocol of collector event envelopes
+	COLLECTOR                           string  = "collector"
+	COLLECTOR_EVENT_SCHEMA              string  = "io.silverton/buz/internal/collector/event/v1.0.json"
+	DEFAULT_HEARTBEAT_SECONDS           int     = 60
+	DEFAULT_INVALID_SPIKE_RATE          float64 = 0.1
+	DEFAULT_INVALID_SPIKE_MIN_ENVELOPES int64   = 100
+)
+
+// Collector events
+const (
+	STARTUP       string = "startup"
+	HEARTBEAT     string = "heartbeat"
+	SHUTDOWN      string = "shutdown"
+	SINK_FAILURE  string = "sinkFailure"
+	INVALID_SPIKE string = "invalidSpike"
+)
+
+type sinkFailure struct {
+	failed int64
+	err    string
+}
+
+// Tracker emits the collector's operational events to one of its sinks.
+// Sink failures are collected and reported with every heartbeat, along
+// with spikes of invalid envelopes.
+type Tracker struct {
+	conf     config.SelfTracking
+	app      config.App
+	meta     *meta.CollectorMeta
+	interval time.Duration
+	now      func() time.Time
+	sink     backendutils.Sink
+	stats    func() manifold.Stats
+	last     manifold.Stats
+	mu       sync.Mutex
+	failures map[string]*sinkFailure
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewTracker(conf config.SelfTracking, app config.App, m *meta.CollectorMeta) *Tracker {
+	if conf.HeartbeatSeconds <= 0 {
+		conf.HeartbeatSeconds = DEFAULT_HEARTBEAT_SECONDS
+	}
+	if conf.InvalidSpike.Rate <= 0 {
+		conf.InvalidSpike.Rate = DEFAULT_INVALID_SPIKE_RATE
+	}
+	if conf.InvalidSpike.MinEnvelopes <= 0 {
+		conf.InvalidSpike.MinEnvelopes = DEFAULT_INVALID_SPIKE_MIN_ENVELOPES
+	}
+	return &Tracker{
+		conf:     conf,
+		app:      app,
+		meta:     m,
+		interval: time.Duration(conf.HeartbeatSeconds) * time.Second,
+		now:      time.Now,
+		failures: make(map[string]*sinkFailure),
+	}
+}
+
+// SinkFailed collects the failures of sinks, to report with the next
+// heartbeat. It is a backendutils.FailureListener.
+func (t *Tracker) SinkFailed(sink backendutils.SinkMetadata, envelopes []envelope.Envelope, err error) {
+	if sink.Name == t.conf.Sink {
+		// Reporting the tracking sink's failures to itself would fail again
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.failures[sink.Name]
+	if !ok {
+		f = &sinkFailure{}
+		t.failures[sink.Name] = f
+	}
+	f.failed += int64(len(envelopes))
+	if err != nil {
+		f.err = err.Error()
+	}
+}
+
+// Start emits the startup event to the tracking sink, and then a
+// heartbeat every interval until the tracker is closed
+func (t *Tracker) Start(sinks []backendutils.Sink, stats func() manifold.Stats) error {
+	for _, s := range sinks {
+		if s.Metadata().Name == t.conf.Sink {
+			t.sink = s
+		}
+	}
+	if t.sink == nil {
+		return errors.New("self tracking sink not found: " + t.conf.Sink)
+	}
+	t.stats = stats
+	t.last = stats()
+	t.emit(t.payload(STARTUP))
+	t.stop, t.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.beat()
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close reports outstanding sink failures and emits the shutdown event.
+// It must be called before the sinks are shut down.
+func (t *Tracker) Close() {
+	if t.stop == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+	t.reportFailures()
+	t.emit(t.payload(SHUTDOWN))
+}
+
+func (t *Tracker) payload(event string) envelope.Payload {
+	p := envelope.Payload{
+		"event": event,
+		"time":  t.now().UTC().Format(time.RFC3339Nano),
+	}
+	if t.meta != nil {
+		p["instanceId"] = t.meta.InstanceId.String()
+		p["name"] = t.meta.Name
+		p["version"] = t.meta.Version
+		p["uptimeSeconds"] = t.meta.Elapsed()
+	}
+	return p
+}
+
+func (t *Tracker) beat() {
+	s := t.stats()
+	p := t.payload(HEARTBEAT)
+	p["stats"] = map[string]interface{}{
+		"received":      s.Received,
+		"valid":         s.Valid,
+		"invalid":       s.Invalid,
+		"rejected":      s.Rejected,
+		"delivered":     s.Delivered,
+		"failed":        s.Failed,
+		"dropped":       s.Dropped,
+		"queuedBatches": s.QueuedBatches,
+	}
+	t.emit(p)
+	t.reportFailures()
+	t.reportInvalidSpike(s)
+	t.last = s
+}
+
+func (t *Tracker) reportFailures() {
+	t.mu.Lock()
+	failures := t.failures
+	t.failures = make(map[string]*sinkFailure)
+	t.mu.Unlock()
+	for sink, f := range failures {
+		p := t.payload(SINK_FAILURE)
+		p["sink"] = sink
+		p["failed"] = f.failed
+		if f.err != "" {
+			p["error"] = f.err
+		}
+		t.emit(p)
+	}
+}
+
+// reportInvalidSpike reports the share of invalid envelopes since the
+// last heartbeat, if it is over the rate
+func (t *Tracker) reportInvalidSpike(s manifold.Stats) {
+	valid, invalid := s.Valid-t.last.Valid, s.Invalid-t.last.Invalid
+	total := valid + invalid
+	if total < t.conf.InvalidSpike.MinEnvelopes {
+		return
+	}
+	rate := float64(invalid) / float64(total)
+	if rate < t.conf.InvalidSpike.Rate {
+		return
+	}
+	p := t.payload(INVALID_SPIKE)
+	p["valid"] = valid
+	p["invalid"] = invalid
+	p["invalidRate"] = rate
+	t.emit(p)
+}
+
+func (t *Tracker) emit(payload envelope.Payload) {
+	e := envelope.NewEnvelope(t.app)
+	e.Protocol = COLLECTOR
+	e.Schema = COLLECTOR_EVENT_SCHEMA
+	e.Vendor = "io.silverton"
+	e.Namespace = "buz.internal.collector.event"
+	e.Version = "1.0"
+	e.IsValid = true
+	e.Payload = payload
+	name := t.sink.Metadata().Name
+	if err := t.sink.Enqueue([]envelope.Envelope{e}); err != nil {
+		log.Error().Err(err).Str("event", payload["event"].(string)).Msg("🔴 could not emit collector event")
+		return
+	}
+	backendutils.Stats().Enqueued(name, 1)
+}
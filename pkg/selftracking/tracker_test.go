@@ -0,0 +1,88 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package selftracking
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/manifold"
+	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSink struct {
+	mu        sync.Mutex
+	name      string
+	envelopes []envelope.Envelope
+}
+
+func (s *recordingSink) Metadata() backendutils.SinkMetadata {
+	return backendutils.SinkMetadata{Name: s.name}
+}
+func (s *recordingSink) Initialize(conf config.Sink) error { return nil }
+func (s *recordingSink) StartWorker() error                { return nil }
+func (s *recordingSink) Enqueue(envelopes []envelope.Envelope) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.envelopes = append(s.envelopes, envelopes...)
+	return nil
+}
+func (s *recordingSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	return nil
+}
+func (s *recordingSink) Shutdown() error { return nil }
+
+func (s *recordingSink) events() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []string
+	for _, e := range s.envelopes {
+		events = append(events, e.Payload["event"].(string))
+	}
+	return events
+}
+
+func TestTracker(t *testing.T) {
+	self := &recordingSink{name: "self"}
+	sinks := []backendutils.Sink{&recordingSink{name: "kafka"}, self}
+	stats := manifold.Stats{Valid: 10}
+	tracker := NewTracker(config.SelfTracking{Sink: "self"}, config.App{Name: "buz"}, &meta.CollectorMeta{Name: "buz", Version: "1.0"})
+	assert.Nil(t, tracker.Start(sinks, func() manifold.Stats { return stats }))
+
+	tracker.SinkFailed(backendutils.SinkMetadata{Name: "kafka"}, make([]envelope.Envelope, 5), errors.New("broker down"))
+	tracker.SinkFailed(backendutils.SinkMetadata{Name: "kafka"}, make([]envelope.Envelope, 3), errors.New("broker still down"))
+	tracker.SinkFailed(backendutils.SinkMetadata{Name: "self"}, make([]envelope.Envelope, 1), errors.New("ignored"))
+	stats = manifold.Stats{Valid: 100, Invalid: 50}
+	tracker.beat()
+	// Spikes are only reported once
+	tracker.beat()
+	tracker.Close()
+
+	assert.Equal(t, []string{STARTUP, HEARTBEAT, SINK_FAILURE, INVALID_SPIKE, HEARTBEAT, SHUTDOWN}, self.events())
+	for _, e := range self.envelopes {
+		assert.Equal(t, COLLECTOR_EVENT_SCHEMA, e.Schema)
+		assert.True(t, e.IsValid)
+		assert.Equal(t, "1.0", e.Payload["version"])
+	}
+	failure := self.envelopes[2].Payload
+	assert.Equal(t, "kafka", failure["sink"])
+	assert.Equal(t, int64(8), failure["failed"])
+	assert.Equal(t, "broker still down", failure["error"])
+	spike := self.envelopes[3].Payload
+	assert.Equal(t, int64(90), spike["valid"])
+	assert.Equal(t, int64(50), spike["invalid"])
+	assert.InDelta(t, 0.357, spike["invalidRate"], 0.001)
+}
+
+func TestTrackerSinkNotFound(t *testing.T) {
+	tracker := NewTracker(config.SelfTracking{Sink: "missing"}, config.App{}, nil)
+	assert.NotNil(t, tracker.Start(nil, func() manifold.Stats { return manifold.Stats{} }))
+}
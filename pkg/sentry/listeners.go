@@ -0,0 +1,79 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sentry
+
+import (
+	"fmt"
+	"sort"
+
+	sentrygo "github.com/getsentry/sentry-go"
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// Error types
+const (
+	PANIC          string = "panic"
+	SINK_FAILURE   string = "sink_failure"
+	REGISTRY_ERROR string = "registry_error"
+)
+
+// SinkFailed reports envelopes a sink failed to deliver, tagged with the
+// sink and the schema and input of the first envelope. It is a
+// backendutils.FailureListener.
+func (r *Reporter) SinkFailed(sink backendutils.SinkMetadata, envelopes []envelope.Envelope, err error) {
+	if err == nil {
+		err = fmt.Errorf("sink %s failed", sink.Name)
+	}
+	tags := map[string]string{"sink": sink.Name, "sinkType": sink.SinkType}
+	if len(envelopes) > 0 {
+		tags["schema"] = envelopes[0].Schema
+		tags["input"] = envelopes[0].Protocol
+	}
+	seen := make(map[string]bool)
+	var schemas []string
+	for _, e := range envelopes {
+		if !seen[e.Schema] {
+			seen[e.Schema] = true
+			schemas = append(schemas, e.Schema)
+		}
+	}
+	sort.Strings(schemas)
+	r.Capture(ERROR, SINK_FAILURE, err, tags, map[string]interface{}{
+		"envelopes": len(envelopes),
+		"schemas":   schemas,
+	})
+}
+
+// RegistryFailed reports a schema the registry backend failed to serve. It
+// is a registry.ErrorListener.
+func (r *Reporter) RegistryFailed(schema string, err error) {
+	r.Capture(ERROR, REGISTRY_ERROR, err, map[string]string{"schema": schema}, nil)
+}
+
+// Middleware reports panics while handling requests, tagged with the route
+// and input, and then panics again for the recovery middleware to respond
+func (r *Reporter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				route := c.FullPath()
+				if route == "" {
+					route = "unmatched"
+				}
+				// The deferred call still runs on the panicking stack
+				r.capture(FATAL, PANIC, fmt.Errorf("%v", recovered), sentrygo.NewStacktrace(), map[string]string{
+					"route":  route,
+					"method": c.Request.Method,
+				}, map[string]interface{}{
+					"path": c.Request.URL.Path,
+				})
+				panic(recovered)
+			}
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sentry
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sentrygo "github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/meta"
+)
+
+// Levels
+const (
+	ERROR sentrygo.Level = sentrygo.LevelError
+	FATAL sentrygo.Level = sentrygo.LevelFatal
+)
+
+const (
+	DEFAULT_SENTRY_DEDUPE_SECONDS int = 60
+	DEFAULT_SENTRY_TIMEOUT_MS     int = 5000
+	// Events are dropped rather than blocking the caller when this many
+	// are waiting to be sent
+	SENTRY_QUEUE_SIZE int = 100
+)
+
+var ErrInvalidDsn = errors.New("invalid sentry dsn")
+
+// Reporter sends events to sentry in the background, so reporting never
+// slows down collection
+type Reporter struct {
+	hub      *sentrygo.Hub
+	timeout  time.Duration
+	dedupe   time.Duration
+	now      func() time.Time
+	mu       sync.Mutex
+	reported map[string]time.Time
+	closed   bool
+}
+
+func NewReporter(conf config.Sentry, app config.App, m *meta.CollectorMeta) (*Reporter, error) {
+	return newReporter(conf, app, m, nil)
+}
+
+// newReporter builds a reporter which sends events with the transport,
+// or over http if the transport is nil
+func newReporter(conf config.Sentry, app config.App, m *meta.CollectorMeta, transport sentrygo.Transport) (*Reporter, error) {
+	if conf.Dsn == "" {
+		return nil, ErrInvalidDsn
+	}
+	timeout := conf.TimeoutMs
+	if timeout <= 0 {
+		timeout = DEFAULT_SENTRY_TIMEOUT_MS
+	}
+	if transport == nil {
+		t := sentrygo.NewHTTPTransport()
+		t.Timeout = time.Duration(timeout) * time.Millisecond
+		t.BufferSize = SENTRY_QUEUE_SIZE
+		transport = t
+	}
+	opts := sentrygo.ClientOptions{
+		Dsn:         conf.Dsn,
+		Environment: conf.Environment,
+		SampleRate:  conf.SampleRate,
+		Transport:   transport,
+	}
+	if opts.Environment == "" {
+		opts.Environment = app.Env
+	}
+	if opts.SampleRate <= 0 {
+		opts.SampleRate = 1
+	}
+	if m != nil {
+		opts.Release = "buz@" + m.Version
+		opts.ServerName = m.InstanceId.String()
+	}
+	client, err := sentrygo.NewClient(opts)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not build sentry client")
+		return nil, ErrInvalidDsn
+	}
+	r := &Reporter{
+		hub:      sentrygo.NewHub(client, sentrygo.NewScope()),
+		timeout:  time.Duration(timeout) * time.Millisecond,
+		dedupe:   time.Duration(conf.DedupeSeconds) * time.Second,
+		now:      time.Now,
+		reported: make(map[string]time.Time),
+	}
+	if r.dedupe <= 0 {
+		r.dedupe = time.Duration(DEFAULT_SENTRY_DEDUPE_SECONDS) * time.Second
+	}
+	return r, nil
+}
+
+// Capture reports an error, with the stack trace carried by the error if
+// there is one. The same error, with the same tags, is only reported once
+// per dedupe window.
+func (r *Reporter) Capture(level sentrygo.Level, errorType string, err error, tags map[string]string, extra map[string]interface{}) {
+	r.capture(level, errorType, err, sentrygo.ExtractStacktrace(err), tags, extra)
+}
+
+func (r *Reporter) capture(level sentrygo.Level, errorType string, err error, stacktrace *sentrygo.Stacktrace, tags map[string]string, extra map[string]interface{}) {
+	fingerprint := []string{errorType, err.Error()}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fingerprint = append(fingerprint, k+"="+tags[k])
+	}
+	if !r.shouldReport(strings.Join(fingerprint, "|")) {
+		return
+	}
+	e := sentrygo.NewEvent()
+	e.Level = level
+	e.Logger = "buz"
+	e.Fingerprint = fingerprint
+	e.Exception = []sentrygo.Exception{{Type: errorType, Value: err.Error(), Stacktrace: stacktrace}}
+	e.Tags = tags
+	for k, v := range extra {
+		e.Extra[k] = v
+	}
+	r.hub.CaptureEvent(e)
+}
+
+func (r *Reporter) shouldReport(fingerprint string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	now := r.now()
+	if last, ok := r.reported[fingerprint]; ok && now.Sub(last) < r.dedupe {
+		return false
+	}
+	for f, last := range r.reported {
+		if now.Sub(last) >= r.dedupe {
+			delete(r.reported, f)
+		}
+	}
+	r.reported[fingerprint] = now
+	return true
+}
+
+// Close sends the events which are waiting
+func (r *Reporter) Close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	if !r.hub.Flush(r.timeout) {
+		log.Debug().Msg("🟡 could not send all sentry events before closing")
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sentry
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	sentrygo "github.com/getsentry/sentry-go"
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/backend/backendutils"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingTransport struct {
+	mu     sync.Mutex
+	events []*sentrygo.Event
+}
+
+func (t *recordingTransport) Configure(options sentrygo.ClientOptions) {}
+
+func (t *recordingTransport) SendEvent(e *sentrygo.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, e)
+}
+
+func (t *recordingTransport) Flush(timeout time.Duration) bool {
+	return true
+}
+
+func newTestReporter(t *testing.T) (*Reporter, *recordingTransport) {
+	s := &recordingTransport{}
+	r, err := newReporter(config.Sentry{Dsn: "https://public@sentry.example.com/42"}, config.App{Env: "prod"}, &meta.CollectorMeta{Version: "1.0"}, s)
+	assert.Nil(t, err)
+	return r, s
+}
+
+func TestNewReporter(t *testing.T) {
+	for _, dsn := range []string{"", "https://o1.ingest.sentry.io/42", "not a dsn"} {
+		_, err := NewReporter(config.Sentry{Dsn: dsn}, config.App{}, nil)
+		assert.ErrorIs(t, err, ErrInvalidDsn)
+	}
+}
+
+func TestReporter(t *testing.T) {
+	r, s := newTestReporter(t)
+	sink := backendutils.SinkMetadata{Name: "warehouse", SinkType: "postgres"}
+	envelopes := []envelope.Envelope{
+		{Schema: "com.yourcompany/checkout/v1.0.json", Protocol: "webhook"},
+		{Schema: "com.yourcompany/cart/v1.0.json", Protocol: "webhook"},
+	}
+	r.SinkFailed(sink, envelopes, errors.New("connection refused"))
+	// The same failure is only reported once per window
+	r.SinkFailed(sink, envelopes, errors.New("connection refused"))
+	r.RegistryFailed("com.yourcompany/checkout/v1.0.json", errors.New("timeout"))
+	r.Close()
+
+	assert.Len(t, s.events, 2)
+	failure := s.events[0]
+	assert.Equal(t, ERROR, failure.Level)
+	assert.Equal(t, "prod", failure.Environment)
+	assert.Equal(t, "buz@1.0", failure.Release)
+	assert.Equal(t, []sentrygo.Exception{{Type: SINK_FAILURE, Value: "connection refused"}}, failure.Exception)
+	assert.Equal(t, map[string]string{
+		"sink":     "warehouse",
+		"sinkType": "postgres",
+		"schema":   "com.yourcompany/checkout/v1.0.json",
+		"input":    "webhook",
+	}, failure.Tags)
+	assert.Equal(t, []string{"com.yourcompany/cart/v1.0.json", "com.yourcompany/checkout/v1.0.json"}, failure.Extra["schemas"])
+	assert.Equal(t, "com.yourcompany/checkout/v1.0.json", s.events[1].Tags["schema"])
+
+	// Errors after closing are dropped
+	r.RegistryFailed("com.yourcompany/cart/v1.0.json", errors.New("timeout"))
+}
+
+func TestMiddleware(t *testing.T) {
+	r, s := newTestReporter(t)
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.Use(gin.Recovery(), r.Middleware())
+	e.GET("/boom", func(c *gin.Context) { panic("boom") })
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/boom", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	r.Close()
+
+	assert.Len(t, s.events, 1)
+	assert.Equal(t, FATAL, s.events[0].Level)
+	exception := s.events[0].Exception[0]
+	assert.Equal(t, PANIC, exception.Type)
+	assert.Equal(t, "/boom", s.events[0].Tags["route"])
+	// The frames include the handler which panicked
+	assert.NotNil(t, exception.Stacktrace)
+	var functions []string
+	for _, f := range exception.Stacktrace.Frames {
+		functions = append(functions, f.Function)
+	}
+	assert.Contains(t, functions, "TestMiddleware.func1")
+}
@@ -27,9 +27,41 @@ import (
 	"github.com/silverton-io/buz/pkg/backend/stdout"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/expression"
 )
 
+// Sinks which write bytes, and support binary output formats
+var binarySinks = map[string]bool{
+	constants.KAFKA:    true,
+	constants.REDPANDA: true,
+	constants.PUBSUB:   true,
+	constants.KINESIS:  true,
+}
+
+// Sinks which can compress their payloads
+var compressingSinks = map[string]bool{
+	constants.KAFKA:    true,
+	constants.REDPANDA: true,
+	constants.HTTP:     true,
+	constants.HTTPS:    true,
+	constants.SPLUNK:   true,
+}
+
+// Builder returns a new, uninitialized sink
+type Builder func() backendutils.Sink
+
+var builders = make(map[string]Builder)
+
+// Register adds a custom sink type, or replaces a built-in one. Sinks
+// must be registered before the app is initialized.
+func Register(sinkType string, b Builder) {
+	builders[sinkType] = b
+}
+
 func getSink(conf config.Sink) (sink backendutils.Sink, err error) {
+	if b, ok := builders[conf.Type]; ok {
+		return b(), nil
+	}
 	switch conf.Type {
 	// System
 	case constants.BLACKHOLE:
@@ -106,8 +138,35 @@ func getSink(conf config.Sink) (sink backendutils.Sink, err error) {
 }
 
 func NewSink(conf config.Sink) (backendutils.Sink, error) {
-	sink, _ := getSink(conf)
-	err := sink.Initialize(conf)
+	if conf.Filter != "" {
+		if _, err := expression.Cached(conf.Filter); err != nil {
+			log.Error().Err(err).Msg("🔴 invalid filter for sink " + conf.Name)
+			return nil, err
+		}
+	}
+	if _, registered := builders[conf.Type]; !registered && !binarySinks[conf.Type] && conf.Format != "" && conf.Format != backendutils.JSON {
+		err := errors.New("unsupported output format for sink " + conf.Name + ": " + conf.Format)
+		log.Error().Err(err).Msg("🔴 unsupported output format")
+		return nil, err
+	}
+	if _, registered := builders[conf.Type]; !registered && !compressingSinks[conf.Type] && conf.Compression != "" {
+		err := errors.New("unsupported compression for sink " + conf.Name + ": " + conf.Compression)
+		log.Error().Err(err).Msg("🔴 unsupported compression")
+		return nil, err
+	}
+	if err := backendutils.ValidateReceives(conf.Receives); err != nil {
+		log.Error().Err(err).Msg("🔴 invalid receives for sink " + conf.Name)
+		return nil, err
+	}
+	if err := backendutils.ValidatePoisonPolicy(conf.OnPoison); err != nil {
+		log.Error().Err(err).Msg("🔴 invalid poison policy for sink " + conf.Name)
+		return nil, err
+	}
+	sink, err := getSink(conf)
+	if err != nil {
+		return nil, err
+	}
+	err = sink.Initialize(conf)
 	if err != nil {
 		log.Fatal().Err(err).Msg("🔴 could not initialize sink")
 		return nil, err
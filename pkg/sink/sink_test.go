@@ -5,9 +5,14 @@
 package sink
 
 import (
+	"context"
+	"testing"
+
 	"github.com/google/uuid"
 	"github.com/silverton-io/buz/pkg/backend/backendutils"
 	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -32,7 +37,37 @@ func (ms *MockSink) Initialize(conf config.Sink) error {
 	return nil
 }
 
+func (ms *MockSink) StartWorker() error {
+	ms.Called()
+	return nil
+}
+
+func (ms *MockSink) Enqueue(envelopes []envelope.Envelope) error {
+	ms.Called(envelopes)
+	return nil
+}
+
+func (ms *MockSink) Dequeue(ctx context.Context, envelopes []envelope.Envelope, output string) error {
+	ms.Called(ctx, envelopes, output)
+	return nil
+}
+
 func (ms *MockSink) Shutdown() error {
 	ms.Called()
 	return nil
 }
+
+func TestRegisteredSink(t *testing.T) {
+	Register("mock", func() backendutils.Sink { return &MockSink{} })
+	defer delete(builders, "mock")
+	s, err := getSink(config.Sink{Type: "mock"})
+	assert.Nil(t, err)
+	assert.IsType(t, &MockSink{}, s)
+	_, err = getSink(config.Sink{Type: "unregistered"})
+	assert.NotNil(t, err)
+}
+
+func TestNewSinkRejectsBinaryFormat(t *testing.T) {
+	_, err := NewSink(config.Sink{Name: "local", Type: "file", Format: backendutils.AVRO})
+	assert.NotNil(t, err)
+}
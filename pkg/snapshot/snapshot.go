@@ -0,0 +1,96 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshots are bounded so a malicious or corrupt archive can't exhaust memory
+const (
+	MAX_SCHEMA_BYTES   int64 = 10 * 1024 * 1024
+	MAX_SNAPSHOT_BYTES int64 = 512 * 1024 * 1024
+)
+
+var ErrInvalidSnapshot = errors.New("invalid schema snapshot")
+
+// All entries share a modification time so identical schemas produce
+// identical snapshots.
+var epoch = time.Unix(0, 0).UTC()
+
+// Write writes the schemas as a gzipped tarball, keyed by schema name
+func Write(w io.Writer, schemas map[string][]byte) error {
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		contents := schemas[name]
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(contents)),
+			ModTime: epoch,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(contents); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Read reads the schemas of a gzipped tarball written by Write
+func Read(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSnapshot, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	schemas := make(map[string][]byte)
+	var total int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return schemas, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSnapshot, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") || !strings.HasSuffix(name, ".json") {
+			return nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidSnapshot, header.Name)
+		}
+		total += header.Size
+		if header.Size > MAX_SCHEMA_BYTES || total > MAX_SNAPSHOT_BYTES {
+			return nil, fmt.Errorf("%w: too large", ErrInvalidSnapshot)
+		}
+		contents, err := io.ReadAll(io.LimitReader(tr, header.Size))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSnapshot, err)
+		}
+		schemas[name] = contents
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRead(t *testing.T) {
+	schemas := map[string][]byte{
+		"com.acme/order/v1.0.json": []byte(`{"type": "object"}`),
+		"com.acme/user/v1.0.json":  []byte(`{"type": "string"}`),
+	}
+	var first, second bytes.Buffer
+	assert.Nil(t, Write(&first, schemas))
+	assert.Nil(t, Write(&second, schemas))
+	assert.Equal(t, first.Bytes(), second.Bytes(), "snapshots should be reproducible")
+
+	read, err := Read(&first)
+	assert.Nil(t, err)
+	assert.Equal(t, schemas, read)
+}
+
+func TestReadRejectsUnsafeEntries(t *testing.T) {
+	for _, name := range []string{"../etc/passwd.json", "/abs/v1.0.json", "com.acme/readme.md"} {
+		var archive bytes.Buffer
+		gz := gzip.NewWriter(&archive)
+		tw := tar.NewWriter(gz)
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 2, Typeflag: tar.TypeReg})
+		tw.Write([]byte(`{}`))
+		tw.Close()
+		gz.Close()
+		_, err := Read(&archive)
+		assert.True(t, errors.Is(err, ErrInvalidSnapshot), name)
+	}
+	_, err := Read(bytes.NewReader([]byte("not a tarball")))
+	assert.True(t, errors.Is(err, ErrInvalidSnapshot))
+}
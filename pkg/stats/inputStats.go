@@ -0,0 +1,212 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package stats
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Input throughput is kept in per-second buckets, and summarized over
+// rolling windows of up to INPUT_STATS_SECONDS
+const INPUT_STATS_SECONDS int = 900
+
+var INPUT_STATS_WINDOWS = []int{60, 300, 900}
+
+// Request latencies are counted in buckets whose upper bounds double from
+// LATENCY_BUCKET_MS, so percentiles are accurate to within a factor of two
+const (
+	LATENCY_BUCKET_MS float64 = 0.25
+	LATENCY_BUCKETS   int     = 24
+)
+
+type inputBucket struct {
+	second    int64
+	requests  int64
+	errors    int64
+	events    int64
+	invalid   int64
+	latencies [LATENCY_BUCKETS + 1]int64
+}
+
+// InputWindow summarizes an input's throughput over a window
+type InputWindow struct {
+	Requests          int64   `json:"requests"`
+	Errors            int64   `json:"errors"` // Requests which failed with a server error
+	Events            int64   `json:"events"`
+	Invalid           int64   `json:"invalid"`
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	EventsPerSecond   float64 `json:"eventsPerSecond"`
+	ErrorRate         float64 `json:"errorRate"`
+	InvalidRate       float64 `json:"invalidRate"`
+	P95LatencyMs      float64 `json:"p95LatencyMs"`
+}
+
+// InputStats counts the requests and events of each input over rolling
+// windows
+type InputStats struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	started time.Time
+	inputs  map[string]*[INPUT_STATS_SECONDS]inputBucket
+}
+
+func NewInputStats() *InputStats {
+	s := &InputStats{now: time.Now, inputs: make(map[string]*[INPUT_STATS_SECONDS]inputBucket)}
+	s.started = s.now()
+	return s
+}
+
+var inputStats = NewInputStats()
+
+// Inputs returns the throughput stats of every input
+func Inputs() *InputStats {
+	return inputStats
+}
+
+// Track adds an input, so it is summarized before it has any traffic.
+// Only the events of tracked inputs are counted.
+func (s *InputStats) Track(input string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inputs[input] == nil {
+		s.inputs[input] = &[INPUT_STATS_SECONDS]inputBucket{}
+	}
+}
+
+// bucket returns the input's bucket of the current second, clearing it if
+// it holds an older second. The lock must be held.
+func (s *InputStats) bucket(input string) *inputBucket {
+	buckets := s.inputs[input]
+	if buckets == nil {
+		return nil
+	}
+	second := s.now().Unix()
+	b := &buckets[second%int64(INPUT_STATS_SECONDS)]
+	if b.second != second {
+		*b = inputBucket{second: second}
+	}
+	return b
+}
+
+func latencyBucket(latency time.Duration) int {
+	ms := float64(latency) / float64(time.Millisecond)
+	bound := LATENCY_BUCKET_MS
+	for i := 0; i < LATENCY_BUCKETS; i++ {
+		if ms <= bound {
+			return i
+		}
+		bound *= 2
+	}
+	return LATENCY_BUCKETS
+}
+
+// latencyBound returns the upper bound of the latency bucket, in ms
+func latencyBound(i int) float64 {
+	bound := LATENCY_BUCKET_MS
+	for ; i > 0; i-- {
+		bound *= 2
+	}
+	return bound
+}
+
+// Request counts a request to the input
+func (s *InputStats) Request(input string, latency time.Duration, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.bucket(input)
+	if b == nil {
+		return
+	}
+	b.requests++
+	if status >= http.StatusInternalServerError {
+		b.errors++
+	}
+	b.latencies[latencyBucket(latency)]++
+}
+
+// Events counts the envelopes of the input
+func (s *InputStats) Events(input string, events int64, invalid int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.bucket(input)
+	if b == nil {
+		return
+	}
+	b.events += events
+	b.invalid += invalid
+}
+
+// windowName names a window of seconds, such as 5m
+func windowName(seconds int) string {
+	if seconds%60 == 0 {
+		return strconv.Itoa(seconds/60) + "m"
+	}
+	return strconv.Itoa(seconds) + "s"
+}
+
+// summarize sums the buckets of the window. Windows longer than the
+// stats have been kept are averaged over the time they have been kept.
+func (s *InputStats) summarize(buckets *[INPUT_STATS_SECONDS]inputBucket, now time.Time, seconds int) InputWindow {
+	var w InputWindow
+	var latencies [LATENCY_BUCKETS + 1]int64
+	current := now.Unix()
+	for i := range buckets {
+		b := &buckets[i]
+		if b.second <= current-int64(seconds) || b.second > current {
+			continue
+		}
+		w.Requests += b.requests
+		w.Errors += b.errors
+		w.Events += b.events
+		w.Invalid += b.invalid
+		for j, n := range b.latencies {
+			latencies[j] += n
+		}
+	}
+	elapsed := now.Sub(s.started).Seconds()
+	if elapsed > float64(seconds) {
+		elapsed = float64(seconds)
+	}
+	if elapsed < 1 {
+		elapsed = 1
+	}
+	w.RequestsPerSecond = float64(w.Requests) / elapsed
+	w.EventsPerSecond = float64(w.Events) / elapsed
+	if w.Requests > 0 {
+		w.ErrorRate = float64(w.Errors) / float64(w.Requests)
+		rank := int64(0.95*float64(w.Requests) + 0.5)
+		var seen int64
+		for i, n := range latencies {
+			seen += n
+			if seen >= rank {
+				w.P95LatencyMs = latencyBound(i)
+				break
+			}
+		}
+	}
+	if w.Events > 0 {
+		w.InvalidRate = float64(w.Invalid) / float64(w.Events)
+	}
+	return w
+}
+
+// Snapshot summarizes every input over each window, by input and window
+func (s *InputStats) Snapshot() map[string]map[string]InputWindow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	snapshot := make(map[string]map[string]InputWindow, len(s.inputs))
+	for input, buckets := range s.inputs {
+		windows := make(map[string]InputWindow, len(INPUT_STATS_WINDOWS))
+		for _, seconds := range INPUT_STATS_WINDOWS {
+			windows[windowName(seconds)] = s.summarize(buckets, now, seconds)
+		}
+		snapshot[input] = windows
+	}
+	return snapshot
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package stats
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInputStats(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := NewInputStats()
+	s.now = func() time.Time { return now }
+	s.started = now.Add(-time.Hour)
+	s.Track("webhook")
+	s.Track("pixel")
+
+	// Ten minutes ago, only in the 15m window
+	now = now.Add(-10 * time.Minute)
+	for i := 0; i < 60; i++ {
+		s.Request("webhook", 100*time.Millisecond, http.StatusOK)
+	}
+	s.Events("webhook", 600, 0)
+	now = now.Add(10 * time.Minute)
+	for i := 0; i < 19; i++ {
+		s.Request("webhook", time.Millisecond, http.StatusOK)
+	}
+	s.Request("webhook", 50*time.Millisecond, http.StatusInternalServerError)
+	s.Events("webhook", 60, 15)
+	// Untracked protocols, such as heartbeats, aren't inputs
+	s.Events("heartbeat", 1, 0)
+
+	snapshot := s.Snapshot()
+	assert.Len(t, snapshot, 2)
+	assert.Equal(t, InputWindow{}, snapshot["pixel"]["1m"])
+	recent := snapshot["webhook"]["1m"]
+	assert.Equal(t, int64(20), recent.Requests)
+	assert.Equal(t, int64(1), recent.Errors)
+	assert.Equal(t, int64(60), recent.Events)
+	assert.InDelta(t, 20.0/60, recent.RequestsPerSecond, 0.0001)
+	assert.InDelta(t, 1.0, recent.EventsPerSecond, 0.0001)
+	assert.Equal(t, 0.05, recent.ErrorRate)
+	assert.Equal(t, 0.25, recent.InvalidRate)
+	assert.Equal(t, 1.0, recent.P95LatencyMs)
+	five := snapshot["webhook"]["5m"]
+	assert.Equal(t, recent.Requests, five.Requests)
+	assert.InDelta(t, 1.0/5, five.EventsPerSecond, 0.0001)
+
+	all := snapshot["webhook"]["15m"]
+	assert.Equal(t, int64(80), all.Requests)
+	assert.Equal(t, int64(660), all.Events)
+	assert.Equal(t, 128.0, all.P95LatencyMs)
+
+	// Buckets are reused once their second has passed out of every window
+	now = now.Add(5 * time.Minute)
+	s.Request("webhook", time.Millisecond, http.StatusOK)
+	assert.Equal(t, int64(21), s.Snapshot()["webhook"]["15m"].Requests)
+	now = now.Add(10 * time.Minute)
+	assert.Equal(t, int64(1), s.Snapshot()["webhook"]["15m"].Requests)
+}
+
+func TestInputStatsRatesBeforeWindowFills(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := NewInputStats()
+	s.now = func() time.Time { return now }
+	s.started = now.Add(-10 * time.Second)
+	s.Track("webhook")
+	s.Events("webhook", 100, 0)
+	assert.InDelta(t, 10.0, s.Snapshot()["webhook"]["15m"].EventsPerSecond, 0.0001)
+}
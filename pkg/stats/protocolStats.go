@@ -4,42 +4,50 @@
 
 package stats
 
+import "sync"
+
+// ProtocolStats counts valid and invalid envelopes by protocol and
+// namespace
 type ProtocolStats struct {
-	// vmu     sync.Mutex
-	// imu     sync.Mutex
+	mu      sync.Mutex
 	Invalid map[string]map[string]int64 `json:"invalid"`
 	Valid   map[string]map[string]int64 `json:"valid"`
 }
 
-// func (ps *ProtocolStats) Build() {
-// 	var vProtoStat = make(map[string]map[string]int64)
-// 	var invProtoStat = make(map[string]map[string]int64)
-// 	ps.Valid = vProtoStat
-// 	ps.Invalid = invProtoStat
-// 	for _, protocol := range protocol.GetInputProtocols() {
-// 		var vEventStat = make(map[string]int64)
-// 		var invEventStat = make(map[string]int64)
-// 		ps.Valid[protocol] = vEventStat
-// 		ps.Invalid[protocol] = invEventStat
-// 	}
-// }
+func NewProtocolStats() *ProtocolStats {
+	return &ProtocolStats{
+		Invalid: make(map[string]map[string]int64),
+		Valid:   make(map[string]map[string]int64),
+	}
+}
 
-// func (ps *ProtocolStats) IncrementValid(event *envelope.EventMeta, count int64) {
-// 	i := ps.Valid[event.Protocol][event.Namespace]
-// 	ps.vmu.Lock()
-// 	defer ps.vmu.Unlock()
-// 	ps.Valid[event.Protocol][event.Namespace] = i + count
-// }
+func (ps *ProtocolStats) Increment(protocol string, namespace string, valid bool, count int64) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	counts := ps.Invalid
+	if valid {
+		counts = ps.Valid
+	}
+	if counts[protocol] == nil {
+		counts[protocol] = make(map[string]int64)
+	}
+	counts[protocol][namespace] += count
+}
 
-// func (ps *ProtocolStats) IncrementInvalid(event *envelope.EventMeta, count int64) {
-// 	i := ps.Invalid[event.Protocol][event.Namespace]
-// 	ps.imu.Lock()
-// 	defer ps.imu.Unlock()
-// 	ps.Invalid[event.Protocol][event.Namespace] = i + count
-// }
+func copyCounts(counts map[string]map[string]int64) map[string]map[string]int64 {
+	c := make(map[string]map[string]int64, len(counts))
+	for protocol, namespaces := range counts {
+		c[protocol] = make(map[string]int64, len(namespaces))
+		for namespace, count := range namespaces {
+			c[protocol][namespace] = count
+		}
+	}
+	return c
+}
 
-// func BuildProtocolStats() *ProtocolStats {
-// 	ps := ProtocolStats{}
-// 	ps.Build()
-// 	return &ps
-// }
+// Snapshot returns a copy of the counts
+func (ps *ProtocolStats) Snapshot() *ProtocolStats {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return &ProtocolStats{Invalid: copyCounts(ps.Invalid), Valid: copyCounts(ps.Valid)}
+}
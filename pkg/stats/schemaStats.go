@@ -0,0 +1,41 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package stats
+
+import "sync"
+
+// SchemaStats counts envelopes by schema and key, such as pii detections
+// by detector
+type SchemaStats struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int64
+}
+
+func NewSchemaStats() *SchemaStats {
+	return &SchemaStats{counts: make(map[string]map[string]int64)}
+}
+
+func (s *SchemaStats) Increment(schema string, key string, count int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts[schema] == nil {
+		s.counts[schema] = make(map[string]int64)
+	}
+	s.counts[schema][key] += count
+}
+
+// Snapshot returns a copy of the counts
+func (s *SchemaStats) Snapshot() map[string]map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := make(map[string]map[string]int64, len(s.counts))
+	for schema, keys := range s.counts {
+		snapshot[schema] = make(map[string]int64, len(keys))
+		for key, count := range keys {
+			snapshot[schema][key] = count
+		}
+	}
+	return snapshot
+}
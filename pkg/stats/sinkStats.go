@@ -0,0 +1,244 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Latency percentiles are computed over the most recent deliveries
+const LATENCY_SAMPLES int = 1024
+
+type SinkSnapshot struct {
+	Enqueued           int64   `json:"enqueued"`
+	Delivered          int64   `json:"delivered"`
+	Failed             int64   `json:"failed"`
+	Retries            int64   `json:"retries"`
+	Quarantined        int64   `json:"quarantined"`
+	ThrottledMs        float64 `json:"throttledMs"`
+	Dropped            int64   `json:"dropped"`
+	QueuedBatches      int     `json:"queuedBatches"`
+	QueueCapacity      int     `json:"queueCapacity"`
+	EnqueuedPerSecond  float64 `json:"enqueuedPerSecond"`
+	DeliveredPerSecond float64 `json:"deliveredPerSecond"`
+	MeanLatencyMs      float64 `json:"meanLatencyMs"`
+	MaxLatencyMs       float64 `json:"maxLatencyMs"`
+	// Batches
+	Deliveries       int64      `json:"deliveries"`
+	FailedDeliveries int64      `json:"failedDeliveries"`
+	MeanBatchSize    float64    `json:"meanBatchSize"`
+	MaxBatchSize     int        `json:"maxBatchSize"`
+	P50LatencyMs     float64    `json:"p50LatencyMs"`
+	P95LatencyMs     float64    `json:"p95LatencyMs"`
+	P99LatencyMs     float64    `json:"p99LatencyMs"`
+	LastDeliveredAt  *time.Time `json:"lastDeliveredAt,omitempty"`
+	LastFailedAt     *time.Time `json:"lastFailedAt,omitempty"`
+}
+
+type sinkCounters struct {
+	enqueued    int64
+	delivered   int64
+	failed      int64
+	retries     int64
+	quarantined int64
+	throttled   time.Duration
+	dropped     int64
+	deliveries  int64
+	failures    int64
+	latency     time.Duration
+	maxLatency  time.Duration
+	maxBatch    int
+	samples     []time.Duration // A ring of recent latencies
+	next        int
+	lastSuccess time.Time
+	lastFailure time.Time
+	queues      []func() int
+	capacity    int
+}
+
+func (c *sinkCounters) sample(latency time.Duration) {
+	if len(c.samples) < LATENCY_SAMPLES {
+		c.samples = append(c.samples, latency)
+		return
+	}
+	c.samples[c.next] = latency
+	c.next = (c.next + 1) % LATENCY_SAMPLES
+}
+
+// percentiles returns the latencies of the samples at each percentile, in ms
+func (c *sinkCounters) percentiles(ps ...float64) []float64 {
+	out := make([]float64, len(ps))
+	if len(c.samples) == 0 {
+		return out
+	}
+	sorted := append([]time.Duration{}, c.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i, p := range ps {
+		rank := int(p*float64(len(sorted))+0.5) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		if rank >= len(sorted) {
+			rank = len(sorted) - 1
+		}
+		out[i] = float64(sorted[rank]) / float64(time.Millisecond)
+	}
+	return out
+}
+
+// SinkStats counts the envelopes enqueued to, delivered by, and dropped
+// before each sink, and tracks the depth of the queues in front of it.
+// Rates are averaged since the stats were created.
+type SinkStats struct {
+	mu    sync.Mutex
+	start time.Time
+	sinks map[string]*sinkCounters
+}
+
+func NewSinkStats() *SinkStats {
+	return &SinkStats{start: time.Now(), sinks: make(map[string]*sinkCounters)}
+}
+
+func (s *SinkStats) counters(sink string) *sinkCounters {
+	c, ok := s.sinks[sink]
+	if !ok {
+		c = &sinkCounters{}
+		s.sinks[sink] = c
+	}
+	return c
+}
+
+func (s *SinkStats) Enqueued(sink string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters(sink).enqueued += int64(count)
+}
+
+// Delivered counts a delivery of a batch of envelopes, and how long the
+// sink took
+func (s *SinkStats) Delivered(sink string, count int, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.counters(sink)
+	c.delivered += int64(count)
+	c.deliveries++
+	c.latency += latency
+	if latency > c.maxLatency {
+		c.maxLatency = latency
+	}
+	if count > c.maxBatch {
+		c.maxBatch = count
+	}
+	c.sample(latency)
+	c.lastSuccess = time.Now()
+}
+
+// Failed counts a batch of envelopes which the sink failed to deliver
+func (s *SinkStats) Failed(sink string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.counters(sink)
+	c.failed += int64(count)
+	c.failures++
+	c.lastFailure = time.Now()
+}
+
+// Retried counts the deliveries which were retried after failing
+func (s *SinkStats) Retried(sink string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters(sink).retries += int64(count)
+}
+
+// Throttled counts the time deliveries waited for the sink's rate limit
+func (s *SinkStats) Throttled(sink string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters(sink).throttled += d
+}
+
+// Quarantined counts the poison envelopes which the sink couldn't deliver
+func (s *SinkStats) Quarantined(sink string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters(sink).quarantined += int64(count)
+}
+
+func (s *SinkStats) Dropped(sink string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters(sink).dropped += int64(count)
+}
+
+// TrackQueue adds a queue of batches to the queue depth and capacity of
+// the sink
+func (s *SinkStats) TrackQueue(sink string, depth func() int, capacity int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.counters(sink)
+	c.queues = append(c.queues, depth)
+	c.capacity += capacity
+}
+
+// QueuedBatches returns the depth of the queues in front of every sink
+func (s *SinkStats) QueuedBatches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var queued int
+	for _, c := range s.sinks {
+		for _, depth := range c.queues {
+			queued += depth()
+		}
+	}
+	return queued
+}
+
+func (s *SinkStats) Snapshot() map[string]SinkSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elapsed := time.Since(s.start).Seconds()
+	snapshot := make(map[string]SinkSnapshot, len(s.sinks))
+	for sink, c := range s.sinks {
+		snap := SinkSnapshot{
+			Enqueued:         c.enqueued,
+			Delivered:        c.delivered,
+			Failed:           c.failed,
+			Retries:          c.retries,
+			Quarantined:      c.quarantined,
+			ThrottledMs:      float64(c.throttled) / float64(time.Millisecond),
+			Dropped:          c.dropped,
+			MaxLatencyMs:     float64(c.maxLatency) / float64(time.Millisecond),
+			Deliveries:       c.deliveries,
+			FailedDeliveries: c.failures,
+			MaxBatchSize:     c.maxBatch,
+			QueueCapacity:    c.capacity,
+		}
+		for _, depth := range c.queues {
+			snap.QueuedBatches += depth()
+		}
+		if elapsed > 0 {
+			snap.EnqueuedPerSecond = float64(c.enqueued) / elapsed
+			snap.DeliveredPerSecond = float64(c.delivered) / elapsed
+		}
+		if c.deliveries > 0 {
+			snap.MeanLatencyMs = float64(c.latency) / float64(c.deliveries) / float64(time.Millisecond)
+			snap.MeanBatchSize = float64(c.delivered) / float64(c.deliveries)
+		}
+		p := c.percentiles(0.5, 0.95, 0.99)
+		snap.P50LatencyMs, snap.P95LatencyMs, snap.P99LatencyMs = p[0], p[1], p[2]
+		if !c.lastSuccess.IsZero() {
+			t := c.lastSuccess
+			snap.LastDeliveredAt = &t
+		}
+		if !c.lastFailure.IsZero() {
+			t := c.lastFailure
+			snap.LastFailedAt = &t
+		}
+		snapshot[sink] = snap
+	}
+	return snapshot
+}
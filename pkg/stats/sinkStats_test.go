@@ -0,0 +1,68 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSinkStats(t *testing.T) {
+	s := NewSinkStats()
+	s.start = time.Now().Add(-10 * time.Second)
+	queue := make(chan int, 5)
+	queue <- 1
+	queue <- 2
+	s.TrackQueue("kafka", func() int { return len(queue) }, cap(queue))
+	s.Enqueued("kafka", 100)
+	s.Delivered("kafka", 60, 10*time.Millisecond)
+	s.Delivered("kafka", 20, 30*time.Millisecond)
+	s.Failed("kafka", 20)
+	s.Dropped("stdout", 5)
+
+	snapshot := s.Snapshot()
+	kafka := snapshot["kafka"]
+	assert.Equal(t, int64(100), kafka.Enqueued)
+	assert.Equal(t, int64(80), kafka.Delivered)
+	assert.Equal(t, int64(20), kafka.Failed)
+	assert.Equal(t, 2, kafka.QueuedBatches)
+	assert.Equal(t, 5, kafka.QueueCapacity)
+	assert.InDelta(t, 10, kafka.EnqueuedPerSecond, 0.1)
+	assert.InDelta(t, 8, kafka.DeliveredPerSecond, 0.1)
+	assert.Equal(t, 20.0, kafka.MeanLatencyMs)
+	assert.Equal(t, 30.0, kafka.MaxLatencyMs)
+	assert.Equal(t, int64(5), snapshot["stdout"].Dropped)
+	assert.Equal(t, 2, s.QueuedBatches())
+}
+
+func TestSinkStatsDeliveries(t *testing.T) {
+	s := NewSinkStats()
+	for i := 1; i <= 100; i++ {
+		s.Delivered("kafka", i, time.Duration(i)*time.Millisecond)
+	}
+	s.Failed("kafka", 10)
+
+	kafka := s.Snapshot()["kafka"]
+	assert.Equal(t, int64(100), kafka.Deliveries)
+	assert.Equal(t, int64(1), kafka.FailedDeliveries)
+	assert.Equal(t, 50.5, kafka.MeanBatchSize)
+	assert.Equal(t, 100, kafka.MaxBatchSize)
+	assert.Equal(t, 50.0, kafka.P50LatencyMs)
+	assert.Equal(t, 95.0, kafka.P95LatencyMs)
+	assert.Equal(t, 99.0, kafka.P99LatencyMs)
+	assert.NotNil(t, kafka.LastDeliveredAt)
+	assert.NotNil(t, kafka.LastFailedAt)
+
+	// Percentiles are of recent deliveries
+	for i := 0; i < LATENCY_SAMPLES; i++ {
+		s.Delivered("kafka", 1, time.Second)
+	}
+	kafka = s.Snapshot()["kafka"]
+	assert.Equal(t, 1000.0, kafka.P50LatencyMs)
+	assert.Equal(t, 1000.0, kafka.MaxLatencyMs)
+	assert.Nil(t, s.Snapshot()["missing"].LastDeliveredAt)
+}
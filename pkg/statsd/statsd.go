@@ -0,0 +1,250 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package statsd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+// Tag formats
+const (
+	DOGSTATSD string = "dogstatsd" // name:1|c|#key:value
+	INFLUX    string = "influx"    // name,key=value:1|c, as read by telegraf
+)
+
+const (
+	DEFAULT_STATSD_PREFIX            string = "buz."
+	DEFAULT_STATSD_FLUSH_INTERVAL_MS int    = 10000
+	// Metrics are batched into packets which fit in an ethernet frame
+	MAX_PACKET_BYTES int = 1432
+)
+
+// Exporter pushes metrics to a StatsD or DogStatsD agent over udp. Counts
+// and timings are sent as they happen, in batched packets, and the stats
+// of collectors are sent on every flush.
+type Exporter struct {
+	mu         sync.Mutex
+	conn       io.WriteCloser
+	prefix     string
+	format     string
+	tags       []string
+	buf        bytes.Buffer
+	totals     map[string]int64
+	collectors []func(*Exporter)
+	interval   time.Duration
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func NewExporter(conf config.Statsd) (*Exporter, error) {
+	e := &Exporter{
+		prefix:   conf.Prefix,
+		format:   conf.Format,
+		totals:   make(map[string]int64),
+		interval: time.Duration(conf.FlushIntervalMs) * time.Millisecond,
+	}
+	switch e.format {
+	case DOGSTATSD, INFLUX:
+	case "":
+		e.format = DOGSTATSD
+	default:
+		return nil, errors.New("unsupported statsd format: " + conf.Format)
+	}
+	if e.prefix == "" {
+		e.prefix = DEFAULT_STATSD_PREFIX
+	}
+	if e.interval <= 0 {
+		e.interval = time.Duration(DEFAULT_STATSD_FLUSH_INTERVAL_MS) * time.Millisecond
+	}
+	for k, v := range conf.Tags {
+		e.tags = append(e.tags, k+":"+v)
+	}
+	sort.Strings(e.tags)
+	conn, err := net.Dial("udp", conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+	e.conn = conn
+	return e, nil
+}
+
+// line formats a metric, with tags as key:value
+func (e *Exporter) line(name string, value string, kind string, tags []string) string {
+	var b strings.Builder
+	b.WriteString(e.prefix + name)
+	all := append(append([]string{}, e.tags...), tags...)
+	if e.format == INFLUX {
+		for _, t := range all {
+			k, v, _ := strings.Cut(t, ":")
+			b.WriteString("," + k + "=" + v)
+		}
+	}
+	b.WriteString(":" + value + "|" + kind)
+	if e.format == DOGSTATSD && len(all) > 0 {
+		b.WriteString("|#" + strings.Join(all, ","))
+	}
+	return b.String()
+}
+
+func (e *Exporter) send(line string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.buf.Len() > 0 && e.buf.Len()+len(line)+1 > MAX_PACKET_BYTES {
+		e.flush()
+	}
+	if e.buf.Len() > 0 {
+		e.buf.WriteByte('\n')
+	}
+	e.buf.WriteString(line)
+}
+
+// flush writes the buffered metrics. The lock must be held.
+func (e *Exporter) flush() {
+	if e.buf.Len() == 0 {
+		return
+	}
+	if _, err := e.conn.Write(e.buf.Bytes()); err != nil {
+		log.Debug().Err(err).Msg("🟡 could not send statsd metrics")
+	}
+	e.buf.Reset()
+}
+
+func (e *Exporter) Count(name string, value int64, tags ...string) {
+	e.send(e.line(name, strconv.FormatInt(value, 10), "c", tags))
+}
+
+func (e *Exporter) Gauge(name string, value float64, tags ...string) {
+	e.send(e.line(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags))
+}
+
+func (e *Exporter) Timing(name string, d time.Duration, tags ...string) {
+	e.send(e.line(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), "ms", tags))
+}
+
+// Total counts the increase of a cumulative count since it was last sent,
+// for counts which are only kept as totals
+func (e *Exporter) Total(name string, total int64, tags ...string) {
+	key := name + "|" + strings.Join(tags, ",")
+	e.mu.Lock()
+	last, seen := e.totals[key]
+	e.totals[key] = total
+	e.mu.Unlock()
+	if delta := total - last; delta > 0 || !seen {
+		e.Count(name, delta, tags...)
+	}
+}
+
+// Collect adds a collector, which sends metrics on every flush
+func (e *Exporter) Collect(fn func(*Exporter)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.collectors = append(e.collectors, fn)
+}
+
+// CollectSinks sends the delivery counts, queue depth, and latency of
+// every sink
+func (e *Exporter) CollectSinks(snapshot func() map[string]stats.SinkSnapshot) {
+	e.Collect(func(e *Exporter) {
+		for sink, s := range snapshot() {
+			tag := "sink:" + sink
+			e.Total("sink.enqueued", s.Enqueued, tag)
+			e.Total("sink.delivered", s.Delivered, tag)
+			e.Total("sink.failed", s.Failed, tag)
+			e.Total("sink.retries", s.Retries, tag)
+			e.Total("sink.quarantined", s.Quarantined, tag)
+			e.Total("sink.dropped", s.Dropped, tag)
+			e.Total("sink.deliveries", s.Deliveries, tag)
+			e.Total("sink.failed_deliveries", s.FailedDeliveries, tag)
+			e.Gauge("sink.queued_batches", float64(s.QueuedBatches), tag)
+			e.Gauge("sink.batch_size.mean", s.MeanBatchSize, tag)
+			e.Gauge("sink.latency_ms.mean", s.MeanLatencyMs, tag)
+			e.Gauge("sink.latency_ms.max", s.MaxLatencyMs, tag)
+			e.Gauge("sink.latency_ms.p50", s.P50LatencyMs, tag)
+			e.Gauge("sink.latency_ms.p95", s.P95LatencyMs, tag)
+			e.Gauge("sink.latency_ms.p99", s.P99LatencyMs, tag)
+		}
+	})
+}
+
+// CollectCounts sends counts kept by group and key, such as detections by
+// schema and detector, tagged with both
+func (e *Exporter) CollectCounts(name string, snapshot func() map[string]map[string]int64) {
+	e.Collect(func(e *Exporter) {
+		for group, keys := range snapshot() {
+			for key, total := range keys {
+				e.Total(name, total, "group:"+group, "key:"+key)
+			}
+		}
+	})
+}
+
+func (e *Exporter) collect() {
+	e.mu.Lock()
+	collectors := append([]func(*Exporter){}, e.collectors...)
+	e.mu.Unlock()
+	for _, fn := range collectors {
+		fn(e)
+	}
+	e.mu.Lock()
+	e.flush()
+	e.mu.Unlock()
+}
+
+// Start collects and flushes metrics every interval, until the exporter
+// is closed
+func (e *Exporter) Start() {
+	e.stop, e.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.collect()
+			case <-e.stop:
+				e.collect()
+				return
+			}
+		}
+	}()
+}
+
+// Close sends the last metrics
+func (e *Exporter) Close() error {
+	if e.stop != nil {
+		close(e.stop)
+		<-e.done
+	} else {
+		e.collect()
+	}
+	return e.conn.Close()
+}
+
+// Middleware times every request, by route, method, and status
+func (e *Exporter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		e.Timing("request.duration", time.Since(start), "route:"+route, "method:"+c.Request.Method, "status:"+strconv.Itoa(c.Writer.Status()))
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package statsd
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func listen(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func receive(t *testing.T, conn *net.UDPConn) []string {
+	buf := make([]byte, 65535)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestExporter(t *testing.T) {
+	conn := listen(t)
+	e, err := NewExporter(config.Statsd{Addr: conn.LocalAddr().String(), Tags: map[string]string{"env": "prod"}})
+	assert.Nil(t, err)
+
+	e.Count("events", 3, "input:webhook")
+	e.Gauge("queue", 1.5)
+	e.Timing("latency", 1500*time.Microsecond)
+	e.Total("delivered", 10)
+	e.Total("delivered", 15)
+	e.Total("delivered", 15)
+	assert.Nil(t, e.Close())
+	assert.Equal(t, []string{
+		"buz.events:3|c|#env:prod,input:webhook",
+		"buz.queue:1.5|g|#env:prod",
+		"buz.latency:1.500|ms|#env:prod",
+		"buz.delivered:10|c|#env:prod",
+		"buz.delivered:5|c|#env:prod",
+	}, receive(t, conn))
+}
+
+func TestExporterInflux(t *testing.T) {
+	conn := listen(t)
+	e, err := NewExporter(config.Statsd{Addr: conn.LocalAddr().String(), Prefix: "collector.", Format: INFLUX, Tags: map[string]string{"env": "prod"}})
+	assert.Nil(t, err)
+	e.Count("events", 1, "input:webhook")
+	assert.Nil(t, e.Close())
+	assert.Equal(t, []string{"collector.events,env=prod,input=webhook:1|c"}, receive(t, conn))
+
+	_, err = NewExporter(config.Statsd{Addr: conn.LocalAddr().String(), Format: "graphite"})
+	assert.NotNil(t, err)
+}
+
+func TestExporterPackets(t *testing.T) {
+	conn := listen(t)
+	e, err := NewExporter(config.Statsd{Addr: conn.LocalAddr().String()})
+	assert.Nil(t, err)
+	for i := 0; i < 200; i++ {
+		e.Count("events", 1, "input:webhook")
+	}
+	assert.Nil(t, e.Close())
+	var lines int
+	for lines < 200 {
+		packet := receive(t, conn)
+		assert.LessOrEqual(t, len(strings.Join(packet, "\n")), MAX_PACKET_BYTES)
+		lines += len(packet)
+	}
+	assert.Equal(t, 200, lines)
+}
+
+func TestExporterCollectors(t *testing.T) {
+	conn := listen(t)
+	e, err := NewExporter(config.Statsd{Addr: conn.LocalAddr().String(), FlushIntervalMs: 10})
+	assert.Nil(t, err)
+	e.CollectSinks(func() map[string]stats.SinkSnapshot {
+		return map[string]stats.SinkSnapshot{"kafka": {Delivered: 4, QueuedBatches: 2}}
+	})
+	e.CollectCounts("pii.detections", func() map[string]map[string]int64 {
+		return map[string]map[string]int64{"io.acme/signup/v1.0.json": {"email": 2}}
+	})
+	e.Start()
+	lines := receive(t, conn)
+	assert.Contains(t, lines, "buz.sink.delivered:4|c|#sink:kafka")
+	assert.Contains(t, lines, "buz.sink.queued_batches:2|g|#sink:kafka")
+	assert.Contains(t, lines, "buz.pii.detections:2|c|#group:io.acme/signup/v1.0.json,key:email")
+	assert.Nil(t, e.Close())
+}
+
+func TestExporterMiddleware(t *testing.T) {
+	conn := listen(t)
+	e, err := NewExporter(config.Statsd{Addr: conn.LocalAddr().String()})
+	assert.Nil(t, err)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(e.Middleware())
+	r.GET("/c/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/c/health", nil))
+	assert.Nil(t, e.Close())
+	lines := receive(t, conn)
+	assert.Len(t, lines, 1)
+	assert.True(t, strings.HasPrefix(lines[0], "buz.request.duration:"))
+	assert.True(t, strings.HasSuffix(lines[0], "|ms|#route:/c/health,method:GET,status:200"))
+}
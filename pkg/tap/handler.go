@@ -0,0 +1,111 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package tap
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/response"
+)
+
+const TAP_ROUTE = "/c/tap"
+
+const (
+	DEFAULT_TAP_SAMPLE_RATE     float64 = 1
+	DEFAULT_TAP_MAX_SUBSCRIBERS int     = 5
+	DEFAULT_TAP_BUFFER_SIZE     int     = 100
+	DEFAULT_TAP_MAX_SECONDS     int     = 3600
+	// Comments are sent while the stream is quiet, so proxies don't close it
+	KEEPALIVE_SECONDS int = 15
+)
+
+// filter returns the filter of the request's schema, protocol, valid,
+// and sample params
+func filter(c *gin.Context, conf config.Tap) (Filter, bool) {
+	f := Filter{Schemas: c.QueryArray("schema"), Protocol: c.Query("protocol"), SampleRate: conf.SampleRate}
+	if v := c.Query("valid"); v != "" {
+		valid, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, false
+		}
+		f.Valid = &valid
+	}
+	if s := c.Query("sample"); s != "" {
+		rate, err := strconv.ParseFloat(s, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return f, false
+		}
+		f.SampleRate = rate
+	}
+	return f, true
+}
+
+// Handler streams envelopes to the client as server-sent events, until
+// the client disconnects or the stream reaches its max duration. Clients
+// which fall behind are sent the number of envelopes they missed.
+func Handler(conf config.Tap) gin.HandlerFunc {
+	if conf.SampleRate <= 0 {
+		conf.SampleRate = DEFAULT_TAP_SAMPLE_RATE
+	}
+	if conf.MaxSubscribers <= 0 {
+		conf.MaxSubscribers = DEFAULT_TAP_MAX_SUBSCRIBERS
+	}
+	if conf.BufferSize <= 0 {
+		conf.BufferSize = DEFAULT_TAP_BUFFER_SIZE
+	}
+	if conf.MaxSeconds <= 0 {
+		conf.MaxSeconds = DEFAULT_TAP_MAX_SECONDS
+	}
+	fn := func(c *gin.Context) {
+		f, ok := filter(c, conf)
+		if !ok {
+			c.JSON(http.StatusBadRequest, response.InvalidTapFilter)
+			return
+		}
+		sub, ok := Subscribe(f, conf.BufferSize, conf.MaxSubscribers)
+		if !ok {
+			c.JSON(http.StatusTooManyRequests, response.TooManyTapSubscribers)
+			return
+		}
+		defer Unsubscribe(sub)
+		log.Info().Str("identity", c.GetString(constants.AUTH_IDENTITY)).Strs("schemas", f.Schemas).Msg("🟢 tap stream opened")
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+		deadline := time.NewTimer(time.Duration(conf.MaxSeconds) * time.Second)
+		defer deadline.Stop()
+		keepalive := time.NewTicker(time.Duration(KEEPALIVE_SECONDS) * time.Second)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-deadline.C:
+				c.SSEvent("close", gin.H{"reason": "max duration"})
+				c.Writer.Flush()
+				return
+			case <-keepalive.C:
+				io.WriteString(c.Writer, ": keepalive\n\n")
+				c.Writer.Flush()
+			case e := <-sub.Envelopes:
+				if dropped := sub.Dropped(); dropped > 0 {
+					c.SSEvent("dropped", gin.H{"dropped": dropped})
+				}
+				c.SSEvent("envelope", e)
+				c.Writer.Flush()
+			}
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package tap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(t *testing.T, conf config.Tap) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.GET(TAP_ROUTE, Handler(conf))
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func waitForSubscribers(n int32) {
+	for i := 0; i < 100 && taps.active.Load() != n; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	srv := serve(t, config.Tap{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+TAP_ROUTE+"?schema=com.acme/checkout/&valid=true", nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	waitForSubscribers(1)
+
+	Publish([]envelope.Envelope{
+		{Schema: "com.acme/page/v1.0.json", IsValid: true},
+		{Schema: "com.acme/checkout/v1.0.json", IsValid: false},
+		{Schema: "com.acme/checkout/v1.0.json", IsValid: true, Payload: envelope.Payload{"orderId": "42"}},
+	})
+	scanner := bufio.NewScanner(resp.Body)
+	var event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event:") {
+			event = line[len("event:"):]
+		}
+		if strings.HasPrefix(line, "data:") {
+			data = line[len("data:"):]
+			break
+		}
+	}
+	assert.Equal(t, "envelope", event)
+	var e envelope.Envelope
+	assert.Nil(t, json.Unmarshal([]byte(data), &e))
+	assert.Equal(t, "42", e.Payload["orderId"])
+
+	cancel()
+	waitForSubscribers(0)
+	assert.Equal(t, int32(0), taps.active.Load())
+}
+
+func TestHandlerRejects(t *testing.T) {
+	srv := serve(t, config.Tap{MaxSubscribers: 1})
+	for _, q := range []string{"?sample=2", "?sample=none", "?valid=maybe"} {
+		resp, err := http.Get(srv.URL + TAP_ROUTE + q)
+		assert.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, q)
+	}
+
+	sub, ok := Subscribe(Filter{}, 1, 1)
+	assert.True(t, ok)
+	defer Unsubscribe(sub)
+	resp, err := http.Get(srv.URL + TAP_ROUTE)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestPublishDropsWhenBehind(t *testing.T) {
+	sub, _ := Subscribe(Filter{SampleRate: 1}, 1, 10)
+	defer Unsubscribe(sub)
+	Publish([]envelope.Envelope{{}, {}, {}})
+	assert.Len(t, sub.Envelopes, 1)
+	assert.Equal(t, int64(2), sub.Dropped())
+	assert.Equal(t, int64(0), sub.Dropped())
+}
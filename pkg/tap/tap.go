@@ -0,0 +1,108 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package tap
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// Filter selects the envelopes of a stream
+type Filter struct {
+	Schemas    []string // Schema prefixes. Empty matches every schema
+	Protocol   string
+	Valid      *bool
+	SampleRate float64
+}
+
+func (f Filter) matches(e envelope.Envelope) bool {
+	if f.Protocol != "" && e.Protocol != f.Protocol {
+		return false
+	}
+	if f.Valid != nil && e.IsValid != *f.Valid {
+		return false
+	}
+	if len(f.Schemas) > 0 {
+		matched := false
+		for _, prefix := range f.Schemas {
+			matched = matched || strings.HasPrefix(e.Schema, prefix)
+		}
+		if !matched {
+			return false
+		}
+	}
+	return f.SampleRate >= 1 || rand.Float64() < f.SampleRate
+}
+
+// Subscription receives a copy of the envelopes matching its filter.
+// Envelopes are dropped rather than holding up the manifold when the
+// subscriber falls behind.
+type Subscription struct {
+	filter    Filter
+	Envelopes chan envelope.Envelope
+	dropped   atomic.Int64
+}
+
+// Dropped returns the number of envelopes dropped since it was last called
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+type hub struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	active atomic.Int32
+}
+
+var taps = &hub{subs: make(map[*Subscription]struct{})}
+
+// Publish copies the envelopes to every matching subscription. It is
+// cheap when nobody is subscribed.
+func Publish(envelopes []envelope.Envelope) {
+	if taps.active.Load() == 0 {
+		return
+	}
+	taps.mu.RLock()
+	defer taps.mu.RUnlock()
+	for s := range taps.subs {
+		for _, e := range envelopes {
+			if !s.filter.matches(e) {
+				continue
+			}
+			select {
+			case s.Envelopes <- e:
+			default:
+				s.dropped.Add(1)
+			}
+		}
+	}
+}
+
+// Subscribe returns a subscription, unless there are already max
+// subscriptions
+func Subscribe(filter Filter, buffer int, max int) (*Subscription, bool) {
+	taps.mu.Lock()
+	defer taps.mu.Unlock()
+	if len(taps.subs) >= max {
+		return nil, false
+	}
+	s := &Subscription{filter: filter, Envelopes: make(chan envelope.Envelope, buffer)}
+	taps.subs[s] = struct{}{}
+	taps.active.Add(1)
+	return s, true
+}
+
+func Unsubscribe(s *Subscription) {
+	taps.mu.Lock()
+	defer taps.mu.Unlock()
+	if _, ok := taps.subs[s]; ok {
+		delete(taps.subs, s)
+		taps.active.Add(-1)
+	}
+}
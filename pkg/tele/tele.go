@@ -5,29 +5,40 @@
 package tele
 
 import (
-	"net/url"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/envelope"
 	"github.com/silverton-io/buz/pkg/meta"
-	"github.com/silverton-io/buz/pkg/request"
 	"github.com/silverton-io/buz/pkg/util"
 )
 
 const (
-	DEFAULT_ENDPOINT string = "https://tele.buz.dev/self-describing"
-	STARTUP_1_0      string = "io.silverton/buz/internal/tele/startup/v1.0.json"
-	HEARTBEAT_1_0    string = "io.silverton/buz/internal/tele/beat/v1.0.json"
-	SHUTDOWN_1_0     string = "io.silverton/buz/internal/tele/shutdown/v1.0.json"
-	HEARTBEAT_MS     int    = 60000
+	DEFAULT_ENDPOINT   string = "https://tele.buz.dev/self-describing"
+	DEFAULT_TIMEOUT_MS int    = 5000
+	STARTUP_1_0        string = "io.silverton/buz/internal/tele/startup/v1.0.json"
+	HEARTBEAT_1_0      string = "io.silverton/buz/internal/tele/beat/v1.0.json"
+	SHUTDOWN_1_0       string = "io.silverton/buz/internal/tele/shutdown/v1.0.json"
+	HEARTBEAT_MS       int    = 60000
+)
+
+// Categories of telemetry, each of which can be disabled
+const (
+	STARTUP   string = "startup"   // The collector meta, when it starts
+	CONFIG    string = "config"    // The redacted config, in the startup event
+	HEARTBEAT string = "heartbeat" // The collector meta and uptime, every minute
+	SHUTDOWN  string = "shutdown"  // The collector meta and uptime, when it shuts down
 )
 
 type startup struct {
 	Meta   *meta.CollectorMeta `json:"meta"`
 	Time   time.Time           `json:"time"`
-	Config config.Config       `json:"config"`
+	Config interface{}         `json:"config,omitempty"`
 }
 
 type beat struct {
@@ -42,7 +53,52 @@ type shutdown struct {
 	ElapsedSeconds float64             `json:"elapsedSeconds"`
 }
 
-func heartbeat(t time.Ticker, m *meta.CollectorMeta) {
+// enabled returns whether the category of telemetry is sent
+func enabled(conf config.Tele, category string) bool {
+	if !conf.Enabled {
+		return false
+	}
+	for _, d := range conf.Disabled {
+		if d == category {
+			return false
+		}
+	}
+	return true
+}
+
+// send posts a self-describing event to the telemetry endpoint
+func send(conf config.Tele, schema string, v interface{}) error {
+	endpoint := conf.Endpoint
+	if endpoint == "" {
+		endpoint = DEFAULT_ENDPOINT
+	}
+	timeout := conf.TimeoutMs
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT_MS
+	}
+	body, err := json.Marshal(envelope.SelfDescribingEvent{
+		Contexts: nil,
+		Payload: envelope.SelfDescribingPayload{
+			Schema: schema,
+			Data:   util.StructToMap(v),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: time.Duration(timeout) * time.Millisecond}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func heartbeat(conf config.Tele, t *time.Ticker, m *meta.CollectorMeta) {
 	for range t.C {
 		log.Trace().Msg("sending heartbeat telemetry")
 		b := beat{
@@ -50,66 +106,51 @@ func heartbeat(t time.Ticker, m *meta.CollectorMeta) {
 			Time:           time.Now().UTC(),
 			ElapsedSeconds: m.Elapsed(),
 		}
-		data := util.StructToMap(b)
-		heartbeatPayload := envelope.SelfDescribingEvent{
-			Contexts: nil,
-			Payload: envelope.SelfDescribingPayload{
-				Schema: HEARTBEAT_1_0,
-				Data:   data,
-			},
-		}
-		endpoint, _ := url.Parse(DEFAULT_ENDPOINT)
-		_, err := request.PostEvent(*endpoint, heartbeatPayload)
-		if err != nil {
-			log.Error().Err(err).Msg("🔴 could not send heartbeat")
+		if err := send(conf, HEARTBEAT_1_0, b); err != nil {
+			log.Debug().Err(err).Msg("🟡 could not send heartbeat telemetry")
 		}
 	}
 }
 
-func Sis(m *meta.CollectorMeta) {
+func Sis(c *config.Config, m *meta.CollectorMeta) {
+	if !enabled(c.Tele, SHUTDOWN) {
+		return
+	}
 	log.Trace().Msg("sending shutdown telemetry")
 	shutdown := shutdown{
 		Meta:           m,
 		Time:           time.Now().UTC(),
 		ElapsedSeconds: m.Elapsed(),
 	}
-	data := util.StructToMap(shutdown)
-	shutdownPayload := envelope.SelfDescribingEvent{
-		Contexts: nil,
-		Payload: envelope.SelfDescribingPayload{
-			Schema: SHUTDOWN_1_0,
-			Data:   data,
-		},
-	}
-	endpoint, _ := url.Parse(DEFAULT_ENDPOINT)
-	_, err := request.PostEvent(*endpoint, shutdownPayload)
-	if err != nil {
-		panic(err)
+	if err := send(c.Tele, SHUTDOWN_1_0, shutdown); err != nil {
+		log.Debug().Err(err).Msg("🟡 could not send shutdown telemetry")
 	}
 }
 
 func Metry(c *config.Config, m *meta.CollectorMeta) {
-	if c.Tele.Enabled {
+	for _, d := range c.Tele.Disabled {
+		if d != STARTUP && d != CONFIG && d != HEARTBEAT && d != SHUTDOWN {
+			log.Warn().Msg("🟡 unknown telemetry category " + d)
+		}
+	}
+	if enabled(c.Tele, STARTUP) {
 		log.Trace().Msg("sending startup telemetry")
 		startup := startup{
-			Meta:   m,
-			Time:   time.Now().UTC(),
-			Config: *c,
+			Meta: m,
+			Time: time.Now().UTC(),
 		}
-		data := util.StructToMap(startup)
-		startupPayload := envelope.SelfDescribingEvent{
-			Contexts: nil,
-			Payload: envelope.SelfDescribingPayload{
-				Schema: STARTUP_1_0,
-				Data:   data,
-			},
+		if enabled(c.Tele, CONFIG) {
+			redacted, err := config.Redact(*c, c.App.RedactPatterns)
+			if err == nil {
+				startup.Config = redacted
+			}
 		}
-		endpoint, _ := url.Parse(DEFAULT_ENDPOINT)
-		_, err := request.PostEvent(*endpoint, startupPayload)
-		if err != nil {
-			panic(err)
+		if err := send(c.Tele, STARTUP_1_0, startup); err != nil {
+			log.Debug().Err(err).Msg("🟡 could not send startup telemetry")
 		}
+	}
+	if enabled(c.Tele, HEARTBEAT) {
 		ticker := time.NewTicker(time.Duration(HEARTBEAT_MS) * time.Millisecond)
-		go heartbeat(*ticker, m)
+		go heartbeat(c.Tele, ticker, m)
 	}
 }
@@ -4,12 +4,83 @@
 
 package tele
 
-import "testing"
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingEndpoint struct {
+	mu     sync.Mutex
+	events []envelope.SelfDescribingEvent
+}
+
+func (e *recordingEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	var event envelope.SelfDescribingEvent
+	json.Unmarshal(b, &event)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, event)
+}
+
+func newTestConfig(t *testing.T, tele config.Tele) (*config.Config, *recordingEndpoint) {
+	e := &recordingEndpoint{}
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	tele.Endpoint = srv.URL
+	c := &config.Config{Tele: tele}
+	c.Sinks = []config.Sink{{Name: "warehouse"}}
+	c.Sentry.Dsn = "https://hunter2@o0.ingest.sentry.io/0"
+	return c, e
+}
 
 func TestElapsed(t *testing.T) {}
 
 func TestHeartbeat(t *testing.T) {}
 
-func TestSis(t *testing.T) {}
+func TestSis(t *testing.T) {
+	m := &meta.CollectorMeta{Version: "1.0", StartTime: time.Now()}
+	c, e := newTestConfig(t, config.Tele{Enabled: true})
+	Sis(c, m)
+	assert.Len(t, e.events, 1)
+	assert.Equal(t, SHUTDOWN_1_0, e.events[0].Payload.Schema)
+
+	c, e = newTestConfig(t, config.Tele{Enabled: true, Disabled: []string{SHUTDOWN}})
+	Sis(c, m)
+	c.Tele.Disabled, c.Tele.Enabled = nil, false
+	Sis(c, m)
+	assert.Len(t, e.events, 0)
+
+	// Unreachable endpoints don't stop the collector from shutting down
+	Sis(&config.Config{Tele: config.Tele{Enabled: true, Endpoint: "http://127.0.0.1:1", TimeoutMs: 100}}, m)
+}
+
+func TestMetry(t *testing.T) {
+	m := &meta.CollectorMeta{Version: "1.0"}
+	c, e := newTestConfig(t, config.Tele{Enabled: true, Disabled: []string{HEARTBEAT}})
+	Metry(c, m)
+	assert.Len(t, e.events, 1)
+	assert.Equal(t, STARTUP_1_0, e.events[0].Payload.Schema)
+	b, _ := json.Marshal(e.events[0].Payload.Data["config"])
+	assert.Contains(t, string(b), "warehouse")
+	assert.NotContains(t, string(b), "hunter2")
+
+	c, e = newTestConfig(t, config.Tele{Enabled: true, Disabled: []string{CONFIG, HEARTBEAT}})
+	Metry(c, m)
+	assert.Len(t, e.events, 1)
+	assert.NotContains(t, e.events[0].Payload.Data, "config")
 
-func TestMetry(t *testing.T) {}
+	c, e = newTestConfig(t, config.Tele{Enabled: true, Disabled: []string{STARTUP, HEARTBEAT}})
+	Metry(c, m)
+	assert.Len(t, e.events, 0)
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const ATTRIBUTION_CONTEXT string = "io.silverton/buz/internal/contexts/attribution/v1.0.json"
+
+// Referrer mediums
+const (
+	SEARCH_MEDIUM   string = "search"
+	SOCIAL_MEDIUM   string = "social"
+	EMAIL_MEDIUM    string = "email"
+	INTERNAL_MEDIUM string = "internal"
+	UNKNOWN_MEDIUM  string = "unknown"
+)
+
+// The Referer header of a pixel request is the page the pixel is on
+var (
+	DEFAULT_URL_HEADERS       = []string{"Referer"}
+	DEFAULT_URL_POINTERS      = []string{"/page_url", "/url"}
+	DEFAULT_REFERRER_POINTERS = []string{"/page_referrer", "/refr", "/referrer"}
+)
+
+var UTM_PARAMS = map[string]string{
+	"utm_source":   "utmSource",
+	"utm_medium":   "utmMedium",
+	"utm_campaign": "utmCampaign",
+	"utm_term":     "utmTerm",
+	"utm_content":  "utmContent",
+}
+
+var CLICK_ID_PARAMS = []string{"gclid", "gbraid", "wbraid", "dclid", "fbclid", "msclkid", "ttclid", "twclid", "li_fat_id"}
+
+type referrer struct {
+	source      string
+	medium      string
+	searchParam string
+}
+
+// Known referrers, keyed by domain. Subdomains match their parent domain.
+var referrers = map[string]referrer{
+	"google.com":         {"Google", SEARCH_MEDIUM, "q"},
+	"bing.com":           {"Bing", SEARCH_MEDIUM, "q"},
+	"yahoo.com":          {"Yahoo!", SEARCH_MEDIUM, "p"},
+	"duckduckgo.com":     {"DuckDuckGo", SEARCH_MEDIUM, "q"},
+	"baidu.com":          {"Baidu", SEARCH_MEDIUM, "wd"},
+	"yandex.ru":          {"Yandex", SEARCH_MEDIUM, "text"},
+	"ecosia.org":         {"Ecosia", SEARCH_MEDIUM, "q"},
+	"facebook.com":       {"Facebook", SOCIAL_MEDIUM, ""},
+	"instagram.com":      {"Instagram", SOCIAL_MEDIUM, ""},
+	"t.co":               {"Twitter", SOCIAL_MEDIUM, ""},
+	"twitter.com":        {"Twitter", SOCIAL_MEDIUM, ""},
+	"x.com":              {"Twitter", SOCIAL_MEDIUM, ""},
+	"linkedin.com":       {"LinkedIn", SOCIAL_MEDIUM, ""},
+	"lnkd.in":            {"LinkedIn", SOCIAL_MEDIUM, ""},
+	"reddit.com":         {"Reddit", SOCIAL_MEDIUM, ""},
+	"pinterest.com":      {"Pinterest", SOCIAL_MEDIUM, ""},
+	"youtube.com":        {"YouTube", SOCIAL_MEDIUM, ""},
+	"tiktok.com":         {"TikTok", SOCIAL_MEDIUM, ""},
+	"mail.google.com":    {"Gmail", EMAIL_MEDIUM, ""},
+	"outlook.live.com":   {"Outlook.com", EMAIL_MEDIUM, ""},
+	"mail.yahoo.com":     {"Yahoo! Mail", EMAIL_MEDIUM, ""},
+	"mail.proton.me":     {"Proton Mail", EMAIL_MEDIUM, ""},
+	"outlook.office.com": {"Outlook", EMAIL_MEDIUM, ""},
+}
+
+// AttributionEnricher attaches a context with the utm parameters and
+// click ids of the page url, and the source and medium of the referrer.
+type AttributionEnricher struct {
+	urls            *source
+	referrers       *source
+	internalDomains []string
+}
+
+func NewAttributionEnricher(conf config.Transform) (*AttributionEnricher, error) {
+	urlPointers, referrerPointers := conf.UrlPointers, conf.ReferrerPointers
+	if len(urlPointers) == 0 {
+		urlPointers = DEFAULT_URL_POINTERS
+	}
+	if len(referrerPointers) == 0 {
+		referrerPointers = DEFAULT_REFERRER_POINTERS
+	}
+	urls, err := sourceOf(DEFAULT_URL_HEADERS, urlPointers)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := sourceOf(nil, referrerPointers)
+	if err != nil {
+		return nil, err
+	}
+	return &AttributionEnricher{urls: urls, referrers: refs, internalDomains: conf.InternalDomains}, nil
+}
+
+// firstUrl returns the first absolute url of the values
+func firstUrl(values []interface{}) *url.URL {
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if u, err := url.Parse(s); err == nil && u.Host != "" {
+			return u
+		}
+	}
+	return nil
+}
+
+// matchesDomain returns true if the host is the domain or one of its subdomains
+func matchesDomain(host string, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func (a *AttributionEnricher) classify(ref *url.URL, page *url.URL) map[string]interface{} {
+	host := strings.TrimPrefix(strings.ToLower(ref.Hostname()), "www.")
+	internal := page != nil && strings.EqualFold(ref.Hostname(), page.Hostname())
+	for _, domain := range a.internalDomains {
+		internal = internal || matchesDomain(host, strings.ToLower(domain))
+	}
+	if internal {
+		return map[string]interface{}{"referrerMedium": INTERNAL_MEDIUM}
+	}
+	// The most specific domain wins, so mail.google.com isn't google.com
+	var match referrer
+	var matchedDomain string
+	for domain, r := range referrers {
+		if matchesDomain(host, domain) && len(domain) > len(matchedDomain) {
+			match, matchedDomain = r, domain
+		}
+	}
+	if matchedDomain == "" {
+		return map[string]interface{}{"referrerMedium": UNKNOWN_MEDIUM, "referrerSource": host}
+	}
+	classified := map[string]interface{}{"referrerMedium": match.medium, "referrerSource": match.source}
+	if term := ref.Query().Get(match.searchParam); match.searchParam != "" && term != "" {
+		classified["referrerTerm"] = term
+	}
+	return classified
+}
+
+func (a *AttributionEnricher) Enrich(ctx context.Context, e *envelope.Envelope) error {
+	attribution := make(map[string]interface{})
+	// Utm parameters and click ids of pixels are sent as payload params
+	params := make(url.Values)
+	for param := range UTM_PARAMS {
+		if v, ok := e.Payload[param].(string); ok {
+			params.Set(param, v)
+		}
+	}
+	for _, param := range CLICK_ID_PARAMS {
+		if v, ok := e.Payload[param].(string); ok {
+			params.Set(param, v)
+		}
+	}
+	// The page url of the payload comes before the headers, since trackers
+	// may be proxying the original request
+	page := firstUrl(append(a.urls.fieldValues(e), a.urls.headerValues(e)...))
+	if page != nil {
+		for k, v := range page.Query() {
+			params[k] = v
+		}
+	}
+	for param, field := range UTM_PARAMS {
+		if v := params.Get(param); v != "" {
+			attribution[field] = v
+		}
+	}
+	clickIds := make(map[string]interface{})
+	for _, param := range CLICK_ID_PARAMS {
+		if v := params.Get(param); v != "" {
+			clickIds[param] = v
+		}
+	}
+	if len(clickIds) > 0 {
+		attribution["clickIds"] = clickIds
+	}
+	if ref := firstUrl(a.referrers.values(e)); ref != nil {
+		for k, v := range a.classify(ref, page) {
+			attribution[k] = v
+		}
+	}
+	if len(attribution) == 0 {
+		return nil
+	}
+	setContext(e, ATTRIBUTION_CONTEXT, attribution)
+	return nil
+}
+
+func (a *AttributionEnricher) Close() error {
+	return nil
+}
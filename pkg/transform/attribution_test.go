@@ -0,0 +1,82 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func attribution(t *testing.T, a *AttributionEnricher, e envelope.Envelope) map[string]interface{} {
+	assert.Nil(t, a.Enrich(context.Background(), &e))
+	if e.Contexts == nil {
+		return nil
+	}
+	return (*e.Contexts)[ATTRIBUTION_CONTEXT].(map[string]interface{})
+}
+
+func TestAttribution(t *testing.T) {
+	a, err := NewAttributionEnricher(config.Transform{InternalDomains: []string{"acme.com"}})
+	assert.Nil(t, err)
+	var testCases = []struct {
+		name string
+		e    envelope.Envelope
+		want map[string]interface{}
+	}{
+		{
+			"snowplowSearch",
+			envelope.Envelope{Payload: envelope.Payload{
+				"page_url":      "https://shop.acme.io/?utm_source=newsletter&utm_medium=email&utm_campaign=spring&gclid=abc",
+				"page_referrer": "https://www.google.com/search?q=running+shoes",
+			}},
+			map[string]interface{}{
+				"utmSource":      "newsletter",
+				"utmMedium":      "email",
+				"utmCampaign":    "spring",
+				"clickIds":       map[string]interface{}{"gclid": "abc"},
+				"referrerMedium": SEARCH_MEDIUM,
+				"referrerSource": "Google",
+				"referrerTerm":   "running shoes",
+			},
+		},
+		{
+			"pixelParams",
+			envelope.Envelope{
+				Contexts: &envelope.Contexts{envelope.HTTP_HEADERS_CONTEXT: map[string]interface{}{"Referer": "https://acme.io/landing?fbclid=xyz"}},
+				Payload:  envelope.Payload{"utm_source": "facebook"},
+			},
+			map[string]interface{}{"utmSource": "facebook", "clickIds": map[string]interface{}{"fbclid": "xyz"}},
+		},
+		{
+			"email",
+			envelope.Envelope{Payload: envelope.Payload{"page_referrer": "https://mail.google.com/mail/u/0/"}},
+			map[string]interface{}{"referrerMedium": EMAIL_MEDIUM, "referrerSource": "Gmail"},
+		},
+		{
+			"internal",
+			envelope.Envelope{Payload: envelope.Payload{"page_referrer": "https://docs.acme.com/start"}},
+			map[string]interface{}{"referrerMedium": INTERNAL_MEDIUM},
+		},
+		{
+			"unknown",
+			envelope.Envelope{Payload: envelope.Payload{"page_referrer": "https://blog.example.org/post"}},
+			map[string]interface{}{"referrerMedium": UNKNOWN_MEDIUM, "referrerSource": "blog.example.org"},
+		},
+		{
+			"none",
+			envelope.Envelope{Payload: envelope.Payload{"page_url": "https://acme.io/"}},
+			nil,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, attribution(t, a, tc.e))
+		})
+	}
+}
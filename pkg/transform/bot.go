@@ -0,0 +1,168 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const BOT_CONTEXT string = "io.silverton/buz/internal/contexts/bot/v1.0.json"
+
+// Bot actions
+const (
+	TAG      string = "tag"  // Attach a bot context
+	DROP_BOT string = "drop" // Drop the envelope
+)
+
+// Reasons an envelope was detected as a bot
+const (
+	KNOWN_USER_AGENT        string = "knownUserAgent"
+	LISTED_USER_AGENT       string = "listedUserAgent"
+	MISSING_USER_AGENT      string = "missingUserAgent"
+	MISSING_ACCEPT_LANGUAGE string = "missingAcceptLanguage"
+)
+
+// Known bots, crawlers, http libraries, and headless browsers
+var knownBots = regexp.MustCompile(`(?i)bot\b|bot/|crawl|spider|slurp|scrape|archiver|facebookexternalhit|` +
+	`embedly|preview|lighthouse|pingdom|uptime|monitor|headlesschrome|phantomjs|selenium|puppeteer|playwright|` +
+	`^curl/|^wget/|python-requests|python-urllib|^go-http-client|^java/|okhttp|axios/|node-fetch|^libwww-perl|^httpie/`)
+
+// BotStage detects bots by their user agent, with a built-in list and an
+// optional list file such as the iab spiders and bots list, and by the
+// headers of their requests. Bots are tagged with a bot context or
+// dropped.
+type BotStage struct {
+	source *source
+	action string
+	listed []string
+}
+
+// readBotList reads a list of case-insensitive user agent patterns, one
+// per line. Pipe-delimited lines, as in the iab list, have the pattern
+// first and are skipped if their second field is 0.
+func readBotList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) > 1 && strings.TrimSpace(fields[1]) == "0" {
+			continue
+		}
+		if pattern := strings.ToLower(strings.TrimSpace(fields[0])); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+func NewBotStage(conf config.Transform) (*BotStage, error) {
+	src, err := newSource(conf, DEFAULT_USER_AGENT_HEADERS, DEFAULT_USER_AGENT_POINTERS)
+	if err != nil {
+		return nil, err
+	}
+	s := &BotStage{source: src, action: conf.Action}
+	switch s.action {
+	case "":
+		s.action = TAG
+	case TAG, DROP_BOT:
+	default:
+		return nil, errors.New("unsupported bot action: " + s.action)
+	}
+	if conf.Path != "" {
+		if s.listed, err = readBotList(conf.Path); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// headersOf returns the http headers context of the envelope, if it has one
+func headersOf(e envelope.Envelope) (map[string]interface{}, bool) {
+	if e.Contexts == nil {
+		return nil, false
+	}
+	headers, ok := (*e.Contexts)[envelope.HTTP_HEADERS_CONTEXT].(map[string]interface{})
+	return headers, ok
+}
+
+func hasHeader(headers map[string]interface{}, name string) bool {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// detect returns the reason the envelope is a bot, and the matching
+// pattern, or an empty reason if it isn't one
+func (s *BotStage) detect(e envelope.Envelope) (string, string) {
+	var userAgent string
+	for _, v := range s.source.values(&e) {
+		if ua, ok := v.(string); ok && ua != "" {
+			userAgent = ua
+			break
+		}
+	}
+	// Headers are only checked for envelopes that came with them
+	headers, hasHeaders := headersOf(e)
+	if userAgent == "" {
+		if hasHeaders {
+			return MISSING_USER_AGENT, ""
+		}
+		return "", ""
+	}
+	if match := knownBots.FindString(userAgent); match != "" {
+		return KNOWN_USER_AGENT, match
+	}
+	lower := strings.ToLower(userAgent)
+	for _, pattern := range s.listed {
+		if strings.Contains(lower, pattern) {
+			return LISTED_USER_AGENT, pattern
+		}
+	}
+	// Browsers always send Accept-Language
+	if hasHeaders && strings.HasPrefix(userAgent, "Mozilla/") && !hasHeader(headers, "Accept-Language") {
+		return MISSING_ACCEPT_LANGUAGE, ""
+	}
+	return "", ""
+}
+
+func (s *BotStage) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	reason, pattern := s.detect(e)
+	if reason == "" {
+		return e, nil
+	}
+	if s.action == DROP_BOT {
+		return e, ErrDrop
+	}
+	bot := map[string]interface{}{"reason": reason}
+	if pattern != "" {
+		bot["pattern"] = pattern
+	}
+	setContext(&e, BOT_CONTEXT, bot)
+	return e, nil
+}
+
+func (s *BotStage) Close() error {
+	return nil
+}
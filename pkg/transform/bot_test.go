@@ -0,0 +1,71 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func requestEnvelope(headers map[string]interface{}) envelope.Envelope {
+	contexts := envelope.Contexts{envelope.HTTP_HEADERS_CONTEXT: headers}
+	return envelope.Envelope{Contexts: &contexts, Payload: envelope.Payload{}}
+}
+
+func TestBotDetection(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "bots.txt")
+	assert.Nil(t, os.WriteFile(list, []byte("# iab list\nacmefetcher|1|0\nretired|0|0\n"), 0644))
+	s, err := NewBotStage(config.Transform{Path: list})
+	assert.Nil(t, err)
+	var testCases = []struct {
+		name       string
+		e          envelope.Envelope
+		wantReason string
+	}{
+		{"googlebot", requestEnvelope(map[string]interface{}{"User-Agent": "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"}), KNOWN_USER_AGENT},
+		{"curl", requestEnvelope(map[string]interface{}{"User-Agent": "curl/8.1.2"}), KNOWN_USER_AGENT},
+		{"listed", requestEnvelope(map[string]interface{}{"User-Agent": "AcmeFetcher 1.0"}), LISTED_USER_AGENT},
+		{"retired", requestEnvelope(map[string]interface{}{"User-Agent": "Retired 1.0"}), ""},
+		{"missingUserAgent", requestEnvelope(map[string]interface{}{}), MISSING_USER_AGENT},
+		{"missingAcceptLanguage", requestEnvelope(map[string]interface{}{"User-Agent": CHROME_MAC}), MISSING_ACCEPT_LANGUAGE},
+		{"browser", requestEnvelope(map[string]interface{}{"User-Agent": CHROME_MAC, "Accept-Language": "en-US"}), ""},
+		{"noHeaders", envelope.Envelope{Payload: envelope.Payload{}}, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transformed, err := s.Transform(context.Background(), tc.e)
+			assert.Nil(t, err)
+			bot, tagged := (*contextsOrEmpty(transformed))[BOT_CONTEXT].(map[string]interface{})
+			assert.Equal(t, tc.wantReason != "", tagged)
+			if tagged {
+				assert.Equal(t, tc.wantReason, bot["reason"])
+			}
+		})
+	}
+}
+
+func contextsOrEmpty(e envelope.Envelope) *envelope.Contexts {
+	if e.Contexts == nil {
+		return &envelope.Contexts{}
+	}
+	return e.Contexts
+}
+
+func TestBotDrop(t *testing.T) {
+	p, err := BuildPipeline([]config.Transform{{Type: BOT, Action: DROP_BOT, Protocols: []string{"pixel"}}})
+	assert.Nil(t, err)
+	bot := requestEnvelope(map[string]interface{}{"User-Agent": "curl/8.1.2"})
+	pixelBot := requestEnvelope(map[string]interface{}{"User-Agent": "curl/8.1.2"})
+	pixelBot.Protocol = "pixel"
+	assert.Len(t, p.Run([]envelope.Envelope{bot, pixelBot}), 1)
+	_, err = NewBotStage(config.Transform{Action: "block"})
+	assert.NotNil(t, err)
+}
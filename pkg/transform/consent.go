@@ -0,0 +1,366 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+// Consent actions, for envelopes without consent to a purpose. Envelopes
+// are kept (KEEP) or dropped (DROP) like they are by other transforms.
+const PSEUDONYMIZE string = "pseudonymize" // Hash the identifiers of the envelope, and strip identifying contexts
+
+const (
+	DEFAULT_CONSENT_POINTER string = "/consent"
+	DEFAULT_CONSENT_TTL_SEC int    = 180 * 24 * 60 * 60
+	// The memory store forgets the consent of the devices set longest ago
+	// beyond this, which treats them as not having consented
+	DEFAULT_CONSENT_MAX_DEVICES int    = 1000000
+	CONSENT_KEY_PREFIX          string = "buz:consent:"
+)
+
+// Payload fields which identify a user or device, and are hashed when
+// envelopes are pseudonymized
+var DEFAULT_CONSENT_IDENTIFIERS = []string{
+	"/network_userid",
+	"/domain_userid",
+	"/user_id",
+	"/domain_sessionid",
+	"/user_ipaddress",
+	"/user_fingerprint",
+}
+
+var consentActions = stats.NewSchemaStats()
+
+// ConsentActions returns the envelopes pseudonymized or dropped for lack
+// of consent, by schema and action
+func ConsentActions() map[string]map[string]int64 {
+	return consentActions.Snapshot()
+}
+
+// consentStore remembers the purposes each device consented to, so
+// envelopes which don't carry consent can be joined to it
+type consentStore interface {
+	get(ctx context.Context, device string, now time.Time) ([]string, bool, error)
+	set(ctx context.Context, device string, granted []string, now time.Time, ttl time.Duration) error
+	close() error
+}
+
+type storedConsent struct {
+	device  string
+	granted []string
+	expiry  time.Time
+}
+
+// memoryConsentStore keeps consents in the order they were set, which is
+// the order they expire in since the ttl is fixed, so expired consents are
+// removed from the front as new ones are set
+type memoryConsentStore struct {
+	mu         sync.Mutex
+	maxDevices int
+	ll         *list.List
+	consents   map[string]*list.Element
+}
+
+func newMemoryConsentStore(maxDevices int) *memoryConsentStore {
+	if maxDevices <= 0 {
+		maxDevices = DEFAULT_CONSENT_MAX_DEVICES
+	}
+	return &memoryConsentStore{maxDevices: maxDevices, ll: list.New(), consents: make(map[string]*list.Element)}
+}
+
+func (s *memoryConsentStore) get(ctx context.Context, device string, now time.Time) ([]string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.consents[device]
+	if !ok || now.After(el.Value.(*storedConsent).expiry) {
+		return nil, false, nil
+	}
+	return el.Value.(*storedConsent).granted, true, nil
+}
+
+func (s *memoryConsentStore) remove(el *list.Element) {
+	s.ll.Remove(el)
+	delete(s.consents, el.Value.(*storedConsent).device)
+}
+
+func (s *memoryConsentStore) set(ctx context.Context, device string, granted []string, now time.Time, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.consents[device]; ok {
+		s.remove(el)
+	}
+	s.consents[device] = s.ll.PushBack(&storedConsent{device: device, granted: granted, expiry: now.Add(ttl)})
+	for el := s.ll.Front(); el != nil && (now.After(el.Value.(*storedConsent).expiry) || s.ll.Len() > s.maxDevices); el = s.ll.Front() {
+		s.remove(el)
+	}
+	return nil
+}
+
+func (s *memoryConsentStore) close() error {
+	return nil
+}
+
+// redisConsentStore shares consents across instances
+type redisConsentStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func (s *redisConsentStore) get(ctx context.Context, device string, now time.Time) ([]string, bool, error) {
+	b, err := s.client.Get(ctx, s.prefix+CONSENT_KEY_PREFIX+device).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var granted []string
+	if err := json.Unmarshal(b, &granted); err != nil {
+		return nil, false, err
+	}
+	return granted, true, nil
+}
+
+func (s *redisConsentStore) set(ctx context.Context, device string, granted []string, now time.Time, ttl time.Duration) error {
+	b, err := json.Marshal(granted)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.prefix+CONSENT_KEY_PREFIX+device, b, ttl).Err()
+}
+
+func (s *redisConsentStore) close() error {
+	return s.client.Close()
+}
+
+type consentRule struct {
+	purpose       string
+	schemas       []string
+	jurisdictions []string
+	action        string
+}
+
+func (r consentRule) appliesTo(schema string, jurisdiction string) bool {
+	if len(r.schemas) > 0 {
+		matched := false
+		for _, prefix := range r.schemas {
+			matched = matched || strings.HasPrefix(schema, prefix)
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.jurisdictions) == 0 {
+		return true
+	}
+	for _, j := range r.jurisdictions {
+		if strings.EqualFold(j, jurisdiction) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConsentStage enforces the consent of envelopes to the purposes of its
+// rules. Consent is read from a payload field, which is either a list of
+// granted purposes or an object of purposes to booleans. The consent of
+// each device is remembered, so later envelopes which don't carry consent
+// are joined to it. Envelopes without any consent have consented to
+// nothing.
+//
+// Each rule which applies to the schema and jurisdiction of an envelope,
+// and whose purpose wasn't granted, decides what happens to the envelope.
+// The strictest action wins.
+type ConsentStage struct {
+	pointer      []string
+	jurisdiction string
+	rules        []consentRule
+	devices      *source
+	hash         *HashStage
+	ttl          time.Duration
+	store        consentStore
+	now          func() time.Time
+}
+
+func NewConsentStage(conf config.Transform) (*ConsentStage, error) {
+	if len(conf.ConsentRules) == 0 {
+		return nil, errors.New("consent transform has no rules")
+	}
+	consentPointer := conf.ConsentPointer
+	if consentPointer == "" {
+		consentPointer = DEFAULT_CONSENT_POINTER
+	}
+	pointer, err := parsePointer(consentPointer)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := newSource(conf, nil, DEFAULT_SESSION_POINTERS)
+	if err != nil {
+		return nil, err
+	}
+	s := &ConsentStage{pointer: pointer, jurisdiction: conf.JurisdictionHeader, devices: devices, now: time.Now}
+	for _, r := range conf.ConsentRules {
+		if r.Purpose == "" {
+			return nil, errors.New("consent rules require a purpose")
+		}
+		switch r.Action {
+		case KEEP, PSEUDONYMIZE, DROP:
+		default:
+			return nil, errors.New("unsupported consent action: " + r.Action)
+		}
+		s.rules = append(s.rules, consentRule{purpose: r.Purpose, schemas: r.Schemas, jurisdictions: r.Jurisdictions, action: r.Action})
+	}
+	hashConf := conf
+	if len(hashConf.Fields) == 0 {
+		for _, p := range DEFAULT_CONSENT_IDENTIFIERS {
+			hashConf.Fields = append(hashConf.Fields, config.HashField{Pointer: p})
+		}
+	}
+	if s.hash, err = NewHashStage(hashConf); err != nil {
+		return nil, err
+	}
+	ttl := conf.TtlSeconds
+	if ttl <= 0 {
+		ttl = DEFAULT_CONSENT_TTL_SEC
+	}
+	s.ttl = time.Duration(ttl) * time.Second
+	switch conf.Store {
+	case MEMORY, "":
+		s.store = newMemoryConsentStore(conf.MaxDevices)
+	case REDIS:
+		client := newRedisClient(conf.Redis)
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			client.Close()
+			return nil, err
+		}
+		s.store = &redisConsentStore{client: client, prefix: conf.Redis.Prefix}
+	default:
+		return nil, errors.New("unsupported consent store: " + conf.Store)
+	}
+	return s, nil
+}
+
+// granted returns the purposes of the consent, and false if the value
+// isn't consent
+func granted(v interface{}) ([]string, bool) {
+	var purposes []string
+	switch v := v.(type) {
+	case []interface{}:
+		for _, p := range v {
+			if purpose, ok := p.(string); ok {
+				purposes = append(purposes, purpose)
+			}
+		}
+	case map[string]interface{}:
+		for purpose, ok := range v {
+			if b, _ := ok.(bool); b {
+				purposes = append(purposes, purpose)
+			}
+		}
+	default:
+		return nil, false
+	}
+	return purposes, true
+}
+
+func (s *ConsentStage) device(e *envelope.Envelope) string {
+	for _, v := range s.devices.values(e) {
+		if device, ok := v.(string); ok && device != "" {
+			return device
+		}
+	}
+	return ""
+}
+
+// consent returns the purposes the envelope consented to, recording them
+// for its device or joining them from it
+func (s *ConsentStage) consent(ctx context.Context, e *envelope.Envelope) ([]string, error) {
+	device := s.device(e)
+	if purposes, ok := granted(resolve(map[string]interface{}(e.Payload), s.pointer)); ok {
+		if device != "" {
+			if err := s.store.set(ctx, device, purposes, s.now(), s.ttl); err != nil {
+				return nil, err
+			}
+		}
+		return purposes, nil
+	}
+	if device == "" {
+		return nil, nil
+	}
+	purposes, _, err := s.store.get(ctx, device, s.now())
+	return purposes, err
+}
+
+func (s *ConsentStage) jurisdictionOf(e envelope.Envelope) string {
+	if s.jurisdiction == "" {
+		return ""
+	}
+	headers, _ := headersOf(e)
+	for k, v := range headers {
+		if value, ok := v.(string); ok && strings.EqualFold(k, s.jurisdiction) {
+			return value
+		}
+	}
+	return ""
+}
+
+func consentStrictness(action string) int {
+	switch action {
+	case PSEUDONYMIZE:
+		return 1
+	case DROP:
+		return 2
+	}
+	return 0
+}
+
+func (s *ConsentStage) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	purposes, err := s.consent(ctx, &e)
+	if err != nil {
+		return e, err
+	}
+	consented := make(map[string]bool, len(purposes))
+	for _, p := range purposes {
+		consented[p] = true
+	}
+	jurisdiction := s.jurisdictionOf(e)
+	action := KEEP
+	for _, r := range s.rules {
+		if !consented[r.purpose] && r.appliesTo(e.Schema, jurisdiction) && consentStrictness(r.action) > consentStrictness(action) {
+			action = r.action
+		}
+	}
+	switch action {
+	case DROP:
+		consentActions.Increment(e.Schema, DROP, 1)
+		return e, ErrDrop
+	case PSEUDONYMIZE:
+		consentActions.Increment(e.Schema, PSEUDONYMIZE, 1)
+		e, err := s.hash.Transform(ctx, e)
+		if err != nil {
+			return e, err
+		}
+		// The client ip and identifying headers aren't kept either
+		envelope.StripIdentifyingContexts(&e)
+		return e, nil
+	}
+	return e, nil
+}
+
+func (s *ConsentStage) Close() error {
+	return s.store.close()
+}
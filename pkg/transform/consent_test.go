@@ -0,0 +1,107 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func consentEnvelope(schema string, jurisdiction string, payload envelope.Payload) envelope.Envelope {
+	contexts := envelope.Contexts{envelope.HTTP_HEADERS_CONTEXT: map[string]interface{}{"Cf-Ipcountry": jurisdiction}}
+	return envelope.Envelope{Schema: schema, Contexts: &contexts, Payload: payload}
+}
+
+func TestConsent(t *testing.T) {
+	mr := miniredis.RunT(t)
+	for _, store := range []string{MEMORY, REDIS} {
+		t.Run(store, func(t *testing.T) {
+			s, err := NewConsentStage(config.Transform{
+				Store:              store,
+				Redis:              config.Redis{Addr: mr.Addr()},
+				JurisdictionHeader: "CF-IPCountry",
+				ConsentRules: []config.ConsentRule{
+					{Purpose: "analytics", Jurisdictions: []string{"DE", "FR"}, Action: PSEUDONYMIZE},
+					{Purpose: "ads", Schemas: []string{"com.acme/ads"}, Action: DROP},
+				},
+			})
+			assert.Nil(t, err)
+			defer s.Close()
+			ctx := context.Background()
+			device := "device-" + store
+
+			// Envelopes without consent to analytics are pseudonymized in the eu
+			e, err := s.Transform(ctx, consentEnvelope("com.acme/page", "de", envelope.Payload{"domain_userid": device}))
+			assert.Nil(t, err)
+			assert.NotEqual(t, device, e.Payload["domain_userid"])
+			e, err = s.Transform(ctx, consentEnvelope("com.acme/page", "US", envelope.Payload{"domain_userid": device}))
+			assert.Nil(t, err)
+			assert.Equal(t, device, e.Payload["domain_userid"])
+
+			// Consent is remembered for the device
+			_, err = s.Transform(ctx, consentEnvelope("com.acme/consent", "DE", envelope.Payload{
+				"domain_userid": device,
+				"consent":       map[string]interface{}{"analytics": true, "ads": false},
+			}))
+			assert.Nil(t, err)
+			e, err = s.Transform(ctx, consentEnvelope("com.acme/page", "DE", envelope.Payload{"domain_userid": device}))
+			assert.Nil(t, err)
+			assert.Equal(t, device, e.Payload["domain_userid"])
+
+			_, err = s.Transform(ctx, consentEnvelope("com.acme/ads/click", "DE", envelope.Payload{"domain_userid": device}))
+			assert.ErrorIs(t, err, ErrDrop)
+			_, err = s.Transform(ctx, consentEnvelope("com.acme/ads/click", "DE", envelope.Payload{"consent": []interface{}{"ads"}}))
+			assert.Nil(t, err)
+		})
+	}
+	assert.Equal(t, int64(2), ConsentActions()["com.acme/page"][PSEUDONYMIZE])
+}
+
+func TestConsentPseudonymizeStripsIdentifyingContexts(t *testing.T) {
+	s, err := NewConsentStage(config.Transform{ConsentRules: []config.ConsentRule{{Purpose: "analytics", Action: PSEUDONYMIZE}}})
+	assert.Nil(t, err)
+	defer s.Close()
+	contexts := envelope.Contexts{
+		envelope.HTTP_HEADERS_CONTEXT: map[string]interface{}{"Cookie": "sp=device", "X-Forwarded-For": "203.0.113.7", "Cf-Ipcountry": "DE"},
+		envelope.CLIENT_CONTEXT:       map[string]interface{}{"ip": "203.0.113.7"},
+	}
+	e, err := s.Transform(context.Background(), envelope.Envelope{Schema: "com.acme/page", Contexts: &contexts, Payload: envelope.Payload{"user_ipaddress": "203.0.113.7"}})
+	assert.Nil(t, err)
+	assert.NotEqual(t, "203.0.113.7", e.Payload["user_ipaddress"])
+	assert.Equal(t, map[string]interface{}{"Cf-Ipcountry": "DE"}, (*e.Contexts)[envelope.HTTP_HEADERS_CONTEXT])
+	assert.NotContains(t, *e.Contexts, envelope.CLIENT_CONTEXT)
+}
+
+func TestMemoryConsentStoreForgetsExpiredAndOldestDevices(t *testing.T) {
+	s := newMemoryConsentStore(3)
+	ctx := context.Background()
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, s.set(ctx, fmt.Sprint(i), []string{"analytics"}, now, time.Minute))
+	}
+	// The devices set longest ago are forgotten
+	assert.Equal(t, 3, len(s.consents))
+	_, ok, _ := s.get(ctx, "1", now)
+	assert.False(t, ok)
+	_, ok, _ = s.get(ctx, "4", now)
+	assert.True(t, ok)
+	// Expired consents are removed as others are set
+	assert.Nil(t, s.set(ctx, "5", []string{"analytics"}, now.Add(2*time.Minute), time.Minute))
+	assert.Equal(t, 1, len(s.consents))
+}
+
+func TestConsentRequiresValidRules(t *testing.T) {
+	_, err := NewConsentStage(config.Transform{})
+	assert.NotNil(t, err)
+	_, err = NewConsentStage(config.Transform{ConsentRules: []config.ConsentRule{{Purpose: "ads", Action: "anonymize"}}})
+	assert.NotNil(t, err)
+}
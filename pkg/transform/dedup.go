@@ -0,0 +1,208 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const (
+	DEFAULT_DEDUP_TTL_SEC int    = 3600
+	DEFAULT_DEDUP_MAX_IDS int    = 1000000
+	DEDUP_KEY_PREFIX      string = "buz:dedup:"
+)
+
+var (
+	DEFAULT_DEDUP_HEADERS  = []string{"Idempotency-Key"}
+	DEFAULT_DEDUP_POINTERS = []string{"/event_id"}
+)
+
+// dedupStore remembers ids for the ttl. Claiming an id returns false if
+// it has already been claimed, until it is released.
+type dedupStore interface {
+	claim(ctx context.Context, id string, now time.Time, ttl time.Duration) (bool, error)
+	release(ctx context.Context, id string) error
+	close() error
+}
+
+type claimedId struct {
+	id     string
+	expiry time.Time
+}
+
+// memoryDedupStore keeps ids in the order they were claimed, which is the
+// order they expire in since the ttl is fixed, so expired ids are removed
+// from the front as new ones are claimed. Once it holds maxIds, the ids
+// claimed longest ago are forgotten first.
+type memoryDedupStore struct {
+	mu     sync.Mutex
+	maxIds int
+	ll     *list.List
+	ids    map[string]*list.Element
+}
+
+func newMemoryDedupStore(maxIds int) *memoryDedupStore {
+	if maxIds <= 0 {
+		maxIds = DEFAULT_DEDUP_MAX_IDS
+	}
+	return &memoryDedupStore{maxIds: maxIds, ll: list.New(), ids: make(map[string]*list.Element)}
+}
+
+func (s *memoryDedupStore) remove(el *list.Element) {
+	s.ll.Remove(el)
+	delete(s.ids, el.Value.(*claimedId).id)
+}
+
+func (s *memoryDedupStore) claim(ctx context.Context, id string, now time.Time, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.ids[id]; ok {
+		if !now.After(el.Value.(*claimedId).expiry) {
+			return false, nil
+		}
+		s.remove(el)
+	}
+	s.ids[id] = s.ll.PushBack(&claimedId{id: id, expiry: now.Add(ttl)})
+	for el := s.ll.Front(); el != nil && (now.After(el.Value.(*claimedId).expiry) || s.ll.Len() > s.maxIds); el = s.ll.Front() {
+		s.remove(el)
+	}
+	return true, nil
+}
+
+func (s *memoryDedupStore) release(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.ids[id]; ok {
+		s.remove(el)
+	}
+	return nil
+}
+
+func (s *memoryDedupStore) close() error {
+	return nil
+}
+
+// redisDedupStore shares claimed ids across instances, so duplicates
+// are dropped no matter which instance receives them
+type redisDedupStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func (s *redisDedupStore) claim(ctx context.Context, id string, now time.Time, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.prefix+DEDUP_KEY_PREFIX+id, now.UnixMilli(), ttl).Result()
+}
+
+func (s *redisDedupStore) release(ctx context.Context, id string) error {
+	return s.client.Del(ctx, s.prefix+DEDUP_KEY_PREFIX+id).Err()
+}
+
+func (s *redisDedupStore) close() error {
+	return s.client.Close()
+}
+
+// DedupStage drops envelopes with an id that has already been seen
+// within the ttl, such as retried tracker requests. Ids come from payload
+// fields, or from a request header along with the envelope's position in
+// the request. Envelopes without an id are kept. Ids are released if the
+// manifold rejects the envelopes. If the store is unavailable the stage
+// fails, so the error policy decides whether duplicates are risked or
+// envelopes are dropped.
+type DedupStage struct {
+	source *source
+	ttl    time.Duration
+	store  dedupStore
+	now    func() time.Time
+}
+
+func NewDedupStage(conf config.Transform) (*DedupStage, error) {
+	src, err := newSource(conf, DEFAULT_DEDUP_HEADERS, DEFAULT_DEDUP_POINTERS)
+	if err != nil {
+		return nil, err
+	}
+	ttl := conf.TtlSeconds
+	if ttl <= 0 {
+		ttl = DEFAULT_DEDUP_TTL_SEC
+	}
+	s := &DedupStage{source: src, ttl: time.Duration(ttl) * time.Second, now: time.Now}
+	switch conf.Store {
+	case MEMORY, "":
+		s.store = newMemoryDedupStore(conf.MaxIds)
+	case REDIS:
+		client := newRedisClient(conf.Redis)
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			client.Close()
+			return nil, err
+		}
+		s.store = &redisDedupStore{client: client, prefix: conf.Redis.Prefix}
+	default:
+		return nil, errors.New("unsupported dedup store: " + conf.Store)
+	}
+	return s, nil
+}
+
+// firstId returns the first non-empty string of the values
+func firstId(values []interface{}) string {
+	for _, v := range values {
+		if str, ok := v.(string); ok && str != "" {
+			return str
+		}
+	}
+	return ""
+}
+
+// id returns the id of the envelope, or an empty string if it has none
+func (s *DedupStage) id(ctx context.Context, e envelope.Envelope) string {
+	if id := firstId(s.source.fieldValues(&e)); id != "" {
+		return id
+	}
+	// Every envelope of a request shares its headers, so they are told
+	// apart by their position in the request
+	key := firstId(s.source.headerValues(&e))
+	if key == "" {
+		return ""
+	}
+	return key + "#" + strconv.Itoa(requestIndex(ctx))
+}
+
+func (s *DedupStage) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	id := s.id(ctx, e)
+	if id == "" {
+		return e, nil
+	}
+	claimed, err := s.store.claim(ctx, id, s.now(), s.ttl)
+	if err != nil {
+		return e, err
+	}
+	if !claimed {
+		log.Debug().Str("id", id).Str("schema", e.Schema).Msg("🟡 dropping duplicate envelope")
+		return e, ErrDrop
+	}
+	return e, nil
+}
+
+// Release forgets the id of an envelope which wasn't accepted, so it isn't
+// dropped when it is retried
+func (s *DedupStage) Release(ctx context.Context, e envelope.Envelope) error {
+	id := s.id(ctx, e)
+	if id == "" {
+		return nil
+	}
+	return s.store.release(ctx, id)
+}
+
+func (s *DedupStage) Close() error {
+	return s.store.close()
+}
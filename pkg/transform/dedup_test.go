@@ -0,0 +1,125 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedup(t *testing.T) {
+	mr := miniredis.RunT(t)
+	for _, store := range []string{MEMORY, REDIS} {
+		t.Run(store, func(t *testing.T) {
+			conf := config.Transform{Store: store, Redis: config.Redis{Addr: mr.Addr(), Prefix: "a:"}, TtlSeconds: 60}
+			s, err := NewDedupStage(conf)
+			assert.Nil(t, err)
+			defer s.Close()
+			now := time.Unix(1700000000, 0)
+			s.now = func() time.Time { return now }
+			e := envelope.Envelope{Payload: envelope.Payload{"event_id": store}}
+
+			_, err = s.Transform(context.Background(), e)
+			assert.Nil(t, err)
+			_, err = s.Transform(context.Background(), e)
+			assert.ErrorIs(t, err, ErrDrop)
+
+			// Replicas sharing a store drop each other's duplicates
+			if store == REDIS {
+				replica, err := NewDedupStage(conf)
+				assert.Nil(t, err)
+				defer replica.Close()
+				_, err = replica.Transform(context.Background(), e)
+				assert.ErrorIs(t, err, ErrDrop)
+				mr.FastForward(61 * time.Second)
+			}
+			now = now.Add(61 * time.Second)
+			_, err = s.Transform(context.Background(), e)
+			assert.Nil(t, err)
+
+			_, err = s.Transform(context.Background(), envelope.Envelope{Payload: envelope.Payload{}})
+			assert.Nil(t, err)
+		})
+	}
+}
+
+func TestDedupFailsWithoutStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := NewDedupStage(config.Transform{Store: REDIS, Redis: config.Redis{Addr: mr.Addr()}})
+	assert.Nil(t, err)
+	defer s.Close()
+	mr.Close()
+	_, err = s.Transform(context.Background(), envelope.Envelope{Payload: envelope.Payload{"event_id": "1"}})
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, ErrDrop)
+}
+
+func TestDedupByRequestHeader(t *testing.T) {
+	s, err := NewDedupStage(config.Transform{})
+	assert.Nil(t, err)
+	defer s.Close()
+	contexts := envelope.Contexts{envelope.HTTP_HEADERS_CONTEXT: map[string]interface{}{"Idempotency-Key": "request-1"}}
+	request := []envelope.Envelope{
+		{Schema: "a", Payload: envelope.Payload{}, Contexts: &contexts},
+		{Schema: "b", Payload: envelope.Payload{}, Contexts: &contexts},
+	}
+	p := &Pipeline{stages: []*stage{{Stage: s, name: DEDUP, timeout: time.Second}}}
+	// The envelopes of a request share its key, but aren't duplicates
+	assert.Len(t, p.Run(request), 2)
+	// A retried request is
+	assert.Len(t, p.Run(request), 0)
+}
+
+func TestMemoryDedupStoreIsBounded(t *testing.T) {
+	s := newMemoryDedupStore(2)
+	now := time.Unix(1700000000, 0)
+	for _, id := range []string{"a", "b", "c"} {
+		claimed, err := s.claim(context.Background(), id, now, time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, claimed)
+	}
+	assert.Equal(t, 2, s.ll.Len())
+	// The id claimed longest ago is forgotten
+	claimed, _ := s.claim(context.Background(), "a", now, time.Minute)
+	assert.True(t, claimed)
+	claimed, _ = s.claim(context.Background(), "c", now, time.Minute)
+	assert.False(t, claimed)
+
+	// Expired ids are removed as new ones are claimed
+	claimed, _ = s.claim(context.Background(), "d", now.Add(2*time.Minute), time.Minute)
+	assert.True(t, claimed)
+	assert.Equal(t, 1, s.ll.Len())
+	assert.Len(t, s.ids, 1)
+}
+
+func TestDedupReleasesRejectedIds(t *testing.T) {
+	mr := miniredis.RunT(t)
+	for _, store := range []string{MEMORY, REDIS} {
+		t.Run(store, func(t *testing.T) {
+			s, err := NewDedupStage(config.Transform{Store: store, Redis: config.Redis{Addr: mr.Addr()}})
+			assert.Nil(t, err)
+			defer s.Close()
+			p := &Pipeline{stages: []*stage{{Stage: s, name: DEDUP, timeout: time.Second}}}
+			contexts := envelope.Contexts{envelope.HTTP_HEADERS_CONTEXT: map[string]interface{}{"Idempotency-Key": store}}
+			request := []envelope.Envelope{
+				{Payload: envelope.Payload{"event_id": store}},
+				{Payload: envelope.Payload{}, Contexts: &contexts},
+			}
+			transformed, release := p.RunReleasable(request)
+			assert.Len(t, transformed, 2)
+			// The request was rejected, so its retry isn't a duplicate
+			release()
+			transformed, _ = p.RunReleasable(request)
+			assert.Len(t, transformed, 2)
+			assert.Len(t, p.Run(request), 0)
+		})
+	}
+}
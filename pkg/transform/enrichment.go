@@ -0,0 +1,57 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// Enricher attaches information to an envelope, such as a context, without
+// otherwise transforming it. Enrichers must not modify the envelope if they
+// return an error.
+type Enricher interface {
+	Enrich(ctx context.Context, e *envelope.Envelope) error
+	Close() error
+}
+
+// EnricherBuilder returns a new enricher for the transform config
+type EnricherBuilder func(conf config.Transform) (Enricher, error)
+
+type enrichment struct {
+	Enricher
+}
+
+func (s *enrichment) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	err := s.Enrich(ctx, &e)
+	return e, err
+}
+
+// setContext sets a context of the envelope. Contexts are copied rather
+// than modified, since they may be shared with the original envelope.
+func setContext(e *envelope.Envelope, name string, value interface{}) {
+	contexts := make(envelope.Contexts)
+	if e.Contexts != nil {
+		for k, v := range *e.Contexts {
+			contexts[k] = v
+		}
+	}
+	contexts[name] = value
+	e.Contexts = &contexts
+}
+
+// RegisterEnrichment adds a custom enrichment, which is configured as a
+// transform of the enrichment type.
+func RegisterEnrichment(enrichmentType string, b EnricherBuilder) {
+	Register(enrichmentType, func(conf config.Transform) (Stage, error) {
+		e, err := b(conf)
+		if err != nil {
+			return nil, err
+		}
+		return &enrichment{Enricher: e}, nil
+	})
+}
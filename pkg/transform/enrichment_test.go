@@ -0,0 +1,83 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+type upperSchema struct{}
+
+func (s *upperSchema) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	e.Schema = strings.ToUpper(e.Schema)
+	return e, nil
+}
+
+func (s *upperSchema) Close() error { return nil }
+
+type tenantEnricher struct {
+	tenant string
+}
+
+func (t *tenantEnricher) Enrich(ctx context.Context, e *envelope.Envelope) error {
+	e.Contexts = &envelope.Contexts{"tenant": t.tenant}
+	return nil
+}
+
+func (t *tenantEnricher) Close() error { return nil }
+
+func TestRegisteredTransformsAndEnrichments(t *testing.T) {
+	Register("upper", func(conf config.Transform) (Stage, error) {
+		return &upperSchema{}, nil
+	})
+	RegisterEnrichment("tenant", func(conf config.Transform) (Enricher, error) {
+		return &tenantEnricher{tenant: conf.Name}, nil
+	})
+	p, err := BuildPipeline([]config.Transform{{Type: "upper"}, {Name: "acme", Type: "tenant"}})
+	assert.Nil(t, err)
+	transformed := p.Run([]envelope.Envelope{testEnvelope()})
+	assert.Equal(t, "COM.ACME/PAGE/V1.0.JSON", transformed[0].Schema)
+	assert.Equal(t, "acme", (*transformed[0].Contexts)["tenant"])
+}
+
+func TestCelFilter(t *testing.T) {
+	p, err := BuildPipeline([]config.Transform{{Type: CEL, Expression: `payload.path != "/health"`, OnError: DROP}})
+	assert.Nil(t, err)
+	health := testEnvelope()
+	health.Payload = envelope.Payload{"path": "/health"}
+	missing := testEnvelope()
+	missing.Payload = envelope.Payload{}
+	transformed := p.Run([]envelope.Envelope{testEnvelope(), health, missing})
+	assert.Len(t, transformed, 1)
+	assert.Equal(t, "/", transformed[0].Payload["path"])
+	_, err = BuildPipeline([]config.Transform{{Type: CEL, Expression: `payload.`}})
+	assert.NotNil(t, err)
+}
+
+type panickingStage struct{}
+
+func (s *panickingStage) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	panic("poison")
+}
+
+func (s *panickingStage) Close() error { return nil }
+
+func TestPanickingTransform(t *testing.T) {
+	Register("panic", func(conf config.Transform) (Stage, error) {
+		return &panickingStage{}, nil
+	})
+	p, err := BuildPipeline([]config.Transform{{Name: "panic", Type: "panic", OnError: INVALID}})
+	assert.Nil(t, err)
+	transformed := p.Run([]envelope.Envelope{testEnvelope()})
+	assert.Len(t, transformed, 1)
+	assert.False(t, transformed[0].IsValid)
+	assert.Equal(t, "transform panicked: poison", transformed[0].ValidationError.Errors[0].Description)
+}
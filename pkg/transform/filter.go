@@ -0,0 +1,41 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/expression"
+)
+
+// FilterStage drops envelopes the cel expression is false for
+type FilterStage struct {
+	expr *expression.Expression
+}
+
+func NewFilterStage(conf config.Transform) (*FilterStage, error) {
+	expr, err := expression.Compile(conf.Expression)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterStage{expr: expr}, nil
+}
+
+func (s *FilterStage) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	keep, err := s.expr.Match(e)
+	if err != nil {
+		return e, err
+	}
+	if !keep {
+		return e, ErrDrop
+	}
+	return e, nil
+}
+
+func (s *FilterStage) Close() error {
+	return nil
+}
@@ -0,0 +1,306 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const GEO_CONTEXT string = "io.silverton/buz/internal/contexts/geo/v1.0.json"
+
+const (
+	DEFAULT_GEO_EDITION              string = "GeoLite2-City"
+	DEFAULT_ASN_EDITION              string = "GeoLite2-ASN"
+	DEFAULT_GEO_REFRESH_INTERVAL_SEC int    = 86400
+	// The edition is formatted into the url, and the account id and
+	// license key are sent as basic auth
+	MAXMIND_DOWNLOAD_URL string        = "https://download.maxmind.com/geoip/databases/%s/download?suffix=tar.gz"
+	GEO_DOWNLOAD_TIMEOUT time.Duration = 5 * time.Minute
+)
+
+// geoReader is satisfied by *geoip2.Reader
+type geoReader interface {
+	City(ip net.IP) (*geoip2.City, error)
+	ASN(ip net.IP) (*geoip2.ASN, error)
+	Close() error
+}
+
+// GeoEnricher attaches a geo context to envelopes, by looking up the
+// client ip in maxmind city and asn databases. Databases are downloaded
+// with a license key if one is configured, and reloaded periodically.
+type GeoEnricher struct {
+	conf        config.Transform
+	source      *source
+	client      *http.Client
+	downloadUrl string
+	mu          sync.RWMutex
+	city        geoReader
+	asn         geoReader
+	shutdown    chan struct{}
+	wg          sync.WaitGroup
+}
+
+func NewGeoEnricher(conf config.Transform) (*GeoEnricher, error) {
+	if conf.Path == "" {
+		return nil, errors.New("geoip enrichment has no database path")
+	}
+	if conf.LicenseKey != "" && conf.AccountId == "" {
+		return nil, errors.New("geoip database downloads need the maxmind account id of the license key")
+	}
+	if conf.Edition == "" {
+		conf.Edition = DEFAULT_GEO_EDITION
+	}
+	if conf.AsnEdition == "" {
+		conf.AsnEdition = DEFAULT_ASN_EDITION
+	}
+	src, err := newSource(conf, DEFAULT_IP_HEADERS, DEFAULT_IP_POINTERS)
+	if err != nil {
+		return nil, err
+	}
+	// The resolved client ip is preferred to headers, whose first ip may be spoofed
+	src.clientIp = true
+	g := &GeoEnricher{
+		conf:        conf,
+		source:      src,
+		client:      &http.Client{Timeout: GEO_DOWNLOAD_TIMEOUT},
+		downloadUrl: MAXMIND_DOWNLOAD_URL,
+		shutdown:    make(chan struct{}),
+	}
+	if err := g.load(); err != nil {
+		return nil, err
+	}
+	interval := conf.RefreshIntervalSeconds
+	if interval <= 0 {
+		interval = DEFAULT_GEO_REFRESH_INTERVAL_SEC
+	}
+	g.wg.Add(1)
+	go g.refresh(time.Duration(interval) * time.Second)
+	return g, nil
+}
+
+// download replaces the database at the path with the latest edition.
+// Errors leave out the url, so it isn't logged.
+func (g *GeoEnricher) download(edition string, path string) error {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(g.downloadUrl, url.PathEscape(edition)), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(g.conf.AccountId, g.conf.LicenseKey)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("could not download %s: %w", edition, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not download %s: %s", edition, resp.Status)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%s download has no database", edition)
+		}
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(header.Name, ".mmdb") {
+			continue
+		}
+		// Write then rename, so readers never see a partial database
+		tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tmp, tr)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+		return os.Rename(tmp.Name(), path)
+	}
+}
+
+// load downloads the databases if there is a license key, and swaps
+// in readers of them
+func (g *GeoEnricher) load() error {
+	if g.conf.LicenseKey != "" {
+		if err := g.download(g.conf.Edition, g.conf.Path); err != nil {
+			return err
+		}
+		if g.conf.AsnPath != "" {
+			if err := g.download(g.conf.AsnEdition, g.conf.AsnPath); err != nil {
+				return err
+			}
+		}
+	}
+	city, err := geoip2.Open(g.conf.Path)
+	if err != nil {
+		return err
+	}
+	var asn geoReader
+	if g.conf.AsnPath != "" {
+		if asn, err = geoip2.Open(g.conf.AsnPath); err != nil {
+			city.Close()
+			return err
+		}
+	}
+	g.swap(city, asn)
+	return nil
+}
+
+func (g *GeoEnricher) swap(city geoReader, asn geoReader) {
+	g.mu.Lock()
+	oldCity, oldAsn := g.city, g.asn
+	g.city, g.asn = city, asn
+	g.mu.Unlock()
+	if oldCity != nil {
+		oldCity.Close()
+	}
+	if oldAsn != nil {
+		oldAsn.Close()
+	}
+}
+
+func (g *GeoEnricher) refresh(interval time.Duration) {
+	defer g.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.shutdown:
+			return
+		case <-ticker.C:
+			// The current databases are kept if they can't be reloaded
+			if err := g.load(); err != nil {
+				log.Error().Err(err).Msg("🔴 could not refresh geoip databases")
+			} else {
+				log.Debug().Msg("🟡 refreshed geoip databases")
+			}
+		}
+	}
+}
+
+func firstIp(v interface{}) net.IP {
+	switch v := v.(type) {
+	case string:
+		for _, candidate := range strings.Split(v, ",") {
+			if ip := net.ParseIP(strings.TrimSpace(candidate)); ip != nil {
+				return ip
+			}
+		}
+	case []string:
+		return firstIp(toInterfaces(v))
+	case []interface{}:
+		for _, item := range v {
+			if ip := firstIp(item); ip != nil {
+				return ip
+			}
+		}
+	}
+	return nil
+}
+
+func (g *GeoEnricher) lookup(ip net.IP) (map[string]interface{}, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	// The readers are gone once the enricher is closed
+	if g.city == nil {
+		return nil, nil
+	}
+	geo := make(map[string]interface{})
+	city, err := g.city.City(ip)
+	if err != nil {
+		return nil, err
+	}
+	if city.Country.IsoCode != "" {
+		geo["country"] = city.Country.IsoCode
+		geo["countryName"] = city.Country.Names["en"]
+	}
+	if len(city.Subdivisions) > 0 {
+		geo["region"] = city.Subdivisions[0].IsoCode
+		geo["regionName"] = city.Subdivisions[0].Names["en"]
+	}
+	if name := city.City.Names["en"]; name != "" {
+		geo["city"] = name
+	}
+	if city.Postal.Code != "" {
+		geo["postalCode"] = city.Postal.Code
+	}
+	if city.Location.TimeZone != "" {
+		geo["timezone"] = city.Location.TimeZone
+	}
+	if city.Location.Latitude != 0 || city.Location.Longitude != 0 {
+		geo["latitude"] = city.Location.Latitude
+		geo["longitude"] = city.Location.Longitude
+	}
+	if g.asn != nil {
+		asn, err := g.asn.ASN(ip)
+		if err != nil {
+			return nil, err
+		}
+		if asn.AutonomousSystemNumber != 0 {
+			geo["asn"] = asn.AutonomousSystemNumber
+			geo["asOrganization"] = asn.AutonomousSystemOrganization
+		}
+	}
+	return geo, nil
+}
+
+func (g *GeoEnricher) Enrich(ctx context.Context, e *envelope.Envelope) error {
+	var ip net.IP
+	for _, v := range g.source.values(e) {
+		if ip = firstIp(v); ip != nil {
+			break
+		}
+	}
+	if ip == nil {
+		return nil
+	}
+	geo, err := g.lookup(ip)
+	if err != nil {
+		return err
+	}
+	if len(geo) == 0 {
+		return nil
+	}
+	setContext(e, GEO_CONTEXT, geo)
+	return nil
+}
+
+func (g *GeoEnricher) Close() error {
+	close(g.shutdown)
+	g.wg.Wait()
+	g.swap(nil, nil)
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeGeoReader struct {
+	lookups []string
+}
+
+func (r *fakeGeoReader) City(ip net.IP) (*geoip2.City, error) {
+	r.lookups = append(r.lookups, ip.String())
+	city := &geoip2.City{}
+	city.Country.IsoCode = "US"
+	city.Country.Names = map[string]string{"en": "United States"}
+	city.City.Names = map[string]string{"en": "Denver"}
+	city.Location.TimeZone = "America/Denver"
+	return city, nil
+}
+
+func (r *fakeGeoReader) ASN(ip net.IP) (*geoip2.ASN, error) {
+	return &geoip2.ASN{AutonomousSystemNumber: 64496, AutonomousSystemOrganization: "Example"}, nil
+}
+
+func (r *fakeGeoReader) Close() error { return nil }
+
+func TestGeoEnrichment(t *testing.T) {
+	reader := &fakeGeoReader{}
+	g := &GeoEnricher{source: &source{headers: DEFAULT_IP_HEADERS, pointers: [][]string{{"user_ipaddress"}}}, city: reader, asn: reader}
+	contexts := envelope.Contexts{envelope.HTTP_HEADERS_CONTEXT: map[string]interface{}{"X-Forwarded-For": "unknown, 203.0.113.195"}}
+	e := envelope.Envelope{Contexts: &contexts, Payload: envelope.Payload{"user_ipaddress": "198.51.100.7"}}
+	assert.Nil(t, g.Enrich(context.Background(), &e))
+	assert.Equal(t, map[string]interface{}{
+		"country":        "US",
+		"countryName":    "United States",
+		"city":           "Denver",
+		"timezone":       "America/Denver",
+		"asn":            uint(64496),
+		"asOrganization": "Example",
+	}, (*e.Contexts)[GEO_CONTEXT])
+	assert.NotContains(t, contexts, GEO_CONTEXT)
+
+	e = envelope.Envelope{Payload: envelope.Payload{"user_ipaddress": "198.51.100.7"}}
+	assert.Nil(t, g.Enrich(context.Background(), &e))
+	assert.Equal(t, []string{"203.0.113.195", "198.51.100.7"}, reader.lookups)
+
+	e = envelope.Envelope{Payload: envelope.Payload{}}
+	assert.Nil(t, g.Enrich(context.Background(), &e))
+	assert.Nil(t, e.Contexts)
+
+	// Envelopes still in flight once the enricher is closed aren't enriched
+	g.shutdown = make(chan struct{})
+	assert.Nil(t, g.Close())
+	e = envelope.Envelope{Payload: envelope.Payload{"user_ipaddress": "198.51.100.7"}}
+	assert.Nil(t, g.Enrich(context.Background(), &e))
+	assert.Nil(t, e.Contexts)
+}
+
+func TestGeoDatabaseMustExist(t *testing.T) {
+	_, err := NewGeoEnricher(config.Transform{})
+	assert.NotNil(t, err)
+	_, err = BuildPipeline([]config.Transform{{Type: GEO, Path: filepath.Join(t.TempDir(), "missing.mmdb")}})
+	assert.NotNil(t, err)
+}
+
+func TestGeoDownload(t *testing.T) {
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	db := []byte("mmdb")
+	tw.WriteHeader(&tar.Header{Name: "GeoLite2-City_20230101/GeoLite2-City.mmdb", Mode: 0644, Size: int64(len(db))})
+	tw.Write(db)
+	tw.Close()
+	gz.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account, key, ok := r.BasicAuth()
+		if !ok || account != "42" || key != "secret" || r.URL.Path != "/GeoLite2-City/download" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(archive.Bytes())
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	g := &GeoEnricher{
+		conf:        config.Transform{AccountId: "42", LicenseKey: "secret"},
+		client:      server.Client(),
+		downloadUrl: server.URL + "/%s/download",
+	}
+	assert.Nil(t, g.download("GeoLite2-City", path))
+	b, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, db, b)
+
+	g.conf.LicenseKey = "wrong"
+	assert.NotNil(t, g.download("GeoLite2-City", path))
+
+	// The license key isn't in the errors which are logged
+	server.Close()
+	g.conf.LicenseKey = "secret"
+	err = g.download("GeoLite2-City", path)
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+	assert.NotContains(t, err.Error(), server.URL)
+
+	_, err = NewGeoEnricher(config.Transform{Path: path, LicenseKey: "secret"})
+	assert.NotNil(t, err)
+}
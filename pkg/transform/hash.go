@@ -0,0 +1,193 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// Hash methods
+const (
+	SHA256  string = "hash"
+	ENCRYPT string = "encrypt"
+)
+
+type hashField struct {
+	pointer []string
+	method  string
+}
+
+// HashStage pseudonymizes payload fields before they are delivered,
+// by hashing them with sha256 or encrypting them with aes-gcm. Hashes
+// are hex encoded, and ciphertexts are base64 encoded with the nonce
+// prepended.
+type HashStage struct {
+	fields []hashField
+	salt   string
+	pepper []byte
+	aead   cipher.AEAD
+}
+
+// parsePointer parses a json pointer into its reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("invalid json pointer: " + pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func NewHashStage(conf config.Transform) (*HashStage, error) {
+	if len(conf.Fields) == 0 {
+		return nil, errors.New("hash transform has no fields")
+	}
+	s := &HashStage{salt: conf.Salt, pepper: []byte(conf.Pepper)}
+	for _, f := range conf.Fields {
+		pointer, err := parsePointer(f.Pointer)
+		if err != nil {
+			return nil, err
+		}
+		method := f.Method
+		if method == "" {
+			method = SHA256
+		}
+		switch method {
+		case SHA256:
+		case ENCRYPT:
+			if s.aead == nil {
+				if s.aead, err = newAead(conf.Key); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, errors.New("unsupported hash method: " + method)
+		}
+		s.fields = append(s.fields, hashField{pointer: pointer, method: method})
+	}
+	return s, nil
+}
+
+func newAead(key string) (cipher.AEAD, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, errors.New("aes key is not base64 encoded")
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// stringify returns the value as a string, so fields of any type
+// can be hashed
+func stringify(v interface{}) string {
+	if str, ok := v.(string); ok {
+		return str
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func (s *HashStage) hash(v interface{}) string {
+	var h hash.Hash
+	if len(s.pepper) > 0 {
+		h = hmac.New(sha256.New, s.pepper)
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(s.salt + stringify(v)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *HashStage) encrypt(v interface{}) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(stringify(v)), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// replace returns a copy of the value with the field at the pointer
+// replaced. Missing fields are left as they are.
+func replace(v interface{}, pointer []string, fn func(interface{}) (string, error)) (interface{}, error) {
+	if len(pointer) == 0 {
+		if v == nil {
+			return nil, nil
+		}
+		return fn(v)
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		child, ok := v[pointer[0]]
+		if !ok {
+			return v, nil
+		}
+		replaced, err := replace(child, pointer[1:], fn)
+		if err != nil {
+			return v, err
+		}
+		c := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			c[k] = item
+		}
+		c[pointer[0]] = replaced
+		return c, nil
+	case envelope.Payload:
+		return replace(map[string]interface{}(v), pointer, fn)
+	case []interface{}:
+		i, err := strconv.Atoi(pointer[0])
+		if err != nil || i < 0 || i >= len(v) {
+			return v, nil
+		}
+		replaced, err := replace(v[i], pointer[1:], fn)
+		if err != nil {
+			return v, err
+		}
+		c := append([]interface{}{}, v...)
+		c[i] = replaced
+		return c, nil
+	}
+	return v, nil
+}
+
+func (s *HashStage) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	var payload interface{} = map[string]interface{}(e.Payload)
+	for _, f := range s.fields {
+		fn := s.encrypt
+		if f.method == SHA256 {
+			fn = func(v interface{}) (string, error) { return s.hash(v), nil }
+		}
+		var err error
+		if payload, err = replace(payload, f.pointer, fn); err != nil {
+			return e, err
+		}
+	}
+	e.Payload = payload.(map[string]interface{})
+	return e, nil
+}
+
+func (s *HashStage) Close() error {
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashFields(t *testing.T) {
+	stage, err := NewHashStage(config.Transform{
+		Salt: "salt",
+		Fields: []config.HashField{
+			{Pointer: "/user/email"},
+			{Pointer: "/ids/1"},
+			{Pointer: "/a~1b"},
+			{Pointer: "/missing/field"},
+		},
+	})
+	assert.Nil(t, err)
+	e := envelope.Envelope{Payload: envelope.Payload{
+		"user": map[string]interface{}{"email": "jane@example.com", "name": "jane"},
+		"ids":  []interface{}{"keep", float64(42)},
+		"a/b":  "slash",
+	}}
+	transformed, err := stage.Transform(context.Background(), e)
+	assert.Nil(t, err)
+	sum := func(v string) string {
+		h := sha256.Sum256([]byte("salt" + v))
+		return hex.EncodeToString(h[:])
+	}
+	assert.Equal(t, envelope.Payload{
+		"user": map[string]interface{}{"email": sum("jane@example.com"), "name": "jane"},
+		"ids":  []interface{}{"keep", sum("42")},
+		"a/b":  sum("slash"),
+	}, transformed.Payload)
+	assert.Equal(t, "jane@example.com", e.Payload["user"].(map[string]interface{})["email"])
+}
+
+func TestHashPepper(t *testing.T) {
+	peppered, _ := NewHashStage(config.Transform{Pepper: "pepper", Fields: []config.HashField{{Pointer: "/id"}}})
+	plain, _ := NewHashStage(config.Transform{Fields: []config.HashField{{Pointer: "/id"}}})
+	e := envelope.Envelope{Payload: envelope.Payload{"id": "1"}}
+	a, _ := peppered.Transform(context.Background(), e)
+	b, _ := plain.Transform(context.Background(), e)
+	assert.NotEqual(t, a.Payload["id"], b.Payload["id"])
+	assert.Len(t, a.Payload["id"], 64)
+}
+
+func TestEncryptFields(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	stage, err := NewHashStage(config.Transform{Key: key, Fields: []config.HashField{{Pointer: "/id", Method: ENCRYPT}}})
+	assert.Nil(t, err)
+	transformed, err := stage.Transform(context.Background(), envelope.Envelope{Payload: envelope.Payload{"id": "1"}})
+	assert.Nil(t, err)
+	sealed, err := base64.StdEncoding.DecodeString(transformed.Payload["id"].(string))
+	assert.Nil(t, err)
+	nonce, ciphertext := sealed[:stage.aead.NonceSize()], sealed[stage.aead.NonceSize():]
+	plaintext, err := stage.aead.Open(nil, nonce, ciphertext, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", string(plaintext))
+}
+
+func TestHashInvalidConfig(t *testing.T) {
+	for _, conf := range []config.Transform{
+		{},
+		{Fields: []config.HashField{{Pointer: "id"}}},
+		{Fields: []config.HashField{{Pointer: "/id", Method: "md5"}}},
+		{Fields: []config.HashField{{Pointer: "/id", Method: ENCRYPT}}, Key: "c2hvcnQ="},
+	} {
+		_, err := NewHashStage(conf)
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// Ip modes
+const (
+	KEEP     string = "keep"
+	TRUNCATE string = "truncate" // Zero the last octet of ipv4, or the last 80 bits of ipv6
+	HASH_IP  string = "hash"     // Replace with a salted sha256 hash
+	DROP_IP  string = "drop"     // Remove the header or field
+)
+
+var DEFAULT_IP_HEADERS = []string{"X-Forwarded-For", "X-Real-Ip", "True-Client-Ip", "Cf-Connecting-Ip", "Forwarded"}
+var DEFAULT_IP_POINTERS = []string{"/user_ipaddress"}
+
+var (
+	ipv4Mask = net.CIDRMask(24, 32)
+	ipv6Mask = net.CIDRMask(48, 128)
+)
+
+// IpStage anonymizes the ips of envelopes, in ip headers of the http
+// headers context and in payload fields. Valid and invalid envelopes
+// are handled separately, since invalid envelopes are often kept for
+// debugging.
+type IpStage struct {
+	valid    string
+	invalid  string
+	headers  []string
+	pointers [][]string
+	hasher   *HashStage
+}
+
+func ipMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return TRUNCATE, nil
+	case KEEP, TRUNCATE, HASH_IP, DROP_IP:
+		return mode, nil
+	}
+	return "", errors.New("unsupported ip mode: " + mode)
+}
+
+func NewIpStage(conf config.Transform) (*IpStage, error) {
+	valid, err := ipMode(conf.Valid)
+	if err != nil {
+		return nil, err
+	}
+	invalid, err := ipMode(conf.Invalid)
+	if err != nil {
+		return nil, err
+	}
+	s := &IpStage{
+		valid:   valid,
+		invalid: invalid,
+		headers: conf.Headers,
+		hasher:  &HashStage{salt: conf.Salt, pepper: []byte(conf.Pepper)},
+	}
+	if len(s.headers) == 0 {
+		s.headers = DEFAULT_IP_HEADERS
+	}
+	pointers := conf.Pointers
+	if len(pointers) == 0 {
+		pointers = DEFAULT_IP_POINTERS
+	}
+	for _, p := range pointers {
+		pointer, err := parsePointer(p)
+		if err != nil {
+			return nil, err
+		}
+		s.pointers = append(s.pointers, pointer)
+	}
+	return s, nil
+}
+
+// truncate zeroes the host bits of an ip, returning false if it isn't one
+func truncate(value string) (string, bool) {
+	ip := net.ParseIP(strings.TrimSpace(value))
+	if ip == nil {
+		return "", false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(ipv4Mask).String(), true
+	}
+	return ip.Mask(ipv6Mask).String(), true
+}
+
+// anonymize anonymizes each ip of a comma separated list, such as
+// X-Forwarded-For. Values that aren't ips can't be truncated, so
+// they are dropped.
+func (s *IpStage) anonymize(mode string, value string) (string, bool) {
+	var anonymized []string
+	for _, ip := range strings.Split(value, ",") {
+		switch mode {
+		case HASH_IP:
+			anonymized = append(anonymized, s.hasher.hash(strings.TrimSpace(ip)))
+		case TRUNCATE:
+			if truncated, ok := truncate(ip); ok {
+				anonymized = append(anonymized, truncated)
+			}
+		}
+	}
+	if len(anonymized) == 0 {
+		return "", false
+	}
+	return strings.Join(anonymized, ", "), true
+}
+
+// anonymizeValue anonymizes string or list header values
+func (s *IpStage) anonymizeValue(mode string, v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case string:
+		return s.anonymize(mode, v)
+	case []string:
+		return s.anonymizeValue(mode, toInterfaces(v))
+	case []interface{}:
+		var anonymized []interface{}
+		for _, item := range v {
+			if item, ok := s.anonymizeValue(mode, item); ok {
+				anonymized = append(anonymized, item)
+			}
+		}
+		return anonymized, len(anonymized) > 0
+	}
+	return nil, false
+}
+
+func toInterfaces(values []string) []interface{} {
+	items := make([]interface{}, len(values))
+	for i, v := range values {
+		items[i] = v
+	}
+	return items
+}
+
+func (s *IpStage) anonymizeHeaders(mode string, contexts envelope.Contexts) envelope.Contexts {
+	headers, ok := contexts[envelope.HTTP_HEADERS_CONTEXT].(map[string]interface{})
+	if !ok {
+		return contexts
+	}
+	anonymized := make(map[string]interface{}, len(headers))
+	for k, v := range headers {
+		anonymized[k] = v
+	}
+	for _, h := range s.headers {
+		for k, v := range headers {
+			if !strings.EqualFold(k, h) {
+				continue
+			}
+			delete(anonymized, k)
+			if v, ok := s.anonymizeValue(mode, v); ok && mode != DROP_IP {
+				anonymized[k] = v
+			}
+		}
+	}
+	c := make(envelope.Contexts, len(contexts))
+	for k, v := range contexts {
+		c[k] = v
+	}
+	c[envelope.HTTP_HEADERS_CONTEXT] = anonymized
+	return c
+}
+
+// anonymizeClient anonymizes the client ip the envelope was collected from
+func (s *IpStage) anonymizeClient(mode string, contexts envelope.Contexts) envelope.Contexts {
+	client, ok := contexts[envelope.CLIENT_CONTEXT].(map[string]interface{})
+	if !ok {
+		return contexts
+	}
+	anonymized := make(map[string]interface{}, len(client))
+	for k, v := range client {
+		anonymized[k] = v
+	}
+	delete(anonymized, "ip")
+	if ip, ok := client["ip"].(string); ok && mode != DROP_IP {
+		if ip, ok := s.anonymize(mode, ip); ok {
+			anonymized["ip"] = ip
+		}
+	}
+	c := make(envelope.Contexts, len(contexts))
+	for k, v := range contexts {
+		c[k] = v
+	}
+	c[envelope.CLIENT_CONTEXT] = anonymized
+	return c
+}
+
+// remove returns a copy of the value with the field at the pointer removed
+func remove(v interface{}, pointer []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		child, ok := v[pointer[0]]
+		if !ok {
+			return v
+		}
+		c := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			c[k] = item
+		}
+		if len(pointer) == 1 {
+			delete(c, pointer[0])
+		} else {
+			c[pointer[0]] = remove(child, pointer[1:])
+		}
+		return c
+	case []interface{}:
+		i, err := strconv.Atoi(pointer[0])
+		if err != nil || i < 0 || i >= len(v) {
+			return v
+		}
+		c := append([]interface{}{}, v...)
+		if len(pointer) == 1 {
+			return append(c[:i], c[i+1:]...)
+		}
+		c[i] = remove(c[i], pointer[1:])
+		return c
+	}
+	return v
+}
+
+func (s *IpStage) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	mode := s.valid
+	if !e.IsValid {
+		mode = s.invalid
+	}
+	if mode == KEEP {
+		return e, nil
+	}
+	if e.Contexts != nil {
+		contexts := s.anonymizeClient(mode, s.anonymizeHeaders(mode, *e.Contexts))
+		e.Contexts = &contexts
+	}
+	var payload interface{} = map[string]interface{}(e.Payload)
+	for _, pointer := range s.pointers {
+		if mode == DROP_IP {
+			payload = remove(payload, pointer)
+			continue
+		}
+		var dropped bool
+		payload, _ = replace(payload, pointer, func(v interface{}) (string, error) {
+			anonymized, ok := s.anonymize(mode, stringify(v))
+			dropped = !ok
+			return anonymized, nil
+		})
+		if dropped {
+			payload = remove(payload, pointer)
+		}
+	}
+	e.Payload = payload.(map[string]interface{})
+	return e, nil
+}
+
+func (s *IpStage) Close() error {
+	return nil
+}
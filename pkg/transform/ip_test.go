@@ -0,0 +1,76 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func ipEnvelope(valid bool) envelope.Envelope {
+	contexts := envelope.Contexts{
+		envelope.HTTP_HEADERS_CONTEXT: map[string]interface{}{
+			"X-Forwarded-For": "203.0.113.195, 2001:db8:85a3:1234::8a2e:370:7334",
+			"X-Real-Ip":       []string{"198.51.100.7"},
+			"User-Agent":      "curl",
+		},
+		envelope.CLIENT_CONTEXT: map[string]interface{}{"ip": "198.51.100.7"},
+	}
+	return envelope.Envelope{
+		IsValid:  valid,
+		Contexts: &contexts,
+		Payload:  envelope.Payload{"user_ipaddress": "198.51.100.7", "page": "/"},
+	}
+}
+
+func headers(e envelope.Envelope) map[string]interface{} {
+	return (*e.Contexts)[envelope.HTTP_HEADERS_CONTEXT].(map[string]interface{})
+}
+
+func TestIpModes(t *testing.T) {
+	stage, err := NewIpStage(config.Transform{Valid: TRUNCATE, Invalid: DROP_IP})
+	assert.Nil(t, err)
+
+	valid := ipEnvelope(true)
+	transformed, err := stage.Transform(context.Background(), valid)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"X-Forwarded-For": "203.0.113.0, 2001:db8:85a3::",
+		"X-Real-Ip":       []interface{}{"198.51.100.0"},
+		"User-Agent":      "curl",
+	}, headers(transformed))
+	assert.Equal(t, envelope.Payload{"user_ipaddress": "198.51.100.0", "page": "/"}, transformed.Payload)
+	assert.Equal(t, "198.51.100.0", transformed.ClientIp())
+	assert.Equal(t, ipEnvelope(true).Payload, valid.Payload)
+	assert.Equal(t, headers(ipEnvelope(true)), headers(valid))
+
+	transformed, err = stage.Transform(context.Background(), ipEnvelope(false))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"User-Agent": "curl"}, headers(transformed))
+	assert.Equal(t, envelope.Payload{"page": "/"}, transformed.Payload)
+	assert.Equal(t, "", transformed.ClientIp())
+}
+
+func TestIpHashAndKeep(t *testing.T) {
+	stage, err := NewIpStage(config.Transform{Valid: HASH_IP, Invalid: KEEP, Salt: "salt"})
+	assert.Nil(t, err)
+	transformed, _ := stage.Transform(context.Background(), ipEnvelope(true))
+	assert.Equal(t, stage.hasher.hash("198.51.100.7"), transformed.Payload["user_ipaddress"])
+	assert.Equal(t, []interface{}{stage.hasher.hash("198.51.100.7")}, headers(transformed)["X-Real-Ip"])
+	transformed, _ = stage.Transform(context.Background(), ipEnvelope(false))
+	assert.Equal(t, ipEnvelope(false), transformed)
+}
+
+func TestIpTruncateDropsNonIps(t *testing.T) {
+	stage, _ := NewIpStage(config.Transform{Pointers: []string{"/ip"}})
+	transformed, _ := stage.Transform(context.Background(), envelope.Envelope{IsValid: true, Payload: envelope.Payload{"ip": "unknown"}})
+	assert.Equal(t, envelope.Payload{}, transformed.Payload)
+	_, err := NewIpStage(config.Transform{Valid: "scramble"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const LUA_TRANSFORM_FUNCTION string = "transform"
+
+// LuaStage runs envelopes through a lua script, which defines a global
+// `transform` function. The function is passed the envelope as a table,
+// and returns the transformed table, or nil to drop the envelope:
+//
+//	function transform(event)
+//	  event.payload.userId = event.payload.user_id
+//	  event.payload.user_id = nil
+//	  return event
+//	end
+//
+// Scripts can use the base, table, string, and math libraries, but can't
+// load files or modules.
+type LuaStage struct {
+	proto  *lua.FunctionProto
+	states sync.Pool
+}
+
+func NewLuaStage(conf config.Transform) (*LuaStage, error) {
+	script, name := conf.Script, conf.Name
+	if script == "" {
+		contents, err := os.ReadFile(conf.Path)
+		if err != nil {
+			return nil, err
+		}
+		script, name = string(contents), conf.Path
+	}
+	chunk, err := parse.Parse(strings.NewReader(script), name)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, err
+	}
+	s := &LuaStage{proto: proto}
+	// Fail fast if the script doesn't run or define the function
+	L, err := s.newState()
+	if err != nil {
+		return nil, err
+	}
+	s.states.Put(L)
+	return s, nil
+}
+
+func (s *LuaStage) newState() (*lua.LState, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, unsafe := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(unsafe, lua.LNil)
+	}
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+		L.Close()
+		return nil, err
+	}
+	if _, ok := L.GetGlobal(LUA_TRANSFORM_FUNCTION).(*lua.LFunction); !ok {
+		L.Close()
+		return nil, errors.New("lua script does not define a transform function")
+	}
+	return L, nil
+}
+
+// state returns an idle lua state, since states can't be used concurrently
+func (s *LuaStage) state() (*lua.LState, error) {
+	if L, ok := s.states.Get().(*lua.LState); ok {
+		return L, nil
+	}
+	return s.newState()
+}
+
+func (s *LuaStage) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	input, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+	var event interface{}
+	if err := json.Unmarshal(input, &event); err != nil {
+		return e, err
+	}
+	L, err := s.state()
+	if err != nil {
+		return e, err
+	}
+	L.SetContext(ctx)
+	err = L.CallByParam(lua.P{
+		Fn:      L.GetGlobal(LUA_TRANSFORM_FUNCTION),
+		NRet:    1,
+		Protect: true,
+	}, toLua(L, event))
+	if err != nil {
+		// The state may be left mid-call
+		L.Close()
+		return e, err
+	}
+	result := L.Get(-1)
+	L.Pop(1)
+	L.RemoveContext()
+	s.states.Put(L)
+	if result == lua.LNil {
+		return e, ErrDrop
+	}
+	if _, ok := result.(*lua.LTable); !ok {
+		return e, fmt.Errorf("lua transform returned a %s, not a table", result.Type())
+	}
+	output, err := json.Marshal(fromLua(result))
+	if err != nil {
+		return e, err
+	}
+	var transformed envelope.Envelope
+	if err := json.Unmarshal(output, &transformed); err != nil {
+		return e, fmt.Errorf("lua transform returned an invalid envelope: %w", err)
+	}
+	return transformed, nil
+}
+
+func (s *LuaStage) Close() error {
+	return nil
+}
+
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []interface{}:
+		t := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			t.Append(toLua(L, item))
+		}
+		return t
+	case map[string]interface{}:
+		t := L.CreateTable(0, len(v))
+		for k, item := range v {
+			t.RawSetString(k, toLua(L, item))
+		}
+		return t
+	}
+	return lua.LNil
+}
+
+// fromLua converts lua values to json values. Tables with only
+// sequential integer keys are converted to arrays.
+func fromLua(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LString:
+		return string(v)
+	case *lua.LTable:
+		if n := v.MaxN(); n > 0 && n == v.Len() {
+			arr := make([]interface{}, 0, n)
+			v.ForEach(func(k lua.LValue, item lua.LValue) {
+				arr = append(arr, fromLua(item))
+			})
+			if len(arr) == n {
+				return arr
+			}
+		}
+		obj := make(map[string]interface{})
+		v.ForEach(func(k lua.LValue, item lua.LValue) {
+			obj[k.String()] = fromLua(item)
+		})
+		return obj
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func luaPipeline(t *testing.T, transform config.Transform) *Pipeline {
+	transform.Type = LUA
+	p, err := BuildPipeline([]config.Transform{transform})
+	assert.Nil(t, err)
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestLuaTransform(t *testing.T) {
+	p := luaPipeline(t, config.Transform{Name: "rename", Script: `
+function transform(event)
+  event.payload.page = event.payload.path
+  event.payload.path = nil
+  event.payload.depth = tonumber(event.payload.depth)
+  event.payload.tags = {"a", "b"}
+  event.schema = string.gsub(event.schema, "v1", "v2")
+  return event
+end`})
+	e := testEnvelope()
+	e.Payload["depth"] = "3"
+	transformed := p.Run([]envelope.Envelope{e, testEnvelope()})
+	assert.Len(t, transformed, 2)
+	assert.Equal(t, "com.acme/page/v2.0.json", transformed[0].Schema)
+	assert.Equal(t, envelope.Payload{"page": "/", "depth": float64(3), "tags": []interface{}{"a", "b"}}, transformed[0].Payload)
+	assert.True(t, transformed[0].IsValid)
+}
+
+func TestLuaDrop(t *testing.T) {
+	p := luaPipeline(t, config.Transform{Name: "drop", OnError: INVALID, Script: `
+function transform(event)
+  if event.payload.path == "/" then return nil end
+  return event
+end`})
+	kept := testEnvelope()
+	kept.Payload["path"] = "/home"
+	transformed := p.Run([]envelope.Envelope{testEnvelope(), kept})
+	assert.Len(t, transformed, 1)
+	assert.Equal(t, "/home", transformed[0].Payload["path"])
+}
+
+func TestLuaProtocols(t *testing.T) {
+	p := luaPipeline(t, config.Transform{Name: "drop", Protocols: []string{"pixel"}, Script: `function transform(event) return nil end`})
+	pixel := testEnvelope()
+	pixel.Protocol = "pixel"
+	assert.Len(t, p.Run([]envelope.Envelope{testEnvelope(), pixel}), 1)
+}
+
+func TestLuaErrors(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		script string
+	}{
+		{"error", `function transform(event) error("boom") end`},
+		{"notTable", `function transform(event) return 1 end`},
+		{"loop", `function transform(event) while true do end end`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := luaPipeline(t, config.Transform{Name: "failing", OnError: INVALID, TimeoutMs: 20, Script: tc.script})
+			start := time.Now()
+			transformed := p.Run([]envelope.Envelope{testEnvelope()})
+			assert.Less(t, time.Since(start), time.Second)
+			assert.Len(t, transformed, 1)
+			assert.False(t, transformed[0].IsValid)
+			assert.Equal(t, testEnvelope().Payload, transformed[0].Payload)
+		})
+	}
+}
+
+func TestLuaScriptMustDefineTransform(t *testing.T) {
+	for _, script := range []string{`function transform(`, `local x = 1`, `dofile("/etc/passwd")`} {
+		_, err := NewLuaStage(config.Transform{Script: script})
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+// Detector types
+const (
+	REGEX       string = "regex"
+	FIELDS      string = "fields"
+	EMAIL       string = "email"
+	PHONE       string = "phone"
+	CREDIT_CARD string = "creditCard"
+)
+
+// Pii actions
+const (
+	MASK   string = "mask"   // Replace matched characters with MASK_CHARACTER
+	REDACT string = "redact" // Replace the whole value with REDACTED
+	REMOVE string = "drop"   // Remove the field
+)
+
+const (
+	MASK_CHARACTER string = "*"
+	REDACTED       string = "[REDACTED]"
+)
+
+var builtinPatterns = map[string]*regexp.Regexp{
+	EMAIL:       regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	PHONE:       regexp.MustCompile(`(?:\+\d{1,3}[\s.\-]?)?\(?\d{3}\)?[\s.\-]?\d{3}[\s.\-]?\d{4}\b`),
+	CREDIT_CARD: regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+}
+
+var detections = stats.NewSchemaStats()
+
+// Detections returns the number of pii detections by schema and detector
+func Detections() map[string]map[string]int64 {
+	return detections.Snapshot()
+}
+
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	fields  map[string]bool
+	action  string
+	// valid filters pattern matches, to avoid false positives
+	valid func(match string) bool
+}
+
+// luhn returns true if the digits of the match pass the luhn checksum
+func luhn(match string) bool {
+	sum, double := 0, false
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// PiiStage scans payload fields with each detector, and masks, redacts,
+// or removes the fields they match. Detections are counted by schema.
+type PiiStage struct {
+	detectors []detector
+}
+
+func validAction(action string) bool {
+	switch action {
+	case MASK, REDACT, REMOVE:
+		return true
+	}
+	return false
+}
+
+func NewPiiStage(conf config.Transform) (*PiiStage, error) {
+	action := conf.Action
+	if action == "" {
+		action = MASK
+	}
+	if !validAction(action) {
+		return nil, errors.New("unsupported pii action: " + action)
+	}
+	if len(conf.Detectors) == 0 {
+		return nil, errors.New("pii transform has no detectors")
+	}
+	s := &PiiStage{}
+	for _, c := range conf.Detectors {
+		d := detector{name: c.Name, action: c.Action}
+		if d.name == "" {
+			d.name = c.Type
+		}
+		if d.action == "" {
+			d.action = action
+		}
+		if !validAction(d.action) {
+			return nil, errors.New("unsupported pii action: " + d.action)
+		}
+		switch c.Type {
+		case REGEX:
+			pattern, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			d.pattern = pattern
+		case FIELDS:
+			d.fields = make(map[string]bool)
+			for _, f := range c.Fields {
+				d.fields[strings.ToLower(f)] = true
+			}
+		case EMAIL, PHONE:
+			d.pattern = builtinPatterns[c.Type]
+		case CREDIT_CARD:
+			d.pattern, d.valid = builtinPatterns[c.Type], luhn
+		default:
+			return nil, errors.New("unsupported pii detector type: " + c.Type)
+		}
+		s.detectors = append(s.detectors, d)
+	}
+	return s, nil
+}
+
+// scan returns the value with detections applied, and false if the
+// field should be removed. Maps and slices are copied rather than
+// modified, so the original envelope is left untouched.
+func (s *PiiStage) scan(key string, v interface{}, counts map[string]int64) (interface{}, bool) {
+	for _, d := range s.detectors {
+		if d.fields == nil || !d.fields[strings.ToLower(key)] {
+			continue
+		}
+		counts[d.name]++
+		switch d.action {
+		case REMOVE:
+			return nil, false
+		case REDACT:
+			return REDACTED, true
+		}
+		if str, ok := v.(string); ok {
+			return strings.Repeat(MASK_CHARACTER, len(str)), true
+		}
+		return REDACTED, true
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return s.scanMap(v, counts), true
+	case envelope.Payload:
+		return s.scanMap(v, counts), true
+	case []interface{}:
+		scanned := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if item, keep := s.scan(key, item, counts); keep {
+				scanned = append(scanned, item)
+			}
+		}
+		return scanned, true
+	case string:
+		return s.scanString(v, counts)
+	}
+	return v, true
+}
+
+func (s *PiiStage) scanMap(m map[string]interface{}, counts map[string]int64) map[string]interface{} {
+	scanned := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if v, keep := s.scan(k, v, counts); keep {
+			scanned[k] = v
+		}
+	}
+	return scanned
+}
+
+func (s *PiiStage) scanString(str string, counts map[string]int64) (interface{}, bool) {
+	for _, d := range s.detectors {
+		if d.pattern == nil {
+			continue
+		}
+		detected := false
+		masked := d.pattern.ReplaceAllStringFunc(str, func(match string) string {
+			if d.valid != nil && !d.valid(match) {
+				return match
+			}
+			detected = true
+			return strings.Repeat(MASK_CHARACTER, len(match))
+		})
+		if !detected {
+			continue
+		}
+		counts[d.name]++
+		switch d.action {
+		case REMOVE:
+			return nil, false
+		case REDACT:
+			return REDACTED, true
+		}
+		str = masked
+	}
+	return str, true
+}
+
+func (s *PiiStage) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	counts := make(map[string]int64)
+	payload := s.scanMap(e.Payload, counts)
+	for name, count := range counts {
+		detections.Increment(e.Schema, name, count)
+	}
+	e.Payload = payload
+	return e, nil
+}
+
+func (s *PiiStage) Close() error {
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func piiEnvelope() envelope.Envelope {
+	return envelope.Envelope{
+		Schema: "com.acme/signup/v1.0.json",
+		Payload: envelope.Payload{
+			"email":    "jane@example.com",
+			"note":     "call +1 555-123-4567 or card 4111 1111 1111 1111",
+			"order":    "1234 5678 9012 3456",
+			"password": "hunter2",
+			"profile":  map[string]interface{}{"Password": "hunter2", "ssn": "123-45-6789"},
+			"tags":     []interface{}{"a@b.io", "plain"},
+			"count":    float64(3),
+		},
+	}
+}
+
+func TestPiiActions(t *testing.T) {
+	stage, err := NewPiiStage(config.Transform{
+		Action: REDACT,
+		Detectors: []config.PiiDetector{
+			{Type: EMAIL, Action: MASK},
+			{Type: PHONE, Action: MASK},
+			{Type: CREDIT_CARD, Action: MASK},
+			{Name: "ssn", Type: REGEX, Pattern: `\d{3}-\d{2}-\d{4}`},
+			{Name: "secrets", Type: FIELDS, Fields: []string{"password"}, Action: REMOVE},
+		},
+	})
+	assert.Nil(t, err)
+	e := piiEnvelope()
+	transformed, err := stage.Transform(context.Background(), e)
+	assert.Nil(t, err)
+	assert.Equal(t, envelope.Payload{
+		"email":   "****************",
+		"note":    "call *************** or card *******************",
+		"order":   "1234 5678 9012 3456", // Fails the luhn check
+		"profile": map[string]interface{}{"ssn": REDACTED},
+		"tags":    []interface{}{"******", "plain"},
+		"count":   float64(3),
+	}, transformed.Payload)
+	assert.Equal(t, piiEnvelope().Payload, e.Payload)
+	counts := Detections()[e.Schema]
+	assert.Equal(t, int64(2), counts[EMAIL])
+	assert.Equal(t, int64(2), counts["secrets"])
+	assert.Equal(t, int64(1), counts["ssn"])
+}
+
+func TestPiiInvalidConfig(t *testing.T) {
+	for _, conf := range []config.Transform{
+		{},
+		{Action: "hash", Detectors: []config.PiiDetector{{Type: EMAIL}}},
+		{Detectors: []config.PiiDetector{{Type: "ssn"}}},
+		{Detectors: []config.PiiDetector{{Type: REGEX, Pattern: "("}}},
+	} {
+		_, err := NewPiiStage(conf)
+		assert.NotNil(t, err)
+	}
+}
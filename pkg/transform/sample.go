@@ -0,0 +1,94 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+var sampledOut = stats.NewSchemaStats()
+
+// SampledOut returns the number of envelopes dropped by sampling, by
+// schema and transform
+func SampledOut() map[string]map[string]int64 {
+	return sampledOut.Snapshot()
+}
+
+// SampleStage keeps a fraction of the envelopes of each schema. Envelopes
+// are sampled by a hash of a field, such as the device id, so the same
+// user is consistently kept or dropped. Envelopes without the field are
+// sampled by their uuid.
+type SampleStage struct {
+	name   string
+	source *source
+	salt   string
+	rates  []config.SampleRate
+}
+
+func NewSampleStage(conf config.Transform) (*SampleStage, error) {
+	if len(conf.Rates) == 0 {
+		return nil, errors.New("sample transform has no rates")
+	}
+	for _, r := range conf.Rates {
+		if r.Rate < 0 || r.Rate > 1 {
+			return nil, fmt.Errorf("sample rate of %s is not between 0 and 1", r.Schema)
+		}
+	}
+	src, err := newSource(conf, nil, DEFAULT_SESSION_POINTERS)
+	if err != nil {
+		return nil, err
+	}
+	return &SampleStage{name: conf.Name, source: src, salt: conf.Salt, rates: conf.Rates}, nil
+}
+
+// rate returns the rate of the longest matching schema prefix
+func (s *SampleStage) rate(schema string) float64 {
+	rate, matched := 1.0, -1
+	for _, r := range s.rates {
+		if strings.HasPrefix(schema, r.Schema) && len(r.Schema) > matched {
+			rate, matched = r.Rate, len(r.Schema)
+		}
+	}
+	return rate
+}
+
+// keep returns true if the hash of the key falls within the rate
+func keep(key string, rate float64) bool {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(h.Sum64()) < rate*math.MaxUint64
+}
+
+func (s *SampleStage) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	rate := s.rate(e.Schema)
+	if rate >= 1 {
+		return e, nil
+	}
+	key := e.Uuid.String()
+	for _, v := range s.source.values(&e) {
+		if str, ok := v.(string); ok && str != "" {
+			key = str
+			break
+		}
+	}
+	if keep(s.salt+key, rate) {
+		return e, nil
+	}
+	sampledOut.Increment(e.Schema, s.name, 1)
+	return e, ErrDrop
+}
+
+func (s *SampleStage) Close() error {
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampling(t *testing.T) {
+	p, err := BuildPipeline([]config.Transform{{
+		Name: "heartbeats",
+		Type: SAMPLE,
+		Rates: []config.SampleRate{
+			{Schema: "com.acme/", Rate: 0},
+			{Schema: "com.acme/heartbeat/", Rate: 0.1},
+		},
+	}})
+	assert.Nil(t, err)
+	var envelopes []envelope.Envelope
+	for i := 0; i < 10000; i++ {
+		envelopes = append(envelopes, envelope.Envelope{
+			Uuid:    uuid.New(),
+			Schema:  "com.acme/heartbeat/v1.0.json",
+			Payload: envelope.Payload{"domain_userid": fmt.Sprint(i)},
+		})
+	}
+	kept := p.Run(envelopes)
+	assert.InDelta(t, 1000, len(kept), 150)
+	// Users are consistently sampled
+	assert.Equal(t, kept, p.Run(kept))
+
+	other := envelope.Envelope{Uuid: uuid.New(), Schema: "com.acme/page/v1.0.json", Payload: envelope.Payload{}}
+	unsampled := envelope.Envelope{Uuid: uuid.New(), Schema: "io.silverton/page/v1.0.json", Payload: envelope.Payload{}}
+	assert.Equal(t, []envelope.Envelope{unsampled}, p.Run([]envelope.Envelope{other, unsampled}))
+
+	counts := SampledOut()
+	assert.Equal(t, int64(10000-len(kept)), counts["com.acme/heartbeat/v1.0.json"]["heartbeats"])
+	assert.Equal(t, int64(1), counts["com.acme/page/v1.0.json"]["heartbeats"])
+}
+
+func TestSampleRatesMustBeFractions(t *testing.T) {
+	_, err := NewSampleStage(config.Transform{Rates: []config.SampleRate{{Schema: "com.acme/", Rate: 2}}})
+	assert.NotNil(t, err)
+	_, err = NewSampleStage(config.Transform{})
+	assert.NotNil(t, err)
+}
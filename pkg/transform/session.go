@@ -0,0 +1,231 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const SESSION_CONTEXT string = "io.silverton/buz/internal/contexts/session/v1.0.json"
+
+// Session stores
+const (
+	MEMORY string = "memory"
+	REDIS  string = "redis"
+)
+
+const (
+	DEFAULT_INACTIVITY_TIMEOUT_SEC int           = 1800
+	SESSION_RETENTION              time.Duration = 30 * 24 * time.Hour // Devices are forgotten, and their session index reset, after this long
+	SESSION_KEY_PREFIX             string        = "buz:session:"
+)
+
+var DEFAULT_SESSION_POINTERS = []string{"/domain_userid", "/network_userid"}
+
+type session struct {
+	id    string
+	index int64
+	first bool
+}
+
+// sessionStore tracks the current session of each device. Touching a
+// device starts a new session, with the given id, if the device has
+// been inactive for longer than the timeout.
+type sessionStore interface {
+	touch(ctx context.Context, device string, now time.Time, timeout time.Duration, id string) (session, error)
+	close() error
+}
+
+type sessionState struct {
+	id       string
+	index    int64
+	lastSeen time.Time
+}
+
+type memorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]*sessionState
+	lastSweep time.Time
+}
+
+func (s *memorySessionStore) touch(ctx context.Context, device string, now time.Time, timeout time.Duration, id string) (session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Forgotten devices are swept at most once per timeout
+	if now.Sub(s.lastSweep) > timeout {
+		for d, state := range s.sessions {
+			if now.Sub(state.lastSeen) > SESSION_RETENTION {
+				delete(s.sessions, d)
+			}
+		}
+		s.lastSweep = now
+	}
+	state, ok := s.sessions[device]
+	if ok && now.Sub(state.lastSeen) <= timeout {
+		if now.After(state.lastSeen) {
+			state.lastSeen = now
+		}
+		return session{id: state.id, index: state.index}, nil
+	}
+	if !ok || now.Sub(state.lastSeen) > SESSION_RETENTION {
+		state = &sessionState{}
+		s.sessions[device] = state
+	}
+	state.id, state.lastSeen = id, now
+	state.index++
+	return session{id: state.id, index: state.index, first: true}, nil
+}
+
+func (s *memorySessionStore) close() error {
+	return nil
+}
+
+// The session is read and updated atomically, so concurrent instances
+// don't start duplicate sessions
+var touchScript = redis.NewScript(`
+local state = redis.call("HMGET", KEYS[1], "id", "index", "lastSeen")
+local now, timeout = tonumber(ARGV[1]), tonumber(ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+if state[1] and now - tonumber(state[3]) <= timeout then
+  if now > tonumber(state[3]) then
+    redis.call("HSET", KEYS[1], "lastSeen", now)
+  end
+  return {state[1], tonumber(state[2]), 0}
+end
+local index = (tonumber(state[2]) or 0) + 1
+redis.call("HSET", KEYS[1], "id", ARGV[3], "index", index, "lastSeen", now)
+return {ARGV[3], index, 1}
+`)
+
+type redisSessionStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func (s *redisSessionStore) touch(ctx context.Context, device string, now time.Time, timeout time.Duration, id string) (session, error) {
+	result, err := touchScript.Run(ctx, s.client, []string{s.prefix + SESSION_KEY_PREFIX + device},
+		now.UnixMilli(), timeout.Milliseconds(), id, SESSION_RETENTION.Milliseconds()).Slice()
+	if err != nil {
+		return session{}, err
+	}
+	if len(result) != 3 {
+		return session{}, errors.New("unexpected session script result")
+	}
+	sessionId, _ := result[0].(string)
+	index, _ := result[1].(int64)
+	first, _ := result[2].(int64)
+	return session{id: sessionId, index: index, first: first == 1}, nil
+}
+
+func (s *redisSessionStore) close() error {
+	return s.client.Close()
+}
+
+func newRedisClient(conf config.Redis) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     conf.Addr,
+		Username: conf.Username,
+		Password: conf.Password,
+		DB:       conf.Db,
+	})
+}
+
+// SessionEnricher stitches envelopes into server-side sessions by device
+// id, starting a new session after a period of inactivity. It attaches a
+// context with the session id, the session index of the device, and
+// whether the envelope is the first of its session.
+type SessionEnricher struct {
+	source  *source
+	cookie  string
+	timeout time.Duration
+	store   sessionStore
+	now     func() time.Time
+}
+
+func NewSessionEnricher(conf config.Transform) (*SessionEnricher, error) {
+	src, err := newSource(conf, nil, DEFAULT_SESSION_POINTERS)
+	if err != nil {
+		return nil, err
+	}
+	timeout := conf.InactivityTimeoutSeconds
+	if timeout <= 0 {
+		timeout = DEFAULT_INACTIVITY_TIMEOUT_SEC
+	}
+	s := &SessionEnricher{
+		source:  src,
+		cookie:  conf.Cookie,
+		timeout: time.Duration(timeout) * time.Second,
+		now:     time.Now,
+	}
+	switch conf.Store {
+	case MEMORY, "":
+		s.store = &memorySessionStore{sessions: make(map[string]*sessionState)}
+	case REDIS:
+		client := newRedisClient(conf.Redis)
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			client.Close()
+			return nil, err
+		}
+		s.store = &redisSessionStore{client: client, prefix: conf.Redis.Prefix}
+	default:
+		return nil, errors.New("unsupported session store: " + conf.Store)
+	}
+	return s, nil
+}
+
+// device returns the device id of the envelope, from payload fields or
+// the cookie
+func (s *SessionEnricher) device(e *envelope.Envelope) string {
+	for _, v := range s.source.values(e) {
+		if device, ok := v.(string); ok && device != "" {
+			return device
+		}
+	}
+	if s.cookie == "" || e.Contexts == nil {
+		return ""
+	}
+	headers, _ := (*e.Contexts)[envelope.HTTP_HEADERS_CONTEXT].(map[string]interface{})
+	for k, v := range headers {
+		header, ok := v.(string)
+		if !ok || http.CanonicalHeaderKey(k) != "Cookie" {
+			continue
+		}
+		r := http.Request{Header: http.Header{"Cookie": {header}}}
+		if c, err := r.Cookie(s.cookie); err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func (s *SessionEnricher) Enrich(ctx context.Context, e *envelope.Envelope) error {
+	device := s.device(e)
+	if device == "" {
+		return nil
+	}
+	sess, err := s.store.touch(ctx, device, s.now(), s.timeout, uuid.New().String())
+	if err != nil {
+		return err
+	}
+	setContext(e, SESSION_CONTEXT, map[string]interface{}{
+		"sessionId":    sess.id,
+		"sessionIndex": sess.index,
+		"firstEvent":   sess.first,
+	})
+	return nil
+}
+
+func (s *SessionEnricher) Close() error {
+	return s.store.close()
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func sessionOf(t *testing.T, s *SessionEnricher, e envelope.Envelope) map[string]interface{} {
+	assert.Nil(t, s.Enrich(context.Background(), &e))
+	if e.Contexts == nil {
+		return nil
+	}
+	return (*e.Contexts)[SESSION_CONTEXT].(map[string]interface{})
+}
+
+func TestSessions(t *testing.T) {
+	mr := miniredis.RunT(t)
+	for _, store := range []string{MEMORY, REDIS} {
+		t.Run(store, func(t *testing.T) {
+			s, err := NewSessionEnricher(config.Transform{
+				Store:                    store,
+				Redis:                    config.Redis{Addr: mr.Addr()},
+				Cookie:                   "nuid",
+				InactivityTimeoutSeconds: 60,
+			})
+			assert.Nil(t, err)
+			defer s.Close()
+			now := time.Unix(1700000000, 0)
+			s.now = func() time.Time { return now }
+			device := envelope.Envelope{Payload: envelope.Payload{"domain_userid": store}}
+
+			first := sessionOf(t, s, device)
+			assert.Equal(t, int64(1), first["sessionIndex"])
+			assert.Equal(t, true, first["firstEvent"])
+
+			now = now.Add(59 * time.Second)
+			second := sessionOf(t, s, device)
+			assert.Equal(t, first["sessionId"], second["sessionId"])
+			assert.Equal(t, false, second["firstEvent"])
+
+			// Inactivity is measured from the last event
+			now = now.Add(59 * time.Second)
+			assert.Equal(t, first["sessionId"], sessionOf(t, s, device)["sessionId"])
+
+			now = now.Add(61 * time.Second)
+			third := sessionOf(t, s, device)
+			assert.NotEqual(t, first["sessionId"], third["sessionId"])
+			assert.Equal(t, int64(2), third["sessionIndex"])
+			assert.Equal(t, true, third["firstEvent"])
+
+			contexts := envelope.Contexts{envelope.HTTP_HEADERS_CONTEXT: map[string]interface{}{"Cookie": "a=b; nuid=" + store + "-cookie"}}
+			cookie := sessionOf(t, s, envelope.Envelope{Contexts: &contexts})
+			assert.Equal(t, int64(1), cookie["sessionIndex"])
+
+			assert.Nil(t, sessionOf(t, s, envelope.Envelope{}))
+		})
+	}
+}
+
+func TestSessionStoreMustBeReachable(t *testing.T) {
+	_, err := NewSessionEnricher(config.Transform{Store: REDIS, Redis: config.Redis{Addr: "127.0.0.1:1"}})
+	assert.NotNil(t, err)
+	_, err = NewSessionEnricher(config.Transform{Store: "memcached"})
+	assert.NotNil(t, err)
+}
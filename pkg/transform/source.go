@@ -0,0 +1,104 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// source reads values from the http headers context and payload fields
+// of envelopes, for enrichments of request properties such as the ip
+type source struct {
+	headers  []string
+	pointers [][]string
+	clientIp bool // Whether the client ip of the envelope comes before the headers
+}
+
+func newSource(conf config.Transform, defaultHeaders []string, defaultPointers []string) (*source, error) {
+	headers, pointers := conf.Headers, conf.Pointers
+	if len(headers) == 0 {
+		headers = defaultHeaders
+	}
+	if len(pointers) == 0 {
+		pointers = defaultPointers
+	}
+	return sourceOf(headers, pointers)
+}
+
+func sourceOf(headers []string, pointers []string) (*source, error) {
+	s := &source{headers: headers}
+	for _, p := range pointers {
+		pointer, err := parsePointer(p)
+		if err != nil {
+			return nil, err
+		}
+		s.pointers = append(s.pointers, pointer)
+	}
+	return s, nil
+}
+
+// values returns the client ip if the source uses it, then the values of
+// the headers, then the payload fields, in the order they are configured.
+// Missing values are omitted.
+func (s *source) values(e *envelope.Envelope) []interface{} {
+	var values []interface{}
+	if ip := e.ClientIp(); s.clientIp && ip != "" {
+		values = append(values, ip)
+	}
+	values = append(values, s.headerValues(e)...)
+	return append(values, s.fieldValues(e)...)
+}
+
+// fieldValues returns the values of the payload fields
+func (s *source) fieldValues(e *envelope.Envelope) []interface{} {
+	var values []interface{}
+	for _, pointer := range s.pointers {
+		if v := resolve(map[string]interface{}(e.Payload), pointer); v != nil {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// headerValues returns the values of the headers, which are shared by
+// every envelope of a request
+func (s *source) headerValues(e *envelope.Envelope) []interface{} {
+	var values []interface{}
+	if e.Contexts != nil {
+		if headers, ok := (*e.Contexts)[envelope.HTTP_HEADERS_CONTEXT].(map[string]interface{}); ok {
+			for _, h := range s.headers {
+				for k, v := range headers {
+					if strings.EqualFold(k, h) {
+						values = append(values, v)
+					}
+				}
+			}
+		}
+	}
+	return values
+}
+
+// resolve returns the value at the pointer, or nil if there isn't one
+func resolve(v interface{}, pointer []string) interface{} {
+	for _, token := range pointer {
+		switch current := v.(type) {
+		case map[string]interface{}:
+			v = current[token]
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(current) {
+				return nil
+			}
+			v = current[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
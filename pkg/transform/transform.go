@@ -0,0 +1,321 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/validator"
+)
+
+// Transform types
+const (
+	WASM        string = "wasm"
+	CEL         string = "cel"
+	LUA         string = "lua"
+	PII         string = "pii"
+	HASH        string = "hash"
+	IP          string = "ip"
+	GEO         string = "geoip"
+	UA          string = "useragent"
+	ATTRIBUTION string = "attribution"
+	SESSION     string = "session"
+	BOT         string = "bot"
+	DEDUP       string = "dedup"
+	SAMPLE      string = "sample"
+	CONSENT     string = "consent"
+)
+
+// Error policies
+const (
+	PASS    string = "pass"    // Deliver the envelope as it was before the failing stage
+	DROP    string = "drop"    // Drop the envelope
+	INVALID string = "invalid" // Deliver the envelope to invalid sinks, with the error attached
+)
+
+const DEFAULT_TIMEOUT_MS int = 100
+
+// ErrDrop is returned by stages to drop the envelope
+var ErrDrop = errors.New("drop envelope")
+
+// Stage transforms a single envelope. Stages must not modify the
+// envelope they are passed if they return an error.
+type Stage interface {
+	Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error)
+	Close() error
+}
+
+// Releaser is a stage which claims envelopes as they pass through it, such
+// as dedup. Release undoes the claim of an envelope which wasn't accepted,
+// and is given the envelope and context it was transformed with.
+type Releaser interface {
+	Release(ctx context.Context, e envelope.Envelope) error
+}
+
+type stage struct {
+	Stage
+	name      string
+	schemas   []string
+	protocols []string
+	timeout   time.Duration
+	onError   string
+}
+
+// transform runs the stage, failing the envelope rather than the request
+// if the stage panics on it
+func (s *stage) transform(ctx context.Context, e envelope.Envelope) (transformed envelope.Envelope, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("transform panicked: %v", r)
+		}
+	}()
+	return s.Transform(ctx, e)
+}
+
+func (s *stage) appliesTo(e envelope.Envelope) bool {
+	if len(s.protocols) > 0 {
+		matched := false
+		for _, protocol := range s.protocols {
+			matched = matched || protocol == e.Protocol
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(s.schemas) == 0 {
+		return true
+	}
+	for _, prefix := range s.schemas {
+		if strings.HasPrefix(e.Schema, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pipeline runs envelopes through each stage in order
+type Pipeline struct {
+	stages []*stage
+}
+
+// Builder returns a new stage for the transform config
+type Builder func(conf config.Transform) (Stage, error)
+
+var builders = make(map[string]Builder)
+
+// Register adds a custom transform type. Transforms must be
+// registered before the app is initialized.
+func Register(transformType string, b Builder) {
+	builders[transformType] = b
+}
+
+func buildStage(conf config.Transform) (Stage, error) {
+	if b, ok := builders[conf.Type]; ok {
+		return b(conf)
+	}
+	switch conf.Type {
+	case WASM:
+		return NewWasmStage(conf)
+	case CEL:
+		return NewFilterStage(conf)
+	case LUA:
+		return NewLuaStage(conf)
+	case PII:
+		return NewPiiStage(conf)
+	case HASH:
+		return NewHashStage(conf)
+	case IP:
+		return NewIpStage(conf)
+	case GEO:
+		e, err := NewGeoEnricher(conf)
+		if err != nil {
+			return nil, err
+		}
+		return &enrichment{Enricher: e}, nil
+	case UA:
+		e, err := NewUserAgentEnricher(conf)
+		if err != nil {
+			return nil, err
+		}
+		return &enrichment{Enricher: e}, nil
+	case ATTRIBUTION:
+		e, err := NewAttributionEnricher(conf)
+		if err != nil {
+			return nil, err
+		}
+		return &enrichment{Enricher: e}, nil
+	case BOT:
+		return NewBotStage(conf)
+	case DEDUP:
+		return NewDedupStage(conf)
+	case SAMPLE:
+		return NewSampleStage(conf)
+	case CONSENT:
+		return NewConsentStage(conf)
+	case SESSION:
+		e, err := NewSessionEnricher(conf)
+		if err != nil {
+			return nil, err
+		}
+		return &enrichment{Enricher: e}, nil
+	}
+	return nil, errors.New("unsupported transform type: " + conf.Type)
+}
+
+func BuildPipeline(conf []config.Transform) (*Pipeline, error) {
+	p := &Pipeline{}
+	for _, c := range conf {
+		log.Info().Str("name", c.Name).Str("type", c.Type).Msg("🟢 initializing transform")
+		switch c.OnError {
+		case PASS, DROP, INVALID, "":
+		default:
+			p.Close()
+			return nil, errors.New("unsupported transform error policy: " + c.OnError)
+		}
+		s, err := buildStage(c)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		timeout := c.TimeoutMs
+		if timeout <= 0 {
+			timeout = DEFAULT_TIMEOUT_MS
+		}
+		p.stages = append(p.stages, &stage{
+			Stage:     s,
+			name:      c.Name,
+			schemas:   c.Schemas,
+			protocols: c.Protocols,
+			timeout:   time.Duration(timeout) * time.Millisecond,
+			onError:   c.OnError,
+		})
+	}
+	return p, nil
+}
+
+func invalidate(e *envelope.Envelope, name string, err error) {
+	e.IsValid = false
+	e.ValidationError = &envelope.ValidationError{
+		ErrorType:       &validator.TransformationFailed.Type,
+		ErrorResolution: &validator.TransformationFailed.Resolution,
+		Errors: []envelope.PayloadValidationError{{
+			ErrorType:   name,
+			Description: err.Error(),
+		}},
+	}
+}
+
+// indexKey is the context key of the envelope's position in its request
+type indexKey struct{}
+
+// requestIndex returns the position of the envelope among the envelopes
+// of its request
+func requestIndex(ctx context.Context) int {
+	i, _ := ctx.Value(indexKey{}).(int)
+	return i
+}
+
+// claim is an envelope claimed by a stage, as the stage was given it
+type claim struct {
+	stage    *stage
+	releaser Releaser
+	index    int
+	envelope envelope.Envelope
+}
+
+func (s *stage) context(i int) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithValue(context.Background(), indexKey{}, i), s.timeout)
+}
+
+// run runs the envelope through each stage, returning false if it was
+// dropped. The claims of releasing stages are appended to claims.
+func (p *Pipeline) run(i int, e envelope.Envelope, claims *[]claim) (envelope.Envelope, bool) {
+	// Erasure requests are built by buz itself, and must reach every sink
+	// with the subject as it was requested
+	if e.IsErasureRequest() {
+		return e, true
+	}
+	for _, s := range p.stages {
+		if !s.appliesTo(e) {
+			continue
+		}
+		ctx, cancel := s.context(i)
+		transformed, err := s.transform(ctx, e)
+		cancel()
+		switch {
+		case err == nil:
+			if r, ok := s.Stage.(Releaser); ok {
+				*claims = append(*claims, claim{stage: s, releaser: r, index: i, envelope: e})
+			}
+			e = transformed
+		case errors.Is(err, ErrDrop):
+			return e, false
+		default:
+			log.Debug().Err(err).Str("transform", s.name).Str("schema", e.Schema).Msg("🟡 transform failed")
+			switch s.onError {
+			case DROP:
+				return e, false
+			case INVALID:
+				// Later stages are skipped, since the envelope isn't deliverable as-is
+				invalidate(&e, s.name, err)
+				return e, true
+			}
+		}
+	}
+	return e, true
+}
+
+// Run runs the envelopes through the pipeline, omitting dropped envelopes
+func (p *Pipeline) Run(envelopes []envelope.Envelope) []envelope.Envelope {
+	transformed, _ := p.RunReleasable(envelopes)
+	return transformed
+}
+
+// RunReleasable runs the envelopes through the pipeline like Run, and
+// returns a func releasing the claims stages made on them. Manifolds
+// release the claims of envelopes they reject, so the envelopes clients
+// retry aren't dropped as duplicates.
+func (p *Pipeline) RunReleasable(envelopes []envelope.Envelope) ([]envelope.Envelope, func()) {
+	if p == nil || len(p.stages) == 0 {
+		return envelopes, func() {}
+	}
+	var claims []claim
+	transformed := make([]envelope.Envelope, 0, len(envelopes))
+	for i, e := range envelopes {
+		if e, keep := p.run(i, e, &claims); keep {
+			transformed = append(transformed, e)
+		}
+	}
+	return transformed, func() {
+		for _, c := range claims {
+			ctx, cancel := c.stage.context(c.index)
+			if err := c.releaser.Release(ctx, c.envelope); err != nil {
+				log.Error().Err(err).Str("transform", c.stage.name).Str("schema", c.envelope.Schema).Msg("🔴 could not release envelope")
+			}
+			cancel()
+		}
+	}
+}
+
+func (p *Pipeline) Close() error {
+	if p == nil {
+		return nil
+	}
+	var err error
+	for _, s := range p.stages {
+		if closeErr := s.Close(); closeErr != nil {
+			log.Error().Err(closeErr).Str("transform", s.name).Msg("🔴 could not close transform")
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/ua-parser/uap-go/uaparser"
+)
+
+const USER_AGENT_CONTEXT string = "io.silverton/buz/internal/contexts/userAgent/v1.0.json"
+
+const DEFAULT_USER_AGENT_CACHE_SIZE int = 10000
+
+var DEFAULT_USER_AGENT_HEADERS = []string{"User-Agent"}
+var DEFAULT_USER_AGENT_POINTERS = []string{"/useragent"}
+
+// The parser compiles several hundred regexes, so it is shared
+var (
+	uaParser     *uaparser.Parser
+	uaParserOnce sync.Once
+)
+
+type userAgentEntry struct {
+	userAgent string
+	parsed    map[string]interface{}
+}
+
+// UserAgentEnricher attaches a context with the browser, os, and device
+// of the user agent. Parsed user agents are kept in an lru cache, since
+// most traffic comes from a small number of agents.
+type UserAgentEnricher struct {
+	source  *source
+	mu      sync.Mutex
+	size    int
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+func NewUserAgentEnricher(conf config.Transform) (*UserAgentEnricher, error) {
+	src, err := newSource(conf, DEFAULT_USER_AGENT_HEADERS, DEFAULT_USER_AGENT_POINTERS)
+	if err != nil {
+		return nil, err
+	}
+	size := conf.CacheSize
+	if size <= 0 {
+		size = DEFAULT_USER_AGENT_CACHE_SIZE
+	}
+	uaParserOnce.Do(func() {
+		uaParser = uaparser.NewFromSaved()
+	})
+	return &UserAgentEnricher{
+		source:  src,
+		size:    size,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}, nil
+}
+
+func version(parts ...string) string {
+	var nonEmpty []string
+	for _, p := range parts {
+		if p == "" {
+			break
+		}
+		nonEmpty = append(nonEmpty, p)
+	}
+	return strings.Join(nonEmpty, ".")
+}
+
+func parseUserAgent(userAgent string) map[string]interface{} {
+	client := uaParser.Parse(userAgent)
+	return map[string]interface{}{
+		"browserFamily":  client.UserAgent.Family,
+		"browserVersion": version(client.UserAgent.Major, client.UserAgent.Minor, client.UserAgent.Patch),
+		"osFamily":       client.Os.Family,
+		"osVersion":      version(client.Os.Major, client.Os.Minor, client.Os.Patch, client.Os.PatchMinor),
+		"deviceFamily":   client.Device.Family,
+		"deviceBrand":    client.Device.Brand,
+		"deviceModel":    client.Device.Model,
+	}
+}
+
+// parse returns the cached context of the user agent, parsing it on a miss
+func (u *UserAgentEnricher) parse(userAgent string) map[string]interface{} {
+	u.mu.Lock()
+	if el, ok := u.entries[userAgent]; ok {
+		u.ll.MoveToFront(el)
+		u.mu.Unlock()
+		return el.Value.(*userAgentEntry).parsed
+	}
+	u.mu.Unlock()
+	// Parsing is slow, so it happens outside the lock
+	parsed := parseUserAgent(userAgent)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.entries[userAgent]; !ok {
+		u.entries[userAgent] = u.ll.PushFront(&userAgentEntry{userAgent: userAgent, parsed: parsed})
+		if u.ll.Len() > u.size {
+			oldest := u.ll.Back()
+			u.ll.Remove(oldest)
+			delete(u.entries, oldest.Value.(*userAgentEntry).userAgent)
+		}
+	}
+	return parsed
+}
+
+func (u *UserAgentEnricher) Enrich(ctx context.Context, e *envelope.Envelope) error {
+	var userAgent string
+	for _, v := range u.source.values(e) {
+		if s, ok := v.(string); ok && s != "" {
+			userAgent = s
+			break
+		}
+	}
+	if userAgent == "" {
+		return nil
+	}
+	setContext(e, USER_AGENT_CONTEXT, u.parse(userAgent))
+	return nil
+}
+
+func (u *UserAgentEnricher) Close() error {
+	return nil
+}
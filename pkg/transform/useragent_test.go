@@ -0,0 +1,51 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+const CHROME_MAC string = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.5735.198 Safari/537.36"
+
+func TestUserAgentEnrichment(t *testing.T) {
+	u, err := NewUserAgentEnricher(config.Transform{})
+	assert.Nil(t, err)
+	contexts := envelope.Contexts{envelope.HTTP_HEADERS_CONTEXT: map[string]interface{}{"User-Agent": CHROME_MAC}}
+	e := envelope.Envelope{Contexts: &contexts}
+	assert.Nil(t, u.Enrich(context.Background(), &e))
+	parsed := (*e.Contexts)[USER_AGENT_CONTEXT].(map[string]interface{})
+	assert.Equal(t, "Chrome", parsed["browserFamily"])
+	assert.Equal(t, "114.0.5735", parsed["browserVersion"])
+	assert.Equal(t, "Mac OS X", parsed["osFamily"])
+	assert.Equal(t, "10.15.7", parsed["osVersion"])
+	assert.Equal(t, "Mac", parsed["deviceFamily"])
+	assert.NotContains(t, contexts, USER_AGENT_CONTEXT)
+
+	e = envelope.Envelope{Payload: envelope.Payload{"useragent": "curl/8.1.2"}}
+	assert.Nil(t, u.Enrich(context.Background(), &e))
+	assert.Equal(t, "curl", (*e.Contexts)[USER_AGENT_CONTEXT].(map[string]interface{})["browserFamily"])
+
+	e = envelope.Envelope{}
+	assert.Nil(t, u.Enrich(context.Background(), &e))
+	assert.Nil(t, e.Contexts)
+}
+
+func TestUserAgentCache(t *testing.T) {
+	u, _ := NewUserAgentEnricher(config.Transform{CacheSize: 2})
+	first := u.parse("a")
+	u.parse("b")
+	u.parse("a")
+	u.parse("c") // Evicts b, the least recently used
+	assert.Equal(t, 2, u.ll.Len())
+	assert.Contains(t, u.entries, "a")
+	assert.NotContains(t, u.entries, "b")
+	assert.Equal(t, first, u.parse("a"))
+}
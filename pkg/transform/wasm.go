@@ -0,0 +1,139 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// WasmStage runs envelopes through a WebAssembly module. The module must
+// export its `memory` and two functions:
+//
+//	alloc(size i32) i32                   returns a pointer to size bytes of memory
+//	transform(ptr i32, size i32) i64      transforms the json envelope at ptr
+//
+// transform returns the pointer to the transformed json envelope in the
+// upper 32 bits, and its size in the lower 32 bits. A size of zero drops the
+// envelope. A trap fails the transform. The module may also export
+// `dealloc(ptr i32, size i32)`, which is called with each allocation once
+// it is no longer needed. Modules may import wasi.
+type WasmStage struct {
+	runtime   wazero.Runtime
+	compiled  wazero.CompiledModule
+	instances sync.Pool
+}
+
+func NewWasmStage(conf config.Transform) (*WasmStage, error) {
+	contents, err := os.ReadFile(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+	return newWasmStage(contents)
+}
+
+func newWasmStage(contents []byte) (*WasmStage, error) {
+	ctx := context.Background()
+	// Closing on context done enforces the transform timeout
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	wasi_snapshot_preview1.MustInstantiate(ctx, r)
+	compiled, err := r.CompileModule(ctx, contents)
+	if err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	exports := compiled.ExportedFunctions()
+	for _, name := range []string{"alloc", "transform"} {
+		if _, ok := exports[name]; !ok {
+			r.Close(ctx)
+			return nil, errors.New("wasm module does not export " + name)
+		}
+	}
+	return &WasmStage{runtime: r, compiled: compiled}, nil
+}
+
+// instance returns an idle module instance, since instances
+// can't be called concurrently.
+func (s *WasmStage) instance(ctx context.Context) (api.Module, error) {
+	if m, ok := s.instances.Get().(api.Module); ok && !m.IsClosed() {
+		return m, nil
+	}
+	// Anonymous instances, so a module can be instantiated more than once
+	conf := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	return s.runtime.InstantiateModule(ctx, s.compiled, conf)
+}
+
+func (s *WasmStage) Transform(ctx context.Context, e envelope.Envelope) (envelope.Envelope, error) {
+	input, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+	m, err := s.instance(context.Background())
+	if err != nil {
+		return e, err
+	}
+	output, err := call(ctx, m, input)
+	if err != nil {
+		// The instance may be left in an unknown state
+		m.Close(context.Background())
+		return e, err
+	}
+	s.instances.Put(m)
+	if output == nil {
+		return e, ErrDrop
+	}
+	var transformed envelope.Envelope
+	if err := json.Unmarshal(output, &transformed); err != nil {
+		return e, fmt.Errorf("wasm module returned an invalid envelope: %w", err)
+	}
+	return transformed, nil
+}
+
+func call(ctx context.Context, m api.Module, input []byte) ([]byte, error) {
+	results, err := m.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(results[0])
+	if !m.Memory().Write(ptr, input) {
+		return nil, errors.New("wasm module allocated memory out of range")
+	}
+	dealloc := m.ExportedFunction("dealloc")
+	if dealloc != nil {
+		defer dealloc.Call(ctx, uint64(ptr), uint64(len(input)))
+	}
+	results, err = m.ExportedFunction("transform").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	outPtr, outSize := uint32(results[0]>>32), uint32(results[0])
+	if outSize == 0 {
+		return nil, nil
+	}
+	output, ok := m.Memory().Read(outPtr, outSize)
+	if !ok {
+		return nil, errors.New("wasm module returned memory out of range")
+	}
+	// Copy the output, since the memory is reused by later calls
+	output = append([]byte{}, output...)
+	if dealloc != nil && outPtr != ptr {
+		dealloc.Call(ctx, uint64(outPtr), uint64(outSize))
+	}
+	return output, nil
+}
+
+func (s *WasmStage) Close() error {
+	return s.runtime.Close(context.Background())
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func uleb(v uint64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func sleb(v int64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func vec(items ...[]byte) []byte {
+	b := uleb(uint64(len(items)))
+	for _, i := range items {
+		b = append(b, i...)
+	}
+	return b
+}
+
+func section(id byte, contents []byte) []byte {
+	return append(append([]byte{id}, uleb(uint64(len(contents)))...), contents...)
+}
+
+func name(n string) []byte {
+	return append(uleb(uint64(len(n))), n...)
+}
+
+// wasmModule builds a module exporting memory, `alloc`, which always returns
+// offset 1024, and `transform` with the body. The data is written at offset 2048.
+func wasmModule(transform []byte, data string) []byte {
+	m := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	m = append(m, section(1, vec(
+		[]byte{0x60, 0x01, 0x7f, 0x01, 0x7f},       // (i32) -> i32
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e}, // (i32, i32) -> i64
+	))...)
+	m = append(m, section(3, vec([]byte{0x00}, []byte{0x01}))...)
+	m = append(m, section(5, vec([]byte{0x00, 0x01}))...)
+	m = append(m, section(7, vec(
+		append(name("memory"), 0x02, 0x00),
+		append(name("alloc"), 0x00, 0x00),
+		append(name("transform"), 0x00, 0x01),
+	))...)
+	alloc := append(append([]byte{0x00, 0x41}, sleb(1024)...), 0x0b)
+	body := append(append([]byte{0x00}, transform...), 0x0b)
+	m = append(m, section(10, vec(
+		append(uleb(uint64(len(alloc))), alloc...),
+		append(uleb(uint64(len(body))), body...),
+	))...)
+	if data != "" {
+		segment := append(append([]byte{0x00, 0x41}, sleb(2048)...), 0x0b)
+		m = append(m, section(11, vec(append(segment, name(data)...)))...)
+	}
+	return m
+}
+
+var (
+	// Return the input: (ptr << 32) | size
+	identity = []byte{0x20, 0x00, 0xad, 0x42, 0x20, 0x86, 0x20, 0x01, 0xad, 0x84}
+	trap     = []byte{0x00}
+	loop     = []byte{0x03, 0x40, 0x0c, 0x00, 0x0b, 0x42, 0x00}
+	drop     = []byte{0x42, 0x00}
+)
+
+// returnData returns the data written at offset 2048
+func returnData(data string) []byte {
+	return append([]byte{0x42}, sleb(2048<<32|int64(len(data)))...)
+}
+
+func testEnvelope() envelope.Envelope {
+	return envelope.Envelope{Schema: "com.acme/page/v1.0.json", IsValid: true, Payload: envelope.Payload{"path": "/"}}
+}
+
+func testPipeline(t *testing.T, module []byte, transform config.Transform) *Pipeline {
+	path := filepath.Join(t.TempDir(), "transform.wasm")
+	assert.Nil(t, os.WriteFile(path, module, 0644))
+	transform.Type, transform.Path = WASM, path
+	p, err := BuildPipeline([]config.Transform{transform})
+	assert.Nil(t, err)
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestWasmTransform(t *testing.T) {
+	rewritten := `{"schema":"com.acme/page/v2.0.json","isValid":true,"payload":{"path":"/home"}}`
+	p := testPipeline(t, wasmModule(returnData(rewritten), rewritten), config.Transform{Name: "rewrite"})
+	transformed := p.Run([]envelope.Envelope{testEnvelope(), testEnvelope()})
+	assert.Len(t, transformed, 2)
+	assert.Equal(t, "com.acme/page/v2.0.json", transformed[1].Schema)
+	assert.Equal(t, "/home", transformed[1].Payload["path"])
+}
+
+func TestWasmIdentity(t *testing.T) {
+	p := testPipeline(t, wasmModule(identity, ""), config.Transform{Name: "identity"})
+	transformed := p.Run([]envelope.Envelope{testEnvelope()})
+	assert.Equal(t, testEnvelope().Payload, transformed[0].Payload)
+}
+
+func TestWasmDrop(t *testing.T) {
+	p := testPipeline(t, wasmModule(drop, ""), config.Transform{Name: "drop", OnError: INVALID})
+	assert.Empty(t, p.Run([]envelope.Envelope{testEnvelope()}))
+}
+
+func TestWasmSchemaPrefixes(t *testing.T) {
+	p := testPipeline(t, wasmModule(drop, ""), config.Transform{Name: "drop", Schemas: []string{"io.silverton/"}})
+	assert.Len(t, p.Run([]envelope.Envelope{testEnvelope()}), 1)
+}
+
+func TestWasmErrorPolicies(t *testing.T) {
+	var testCases = []struct {
+		onError   string
+		module    []byte
+		wantKept  bool
+		wantValid bool
+	}{
+		{PASS, wasmModule(trap, ""), true, true},
+		{DROP, wasmModule(trap, ""), false, false},
+		{INVALID, wasmModule(trap, ""), true, false},
+		{INVALID, wasmModule(loop, ""), true, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.onError, func(t *testing.T) {
+			p := testPipeline(t, tc.module, config.Transform{Name: "failing", OnError: tc.onError, TimeoutMs: 20})
+			start := time.Now()
+			transformed := p.Run([]envelope.Envelope{testEnvelope()})
+			assert.Less(t, time.Since(start), time.Second)
+			assert.Equal(t, tc.wantKept, len(transformed) == 1)
+			if tc.wantKept {
+				assert.Equal(t, tc.wantValid, transformed[0].IsValid)
+				assert.Equal(t, testEnvelope().Payload, transformed[0].Payload)
+			}
+			if tc.wantKept && !tc.wantValid {
+				assert.Equal(t, "failing", transformed[0].ValidationError.Errors[0].ErrorType)
+			}
+		})
+	}
+}
+
+func TestWasmModuleMustExportTransform(t *testing.T) {
+	_, err := newWasmStage([]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00})
+	assert.NotNil(t, err)
+	_, err = BuildPipeline([]config.Transform{{Type: "python"}})
+	assert.NotNil(t, err)
+	_, err = BuildPipeline([]config.Transform{{Type: WASM, OnError: "retry"}})
+	assert.NotNil(t, err)
+	var p *Pipeline
+	assert.Len(t, p.Run([]envelope.Envelope{testEnvelope()}), 1)
+}
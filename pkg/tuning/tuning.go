@@ -0,0 +1,262 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package tuning
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"go.uber.org/automaxprocs/maxprocs"
+)
+
+// Sources of the runtime settings
+const (
+	CONFIG  string = "config"
+	ENV     string = "env"
+	CGROUP  string = "cgroup"
+	DEFAULT string = "default" // The go runtime's own default
+)
+
+const DEFAULT_MEMORY_LIMIT_RATIO float64 = 0.9
+
+// Cgroup limits above this are unlimited, as cgroup v1 reports
+// unlimited memory as a page-aligned max int64
+const UNLIMITED_BYTES int64 = 1 << 62
+
+// Settings are the effective runtime settings, and the container limits
+// they were derived from
+type Settings struct {
+	GoMaxProcs           int     `json:"goMaxProcs"`
+	GoMaxProcsSource     string  `json:"goMaxProcsSource"`
+	NumCpu               int     `json:"numCpu"`
+	CpuLimit             float64 `json:"cpuLimit,omitempty"` // Cpus the container may use, if it is limited
+	MemoryLimitBytes     int64   `json:"memoryLimitBytes"`   // The go memory limit, which is max int64 if there is none
+	MemoryLimitSource    string  `json:"memoryLimitSource"`
+	ContainerMemoryBytes int64   `json:"containerMemoryBytes,omitempty"` // The container's memory limit, if it is limited
+}
+
+var (
+	mu      sync.Mutex
+	applied Settings
+)
+
+// procRoot is where the cgroups and mounts of the process are read from
+var procRoot = "/proc/self"
+
+// setMaxProcs sets GOMAXPROCS from the cpu quota of the process's cgroup
+var setMaxProcs = func() error {
+	_, err := maxprocs.Set(maxprocs.RoundQuotaFunc(maxProcs), maxprocs.Logger(func(format string, args ...interface{}) {
+		log.Debug().Msgf("🟡 "+format, args...)
+	}))
+	return err
+}
+
+type cgroupMount struct {
+	root  string
+	point string
+}
+
+// cgroupDirs returns the directories of the process's own cgroups by
+// controller, from /proc/self/cgroup and the mounts of the cgroup
+// hierarchies, so they're found with or without a cgroup namespace. The
+// cgroup v2 directory is under "".
+func cgroupDirs() map[string]string {
+	mountinfo, err := os.ReadFile(filepath.Join(procRoot, "mountinfo"))
+	if err != nil {
+		return nil
+	}
+	mounts := make(map[string]cgroupMount)
+	for _, line := range strings.Split(string(mountinfo), "\n") {
+		// The fs type and super options follow the "-" separator
+		fields := strings.Fields(line)
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || sep+3 >= len(fields) {
+			continue
+		}
+		m := cgroupMount{root: fields[3], point: fields[4]}
+		switch fields[sep+1] {
+		case "cgroup2":
+			mounts[""] = m
+		case "cgroup":
+			for _, opt := range strings.Split(fields[sep+3], ",") {
+				mounts[opt] = m
+			}
+		}
+	}
+	cgroups, err := os.ReadFile(filepath.Join(procRoot, "cgroup"))
+	if err != nil {
+		return nil
+	}
+	dirs := make(map[string]string)
+	for _, line := range strings.Split(string(cgroups), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			m, ok := mounts[controller]
+			if !ok {
+				continue
+			}
+			// Cgroups outside of the mount's root can't be read, so the
+			// mount point's limits are used
+			rel, err := filepath.Rel(m.root, parts[2])
+			if err != nil || strings.HasPrefix(rel, "..") {
+				rel = "."
+			}
+			dirs[controller] = filepath.Join(m.point, rel)
+		}
+	}
+	return dirs
+}
+
+func readCgroup(dirs map[string]string, controller string, file string) (string, bool) {
+	dir, ok := dirs[controller]
+	if !ok {
+		return "", false
+	}
+	b, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(b)), true
+}
+
+// cpuLimit returns the cpus the cgroup may use, from cgroup v2 or v1
+func cpuLimit() (float64, bool) {
+	dirs := cgroupDirs()
+	if max, ok := readCgroup(dirs, "", "cpu.max"); ok {
+		fields := strings.Fields(max)
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		quota, err1 := strconv.ParseFloat(fields[0], 64)
+		period, err2 := strconv.ParseFloat(fields[1], 64)
+		if err1 != nil || err2 != nil || quota <= 0 || period <= 0 {
+			return 0, false
+		}
+		return quota / period, true
+	}
+	q, ok1 := readCgroup(dirs, "cpu", "cpu.cfs_quota_us")
+	p, ok2 := readCgroup(dirs, "cpu", "cpu.cfs_period_us")
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	quota, err1 := strconv.ParseFloat(q, 64)
+	period, err2 := strconv.ParseFloat(p, 64)
+	if err1 != nil || err2 != nil || quota <= 0 || period <= 0 {
+		return 0, false
+	}
+	return quota / period, true
+}
+
+// memoryLimit returns the bytes the cgroup may use, from cgroup v2 or v1
+func memoryLimit() (int64, bool) {
+	dirs := cgroupDirs()
+	limit, ok := readCgroup(dirs, "", "memory.max")
+	if !ok {
+		limit, ok = readCgroup(dirs, "memory", "memory.limit_in_bytes")
+	}
+	if !ok || limit == "max" {
+		return 0, false
+	}
+	bytes, err := strconv.ParseInt(limit, 10, 64)
+	if err != nil || bytes <= 0 || bytes >= UNLIMITED_BYTES {
+		return 0, false
+	}
+	return bytes, true
+}
+
+// maxProcs rounds the cpu limit to GOMAXPROCS. Fractional cpus are rounded
+// down, as more procs than cpus are throttled, but there is at least one.
+func maxProcs(cpus float64) int {
+	procs := int(math.Floor(cpus))
+	if procs < 1 {
+		procs = 1
+	}
+	if procs > runtime.NumCPU() {
+		procs = runtime.NumCPU()
+	}
+	return procs
+}
+
+// Tune sets GOMAXPROCS and the go memory limit. Config overrides are used
+// first, then the GOMAXPROCS and GOMEMLIMIT env vars, which the go runtime
+// has already applied, and then the limits of the process's cgroup.
+// GOMAXPROCS is set from the cgroup by automaxprocs.
+func Tune(conf config.Runtime) Settings {
+	s := Settings{NumCpu: runtime.NumCPU(), GoMaxProcsSource: DEFAULT, MemoryLimitSource: DEFAULT}
+	if cpus, ok := cpuLimit(); ok {
+		s.CpuLimit = cpus
+	}
+	if bytes, ok := memoryLimit(); ok {
+		s.ContainerMemoryBytes = bytes
+	}
+	auto := !conf.DisableAutoTune
+
+	switch {
+	case conf.MaxProcs > 0:
+		runtime.GOMAXPROCS(conf.MaxProcs)
+		s.GoMaxProcsSource = CONFIG
+	case os.Getenv("GOMAXPROCS") != "":
+		s.GoMaxProcsSource = ENV
+	case auto:
+		if err := setMaxProcs(); err != nil {
+			log.Warn().Err(err).Msg("🟡 could not set GOMAXPROCS from the cgroup")
+		} else if s.CpuLimit > 0 {
+			s.GoMaxProcsSource = CGROUP
+		}
+	}
+
+	ratio := conf.MemoryLimitRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = DEFAULT_MEMORY_LIMIT_RATIO
+	}
+	switch {
+	case conf.MemoryLimitMb > 0:
+		debug.SetMemoryLimit(int64(conf.MemoryLimitMb) * 1024 * 1024)
+		s.MemoryLimitSource = CONFIG
+	case os.Getenv("GOMEMLIMIT") != "":
+		s.MemoryLimitSource = ENV
+	case auto && s.ContainerMemoryBytes > 0:
+		debug.SetMemoryLimit(int64(float64(s.ContainerMemoryBytes) * ratio))
+		s.MemoryLimitSource = CGROUP
+	}
+
+	s.GoMaxProcs = runtime.GOMAXPROCS(0)
+	s.MemoryLimitBytes = debug.SetMemoryLimit(-1)
+	mu.Lock()
+	applied = s
+	mu.Unlock()
+	log.Info().Int("goMaxProcs", s.GoMaxProcs).Str("goMaxProcsSource", s.GoMaxProcsSource).Int64("memoryLimitBytes", s.MemoryLimitBytes).Str("memoryLimitSource", s.MemoryLimitSource).Msg("🟢 tuned go runtime")
+	return s
+}
+
+// Effective returns the runtime settings, as they are now
+func Effective() Settings {
+	mu.Lock()
+	s := applied
+	mu.Unlock()
+	if s.NumCpu == 0 {
+		s = Settings{NumCpu: runtime.NumCPU(), GoMaxProcsSource: DEFAULT, MemoryLimitSource: DEFAULT}
+	}
+	s.GoMaxProcs = runtime.GOMAXPROCS(0)
+	s.MemoryLimitBytes = debug.SetMemoryLimit(-1)
+	return s
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package tuning
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCgroup is the cgroup of the process in the tests, which aren't in a
+// cgroup namespace
+const testCgroup = "/kubepods/pod/buz"
+
+func writeFile(t *testing.T, path string, contents string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// withCgroup points procRoot at a temp dir with the cgroup v2 hierarchy
+// mounted at its root, and the cpu and memory v1 hierarchies in their
+// directories. Files are written to the process's cgroup in the hierarchy
+// of their directory. The runtime settings are restored once the test is
+// done.
+func withCgroup(t *testing.T, files map[string]string) {
+	root := t.TempDir()
+	proc := filepath.Join(root, "proc")
+	writeFile(t, filepath.Join(proc, "cgroup"), "0::"+testCgroup+"\n4:cpu,cpuacct:"+testCgroup+"\n5:memory:"+testCgroup)
+	writeFile(t, filepath.Join(proc, "mountinfo"), strings.Join([]string{
+		"25 30 0:22 / " + root + " rw,nosuid shared:9 - cgroup2 cgroup2 rw",
+		"26 30 0:23 / " + filepath.Join(root, "cpu") + " rw,nosuid shared:10 - cgroup cgroup rw,cpu,cpuacct",
+		"27 30 0:24 / " + filepath.Join(root, "memory") + " rw,nosuid shared:11 - cgroup cgroup rw,memory",
+	}, "\n"))
+	for name, contents := range files {
+		dir, file := filepath.Split(name)
+		writeFile(t, filepath.Join(root, dir, testCgroup, file), contents)
+	}
+	previousRoot, previousSet := procRoot, setMaxProcs
+	procs := runtime.GOMAXPROCS(0)
+	limit := debug.SetMemoryLimit(-1)
+	procRoot = proc
+	// automaxprocs reads the cgroup of the test process itself
+	setMaxProcs = func() error {
+		if cpus, ok := cpuLimit(); ok {
+			runtime.GOMAXPROCS(maxProcs(cpus))
+		}
+		return nil
+	}
+	t.Setenv("GOMAXPROCS", "")
+	t.Setenv("GOMEMLIMIT", "")
+	t.Cleanup(func() {
+		procRoot, setMaxProcs = previousRoot, previousSet
+		runtime.GOMAXPROCS(procs)
+		debug.SetMemoryLimit(limit)
+	})
+}
+
+func TestCgroupDirs(t *testing.T) {
+	withCgroup(t, nil)
+	root := filepath.Dir(procRoot)
+	assert.Equal(t, map[string]string{
+		"":        filepath.Join(root, testCgroup),
+		"cpu":     filepath.Join(root, "cpu", testCgroup),
+		"cpuacct": filepath.Join(root, "cpu", testCgroup),
+		"memory":  filepath.Join(root, "memory", testCgroup),
+	}, cgroupDirs())
+
+	// In a cgroup namespace, or with the cgroup mounted at its own root
+	writeFile(t, filepath.Join(procRoot, "cgroup"), "0::/")
+	assert.Equal(t, root, cgroupDirs()[""])
+	writeFile(t, filepath.Join(procRoot, "cgroup"), "0::"+testCgroup)
+	writeFile(t, filepath.Join(procRoot, "mountinfo"), "25 30 0:22 "+testCgroup+" "+root+" rw - cgroup2 cgroup2 rw")
+	assert.Equal(t, root, cgroupDirs()[""])
+}
+
+func TestCpuLimit(t *testing.T) {
+	testCases := []struct {
+		name  string
+		files map[string]string
+		cpus  float64
+		ok    bool
+	}{
+		{"v2", map[string]string{"cpu.max": "150000 100000"}, 1.5, true},
+		{"v2 unlimited", map[string]string{"cpu.max": "max 100000"}, 0, false},
+		{"v1", map[string]string{"cpu/cpu.cfs_quota_us": "200000", "cpu/cpu.cfs_period_us": "100000"}, 2, true},
+		{"v1 unlimited", map[string]string{"cpu/cpu.cfs_quota_us": "-1", "cpu/cpu.cfs_period_us": "100000"}, 0, false},
+		{"none", map[string]string{}, 0, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			withCgroup(t, tc.files)
+			cpus, ok := cpuLimit()
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.cpus, cpus)
+		})
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	testCases := []struct {
+		name  string
+		files map[string]string
+		bytes int64
+		ok    bool
+	}{
+		{"v2", map[string]string{"memory.max": "1073741824"}, 1073741824, true},
+		{"v2 unlimited", map[string]string{"memory.max": "max"}, 0, false},
+		{"v1", map[string]string{"memory/memory.limit_in_bytes": "536870912"}, 536870912, true},
+		{"v1 unlimited", map[string]string{"memory/memory.limit_in_bytes": "9223372036854771712"}, 0, false},
+		{"none", map[string]string{}, 0, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			withCgroup(t, tc.files)
+			bytes, ok := memoryLimit()
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.bytes, bytes)
+		})
+	}
+}
+
+func TestMaxProcs(t *testing.T) {
+	assert.Equal(t, 1, maxProcs(0.5))
+	assert.Equal(t, 1, maxProcs(1.9))
+	assert.Equal(t, runtime.NumCPU(), maxProcs(float64(runtime.NumCPU()+8)))
+}
+
+func TestTune(t *testing.T) {
+	t.Run("cgroup", func(t *testing.T) {
+		withCgroup(t, map[string]string{"cpu.max": "100000 100000", "memory.max": "1000000000"})
+		s := Tune(config.Runtime{})
+		assert.Equal(t, 1, s.GoMaxProcs)
+		assert.Equal(t, CGROUP, s.GoMaxProcsSource)
+		assert.Equal(t, int64(900000000), s.MemoryLimitBytes)
+		assert.Equal(t, CGROUP, s.MemoryLimitSource)
+		assert.Equal(t, int64(1000000000), s.ContainerMemoryBytes)
+		assert.Equal(t, s, Effective())
+	})
+
+	t.Run("config overrides", func(t *testing.T) {
+		withCgroup(t, map[string]string{"cpu.max": "100000 100000", "memory.max": "1000000000"})
+		s := Tune(config.Runtime{MaxProcs: 2, MemoryLimitMb: 256})
+		assert.Equal(t, 2, s.GoMaxProcs)
+		assert.Equal(t, CONFIG, s.GoMaxProcsSource)
+		assert.Equal(t, int64(256*1024*1024), s.MemoryLimitBytes)
+		assert.Equal(t, CONFIG, s.MemoryLimitSource)
+	})
+
+	t.Run("env", func(t *testing.T) {
+		withCgroup(t, map[string]string{"cpu.max": "100000 100000", "memory.max": "1000000000"})
+		t.Setenv("GOMAXPROCS", "3")
+		t.Setenv("GOMEMLIMIT", "512MiB")
+		s := Tune(config.Runtime{})
+		assert.Equal(t, ENV, s.GoMaxProcsSource)
+		assert.Equal(t, ENV, s.MemoryLimitSource)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		withCgroup(t, map[string]string{"cpu.max": "100000 100000", "memory.max": "1000000000"})
+		debug.SetMemoryLimit(math.MaxInt64)
+		s := Tune(config.Runtime{DisableAutoTune: true})
+		assert.Equal(t, DEFAULT, s.GoMaxProcsSource)
+		assert.Equal(t, DEFAULT, s.MemoryLimitSource)
+		assert.Equal(t, int64(math.MaxInt64), s.MemoryLimitBytes)
+		assert.Equal(t, float64(1), s.CpuLimit)
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package validator
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// resolvePointer returns the value at the json pointer within the decoded document
+func resolvePointer(doc interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return doc, true
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			val, ok := v[token]
+			if !ok {
+				return nil, false
+			}
+			doc = val
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+func jsonType(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := n.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// expectedValue returns the value of the failing schema keyword. Keyword
+// locations which pass through references can't be resolved against the
+// root schema, so are skipped.
+func expectedValue(schema interface{}, keywordLocation string) interface{} {
+	if strings.Contains(keywordLocation, "$ref") || strings.Contains(keywordLocation, "$dynamicRef") {
+		return nil
+	}
+	v, _ := resolvePointer(schema, keywordLocation)
+	return v
+}
+
+// actualValue summarizes the offending payload value in terms of the keyword -
+// its type, its size, or the value itself if it is a scalar.
+func actualValue(keyword string, instance interface{}) interface{} {
+	switch keyword {
+	case "type":
+		return jsonType(instance)
+	case "minLength", "maxLength":
+		if s, ok := instance.(string); ok {
+			return len([]rune(s))
+		}
+	case "minItems", "maxItems":
+		if a, ok := instance.([]interface{}); ok {
+			return len(a)
+		}
+	case "minProperties", "maxProperties":
+		if o, ok := instance.(map[string]interface{}); ok {
+			return len(o)
+		}
+	}
+	switch instance.(type) {
+	case map[string]interface{}, []interface{}:
+		return nil
+	}
+	return instance
+}
@@ -38,3 +38,13 @@ var NoSchemaInBackend = InvalidMessage{
 	Type:       "schema not published to cache backend",
 	Resolution: "publish schema to the cache backend",
 }
+
+var TransformationFailed = InvalidMessage{
+	Type:       "transformation failed",
+	Resolution: "fix the transformation or the event it failed on",
+}
+
+var EventTooLarge = InvalidMessage{
+	Type:       "event too large",
+	Resolution: "send smaller events, or raise the manifold's event size limit",
+}
@@ -5,27 +5,58 @@
 package validator
 
 import (
-	"context"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"path"
 	"time"
 
-	"github.com/qri-io/jsonschema"
 	"github.com/rs/zerolog/log"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"github.com/silverton-io/buz/pkg/envelope"
 )
 
-func validatePayload(payload []byte, schema []byte) (isValid bool, validationError envelope.ValidationError) {
-	ctx := context.Background()
-	startTime := time.Now().UTC()
-	s := &jsonschema.Schema{}
-	unmarshalErr := json.Unmarshal(schema, s)
-	if unmarshalErr != nil {
-		log.Error().Stack().Err(unmarshalErr).Msg("🔴 failed to unmarshal schema")
+// leafErrors flattens a validation error into its root causes
+func leafErrors(ve *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(ve.Causes) == 0 {
+		return []*jsonschema.ValidationError{ve}
+	}
+	var leaves []*jsonschema.ValidationError
+	for _, cause := range ve.Causes {
+		leaves = append(leaves, leafErrors(cause)...)
 	}
-	validationErrs, vErr := s.ValidateBytes(ctx, payload)
+	return leaves
+}
 
-	if unmarshalErr != nil || vErr != nil {
+// toPayloadValidationErrors describes each failure by the offending payload
+// location, the failing schema keyword, and the expected vs actual values.
+func toPayloadValidationErrors(ve *jsonschema.ValidationError, schema interface{}, payload interface{}) []envelope.PayloadValidationError {
+	var payloadValidationErrors []envelope.PayloadValidationError
+	for _, leaf := range leafErrors(ve) {
+		keyword := path.Base(leaf.KeywordLocation)
+		payloadValidationError := envelope.PayloadValidationError{
+			Field:          leaf.InstanceLocation,
+			Description:    leaf.Message,
+			ErrorType:      keyword,
+			SchemaLocation: leaf.KeywordLocation,
+			Expected:       expectedValue(schema, leaf.KeywordLocation),
+		}
+		if instance, ok := resolvePointer(payload, leaf.InstanceLocation); ok {
+			payloadValidationError.Actual = actualValue(keyword, instance)
+		}
+		payloadValidationErrors = append(payloadValidationErrors, payloadValidationError)
+	}
+	return payloadValidationErrors
+}
+
+func validatePayload(payload []byte, schema []byte, compile func(schema []byte) (*jsonschema.Schema, error)) (isValid bool, validationError envelope.ValidationError) {
+	startTime := time.Now().UTC()
+	defer func() {
 		log.Debug().Msg("🟡 event validated in " + time.Now().UTC().Sub(startTime).String())
+	}()
+	s, err := compile(schema)
+	if err != nil {
+		log.Error().Stack().Err(err).Msg("🔴 failed to compile schema")
 		validationError := envelope.ValidationError{
 			ErrorType:       &InvalidSchema.Type,
 			ErrorResolution: &InvalidSchema.Resolution,
@@ -33,25 +64,39 @@ func validatePayload(payload []byte, schema []byte) (isValid bool, validationErr
 		}
 		return false, validationError
 	}
-	if len(validationErrs) == 0 {
-		log.Debug().Msg("🟡 event validated in " + time.Now().UTC().Sub(startTime).String())
-		return true, envelope.ValidationError{}
-	} else {
-		var payloadValidationErrors []envelope.PayloadValidationError
-		for _, validationErr := range validationErrs {
-			payloadValidationError := envelope.PayloadValidationError{
-				Field:       validationErr.PropertyPath,
-				Description: validationErr.Message,
-				ErrorType:   validationErr.Error(),
-			}
-			payloadValidationErrors = append(payloadValidationErrors, payloadValidationError)
+	var p interface{}
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	if err := d.Decode(&p); err != nil {
+		validationError := envelope.ValidationError{
+			ErrorType:       &InvalidPayload.Type,
+			ErrorResolution: &InvalidPayload.Resolution,
+			Errors:          nil,
 		}
+		return false, validationError
+	}
+	err = s.Validate(p)
+	if err == nil {
+		return true, envelope.ValidationError{}
+	}
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		log.Error().Err(err).Msg("🔴 could not validate payload")
 		validationError := envelope.ValidationError{
 			ErrorType:       &InvalidPayload.Type,
 			ErrorResolution: &InvalidPayload.Resolution,
-			Errors:          payloadValidationErrors,
+			Errors:          nil,
 		}
-		log.Debug().Msg("🟡 event validated in " + time.Now().UTC().Sub(startTime).String())
 		return false, validationError
 	}
+	var schemaDoc interface{}
+	sd := json.NewDecoder(bytes.NewReader(schema))
+	sd.UseNumber()
+	_ = sd.Decode(&schemaDoc) // The schema compiled, so is known to be valid json
+	validationError = envelope.ValidationError{
+		ErrorType:       &InvalidPayload.Type,
+		ErrorResolution: &InvalidPayload.Resolution,
+		Errors:          toPayloadValidationErrors(ve, schemaDoc, p),
+	}
+	return false, validationError
 }
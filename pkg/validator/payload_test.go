@@ -5,13 +5,13 @@
 package validator
 
 import (
-	"context"
 	"encoding/json"
 	"reflect"
 	"testing"
 
-	"github.com/qri-io/jsonschema"
+	"github.com/silverton-io/buz/pkg/compiler"
 	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
 )
 
 type output struct {
@@ -19,24 +19,6 @@ type output struct {
 	validationError envelope.ValidationError
 }
 
-func generatePayloadValidationErrs(payload []byte, schema []byte) []envelope.PayloadValidationError {
-	ctx := context.Background()
-	s := &jsonschema.Schema{}
-	json.Unmarshal(schema, s)
-	validationErrs, _ := s.ValidateBytes(ctx, payload)
-
-	var payloadValidationErrors []envelope.PayloadValidationError
-	for _, validationErr := range validationErrs {
-		payloadValidationError := envelope.PayloadValidationError{
-			Field:       validationErr.PropertyPath,
-			Description: validationErr.Message,
-			ErrorType:   validationErr.Error(),
-		}
-		payloadValidationErrors = append(payloadValidationErrors, payloadValidationError)
-	}
-	return payloadValidationErrors
-}
-
 func TestValidatePayload(t *testing.T) {
 
 	validPayload := []byte(`{"id": 10, "action": "did"}`)
@@ -61,7 +43,9 @@ func TestValidatePayload(t *testing.T) {
 	`)
 	invalidSchema := []byte(`{"something": yup`)
 
-	invalidPayloadValidationErrs := generatePayloadValidationErrs(invalidPayload, validSchema)
+	invalidPayloadValidationErrs := []envelope.PayloadValidationError{
+		{Field: "", Description: "additionalProperties 'somethingBad' not allowed", ErrorType: "additionalProperties", SchemaLocation: "/additionalProperties", Expected: false},
+	}
 
 	var testCases = []struct {
 		name    string
@@ -77,7 +61,7 @@ func TestValidatePayload(t *testing.T) {
 	for _, tc := range testCases {
 
 		t.Run(tc.name, func(t *testing.T) {
-			isValid, vErr := validatePayload(tc.payload, tc.schema)
+			isValid, vErr := validatePayload(tc.payload, tc.schema, compiler.Compile)
 			if isValid != tc.want.isValid {
 				t.Fatalf(`got %v, want %v`, isValid, tc.want.isValid)
 			}
@@ -88,3 +72,94 @@ func TestValidatePayload(t *testing.T) {
 		})
 	}
 }
+
+func TestValidatePayloadDrafts(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		schema  []byte
+		payload []byte
+		want    bool
+	}{
+		{
+			"2020-12 unevaluatedProperties and $defs",
+			[]byte(`{
+				"$schema": "https://json-schema.org/draft/2020-12/schema",
+				"$defs": {"id": {"type": "integer"}},
+				"properties": {"id": {"$ref": "#/$defs/id"}},
+				"allOf": [{"properties": {"name": {"type": "string"}}}],
+				"unevaluatedProperties": false
+			}`),
+			[]byte(`{"id": 1, "name": "buz", "extra": true}`),
+			false,
+		},
+		{
+			"2020-12 dynamic refs",
+			[]byte(`{
+				"$schema": "https://json-schema.org/draft/2020-12/schema",
+				"$id": "https://buz.dev/tree",
+				"$dynamicAnchor": "node",
+				"type": "object",
+				"properties": {"children": {"type": "array", "items": {"$dynamicRef": "#node"}}}
+			}`),
+			[]byte(`{"children": [{"children": []}]}`),
+			true,
+		},
+		{
+			"draft-07 tuple items",
+			[]byte(`{"$schema": "http://json-schema.org/draft-07/schema#", "items": [{"type": "string"}], "additionalItems": false}`),
+			[]byte(`["a", "b"]`),
+			false,
+		},
+		{
+			"custom metaschema defaults to 2020-12",
+			[]byte(`{"$schema": "https://registry.buz.dev/s/io.silverton/buz/internal/meta/v1.0.json", "prefixItems": [{"type": "string"}], "items": false}`),
+			[]byte(`["a", "b"]`),
+			false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			isValid, _ := validatePayload(tc.payload, tc.schema, compiler.Compile)
+			assert.Equal(t, tc.want, isValid)
+		})
+	}
+}
+
+func TestValidatePayloadErrorDetail(t *testing.T) {
+	schema := []byte(`{
+		"$defs": {"code": {"type": "string", "maxLength": 3}},
+		"type": "object",
+		"properties": {
+			"id": {"type": "integer"},
+			"tags": {"type": "array", "maxItems": 1},
+			"code": {"$ref": "#/$defs/code"},
+			"a/b": {"enum": ["x", "y"]}
+		},
+		"required": ["id"]
+	}`)
+	payload := []byte(`{"id": "ten", "tags": ["a", "b"], "code": "abcd", "a/b": "z"}`)
+	isValid, vErr := validatePayload(payload, schema, compiler.Compile)
+	assert.False(t, isValid)
+	errs := make(map[string]envelope.PayloadValidationError)
+	for _, e := range vErr.Errors {
+		errs[e.Field] = e
+	}
+	assert.Equal(t, envelope.PayloadValidationError{
+		Field:          "/id",
+		Description:    "expected integer, but got string",
+		ErrorType:      "type",
+		SchemaLocation: "/properties/id/type",
+		Expected:       "integer",
+		Actual:         "string",
+	}, errs["/id"])
+	assert.Equal(t, "/properties/tags/maxItems", errs["/tags"].SchemaLocation)
+	assert.Equal(t, json.Number("1"), errs["/tags"].Expected)
+	assert.Equal(t, 2, errs["/tags"].Actual)
+	// Keywords reached through references have no resolvable expected value
+	assert.Equal(t, "maxLength", errs["/code"].ErrorType)
+	assert.Nil(t, errs["/code"].Expected)
+	assert.Equal(t, 4, errs["/code"].Actual)
+	assert.Equal(t, "enum", errs["/a~1b"].ErrorType)
+	assert.Equal(t, []interface{}{"x", "y"}, errs["/a~1b"].Expected)
+	assert.Equal(t, "z", errs["/a~1b"].Actual)
+}
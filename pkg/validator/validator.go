@@ -65,7 +65,7 @@ func Validate(e envelope.Envelope, registry *registry.Registry) (isValid bool, v
 			}
 			return false, validationError, nil
 		}
-		isValid, validationError := validatePayload(payloadToValidate, schemaContents)
+		isValid, validationError := validatePayload(payloadToValidate, schemaContents, registry.Compile)
 		return isValid, validationError, schemaContents
 	}
 }
@@ -0,0 +1,329 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// Fsync policies
+const (
+	FSYNC_ALWAYS   string = "always"   // Every append is synced before it returns
+	FSYNC_INTERVAL string = "interval" // Appends are synced in the background
+	FSYNC_NEVER    string = "never"    // Syncing is left to the operating system
+)
+
+const (
+	DEFAULT_SEGMENT_BYTES     int64  = 64 * 1024 * 1024
+	DEFAULT_FSYNC_INTERVAL_MS int    = 1000
+	SEGMENT_SUFFIX            string = ".wal"
+	// Records are bounded so a corrupt length can't exhaust memory on replay
+	MAX_RECORD_BYTES uint32 = 256 * 1024 * 1024
+)
+
+var ErrClosed = errors.New("write-ahead log is closed")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Position identifies an appended record, so it can be acked once delivered
+type Position struct {
+	Segment uint64
+}
+
+type segment struct {
+	seq     uint64
+	pending int
+}
+
+// Log is a write-ahead log of envelope batches. Each record is written to the
+// active segment as a length, a checksum, and the json-encoded batch. Once
+// the active segment exceeds the segment size a new segment is started, and
+// inactive segments are removed when all of their records have been acked.
+type Log struct {
+	dir          string
+	fsync        string
+	segmentBytes int64
+	mu           sync.Mutex
+	active       *os.File
+	activeBytes  int64
+	segments     map[uint64]*segment
+	seq          uint64
+	recovered    []uint64
+	dirty        bool
+	closed       bool
+	stop         chan struct{}
+	stopped      chan struct{}
+}
+
+func segmentPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", seq, SEGMENT_SUFFIX))
+}
+
+// Open opens the log in the configured directory. Segments left by a
+// previous process are kept for Replay, and appends go to a new segment.
+func Open(conf config.Wal) (*Log, error) {
+	if err := os.MkdirAll(conf.Path, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{
+		dir:          conf.Path,
+		fsync:        conf.Fsync,
+		segmentBytes: conf.SegmentBytes,
+		segments:     make(map[uint64]*segment),
+		stop:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+	if l.fsync == "" {
+		l.fsync = FSYNC_ALWAYS
+	}
+	if l.segmentBytes <= 0 {
+		l.segmentBytes = DEFAULT_SEGMENT_BYTES
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), SEGMENT_SUFFIX) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), SEGMENT_SUFFIX), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.recovered = append(l.recovered, seq)
+		if seq > l.seq {
+			l.seq = seq
+		}
+	}
+	sort.Slice(l.recovered, func(i, j int) bool { return l.recovered[i] < l.recovered[j] })
+	if err := l.rotate(); err != nil {
+		return nil, err
+	}
+	interval := conf.FsyncIntervalMs
+	if interval <= 0 {
+		interval = DEFAULT_FSYNC_INTERVAL_MS
+	}
+	if l.fsync == FSYNC_INTERVAL {
+		go l.syncEvery(time.Duration(interval) * time.Millisecond)
+	} else {
+		close(l.stopped)
+	}
+	log.Info().Int("recoveredSegments", len(l.recovered)).Msg("🟢 opened write-ahead log at " + conf.Path)
+	return l, nil
+}
+
+// rotate starts a new active segment, removing the previous segment if
+// all of its records have been acked.
+func (l *Log) rotate() error {
+	f, err := os.OpenFile(segmentPath(l.dir, l.seq+1), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	previous := l.active
+	l.active, l.activeBytes, l.dirty = f, 0, false
+	l.seq++
+	l.segments[l.seq] = &segment{seq: l.seq}
+	if previous != nil {
+		if err := previous.Sync(); err != nil {
+			return err
+		}
+		if err := previous.Close(); err != nil {
+			return err
+		}
+		l.remove(l.seq - 1)
+	}
+	return nil
+}
+
+// remove removes an inactive segment without pending records
+func (l *Log) remove(seq uint64) {
+	s, ok := l.segments[seq]
+	if !ok || s.pending > 0 || seq == l.seq {
+		return
+	}
+	delete(l.segments, seq)
+	if err := os.Remove(segmentPath(l.dir, seq)); err != nil {
+		log.Error().Err(err).Msgf("🔴 could not remove write-ahead log segment %d", seq)
+	}
+}
+
+func (l *Log) syncEvery(interval time.Duration) {
+	defer close(l.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			if l.dirty && !l.closed {
+				if err := l.active.Sync(); err != nil {
+					log.Error().Err(err).Msg("🔴 could not sync write-ahead log")
+				}
+				l.dirty = false
+			}
+			l.mu.Unlock()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Append durably records the envelopes, according to the fsync policy
+func (l *Log) Append(envelopes []envelope.Envelope) (Position, error) {
+	payload, err := json.Marshal(envelopes)
+	if err != nil {
+		return Position{}, err
+	}
+	record := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[8:], payload)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return Position{}, ErrClosed
+	}
+	if l.activeBytes > 0 && l.activeBytes+int64(len(record)) > l.segmentBytes {
+		if err := l.rotate(); err != nil {
+			return Position{}, err
+		}
+	}
+	if _, err := l.active.Write(record); err != nil {
+		return Position{}, err
+	}
+	l.activeBytes += int64(len(record))
+	if l.fsync == FSYNC_ALWAYS {
+		if err := l.active.Sync(); err != nil {
+			return Position{}, err
+		}
+	} else {
+		l.dirty = true
+	}
+	l.segments[l.seq].pending++
+	return Position{Segment: l.seq}, nil
+}
+
+// Ack marks an appended record as delivered
+func (l *Log) Ack(p Position) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, ok := l.segments[p.Segment]; ok && s.pending > 0 {
+		s.pending--
+		l.remove(p.Segment)
+	}
+}
+
+func readSegment(path string, fn func([]envelope.Envelope) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			log.Warn().Err(err).Msg("🟡 ignoring torn record at the end of write-ahead log segment " + path)
+			return nil
+		}
+		size, checksum := binary.BigEndian.Uint32(header[0:4]), binary.BigEndian.Uint32(header[4:8])
+		if size > MAX_RECORD_BYTES {
+			log.Warn().Msg("🟡 ignoring corrupt record in write-ahead log segment " + path)
+			return nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			log.Warn().Err(err).Msg("🟡 ignoring torn record at the end of write-ahead log segment " + path)
+			return nil
+		}
+		if crc32.Checksum(payload, crcTable) != checksum {
+			log.Warn().Msg("🟡 ignoring corrupt record in write-ahead log segment " + path)
+			return nil
+		}
+		var envelopes []envelope.Envelope
+		if err := json.Unmarshal(payload, &envelopes); err != nil {
+			return err
+		}
+		if err := fn(envelopes); err != nil {
+			return err
+		}
+	}
+}
+
+// Replay passes the records of segments left by a previous process to fn,
+// oldest first. Each segment is removed once all of its records have been
+// passed to fn, so fn should durably accept records (for example by
+// appending them to this log) before returning.
+func (l *Log) Replay(fn func([]envelope.Envelope) error) (replayed int, err error) {
+	l.mu.Lock()
+	recovered := l.recovered
+	l.recovered = nil
+	l.mu.Unlock()
+	for i, seq := range recovered {
+		path := segmentPath(l.dir, seq)
+		err := readSegment(path, func(envelopes []envelope.Envelope) error {
+			replayed += len(envelopes)
+			return fn(envelopes)
+		})
+		if err != nil {
+			l.mu.Lock()
+			l.recovered = append(recovered[i:], l.recovered...)
+			l.mu.Unlock()
+			return replayed, err
+		}
+		if err := os.Remove(path); err != nil {
+			return replayed, err
+		}
+	}
+	return replayed, nil
+}
+
+// Close syncs and closes the active segment. Segments with unacked records
+// are kept for replay.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.stop)
+	l.mu.Unlock()
+	<-l.stopped
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		return err
+	}
+	if s := l.segments[l.seq]; s.pending == 0 {
+		delete(l.segments, l.seq)
+		return os.Remove(segmentPath(l.dir, l.seq))
+	}
+	return nil
+}
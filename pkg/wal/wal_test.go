@@ -0,0 +1,94 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func batch(schemas ...string) []envelope.Envelope {
+	var envelopes []envelope.Envelope
+	for _, s := range schemas {
+		envelopes = append(envelopes, envelope.Envelope{Schema: s, Payload: envelope.Payload{"k": "v"}})
+	}
+	return envelopes
+}
+
+func segments(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+SEGMENT_SUFFIX))
+	assert.Nil(t, err)
+	return names
+}
+
+func replayAll(t *testing.T, l *Log) []string {
+	var schemas []string
+	_, err := l.Replay(func(envelopes []envelope.Envelope) error {
+		for _, e := range envelopes {
+			schemas = append(schemas, e.Schema)
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	return schemas
+}
+
+func TestReplayUnackedRecords(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(config.Wal{Path: dir})
+	assert.Nil(t, err)
+	acked, err := l.Append(batch("a", "b"))
+	assert.Nil(t, err)
+	_, err = l.Append(batch("c"))
+	assert.Nil(t, err)
+	l.Ack(acked)
+	// Simulate a crash by reopening without closing
+	recovered, err := Open(config.Wal{Path: dir})
+	assert.Nil(t, err)
+	// Acked records in a segment with unacked records are replayed too
+	assert.Equal(t, []string{"a", "b", "c"}, replayAll(t, recovered))
+	assert.Nil(t, replayAll(t, recovered))
+	assert.Nil(t, recovered.Close())
+	assert.Empty(t, segments(t, dir))
+}
+
+func TestAckedSegmentsAreRemoved(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(config.Wal{Path: dir, SegmentBytes: 1})
+	assert.Nil(t, err)
+	first, _ := l.Append(batch("a"))
+	second, _ := l.Append(batch("b"))
+	assert.NotEqual(t, first.Segment, second.Segment)
+	assert.Len(t, segments(t, dir), 2)
+	l.Ack(first)
+	assert.Len(t, segments(t, dir), 1)
+	// Unacked records in the active segment are kept on close
+	assert.Nil(t, l.Close())
+	assert.Len(t, segments(t, dir), 1)
+	_, err = l.Append(batch("c"))
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestTornRecordIsIgnored(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(config.Wal{Path: dir, Fsync: FSYNC_NEVER})
+	assert.Nil(t, err)
+	_, err = l.Append(batch("a"))
+	assert.Nil(t, err)
+	_, err = l.Append(batch("b"))
+	assert.Nil(t, err)
+	assert.Nil(t, l.Close())
+	path := segments(t, dir)[0]
+	info, _ := os.Stat(path)
+	assert.Nil(t, os.Truncate(path, info.Size()-3))
+	recovered, err := Open(config.Wal{Path: dir})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a"}, replayAll(t, recovered))
+}
@@ -0,0 +1,13 @@
+// Copyright (c) 2023 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package schemas
+
+import "embed"
+
+// Schemas are compiled into the binary so they are always available,
+// regardless of the configured registry backend(s).
+//
+//go:embed *
+var Embedded embed.FS